@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"github.com/falcosecurity/driverkit/pkg/autodetect"
+	logger "github.com/sirupsen/logrus"
+)
+
+// autodetectRoot is the root directory of the local machine files read to detect it.
+var autodetectRoot = "/"
+
+// autodetectedFlags are the flags of the options detected of the local machine.
+var autodetectedFlags = []string{"target", "kernelrelease", "kernelversion", "kernelconfigdata"}
+
+// detectLocalMachine fills the target and kernel options with the ones of the local machine,
+// but the ones given by the flags or the config file.
+func detectLocalMachine(given map[string]bool, rootOpts *RootOptions) error {
+	res, err := autodetect.Detect(autodetectRoot)
+	if err != nil {
+		return err
+	}
+	fields := logger.Fields{}
+	detect := func(name string, v *string, detected string) {
+		if given[name] || len(detected) == 0 {
+			return
+		}
+		*v = detected
+		fields[name] = detected
+	}
+	detect("target", &rootOpts.Target, res.Target.String())
+	detect("kernelrelease", &rootOpts.KernelRelease, res.KernelRelease)
+	detect("kernelversion", &rootOpts.KernelVersion, res.KernelVersion)
+	detect("kernelconfigdata", &rootOpts.KernelConfigData, res.KernelConfigData)
+	if _, ok := fields["kernelconfigdata"]; ok {
+		// too long to be logged
+		fields["kernelconfigdata"] = "<detected>"
+	}
+	logger.WithFields(fields).Info("detected the local machine")
+	return nil
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectLocalMachine(t *testing.T) {
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		"etc/os-release":            "ID=ubuntu\n",
+		"proc/sys/kernel/osrelease": "5.15.0-25-generic\n",
+		"proc/sys/kernel/version":   "#25-Ubuntu SMP\n",
+	} {
+		name = filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := autodetectRoot
+	autodetectRoot = root
+	defer func() { autodetectRoot = old }()
+
+	rootOpts := NewRootOptions()
+	rootOpts.KernelRelease = "5.15.0-30-generic"
+	if err := detectLocalMachine(map[string]bool{"kernelrelease": true}, rootOpts); err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if rootOpts.Target != "ubuntu" || rootOpts.KernelVersion != "25" || rootOpts.KernelRelease != "5.15.0-30-generic" {
+		t.Errorf("Got: [ %s, %s, %s ] / Want: [ the detected target and kernel version, the given kernel release ]", rootOpts.Target, rootOpts.KernelVersion, rootOpts.KernelRelease)
+	}
+}
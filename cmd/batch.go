@@ -0,0 +1,253 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/signals"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// batchEntry is a build of the batch file, or of a document of the config file, with the same keys of the config file.
+// The fields not given are taken from the flags or the config file, but the outputs.
+type batchEntry struct {
+	Target           string   `yaml:"target"`
+	KernelRelease    string   `yaml:"kernelrelease"`
+	KernelVersion    string   `yaml:"kernelversion"`
+	KernelConfigData string   `yaml:"kernelconfigdata"`
+	KernelUrls       []string `yaml:"kernelurls"`
+	Architecture     string   `yaml:"architecture"`
+	PackageArch      string   `yaml:"package-architecture"`
+	DriverVersion    string   `yaml:"driverversion"`
+	BuilderImage     string   `yaml:"builderimage"`
+	ImageRepo        string   `yaml:"image-repo"`
+	BuilderTemplate  string   `yaml:"builder-template"`
+	LLVMVersion      string   `yaml:"llvmversion"`
+	GCCVersion       string   `yaml:"gccversion"`
+	PushOCI          string   `yaml:"push-oci"`
+	BuildLog         string   `yaml:"build-log"`
+	Env              []string `yaml:"env"`
+	MakeFlags        string   `yaml:"make-flags"`
+	CcacheDir        string   `yaml:"ccache-dir"`
+	Output           struct {
+		Module      string `yaml:"module"`
+		Probe       string `yaml:"probe"`
+		ModernProbe string `yaml:"modern-probe"`
+		BTF         string `yaml:"btf"`
+		DKMS        string `yaml:"dkms"`
+		ModuleS3    string `yaml:"module-s3"`
+		ProbeS3     string `yaml:"probe-s3"`
+	} `yaml:"output"`
+}
+
+// addBatchFlags adds the flags running the builds of a batch file.
+func addBatchFlags(flags *pflag.FlagSet) {
+	flags.String("batch-file", "", "YAML file listing the builds to run, each one with the keys of the config file, the missing ones are taken from the options")
+	flags.Int("jobs", 1, "number of builds of the batch file running at the same time")
+	flags.Bool("continue-on-error", false, "keep running the builds of the batch file once one fails")
+}
+
+// batchMode tells whether the builds run as a batch: those of the batch file or of the documents of the config file.
+func batchMode(flags *pflag.FlagSet) bool {
+	if flags.Lookup("batch-file") == nil {
+		return false
+	}
+	batchFile, _ := flags.GetString("batch-file")
+	return len(batchFile) > 0 || configOptions.configFile.batch()
+}
+
+// readBatchFile returns the builds of the batch file, on top of the root options.
+func readBatchFile(name string, rootOpts *RootOptions) ([]*builder.Build, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	values := []map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("invalid batch file %s: %s", name, err)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("no builds found in the batch file %s", name)
+	}
+	for i, v := range values {
+		expandEnv(v)
+		for _, u := range unknownConfigKeys(v, "", batchKeys) {
+			logger.
+				WithField("file", name).
+				WithField("build", i+1).
+				WithField("key", u.Key).
+				WithField("nearest", u.Nearest).
+				Warn("unknown batch file key, it is ignored")
+		}
+	}
+	return batchBuilds("batch file", values, rootOpts)
+}
+
+// batchBuilds returns the builds of the values of the source, on top of the root options.
+func batchBuilds(source string, values []map[string]interface{}, rootOpts *RootOptions) ([]*builder.Build, error) {
+	builds := []*builder.Build{}
+	for i, v := range values {
+		e, err := toBatchEntry(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid build %d of the %s: %s", i+1, source, err)
+		}
+		opts := e.options(rootOpts)
+		b, err := opts.validBuild()
+		if err != nil {
+			return nil, fmt.Errorf("invalid build %d of the %s: %s", i+1, source, err)
+		}
+		builds = append(builds, b)
+	}
+	return builds, nil
+}
+
+// toBatchEntry decodes the values of a build into a batch entry.
+func toBatchEntry(values map[string]interface{}) (batchEntry, error) {
+	e := batchEntry{}
+	data, err := yaml.Marshal(values)
+	if err != nil {
+		return e, err
+	}
+	err = yaml.Unmarshal(data, &e)
+	return e, err
+}
+
+// options returns the root options with those of the entry on top.
+func (e *batchEntry) options(rootOpts *RootOptions) RootOptions {
+	opts := *rootOpts
+	overrideOption(&opts.Target, e.Target)
+	overrideOption(&opts.KernelRelease, e.KernelRelease)
+	overrideOption(&opts.KernelVersion, e.KernelVersion)
+	overrideOption(&opts.KernelConfigData, e.KernelConfigData)
+	overrideOption(&opts.Architecture, e.Architecture)
+	overrideOption(&opts.PackageArchitecture, e.PackageArch)
+	overrideOption(&opts.DriverVersion, e.DriverVersion)
+	overrideOption(&opts.BuilderImage, e.BuilderImage)
+	overrideOption(&opts.ImageRepo, e.ImageRepo)
+	overrideOption(&opts.LLVMVersion, e.LLVMVersion)
+	overrideOption(&opts.GCCVersion, e.GCCVersion)
+	overrideOption(&opts.CcacheDir, e.CcacheDir)
+	overrideOption(&opts.BuilderTemplate, e.BuilderTemplate)
+	overrideOption(&opts.PushOCI, e.PushOCI)
+	overrideOption(&opts.BuildLog, e.BuildLog)
+	overrideOption(&opts.MakeFlags, e.MakeFlags)
+	if len(e.KernelUrls) > 0 {
+		opts.KernelUrls = e.KernelUrls
+	}
+	if len(e.Env) > 0 {
+		opts.Env = e.Env
+	}
+	// the outputs of the builds cannot be shared,
+	// the s3 URLs of the options are templated with the build details instead so they apply to the artifacts built
+	opts.Output = OutputOptions{Module: e.Output.Module, Probe: e.Output.Probe, ModernProbe: e.Output.ModernProbe, BTF: e.Output.BTF, DKMS: e.Output.DKMS}
+	if len(e.Output.Module) > 0 {
+		opts.Output.ModuleS3 = rootOpts.Output.ModuleS3
+	}
+	if len(e.Output.Probe) > 0 {
+		opts.Output.ProbeS3 = rootOpts.Output.ProbeS3
+	}
+	overrideOption(&opts.Output.ModuleS3, e.Output.ModuleS3)
+	overrideOption(&opts.Output.ProbeS3, e.Output.ProbeS3)
+	return opts
+}
+
+// overrideOption replaces the option with the value, when given.
+func overrideOption(v *string, with string) {
+	if len(with) > 0 {
+		*v = with
+	}
+}
+
+// runBatch runs the builds of the batch file with the processor and logs their summary,
+// it returns an error when any of them did not succeed.
+func runBatch(flags *pflag.FlagSet, rootOpts *RootOptions, processor driverbuilder.BuildProcessor) error {
+	batchFile, _ := flags.GetString("batch-file")
+	jobs, _ := flags.GetInt("jobs")
+	continueOnError, _ := flags.GetBool("continue-on-error")
+	if jobs <= 0 {
+		return fmt.Errorf("--jobs must be greater than 0")
+	}
+
+	var builds []*builder.Build
+	var err error
+	if len(batchFile) > 0 {
+		builds, err = readBatchFile(batchFile, rootOpts)
+	} else {
+		builds, err = batchBuilds("config file", configOptions.configFile.builds(), rootOpts)
+	}
+	if err != nil {
+		return err
+	}
+
+	// the timeout applies to each build, the processor bounds them
+	ctx := signals.WithStandardSignals(context.Background())
+	start := time.Now()
+	processor, done, err := dryRunOr(processor)
+	if err != nil {
+		return err
+	}
+	defer done()
+	if err := serveMetrics(ctx); err != nil {
+		return err
+	}
+	results := driverbuilder.RunBatch(ctx, skipExistingOr(processor), builds, jobs, continueOnError)
+	if err := writeReport(func(w io.Writer, format string) error {
+		return driverbuilder.WriteBatchReport(w, format, results)
+	}); err != nil {
+		logger.WithError(err).Error("error writing the build report")
+	}
+
+	failed, partial, skipped, cached := 0, 0, 0, 0
+	for _, res := range results {
+		log := logger.
+			WithField("kernelrelease", res.Build.KernelRelease).
+			WithField("target", res.Build.TargetType)
+		switch {
+		case res.Err == driverbuilder.ErrBatchSkipped:
+			skipped++
+			log.Warn("build skipped")
+		case errors.Is(res.Err, driverbuilder.ErrPartialBuild):
+			failed++
+			partial++
+			log.WithError(res.Err).WithField("duration", res.Duration.Round(time.Second)).Error("build partially succeeded")
+		case errors.Is(res.Err, driverbuilder.ErrArtifactTransfer):
+			failed++
+			log.WithError(res.Err).WithField("duration", res.Duration.Round(time.Second)).Error("build succeeded, its artifacts could not be retrieved")
+		case res.Err != nil:
+			failed++
+			log.WithError(res.Err).WithField("duration", res.Duration.Round(time.Second)).Error("build failed")
+		case res.Report != nil && res.Report.Cached:
+			cached++
+			log.Info("build cached, its artifacts exist already")
+		default:
+			log.WithField("duration", res.Duration.Round(time.Second)).Info("build succeeded")
+		}
+		if res.Report != nil && !res.Report.Cached {
+			logTimings(res.Report)
+		}
+	}
+	logger.
+		WithField("built", len(results)-failed-skipped-cached).
+		WithField("cached", cached).
+		WithField("failed", failed).
+		WithField("partial", partial).
+		WithField("skipped", skipped).
+		WithField("duration", time.Since(start).Round(time.Second)).
+		Info("batch completed")
+	// driverkit tells the batches whose builds failed only partially apart, like the single builds
+	if failed > 0 && failed == partial && skipped == 0 {
+		return fmt.Errorf("%w: %d of %d builds produced only some of their artifacts", driverbuilder.ErrPartialBuild, partial, len(results))
+	}
+	if failed > 0 || skipped > 0 {
+		return interrupted(ctx, fmt.Errorf("%d of %d builds did not succeed", failed+skipped, len(results)))
+	}
+	return nil
+}
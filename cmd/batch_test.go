@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeBatchFile(t *testing.T, content string) string {
+	name := filepath.Join(t.TempDir(), "builds.yaml")
+	if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestReadBatchFile(t *testing.T) {
+	rootOpts := NewRootOptions()
+	rootOpts.Architecture = "amd64"
+	rootOpts.BuilderImage = "falcosecurity/driverkit-builder:latest"
+	rootOpts.Target = "ubuntu-generic"
+	rootOpts.Output.Module = "/tmp/ignored.ko"
+
+	name := writeBatchFile(t, `
+- kernelrelease: 5.15.0-25-generic
+  kernelversion: 26
+  output:
+    module: /tmp/falco-5.15.0-25-generic.ko
+- target: ubuntu-aws
+  kernelrelease: 4.15.0-1057-aws
+  kernelversion: 59
+  output:
+    probe: /tmp/falco-4.15.0-1057-aws.o
+`)
+	builds, err := readBatchFile(name, rootOpts)
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if len(builds) != 2 {
+		t.Fatalf("Got: [ %d builds ] / Want: [ 2 ]", len(builds))
+	}
+	if b := builds[0]; b.TargetType != "ubuntu-generic" || b.KernelVersion != "26" || b.ModuleFilePath != "/tmp/falco-5.15.0-25-generic.ko" || b.ProbeFilePath != "" {
+		t.Errorf("Got: [ %+v ] / Want: [ the first build with the target of the options ]", b)
+	}
+	if b := builds[1]; b.TargetType != "ubuntu-aws" || b.Architecture != "amd64" || b.ModuleFilePath != "" || b.ProbeFilePath != "/tmp/falco-4.15.0-1057-aws.o" {
+		t.Errorf("Got: [ %+v ] / Want: [ the second build with its own target and output ]", b)
+	}
+	if rootOpts.Target != "ubuntu-generic" || rootOpts.Output.Module != "/tmp/ignored.ko" {
+		t.Errorf("Got: [ %+v ] / Want: [ the options left untouched ]", rootOpts)
+	}
+}
+
+func TestReadBatchFileInvalid(t *testing.T) {
+	rootOpts := NewRootOptions()
+	rootOpts.Architecture = "amd64"
+	rootOpts.BuilderImage = "falcosecurity/driverkit-builder:latest"
+
+	for descr, content := range map[string]string{
+		"empty":          ``,
+		"not a list":     `kernelrelease: 5.15.0-25-generic`,
+		"missing output": "- target: ubuntu-generic\n  kernelrelease: 5.15.0-25-generic\n",
+		"missing target": "- kernelrelease: 5.15.0-25-generic\n  output:\n    module: /tmp/falco.ko\n",
+	} {
+		t.Run(descr, func(t *testing.T) {
+			if _, err := readBatchFile(writeBatchFile(t, content), rootOpts); err == nil {
+				t.Errorf("Expecting an error")
+			}
+		})
+	}
+}
+
+func TestReadBatchFileEnv(t *testing.T) {
+	os.Setenv("TEST_OUTPUT_DIR", "/tmp/drivers")
+	defer os.Unsetenv("TEST_OUTPUT_DIR")
+	rootOpts := NewRootOptions()
+	rootOpts.Architecture = "amd64"
+	rootOpts.BuilderImage = "falcosecurity/driverkit-builder:latest"
+	rootOpts.Target = "ubuntu-generic"
+
+	name := writeBatchFile(t, `
+- kernelrelease: 5.15.0-25-generic
+  kernelversion: 26
+  output:
+    module: ${TEST_OUTPUT_DIR}/falco-5.15.0-25-generic.ko
+`)
+	builds, err := readBatchFile(name, rootOpts)
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if got := builds[0].ModuleFilePath; got != "/tmp/drivers/falco-5.15.0-25-generic.ko" {
+		t.Errorf("Got: [ %s ] / Want: [ /tmp/drivers/falco-5.15.0-25-generic.ko ]", got)
+	}
+}
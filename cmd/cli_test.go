@@ -14,6 +14,7 @@ import (
 	"testing"
 
 	"github.com/acarl005/stripansi"
+	"github.com/spf13/cobra"
 	"gotest.tools/assert"
 )
 
@@ -277,6 +278,106 @@ var tests = []testCase{
 			out: "testdata/completion-targets.txt",
 		},
 	},
+	{
+		descr: "complete/docker/architectures",
+		args: []string{
+			"__complete",
+			"docker",
+			"--architecture",
+			"",
+		},
+		expect: expect{
+			out: "testdata/completion-architectures.txt",
+		},
+	},
+	{
+		descr: "validate/from-config-file",
+		args: []string{
+			"validate",
+			"-c",
+			"testdata/configs/1.yaml",
+		},
+		expect: expect{
+			out: "testdata/validate-from-config.txt",
+		},
+	},
+	{
+		descr: "validate/all-problems",
+		args: []string{
+			"validate",
+			"--kernelrelease",
+			"5.10.0-21-amd64",
+			"--kernelversion",
+			"1",
+			"--target",
+			"debian",
+			"--architecture",
+			"riscv64",
+			"--force-emulation",
+			"--gcc-version",
+			"8",
+			"--output-module",
+			"/tmp/falco-debian.ko",
+		},
+		expect: expect{
+			out: "testdata/validate-problems.txt",
+			err: "3 problems found",
+		},
+	},
+	{
+		descr: "mirrors/debian",
+		args: []string{
+			"mirrors",
+			"--target",
+			"debian",
+			"--mirror",
+			"debian=http://mirror.local/debian/pool/main/l/linux",
+		},
+		expect: expect{
+			out: "testdata/mirrors-debian.txt",
+		},
+	},
+	{
+		descr: "mirrors/no-mirrors-to-override",
+		args: []string{
+			"mirrors",
+			"--mirror",
+			"centos=http://mirror.local/centos",
+		},
+		expect: expect{
+			out: "testdata/mirrors-no-mirrors-to-override.txt",
+			err: "target centos has no mirrors to override, see driverkit mirrors",
+		},
+	},
+	{
+		descr: "validate/from-multi-document-config-file",
+		env: map[string]string{
+			"TEST_DRIVER_VERSION": "master",
+		},
+		args: []string{
+			"validate",
+			"-c",
+			"testdata/configs/3.yaml",
+		},
+		expect: expect{
+			out: "testdata/validate-from-multi-document-config.txt",
+		},
+	},
+	{
+		descr: "config/render",
+		env: map[string]string{
+			"TEST_DRIVER_VERSION": "master",
+		},
+		args: []string{
+			"config",
+			"render",
+			"-c",
+			"testdata/configs/3.yaml",
+		},
+		expect: expect{
+			out: "testdata/config-render.txt",
+		},
+	},
 	{
 		descr: "completion/empty",
 		args: []string{
@@ -371,3 +472,18 @@ func TestCLI(t *testing.T) {
 		})
 	}
 }
+
+func TestSkipsRootValidation(t *testing.T) {
+	root := NewRootCmd().Command()
+	skipped := map[string]bool{"completion": true, "targets": true, "serve": true, "validate": true, "render": true, "init": true, "mirrors": true, "help": true}
+	var visit func(c *cobra.Command)
+	visit = func(c *cobra.Command) {
+		if got := skipsRootValidation(c); got != skipped[c.Name()] {
+			t.Errorf("Test Input: '%s' | Got: '%t' / Want: '%t'", c.CommandPath(), got, skipped[c.Name()])
+		}
+		for _, sub := range c.Commands() {
+			visit(sub)
+		}
+	}
+	visit(root)
+}
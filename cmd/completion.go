@@ -49,6 +49,7 @@ func NewCompletionCmd() *cobra.Command {
 		Short:             "Generates completion scripts.",
 		Long:              long.String(),
 		Args:              validateArgs(),
+		Annotations:       map[string]string{skipRootValidation: "true"},
 		ValidArgs:         cmdArgs,
 		DisableAutoGenTag: true,
 		Run: func(c *cobra.Command, args []string) {
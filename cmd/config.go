@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// NewConfigCmd creates the `driverkit config` command.
+func NewConfigCmd(rootFlags *pflag.FlagSet) *cobra.Command {
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the config file.",
+	}
+	renderCmd := &cobra.Command{
+		Use:         "render",
+		Short:       "Print the builds of the config file, its environment variables expanded and its defaults merged.",
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{skipRootValidation: "true"},
+		RunE: func(c *cobra.Command, args []string) error {
+			if configOptions.configFile == nil {
+				return fmt.Errorf("no YAML config file found")
+			}
+			return configOptions.configFile.render(c.OutOrStdout())
+		},
+	}
+	configCmd.AddCommand(renderCmd)
+	configCmd.PersistentFlags().AddFlagSet(rootFlags)
+	return configCmd
+}
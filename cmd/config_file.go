@@ -0,0 +1,295 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultsKey is the key of the block of a config file document whose values apply to all the builds of the file.
+const defaultsKey = "defaults"
+
+// envReference matches the ${ENV_VAR} references of the config file values.
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// nestedConfigKeys are the options the config file can nest, e.g. the output ones.
+var nestedConfigKeys = map[string]string{
+	"output-module":       "output.module",
+	"output-probe":        "output.probe",
+	"output-modern-probe": "output.modern-probe",
+	"output-btf":          "output.btf",
+	"output-module-s3":    "output.module-s3",
+	"output-probe-s3":     "output.probe-s3",
+}
+
+// batchKeys are the keys a build of the batch file can have, the nested ones dotted.
+var batchKeys = yamlKeys(reflect.TypeOf(batchEntry{}), "")
+
+// configFile is a YAML config file, its documents with their environment variable references expanded.
+type configFile struct {
+	documents []map[string]interface{}
+}
+
+// unknownKey is a key of the config file driverkit does not know, with the known one nearest to it.
+type unknownKey struct {
+	Document int
+	Key      string
+	Nearest  string
+}
+
+// parseConfigFile parses the documents of the YAML config file.
+func parseConfigFile(data []byte) (*configFile, error) {
+	cf := &configFile{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		doc := map[string]interface{}{}
+		if err := dec.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if d, ok := doc[defaultsKey]; ok {
+			if _, ok := d.(map[string]interface{}); !ok {
+				return nil, fmt.Errorf("the %s of document %d must be a mapping", defaultsKey, len(cf.documents)+1)
+			}
+		}
+		cf.documents = append(cf.documents, expandEnv(doc).(map[string]interface{}))
+	}
+	return cf, nil
+}
+
+// defaults returns the values of the defaults blocks of all the documents, the later ones overriding the earlier.
+func (cf *configFile) defaults() map[string]interface{} {
+	defaults := map[string]interface{}{}
+	for _, doc := range cf.documents {
+		if d, ok := doc[defaultsKey]; ok {
+			defaults = mergeConfigValues(defaults, d.(map[string]interface{}))
+		}
+	}
+	return defaults
+}
+
+// builds returns the builds of the documents, those having more than a defaults block, merged with the defaults.
+func (cf *configFile) builds() []map[string]interface{} {
+	defaults := cf.defaults()
+	builds := []map[string]interface{}{}
+	for _, doc := range cf.documents {
+		build := map[string]interface{}{}
+		for k, v := range doc {
+			if k != defaultsKey {
+				build[k] = v
+			}
+		}
+		if len(build) > 0 {
+			builds = append(builds, mergeConfigValues(defaults, build))
+		}
+	}
+	return builds
+}
+
+// batch tells whether the config file describes several builds, to run as a batch.
+func (cf *configFile) batch() bool {
+	return cf != nil && len(cf.builds()) > 1
+}
+
+// options returns the values the options are read from:
+// those of the only build or, when there are many of them, the defaults.
+func (cf *configFile) options() map[string]interface{} {
+	if builds := cf.builds(); len(builds) == 1 {
+		return builds[0]
+	}
+	return cf.defaults()
+}
+
+// unknownKeys returns the keys of the documents that are not known,
+// the defaults ones among the option keys, the build ones among the build keys.
+func (cf *configFile) unknownKeys(optionKeys, buildKeys []string) []unknownKey {
+	unknown := []unknownKey{}
+	for i, doc := range cf.documents {
+		for _, k := range sortedKeys(doc) {
+			if k == defaultsKey {
+				for _, u := range unknownConfigKeys(doc[k].(map[string]interface{}), "", optionKeys) {
+					unknown = append(unknown, unknownKey{Document: i + 1, Key: defaultsKey + "." + u.Key, Nearest: u.Nearest})
+				}
+				continue
+			}
+			for _, u := range unknownConfigKeys(map[string]interface{}{k: doc[k]}, "", buildKeys) {
+				u.Document = i + 1
+				unknown = append(unknown, u)
+			}
+		}
+	}
+	return unknown
+}
+
+// render writes the builds of the config file, or its defaults when it has no build, one YAML document each.
+func (cf *configFile) render(w io.Writer) error {
+	builds := cf.builds()
+	if len(builds) == 0 {
+		builds = append(builds, cf.defaults())
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	for _, b := range builds {
+		if err := enc.Encode(b); err != nil {
+			return err
+		}
+	}
+	return enc.Close()
+}
+
+// unknownConfigKeys returns the keys of the values not among the known ones, the nested ones dotted after the prefix.
+func unknownConfigKeys(values map[string]interface{}, prefix string, known []string) []unknownKey {
+	unknown := []unknownKey{}
+	for _, k := range sortedKeys(values) {
+		key := prefix + k
+		if containsKey(known, key) {
+			continue
+		}
+		if nested, ok := values[k].(map[string]interface{}); ok && hasKeyPrefix(known, key+".") {
+			unknown = append(unknown, unknownConfigKeys(nested, key+".", known)...)
+			continue
+		}
+		unknown = append(unknown, unknownKey{Key: key, Nearest: nearestKey(key, known)})
+	}
+	return unknown
+}
+
+// configKeys returns the keys of the config file: the names of the flags and the nested options.
+func configKeys(flags *pflag.FlagSet) []string {
+	keys := []string{}
+	flags.VisitAll(func(f *pflag.Flag) {
+		keys = append(keys, f.Name)
+	})
+	for _, k := range nestedConfigKeys {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// yamlKeys returns the yaml keys of the fields of the struct type, those of the nested structs dotted after their own.
+func yamlKeys(t reflect.Type, prefix string) []string {
+	keys := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := prefix + strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if f.Type.Kind() == reflect.Struct {
+			keys = append(keys, yamlKeys(f.Type, key+".")...)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// expandEnv replaces the ${ENV_VAR} references of the strings of the value with the environment variables,
+// the unset ones with nothing.
+func expandEnv(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return envReference.ReplaceAllStringFunc(v, func(ref string) string {
+			return os.Getenv(envReference.FindStringSubmatch(ref)[1])
+		})
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = expandEnv(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = expandEnv(e)
+		}
+	}
+	return v
+}
+
+// mergeConfigValues returns the values with those of the overrides on top, the nested ones merged too.
+func mergeConfigValues(values, overrides map[string]interface{}) map[string]interface{} {
+	merged := map[string]interface{}{}
+	for k, v := range values {
+		merged[k] = v
+	}
+	for k, v := range overrides {
+		nested, ok := v.(map[string]interface{})
+		if current, isMap := merged[k].(map[string]interface{}); ok && isMap {
+			merged[k] = mergeConfigValues(current, nested)
+			continue
+		}
+		merged[k] = v
+	}
+	return merged
+}
+
+// nearestKey returns the known key with the lowest edit distance from the key.
+func nearestKey(key string, known []string) string {
+	nearest, distance := "", -1
+	for _, k := range known {
+		if d := editDistance(key, k); distance < 0 || d < distance {
+			nearest, distance = k, d
+		}
+	}
+	return nearest
+}
+
+// editDistance returns the Levenshtein distance between the strings.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, minInt(cur[j-1]+1, prev[j-1]+cost))
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func sortedKeys(values map[string]interface{}) []string {
+	keys := []string{}
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func containsKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func hasKeyPrefix(keys []string, prefix string) bool {
+	for _, k := range keys {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseConfigFile(t *testing.T) {
+	os.Setenv("TEST_DRIVER_VERSION", "2.0.0+driver")
+	defer os.Unsetenv("TEST_DRIVER_VERSION")
+	cf, err := parseConfigFile([]byte(`
+defaults:
+  target: ubuntu-generic
+  driverversion: ${TEST_DRIVER_VERSION}
+  output:
+    module-s3: s3://bucket/${TEST_DRIVER_VERSION}/${TEST_UNSET}{{ .KernelRelease }}.ko
+---
+kernelrelease: 5.15.0-25-generic
+output:
+  module: /tmp/falco-${TEST_DRIVER_VERSION}.ko
+---
+target: ubuntu-aws
+kernelrelease: 4.15.0-1057-aws
+make-flags: $(nproc)
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	want := []map[string]interface{}{
+		{
+			"target":        "ubuntu-generic",
+			"driverversion": "2.0.0+driver",
+			"kernelrelease": "5.15.0-25-generic",
+			"output": map[string]interface{}{
+				"module":    "/tmp/falco-2.0.0+driver.ko",
+				"module-s3": "s3://bucket/2.0.0+driver/{{ .KernelRelease }}.ko",
+			},
+		},
+		{
+			"target":        "ubuntu-aws",
+			"driverversion": "2.0.0+driver",
+			"kernelrelease": "4.15.0-1057-aws",
+			"make-flags":    "$(nproc)",
+			"output": map[string]interface{}{
+				"module-s3": "s3://bucket/2.0.0+driver/{{ .KernelRelease }}.ko",
+			},
+		},
+	}
+	if got := cf.builds(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got: [ %v ] / Want: [ %v ]", got, want)
+	}
+	if !cf.batch() {
+		t.Errorf("Got: [ not a batch ] / Want: [ a batch of 2 builds ]")
+	}
+	if got := cf.options(); got["kernelrelease"] != nil || got["target"] != "ubuntu-generic" {
+		t.Errorf("Got: [ %v ] / Want: [ the defaults ]", got)
+	}
+}
+
+func TestParseConfigFileSingleBuild(t *testing.T) {
+	cf, err := parseConfigFile([]byte(`
+defaults:
+  kernelversion: 59
+  output:
+    module: /tmp/default.ko
+kernelrelease: 4.15.0-1057-aws
+kernelversion: 60
+output:
+  probe: /tmp/falco.o
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if cf.batch() {
+		t.Errorf("Got: [ a batch ] / Want: [ a single build ]")
+	}
+	want := map[string]interface{}{
+		"kernelrelease": "4.15.0-1057-aws",
+		"kernelversion": 60,
+		"output": map[string]interface{}{
+			"module": "/tmp/default.ko",
+			"probe":  "/tmp/falco.o",
+		},
+	}
+	if got := cf.options(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got: [ %v ] / Want: [ %v ]", got, want)
+	}
+}
+
+func TestParseConfigFileInvalid(t *testing.T) {
+	for descr, content := range map[string]string{
+		"not a mapping":          `- kernelrelease: 5.15.0-25-generic`,
+		"defaults not a mapping": "defaults: [ubuntu-generic]\n",
+		"invalid yaml":           "kernelrelease: [\n",
+	} {
+		t.Run(descr, func(t *testing.T) {
+			if _, err := parseConfigFile([]byte(content)); err == nil {
+				t.Errorf("Expecting an error")
+			}
+		})
+	}
+}
+
+func TestConfigFileUnknownKeys(t *testing.T) {
+	cf, err := parseConfigFile([]byte(`
+defaults:
+  timeot: 60
+  output:
+    module-s3: s3://bucket/falco.ko
+---
+kernelrelase: 5.15.0-25-generic
+output:
+  modul: /tmp/falco.ko
+---
+kernelrelease: 5.15.0-26-generic
+timeout: 60
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	optionKeys := []string{"kernelrelease", "timeout", "output.module", "output.module-s3"}
+	buildKeys := []string{"kernelrelease", "output.module"}
+	want := []unknownKey{
+		{Document: 1, Key: "defaults.timeot", Nearest: "timeout"},
+		{Document: 2, Key: "kernelrelase", Nearest: "kernelrelease"},
+		{Document: 2, Key: "output.modul", Nearest: "output.module"},
+		{Document: 3, Key: "timeout", Nearest: "output.module"},
+	}
+	if got := cf.unknownKeys(optionKeys, buildKeys); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got: [ %v ] / Want: [ %v ]", got, want)
+	}
+}
+
+func TestConfigFileRender(t *testing.T) {
+	cf, err := parseConfigFile([]byte("defaults:\n  target: ubuntu-generic\n"))
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	b := &bytes.Buffer{}
+	if err := cf.render(b); err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if want := "target: ubuntu-generic\n"; b.String() != want {
+		t.Errorf("Got: [ %q ] / Want: [ %q ]", b.String(), want)
+	}
+}
+
+func TestBatchKeys(t *testing.T) {
+	for _, k := range []string{"kernelrelease", "image-repo", "output.module", "output.probe-s3"} {
+		if !containsKey(batchKeys, k) {
+			t.Errorf("Test Input: '%s' | Got: [ not a batch key ] / Want: [ a batch key ]", k)
+		}
+	}
+	if containsKey(batchKeys, "output") {
+		t.Errorf("Got: [ output a batch key ] / Want: [ only its nested keys ]")
+	}
+}
@@ -1,12 +1,22 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"time"
 
 	"github.com/creasty/defaults"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/metrics"
+	"github.com/falcosecurity/driverkit/pkg/signals"
 	"github.com/falcosecurity/driverkit/validate"
 	"github.com/go-playground/validator/v10"
 	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 )
 
 var validProcessors = []string{"docker", "kubernetes"}
@@ -15,13 +25,24 @@ var configOptions *ConfigOptions
 
 // ConfigOptions represent the persistent configuration flags of driverkit.
 type ConfigOptions struct {
-	ConfigFile string
-	LogLevel   string `validate:"logrus" name:"log level" default:"info"`
-	Timeout    int    `validate:"number,min=30" default:"120" name:"timeout"`
-	ProxyURL   string `validate:"omitempty,proxy" name:"proxy url"`
-	DryRun     bool
+	ConfigFile   string
+	LogLevel     string `validate:"logrus" name:"log level" default:"info"`
+	LogFormat    string `validate:"oneof=text json" name:"log format" default:"text"`
+	Timeout      int    `validate:"number,min=30" default:"120" name:"timeout"`
+	ProxyURL     string `validate:"omitempty,proxy" name:"proxy url"`
+	CACert       string `validate:"omitempty,file" name:"ca cert"`
+	DryRun       bool
+	ValidateOnly bool
+	ScriptOut    string `validate:"omitempty,filepath" name:"script out"`
+	ReportFile   string `validate:"omitempty,filepath" name:"report file"`
+	ReportFormat string `validate:"oneof=json yaml" default:"json" name:"report format"`
+	MetricsAddr  string
+	Verbose      bool
+	SkipExisting bool
 
 	configErrors bool
+	// configFile is the YAML config file read, if any.
+	configFile *configFile
 }
 
 // NewConfigOptions creates an instance of ConfigOptions.
@@ -33,6 +54,157 @@ func NewConfigOptions() *ConfigOptions {
 	return o
 }
 
+// caCertEnv is the environment variable the CA bundle can be provided with too.
+const caCertEnv = "KERNEL_DOWNLOAD_CA_BUNDLE"
+
+// caCert returns the CA bundle path given by flag, config file or environment variable.
+func caCert() string {
+	if v := viper.GetString("ca-cert"); v != "" {
+		return v
+	}
+	return os.Getenv(caCertEnv)
+}
+
+// buildContext returns the context the build runs with,
+// it is canceled on SIGINT and SIGTERM or when the timeout expires.
+func buildContext() (context.Context, context.CancelFunc) {
+	ctx := signals.WithStandardSignals(context.Background())
+	return context.WithTimeout(ctx, time.Duration(viper.GetInt("timeout"))*time.Second)
+}
+
+// runBuild runs the build with the processor, writing its report into the report file if any.
+func runBuild(processor driverbuilder.BuildProcessor, b *builder.Build) error {
+	ctx, cancel := buildContext()
+	defer cancel()
+	if err := driverbuilder.ValidateBuild(b); err != nil {
+		return err
+	}
+	if err := serveMetrics(ctx); err != nil {
+		return err
+	}
+	processor, done, err := dryRunOr(processor)
+	if err != nil {
+		return err
+	}
+	defer done()
+	report, err := skipExistingOr(processor).Start(ctx, b)
+	if report != nil {
+		if err := writeReport(report.Write); err != nil {
+			logger.WithError(err).Error("error writing the build report")
+		}
+		logTimings(report)
+	}
+	return interrupted(ctx, err)
+}
+
+// errInterrupted is wrapped by the errors of the builds interrupted by SIGINT or SIGTERM, once cleaned up.
+var errInterrupted = errors.New("interrupted")
+
+// interrupted wraps the error of the builds run with the context into errInterrupted, when a signal canceled it.
+func interrupted(ctx context.Context, err error) error {
+	if sig, ok := signals.Received(ctx); ok && err != nil {
+		return fmt.Errorf("%w by %s: %s", errInterrupted, sig, err)
+	}
+	return err
+}
+
+// interruptedExitCode is the exit code of driverkit when the builds were interrupted, as the shells exit on SIGINT.
+const interruptedExitCode = 130
+
+// partialBuildExitCode is the exit code of driverkit when the builds produced only some of their artifacts,
+// e.g. the kernel module but not the eBPF probe.
+const partialBuildExitCode = 2
+
+// fatalBuild logs the error the builds failed with and exits, with partialBuildExitCode when they produced some of their artifacts nonetheless,
+// or with interruptedExitCode when they were interrupted.
+func fatalBuild(err error) {
+	if errors.Is(err, errInterrupted) {
+		logger.WithError(err).Error("exiting, the builds were interrupted and cleaned up")
+		logger.Exit(interruptedExitCode)
+	}
+	if errors.Is(err, driverbuilder.ErrPartialBuild) {
+		logger.WithError(err).Error("exiting, only some of the artifacts were built")
+		logger.Exit(partialBuildExitCode)
+	}
+	logger.WithError(err).Fatal("exiting")
+}
+
+// serveMetrics exposes the metrics of the builds until the context is done, when asked to.
+func serveMetrics(ctx context.Context) error {
+	if addr := viper.GetString("metrics-addr"); len(addr) > 0 {
+		return metrics.Serve(ctx, addr)
+	}
+	return nil
+}
+
+// logTimings logs how long the build took to resolve the kernel packages, to build and to handle the artifacts, when verbose.
+func logTimings(r *driverbuilder.BuildReport) {
+	if !viper.GetBool("verbose") {
+		return
+	}
+	seconds := func(s float64) time.Duration {
+		return time.Duration(s * float64(time.Second)).Round(time.Millisecond)
+	}
+	logger.
+		WithField("target", r.Target).
+		WithField("kernelrelease", r.KernelRelease).
+		WithField("resolution", seconds(r.Timings.ResolutionSeconds)).
+		WithField("build", seconds(r.Timings.BuildSeconds)).
+		WithField("artifacts", seconds(r.Timings.ArtifactsSeconds)).
+		WithField("total", seconds(r.DurationSeconds)).
+		Info("build timings")
+}
+
+// dryRunOr returns the processor the builds run with, the dry-run one in place of the given one when asked to,
+// writing the scripts into the script file or to the standard output.
+// The returned function must be called once the builds are done.
+func dryRunOr(processor driverbuilder.BuildProcessor) (driverbuilder.BuildProcessor, func(), error) {
+	if !viper.GetBool("dry-run") {
+		return processor, func() {}, nil
+	}
+	var scriptOut io.Writer = os.Stdout
+	done := func() {}
+	if name := viper.GetString("script-out"); len(name) > 0 {
+		f, err := os.Create(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		scriptOut = f
+		done = func() {
+			if err := f.Close(); err != nil {
+				logger.WithError(err).Error("error writing the script file")
+			}
+		}
+	}
+	return driverbuilder.NewDryRunBuildProcessor(processor, viper.GetInt("timeout"), viper.GetString("proxy"), caCert(), scriptOut), done, nil
+}
+
+// skipExistingOr returns the processor skipping the builds whose artifacts exist already when asked to, the given one otherwise.
+func skipExistingOr(processor driverbuilder.BuildProcessor) driverbuilder.BuildProcessor {
+	if !viper.GetBool("skip-existing") {
+		return processor
+	}
+	return driverbuilder.NewSkipExistingBuildProcessor(processor)
+}
+
+// writeReport writes the report into the report file, if any.
+func writeReport(write func(w io.Writer, format string) error) error {
+	name := viper.GetString("report-file")
+	if len(name) == 0 {
+		return nil
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := write(f, viper.GetString("report-format")); err != nil {
+		f.Close()
+		return err
+	}
+	logger.WithField("path", name).Info("build report available")
+	return f.Close()
+}
+
 // Validate validates the ConfigOptions fields.
 func (co *ConfigOptions) Validate() []error {
 	if err := validate.V.Struct(co); err != nil {
@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"os"
+
 	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
 	logger "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -15,15 +17,84 @@ func NewDockerCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Comman
 		Short: "Build Falco kernel modules and eBPF probes against a docker daemon.",
 		Run: func(c *cobra.Command, args []string) {
 			logger.WithField("processor", c.Name()).Info("driver building, it will take a few seconds")
-			if !configOptions.DryRun {
-				if err := driverbuilder.NewDockerBuildProcessor(viper.GetInt("timeout"), viper.GetString("proxy")).Start(rootOpts.toBuild()); err != nil {
+			reuseContainer, _ := c.Flags().GetString("reuse-container")
+			processor := driverbuilder.NewDockerBuildProcessor(viper.GetInt("timeout"), viper.GetString("proxy"), caCert(), registryCredentials(c.Flags()), dockerHost(c.Flags()), reuseContainer)
+			if cleanup, _ := c.Flags().GetBool("cleanup"); cleanup {
+				if len(reuseContainer) == 0 {
+					logger.Fatal("--cleanup needs the --reuse-container to remove")
+				}
+				if err := processor.Cleanup(); err != nil {
 					logger.WithError(err).Fatal("exiting")
 				}
+				return
+			}
+			if batchMode(c.Flags()) {
+				if jobs, _ := c.Flags().GetInt("jobs"); jobs > 1 && len(reuseContainer) > 0 {
+					logger.Fatal("builds into a --reuse-container cannot run concurrently, use --jobs 1")
+				}
+				if !configOptions.DryRun {
+					if err := runBatch(c.Flags(), rootOpts, processor); err != nil {
+						fatalBuild(err)
+					}
+				}
+				return
+			}
+			if !configOptions.DryRun {
+				if err := runBuild(processor, rootOpts.toBuild()); err != nil {
+					fatalBuild(err)
+				}
 			}
 		},
 	}
+	addRegistryFlags(dockerCmd.PersistentFlags())
+	addBatchFlags(dockerCmd.PersistentFlags())
+	dockerCmd.PersistentFlags().String("reuse-container", "", "long-lived builder container, created if missing, the builds run into one at a time rather than into a new container each")
+	dockerCmd.PersistentFlags().Bool("cleanup", false, "remove the --reuse-container builder container, without building anything")
+	addDockerHostFlags(dockerCmd.PersistentFlags())
 	// Add root flags
 	dockerCmd.PersistentFlags().AddFlagSet(rootFlags)
 
 	return dockerCmd
 }
+
+// registryPasswordEnv is the environment variable the registry password can be provided with too.
+const registryPasswordEnv = "DRIVERKIT_REGISTRY_PASSWORD"
+
+// addRegistryFlags adds the flags of the credentials the builder image is pulled with.
+func addRegistryFlags(flags *pflag.FlagSet) {
+	flags.String("registry-user", "", "username to pull the builder image with")
+	flags.String("registry-password", "", "password to pull the builder image with, it can also be provided with the "+registryPasswordEnv+" environment variable")
+	flags.String("registry-config", "", "docker config file the credentials to pull the builder image with are read from (default $DOCKER_CONFIG/config.json or ~/.docker/config.json)")
+}
+
+// registryCredentials reads the credentials the builder image is pulled with from the flags.
+func registryCredentials(flags *pflag.FlagSet) driverbuilder.RegistryCredentials {
+	creds := driverbuilder.RegistryCredentials{}
+	creds.Username, _ = flags.GetString("registry-user")
+	creds.Password, _ = flags.GetString("registry-password")
+	creds.ConfigFile, _ = flags.GetString("registry-config")
+	if creds.Password == "" {
+		creds.Password = os.Getenv(registryPasswordEnv)
+	}
+	return creds
+}
+
+// addDockerHostFlags adds the flags of the docker daemon to build against.
+func addDockerHostFlags(flags *pflag.FlagSet) {
+	flags.String("docker-host", "", "docker daemon to build against, e.g. tcp://build-host:2376 (default $DOCKER_HOST or the local socket)")
+	flags.Bool("docker-tls-verify", false, "use TLS and verify the docker daemon, with the certificates of $DOCKER_CERT_PATH or ~/.docker unless given")
+	flags.String("docker-tls-ca-cert", "", "CA the docker daemon certificate is verified against, it enables TLS")
+	flags.String("docker-tls-cert", "", "client certificate to authenticate to the docker daemon with, it enables TLS")
+	flags.String("docker-tls-key", "", "client key to authenticate to the docker daemon with, it enables TLS")
+}
+
+// dockerHost reads the docker daemon to build against from the flags.
+func dockerHost(flags *pflag.FlagSet) driverbuilder.DockerHost {
+	h := driverbuilder.DockerHost{}
+	h.Host, _ = flags.GetString("docker-host")
+	h.TLSVerify, _ = flags.GetBool("docker-tls-verify")
+	h.TLSCACert, _ = flags.GetString("docker-tls-ca-cert")
+	h.TLSCert, _ = flags.GetString("docker-tls-cert")
+	h.TLSKey, _ = flags.GetString("docker-tls-key")
+	return h
+}
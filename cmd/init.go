@@ -0,0 +1,348 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/falcosecurity/driverkit/pkg/autodetect"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// wizardConfig is the config file written by `driverkit init`, with the options of a single build.
+type wizardConfig struct {
+	Target               string `yaml:"target"`
+	Architecture         string `yaml:"architecture"`
+	KernelRelease        string `yaml:"kernelrelease"`
+	KernelVersion        string `yaml:"kernelversion,omitempty"`
+	KernelConfigDataFile string `yaml:"kernelconfigdata-file,omitempty"`
+	DriverVersion        string `yaml:"driverversion"`
+	Output               struct {
+		Module string `yaml:"module,omitempty"`
+		Probe  string `yaml:"probe,omitempty"`
+	} `yaml:"output"`
+}
+
+// noOutput is the answer skipping an output of the build.
+const noOutput = "none"
+
+// safeCommandArg matches the arguments of the command that need no quoting.
+var safeCommandArg = regexp.MustCompile(`^[A-Za-z0-9_./:+=@%-]+$`)
+
+// NewInitCmd creates the `driverkit init` command.
+func NewInitCmd() *cobra.Command {
+	initCmd := &cobra.Command{
+		Use:         "init",
+		Short:       "Write a config file for a build, answering questions or detecting the local machine.",
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{skipRootValidation: "true"},
+		// The wrong answers are asked again, the usage would only bury the errors
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			fromHost, _ := c.Flags().GetBool("from-host")
+			printOnly, _ := c.Flags().GetBool("print")
+			output, _ := c.Flags().GetString("output")
+			force, _ := c.Flags().GetBool("force")
+			if !printOnly && !force {
+				if _, err := os.Stat(output); err == nil {
+					return fmt.Errorf("the config file %s exists already, use --force to overwrite it", output)
+				}
+			}
+			w := newInitWizard(c.InOrStdin(), c.ErrOrStderr(), fromHost)
+			ic, err := w.run()
+			if err != nil {
+				return err
+			}
+			data := &bytes.Buffer{}
+			enc := yaml.NewEncoder(data)
+			enc.SetIndent(2)
+			if err := enc.Encode(ic); err != nil {
+				return err
+			}
+			if err := enc.Close(); err != nil {
+				return err
+			}
+			if printOnly {
+				fmt.Fprintf(c.OutOrStdout(), "%s# %s\n", data, ic.command())
+				return nil
+			}
+			if err := ioutil.WriteFile(output, data.Bytes(), 0644); err != nil {
+				return err
+			}
+			fmt.Fprintf(c.OutOrStdout(), "config written to %s, build with:\n  driverkit docker -c %s\nor without the config file:\n  %s\n", output, commandArg(output), ic.command())
+			return nil
+		},
+	}
+	initCmd.Flags().Bool("from-host", false, "detect the build of the local machine instead of asking, failing when an answer is invalid")
+	initCmd.Flags().Bool("print", false, "print the config file, followed by the equivalent command as a comment, to the standard output instead of writing it")
+	initCmd.Flags().StringP("output", "o", "driverkit.yaml", "file the config is written to")
+	initCmd.Flags().Bool("force", false, "overwrite the config file if it exists")
+	return initCmd
+}
+
+// initWizard asks the options of a build, checking every answer as the build would, offline.
+// Detecting the local machine, it takes the detected values as the answers.
+type initWizard struct {
+	in       *bufio.Reader
+	out      io.Writer
+	fromHost bool
+	eof      bool
+	opts     *RootOptions
+	detected *autodetect.Result
+}
+
+func newInitWizard(in io.Reader, out io.Writer, fromHost bool) *initWizard {
+	return &initWizard{
+		in:       bufio.NewReader(in),
+		out:      out,
+		fromHost: fromHost,
+		opts:     NewRootOptions(),
+	}
+}
+
+// run asks the options of the build, returning the config file of it once valid.
+func (w *initWizard) run() (*wizardConfig, error) {
+	res, err := autodetect.Detect(autodetectRoot)
+	if err != nil && w.fromHost {
+		return nil, fmt.Errorf("unable to detect the local machine: %s", err)
+	}
+	if err == nil {
+		w.detected = res
+	} else {
+		w.detected = &autodetect.Result{}
+	}
+	ro := w.opts
+	ro.Architecture = runtime.GOARCH
+
+	var b builder.Builder
+	if ro.Target, err = w.ask("target", w.detected.Target.String(), func(answer string) (err error) {
+		b, err = builder.Factory(builder.Type(answer))
+		if err != nil {
+			return fmt.Errorf("%s, it must be one of %s", err, strings.Join(targetNames(), ", "))
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	m := builder.MetadataOf(b)
+	if ro.Architecture, err = w.ask("architecture", ro.Architecture, func(answer string) error {
+		if !containsKey(m.Architectures, answer) {
+			return fmt.Errorf("target %s does not support the %s architecture, only %s", ro.Target, answer, strings.Join(m.Architectures, ", "))
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if err := w.askKernel(b, m); err != nil {
+		return nil, err
+	}
+	if ro.DriverVersion, err = w.ask("driver version", ro.DriverVersion, func(answer string) error {
+		ro.DriverVersion = answer
+		return firstProblem(ro.fieldProblems("DriverVersion"))
+	}); err != nil {
+		return nil, err
+	}
+	if err := w.askOutputs(m); err != nil {
+		return nil, err
+	}
+
+	if problems := ro.problems(); len(problems) > 0 {
+		return nil, firstProblem(problems)
+	}
+	ic := &wizardConfig{
+		Target:               ro.Target,
+		Architecture:         ro.Architecture,
+		KernelRelease:        ro.KernelRelease,
+		KernelConfigDataFile: ro.KernelConfigDataFile,
+		DriverVersion:        ro.DriverVersion,
+	}
+	if m.RequiresKernelVersion || ro.KernelVersion != NewRootOptions().KernelVersion {
+		ic.KernelVersion = ro.KernelVersion
+	}
+	ic.Output.Module = ro.Output.Module
+	ic.Output.Probe = ro.Output.Probe
+	return ic, nil
+}
+
+// askKernel asks the kernel release, version and config of the build until the builder of the target accepts them.
+func (w *initWizard) askKernel(b builder.Builder, m builder.Metadata) error {
+	ro := w.opts
+	for {
+		var err error
+		if ro.KernelRelease, err = w.ask("kernel release, the output of 'uname -r'", w.detected.KernelRelease, func(answer string) error {
+			ro.KernelRelease = answer
+			return firstProblem(ro.fieldProblems("KernelRelease"))
+		}); err != nil {
+			return err
+		}
+		version := ro.KernelVersion
+		if len(w.detected.KernelVersion) > 0 {
+			version = w.detected.KernelVersion
+		}
+		if ro.KernelVersion, err = w.ask("kernel version, the numeric value after the hash of 'uname -v'", version, nil); err != nil {
+			return err
+		}
+		if m.RequiresKernelConfigData {
+			if err := w.askKernelConfig(); err != nil {
+				return err
+			}
+		}
+		build := ro.toBuild()
+		err = builder.Validate(b, builder.Config{DriverName: build.ModuleDriverName, DeviceName: build.ModuleDeviceName, Build: build}, build.KernelReleaseFromBuildConfig())
+		if err == nil {
+			return nil
+		}
+		if w.fromHost || w.eof {
+			return err
+		}
+		fmt.Fprintf(w.out, "  %s\n", err)
+	}
+}
+
+// askKernelConfig asks the file of the kernel config, the one detected being read already.
+func (w *initWizard) askKernelConfig() error {
+	ro := w.opts
+	var err error
+	ro.KernelConfigDataFile, err = w.ask("kernel config file", w.detected.KernelConfigFile, func(answer string) error {
+		ro.KernelConfigData = ""
+		if answer == w.detected.KernelConfigFile {
+			ro.KernelConfigData = w.detected.KernelConfigData
+			return nil
+		}
+		if answer == "-" {
+			return fmt.Errorf("the kernel config file cannot be the standard input, the answers are read from it")
+		}
+		ro.KernelConfigDataFile = answer
+		return ro.loadKernelConfigDataFile(nil)
+	})
+	return err
+}
+
+// askOutputs asks the paths of the drivers the target builds until they are valid, none skipping one.
+func (w *initWizard) askOutputs(m builder.Metadata) error {
+	ro := w.opts
+	for {
+		var err error
+		if m.Module {
+			if ro.Output.Module, err = w.ask("kernel module path, "+noOutput+" to skip it", "falco-"+ro.KernelRelease+".ko", nil); err != nil {
+				return err
+			}
+		}
+		if m.Probe {
+			if ro.Output.Probe, err = w.ask("eBPF probe path, "+noOutput+" to skip it", "falco-"+ro.KernelRelease+".o", nil); err != nil {
+				return err
+			}
+		}
+		for _, o := range []*string{&ro.Output.Module, &ro.Output.Probe} {
+			if *o == noOutput {
+				*o = ""
+			}
+		}
+		err = firstProblem(ro.fieldProblems("Output.Module", "Output.Probe"))
+		if err == nil {
+			return nil
+		}
+		if w.fromHost || w.eof {
+			return err
+		}
+		fmt.Fprintf(w.out, "  %s\n", err)
+	}
+}
+
+// ask prompts the question until the answer passes the check, the default being the empty answer.
+// Detecting the local machine, the default is the answer.
+func (w *initWizard) ask(question string, def string, check func(string) error) (string, error) {
+	for {
+		answer := def
+		if !w.fromHost {
+			if len(def) > 0 {
+				fmt.Fprintf(w.out, "%s [%s]: ", question, def)
+			} else {
+				fmt.Fprintf(w.out, "%s: ", question)
+			}
+			line, err := w.in.ReadString('\n')
+			if err == io.EOF {
+				w.eof = true
+			} else if err != nil {
+				return "", err
+			}
+			if line = strings.TrimSpace(line); len(line) > 0 {
+				answer = line
+			}
+		}
+		if len(answer) == 0 {
+			if w.fromHost || w.eof {
+				return "", fmt.Errorf("no %s given", question)
+			}
+			fmt.Fprintf(w.out, "  an answer is required\n")
+			continue
+		}
+		if check == nil {
+			return answer, nil
+		}
+		err := check(answer)
+		if err == nil {
+			return answer, nil
+		}
+		if w.fromHost || w.eof {
+			return "", err
+		}
+		fmt.Fprintf(w.out, "  %s\n", err)
+	}
+}
+
+// command returns the command equivalent to the config file.
+func (ic *wizardConfig) command() string {
+	args := []string{"driverkit", "docker", "--target", ic.Target, "--architecture", ic.Architecture, "--kernelrelease", ic.KernelRelease}
+	if len(ic.KernelVersion) > 0 {
+		args = append(args, "--kernelversion", ic.KernelVersion)
+	}
+	if len(ic.KernelConfigDataFile) > 0 {
+		args = append(args, "--kernelconfigdata-file", ic.KernelConfigDataFile)
+	}
+	args = append(args, "--driverversion", ic.DriverVersion)
+	if len(ic.Output.Module) > 0 {
+		args = append(args, "--output-module", ic.Output.Module)
+	}
+	if len(ic.Output.Probe) > 0 {
+		args = append(args, "--output-probe", ic.Output.Probe)
+	}
+	for i, a := range args {
+		args[i] = commandArg(a)
+	}
+	return strings.Join(args, " ")
+}
+
+// commandArg quotes the argument for the shell when needed.
+func commandArg(arg string) string {
+	if safeCommandArg.MatchString(arg) {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// targetNames returns the names of the registered targets, sorted.
+func targetNames() []string {
+	names := []string{}
+	for t := range builder.BuilderByTarget {
+		names = append(names, t.String())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func firstProblem(problems []error) error {
+	if len(problems) > 0 {
+		return problems[0]
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func withAutodetectRoot(t *testing.T, files map[string]string) func() {
+	root := t.TempDir()
+	for name, content := range files {
+		name = filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := autodetectRoot
+	autodetectRoot = root
+	return func() { autodetectRoot = old }
+}
+
+func runInit(t *testing.T, stdin string, args ...string) (string, string, error) {
+	c := NewRootCmd().c
+	out, prompts := &bytes.Buffer{}, &bytes.Buffer{}
+	c.SetIn(strings.NewReader(stdin))
+	c.SetOut(out)
+	c.SetErr(prompts)
+	c.SetArgs(append([]string{"init"}, args...))
+	err := c.Execute()
+	return out.String(), prompts.String(), err
+}
+
+func TestInitFromHost(t *testing.T) {
+	defer withAutodetectRoot(t, map[string]string{
+		"etc/os-release":            "ID=ubuntu\n",
+		"proc/sys/kernel/osrelease": "5.15.0-25-generic\n",
+		"proc/sys/kernel/version":   "#25-Ubuntu SMP\n",
+	})()
+	out, _, err := runInit(t, "", "--from-host", "--print")
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	want := fmt.Sprintf(`target: ubuntu
+architecture: %[1]s
+kernelrelease: 5.15.0-25-generic
+kernelversion: "25"
+driverversion: master
+output:
+  module: falco-5.15.0-25-generic.ko
+  probe: falco-5.15.0-25-generic.o
+# driverkit docker --target ubuntu --architecture %[1]s --kernelrelease 5.15.0-25-generic --kernelversion 25 --driverversion master --output-module falco-5.15.0-25-generic.ko --output-probe falco-5.15.0-25-generic.o
+`, runtime.GOARCH)
+	if out != want {
+		t.Errorf("Got: [ %s ] / Want: [ %s ]", out, want)
+	}
+}
+
+func TestInitFromHostInvalid(t *testing.T) {
+	defer withAutodetectRoot(t, map[string]string{
+		"etc/os-release":            "ID=ubuntu\n",
+		"proc/sys/kernel/osrelease": "5.15.0\n",
+		"proc/sys/kernel/version":   "#25-Ubuntu SMP\n",
+	})()
+	if _, _, err := runInit(t, "", "--from-host", "--print"); err == nil {
+		t.Errorf("Expecting an error")
+	}
+}
+
+func TestInitInteractive(t *testing.T) {
+	defer withAutodetectRoot(t, map[string]string{})()
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config")
+	if err := ioutil.WriteFile(config, []byte("CONFIG_X=y\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "driverkit.yaml")
+	answers := []string{
+		"nope",             // target, not registered
+		"vanilla",          // target
+		"",                 // architecture, the default one
+		"5.10.0",           // kernel release
+		"",                 // kernel version, the default one
+		"/non/existent",    // kernel config file, not found
+		config,             // kernel config file
+		"not a version!",   // driver version, invalid
+		"2.0.0+driver",     // driver version
+		"falco.o",          // kernel module path, invalid
+		"none",             // eBPF probe path, skipped
+		"falco-vanilla.ko", // kernel module path
+		"none",             // eBPF probe path, skipped
+	}
+	out, prompts, err := runInit(t, strings.Join(answers, "\n")+"\n", "-o", output)
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	for _, p := range []string{"no builder found for target: nope", "/non/existent", "'driver version' failed", "must end with .ko"} {
+		if !strings.Contains(prompts, p) {
+			t.Errorf("Test Input: '%s' | Got: [ %s ] / Want: [ the invalid answer reported ]", p, prompts)
+		}
+	}
+	data, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	want := fmt.Sprintf(`target: vanilla
+architecture: %s
+kernelrelease: 5.10.0
+kernelconfigdata-file: %s
+driverversion: 2.0.0+driver
+output:
+  module: falco-vanilla.ko
+`, runtime.GOARCH, config)
+	if string(data) != want {
+		t.Errorf("Got: [ %s ] / Want: [ %s ]", data, want)
+	}
+	if !strings.Contains(out, "--kernelconfigdata-file "+config) || !strings.Contains(out, "driverkit docker -c "+output) {
+		t.Errorf("Got: [ %s ] / Want: [ the commands building with the config ]", out)
+	}
+
+	validateCmd := NewRootCmd().c
+	validateCmd.SetOut(&bytes.Buffer{})
+	validateCmd.SetArgs([]string{"validate", "-c", output})
+	if err := validateCmd.Execute(); err != nil {
+		t.Errorf("Got: [ %s ] / Want: [ a valid config file ]", err)
+	}
+
+	if _, _, err := runInit(t, strings.Join(answers, "\n")+"\n", "-o", output); err == nil {
+		t.Errorf("Got: [ %s overwritten ] / Want: [ an error without --force ]", output)
+	}
+}
+
+func TestInitNoAnswers(t *testing.T) {
+	defer withAutodetectRoot(t, map[string]string{})()
+	if _, _, err := runInit(t, "vanilla\n", "--print"); err == nil {
+		t.Errorf("Expecting an error")
+	}
+}
@@ -0,0 +1,71 @@
+//go:build !windows
+// +build !windows
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/spf13/viper"
+)
+
+// blockingProcessor runs the builds until they are canceled, then cleans them up.
+type blockingProcessor struct {
+	started chan struct{}
+	cleaned bool
+}
+
+func (bp *blockingProcessor) String() string {
+	return "blocking"
+}
+
+func (bp *blockingProcessor) Start(ctx context.Context, b *builder.Build) (*driverbuilder.BuildReport, error) {
+	close(bp.started)
+	<-ctx.Done()
+	bp.cleaned = true
+	return nil, ctx.Err()
+}
+
+func TestRunBuildInterrupted(t *testing.T) {
+	timeout := viper.Get("timeout")
+	viper.Set("timeout", 60)
+	defer viper.Set("timeout", timeout)
+	b := &builder.Build{
+		TargetType:       builder.TargetTypeVanilla,
+		KernelRelease:    "5.10.0",
+		KernelVersion:    "1",
+		DriverVersion:    "master",
+		Architecture:     "amd64",
+		KernelConfigData: "Q09ORklHX0JQRj15Cg==", // CONFIG_BPF=y
+		ModuleFilePath:   filepath.Join(t.TempDir(), "falco.ko"),
+		ModuleDriverName: "falco",
+		ModuleDeviceName: "falco",
+	}
+
+	bp := &blockingProcessor{started: make(chan struct{})}
+	go func() {
+		<-bp.started
+		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
+	}()
+	err := runBuild(bp, b)
+	if !errors.Is(err, errInterrupted) {
+		t.Fatalf("Got: [ %v ] / Want: [ %v ]", err, errInterrupted)
+	}
+	if want := "interrupted by interrupt: context canceled"; err.Error() != want {
+		t.Errorf("Got: [ '%s' ] / Want: [ '%s' ]", err, want)
+	}
+	if !bp.cleaned {
+		t.Errorf("Got: [ not cleaned up ] / Want: [ the build cleaned up before returning ]")
+	}
+
+	// the builds failing on their own are not interrupted ones
+	if err := interrupted(context.Background(), errors.New("build failed")); errors.Is(err, errInterrupted) {
+		t.Errorf("Got: [ %v ] / Want: [ not interrupted ]", err)
+	}
+}
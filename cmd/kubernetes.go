@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"encoding/json"
+	"fmt"
 	"regexp"
 	"strings"
 
@@ -10,6 +12,8 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 )
 
@@ -21,29 +25,16 @@ func NewKubernetesCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Co
 		Aliases: []string{"k8s"},
 	}
 
-	// Add Kubernetes client flags
-	configFlags := genericclioptions.NewConfigFlags(false)
-	configFlags.AddFlags(kubernetesCmd.PersistentFlags())
-	// Some styling to make Kubernetes client flags look like they were ours
-	dotEndingRegexp := regexp.MustCompile(`\.$`)
-	upperAfterPointRegexp := regexp.MustCompile(`\. ([A-Z0-9])`)
-	upperAfterCommaRegexp := regexp.MustCompile(`, ([A-Z0-9])`)
-	kubernetesCmd.PersistentFlags().VisitAll(func(f *pflag.Flag) {
-		f.Usage = strings.ToLower(f.Usage[:1]) + f.Usage[1:]
-		f.Usage = dotEndingRegexp.ReplaceAllString(f.Usage, "")
-		f.Usage = upperAfterPointRegexp.ReplaceAllString(f.Usage, ", ${1}")
-		f.Usage = upperAfterCommaRegexp.ReplaceAllStringFunc(f.Usage, strings.ToLower)
-	})
+	kubefactory := addKubernetesFlags(kubernetesCmd.PersistentFlags())
+	kubernetesCmd.PersistentFlags().String("registry-config", "", "docker config file, e.g. a mounted secret when running in-cluster, turned into a pull secret of the build pod")
 	// Add root flags
 	kubernetesCmd.PersistentFlags().AddFlagSet(rootFlags)
 
-	kubefactory := factory.NewFactory(configFlags)
-
 	kubernetesCmd.Run = func(cmd *cobra.Command, args []string) {
 		logger.WithField("processor", cmd.Name()).Info("driver building, it will take a few seconds")
 		if !configOptions.DryRun {
 			if err := kubernetesRun(cmd, args, kubefactory, rootOpts); err != nil {
-				logger.WithError(err).Fatal("exiting")
+				fatalBuild(err)
 			}
 		}
 	}
@@ -51,31 +42,251 @@ func NewKubernetesCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Co
 	return kubernetesCmd
 }
 
-func kubernetesRun(cmd *cobra.Command, args []string, kubefactory factory.Factory, rootOpts *RootOptions) error {
-	f := cmd.Flags()
-	b := rootOpts.toBuild()
+// addKubernetesFlags adds the flags of the Kubernetes client and of the build pod, but the registry config,
+// returning the factory of the clients they configure.
+func addKubernetesFlags(flags *pflag.FlagSet) factory.Factory {
+	// Add Kubernetes client flags
+	configFlags := genericclioptions.NewConfigFlags(false)
+	clientFlags := pflag.NewFlagSet("kubernetes", pflag.ContinueOnError)
+	configFlags.AddFlags(clientFlags)
+	// Some styling to make Kubernetes client flags look like they were ours
+	dotEndingRegexp := regexp.MustCompile(`\.$`)
+	upperAfterPointRegexp := regexp.MustCompile(`\. ([A-Z0-9])`)
+	upperAfterCommaRegexp := regexp.MustCompile(`, ([A-Z0-9])`)
+	clientFlags.VisitAll(func(f *pflag.Flag) {
+		f.Usage = strings.ToLower(f.Usage[:1]) + f.Usage[1:]
+		f.Usage = dotEndingRegexp.ReplaceAllString(f.Usage, "")
+		f.Usage = upperAfterPointRegexp.ReplaceAllString(f.Usage, ", ${1}")
+		f.Usage = upperAfterCommaRegexp.ReplaceAllStringFunc(f.Usage, strings.ToLower)
+	})
+	flags.AddFlagSet(clientFlags)
+	// Add build pod flags
+	defaults := driverbuilder.DefaultKubernetesPodOptions()
+	flags.String("cpu-request", defaults.Resources.Requests.Cpu().String(), "CPU requested by the build pod, empty to not request it")
+	flags.String("memory-request", defaults.Resources.Requests.Memory().String(), "memory requested by the build pod, empty to not request it")
+	flags.String("cpu-limit", defaults.Resources.Limits.Cpu().String(), "CPU limit of the build pod, empty to not limit it")
+	flags.String("memory-limit", defaults.Resources.Limits.Memory().String(), "memory limit of the build pod, empty to not limit it")
+	flags.StringToString("node-selector", nil, "labels the nodes the build pod runs on must have, the kubernetes.io/arch one defaults to the target architecture (e.g. --node-selector pool=builds)")
+	flags.StringArray("toleration", nil, "taint tolerated by the build pod, as key[=value][:effect] (e.g. --toleration dedicated=builds:NoSchedule)")
+	flags.String("affinity", "", "affinity of the build pod, as JSON")
+	flags.String("priority-class-name", "", "priority class of the build pod")
+	flags.StringArray("image-pull-secret", nil, "secret the builder image is pulled with, can be repeated")
+	flags.String("image-pull-policy", string(defaults.ImagePullPolicy), "pull policy of the builder image, one of Always, IfNotPresent or Never")
+	flags.String("service-account", "", "service account the build pod runs as")
+	flags.String("pod-security-context", "", "security context of the build pod, as JSON (e.g. --pod-security-context '{\"seccompProfile\": {\"type\": \"RuntimeDefault\"}}')")
+	flags.String("security-context", "", "security context of the build container, as JSON, the build script must still run as root with the CHOWN, DAC_OVERRIDE and FOWNER capabilities")
+	flags.Bool("keep-failed-pod", false, "do not delete the build pod when the build fails, it keeps running until the timeout elapses to exec into it")
+	flags.Bool("bare-pod", false, "run the build pod on its own rather than through a job, needing no permissions on the jobs")
+	flags.Int32("backoff-limit", defaults.BackoffLimit, "times the job retries the build pod when it fails, e.g. when evicted")
+	flags.Duration("ttl-after-finished", defaults.TTLAfterFinished, "time after which the cluster deletes the finished build jobs driverkit could not delete, 0 to keep them")
+	flags.String("artifact-transfer", defaults.ArtifactTransfer, "how the kernel module is transferred out of the build pod, one of exec, pvc or s3, the last two need no exec into the pod")
+	flags.String("artifact-pvc", "", "persistent volume claim the build pod copies the kernel module into with --artifact-transfer pvc, read back by a short-lived pod")
+	flags.String("artifact-s3-url", "", "s3://bucket[/prefix] URL the build pod uploads the kernel module under with --artifact-transfer s3, downloaded then deleted, against --s3-endpoint if any")
+	flags.Duration("orphan-jobs-age", defaults.OrphanJobsAge, "age of the build jobs left over by the previous runs deleted before the first build, 0 to keep them")
+	return factory.NewFactory(configFlags)
+}
 
-	namespaceStr, err := f.GetString("namespace")
+func kubernetesRun(cmd *cobra.Command, args []string, kubefactory factory.Factory, rootOpts *RootOptions) error {
+	buildProcessor, err := newKubernetesProcessor(cmd.Flags(), kubefactory)
 	if err != nil {
 		return err
 	}
-	if len(namespaceStr) == 0 {
-		namespaceStr = "default"
+	return runBuild(buildProcessor, rootOpts.toBuild())
+}
+
+// newKubernetesProcessor returns the processor building against the cluster of the flags, with the build pod they configure.
+func newKubernetesProcessor(f *pflag.FlagSet, kubefactory factory.Factory) (*driverbuilder.KubernetesBuildProcessor, error) {
+	// the namespace of the kubeconfig context, or of the service account when running in-cluster, unless given
+	namespaceStr, _, err := kubefactory.ToRawKubeConfigLoader().Namespace()
+	if err != nil {
+		return nil, err
 	}
 
 	kc, err := kubefactory.KubernetesClientSet()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	clientConfig, err := kubefactory.ToRESTConfig()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if err := factory.SetKubernetesDefaults(clientConfig); err != nil {
-		return err
+		return nil, err
+	}
+
+	podOptions, err := kubernetesPodOptions(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return driverbuilder.NewKubernetesBuildProcessor(kc, clientConfig, namespaceStr, viper.GetInt("timeout"), viper.GetString("proxy"), caCert(), podOptions), nil
+}
+
+// kubernetesPodOptions reads the resources and the placement of the build pod from the flags.
+func kubernetesPodOptions(f *pflag.FlagSet) (driverbuilder.KubernetesPodOptions, error) {
+	opts := driverbuilder.KubernetesPodOptions{
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{},
+			Limits:   corev1.ResourceList{},
+		},
+	}
+	resources := []struct {
+		flag string
+		list corev1.ResourceList
+		name corev1.ResourceName
+	}{
+		{"cpu-request", opts.Resources.Requests, corev1.ResourceCPU},
+		{"memory-request", opts.Resources.Requests, corev1.ResourceMemory},
+		{"cpu-limit", opts.Resources.Limits, corev1.ResourceCPU},
+		{"memory-limit", opts.Resources.Limits, corev1.ResourceMemory},
+	}
+	for _, r := range resources {
+		value, err := f.GetString(r.flag)
+		if err != nil {
+			return opts, err
+		}
+		if value == "" {
+			continue
+		}
+		q, err := resource.ParseQuantity(value)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --%s: %s", r.flag, err)
+		}
+		r.list[r.name] = q
 	}
 
-	buildProcessor := driverbuilder.NewKubernetesBuildProcessor(kc.CoreV1(), clientConfig, namespaceStr, viper.GetInt("timeout"), viper.GetString("proxy"))
+	var err error
+	if opts.NodeSelector, err = f.GetStringToString("node-selector"); err != nil {
+		return opts, err
+	}
+	tolerations, err := f.GetStringArray("toleration")
+	if err != nil {
+		return opts, err
+	}
+	for _, t := range tolerations {
+		toleration, err := parseToleration(t)
+		if err != nil {
+			return opts, err
+		}
+		opts.Tolerations = append(opts.Tolerations, toleration)
+	}
+	affinity, err := f.GetString("affinity")
+	if err != nil {
+		return opts, err
+	}
+	if affinity != "" {
+		opts.Affinity = &corev1.Affinity{}
+		if err := json.Unmarshal([]byte(affinity), opts.Affinity); err != nil {
+			return opts, fmt.Errorf("invalid --affinity: %s", err)
+		}
+	}
+	if opts.PriorityClassName, err = f.GetString("priority-class-name"); err != nil {
+		return opts, err
+	}
+	if opts.ImagePullSecrets, err = f.GetStringArray("image-pull-secret"); err != nil {
+		return opts, err
+	}
+	pullPolicy, err := f.GetString("image-pull-policy")
+	if err != nil {
+		return opts, err
+	}
+	opts.ImagePullPolicy = corev1.PullPolicy(pullPolicy)
+	switch opts.ImagePullPolicy {
+	case corev1.PullAlways, corev1.PullIfNotPresent, corev1.PullNever:
+	default:
+		return opts, fmt.Errorf("invalid --image-pull-policy: %s", pullPolicy)
+	}
+	if opts.RegistryConfig, err = f.GetString("registry-config"); err != nil {
+		return opts, err
+	}
+	if opts.VerboseLogs, err = f.GetBool("verbose"); err != nil {
+		return opts, err
+	}
+	if opts.KeepFailedPod, err = f.GetBool("keep-failed-pod"); err != nil {
+		return opts, err
+	}
+	if opts.BarePod, err = f.GetBool("bare-pod"); err != nil {
+		return opts, err
+	}
+	if opts.BackoffLimit, err = f.GetInt32("backoff-limit"); err != nil {
+		return opts, err
+	}
+	if opts.BackoffLimit < 0 {
+		return opts, fmt.Errorf("invalid --backoff-limit: %d", opts.BackoffLimit)
+	}
+	if opts.TTLAfterFinished, err = f.GetDuration("ttl-after-finished"); err != nil {
+		return opts, err
+	}
+	if opts.OrphanJobsAge, err = f.GetDuration("orphan-jobs-age"); err != nil {
+		return opts, err
+	}
+	if opts.ArtifactTransfer, err = f.GetString("artifact-transfer"); err != nil {
+		return opts, err
+	}
+	switch opts.ArtifactTransfer {
+	case driverbuilder.ArtifactTransferExec, driverbuilder.ArtifactTransferPVC, driverbuilder.ArtifactTransferS3:
+	default:
+		return opts, fmt.Errorf("invalid --artifact-transfer: %s", opts.ArtifactTransfer)
+	}
+	if opts.ArtifactPVC, err = f.GetString("artifact-pvc"); err != nil {
+		return opts, err
+	}
+	if opts.ArtifactTransfer == driverbuilder.ArtifactTransferPVC && len(opts.ArtifactPVC) == 0 {
+		return opts, fmt.Errorf("--artifact-transfer pvc needs --artifact-pvc")
+	}
+	if opts.ArtifactS3URL, err = f.GetString("artifact-s3-url"); err != nil {
+		return opts, err
+	}
+	if opts.ArtifactTransfer == driverbuilder.ArtifactTransferS3 && len(opts.ArtifactS3URL) == 0 {
+		return opts, fmt.Errorf("--artifact-transfer s3 needs --artifact-s3-url")
+	}
+	if opts.ServiceAccount, err = f.GetString("service-account"); err != nil {
+		return opts, err
+	}
+	podSecurityContext, err := f.GetString("pod-security-context")
+	if err != nil {
+		return opts, err
+	}
+	if podSecurityContext != "" {
+		opts.PodSecurityContext = &corev1.PodSecurityContext{}
+		if err := json.Unmarshal([]byte(podSecurityContext), opts.PodSecurityContext); err != nil {
+			return opts, fmt.Errorf("invalid --pod-security-context: %s", err)
+		}
+	}
+	securityContext, err := f.GetString("security-context")
+	if err != nil {
+		return opts, err
+	}
+	if securityContext != "" {
+		opts.SecurityContext = &corev1.SecurityContext{}
+		if err := json.Unmarshal([]byte(securityContext), opts.SecurityContext); err != nil {
+			return opts, fmt.Errorf("invalid --security-context: %s", err)
+		}
+	}
+	return opts, nil
+}
 
-	return buildProcessor.Start(b)
+// parseToleration parses a toleration in the key[=value][:effect] form of the taints,
+// a key without value tolerates the taints having the key whatever their value is.
+func parseToleration(s string) (corev1.Toleration, error) {
+	t := corev1.Toleration{Operator: corev1.TolerationOpExists}
+	if i := strings.LastIndex(s, ":"); i >= 0 {
+		t.Effect = corev1.TaintEffect(s[i+1:])
+		s = s[:i]
+		switch t.Effect {
+		case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+		default:
+			return t, fmt.Errorf("invalid toleration effect: %s", t.Effect)
+		}
+	}
+	if i := strings.Index(s, "="); i >= 0 {
+		t.Operator = corev1.TolerationOpEqual
+		t.Value = s[i+1:]
+		s = s[:i]
+	}
+	if s == "" {
+		return t, fmt.Errorf("invalid toleration, the key is missing")
+	}
+	t.Key = s
+	return t, nil
 }
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// NewLocalCmd creates the `driverkit local` command.
+func NewLocalCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Command {
+	localCmd := &cobra.Command{
+		Use:   "local",
+		Short: "Build Falco kernel modules and eBPF probes directly on this host, using its toolchain.",
+	}
+
+	localCmd.PersistentFlags().Bool("allow-root", false, "allow running the build script as root")
+	// Add root flags
+	localCmd.PersistentFlags().AddFlagSet(rootFlags)
+
+	localCmd.Run = func(cmd *cobra.Command, args []string) {
+		logger.WithField("processor", cmd.Name()).Info("driver building, it will take a few seconds")
+		if !configOptions.DryRun {
+			if err := localRun(cmd, rootOpts); err != nil {
+				fatalBuild(err)
+			}
+		}
+	}
+
+	return localCmd
+}
+
+func localRun(cmd *cobra.Command, rootOpts *RootOptions) error {
+	allowRoot, err := cmd.Flags().GetBool("allow-root")
+	if err != nil {
+		return err
+	}
+
+	// the variables given with --env are part of the build, exported by the build script
+	buildProcessor := driverbuilder.NewLocalBuildProcessor(viper.GetInt("timeout"), viper.GetString("proxy"), caCert(), nil, allowRoot)
+
+	return runBuild(buildProcessor, rootOpts.toBuild())
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// NewMirrorsCmd creates the `driverkit mirrors` command.
+func NewMirrorsCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Command {
+	mirrorsCmd := &cobra.Command{
+		Use:         "mirrors",
+		Short:       "List the mirrors the targets look for the kernel packages into, the ones given with --mirror included, in order.",
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{skipRootValidation: "true"},
+		// The problems of the mirrors given are the output, the usage would only bury them
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			build := rootOpts.toBuild()
+			if problems := driverbuilder.MirrorsProblems(build); len(problems) > 0 {
+				return firstProblem(problems)
+			}
+			targets := targetNames()
+			if len(rootOpts.Target) > 0 {
+				b, err := builder.Factory(builder.Type(rootOpts.Target))
+				if err != nil {
+					return err
+				}
+				if _, ok := b.(builder.MirrorsProvider); !ok {
+					return fmt.Errorf("target %s has no mirrors, it does not look for the kernel packages into a list of them", rootOpts.Target)
+				}
+				targets = []string{rootOpts.Target}
+			}
+			return writeMirrors(c.OutOrStdout(), build, targets)
+		},
+	}
+	mirrorsCmd.PersistentFlags().AddFlagSet(rootFlags)
+	return mirrorsCmd
+}
+
+// writeMirrors prints the mirrors of the targets for the build, one per line, the targets having none being left out.
+func writeMirrors(w io.Writer, build *builder.Build, targets []string) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "TARGET\tMIRROR")
+	for _, t := range targets {
+		b, err := builder.Factory(builder.Type(t))
+		if err != nil {
+			return err
+		}
+		targetBuild := *build
+		targetBuild.TargetType = builder.Type(t)
+		mirrors, ok := builder.Mirrors(b, &targetBuild)
+		if !ok {
+			continue
+		}
+		for _, m := range mirrors {
+			fmt.Fprintf(tw, "%s\t%s\n", t, m)
+		}
+	}
+	return tw.Flush()
+}
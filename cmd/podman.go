@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// NewPodmanCmd creates the `driverkit podman` command.
+func NewPodmanCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Command {
+	podmanCmd := &cobra.Command{
+		Use:   "podman",
+		Short: "Build Falco kernel modules and eBPF probes against a podman service, rootless or remote through CONTAINER_HOST.",
+		Run: func(c *cobra.Command, args []string) {
+			logger.WithField("processor", c.Name()).Info("driver building, it will take a few seconds")
+			if !configOptions.DryRun {
+				processor := driverbuilder.NewPodmanBuildProcessor(viper.GetInt("timeout"), viper.GetString("proxy"), caCert(), registryCredentials(c.Flags()))
+				if err := runBuild(processor, rootOpts.toBuild()); err != nil {
+					fatalBuild(err)
+				}
+			}
+		},
+	}
+	addRegistryFlags(podmanCmd.PersistentFlags())
+	// Add root flags
+	podmanCmd.PersistentFlags().AddFlagSet(rootFlags)
+
+	return podmanCmd
+}
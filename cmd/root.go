@@ -1,14 +1,18 @@
 package cmd
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"runtime"
 	"sort"
 	"strings"
 
 	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 	"github.com/falcosecurity/driverkit/pkg/version"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -16,6 +20,7 @@ import (
 	homedir "github.com/mitchellh/go-homedir"
 	logger "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
 )
 
 func persistentValidateFunc(rootCommand *RootCmd, rootOpts *RootOptions) func(c *cobra.Command, args []string) error {
@@ -26,16 +31,27 @@ func persistentValidateFunc(rootCommand *RootCmd, rootOpts *RootOptions) func(c
 		}
 		// Merge environment variables or config file values into the RootOptions instance
 		skip := map[string]bool{ // do not merge these
-			"config":   true,
-			"timeout":  true,
-			"loglevel": true,
-			"dryrun":   true,
-			"proxy":    true,
+			"config":        true,
+			"timeout":       true,
+			"loglevel":      true,
+			"log-format":    true,
+			"dryrun":        true,
+			"dry-run":       true,
+			"script-out":    true,
+			"proxy":         true,
+			"ca-cert":       true,
+			"report-file":   true,
+			"report-format": true,
+			"metrics-addr":  true,
+			"verbose":       true,
+			"skip-existing": true,
 		}
-		nested := map[string]string{ // handle nested options in config file
-			"output-module": "output.module",
-			"output-probe":  "output.probe",
+		// the merge marks every flag as changed, the options given explicitly are the ones set before it
+		given := map[string]bool{}
+		for _, name := range autodetectedFlags {
+			given[name] = viper.IsSet(name)
 		}
+		given["moduledevicename"] = viper.IsSet("moduledevicename")
 		rootCommand.c.Flags().VisitAll(func(f *pflag.Flag) {
 		    if name := f.Name; !skip[name] {
                 if name == "kernelurls" {
@@ -51,11 +67,19 @@ func persistentValidateFunc(rootCommand *RootCmd, rootOpts *RootOptions) func(c
                         strValue := strings.Join(value, ",")
                         rootCommand.c.Flags().Set(name, strValue)
                     }
+                } else if name == "env" || name == "kernel-config-fragment" || name == "mirror" {
+                    // Each Set appends a variable, with none given on the CLI they come from the config
+                    if cli_env, err := rootCommand.c.Flags().GetStringArray(name); err == nil && len(cli_env) != 0 {
+                       return
+                    }
+                    for _, value := range viper.GetStringSlice(name) {
+                        rootCommand.c.Flags().Set(name, value)
+                    }
                 } else {
                     value := viper.GetString(name)
                     if value == "" {
                         // fallback to nested options in config file, if any
-                        if nestedName, ok := nested[name]; ok {
+                        if nestedName, ok := nestedConfigKeys[name]; ok {
                             value = viper.GetString(nestedName)
                         }
                     }
@@ -67,11 +91,49 @@ func persistentValidateFunc(rootCommand *RootCmd, rootOpts *RootOptions) func(c
             }
 		})
 
+		// The devices are named after the kernel module, unless their name is given too
+		if !given["moduledevicename"] {
+			rootOpts.ModuleDeviceName = rootOpts.ModuleDriverName
+		}
+
 		// Avoid sensitive info into default values help line
 		rootCommand.StripSensitive()
 
-		// Do not block root or help command to exec disregarding the root flags validity
-		if c.Root() != c && c.Name() != "help" && c.Name() != "__complete" && c.Name() != "__completeNoDesc" && c.Name() != "completion" {
+		if cf := configOptions.configFile; cf != nil {
+			buildKeys := configKeys(rootCommand.c.Flags())
+			if cf.batch() {
+				buildKeys = batchKeys
+			}
+			for _, u := range cf.unknownKeys(configKeys(rootCommand.c.Flags()), buildKeys) {
+				logger.
+					WithField("file", viper.ConfigFileUsed()).
+					WithField("document", u.Document).
+					WithField("key", u.Key).
+					WithField("nearest", u.Nearest).
+					Warn("unknown config key, it is ignored")
+			}
+		}
+
+		if err := rootOpts.loadKernelConfigDataFile(c.InOrStdin()); err != nil {
+			logger.WithError(err).Error("error reading the kernel config data file")
+			return fmt.Errorf("exiting for validation errors")
+		}
+		given["kernelconfigdata"] = given["kernelconfigdata"] || len(rootOpts.KernelConfigDataFile) > 0
+
+		if rootOpts.Autodetect {
+			if err := detectLocalMachine(given, rootOpts); err != nil {
+				logger.WithError(err).Error("error detecting the local machine")
+				return fmt.Errorf("exiting for autodetection errors")
+			}
+		}
+
+		// Do not block root, help and the annotated commands to exec disregarding the root flags validity, nor the cleanup and the batch builds
+		cleanup, _ := c.Flags().GetBool("cleanup")
+		if c.Root() != c && !skipsRootValidation(c) && !cleanup && !batchMode(c.Flags()) {
+			if configOptions.configFile.batch() {
+				logger.WithField("file", viper.ConfigFileUsed()).Error("the config file has several builds, they run as a batch with the docker processor only")
+				return fmt.Errorf("exiting for validation errors")
+			}
 			if errs := rootOpts.Validate(); errs != nil {
 				for _, err := range errs {
 					logger.WithError(err).Error("error validating build options")
@@ -90,6 +152,19 @@ type RootCmd struct {
 }
 
 // NewRootCmd instantiates the root command.
+// skipRootValidation annotates the commands that do not build with the root flags, e.g. listing the targets or validating the config file.
+const skipRootValidation = "skipRootValidation"
+
+// skipsRootValidation tells whether the command runs disregarding the root flags validity.
+func skipsRootValidation(c *cobra.Command) bool {
+	switch c.Name() {
+	case "help", cobra.ShellCompRequestCmd, cobra.ShellCompNoDescRequestCmd:
+		return true
+	}
+	_, ok := c.Annotations[skipRootValidation]
+	return ok
+}
+
 func NewRootCmd() *RootCmd {
 	configOptions = NewConfigOptions()
 	rootOpts := NewRootOptions()
@@ -120,25 +195,83 @@ func NewRootCmd() *RootCmd {
 
 	flags.StringVarP(&configOptions.ConfigFile, "config", "c", configOptions.ConfigFile, "config file path (default $HOME/.driverkit.yaml if exists)")
 	flags.StringVarP(&configOptions.LogLevel, "loglevel", "l", configOptions.LogLevel, "log level")
+	flags.StringVar(&configOptions.LogFormat, "log-format", configOptions.LogFormat, "log format, text or json")
 	flags.IntVar(&configOptions.Timeout, "timeout", configOptions.Timeout, "timeout in seconds")
 	flags.BoolVar(&configOptions.DryRun, "dryrun", configOptions.DryRun, "do not actually perform the action")
+	flags.BoolVar(&configOptions.ValidateOnly, "dry-run", configOptions.ValidateOnly, "validate the build and resolve the kernel packages it would use, then exit without building")
+	flags.StringVar(&configOptions.ScriptOut, "script-out", configOptions.ScriptOut, "file where to write the script the build would run with --dry-run, for the docker, podman and kubernetes processors (default the standard output)")
 	flags.StringVar(&configOptions.ProxyURL, "proxy", configOptions.ProxyURL, "the proxy to use to download data")
+	flags.StringVar(&configOptions.CACert, "ca-cert", configOptions.CACert, "PEM encoded CA bundle to trust when downloading data, it can also be provided with the "+caCertEnv+" environment variable")
+	flags.StringVar(&configOptions.ReportFile, "report-file", configOptions.ReportFile, "file where to write the report of the build, with the kernel packages used and the checksums of the artifacts")
+	flags.StringVar(&configOptions.ReportFormat, "report-format", configOptions.ReportFormat, "format of the report file, json or yaml")
+	flags.StringVar(&configOptions.MetricsAddr, "metrics-addr", configOptions.MetricsAddr, "address where to expose the Prometheus metrics of the builds on /metrics while running, e.g. :9090")
+	flags.BoolVar(&configOptions.SkipExisting, "skip-existing", configOptions.SkipExisting, "skip the builds whose artifacts exist already, matching their checksum files if any, or whose s3 objects and oci artifact exist when published, reporting them as cached")
+	flags.BoolVar(&configOptions.Verbose, "verbose", configOptions.Verbose, "log the timings of the builds once done, and forward the logs of the kubernetes build pod at info level rather than at debug level")
 
 	flags.StringVar(&rootOpts.Output.Module, "output-module", rootOpts.Output.Module, "filepath where to save the resulting kernel module")
 	flags.StringVar(&rootOpts.Output.Probe, "output-probe", rootOpts.Output.Probe, "filepath where to save the resulting eBPF probe")
+	flags.StringVar(&rootOpts.Output.ModernProbe, "output-modern-probe", rootOpts.Output.ModernProbe, "filepath where to save the skeleton of the resulting modern eBPF probe, it requires a kernel with BTF")
+	flags.StringVar(&rootOpts.Output.DKMS, "output-dkms", rootOpts.Output.DKMS, "filepath where to save the DKMS source package of the resulting kernel module, a .tar.gz, for the nodes to rebuild it on their kernel upgrades")
+	flags.StringVar(&rootOpts.Output.BTF, "output-btf", rootOpts.Output.BTF, "filepath where to save the raw BTF of the kernel, generated from its debug package, skipped with a warning when the package is not found")
+	flags.StringVar(&rootOpts.Output.ModuleS3, "output-module-s3", rootOpts.Output.ModuleS3, "s3://bucket/key where to upload the resulting kernel module, the key can use the build details, e.g. {{ .KernelRelease }}")
+	flags.StringVar(&rootOpts.Output.ProbeS3, "output-probe-s3", rootOpts.Output.ProbeS3, "s3://bucket/key where to upload the resulting eBPF probe, the key can use the build details, e.g. {{ .KernelRelease }}")
+	flags.StringVar(&rootOpts.PushOCI, "push-oci", rootOpts.PushOCI, "reference where to push the resulting kernel module and eBPF probe as an OCI artifact, it can use the build details, e.g. registry/driver:{{ .KernelRelease }}")
+	flags.StringVar(&rootOpts.BuildLog, "build-log", rootOpts.BuildLog, "file where to save the whole output of the build script, after a header describing the build, it can use the build details, e.g. /tmp/build-{{ .KernelRelease }}.log")
+	flags.BoolVar(&rootOpts.OCIInsecure, "oci-insecure", rootOpts.OCIInsecure, "push the OCI artifact to a registry over plain HTTP")
+	flags.StringVar(&rootOpts.S3Endpoint, "s3-endpoint", rootOpts.S3Endpoint, "endpoint of the S3 compatible storage to upload to instead of AWS, e.g. a MinIO server")
 	flags.StringVar(&rootOpts.Architecture, "architecture", runtime.GOARCH, "target architecture for the built driver")
-	flags.StringVar(&rootOpts.DriverVersion, "driverversion", rootOpts.DriverVersion, "driver version as a git commit hash or as a git tag")
+	flags.StringVar(&rootOpts.PackageArchitecture, "package-architecture", rootOpts.PackageArchitecture, "architecture of the kernel packages of the arm builds, armhf or armel, when the flavor of the kernel release does not tell it")
+	flags.StringVar(&rootOpts.DriverVersion, "driverversion", rootOpts.DriverVersion, "driver version as a git commit hash, as a git tag or as a git ref, e.g. refs/pull/123/head")
+	flags.StringVar(&rootOpts.DriverRepo, "repo", rootOpts.DriverRepo, "GitHub repository, as owner/name, the driver sources are downloaded from at the driver version (default \"falcosecurity/libs\")")
+	flags.BoolVar(&rootOpts.DriverUseGit, "use-git", rootOpts.DriverUseGit, "clone the driver sources at the driver version with git, shallowly, rather than downloading their tarball")
 	flags.StringVar(&rootOpts.KernelVersion, "kernelversion", rootOpts.KernelVersion, "kernel version to build the module for, it's the numeric value after the hash when you execute 'uname -v'")
 	flags.StringVar(&rootOpts.KernelRelease, "kernelrelease", rootOpts.KernelRelease, "kernel release to build the module for, it can be found by executing 'uname -v'")
 	flags.StringVarP(&rootOpts.Target, "target", "t", rootOpts.Target, "the system to target the build for")
+	flags.BoolVar(&rootOpts.Autodetect, "autodetect", rootOpts.Autodetect, "detect the target, kernel release, kernel version and kernel config data of the local machine, the ones given explicitly take precedence")
 	flags.StringVar(&rootOpts.KernelConfigData, "kernelconfigdata", rootOpts.KernelConfigData, "base64 encoded kernel config data: in some systems it can be found under the /boot directory, in other it is gzip compressed under /proc")
-	flags.StringVar(&rootOpts.ModuleDeviceName, "moduledevicename", rootOpts.ModuleDeviceName, "kernel module device name (the default is falco, so the device will be under /dev/falco*)")
+	flags.StringVar(&rootOpts.KernelConfigDataFile, "kernelconfigdata-file", rootOpts.KernelConfigDataFile, "file the kernel config data is read from instead of --kernelconfigdata, - for the standard input, e.g. /boot/config-$(uname -r) or /proc/config.gz as is")
+	flags.StringVar(&rootOpts.ModuleDeviceName, "moduledevicename", rootOpts.ModuleDeviceName, "kernel module device name, the devices being /dev/<name>*, also given as --device-name, it defaults to the kernel module driver name")
 	flags.StringVar(&rootOpts.ModuleDriverName, "moduledrivername", rootOpts.ModuleDriverName, "kernel module driver name, i.e. the name you see when you check installed modules via lsmod")
+	flags.StringVar(&rootOpts.BuilderTemplate, "builder-template", rootOpts.BuilderTemplate, "template of the build script replacing the embedded one of the target, it gets the same data, e.g. {{ .KernelDownloadURLS }}")
 	flags.StringVar(&rootOpts.BuilderImage, "builderimage", rootOpts.BuilderImage, "docker image to be used to build the kernel module and eBPF probe. If not provided, the default image will be used.")
+	flags.StringVar(&rootOpts.ImageRepo, "image-repo", rootOpts.ImageRepo, "repository the builder image is selected from when --builderimage is not given, tagged by target, gcc version and architecture, e.g. registry/driverkit:debian-gcc6-amd64")
 	flags.StringSliceVar(&rootOpts.KernelUrls, "kernelurls", nil, "list of kernel header urls (e.g. --kernelurls <URL1> --kernelurls <URL2> --kernelurls \"<URL3>,<URL4>\")")
+	flags.StringArrayVar(&rootOpts.Env, "env", nil, "environment variable of the build, as NAME=VALUE or just NAME to pass the current one, exported by the build script and set on the builder container or pod (e.g. --env KCFLAGS=-Wno-error)")
+	flags.StringArrayVar(&rootOpts.KernelConfigFragments, "kernel-config-fragment", nil, "kernel config fragment the vanilla target merges into the kernel config data, a file or inline (e.g. --kernel-config-fragment CONFIG_FTRACE_SYSCALLS=y --kernel-config-fragment /tmp/ftrace.config)")
+	flags.StringArrayVar(&rootOpts.Mirrors, "mirror", nil, "mirror the target looks for its kernel packages into before its built-in ones, as target=URL, with the layout of the built-in ones printed by driverkit mirrors (e.g. --mirror debian=http://mirror.local/debian/pool/main/l/linux/)")
+	flags.BoolVar(&rootOpts.ReplaceMirrors, "replace-mirrors", rootOpts.ReplaceMirrors, "look for the kernel packages into the mirrors given with --mirror only, instead of the built-in ones of their targets too")
+	flags.StringVar(&rootOpts.MakeFlags, "make-flags", rootOpts.MakeFlags, "extra flags given to every make invocation of the build script, e.g. \"-j8 V=1\"")
+	flags.StringVar(&rootOpts.LocalDriverDir, "local-driver-dir", rootOpts.LocalDriverDir, "local tree of the libs to build the driver from instead of downloading its sources, e.g. a working copy, without its symlinks and .git directories")
+	flags.StringVar(&rootOpts.KernelURLsAuth, "kernelurls-auth", rootOpts.KernelURLsAuth, "basic credentials, as user:password, to download the kernel header urls with, sent to their hosts only, it can also be provided with the DRIVERKIT_KERNELURLS_AUTH environment variable")
+	flags.StringVar(&rootOpts.KernelURLsToken, "kernelurls-token", rootOpts.KernelURLsToken, "bearer token to download the kernel header urls with, sent to their hosts only, it can also be provided with the DRIVERKIT_KERNELURLS_TOKEN environment variable")
+	flags.StringVar(&rootOpts.LocalKernelDir, "local-kernel-dir", rootOpts.LocalKernelDir, "directory containing the kernel header packages to build against instead of downloading them, e.g. for air-gapped builds")
+	flags.StringVar(&rootOpts.CcacheDir, "ccache-dir", rootOpts.CcacheDir, "directory the kernel module is compiled through ccache into, of the docker host or of the kubernetes node, or a persistent volume claim as pvc:<name> on kubernetes")
+	flags.StringVar(&rootOpts.CacheDir, "cache-dir", rootOpts.CacheDir, "directory where to cache the mirror index pages between runs, they are only cached in memory when not provided")
+	flags.BoolVar(&rootOpts.SkipChecksum, "skip-checksum", rootOpts.SkipChecksum, "do not verify the downloaded kernel packages against the checksums published by their repositories")
+	flags.BoolVar(&rootOpts.ForceEmulation, "force-emulation", rootOpts.ForceEmulation, "build for another architecture under qemu emulation instead of cross compiling from the amd64 builder image, for the kernel headers not cross compiling")
+	flags.StringVar(&rootOpts.ModuleSigningKey, "module-signing-key", rootOpts.ModuleSigningKey, "private key signing the resulting kernel module for Secure Boot, e.g. the MOK one, along with --module-signing-cert")
+	flags.StringVar(&rootOpts.ModuleSigningCert, "module-signing-cert", rootOpts.ModuleSigningCert, "certificate of the key signing the resulting kernel module for Secure Boot, along with --module-signing-key")
+	flags.StringVar(&rootOpts.RHELEntitlementCert, "rhel-entitlement-cert", rootOpts.RHELEntitlementCert, "entitlement certificate of a Red Hat subscription the redhat target downloads the kernel from the Red Hat CDN with, e.g. /etc/pki/entitlement/<serial>.pem, along with --rhel-entitlement-key")
+	flags.StringVar(&rootOpts.RHELEntitlementKey, "rhel-entitlement-key", rootOpts.RHELEntitlementKey, "key of the entitlement certificate of the Red Hat subscription, e.g. /etc/pki/entitlement/<serial>-key.pem, along with --rhel-entitlement-cert")
+	flags.BoolVar(&rootOpts.VerifyKernelSignature, "verify-kernel-signature", rootOpts.VerifyKernelSignature, "verify the kernel.org tarball of the vanilla target against its detached signature, made by one of the pinned kernel.org keys")
+	flags.StringVar(&rootOpts.Compress, "compress", rootOpts.Compress, "algorithm compressing the kernel module and eBPF probe once built, appending its extension to their output paths: gzip (.gz), xz (.xz) or none")
+	flags.StringVar(&rootOpts.Checksum, "checksum", rootOpts.Checksum, "algorithm of the checksum files written next to the kernel module and eBPF probe, in the sha256sum format: sha256, sha512 or none")
+	flags.StringVar(&rootOpts.LLVMVersion, "llvm-version", rootOpts.LLVMVersion, "LLVM version (e.g. 12) used to build the eBPF probe, it overrides the one chosen by the target")
+	flags.StringVar(&rootOpts.GCCVersion, "gcc-version", rootOpts.GCCVersion, "gcc version (e.g. 6) used to build the kernel module and the eBPF probe, it overrides the one chosen by the target, the build is then not cross compiled")
 
 	viper.BindPFlags(flags)
 
+	// --builder-image is the same as --builderimage, --device-name as --moduledevicename
+	rootCmd.SetGlobalNormalizationFunc(func(f *pflag.FlagSet, name string) pflag.NormalizedName {
+		switch name {
+		case "builder-image":
+			name = "builderimage"
+		case "device-name":
+			name = "moduledevicename"
+		}
+		return pflag.NormalizedName(name)
+	})
+
 	// Flag annotations and custom completions
 	rootCmd.MarkFlagFilename("config", viper.SupportedExts...)
 	rootCmd.RegisterFlagCompletionFunc("target", func(c *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
@@ -146,11 +279,27 @@ func NewRootCmd() *RootCmd {
 		sort.Strings(targets)
 		return targets, cobra.ShellCompDirectiveDefault
 	})
+	rootCmd.RegisterFlagCompletionFunc("architecture", func(c *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		architectures := []string{}
+		for _, a := range kernelrelease.Architectures {
+			architectures = append(architectures, a.String())
+		}
+		return architectures, cobra.ShellCompDirectiveNoFileComp
+	})
 
 	// Subcommands
 	rootCmd.AddCommand(NewKubernetesCmd(rootOpts, flags))
 	rootCmd.AddCommand(NewDockerCmd(rootOpts, flags))
+	rootCmd.AddCommand(NewPodmanCmd(rootOpts, flags))
+	rootCmd.AddCommand(NewLocalCmd(rootOpts, flags))
+	rootCmd.AddCommand(NewSSHCmd(rootOpts, flags))
+	rootCmd.AddCommand(NewServeCmd(rootOpts, flags))
 	rootCmd.AddCommand(NewCompletionCmd())
+	rootCmd.AddCommand(NewTargetsCmd())
+	rootCmd.AddCommand(NewValidateCmd(rootOpts, flags))
+	rootCmd.AddCommand(NewConfigCmd(flags))
+	rootCmd.AddCommand(NewInitCmd())
+	rootCmd.AddCommand(NewMirrorsCmd(rootOpts, flags))
 
 	ret.StripSensitive()
 
@@ -216,6 +365,9 @@ func init() {
 
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
+	if configOptions.LogFormat == "json" {
+		logger.SetFormatter(&logger.JSONFormatter{})
+	}
 	if errs := configOptions.Validate(); errs != nil {
 		for _, err := range errs {
 			logger.WithError(err).Error("error validating config options")
@@ -243,6 +395,10 @@ func initConfig() {
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
 		logger.WithField("file", viper.ConfigFileUsed()).Info("using config file")
+		if err := readYAMLConfig(viper.ConfigFileUsed()); err != nil {
+			logger.WithField("file", viper.ConfigFileUsed()).WithError(err).Debug("error running with config file")
+			configOptions.configErrors = true
+		}
 	} else {
 		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
 			// Config file not found, ignore ...
@@ -254,3 +410,25 @@ func initConfig() {
 		}
 	}
 }
+
+// readYAMLConfig reads the YAML config file again, its documents expanded and merged with their defaults,
+// into the config the options are read from.
+func readYAMLConfig(name string) error {
+	if ext := filepath.Ext(name); ext != ".yaml" && ext != ".yml" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	cf, err := parseConfigFile(data)
+	if err != nil {
+		return err
+	}
+	options, err := yaml.Marshal(cf.options())
+	if err != nil {
+		return err
+	}
+	configOptions.configFile = cf
+	return viper.ReadConfig(bytes.NewReader(options))
+}
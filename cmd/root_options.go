@@ -1,7 +1,15 @@
 package cmd
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
 
 	"github.com/creasty/defaults"
 	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
@@ -11,25 +19,67 @@ import (
 	logger "github.com/sirupsen/logrus"
 )
 
-// OutputOptions wraps the two drivers that driverkit builds.
+// OutputOptions wraps the drivers that driverkit builds.
 type OutputOptions struct {
-	Module string `validate:"required_without=Probe,filepath,omitempty,endswith=.ko" name:"output module path"`
-	Probe  string `validate:"required_without=Module,filepath,omitempty,endswith=.o" name:"output probe path"`
+	Module string `validate:"required_without_all=Probe ModernProbe,filepath,omitempty,endswith=.ko" name:"output module path"`
+	Probe  string `validate:"required_without_all=Module ModernProbe,filepath,omitempty,endswith=.o" name:"output probe path"`
+	// ModernProbe is the skeleton of the modern eBPF probe, CO-RE.
+	ModernProbe string `validate:"required_without_all=Module Probe,filepath,omitempty,endswith=.h" name:"output modern probe path"`
+	// BTF is the raw BTF of the kernel, generated along the drivers when its debug package is found.
+	BTF string `validate:"omitempty,filepath" name:"output btf path"`
+	// DKMS is the DKMS source package of the kernel module, assembled along it.
+	DKMS string `validate:"omitempty,filepath,endswith=.tar.gz" name:"output dkms path"`
+	// ModuleS3 and ProbeS3 are the s3:// URLs the drivers are uploaded to, they can contain templates of the build details.
+	ModuleS3 string `validate:"omitempty,s3url" name:"output module s3 url"`
+	ProbeS3  string `validate:"omitempty,s3url" name:"output probe s3 url"`
 }
 
 // RootOptions ...
 type RootOptions struct {
-	Architecture     string   `validate:"required,oneof=amd64 arm64" name:"architecture"`
-	DriverVersion    string   `default:"master" validate:"eq=master|sha1|semver" name:"driver version"`
-	KernelVersion    string   `default:"1" validate:"omitempty" name:"kernel version"`
-	ModuleDriverName string   `default:"falco" validate:"max=60" name:"kernel module driver name"`
-	ModuleDeviceName string   `default:"falco" validate:"excludes=/,max=255" name:"kernel module device name"`
-	KernelRelease    string   `validate:"required,ascii" name:"kernel release"`
-	Target           string   `validate:"required,target" name:"target"`
-	KernelConfigData string   `validate:"omitempty,base64" name:"kernel config data"` // fixme > tag "name" does not seem to work when used at struct level, but works when used at inner level
-	BuilderImage     string   `validate:"imagename" name:"builder image"`
-	KernelUrls       []string `name:"kernel header urls"`
-	Output           OutputOptions
+	Architecture          string   `validate:"required,oneof=amd64 arm64 ppc64le s390x riscv64 arm" name:"architecture"`
+	PackageArchitecture   string   `validate:"omitempty,oneof=armhf armel" name:"package architecture"`
+	DriverVersion         string   `default:"master" validate:"eq=master|sha1|semver|gitref" name:"driver version"`
+	KernelVersion         string   `default:"1" validate:"omitempty" name:"kernel version"`
+	ModuleDriverName      string   `default:"falco" validate:"max=60" name:"kernel module driver name"`
+	ModuleDeviceName      string   `default:"falco" validate:"excludes=/,max=255" name:"kernel module device name"`
+	KernelRelease         string   `validate:"required,ascii" name:"kernel release"`
+	Target                string   `validate:"required,target" name:"target"`
+	Autodetect            bool     `name:"autodetect"`
+	KernelConfigData      string   `validate:"omitempty,base64" name:"kernel config data"` // fixme > tag "name" does not seem to work when used at struct level, but works when used at inner level
+	KernelConfigDataFile  string   `name:"kernel config data file"`
+	BuilderImage          string   `validate:"imagename" name:"builder image"`
+	ImageRepo             string   `validate:"omitempty,imagename" name:"image repository"`
+	BuilderTemplate       string   `validate:"omitempty,file" name:"builder template"`
+	KernelUrls            []string `name:"kernel header urls"`
+	Env                   []string `name:"env"`
+	MakeFlags             string   `name:"make flags"`
+	LLVMVersion           string   `validate:"omitempty,excludesall= /" name:"llvm version"`
+	GCCVersion            string   `validate:"omitempty,excludesall= /" name:"gcc version"`
+	CacheDir              string   `validate:"omitempty,dirpath" name:"cache directory"`
+	CcacheDir             string   `name:"ccache directory"`
+	SkipChecksum          bool     `name:"skip checksum"`
+	ForceEmulation        bool     `name:"force emulation"`
+	LocalKernelDir        string   `validate:"omitempty,dirpath" name:"local kernel directory"`
+	Checksum              string   `default:"sha256" validate:"oneof=sha256 sha512 none" name:"checksum"`
+	ModuleSigningKey      string   `validate:"required_with=ModuleSigningCert,omitempty,filepath" name:"module signing key"`
+	ModuleSigningCert     string   `validate:"required_with=ModuleSigningKey,omitempty,filepath" name:"module signing cert"`
+	RHELEntitlementCert   string   `validate:"required_with=RHELEntitlementKey,omitempty,filepath" name:"rhel entitlement cert"`
+	RHELEntitlementKey    string   `validate:"required_with=RHELEntitlementCert,omitempty,filepath" name:"rhel entitlement key"`
+	VerifyKernelSignature bool     `name:"verify kernel signature"`
+	KernelConfigFragments []string `name:"kernel config fragments"`
+	DriverRepo            string   `validate:"omitempty,excludes= ,contains=/" name:"driver repository"`
+	DriverUseGit          bool     `name:"use git"`
+	LocalDriverDir        string   `validate:"omitempty,dirpath" name:"local driver directory"`
+	KernelURLsAuth        string   `validate:"omitempty,contains=:" name:"kernel header urls auth"`
+	KernelURLsToken       string   `name:"kernel header urls token"`
+	Compress              string   `default:"none" validate:"oneof=gzip xz none" name:"compression"`
+	S3Endpoint            string   `validate:"omitempty,url" name:"s3 endpoint"`
+	PushOCI               string   `name:"oci reference"`
+	OCIInsecure           bool     `name:"oci insecure"`
+	BuildLog              string   `name:"build log"`
+	Mirrors               []string `name:"mirrors"`
+	ReplaceMirrors        bool     `name:"replace mirrors"`
+	Output                OutputOptions
 }
 
 func init() {
@@ -65,6 +115,44 @@ func (ro *RootOptions) Validate() []error {
 	return nil
 }
 
+// problems returns all the problems of the options: the invalid ones or, once they are all valid, those of the build they describe.
+func (ro *RootOptions) problems() []error {
+	if errs := ro.Validate(); errs != nil {
+		return errs
+	}
+	return driverbuilder.BuildProblems(ro.toBuild())
+}
+
+// fieldProblems returns the problems of the fields of the options, e.g. DriverVersion or Output.Module,
+// leaving out those of the other fields the struct level validation reports.
+func (ro *RootOptions) fieldProblems(fields ...string) []error {
+	err := validate.V.StructPartial(ro, fields...)
+	if err == nil {
+		return nil
+	}
+	errs := []error{}
+	for _, e := range err.(validator.ValidationErrors) {
+		for _, f := range fields {
+			if e.StructNamespace() == "RootOptions."+f {
+				errs = append(errs, fmt.Errorf(e.Translate(validate.T)))
+			}
+		}
+	}
+	return errs
+}
+
+// validBuild returns the build of the options once validated, with the errors of all the invalid ones.
+func (ro *RootOptions) validBuild() (*builder.Build, error) {
+	if errs := ro.problems(); len(errs) > 0 {
+		msgs := []string{}
+		for _, err := range errs {
+			msgs = append(msgs, err.Error())
+		}
+		return nil, fmt.Errorf("%s", strings.Join(msgs, ", "))
+	}
+	return ro.toBuild(), nil
+}
+
 // Log emits a log line containing the receiving RootOptions for debugging purposes.
 //
 // Call it only after validation.
@@ -77,6 +165,21 @@ func (ro *RootOptions) Log() {
 		fields["output-probe"] = ro.Output.Probe
 
 	}
+	if ro.Output.ModernProbe != "" {
+		fields["output-modern-probe"] = ro.Output.ModernProbe
+	}
+	if ro.Output.BTF != "" {
+		fields["output-btf"] = ro.Output.BTF
+	}
+	if ro.Output.DKMS != "" {
+		fields["output-dkms"] = ro.Output.DKMS
+	}
+	if ro.Output.ModuleS3 != "" {
+		fields["output-module-s3"] = ro.Output.ModuleS3
+	}
+	if ro.Output.ProbeS3 != "" {
+		fields["output-probe-s3"] = ro.Output.ProbeS3
+	}
 	if ro.DriverVersion != "" {
 		fields["driverversion"] = ro.DriverVersion
 	}
@@ -90,8 +193,104 @@ func (ro *RootOptions) Log() {
 		fields["target"] = ro.Target
 	}
 	fields["arch"] = ro.Architecture
+	if ro.PackageArchitecture != "" {
+		fields["packagearch"] = ro.PackageArchitecture
+	}
 	if len(ro.KernelUrls) > 0 {
-		fields["kernelurls"] = ro.KernelUrls
+		urls := make([]string, len(ro.KernelUrls))
+		for i, u := range ro.KernelUrls {
+			urls[i] = builder.RedactURL(u)
+		}
+		fields["kernelurls"] = urls
+	}
+	// the credentials are never logged, only the user is
+	if ro.KernelURLsAuth != "" {
+		fields["kernelurls-auth"] = strings.SplitN(ro.KernelURLsAuth, ":", 2)[0] + ":xxxxx"
+	}
+	if ro.KernelURLsToken != "" {
+		fields["kernelurls-token"] = "xxxxx"
+	}
+	if ro.ImageRepo != "" {
+		fields["image-repo"] = ro.ImageRepo
+	}
+	if ro.LLVMVersion != "" {
+		fields["llvm-version"] = ro.LLVMVersion
+	}
+	if ro.GCCVersion != "" {
+		fields["gcc-version"] = ro.GCCVersion
+	}
+	if ro.CacheDir != "" {
+		fields["cache-dir"] = ro.CacheDir
+	}
+	if ro.CcacheDir != "" {
+		fields["ccache-dir"] = ro.CcacheDir
+	}
+	if ro.SkipChecksum {
+		fields["skip-checksum"] = ro.SkipChecksum
+	}
+	if ro.ForceEmulation {
+		fields["force-emulation"] = ro.ForceEmulation
+	}
+	if len(ro.KernelConfigFragments) > 0 {
+		fields["kernel-config-fragment"] = ro.KernelConfigFragments
+	}
+	if ro.VerifyKernelSignature {
+		fields["verify-kernel-signature"] = ro.VerifyKernelSignature
+	}
+	if ro.DriverRepo != "" {
+		fields["repo"] = ro.DriverRepo
+	}
+	if ro.DriverUseGit {
+		fields["use-git"] = ro.DriverUseGit
+	}
+	if ro.LocalKernelDir != "" {
+		fields["local-kernel-dir"] = ro.LocalKernelDir
+	}
+	if ro.LocalDriverDir != "" {
+		fields["local-driver-dir"] = ro.LocalDriverDir
+	}
+	if ro.BuilderTemplate != "" {
+		fields["builder-template"] = ro.BuilderTemplate
+	}
+	if len(ro.Env) > 0 {
+		fields["env"] = ro.Env
+	}
+	if ro.MakeFlags != "" {
+		fields["make-flags"] = ro.MakeFlags
+	}
+	if len(ro.Mirrors) > 0 {
+		fields["mirror"] = ro.Mirrors
+	}
+	if ro.ReplaceMirrors {
+		fields["replace-mirrors"] = ro.ReplaceMirrors
+	}
+	fields["checksum"] = ro.Checksum
+	if ro.ModuleSigningKey != "" {
+		fields["module-signing-key"] = ro.ModuleSigningKey
+	}
+	if ro.ModuleSigningCert != "" {
+		fields["module-signing-cert"] = ro.ModuleSigningCert
+	}
+	if ro.RHELEntitlementCert != "" {
+		fields["rhel-entitlement-cert"] = ro.RHELEntitlementCert
+	}
+	if ro.RHELEntitlementKey != "" {
+		fields["rhel-entitlement-key"] = ro.RHELEntitlementKey
+	}
+	if ro.Compress != "none" {
+		fields["compress"] = ro.Compress
+	}
+	if ro.S3Endpoint != "" {
+		fields["s3-endpoint"] = ro.S3Endpoint
+	}
+	if ro.PushOCI != "" {
+		fields["push-oci"] = ro.PushOCI
+	}
+	if ro.OCIInsecure {
+		fields["oci-insecure"] = ro.OCIInsecure
+	}
+	if ro.BuildLog != "" {
+		fields["build-log"] = ro.BuildLog
 	}
 
 	logger.WithFields(fields).Debug("running with options")
@@ -100,28 +299,150 @@ func (ro *RootOptions) Log() {
 func (ro *RootOptions) toBuild() *builder.Build {
 	kernelConfigData := ro.KernelConfigData
 	if len(kernelConfigData) == 0 {
-		kernelConfigData = "bm8tZGF0YQ==" // no-data
+		kernelConfigData = builder.NoKernelConfigData
+	}
+
+	checksum := ro.Checksum
+	if checksum == "none" {
+		checksum = ""
+	}
+
+	compression := ro.Compress
+	if compression == "none" {
+		compression = ""
 	}
 
 	return &builder.Build{
-		TargetType:         builder.Type(ro.Target),
-		DriverVersion:      ro.DriverVersion,
-		KernelVersion:      ro.KernelVersion,
-		KernelRelease:      ro.KernelRelease,
-		Architecture:       ro.Architecture,
-		KernelConfigData:   kernelConfigData,
-		ModuleFilePath:     ro.Output.Module,
-		ProbeFilePath:      ro.Output.Probe,
-		ModuleDriverName:   ro.ModuleDriverName,
-		ModuleDeviceName:   ro.ModuleDeviceName,
-		CustomBuilderImage: ro.BuilderImage,
-		KernelUrls:         ro.KernelUrls,
+		TargetType:            builder.Type(ro.Target),
+		DriverVersion:         ro.DriverVersion,
+		KernelVersion:         ro.KernelVersion,
+		KernelRelease:         ro.KernelRelease,
+		Architecture:          ro.Architecture,
+		PackageArchitecture:   ro.PackageArchitecture,
+		KernelConfigData:      kernelConfigData,
+		ModuleFilePath:        ro.Output.Module,
+		ProbeFilePath:         ro.Output.Probe,
+		ModernProbeFilePath:   ro.Output.ModernProbe,
+		BTFFilePath:           ro.Output.BTF,
+		DKMSFilePath:          ro.Output.DKMS,
+		ModuleDriverName:      ro.ModuleDriverName,
+		ModuleDeviceName:      ro.ModuleDeviceName,
+		CustomBuilderImage:    ro.BuilderImage,
+		ImageRepo:             ro.ImageRepo,
+		KernelUrls:            ro.KernelUrls,
+		LLVMVersion:           ro.LLVMVersion,
+		GCCVersion:            ro.GCCVersion,
+		CacheDir:              ro.CacheDir,
+		CcacheDir:             ro.CcacheDir,
+		SkipChecksum:          ro.SkipChecksum,
+		ForceEmulation:        ro.ForceEmulation,
+		LocalKernelDir:        ro.LocalKernelDir,
+		TemplateOverride:      ro.BuilderTemplate,
+		Env:                   buildEnv(ro.Env),
+		MakeFlags:             ro.MakeFlags,
+		Checksum:              checksum,
+		Compression:           compression,
+		ModuleSigningKey:      ro.ModuleSigningKey,
+		ModuleSigningCert:     ro.ModuleSigningCert,
+		RHELEntitlementCert:   ro.RHELEntitlementCert,
+		RHELEntitlementKey:    ro.RHELEntitlementKey,
+		VerifyKernelSignature: ro.VerifyKernelSignature,
+		KernelConfigFragments: ro.KernelConfigFragments,
+		DriverRepo:            ro.DriverRepo,
+		DriverUseGit:          ro.DriverUseGit,
+		LocalDriverDir:        ro.LocalDriverDir,
+		KernelURLsAuth:        ro.KernelURLsAuth,
+		KernelURLsToken:       ro.KernelURLsToken,
+		ModuleS3URL:           ro.Output.ModuleS3,
+		ProbeS3URL:            ro.Output.ProbeS3,
+		S3Endpoint:            ro.S3Endpoint,
+		OCIRef:                ro.PushOCI,
+		OCIInsecure:           ro.OCIInsecure,
+		BuildLogPath:          ro.BuildLog,
+		Mirrors:               buildMirrors(ro.Mirrors),
+		ReplaceMirrors:        ro.ReplaceMirrors,
+	}
+}
+
+// buildEnv returns the variables of the build, given as NAME=VALUE or just NAME to pass the current one,
+// the unset ones being skipped.
+func buildEnv(vars []string) map[string]string {
+	if len(vars) == 0 {
+		return nil
+	}
+	env := map[string]string{}
+	for _, v := range vars {
+		name, value := v, ""
+		if i := strings.Index(v, "="); i >= 0 {
+			name, value = v[:i], v[i+1:]
+		} else if current, ok := os.LookupEnv(v); ok {
+			value = current
+		} else {
+			continue
+		}
+		env[name] = value
+	}
+	return env
+}
+
+// buildMirrors returns the mirrors of the build by target, given as target=URL,
+// the ones without target being kept as they are, without target, for their validation to report them.
+func buildMirrors(mirrors []string) map[string][]string {
+	if len(mirrors) == 0 {
+		return nil
+	}
+	byTarget := map[string][]string{}
+	for _, m := range mirrors {
+		target, u := "", m
+		if i := strings.Index(m, "="); i >= 0 {
+			target, u = m[:i], m[i+1:]
+		}
+		byTarget[target] = append(byTarget[target], u)
 	}
+	return byTarget
+}
+
+// kernelConfigLinePattern matches the lines setting a symbol of a kernel config, e.g. CONFIG_BPF=y or # CONFIG_BPF is not set.
+var kernelConfigLinePattern = regexp.MustCompile(`(?m)^(CONFIG_[A-Za-z0-9_]+=|# CONFIG_[A-Za-z0-9_]+ is not set)`)
+
+// loadKernelConfigDataFile sets the kernel config data to the base64 encoded content of the kernel config data file, if any,
+// the standard input being read when it is -. The gzip compressed configs, e.g. /proc/config.gz, are uncompressed first.
+func (ro *RootOptions) loadKernelConfigDataFile(stdin io.Reader) error {
+	if len(ro.KernelConfigDataFile) == 0 {
+		return nil
+	}
+	if len(ro.KernelConfigData) > 0 {
+		return fmt.Errorf("the kernel config data and the kernel config data file cannot be used together")
+	}
+	var data []byte
+	var err error
+	if ro.KernelConfigDataFile == "-" {
+		data, err = ioutil.ReadAll(stdin)
+	} else {
+		data, err = ioutil.ReadFile(ro.KernelConfigDataFile)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read the kernel config data file: %s", err)
+	}
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("invalid gzip compressed kernel config data file: %s", err)
+		}
+		if data, err = ioutil.ReadAll(r); err != nil {
+			return fmt.Errorf("invalid gzip compressed kernel config data file: %s", err)
+		}
+	}
+	if !kernelConfigLinePattern.Match(data) {
+		return fmt.Errorf("the kernel config data file %s is not a kernel config, it has no CONFIG_ line: it must be e.g. /boot/config-$(uname -r) or /proc/config.gz", ro.KernelConfigDataFile)
+	}
+	ro.KernelConfigData = base64.StdEncoding.EncodeToString(data)
+	return nil
 }
 
 // RootOptionsLevelValidation validates KernelConfigData and Target at the same time.
 //
-// It reports an error when `KernelConfigData` is empty and `Target` is `vanilla`.
+// It reports an error when `KernelConfigData` is empty and `Target` is `vanilla` or `gentoo`.
 func RootOptionsLevelValidation(level validator.StructLevel) {
 	opts := level.Current().Interface().(RootOptions)
 
@@ -129,13 +450,63 @@ func RootOptionsLevelValidation(level validator.StructLevel) {
 		level.ReportError(opts.KernelConfigData, "kernelConfigData", "KernelConfigData", "required_kernelconfigdata_with_target_vanilla", "")
 	}
 
+	if len(opts.KernelConfigData) == 0 && opts.Target == builder.TargetTypeGentoo.String() {
+		level.ReportError(opts.KernelConfigData, "kernelConfigData", "KernelConfigData", "required_kernelconfigdata_with_target_gentoo", "")
+	}
+
 	// UbuntuAWS and UbuntuGeneric should be deprecated in future in favor of just Ubuntu
 	if opts.KernelVersion == "" && (opts.Target == builder.TargetTypeUbuntu.String() || opts.Target == builder.TargetTypeUbuntuAWS.String() || opts.Target == builder.TargetTypeUbuntuGeneric.String()) {
 		level.ReportError(opts.KernelVersion, "kernelVersion", "KernelVersion", "required_kernelversion_with_target_ubuntu", "")
 	}
 
+	// Target bottlerocket requires the variant (eg. aws-k8s-1.24) as kernel version
+	if opts.Target == builder.TargetTypeBottlerocket.String() && !strings.Contains(opts.KernelVersion, "-") {
+		level.ReportError(opts.KernelVersion, "kernelVersion", "KernelVersion", "required_kernelversion_with_target_bottlerocket", "")
+	}
+
 	// Target redhat requires a valid build image (has to be registered in order to download packages)
-	if opts.Target == builder.TargetTypeRedhat.String() && opts.BuilderImage == driverbuilder.BuilderBaseImage {
+	if opts.Target == builder.TargetTypeRedhat.String() && opts.BuilderImage == driverbuilder.BuilderBaseImage && len(opts.ImageRepo) == 0 {
 		level.ReportError(opts.BuilderImage, "builderimage", "builderimage", "required_builderimage_with_target_redhat", "")
 	}
+
+	// The kernel is downloaded from the Red Hat CDN with the entitlement by the redhat target only
+	if len(opts.RHELEntitlementCert) > 0 && opts.Target != builder.TargetTypeRedhat.String() {
+		level.ReportError(opts.RHELEntitlementCert, "rhel entitlement cert", "RHELEntitlementCert", "excluded_rhel_entitlement_without_target_redhat", "")
+	}
+
+	// The local kernel packages replace the kernel header urls
+	if len(opts.LocalKernelDir) > 0 && len(opts.KernelUrls) > 0 {
+		level.ReportError(opts.LocalKernelDir, "localKernelDir", "LocalKernelDir", "excluded_localkerneldir_with_kernelurls", "")
+	}
+
+	// The credentials authenticate the kernel header urls, one kind at a time
+	if (len(opts.KernelURLsAuth) > 0 || len(opts.KernelURLsToken) > 0) && len(opts.KernelUrls) == 0 {
+		level.ReportError(opts.KernelUrls, "kernelUrls", "KernelUrls", "required_kernelurls_with_kernelurls_auth", "")
+	}
+	if len(opts.KernelURLsAuth) > 0 && len(opts.KernelURLsToken) > 0 {
+		level.ReportError(opts.KernelURLsAuth, "kernelURLsAuth", "KernelURLsAuth", "excluded_kernelurls_auth_with_token", "")
+	}
+
+	// The local driver sources are not downloaded
+	if len(opts.LocalDriverDir) > 0 && (len(opts.DriverRepo) > 0 || opts.DriverUseGit) {
+		level.ReportError(opts.LocalDriverDir, "localDriverDir", "LocalDriverDir", "excluded_localdriverdir_with_repo", "")
+	}
+
+	// Only the kernel module built can be signed
+	if len(opts.ModuleSigningKey) > 0 && len(opts.Output.Module) == 0 {
+		level.ReportError(opts.Output.Module, "output module path", "Module", "required_output_with_module_signing", "")
+	}
+
+	// The DKMS package is assembled from the sources of the kernel module built
+	if len(opts.Output.DKMS) > 0 && len(opts.Output.Module) == 0 {
+		level.ReportError(opts.Output.Module, "output module path", "Module", "required_output_with_dkms", "")
+	}
+
+	// Only the drivers built can be uploaded
+	if len(opts.Output.ModuleS3) > 0 && len(opts.Output.Module) == 0 {
+		level.ReportError(opts.Output.Module, "output module path", "Module", "required_output_with_s3", "")
+	}
+	if len(opts.Output.ProbeS3) > 0 && len(opts.Output.Probe) == 0 {
+		level.ReportError(opts.Output.Probe, "output probe path", "Probe", "required_output_with_s3", "")
+	}
 }
@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/kubernetes/factory"
+	"github.com/falcosecurity/driverkit/pkg/server"
+	"github.com/falcosecurity/driverkit/pkg/signals"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// NewServeCmd creates the `driverkit serve` command.
+func NewServeCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Command {
+	serveCmd := &cobra.Command{
+		Use:         "serve",
+		Short:       "Run the builds requested over HTTP against a docker daemon or a Kubernetes cluster, a bounded number at a time.",
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{skipRootValidation: "true"},
+	}
+
+	serveCmd.PersistentFlags().String("addr", ":8080", "address the HTTP server listens on")
+	serveCmd.PersistentFlags().String("processor", "docker", "processor the builds run with, docker or kubernetes")
+	serveCmd.PersistentFlags().Int("workers", 1, "number of builds running at the same time")
+	serveCmd.PersistentFlags().Int("queue-size", 16, "number of builds waiting for a worker, the ones requested beyond it are rejected")
+	serveCmd.PersistentFlags().String("work-dir", filepath.Join(os.TempDir(), "driverkit-builds"), "directory the artifacts of the builds are written into, one directory each")
+	serveCmd.PersistentFlags().Duration("retention", server.DefaultRetention, "time the completed builds are kept for, with their logs and artifacts")
+	serveCmd.PersistentFlags().Int("max-completed", server.DefaultMaxCompleted, "number of completed builds kept, the oldest ones are removed beyond it")
+	kubefactory := addKubernetesFlags(serveCmd.PersistentFlags())
+	addRegistryFlags(serveCmd.PersistentFlags())
+	addDockerHostFlags(serveCmd.PersistentFlags())
+	// Add root flags, the defaults of the builds requested
+	serveCmd.PersistentFlags().AddFlagSet(rootFlags)
+
+	serveCmd.Run = func(cmd *cobra.Command, args []string) {
+		if !configOptions.DryRun {
+			if err := serveRun(cmd.Flags(), kubefactory, rootOpts); err != nil {
+				logger.WithError(err).Fatal("exiting")
+			}
+		}
+	}
+
+	return serveCmd
+}
+
+func serveRun(f *pflag.FlagSet, kubefactory factory.Factory, rootOpts *RootOptions) error {
+	addr, _ := f.GetString("addr")
+	processorName, _ := f.GetString("processor")
+	workers, _ := f.GetInt("workers")
+	queueSize, _ := f.GetInt("queue-size")
+	workDir, _ := f.GetString("work-dir")
+	retention, _ := f.GetDuration("retention")
+	maxCompleted, _ := f.GetInt("max-completed")
+	if workers <= 0 {
+		return fmt.Errorf("--workers must be greater than 0")
+	}
+	if queueSize < 0 {
+		return fmt.Errorf("--queue-size must not be negative")
+	}
+	if retention <= 0 {
+		return fmt.Errorf("--retention must be greater than 0")
+	}
+	if maxCompleted <= 0 {
+		return fmt.Errorf("--max-completed must be greater than 0")
+	}
+
+	var processor driverbuilder.BuildProcessor
+	switch processorName {
+	case driverbuilder.DockerBuildProcessorName:
+		processor = driverbuilder.NewDockerBuildProcessor(viper.GetInt("timeout"), viper.GetString("proxy"), caCert(), registryCredentials(f), dockerHost(f), "")
+	case driverbuilder.KubernetesBuildProcessorName:
+		var err error
+		if processor, err = newKubernetesProcessor(f, kubefactory); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid --processor %s, it must be docker or kubernetes", processorName)
+	}
+	processor, done, err := dryRunOr(processor)
+	if err != nil {
+		return err
+	}
+	defer done()
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		return err
+	}
+
+	ctx := signals.WithStandardSignals(context.Background())
+	if err := serveMetrics(ctx); err != nil {
+		return err
+	}
+	s := server.New(processor, serveBuild(rootOpts), server.Options{
+		Workers:      workers,
+		QueueSize:    queueSize,
+		WorkDir:      workDir,
+		Retention:    retention,
+		MaxCompleted: maxCompleted,
+	})
+	stopped := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(stopped)
+	}()
+
+	httpServer := &http.Server{Addr: addr, Handler: s}
+	go func() {
+		<-ctx.Done()
+		httpServer.Shutdown(context.Background())
+	}()
+	logger.
+		WithField("addr", addr).
+		WithField("processor", processorName).
+		WithField("workers", workers).
+		Info("serving builds")
+	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	// the builds running are canceled, wait for their pods or containers to be cleaned up
+	<-stopped
+	return nil
+}
+
+// serveBuild returns the builds of the requests on top of the root options, their artifacts written into the directory of each.
+func serveBuild(rootOpts *RootOptions) server.BuildFunc {
+	return func(req server.BuildRequest, dir string) (*builder.Build, error) {
+		opts := *rootOpts
+		overrideOption(&opts.Target, req.Target)
+		overrideOption(&opts.Architecture, req.Architecture)
+		overrideOption(&opts.KernelRelease, req.KernelRelease)
+		overrideOption(&opts.KernelVersion, req.KernelVersion)
+		overrideOption(&opts.KernelConfigData, req.KernelConfigData)
+		overrideOption(&opts.DriverVersion, req.DriverVersion)
+		// the kernel packages of the options are the ones of a single kernel, the targets resolve the ones of each request
+		opts.KernelUrls = nil
+		opts.Output.Module = filepath.Join(dir, opts.ModuleDriverName+".ko")
+		opts.Output.Probe = filepath.Join(dir, opts.ModuleDriverName+".o")
+		b, err := opts.validBuild()
+		if err != nil {
+			return nil, fmt.Errorf("invalid build: %s", err)
+		}
+		return b, nil
+	}
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/server"
+)
+
+func TestServeBuild(t *testing.T) {
+	rootOpts := NewRootOptions()
+	rootOpts.Architecture = "amd64"
+	rootOpts.BuilderImage = "falcosecurity/driverkit-builder:latest"
+	rootOpts.DriverVersion = "2.0.0"
+	rootOpts.KernelUrls = []string{"https://example.org/linux-headers.deb"}
+
+	newBuild := serveBuild(rootOpts)
+	b, err := newBuild(server.BuildRequest{
+		Target:        "ubuntu-generic",
+		KernelRelease: "5.15.0-25-generic",
+		KernelVersion: "26",
+	}, "/tmp/builds/1")
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if b.TargetType != "ubuntu-generic" || b.DriverVersion != "2.0.0" || b.Architecture != "amd64" || len(b.KernelUrls) != 0 {
+		t.Errorf("Got: [ %+v ] / Want: [ the build of the request on top of the options ]", b)
+	}
+	if b.ModuleFilePath != "/tmp/builds/1/falco.ko" || b.ProbeFilePath != "/tmp/builds/1/falco.o" {
+		t.Errorf("Got: [ %s, %s ] / Want: [ the artifacts into the directory of the build ]", b.ModuleFilePath, b.ProbeFilePath)
+	}
+
+	if _, err := newBuild(server.BuildRequest{Target: "ubuntu-generic"}, "/tmp/builds/2"); err == nil {
+		t.Errorf("Got: [ no error ] / Want: [ the kernel release is required ]")
+	}
+}
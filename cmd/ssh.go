@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
+	homedir "github.com/mitchellh/go-homedir"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// NewSSHCmd creates the `driverkit ssh` command.
+func NewSSHCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Command {
+	sshCmd := &cobra.Command{
+		Use:   "ssh",
+		Short: "Build Falco kernel modules and eBPF probes on a remote host reached through SSH.",
+	}
+
+	knownHosts := "$HOME/.ssh/known_hosts"
+	if home, err := homedir.Dir(); err == nil {
+		knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	sshCmd.PersistentFlags().String("ssh-host", "", "build host, as [user@]host[:port]")
+	sshCmd.PersistentFlags().String("ssh-jump", "", "bastion the build host is reached through, as [user@]host[:port]")
+	sshCmd.PersistentFlags().String("ssh-key", "", "private key to authenticate with, the keys of the ssh agent are tried too")
+	sshCmd.PersistentFlags().String("ssh-known-hosts", knownHosts, "known hosts file the host keys are verified against")
+	sshCmd.PersistentFlags().Bool("ssh-docker", false, "run the build into a container of the builder image on the build host, rather than directly on it")
+	// Add root flags
+	sshCmd.PersistentFlags().AddFlagSet(rootFlags)
+
+	sshCmd.Run = func(cmd *cobra.Command, args []string) {
+		logger.WithField("processor", cmd.Name()).Info("driver building, it will take a few seconds")
+		if !configOptions.DryRun {
+			if err := sshRun(cmd, rootOpts); err != nil {
+				fatalBuild(err)
+			}
+		}
+	}
+
+	return sshCmd
+}
+
+func sshRun(cmd *cobra.Command, rootOpts *RootOptions) error {
+	f := cmd.Flags()
+	opts := driverbuilder.RemoteSSHOptions{}
+	var err error
+	if opts.Host, err = f.GetString("ssh-host"); err != nil {
+		return err
+	}
+	if len(opts.Host) == 0 {
+		return fmt.Errorf("the build host is required, provide it with --ssh-host")
+	}
+	if opts.Jump, err = f.GetString("ssh-jump"); err != nil {
+		return err
+	}
+	if opts.KeyFile, err = f.GetString("ssh-key"); err != nil {
+		return err
+	}
+	if opts.KnownHosts, err = f.GetString("ssh-known-hosts"); err != nil {
+		return err
+	}
+	if opts.Docker, err = f.GetBool("ssh-docker"); err != nil {
+		return err
+	}
+
+	buildProcessor := driverbuilder.NewRemoteSSHBuildProcessor(viper.GetInt("timeout"), viper.GetString("proxy"), caCert(), opts)
+
+	return runBuild(buildProcessor, rootOpts.toBuild())
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// targetInfo describes a target, what its builder supports and the inputs it requires.
+type targetInfo struct {
+	Target           string `json:"target" yaml:"target"`
+	builder.Metadata `yaml:",inline"`
+}
+
+// NewTargetsCmd creates the `driverkit targets` command.
+func NewTargetsCmd() *cobra.Command {
+	targetsCmd := &cobra.Command{
+		Use:         "targets",
+		Short:       "List the supported targets, their architectures and the inputs they require.",
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{skipRootValidation: "true"},
+		RunE: func(c *cobra.Command, args []string) error {
+			output, _ := c.Flags().GetString("output")
+			return writeTargets(c.OutOrStdout(), output, supportedTargets())
+		},
+	}
+	targetsCmd.Flags().StringP("output", "o", "table", "output format: table, json or yaml")
+	targetsCmd.RegisterFlagCompletionFunc("output", func(c *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{"table", "json", "yaml"}, cobra.ShellCompDirectiveNoFileComp
+	})
+	return targetsCmd
+}
+
+// supportedTargets returns the targets sorted by name.
+func supportedTargets() []targetInfo {
+	targets := []targetInfo{}
+	for t, b := range builder.BuilderByTarget {
+		targets = append(targets, targetInfo{Target: t.String(), Metadata: builder.MetadataOf(b)})
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].Target < targets[j].Target
+	})
+	return targets
+}
+
+func writeTargets(w io.Writer, format string, targets []targetInfo) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(targets)
+	case "yaml":
+		enc := yaml.NewEncoder(w)
+		defer enc.Close()
+		return enc.Encode(targets)
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "TARGET\tARCHITECTURES\tKERNELVERSION\tKERNELCONFIGDATA\tMODULE\tPROBE")
+		for _, t := range targets {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", t.Target, strings.Join(t.Architectures, ","), required(t.RequiresKernelVersion), required(t.RequiresKernelConfigData), yesNo(t.Module), yesNo(t.Probe))
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unsupported output format %s", format)
+}
+
+func required(b bool) string {
+	if b {
+		return "required"
+	}
+	return "optional"
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
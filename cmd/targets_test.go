@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestTargetsJSON(t *testing.T) {
+	c := NewTargetsCmd()
+	var out bytes.Buffer
+	c.SetOut(&out)
+	c.SetArgs([]string{"-o", "json"})
+	if err := c.Execute(); err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	targets := []targetInfo{}
+	if err := json.Unmarshal(out.Bytes(), &targets); err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	found := map[string]targetInfo{}
+	for _, target := range targets {
+		found[target.Target] = target
+	}
+	if vanilla, ok := found["vanilla"]; !ok || !vanilla.RequiresKernelConfigData || vanilla.RequiresKernelVersion {
+		t.Errorf("Got: %+v / Want: vanilla requiring the kernel config data only", vanilla)
+	}
+	if ubuntu, ok := found["ubuntu"]; !ok || !ubuntu.RequiresKernelVersion || !ubuntu.Module || !ubuntu.Probe {
+		t.Errorf("Got: %+v / Want: ubuntu requiring the kernel version, building both the artifacts", ubuntu)
+	}
+}
+
+func TestTargetsInvalidOutput(t *testing.T) {
+	c := NewTargetsCmd()
+	c.SetOut(&bytes.Buffer{})
+	c.SetErr(&bytes.Buffer{})
+	c.SetArgs([]string{"-o", "xml"})
+	if err := c.Execute(); err == nil {
+		t.Errorf("Got no error / Want: the output format not being supported")
+	}
+}
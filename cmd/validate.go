@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// NewValidateCmd creates the `driverkit validate` command.
+func NewValidateCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Command {
+	validateCmd := &cobra.Command{
+		Use:         "validate",
+		Short:       "Validate the build options, e.g. of a config file, printing all their problems at once.",
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{skipRootValidation: "true"},
+		// The problems are the output, the usage would only bury them
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if configOptions.configFile.batch() {
+				return writeProblems(c.OutOrStdout(), batchProblems(configOptions.configFile.builds(), rootOpts))
+			}
+			return writeProblems(c.OutOrStdout(), rootOpts.problems())
+		},
+	}
+	validateCmd.PersistentFlags().AddFlagSet(rootFlags)
+	return validateCmd
+}
+
+// batchProblems returns the problems of the builds of the values, on top of the root options, telling the build of each one.
+func batchProblems(values []map[string]interface{}, rootOpts *RootOptions) []error {
+	problems := []error{}
+	for i, v := range values {
+		e, err := toBatchEntry(v)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("build %d: %s", i+1, err))
+			continue
+		}
+		opts := e.options(rootOpts)
+		for _, p := range opts.problems() {
+			problems = append(problems, fmt.Errorf("build %d: %s", i+1, p))
+		}
+	}
+	return problems
+}
+
+// writeProblems prints the problems one per line, failing when there is any.
+func writeProblems(w io.Writer, problems []error) error {
+	if len(problems) == 0 {
+		fmt.Fprintln(w, "the build options are valid")
+		return nil
+	}
+	for _, p := range problems {
+		fmt.Fprintf(w, "- %s\n", p)
+	}
+	if len(problems) == 1 {
+		return fmt.Errorf("1 problem found")
+	}
+	return fmt.Errorf("%d problems found", len(problems))
+}
@@ -0,0 +1,180 @@
+// Package autodetect detects the target and the kernel of the local machine, so to build the drivers for it.
+package autodetect
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+// Result is what is detected of the local machine.
+type Result struct {
+	Target        builder.Type
+	KernelRelease string
+	KernelVersion string
+	// KernelConfigData is the base64 encoded kernel config, only detected for the targets needing it.
+	KernelConfigData string
+	// KernelConfigFile is the file of the local machine the kernel config is read from, e.g. /proc/config.gz.
+	KernelConfigFile string
+}
+
+// targetsByID maps the ID of /etc/os-release to the target, when it does not depend on the version.
+var targetsByID = map[string]builder.Type{
+	"almalinux":           builder.TargetTypeAlmaLinux,
+	"alpine":              builder.TargetTypeAlpine,
+	"arch":                builder.TargetTypeArchlinux,
+	"bottlerocket":        builder.TargetTypeBottlerocket,
+	"centos":              builder.TargetTypeCentos,
+	"cos":                 builder.TargetTypeCos,
+	"debian":              builder.TargetTypeDebian,
+	"fedora":              builder.TargetTypeFedora,
+	"flatcar":             builder.TargetTypeFlatcar,
+	"gentoo":              builder.TargetTypeGentoo,
+	"mariner":             builder.TargetTypeMariner,
+	"ol":                  builder.TargetTypeOracleLinux,
+	"openEuler":           builder.TargetTypeOpenEuler,
+	"opensuse-leap":       builder.TargetTypeSuse,
+	"opensuse-tumbleweed": builder.TargetTypeSuse,
+	"photon":              builder.TargetTypePhoton,
+	"raspbian":            builder.TargetTypeRaspios,
+	"rhel":                builder.TargetTypeRedhat,
+	"rocky":               builder.TargetTypeRocky,
+	"sles":                builder.TargetTypeSuse,
+	"talos":               builder.TargetTypeTalos,
+	"ubuntu":              builder.TargetTypeUbuntu,
+}
+
+// kernelConfigTargets are the targets needing the kernel config.
+var kernelConfigTargets = map[builder.Type]bool{
+	builder.TargetTypeVanilla: true,
+	builder.TargetTypeGentoo:  true,
+	builder.TargetTypeTalos:   true,
+}
+
+// Detect detects the local machine, reading its files under the root directory, / but in tests.
+// The machines whose distribution is not supported are detected as vanilla ones.
+func Detect(root string) (*Result, error) {
+	osRelease, err := readOSRelease(root)
+	if err != nil {
+		return nil, err
+	}
+	release, err := readTrimmed(filepath.Join(root, "/proc/sys/kernel/osrelease"))
+	if err != nil {
+		return nil, err
+	}
+	version, err := readTrimmed(filepath.Join(root, "/proc/sys/kernel/version"))
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Result{
+		Target:        targetFromOSRelease(osRelease),
+		KernelRelease: release,
+		KernelVersion: "1",
+	}
+	if kv := kernelrelease.FromUname(release, version).KernelVersion; len(kv) > 0 {
+		res.KernelVersion = kv
+	}
+	// the targets identifying the kernel by the distribution release
+	switch res.Target {
+	case builder.TargetTypeFlatcar:
+		res.KernelRelease = osRelease["VERSION_ID"]
+	case builder.TargetTypeCos:
+		res.KernelVersion = osRelease["BUILD_ID"]
+	case builder.TargetTypeBottlerocket:
+		res.KernelVersion = osRelease["VARIANT_ID"]
+	case builder.TargetTypeTalos:
+		res.KernelVersion = osRelease["VERSION_ID"]
+	}
+
+	if kernelConfigTargets[res.Target] {
+		config, file, err := readKernelConfig(root, release)
+		if err != nil {
+			return nil, err
+		}
+		res.KernelConfigData = base64.StdEncoding.EncodeToString(config)
+		res.KernelConfigFile = file
+	}
+	return res, nil
+}
+
+// targetFromOSRelease returns the target of the distribution, vanilla when not supported.
+func targetFromOSRelease(osRelease map[string]string) builder.Type {
+	id := osRelease["ID"]
+	if id == "amzn" {
+		switch osRelease["VERSION_ID"] {
+		case "2":
+			return builder.TargetTypeAmazonLinux2
+		case "2022":
+			return builder.TargetTypeAmazonLinux2022
+		case "2023":
+			return builder.TargetTypeAmazonLinux2023
+		}
+		return builder.TargetTypeAmazonLinux
+	}
+	if target, ok := targetsByID[id]; ok {
+		return target
+	}
+	return builder.TargetTypeVanilla
+}
+
+// readOSRelease reads the KEY=value pairs of /etc/os-release, falling back to /usr/lib/os-release.
+func readOSRelease(root string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(root, "/etc/os-release"))
+	if os.IsNotExist(err) {
+		data, err = ioutil.ReadFile(filepath.Join(root, "/usr/lib/os-release"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	osRelease := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		osRelease[kv[0]] = strings.Trim(kv[1], `"'`)
+	}
+	return osRelease, scanner.Err()
+}
+
+// readKernelConfig reads the config of the running kernel, from /proc/config.gz or from /boot, returning the file read too.
+func readKernelConfig(root string, release string) ([]byte, string, error) {
+	if f, err := os.Open(filepath.Join(root, "/proc/config.gz")); err == nil {
+		defer f.Close()
+		r, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid /proc/config.gz: %s", err)
+		}
+		config, err := ioutil.ReadAll(r)
+		return config, "/proc/config.gz", err
+	}
+	file := filepath.Join("/boot", "config-"+release)
+	config, err := ioutil.ReadFile(filepath.Join(root, file))
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to find the kernel config in /proc/config.gz nor in /boot/config-%s", release)
+	}
+	return config, file, nil
+}
+
+func readTrimmed(name string) (string, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,118 @@
+package autodetect
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+)
+
+// fakeRoot writes the files of a local machine under a temporary root directory.
+func fakeRoot(t *testing.T, files map[string]string) string {
+	root := t.TempDir()
+	for name, content := range files {
+		name = filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func gzipped(t *testing.T, content string) string {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	return buf.String()
+}
+
+func TestDetect(t *testing.T) {
+	tests := map[string]struct {
+		files map[string]string
+		want  Result
+	}{
+		"ubuntu": {
+			files: map[string]string{
+				"/etc/os-release":            "NAME=\"Ubuntu\"\nID=ubuntu\nVERSION_ID=\"22.04\"\n",
+				"/proc/sys/kernel/osrelease": "5.15.0-25-generic\n",
+				"/proc/sys/kernel/version":   "#25-Ubuntu SMP Wed Mar 30 15:54:22 UTC 2022\n",
+			},
+			want: Result{Target: builder.TargetTypeUbuntu, KernelRelease: "5.15.0-25-generic", KernelVersion: "25"},
+		},
+		"amazonlinux2": {
+			files: map[string]string{
+				"/usr/lib/os-release":        "ID=\"amzn\"\nVERSION_ID=\"2\"\n",
+				"/proc/sys/kernel/osrelease": "4.14.186-146.268.amzn2.x86_64\n",
+				"/proc/sys/kernel/version":   "#1 SMP Tue Jul 14 18:16:52 UTC 2020\n",
+			},
+			want: Result{Target: builder.TargetTypeAmazonLinux2, KernelRelease: "4.14.186-146.268.amzn2.x86_64", KernelVersion: "1"},
+		},
+		"flatcar": {
+			files: map[string]string{
+				"/etc/os-release":            "ID=flatcar\nVERSION_ID=3185.0.0\n",
+				"/proc/sys/kernel/osrelease": "5.15.32-flatcar\n",
+				"/proc/sys/kernel/version":   "#1 SMP Mon Apr 4 17:44:59 -00 2022\n",
+			},
+			want: Result{Target: builder.TargetTypeFlatcar, KernelRelease: "3185.0.0", KernelVersion: "1"},
+		},
+		"bottlerocket": {
+			files: map[string]string{
+				"/etc/os-release":            "ID=bottlerocket\nVARIANT_ID=aws-k8s-1.24\n",
+				"/proc/sys/kernel/osrelease": "5.15.59\n",
+				"/proc/sys/kernel/version":   "#1 SMP Wed Sep 7 20:45:29 UTC 2022\n",
+			},
+			want: Result{Target: builder.TargetTypeBottlerocket, KernelRelease: "5.15.59", KernelVersion: "aws-k8s-1.24"},
+		},
+		"unsupported with /proc/config.gz": {
+			files: map[string]string{
+				"/etc/os-release":            "ID=unknown\n",
+				"/proc/sys/kernel/osrelease": "5.10.0\n",
+				"/proc/sys/kernel/version":   "#3 SMP PREEMPT\n",
+				"/proc/config.gz":            gzipped(t, "CONFIG_X=y\n"),
+			},
+			want: Result{Target: builder.TargetTypeVanilla, KernelRelease: "5.10.0", KernelVersion: "3", KernelConfigData: base64.StdEncoding.EncodeToString([]byte("CONFIG_X=y\n")), KernelConfigFile: "/proc/config.gz"},
+		},
+		"gentoo with /boot config": {
+			files: map[string]string{
+				"/etc/os-release":             "ID=gentoo\n",
+				"/proc/sys/kernel/osrelease":  "5.15.41-gentoo\n",
+				"/proc/sys/kernel/version":    "#1 SMP\n",
+				"/boot/config-5.15.41-gentoo": "CONFIG_Y=m\n",
+			},
+			want: Result{Target: builder.TargetTypeGentoo, KernelRelease: "5.15.41-gentoo", KernelVersion: "1", KernelConfigData: base64.StdEncoding.EncodeToString([]byte("CONFIG_Y=m\n")), KernelConfigFile: "/boot/config-5.15.41-gentoo"},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := Detect(fakeRoot(t, test.files))
+			if err != nil {
+				t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+			}
+			if *got != test.want {
+				t.Errorf("Got: [ %+v ] / Want: [ %+v ]", *got, test.want)
+			}
+		})
+	}
+}
+
+func TestDetectMissingKernelConfig(t *testing.T) {
+	root := fakeRoot(t, map[string]string{
+		"/etc/os-release":            "ID=gentoo\n",
+		"/proc/sys/kernel/osrelease": "5.15.41-gentoo\n",
+		"/proc/sys/kernel/version":   "#1 SMP\n",
+	})
+	if _, err := Detect(root); err == nil {
+		t.Errorf("Expecting an error")
+	}
+}
@@ -0,0 +1,87 @@
+package driverbuilder
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+// ErrBatchSkipped is the error of the builds of a batch not run, because a previous one failed or the batch was canceled.
+var ErrBatchSkipped = errors.New("build skipped")
+
+// BatchResult is the outcome of a build of a batch.
+type BatchResult struct {
+	Build    *builder.Build
+	Report   *BuildReport
+	Err      error
+	Duration time.Duration
+}
+
+// RunBatch runs the builds with the processor, jobs of them at a time, and returns their results in the very same order.
+// Unless continueOnError is set, the builds not started yet are skipped as soon as one fails.
+// The builds share the HTTP client, and the index cache, they are stopped when the context is canceled.
+func RunBatch(ctx context.Context, bp BuildProcessor, builds []*builder.Build, jobs int, continueOnError bool) []BatchResult {
+	if jobs <= 0 {
+		jobs = 1
+	}
+	results := make([]BatchResult, len(builds))
+	for i, b := range builds {
+		results[i] = BatchResult{Build: b, Err: ErrBatchSkipped}
+	}
+
+	var failed bool
+	var mu sync.Mutex
+	// stopped tells whether the builds left must be skipped
+	stopped := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return (failed && !continueOnError) || ctx.Err() != nil
+	}
+	indexes := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < jobs && w < len(builds); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if stopped() {
+					continue
+				}
+				b := builds[i]
+				// the processor logs with the same build ID
+				id := string(uuid.NewUUID())
+				log := builder.Logger(ctx).WithFields(buildFields(id, b))
+				log.Info("starting build")
+				start := time.Now()
+				report, err := bp.Start(WithBuildID(ctx, id), b)
+				results[i].Report = report
+				results[i].Err = err
+				results[i].Duration = time.Since(start)
+				if err != nil {
+					log.WithError(err).Error("build failed")
+					mu.Lock()
+					failed = true
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+feed:
+	for i := range builds {
+		if stopped() {
+			break
+		}
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	return results
+}
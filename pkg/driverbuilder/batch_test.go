@@ -0,0 +1,93 @@
+package driverbuilder
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+)
+
+// fakeBuildProcessor sleeps for each build, failing the ones of the given kernel releases.
+type fakeBuildProcessor struct {
+	delay   time.Duration
+	fail    map[string]bool
+	running int32
+	max     int32
+}
+
+func (bp *fakeBuildProcessor) String() string {
+	return "fake"
+}
+
+func (bp *fakeBuildProcessor) Start(ctx context.Context, b *builder.Build) (*BuildReport, error) {
+	running := atomic.AddInt32(&bp.running, 1)
+	defer atomic.AddInt32(&bp.running, -1)
+	for {
+		max := atomic.LoadInt32(&bp.max)
+		if running <= max || atomic.CompareAndSwapInt32(&bp.max, max, running) {
+			break
+		}
+	}
+	time.Sleep(bp.delay)
+	if bp.fail[b.KernelRelease] {
+		return nil, fmt.Errorf("build of %s failed", b.KernelRelease)
+	}
+	return nil, nil
+}
+
+func batchBuilds(releases ...string) []*builder.Build {
+	builds := []*builder.Build{}
+	for _, r := range releases {
+		builds = append(builds, &builder.Build{TargetType: builder.TargetTypeVanilla, KernelRelease: r})
+	}
+	return builds
+}
+
+func TestRunBatchJobs(t *testing.T) {
+	bp := &fakeBuildProcessor{delay: 50 * time.Millisecond}
+	builds := batchBuilds("5.10.0", "5.11.0", "5.12.0", "5.13.0", "5.14.0")
+
+	results := RunBatch(context.Background(), bp, builds, 2, false)
+	if len(results) != len(builds) {
+		t.Fatalf("Got: [ %d results ] / Want: [ %d ]", len(results), len(builds))
+	}
+	for i, res := range results {
+		if res.Build != builds[i] || res.Err != nil {
+			t.Errorf("Result %d | Got: [ %s, %v ] / Want: [ %s, no error ]", i, res.Build.KernelRelease, res.Err, builds[i].KernelRelease)
+		}
+	}
+	if got := atomic.LoadInt32(&bp.max); got != 2 {
+		t.Errorf("Concurrent builds | Got: [ %d ] / Want: [ 2 ]", got)
+	}
+}
+
+func TestRunBatchFailure(t *testing.T) {
+	bp := &fakeBuildProcessor{fail: map[string]bool{"5.11.0": true}}
+	builds := batchBuilds("5.10.0", "5.11.0", "5.12.0")
+
+	results := RunBatch(context.Background(), bp, builds, 1, false)
+	if results[0].Err != nil || results[1].Err == nil || results[2].Err != ErrBatchSkipped {
+		t.Errorf("Got: [ %v, %v, %v ] / Want: [ no error, the failure, skipped ]", results[0].Err, results[1].Err, results[2].Err)
+	}
+
+	results = RunBatch(context.Background(), bp, builds, 1, true)
+	if results[0].Err != nil || results[1].Err == nil || results[2].Err != nil {
+		t.Errorf("Got: [ %v, %v, %v ] / Want: [ no error, the failure, no error ]", results[0].Err, results[1].Err, results[2].Err)
+	}
+}
+
+func TestRunBatchCanceled(t *testing.T) {
+	bp := &fakeBuildProcessor{delay: 50 * time.Millisecond}
+	builds := batchBuilds("5.10.0", "5.11.0", "5.12.0")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i, res := range RunBatch(ctx, bp, builds, 1, true) {
+		if res.Err != ErrBatchSkipped {
+			t.Errorf("Result %d | Got: [ %v ] / Want: [ %v ]", i, res.Err, ErrBatchSkipped)
+		}
+	}
+}
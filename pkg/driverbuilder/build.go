@@ -0,0 +1,341 @@
+package driverbuilder
+
+import (
+	"runtime"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+)
+
+// BuildOption configures a build created with NewBuild.
+type BuildOption func(b *builder.Build)
+
+// NewBuild returns a build with the defaults of the CLI, configured by the options and validated with ValidateBuild.
+// The build can be given to the Start of any BuildProcessor, e.g. a DockerBuildProcessor or a KubernetesBuildProcessor.
+func NewBuild(opts ...BuildOption) (*builder.Build, error) {
+	b := &builder.Build{
+		DriverVersion:      "master",
+		KernelVersion:      "1",
+		Architecture:       runtime.GOARCH,
+		KernelConfigData:   builder.NoKernelConfigData,
+		ModuleDriverName:   "falco",
+		ModuleDeviceName:   "falco",
+		CustomBuilderImage: BuilderBaseImage,
+		Checksum:           "sha256",
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	if err := ValidateBuild(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// WithTarget sets the target of the build, e.g. builder.TargetTypeUbuntu.
+func WithTarget(target builder.Type) BuildOption {
+	return func(b *builder.Build) {
+		b.TargetType = target
+	}
+}
+
+// WithKernel sets the kernel to build for, its release as given by 'uname -r' and its version,
+// the numeric value after the hash of 'uname -v'.
+func WithKernel(release string, version string) BuildOption {
+	return func(b *builder.Build) {
+		b.KernelRelease = release
+		b.KernelVersion = version
+	}
+}
+
+// WithKernelConfigData sets the base64 encoded config of the kernel, required by some targets, e.g. vanilla.
+func WithKernelConfigData(data string) BuildOption {
+	return func(b *builder.Build) {
+		b.KernelConfigData = data
+	}
+}
+
+// WithArchitecture sets the architecture to build for, amd64, arm64, ppc64le, s390x, riscv64 or arm.
+func WithArchitecture(arch string) BuildOption {
+	return func(b *builder.Build) {
+		b.Architecture = arch
+	}
+}
+
+// WithPackageArchitecture sets the architecture of the kernel packages of the arm builds, armhf or armel,
+// when the flavor of the kernel release does not tell it.
+func WithPackageArchitecture(arch string) BuildOption {
+	return func(b *builder.Build) {
+		b.PackageArchitecture = arch
+	}
+}
+
+// WithDriverVersion sets the version of the driver to build, as a git commit hash or as a git tag.
+func WithDriverVersion(version string) BuildOption {
+	return func(b *builder.Build) {
+		b.DriverVersion = version
+	}
+}
+
+// WithModuleOutput builds the kernel module, into the path.
+func WithModuleOutput(path string) BuildOption {
+	return func(b *builder.Build) {
+		b.ModuleFilePath = path
+	}
+}
+
+// WithProbeOutput builds the eBPF probe, into the path.
+func WithProbeOutput(path string) BuildOption {
+	return func(b *builder.Build) {
+		b.ProbeFilePath = path
+	}
+}
+
+// WithModernProbeOutput builds the skeleton of the modern eBPF probe, into the path.
+func WithModernProbeOutput(path string) BuildOption {
+	return func(b *builder.Build) {
+		b.ModernProbeFilePath = path
+	}
+}
+
+// WithBTFOutput generates the raw BTF of the kernel, into the path, when its debug package is found.
+func WithBTFOutput(path string) BuildOption {
+	return func(b *builder.Build) {
+		b.BTFFilePath = path
+	}
+}
+
+// WithDKMSOutput assembles the DKMS source package of the kernel module, into the path, a .tar.gz.
+func WithDKMSOutput(path string) BuildOption {
+	return func(b *builder.Build) {
+		b.DKMSFilePath = path
+	}
+}
+
+// WithEnv sets the variable for the build script, exported before building and set on the builder container or pod.
+func WithEnv(name, value string) BuildOption {
+	return func(b *builder.Build) {
+		if b.Env == nil {
+			b.Env = map[string]string{}
+		}
+		b.Env[name] = value
+	}
+}
+
+// WithMakeFlags gives the flags to every make invocation of the build script, e.g. -j8.
+func WithMakeFlags(flags string) BuildOption {
+	return func(b *builder.Build) {
+		b.MakeFlags = flags
+	}
+}
+
+// WithModuleNames sets the names of the kernel module, as reported by lsmod, and of its devices under /dev,
+// the devices are named after the kernel module when their name is empty.
+func WithModuleNames(driverName string, deviceName string) BuildOption {
+	return func(b *builder.Build) {
+		if len(deviceName) == 0 {
+			deviceName = driverName
+		}
+		b.ModuleDriverName = driverName
+		b.ModuleDeviceName = deviceName
+	}
+}
+
+// WithImageRepo selects the image the build runs into from the repository, by target, architecture and gcc version, see BuilderImageTag.
+func WithImageRepo(repo string) BuildOption {
+	return func(b *builder.Build) {
+		b.ImageRepo = repo
+	}
+}
+
+// WithBuilderImage sets the image the build runs into.
+func WithBuilderImage(image string) BuildOption {
+	return func(b *builder.Build) {
+		b.CustomBuilderImage = image
+	}
+}
+
+// WithKernelURLs sets the kernel packages to build against, instead of the ones the target looks for.
+func WithKernelURLs(urls ...string) BuildOption {
+	return func(b *builder.Build) {
+		b.KernelUrls = urls
+	}
+}
+
+// WithKernelURLsAuth authenticates the downloads of the kernel URLs with the basic credentials, as user:password.
+func WithKernelURLsAuth(auth string) BuildOption {
+	return func(b *builder.Build) {
+		b.KernelURLsAuth = auth
+	}
+}
+
+// WithKernelURLsToken authenticates the downloads of the kernel URLs with the bearer token.
+func WithKernelURLsToken(token string) BuildOption {
+	return func(b *builder.Build) {
+		b.KernelURLsToken = token
+	}
+}
+
+// WithMirror probes the mirror for the kernel packages of the target before its built-in ones, it has their same layout, see builder.Mirrors.
+func WithMirror(target builder.Type, url string) BuildOption {
+	return func(b *builder.Build) {
+		if b.Mirrors == nil {
+			b.Mirrors = map[string][]string{}
+		}
+		b.Mirrors[target.String()] = append(b.Mirrors[target.String()], url)
+	}
+}
+
+// WithReplaceMirrors probes the mirrors of the build instead of the built-in ones of their target.
+func WithReplaceMirrors() BuildOption {
+	return func(b *builder.Build) {
+		b.ReplaceMirrors = true
+	}
+}
+
+// WithLocalKernelDir sets the directory containing the kernel packages to build against, instead of downloading them.
+func WithLocalKernelDir(dir string) BuildOption {
+	return func(b *builder.Build) {
+		b.LocalKernelDir = dir
+	}
+}
+
+// WithLLVMVersion sets the LLVM version building the eBPF probe, instead of the one chosen by the target.
+func WithLLVMVersion(version string) BuildOption {
+	return func(b *builder.Build) {
+		b.LLVMVersion = version
+	}
+}
+
+// WithGCCVersion sets the gcc version building the kernel module and the eBPF probe, instead of the one chosen by the target.
+func WithGCCVersion(version string) BuildOption {
+	return func(b *builder.Build) {
+		b.GCCVersion = version
+	}
+}
+
+// WithCcacheDir compiles the kernel module through ccache, caching into the directory:
+// a directory of the docker host or of the kubernetes node, or a persistent volume claim as pvc:<name> on kubernetes.
+func WithCcacheDir(dir string) BuildOption {
+	return func(b *builder.Build) {
+		b.CcacheDir = dir
+	}
+}
+
+// WithCacheDir sets the directory the mirror index pages are cached into between the builds.
+func WithCacheDir(dir string) BuildOption {
+	return func(b *builder.Build) {
+		b.CacheDir = dir
+	}
+}
+
+// WithSkipChecksum does not verify the kernel packages against the checksums published by their repositories.
+func WithSkipChecksum() BuildOption {
+	return func(b *builder.Build) {
+		b.SkipChecksum = true
+	}
+}
+
+// WithKernelConfigFragments merges the kernel config fragments into the kernel config data of the vanilla target,
+// each one given inline, e.g. CONFIG_FTRACE_SYSCALLS=y, or as the path of its file.
+func WithKernelConfigFragments(fragments ...string) BuildOption {
+	return func(b *builder.Build) {
+		b.KernelConfigFragments = append(b.KernelConfigFragments, fragments...)
+	}
+}
+
+// WithVerifyKernelSignature verifies the kernel.org tarball of the vanilla target against its detached signature.
+func WithVerifyKernelSignature() BuildOption {
+	return func(b *builder.Build) {
+		b.VerifyKernelSignature = true
+	}
+}
+
+// WithDriverRepo downloads the driver sources from the GitHub repository, as owner/name, at the driver version.
+func WithDriverRepo(repo string) BuildOption {
+	return func(b *builder.Build) {
+		b.DriverRepo = repo
+	}
+}
+
+// WithDriverUseGit clones the driver sources at the driver version with git rather than downloading their tarball.
+func WithDriverUseGit() BuildOption {
+	return func(b *builder.Build) {
+		b.DriverUseGit = true
+	}
+}
+
+// WithLocalDriverDir builds the driver from the local tree of the libs, e.g. a working copy, instead of downloading its sources.
+func WithLocalDriverDir(dir string) BuildOption {
+	return func(b *builder.Build) {
+		b.LocalDriverDir = dir
+	}
+}
+
+// WithForceEmulation runs the build emulated when its architecture is not the one of the host, instead of cross compiling it.
+func WithForceEmulation() BuildOption {
+	return func(b *builder.Build) {
+		b.ForceEmulation = true
+	}
+}
+
+// WithChecksum sets the algorithm of the checksum files written next to the artifacts, sha256 or sha512, none when empty.
+func WithChecksum(algorithm string) BuildOption {
+	return func(b *builder.Build) {
+		b.Checksum = algorithm
+	}
+}
+
+// WithCompression compresses the artifacts once built, with gzip or xz.
+func WithCompression(algorithm string) BuildOption {
+	return func(b *builder.Build) {
+		b.Compression = algorithm
+	}
+}
+
+// WithModuleSigning signs the kernel module for Secure Boot with the key pair.
+func WithModuleSigning(key string, cert string) BuildOption {
+	return func(b *builder.Build) {
+		b.ModuleSigningKey = key
+		b.ModuleSigningCert = cert
+	}
+}
+
+// WithRHELEntitlement downloads the kernel of the redhat target from the Red Hat CDN with the entitlement certificate and key of a subscription.
+func WithRHELEntitlement(cert string, key string) BuildOption {
+	return func(b *builder.Build) {
+		b.RHELEntitlementCert = cert
+		b.RHELEntitlementKey = key
+	}
+}
+
+// WithS3 uploads the artifacts to the templated s3:// URLs, against the S3 compatible endpoint when given.
+// The artifacts not built are not uploaded, their URL can be empty.
+func WithS3(moduleURL string, probeURL string, endpoint string) BuildOption {
+	return func(b *builder.Build) {
+		b.ModuleS3URL = moduleURL
+		b.ProbeS3URL = probeURL
+		b.S3Endpoint = endpoint
+	}
+}
+
+// WithOCI pushes the artifacts to the templated reference, as an OCI artifact.
+func WithOCI(ref string, insecure bool) BuildOption {
+	return func(b *builder.Build) {
+		b.OCIRef = ref
+		b.OCIInsecure = insecure
+	}
+}
+
+// WithBuildLogFile saves the output of the build script into the templated path, after a header describing the build.
+func WithBuildLogFile(path string) BuildOption {
+	return func(b *builder.Build) {
+		b.BuildLogPath = path
+	}
+}
+
+// WithTemplateOverride replaces the embedded template of the build script of the target with the one of the path.
+func WithTemplateOverride(path string) BuildOption {
+	return func(b *builder.Build) {
+		b.TemplateOverride = path
+	}
+}
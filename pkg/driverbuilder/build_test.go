@@ -0,0 +1,113 @@
+package driverbuilder
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+)
+
+func TestNewBuild(t *testing.T) {
+	b, err := NewBuild(
+		WithTarget(builder.TargetTypeUbuntu),
+		WithKernel("5.15.0-25-generic", "25"),
+		WithArchitecture("arm64"),
+		WithModuleOutput("/tmp/falco.ko"),
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if b.DriverVersion != "master" || b.ModuleDriverName != "falco" || b.CustomBuilderImage != BuilderBaseImage || b.Checksum != "sha256" || b.Architecture != "arm64" {
+		t.Errorf("Got: [ %+v ] / Want: [ the defaults of the CLI, with the options given ]", b)
+	}
+
+	b, err = NewBuild(WithTarget(builder.TargetTypeUbuntu), WithKernel("5.15.0-25-generic", "25"), WithModuleOutput("/tmp/acme.ko"), WithModuleNames("acme", ""))
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if b.ModuleDriverName != "acme" || b.ModuleDeviceName != "acme" {
+		t.Errorf("Got: [ '%s', '%s' ] / Want: [ 'acme', 'acme' ]", b.ModuleDriverName, b.ModuleDeviceName)
+	}
+
+	// the kernel module only builds do not need the LLVM of the eBPF probe
+	if _, err := NewBuild(WithTarget(builder.TargetTypeDebian), WithKernel("6.1.0-17-amd64", "1"), WithModuleOutput("/tmp/falco.ko"), WithLLVMVersion("13")); err != nil {
+		t.Errorf("Unexpected error encountered | Error: '%s'", err)
+	}
+
+	tests := []struct {
+		opts []BuildOption
+		err  string
+	}{
+		{[]BuildOption{WithTarget("unknown"), WithKernel("5.10.0", "1"), WithModuleOutput("/tmp/falco.ko")}, "no builder found for target: unknown"},
+		{[]BuildOption{WithTarget(builder.TargetTypeCentos), WithModuleOutput("/tmp/falco.ko")}, "the kernel release is required"},
+		{[]BuildOption{WithTarget(builder.TargetTypeCentos), WithKernel("5.10.0", "1")}, "the output path of the kernel module, of the eBPF probe or of the modern eBPF probe is required"},
+		{[]BuildOption{WithTarget(builder.TargetTypeCentos), WithKernel("5.10.0", "1"), WithProbeOutput("/tmp/falco.ko")}, "invalid eBPF probe path"},
+		{[]BuildOption{WithTarget(builder.TargetTypeCentos), WithKernel("5.10.0", "1"), WithModernProbeOutput("/tmp/falco.o")}, "invalid modern eBPF probe path"},
+		{[]BuildOption{WithTarget(builder.TargetTypeCentos), WithKernel("4.18.0-372.9.1.el8.x86_64", "1"), WithModernProbeOutput("/tmp/bpf_probe.skel.h")}, "the modern eBPF probe requires a kernel >= 5.8.0 with BTF"},
+		{[]BuildOption{WithTarget(builder.TargetTypeCentos), WithKernel("5.10.0", "1"), WithProbeOutput("/tmp/falco.o"), WithModuleSigning("key.pem", "key.x509")}, "only the kernel module can be signed"},
+		{[]BuildOption{WithTarget(builder.TargetTypeCentos), WithKernel("5.10.0", "1"), WithProbeOutput("/tmp/falco.o"), WithS3("s3://bucket/falco.ko", "", "")}, "only the drivers built can be uploaded"},
+		{[]BuildOption{WithTarget(builder.TargetTypeRedhat), WithKernel("4.18.0-372.9.1.el8.x86_64", "1"), WithModuleOutput("/tmp/falco.ko")}, "target redhat requires a builder image"},
+		{[]BuildOption{WithTarget(builder.TargetTypeRedhat), WithKernel("4.18.0-372.9.1.el8.x86_64", "1"), WithModuleOutput("/tmp/falco.ko"), WithBuilderImage("registry.example.com/ubi8:gcc"), WithRHELEntitlement("entitlement.pem", "")}, "the RHEL entitlement cert and key are required together"},
+		{[]BuildOption{WithTarget(builder.TargetTypeCentos), WithKernel("4.18.0-372.9.1.el8.x86_64", "1"), WithModuleOutput("/tmp/falco.ko"), WithRHELEntitlement("entitlement.pem", "entitlement-key.pem")}, "the RHEL entitlement is only used by the redhat target"},
+		{[]BuildOption{WithTarget(builder.TargetTypeVanilla), WithKernel("5.10.0", "1"), WithModuleOutput("/tmp/falco.ko")}, "target vanilla requires the kernel config data"},
+		{[]BuildOption{WithTarget(builder.TargetTypeVanilla), WithKernel("5.10.0", "1"), WithModuleOutput("/tmp/falco.ko"), WithKernelConfigData("Q09ORklHX0JQRj15Cg=="), WithKernelConfigFragments("CONFIG_FTRACE=y", "# CONFIG_FTRACE is not set")}, "conflicting kernel config fragments, they set different values to: CONFIG_FTRACE"},
+		{[]BuildOption{WithTarget(builder.TargetTypeCentos), WithKernel("5.10.0", "1"), WithModuleOutput("/tmp/falco.ko"), WithKernelConfigFragments("CONFIG_FTRACE=y")}, "the kernel config fragments are only merged by the vanilla target"},
+		{[]BuildOption{WithTarget(builder.TargetTypeCentos), WithKernel("5.10.0", "1"), WithModuleOutput("/tmp/falco.ko"), WithEnv("KCFLAGS=", "-O2")}, "invalid environment variable name"},
+		{[]BuildOption{WithTarget(builder.TargetTypeDebian), WithArchitecture("riscv64"), WithKernel("6.12.6-1-riscv64", "1"), WithModuleOutput("/tmp/falco.ko"), WithGCCVersion("6")}, "the gcc of the riscv64 builds cannot be chosen"},
+		{[]BuildOption{WithTarget(builder.TargetTypeDebian), WithArchitecture("arm"), WithKernel("6.1.0-17-armmp", "1"), WithModuleOutput("/tmp/falco.ko"), WithPackageArchitecture("arm64")}, "invalid package architecture arm64"},
+		{[]BuildOption{WithTarget(builder.TargetTypeDebian), WithKernel("6.1.0-17-amd64", "1"), WithModuleOutput("/tmp/falco.ko"), WithPackageArchitecture("armel")}, "for the arm builds only"},
+		{[]BuildOption{WithTarget(builder.TargetTypeDebian), WithKernel("6.1.0-17-amd64", "1"), WithProbeOutput("/tmp/falco.o"), WithLLVMVersion("13")}, "LLVM 13 is not available in the builder image, it ships 6.0, 7, 12, 14"},
+		{[]BuildOption{WithTarget(builder.TargetTypeCentos), WithKernel("5.10.0", "1"), WithModuleOutput("/tmp/falco.ko"), WithBuilderImage("registry.example.com/builder:1.0"), WithImageRepo("registry.example.com/driverkit")}, "cannot be used together"},
+		{[]BuildOption{WithTarget(builder.TargetTypeCentos), WithKernel("5.10.0", "1"), WithModuleOutput("/tmp/falco.ko"), WithImageRepo("registry.example.com/driverkit:latest")}, "invalid image repository"},
+		{[]BuildOption{WithTarget(builder.TargetTypeCentos), WithKernel("5.10.0", "1"), WithModuleOutput("/tmp/falco.ko"), WithBuilderImage("Registry//builder")}, "invalid builder image"},
+		{[]BuildOption{WithTarget(builder.TargetTypeCentos), WithKernel("5.10.0", "1"), WithModuleOutput("/tmp/falco.ko"), WithCcacheDir("ccache")}, "invalid ccache directory"},
+		{[]BuildOption{WithTarget(builder.TargetTypeCentos), WithKernel("5.10.0", "1"), WithModuleOutput("/tmp/falco.ko"), WithLocalDriverDir("/src/libs"), WithDriverUseGit()}, "the local driver directory cannot be used together"},
+		{[]BuildOption{WithTarget(builder.TargetTypeCentos), WithKernel("5.10.0", "1"), WithModuleOutput("/tmp/falco.ko"), WithKernelURLsToken("t0k3n")}, "the kernel URLs credentials only authenticate the custom kernel URLs"},
+		{[]BuildOption{WithTarget(builder.TargetTypeCentos), WithKernel("5.10.0", "1"), WithModuleOutput("/tmp/falco.ko"), WithKernelURLs("https://artifacts.example.com/kernel-devel.rpm"), WithKernelURLsAuth("user:pass"), WithKernelURLsToken("t0k3n")}, "cannot be used together"},
+		{[]BuildOption{WithTarget(builder.TargetTypeCentos), WithKernel("5.10.0", "1"), WithModuleOutput("/tmp/falco.ko"), WithKernelURLs("https://artifacts.example.com/kernel-devel.rpm"), WithKernelURLsAuth("user")}, "they must be user:password"},
+		{[]BuildOption{WithTarget(builder.TargetTypeCentos), WithKernel("5.10.0", "1"), WithModuleOutput("/tmp/falco.ko"), WithCcacheDir("pvc:Driverkit_Ccache")}, "invalid ccache directory"},
+		{[]BuildOption{WithTarget(builder.TargetTypeCentos), WithKernel("5.10.0", "1"), WithModuleOutput("/tmp/falco.ko"), WithDKMSOutput("/tmp/falco-dkms.zip")}, "invalid DKMS package path"},
+		{[]BuildOption{WithTarget(builder.TargetTypeCentos), WithKernel("5.10.0", "1"), WithProbeOutput("/tmp/falco.o"), WithDKMSOutput("/tmp/falco-dkms.tar.gz")}, "the DKMS package is assembled from the sources of the kernel module"},
+		{[]BuildOption{WithTarget(builder.TargetTypeCentos), WithKernel("5.10.0", "1"), WithModuleOutput("/tmp/falco.ko"), WithMirror(builder.TargetTypeCentos, "https://mirror.example.com/centos")}, "target centos has no mirrors to override"},
+		{[]BuildOption{WithTarget(builder.TargetTypeCentos), WithKernel("5.10.0", "1"), WithModuleOutput("/tmp/falco.ko"), WithMirror("unknown", "https://mirror.example.com")}, "invalid mirror target unknown"},
+		{[]BuildOption{WithTarget(builder.TargetTypeDebian), WithKernel("5.10.0-27-amd64", "1"), WithModuleOutput("/tmp/falco.ko"), WithMirror(builder.TargetTypeDebian, "ftp://mirror.example.com/debian")}, "it must be an http or https URL"},
+		{[]BuildOption{WithTarget(builder.TargetTypeDebian), WithKernel("5.10.0-27-amd64", "1"), WithModuleOutput("/tmp/falco.ko"), WithReplaceMirrors()}, "the built-in mirrors can only be replaced with the mirrors of the build"},
+	}
+	for _, test := range tests {
+		if _, err := NewBuild(test.opts...); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Got: '%v' / Want: '%s'", err, test.err)
+		}
+	}
+}
+
+func TestBuildReportOpen(t *testing.T) {
+	dir := t.TempDir()
+	b := &builder.Build{
+		TargetType:     builder.TargetTypeVanilla,
+		KernelRelease:  "5.10.0",
+		ModuleFilePath: filepath.Join(dir, "falco.ko"),
+	}
+	if err := ioutil.WriteFile(b.ModuleFilePath, []byte("module"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, reporter := startReport(context.Background(), b, BuilderBaseImage)
+	report, err := reporter.complete(context.Background(), b, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+
+	r, err := report.Open(ArtifactModule)
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	defer r.Close()
+	if content, err := ioutil.ReadAll(r); err != nil || string(content) != "module" {
+		t.Errorf("Got: [ %s, %v ] / Want: [ the kernel module ]", content, err)
+	}
+	if _, err := report.Open(ArtifactProbe); err == nil {
+		t.Errorf("Got no error / Want: the build having no eBPF probe")
+	}
+}
@@ -0,0 +1,74 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+// TargetTypeAlmaLinux identifies the AlmaLinux target.
+const TargetTypeAlmaLinux Type = "almalinux"
+
+func init() {
+	BuilderByTarget[TargetTypeAlmaLinux] = &almalinux{}
+}
+
+// almalinux is a driverkit target.
+type almalinux struct {
+}
+
+// almalinuxBaseURLs are the roots of the releases, the older minor ones are moved to the vault.
+var almalinuxBaseURLs = []string{
+	"https://repo.almalinux.org/almalinux",
+	"https://repo.almalinux.org/vault",
+}
+
+// Mirrors implements MirrorsProvider.
+func (c almalinux) Mirrors(arch kernelrelease.Architecture) []string {
+	return almalinuxBaseURLs
+}
+
+// Script compiles the script to build the kernel module and/or the eBPF probe.
+func (c almalinux) Script(cfg Config, kr kernelrelease.KernelRelease, sources KernelSources) (string, error) {
+	return elCloneScript(TargetTypeAlmaLinux, cfg, sources)
+}
+
+// ResolveKernelSources implements Builder, resolving the kernel packages of the build.
+func (c almalinux) ResolveKernelSources(ctx context.Context, cfg Config, kr kernelrelease.KernelRelease) (KernelSources, error) {
+	return resolveELCloneKernelSources(ctx, cfg, kr, func(kr kernelrelease.KernelRelease) ([]string, error) {
+		return fetchAlmaLinuxKernelURLS(ctx, kr)
+	})
+}
+
+var almalinuxVaultReleases = map[string][]string{
+	"8": {"8.10", "8.9", "8.8", "8.7", "8.6", "8.5", "8.4", "8.3"},
+	"9": {"9.4", "9.3", "9.2", "9.1", "9.0"},
+}
+
+func fetchAlmaLinuxKernelURLS(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
+	releases, err := elReleasesFromKernelRelease(kr, almalinuxVaultReleases)
+	if err != nil {
+		return nil, err
+	}
+
+	repos := []string{"BaseOS", "AppStream"}
+
+	urls := []string{}
+	for _, b := range mirrorsFrom(ctx, almalinuxBaseURLs) {
+		for _, r := range releases {
+			for _, repo := range repos {
+				urls = append(urls, fmt.Sprintf(
+					"%s/%s/%s/%s/os/Packages/kernel-devel-%s%s.rpm",
+					b,
+					r,
+					repo,
+					kr.Architecture.ToNonDeb(),
+					kr.Fullversion,
+					kr.FullExtraversion,
+				))
+			}
+		}
+	}
+	return urls, nil
+}
@@ -0,0 +1,162 @@
+package builder
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+//go:embed templates/alpine.sh
+var alpineTemplate string
+
+// TargetTypeAlpine identifies the Alpine target.
+const TargetTypeAlpine Type = "alpine"
+
+func init() {
+	BuilderByTarget[TargetTypeAlpine] = &alpine{}
+}
+
+// alpine is a driverkit target.
+type alpine struct {
+}
+
+type alpineTemplateData struct {
+	buildTemplateData
+	DriverBuildDir    string
+	ModuleDownloadURL string
+	KernelDownloadURL string
+	AlpineRepoURL     string
+	GCCVersion        string
+	LLVMVersion       string
+	ModuleDriverName  string
+	ModuleFullPath    string
+	BuildModule       bool
+	BuildProbe        bool
+	KernelArch        string
+	CrossCompile      string
+}
+
+// Script compiles the script to build the kernel module and/or the eBPF probe.
+func (c alpine) Script(cfg Config, kr kernelrelease.KernelRelease, sources KernelSources) (string, error) {
+	parsed, err := parseTemplate(cfg, string(TargetTypeAlpine), alpineTemplate, alpineTemplateData{})
+	if err != nil {
+		return "", err
+	}
+	return executeTemplate(parsed, sources.TemplateData)
+}
+
+// ResolveKernelSources implements Builder, resolving the kernel packages of the build.
+func (c alpine) ResolveKernelSources(ctx context.Context, cfg Config, kr kernelrelease.KernelRelease) (KernelSources, error) {
+	var urls []string
+	var err error
+	if cfg.KernelUrls == nil {
+		var kurls []string
+		kurls, err = fetchAlpineKernelURLS(kr)
+		if err != nil {
+			return KernelSources{}, err
+		}
+		// Check (and filter) existing kernels before continuing
+		urls, err = getResolvingURLs(ctx, kurls)
+	} else {
+		urls, err = getResolvingURLs(ctx, cfg.KernelUrls)
+	}
+	if err != nil {
+		return KernelSources{}, err
+	}
+
+	crossCompilePrefix, err := crossCompile(cfg)
+	if err != nil {
+		return KernelSources{}, err
+	}
+
+	td := alpineTemplateData{
+		DriverBuildDir:    DriverDirectory,
+		ModuleDownloadURL: moduleDownloadURL(cfg),
+		KernelDownloadURL: urls[0],
+		AlpineRepoURL:     path.Dir(urls[0]),
+		GCCVersion:        gccVersion(cfg, alpineGccVersionFromKernelRelease(kr)),
+		LLVMVersion:       llvmVersion(cfg, alpineLLVMVersionFromKernelRelease(kr)),
+		ModuleDriverName:  cfg.DriverName,
+		ModuleFullPath:    ModuleFullPath,
+		BuildModule:       len(cfg.Build.ModuleFilePath) > 0,
+		BuildProbe:        len(cfg.Build.ProbeFilePath) > 0,
+		KernelArch:        kr.Architecture.ToKernel(),
+		CrossCompile:      crossCompilePrefix,
+	}
+	td.buildTemplateData = newBuildTemplateData(cfg)
+	return KernelSources{URLs: urls[:1], TemplateData: td}, nil
+}
+
+func fetchAlpineKernelURLS(kr kernelrelease.KernelRelease) ([]string, error) {
+	pkgrel, flavor, err := parseAlpineExtraVersion(kr.Extraversion)
+	if err != nil {
+		return nil, err
+	}
+
+	alpineReleases := []string{
+		"edge",
+		"latest-stable",
+		"v3.18",
+		"v3.17",
+		"v3.16",
+		"v3.15",
+		"v3.14",
+		"v3.13",
+	}
+
+	// linux-edge is only shipped in the community repository
+	repos := []string{"main"}
+	if flavor == "edge" {
+		repos = []string{"community"}
+	}
+
+	urls := []string{}
+	for _, r := range alpineReleases {
+		for _, repo := range repos {
+			urls = append(urls, fmt.Sprintf(
+				"https://dl-cdn.alpinelinux.org/alpine/%s/%s/%s/linux-%s-dev-%s-r%s.apk",
+				r,
+				repo,
+				kr.Architecture.ToNonDeb(),
+				flavor,
+				kr.Fullversion,
+				pkgrel,
+			))
+		}
+	}
+	return urls, nil
+}
+
+// parseAlpineExtraVersion splits the extraversion into the package release and the kernel flavor.
+// Example: Input -> "0-lts", Output -> "0", "lts"
+func parseAlpineExtraVersion(extraversion string) (string, string, error) {
+	split := strings.SplitN(extraversion, "-", 2)
+	if len(split) != 2 {
+		return "", "", fmt.Errorf("unable to parse flavor from alpine kernel release extraversion: %s", extraversion)
+	}
+	switch split[1] {
+	case "lts", "virt", "edge":
+		return split[0], split[1], nil
+	}
+	return "", "", fmt.Errorf("unsupported alpine kernel flavor: %s", split[1])
+}
+
+func alpineGccVersionFromKernelRelease(kr kernelrelease.KernelRelease) string {
+	switch kr.Version {
+	default:
+		return "8"
+	}
+}
+
+func alpineLLVMVersionFromKernelRelease(kr kernelrelease.KernelRelease) string {
+	switch kr.Version {
+	case 4:
+		return "7"
+	default:
+		return "12"
+	}
+}
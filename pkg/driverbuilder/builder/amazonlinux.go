@@ -3,24 +3,14 @@ package builder
 import (
 	"bufio"
 	"bytes"
-	"compress/bzip2"
-	"compress/gzip"
+	"context"
 	_ "embed"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"log"
-	"net/http"
-	"os"
+	"regexp"
+	"sort"
 	"strings"
-	"text/template"
-
-	"database/sql"
-
-	_ "modernc.org/sqlite"
 
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
-	logger "github.com/sirupsen/logrus"
 )
 
 //go:embed templates/amazonlinux.sh
@@ -28,12 +18,29 @@ var amazonlinuxTemplate string
 
 type amazonBuilder interface {
 	Builder
-	repos() []string
-	baseUrl() string
-	ext() string
+	// mirrorLists returns the mirror lists of the repositories the kernel is looked for in, in order,
+	// as the mirrorlist of the official repository files, e.g. /etc/yum.repos.d/amzn2-core.repo.
+	mirrorLists(kr kernelrelease.KernelRelease) []string
 	target() Type
 }
 
+var (
+	// amazonlinuxRegion and amazonlinuxDomain expand the $awsregion and $awsdomain variables of the mirror lists.
+	amazonlinuxRegion = "us-east-1"
+	amazonlinuxDomain = "amazonaws.com"
+
+	amazonlinuxMirrorURL     = "http://repo.$awsregion.$awsdomain"
+	amazonlinux2MirrorURL    = "http://amazonlinux.$awsregion.$awsdomain"
+	amazonlinux2022MirrorURL = "https://al2022-repos-$awsregion-9761ab97.s3.dualstack.$awsregion.$awsdomain/core/mirrors"
+	amazonlinux2023MirrorURL = "https://cdn.amazonlinux.com/al2023/core/mirrors"
+)
+
+// amazonlinuxKernelDevelPattern matches the names of the kernel-devel packages, AL2023 ships versioned ones too, e.g. kernel6.1-devel.
+var amazonlinuxKernelDevelPattern = regexp.MustCompile(`^kernel[0-9.]*-devel$`)
+
+type amazonlinux2023 struct {
+}
+
 type amazonlinux2022 struct {
 }
 
@@ -43,6 +50,9 @@ type amazonlinux2 struct {
 type amazonlinux struct {
 }
 
+// TargetTypeAmazonLinux2023 identifies the AmazonLinux2023 target.
+const TargetTypeAmazonLinux2023 Type = "amazonlinux2023"
+
 // TargetTypeAmazonLinux2022 identifies the AmazonLinux2022 target.
 const TargetTypeAmazonLinux2022 Type = "amazonlinux2022"
 
@@ -53,40 +63,65 @@ const TargetTypeAmazonLinux2 Type = "amazonlinux2"
 const TargetTypeAmazonLinux Type = "amazonlinux"
 
 func init() {
+	BuilderByTarget[TargetTypeAmazonLinux2023] = &amazonlinux2023{}
 	BuilderByTarget[TargetTypeAmazonLinux2022] = &amazonlinux2022{}
 	BuilderByTarget[TargetTypeAmazonLinux2] = &amazonlinux2{}
 	BuilderByTarget[TargetTypeAmazonLinux] = &amazonlinux{}
 }
 
 type amazonlinuxTemplateData struct {
+	buildTemplateData
 	DriverBuildDir     string
 	ModuleDownloadURL  string
 	KernelDownloadURLs []string
+	KernelChecksums    map[string]string
 	ModuleDriverName   string
 	ModuleFullPath     string
 	BuildModule        bool
 	BuildProbe         bool
+	KernelArch         string
+	CrossCompile       string
 	LLVMVersion        string
+	GCCVersion         string
 }
 
 // Script compiles the script to build the kernel module and/or the eBPF probe.
-func (a amazonlinux2022) Script(c Config, kr kernelrelease.KernelRelease) (string, error) {
-	return script(a, c, kr)
+func (a amazonlinux2023) Script(c Config, kr kernelrelease.KernelRelease, sources KernelSources) (string, error) {
+	return script(a, c, sources)
 }
 
-func (a amazonlinux2022) repos() []string {
+// ResolveKernelSources implements Builder, resolving the kernel packages of the build.
+func (a amazonlinux2023) ResolveKernelSources(ctx context.Context, c Config, kr kernelrelease.KernelRelease) (KernelSources, error) {
+	return resolveAmazonKernelSources(ctx, a, c, kr)
+}
+
+// mirrorLists returns the mirror list of the latest release, its repository also contains the packages of the previous ones.
+func (a amazonlinux2023) mirrorLists(kr kernelrelease.KernelRelease) []string {
 	return []string{
-		"2022.0.20220202",
-		"2022.0.20220315",
+		fmt.Sprintf("%s/latest/$basearch/mirror.list", amazonlinux2023MirrorURL),
 	}
 }
 
-func (a amazonlinux2022) baseUrl() string {
-	return "https://al2022-repos-us-east-1-9761ab97.s3.dualstack.us-east-1.amazonaws.com/core/mirrors"
+func (a amazonlinux2023) target() Type {
+	return TargetTypeAmazonLinux2023
+}
+
+// Script compiles the script to build the kernel module and/or the eBPF probe.
+func (a amazonlinux2022) Script(c Config, kr kernelrelease.KernelRelease, sources KernelSources) (string, error) {
+	return script(a, c, sources)
+}
+
+// ResolveKernelSources implements Builder, resolving the kernel packages of the build.
+func (a amazonlinux2022) ResolveKernelSources(ctx context.Context, c Config, kr kernelrelease.KernelRelease) (KernelSources, error) {
+	return resolveAmazonKernelSources(ctx, a, c, kr)
 }
 
-func (a amazonlinux2022) ext() string {
-	return "gz"
+func (a amazonlinux2022) mirrorLists(kr kernelrelease.KernelRelease) []string {
+	lists := []string{}
+	for _, releasever := range []string{"2022.0.20220315", "2022.0.20220202"} {
+		lists = append(lists, fmt.Sprintf("%s/%s/$basearch/mirror.list", amazonlinux2022MirrorURL, releasever))
+	}
+	return lists
 }
 
 func (a amazonlinux2022) target() Type {
@@ -94,25 +129,26 @@ func (a amazonlinux2022) target() Type {
 }
 
 // Script compiles the script to build the kernel module and/or the eBPF probe.
-func (a amazonlinux2) Script(c Config, kr kernelrelease.KernelRelease) (string, error) {
-	return script(a, c, kr)
-}
-
-func (a amazonlinux2) repos() []string {
-	return []string{
-		"core/2.0",
-		"core/latest",
-		"extras/kernel-5.4/latest",
-		"extras/kernel-5.10/latest",
-	}
+func (a amazonlinux2) Script(c Config, kr kernelrelease.KernelRelease, sources KernelSources) (string, error) {
+	return script(a, c, sources)
 }
 
-func (a amazonlinux2) baseUrl() string {
-	return "http://amazonlinux.us-east-1.amazonaws.com/2"
+// ResolveKernelSources implements Builder, resolving the kernel packages of the build.
+func (a amazonlinux2) ResolveKernelSources(ctx context.Context, c Config, kr kernelrelease.KernelRelease) (KernelSources, error) {
+	return resolveAmazonKernelSources(ctx, a, c, kr)
 }
 
-func (a amazonlinux2) ext() string {
-	return "gz"
+// mirrorLists returns the mirror lists of the releasever 2, the ones of the core repository
+// and the one of the extras repository of the kernel, first, when its version is one of the kernel-5.x topics.
+func (a amazonlinux2) mirrorLists(kr kernelrelease.KernelRelease) []string {
+	lists := []string{}
+	if kr.Version >= 5 {
+		lists = append(lists, fmt.Sprintf("%s/2/extras/kernel-%d.%d/latest/$basearch/mirror.list", amazonlinux2MirrorURL, kr.Version, kr.PatchLevel))
+	}
+	for _, target := range []string{"latest", "2.0"} {
+		lists = append(lists, fmt.Sprintf("%s/2/core/%s/$basearch/mirror.list", amazonlinux2MirrorURL, target))
+	}
+	return lists
 }
 
 func (a amazonlinux2) target() Type {
@@ -120,238 +156,178 @@ func (a amazonlinux2) target() Type {
 }
 
 // Script compiles the script to build the kernel module and/or the eBPF probe.
-func (a amazonlinux) Script(c Config, kr kernelrelease.KernelRelease) (string, error) {
-	return script(a, c, kr)
-}
-
-func (a amazonlinux) repos() []string {
-	return []string{
-		"latest/updates",
-		"latest/main",
-		"2017.03/updates",
-		"2017.03/main",
-		"2017.09/updates",
-		"2017.09/main",
-		"2018.03/updates",
-		"2018.03/main",
-	}
+func (a amazonlinux) Script(c Config, kr kernelrelease.KernelRelease, sources KernelSources) (string, error) {
+	return script(a, c, sources)
 }
 
-func (a amazonlinux) baseUrl() string {
-	return "http://repo.us-east-1.amazonaws.com"
+// ResolveKernelSources implements Builder, resolving the kernel packages of the build.
+func (a amazonlinux) ResolveKernelSources(ctx context.Context, c Config, kr kernelrelease.KernelRelease) (KernelSources, error) {
+	return resolveAmazonKernelSources(ctx, a, c, kr)
 }
 
-func (a amazonlinux) ext() string {
-	return "bz2"
+// mirrorLists returns the mirror lists of the updates and main repositories of the releasevers,
+// the kernels released after a point release are in its updates repository only.
+func (a amazonlinux) mirrorLists(kr kernelrelease.KernelRelease) []string {
+	lists := []string{}
+	for _, releasever := range []string{"latest", "2018.03", "2017.09", "2017.03"} {
+		for _, repo := range []string{"updates", "main"} {
+			lists = append(lists, fmt.Sprintf("%s/%s/%s/mirror.list", amazonlinuxMirrorURL, releasever, repo))
+		}
+	}
+	return lists
 }
 
 func (a amazonlinux) target() Type {
 	return TargetTypeAmazonLinux
 }
 
-func script(a amazonBuilder, c Config, kr kernelrelease.KernelRelease) (string, error) {
-	t := template.New(string(a.target()))
-	parsed, err := t.Parse(amazonlinuxTemplate)
+func script(a amazonBuilder, c Config, sources KernelSources) (string, error) {
+	parsed, err := parseTemplate(c, string(a.target()), amazonlinuxTemplate, amazonlinuxTemplateData{})
 	if err != nil {
 		return "", err
 	}
+	return executeTemplate(parsed, sources.TemplateData)
+}
 
+// resolveAmazonKernelSources returns the data of the template of the amazon linux releases, resolving the kernel packages from their repositories.
+func resolveAmazonKernelSources(ctx context.Context, a amazonBuilder, c Config, kr kernelrelease.KernelRelease) (KernelSources, error) {
 	var urls []string
+	var err error
+	lookup := rpmChecksums
 	if c.KernelUrls == nil {
 		// Check (and filter) existing kernels before continuing
-		var packages []string
-		packages, err = fetchAmazonLinuxPackagesURLs(a, kr)
+		var packages []rpmRepoPackage
+		packages, err = fetchAmazonLinuxPackagesURLs(ctx, a, kr)
 		if err != nil {
-			return "", err
+			return KernelSources{}, err
 		}
-		urls, err = getResolvingURLs(packages)
+		candidates := make([]string, 0, len(packages))
+		for _, p := range packages {
+			candidates = append(candidates, p.URL)
+		}
+		urls, err = getResolvingURLs(ctx, candidates)
+		// the checksums are the ones of the primary metadata the packages were found in
+		lookup = rpmPackageChecksums(packages)
 	} else {
-		urls, err = getResolvingURLs(c.KernelUrls)
+		urls, err = getResolvingURLs(ctx, c.KernelUrls)
 	}
 	if err != nil {
-		return "", err
+		return KernelSources{}, err
+	}
+
+	sums, err := kernelChecksums(ctx, c, urls, lookup)
+	if err != nil {
+		return KernelSources{}, err
+	}
+
+	crossCompilePrefix, err := crossCompile(c)
+	if err != nil {
+		return KernelSources{}, err
 	}
 
 	td := amazonlinuxTemplateData{
 		DriverBuildDir:     DriverDirectory,
 		ModuleDownloadURL:  moduleDownloadURL(c),
 		KernelDownloadURLs: urls,
+		KernelChecksums:    sums,
 		ModuleDriverName:   c.DriverName,
 		ModuleFullPath:     ModuleFullPath,
 		BuildModule:        len(c.Build.ModuleFilePath) > 0,
 		BuildProbe:         len(c.Build.ProbeFilePath) > 0,
-		LLVMVersion:        amazonLLVMVersionFromKernelRelease(kr),
-	}
-
-	buf := bytes.NewBuffer(nil)
-	err = parsed.Execute(buf, td)
-	if err != nil {
-		return "", err
+		KernelArch:         kr.Architecture.ToKernel(),
+		CrossCompile:       crossCompilePrefix,
+		LLVMVersion:        llvmVersion(c, amazonLLVMVersionFromKernelRelease(kr)),
+		GCCVersion:         gccVersion(c, vanillaGCCVersionFromKernelRelease(kr)),
 	}
-	return buf.String(), nil
+	td.buildTemplateData = newBuildTemplateData(c)
+	return KernelSources{URLs: urls, TemplateData: td}, nil
 }
 
-func buildMirror(a amazonBuilder, r string, kv kernelrelease.KernelRelease) (string, error) {
-	var baseURL string
-	switch a.target() {
-	case TargetTypeAmazonLinux:
-		baseURL = fmt.Sprintf("%s/%s", a.baseUrl(), r)
-	case TargetTypeAmazonLinux2:
-		baseURL = fmt.Sprintf("%s/%s/%s", a.baseUrl(), r, kv.Architecture.ToNonDeb())
-	case TargetTypeAmazonLinux2022:
-		baseURL = fmt.Sprintf("%s/%s/%s", a.baseUrl(), r, kv.Architecture.ToNonDeb())
-	default:
-		return "", fmt.Errorf("unsupported target")
-	}
-
-	mirror := fmt.Sprintf("%s/%s", baseURL, "mirror.list")
-	logger.WithField("url", mirror).WithField("version", r).Debug("looking for repo...")
-	return mirror, nil
+// expandAmazonLinuxVars expands the variables of the mirror lists and of the mirrors they list, as yum does.
+func expandAmazonLinuxVars(s string, kr kernelrelease.KernelRelease) string {
+	return strings.NewReplacer(
+		"$basearch", kr.Architecture.ToNonDeb(),
+		"$awsregion", amazonlinuxRegion,
+		"$awsdomain", amazonlinuxDomain,
+	).Replace(s)
 }
 
-type unzipFunc func(io.Reader) ([]byte, error)
-
-func unzipFuncFromBuilder(a amazonBuilder) (unzipFunc, error) {
-	switch a.ext() {
-	case "gz":
-		return gunzip, nil
-	case "bz2":
-		return bunzip, nil
+// amazonLinuxMirrors returns the mirrors of the mirror list, in order.
+func amazonLinuxMirrors(ctx context.Context, mirrorList string, kr kernelrelease.KernelRelease) ([]string, error) {
+	Logger(ctx).WithField("url", mirrorList).Debug("looking for repo...")
+	body, err := getIndex(ctx, mirrorList)
+	if err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("unsupported extension: %s", a.ext())
-}
-
-func fetchAmazonLinuxPackagesURLs(a amazonBuilder, kv kernelrelease.KernelRelease) ([]string, error) {
-	urls := []string{}
-	visited := make(map[string]struct{})
-
-	for _, v := range a.repos() {
-		mirror, err := buildMirror(a, v, kv)
-		if err != nil {
-			return nil, err
-		}
-
-		// Obtain the repo URL by getting mirror URL content
-		mirrorRes, err := http.Get(mirror)
-		if err != nil {
-			return nil, err
-		}
-		defer mirrorRes.Body.Close()
-
-		var repo string
-		scanner := bufio.NewScanner(mirrorRes.Body)
-		if scanner.Scan() {
-			repo = scanner.Text()
-		}
-		if repo == "" {
-			return nil, fmt.Errorf("repository not found")
-		}
-		repo = strings.ReplaceAll(strings.TrimSuffix(repo, "\n"), "$basearch", kv.Architecture.ToNonDeb())
-		repo = strings.TrimSuffix(repo, "/")
-		repoDatabaseURL := fmt.Sprintf("%s/repodata/primary.sqlite.%s", repo, a.ext())
-		if _, ok := visited[repoDatabaseURL]; ok {
+	mirrors := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
 			continue
 		}
-		// Download the repo database
-		repoRes, err := http.Get(repoDatabaseURL)
-		logger.WithField("url", repoDatabaseURL).Debug("downloading...")
-		if err != nil {
-			return nil, err
-		}
-		defer repoRes.Body.Close()
-		visited[repoDatabaseURL] = struct{}{}
+		mirrors = append(mirrors, strings.TrimSuffix(expandAmazonLinuxVars(line, kr), "/"))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(mirrors) == 0 {
+		return nil, fmt.Errorf("no mirror found in %s", mirrorList)
+	}
+	return mirrors, nil
+}
 
-		unzip, err := unzipFuncFromBuilder(a)
-		if err != nil {
-			return nil, err
-		}
+// fetchAmazonLinuxPackagesURLs looks for the kernel-devel packages of the kernel by NEVRA into the primary metadata of the repositories,
+// the ones of the mirror lists. The repositories are tried in order, the first having the kernel wins.
+func fetchAmazonLinuxPackagesURLs(ctx context.Context, a amazonBuilder, kr kernelrelease.KernelRelease) ([]rpmRepoPackage, error) {
+	arch := kr.Architecture.ToNonDeb()
+	query := rpmQuery{
+		name:    amazonlinuxKernelDevelPattern,
+		version: kr.Fullversion,
+		release: strings.TrimPrefix(strings.TrimSuffix(kr.FullExtraversion, "."+arch), "-"),
+		arches:  []string{arch, "noarch"},
+	}
+	closest := map[string]struct{}{}
+	visited := map[string]struct{}{}
 
-		dbBytes, err := unzip(repoRes.Body)
-		if err != nil {
-			return nil, err
-		}
-		// Create the temporary database file
-		dbFile, err := ioutil.TempFile(os.TempDir(), fmt.Sprintf("%s-*.sqlite", string(a.target())))
-		if err != nil {
-			return nil, err
-		}
-		defer os.Remove(dbFile.Name())
-		if _, err := dbFile.Write(dbBytes); err != nil {
-			return nil, err
-		}
-		// Open the database
-		db, err := sql.Open("sqlite", dbFile.Name())
+	for _, list := range a.mirrorLists(kr) {
+		mirrors, err := amazonLinuxMirrors(ctx, expandAmazonLinuxVars(list, kr), kr)
 		if err != nil {
-			return nil, err
+			Logger(ctx).WithError(err).WithField("url", list).Debug("skipping repository")
+			continue
 		}
-		defer db.Close()
-		logger.WithField("db", dbFile.Name()).Debug("connecting to database...")
-		// Query the database
-		rel := strings.TrimPrefix(strings.TrimSuffix(kv.FullExtraversion, fmt.Sprintf(".%s", kv.Architecture.ToNonDeb())), "-")
-		q := fmt.Sprintf("SELECT location_href FROM packages WHERE name LIKE 'kernel-devel%%' AND version='%s' AND release='%s'", kv.Fullversion, rel)
-		stmt, err := db.Prepare(q)
+		root, packages, err := rpmRepository{baseURLs: mirrors}.packages(ctx, indexGetter(ctx))
 		if err != nil {
-			return nil, err
+			Logger(ctx).WithError(err).WithField("url", list).Debug("skipping repository")
+			continue
 		}
-		defer stmt.Close()
-		rows, err := stmt.Query()
-		if err != nil {
-			return nil, err
+		if _, ok := visited[root]; ok {
+			continue
 		}
-		defer rows.Close()
-		for rows.Next() {
-			var href string
-			err = rows.Scan(&href)
-			if err != nil {
-				log.Fatal(err)
+		visited[root] = struct{}{}
+
+		found := []rpmRepoPackage{}
+		for _, p := range packages {
+			if query.matches(p) {
+				found = append(found, rpmRepoPackage{p, resolveURLReference(root + "/" + p.Location.Href)})
+			} else if query.name.MatchString(p.Name) && strings.HasPrefix(p.Version.Ver, fmt.Sprintf("%d.%d.", kr.Version, kr.PatchLevel)) {
+				closest[p.Version.Ver+"-"+p.Version.Rel] = struct{}{}
 			}
-			urls = append(urls, fmt.Sprintf("%s/%s", repo, href))
-		}
-
-		if err := dbFile.Close(); err != nil {
-			return nil, err
 		}
-
-		// Found, do not continue
-		if len(urls) > 0 {
-			break
+		if len(found) > 0 {
+			return found, nil
 		}
 	}
 
-	return urls, nil
-}
-
-func gunzip(data io.Reader) (res []byte, err error) {
-	var r io.Reader
-	r, err = gzip.NewReader(data)
-	if err != nil {
-		return
-	}
-
-	var b bytes.Buffer
-	_, err = b.ReadFrom(r)
-	if err != nil {
-		return
-	}
-
-	res = b.Bytes()
-
-	return
-}
-
-func bunzip(data io.Reader) (res []byte, err error) {
-	var r io.Reader
-	r = bzip2.NewReader(data)
-
-	var b bytes.Buffer
-	_, err = b.ReadFrom(r)
-	if err != nil {
-		return
+	if len(closest) > 0 {
+		versions := make([]string, 0, len(closest))
+		for v := range closest {
+			versions = append(versions, v)
+		}
+		sort.Strings(versions)
+		return nil, fmt.Errorf("kernel headers not found, closest available kernel-devel versions: %s", strings.Join(versions, ", "))
 	}
-
-	res = b.Bytes()
-
-	return
+	return nil, fmt.Errorf("kernel headers not found")
 }
 
 func amazonLLVMVersionFromKernelRelease(kr kernelrelease.KernelRelease) string {
@@ -0,0 +1,177 @@
+package builder
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+// newTestAmazonLinuxMirror returns a server serving the repositories captured in testdata/amazonlinux,
+// through the official mirror lists mapped to them, the other mirror lists are not found.
+func newTestAmazonLinuxMirror(t *testing.T, lists map[string]string) *httptest.Server {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	mux.Handle("/repos/", http.StripPrefix("/repos/", http.FileServer(http.Dir("testdata/amazonlinux"))))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		repo, ok := lists[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, "# mirrors of %s\n\n%s/repos/%s/\n", repo, server.URL, repo)
+	})
+
+	for _, v := range []*string{&amazonlinuxMirrorURL, &amazonlinux2MirrorURL} {
+		v, defaultURL := v, *v
+		*v = server.URL
+		t.Cleanup(func() { *v = defaultURL })
+	}
+	withHTTPClient(t, newRetryClient(context.Background(), time.Second, 1))
+	return server
+}
+
+func TestAmazonLinuxMirrorLists(t *testing.T) {
+	tests := map[string]struct {
+		builder       amazonBuilder
+		kernelrelease string
+		expected      []string
+	}{
+		"amazonlinux": {
+			builder:       amazonlinux{},
+			kernelrelease: "4.14.322-170.535.amzn1.x86_64",
+			expected: []string{
+				"http://repo.$awsregion.$awsdomain/latest/updates/mirror.list",
+				"http://repo.$awsregion.$awsdomain/latest/main/mirror.list",
+				"http://repo.$awsregion.$awsdomain/2018.03/updates/mirror.list",
+				"http://repo.$awsregion.$awsdomain/2018.03/main/mirror.list",
+				"http://repo.$awsregion.$awsdomain/2017.09/updates/mirror.list",
+				"http://repo.$awsregion.$awsdomain/2017.09/main/mirror.list",
+				"http://repo.$awsregion.$awsdomain/2017.03/updates/mirror.list",
+				"http://repo.$awsregion.$awsdomain/2017.03/main/mirror.list",
+			},
+		},
+		"amazonlinux2 extras kernel": {
+			builder:       amazonlinux2{},
+			kernelrelease: "5.10.192-183.736.amzn2.x86_64",
+			expected: []string{
+				"http://amazonlinux.$awsregion.$awsdomain/2/extras/kernel-5.10/latest/$basearch/mirror.list",
+				"http://amazonlinux.$awsregion.$awsdomain/2/core/latest/$basearch/mirror.list",
+				"http://amazonlinux.$awsregion.$awsdomain/2/core/2.0/$basearch/mirror.list",
+			},
+		},
+		"amazonlinux2 core kernel": {
+			builder:       amazonlinux2{},
+			kernelrelease: "4.14.322-244.536.amzn2.x86_64",
+			expected: []string{
+				"http://amazonlinux.$awsregion.$awsdomain/2/core/latest/$basearch/mirror.list",
+				"http://amazonlinux.$awsregion.$awsdomain/2/core/2.0/$basearch/mirror.list",
+			},
+		},
+		"amazonlinux2023": {
+			builder:       amazonlinux2023{},
+			kernelrelease: "6.1.49-69.116.amzn2023.aarch64",
+			expected: []string{
+				"https://cdn.amazonlinux.com/al2023/core/mirrors/latest/$basearch/mirror.list",
+			},
+		},
+	}
+
+	for name, test := range tests {
+		got := test.builder.mirrorLists(kernelrelease.FromString(test.kernelrelease))
+		if len(got) != len(test.expected) {
+			t.Fatalf("Slice sizes don't match! Test Input: '%s' | Got: '%v' / Want: '%v'", name, got, test.expected)
+		}
+		for i, v := range got {
+			if v != test.expected[i] {
+				t.Errorf("Slice values don't match! Test Input: '%s' | Got: '%v' / Want: '%v'", name, got, test.expected)
+			}
+		}
+	}
+}
+
+func TestExpandAmazonLinuxVars(t *testing.T) {
+	kr := kernelrelease.FromString("5.10.192-183.736.amzn2.aarch64")
+	kr.Architecture = "arm64"
+	got := expandAmazonLinuxVars("http://amazonlinux.$awsregion.$awsdomain/2/core/latest/$basearch/mirror.list", kr)
+	if want := "http://amazonlinux.us-east-1.amazonaws.com/2/core/latest/aarch64/mirror.list"; got != want {
+		t.Errorf("Got: '%s' / Want: '%s'", got, want)
+	}
+}
+
+func TestFetchAmazonLinuxPackagesURLs(t *testing.T) {
+	server := newTestAmazonLinuxMirror(t, map[string]string{
+		"/latest/updates/mirror.list":                     "amzn-updates",
+		"/latest/main/mirror.list":                        "amzn-main",
+		"/2/extras/kernel-5.10/latest/x86_64/mirror.list": "amzn2-extras-kernel-5.10",
+		"/2/core/latest/x86_64/mirror.list":               "amzn2-core",
+	})
+
+	tests := map[string]struct {
+		builder       amazonBuilder
+		kernelrelease string
+		expected      string
+	}{
+		"amazonlinux kernel only in updates": {
+			builder:       amazonlinux{},
+			kernelrelease: "4.14.322-170.535.amzn1.x86_64",
+			expected:      server.URL + "/repos/amzn-updates/Packages/kernel-devel-4.14.322-170.535.amzn1.x86_64.rpm",
+		},
+		"amazonlinux kernel in main": {
+			builder:       amazonlinux{},
+			kernelrelease: "4.14.88-72.73.amzn1.x86_64",
+			expected:      server.URL + "/repos/amzn-main/Packages/kernel-devel-4.14.88-72.73.amzn1.x86_64.rpm",
+		},
+		"amazonlinux2 extras kernel": {
+			builder:       amazonlinux2{},
+			kernelrelease: "5.10.192-183.736.amzn2.x86_64",
+			expected:      server.URL + "/repos/amzn2-extras-kernel-5.10/Packages/kernel-devel-5.10.192-183.736.amzn2.x86_64.rpm",
+		},
+		"amazonlinux2 core kernel": {
+			builder:       amazonlinux2{},
+			kernelrelease: "4.14.322-244.536.amzn2.x86_64",
+			expected:      server.URL + "/repos/amzn2-core/Packages/kernel-devel-4.14.322-244.536.amzn2.x86_64.rpm",
+		},
+	}
+
+	for name, test := range tests {
+		kr := kernelrelease.FromString(test.kernelrelease)
+		kr.Architecture = "amd64"
+		got, err := fetchAmazonLinuxPackagesURLs(context.Background(), test.builder, kr)
+		if err != nil {
+			t.Errorf("Unexpected error encountered with Test Input: '%s' | Error: '%s'", name, err)
+			continue
+		}
+		if len(got) != 1 || got[0].URL != test.expected {
+			t.Errorf("Test Input: '%s' | Got: '%v' / Want: [ '%s' ]", name, got, test.expected)
+			continue
+		}
+		// the fixtures have the SHA256 sums of the NEVRAs of the packages
+		nevra := strings.TrimSuffix(test.expected[strings.LastIndex(test.expected, "/")+1:], ".rpm")
+		if want := fmt.Sprintf("%x", sha256.Sum256([]byte(nevra))); got[0].sha256() != want {
+			t.Errorf("Test Input: '%s' | Got: '%s' / Want: '%s'", name, got[0].sha256(), want)
+		}
+	}
+}
+
+func TestFetchAmazonLinuxPackagesURLsClosest(t *testing.T) {
+	newTestAmazonLinuxMirror(t, map[string]string{
+		"/2/extras/kernel-5.10/latest/x86_64/mirror.list": "amzn2-extras-kernel-5.10",
+		"/2/core/latest/x86_64/mirror.list":               "amzn2-core",
+	})
+
+	kr := kernelrelease.FromString("5.10.999-1.1.amzn2.x86_64")
+	kr.Architecture = "amd64"
+	_, err := fetchAmazonLinuxPackagesURLs(context.Background(), amazonlinux2{}, kr)
+	want := "kernel headers not found, closest available kernel-devel versions: 5.10.186-179.751.amzn2, 5.10.192-183.736.amzn2"
+	if err == nil || err.Error() != want {
+		t.Errorf("Got: '%v' / Want: '%s'", err, want)
+	}
+}
@@ -0,0 +1,133 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// debianArchiveKeyring are the automatic signing keys of the Debian and the Debian security archives, from buster onwards,
+// exported from the debian-archive-keyring package.
+//
+//go:embed keys/debian-archive-keyring.asc
+var debianArchiveKeyring string
+
+// aptKeys returns the keys the InRelease files of an archive are signed with.
+type aptKeys func(ctx context.Context) (openpgp.EntityList, error)
+
+var (
+	// debianArchiveKeys are the keys of the Debian archives, shipped with driverkit.
+	debianArchiveKeys aptKeys = func(ctx context.Context) (openpgp.EntityList, error) {
+		return openpgp.ReadArmoredKeyRing(strings.NewReader(debianArchiveKeyring))
+	}
+	// ubuntuArchiveKeys are the automatic signing keys of the Ubuntu archives, 2012 and 2018, and of its debug symbols archive.
+	ubuntuArchiveKeys = pinnedAptKeys(
+		"790BC7277767219C42C86F933B4FE6ACC0B21F32",
+		"F6ECB3762474EDA9D21B7022871920D1991BC93C",
+		"F2EDC64DC5AEE1F6B9C621F0C8CAB6595FDFF622",
+	)
+	// raspiosArchiveKeys are the keys of the Raspberry Pi archive.
+	raspiosArchiveKeys = pinnedAptKeys("CF8A1AF502A2AA2D763BAE7E82B129927FA3303E")
+)
+
+// aptKeyserverURL is the keyserver the pinned keys are fetched from, by fingerprint.
+var aptKeyserverURL = "https://keyserver.ubuntu.com/pks/lookup?op=get&options=mr&search=0x"
+
+// pinnedAptKeys fetches the keys of the fingerprints from the keyserver, the keys whose fingerprint differs are dropped.
+// The ones that cannot be fetched are skipped, it fails when none can.
+func pinnedAptKeys(fingerprints ...string) aptKeys {
+	return func(ctx context.Context) (openpgp.EntityList, error) {
+		keys := openpgp.EntityList{}
+		var lastErr error
+		for _, fingerprint := range fingerprints {
+			body, err := getIndex(ctx, aptKeyserverURL+fingerprint)
+			if err != nil {
+				Logger(ctx).WithError(err).WithField("fingerprint", fingerprint).Debug("skipping archive key")
+				lastErr = err
+				continue
+			}
+			entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(body))
+			if err != nil {
+				Logger(ctx).WithError(err).WithField("fingerprint", fingerprint).Debug("skipping archive key")
+				lastErr = err
+				continue
+			}
+			for _, e := range entities {
+				if strings.EqualFold(hex.EncodeToString(e.PrimaryKey.Fingerprint[:]), fingerprint) {
+					keys = append(keys, e)
+				}
+			}
+		}
+		if len(keys) == 0 {
+			if lastErr == nil {
+				lastErr = fmt.Errorf("no key of the fingerprints %s", strings.Join(fingerprints, ", "))
+			}
+			return nil, fmt.Errorf("unable to fetch the archive keys: %s", lastErr)
+		}
+		return keys, nil
+	}
+}
+
+// aptReleaseChecksums verifies the InRelease file of the suite against the keys,
+// returning the SHA256 sums of the indexes it lists, keyed by their path in the suite, e.g. main/binary-amd64/Packages.xz.
+func aptReleaseChecksums(ctx context.Context, suiteURL string, keys openpgp.EntityList) (map[string]string, error) {
+	body, err := getIndex(ctx, suiteURL+"/InRelease")
+	if err != nil {
+		return nil, err
+	}
+	block, _ := clearsign.Decode(body)
+	if block == nil {
+		return nil, fmt.Errorf("the InRelease file of %s is not signed", suiteURL)
+	}
+	if err := verifyAptSignature(keys, block); err != nil {
+		return nil, fmt.Errorf("unable to verify the InRelease file of %s: %s", suiteURL, err)
+	}
+	sums := map[string]string{}
+	inSHA256 := false
+	for _, line := range strings.Split(string(block.Plaintext), "\n") {
+		if len(line) == 0 {
+			continue
+		}
+		// the sums are the lines of the SHA256 field, indented, as: <sum> <size> <path>
+		if line[0] != ' ' {
+			inSHA256 = strings.HasPrefix(line, "SHA256:")
+			continue
+		}
+		if fields := strings.Fields(line); inSHA256 && len(fields) == 3 {
+			sums[fields[2]] = fields[0]
+		}
+	}
+	return sums, nil
+}
+
+// verifyAptSignature checks that one of the signatures of the block is made by one of the keys.
+// The InRelease files are signed with several keys, some of them with algorithms openpgp does not support, e.g. ed25519,
+// every signature is then checked on its own.
+func verifyAptSignature(keys openpgp.EntityList, block *clearsign.Block) error {
+	signatures := packet.NewOpaqueReader(block.ArmoredSignature.Body)
+	err := fmt.Errorf("no signature of the archive keys")
+	for {
+		p, readErr := signatures.Next()
+		if readErr == io.EOF {
+			return err
+		}
+		if readErr != nil {
+			return readErr
+		}
+		signature := &bytes.Buffer{}
+		if serializeErr := p.Serialize(signature); serializeErr != nil {
+			return serializeErr
+		}
+		if _, err = openpgp.CheckDetachedSignature(keys, bytes.NewReader(block.Bytes), signature); err == nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package builder
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/ulikunitz/xz"
+)
+
+// aptRepository is a Debian archive, e.g. http://deb.debian.org/debian, with the suites and the components the packages are looked for in,
+// and the keys its InRelease files are signed with.
+type aptRepository struct {
+	baseURL    string
+	suites     []string
+	components []string
+	keys       aptKeys
+}
+
+// aptPackage is a stanza of a Packages index, with the URL of its Filename.
+type aptPackage struct {
+	Name         string
+	Version      string
+	Architecture string
+	Filename     string
+	SHA256       string
+	URL          string
+}
+
+// aptPackagesIndexes are the Packages indexes looked for in every suite, in order, the archives ship at least one of them.
+var aptPackagesIndexes = []string{"Packages.xz", "Packages.gz"}
+
+// packages calls visit with the packages of the architecture, and the ones for all of them, of every suite and component of the repository.
+// The indexes are verified against the InRelease file of their suite, whose signature is verified against the keys of the repository.
+// The suites and components whose index cannot be fetched or verified are skipped, it fails when none can.
+func (r aptRepository) packages(ctx context.Context, arch string, visit func(p aptPackage)) error {
+	base := strings.TrimSuffix(r.baseURL, "/")
+	keys, err := r.keys(ctx)
+	if err != nil {
+		return err
+	}
+	found := false
+	var lastErr error
+	for _, suite := range r.suites {
+		suiteURL := fmt.Sprintf("%s/dists/%s", base, suite)
+		sums, err := aptReleaseChecksums(ctx, suiteURL, keys)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			Logger(ctx).WithError(err).WithField("url", suiteURL).Debug("skipping suite")
+			lastErr = err
+			continue
+		}
+		for _, component := range r.components {
+			dir := fmt.Sprintf("%s/binary-%s", component, arch)
+			err := aptPackagesIndex(ctx, suiteURL, dir, sums, func(p aptPackage) {
+				p.URL = base + "/" + p.Filename
+				visit(p)
+			})
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				Logger(ctx).WithError(err).WithField("url", suiteURL+"/"+dir).Debug("skipping packages index")
+				lastErr = err
+				continue
+			}
+			found = true
+		}
+	}
+	if !found {
+		if lastErr == nil {
+			return fmt.Errorf("no packages index in %s", base)
+		}
+		return lastErr
+	}
+	return nil
+}
+
+// aptPackagesIndex parses the first Packages index of the directory of the suite that can be fetched,
+// once its SHA256 sum matches the one of the InRelease file.
+func aptPackagesIndex(ctx context.Context, suiteURL string, dir string, sums map[string]string, visit func(p aptPackage)) error {
+	var lastErr error
+	for _, name := range aptPackagesIndexes {
+		index := dir + "/" + name
+		sum, ok := sums[index]
+		if !ok {
+			lastErr = fmt.Errorf("%s is not listed in the InRelease file of %s", index, suiteURL)
+			continue
+		}
+		body, err := getIndex(ctx, suiteURL+"/"+index)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if got := sha256.Sum256(body); hex.EncodeToString(got[:]) != sum {
+			return fmt.Errorf("the SHA256 sum of %s/%s does not match the one of the InRelease file", suiteURL, index)
+		}
+		return parseAptPackages(bytes.NewReader(body), path.Ext(name), visit)
+	}
+	return lastErr
+}
+
+// parseAptPackages calls visit with every stanza of the Packages index, compressed as its extension tells.
+// Only the fields driverkit needs are kept, the multiline ones, e.g. Description, are skipped.
+func parseAptPackages(r io.Reader, ext string, visit func(p aptPackage)) error {
+	switch ext {
+	case ".gz":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	case ".xz":
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return err
+		}
+		r = xr
+	case "":
+	default:
+		return fmt.Errorf("unsupported packages index compression: %s", ext)
+	}
+
+	p := aptPackage{}
+	flush := func() {
+		if len(p.Name) > 0 && len(p.Filename) > 0 {
+			visit(p)
+		}
+		p = aptPackage{}
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(strings.TrimSpace(line)) == 0 {
+			flush()
+			continue
+		}
+		// continuation lines of the multiline fields
+		if line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		field := strings.SplitN(line, ":", 2)
+		if len(field) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(field[1])
+		switch field[0] {
+		case "Package":
+			p.Name = value
+		case "Version":
+			p.Version = value
+		case "Architecture":
+			p.Architecture = value
+		case "Filename":
+			p.Filename = value
+		case "SHA256":
+			p.SHA256 = value
+		}
+	}
+	flush()
+	return scanner.Err()
+}
+
+// aptPackageChecksums looks for the packages into the ones found in the Packages indexes.
+func aptPackageChecksums(packages []aptPackage) checksumLookup {
+	return func(ctx context.Context, urls []string) map[string]string {
+		sums := map[string]string{}
+		for _, p := range packages {
+			if len(p.SHA256) > 0 {
+				sums[p.URL] = p.SHA256
+			}
+		}
+		return sums
+	}
+}
@@ -0,0 +1,203 @@
+package builder
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+// testArchiveKey is the key the InRelease files of testdata are signed with.
+const testArchiveKey = "testdata/archive-key.asc"
+
+// withTestArchiveKeys adds the key of testArchiveKey to the keys of the Debian archives.
+func withTestArchiveKeys(t *testing.T) {
+	data, err := ioutil.ReadFile(testArchiveKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultKeys := debianArchiveKeys
+	debianArchiveKeys = func(ctx context.Context) (openpgp.EntityList, error) {
+		keys, err := defaultKeys(ctx)
+		if err != nil {
+			return nil, err
+		}
+		test, err := openpgp.ReadArmoredKeyRing(strings.NewReader(string(data)))
+		return append(keys, test...), err
+	}
+	t.Cleanup(func() { debianArchiveKeys = defaultKeys })
+}
+
+// newTestAptArchives serves the Packages indexes recorded in testdata/apt as the debian and debian-security archives.
+func newTestAptArchives(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.FileServer(http.Dir("testdata/apt")))
+	t.Cleanup(server.Close)
+	withHTTPClient(t, newRetryClient(context.Background(), time.Second, 1))
+	withTestArchiveKeys(t)
+
+	defaultArchiveURL, defaultSecurityURL := debianArchiveURL, debianSecurityURL
+	debianArchiveURL, debianSecurityURL = server.URL+"/debian", server.URL+"/debian-security"
+	t.Cleanup(func() { debianArchiveURL, debianSecurityURL = defaultArchiveURL, defaultSecurityURL })
+	return server
+}
+
+func TestParseAptPackages(t *testing.T) {
+	index := `Package: linux-headers-6.1.0-17-common
+Source: linux
+Version: 6.1.69-1
+Architecture: all
+Description: Common header files for Linux 6.1.0-17
+ This package provides kernel header files for version 6.1.0-17, for sites
+ that want the latest kernel headers.
+ Filename: not/a/field.deb
+Filename: pool/main/l/linux/linux-headers-6.1.0-17-common_6.1.69-1_all.deb
+SHA256: 1111111111111111111111111111111111111111111111111111111111111111
+
+Package: linux-source
+Version: 6.1.69-1
+
+Package: linux-kbuild-6.1
+Version: 6.1.69-1
+Architecture: amd64
+Filename: pool/main/l/linux/linux-kbuild-6.1_6.1.69-1_amd64.deb
+`
+	packages := []aptPackage{}
+	if err := parseAptPackages(strings.NewReader(index), "", func(p aptPackage) { packages = append(packages, p) }); err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	expected := []aptPackage{
+		{Name: "linux-headers-6.1.0-17-common", Version: "6.1.69-1", Architecture: "all", Filename: "pool/main/l/linux/linux-headers-6.1.0-17-common_6.1.69-1_all.deb", SHA256: "1111111111111111111111111111111111111111111111111111111111111111"},
+		{Name: "linux-kbuild-6.1", Version: "6.1.69-1", Architecture: "amd64", Filename: "pool/main/l/linux/linux-kbuild-6.1_6.1.69-1_amd64.deb"},
+	}
+	if len(packages) != len(expected) {
+		t.Fatalf("Slice sizes don't match! Got: '%v' / Want: '%v'", packages, expected)
+	}
+	for i, p := range packages {
+		if p != expected[i] {
+			t.Errorf("Slice values don't match! Got: '%v' / Want: '%v'", packages, expected)
+		}
+	}
+}
+
+func TestAptRepositoryPackages(t *testing.T) {
+	server := newTestAptArchives(t)
+
+	tests := map[string]struct {
+		repository aptRepository
+		expected   int
+		url        string
+	}{
+		"bookworm xz": {
+			repository: aptRepository{server.URL + "/debian", []string{"bookworm", "bookworm-updates"}, []string{"main"}, debianArchiveKeys},
+			expected:   8,
+			url:        server.URL + "/debian/pool/main/l/linux/linux-kbuild-6.1_6.1.55-1_amd64.deb",
+		},
+		"bookworm-security gz": {
+			repository: aptRepository{server.URL + "/debian-security", []string{"bookworm-security"}, []string{"main"}, debianArchiveKeys},
+			expected:   4,
+			url:        server.URL + "/debian-security/pool/updates/main/l/linux/linux-kbuild-6.1_6.1.69-1_amd64.deb",
+		},
+	}
+	for name, test := range tests {
+		urls := map[string]bool{}
+		err := test.repository.packages(context.Background(), "amd64", func(p aptPackage) { urls[p.URL] = true })
+		if err != nil {
+			t.Errorf("Unexpected error encountered with Test Input: '%s' | Error: '%s'", name, err)
+			continue
+		}
+		if len(urls) != test.expected || !urls[test.url] {
+			t.Errorf("Test Input: '%s' | Got: '%v' / Want: %d packages having '%s'", name, urls, test.expected, test.url)
+		}
+	}
+
+	err := aptRepository{server.URL + "/debian", []string{"trixie"}, []string{"main"}, debianArchiveKeys}.packages(context.Background(), "amd64", func(aptPackage) {})
+	if err == nil {
+		t.Errorf("Got: [ nil ] / Want: [ not found ]")
+	}
+}
+
+func TestAptRepositoryVerification(t *testing.T) {
+	dir := t.TempDir()
+	suite := filepath.Join(dir, "debian", "dists", "bookworm")
+	if err := os.MkdirAll(filepath.Join(suite, "main", "binary-amd64"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	inRelease, err := ioutil.ReadFile("testdata/apt/debian/dists/bookworm/InRelease")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(suite, "InRelease"), inRelease, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// a Packages index other than the one of the InRelease file
+	tampered, err := ioutil.ReadFile("testdata/apt/debian-security/dists/bookworm-security/main/binary-amd64/Packages.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(suite, "main", "binary-amd64", "Packages.xz"), tampered, 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer server.Close()
+	withHTTPClient(t, newRetryClient(context.Background(), time.Second, 1))
+	withTestArchiveKeys(t)
+
+	err = aptRepository{server.URL + "/debian", []string{"bookworm"}, []string{"main"}, debianArchiveKeys}.packages(context.Background(), "amd64", func(aptPackage) {})
+	if err == nil || !strings.Contains(err.Error(), "does not match the one of the InRelease file") {
+		t.Errorf("Got: [ %v ] / Want: [ checksum mismatch ]", err)
+	}
+
+	// the keys of the Debian archives only
+	debianKeys := func(ctx context.Context) (openpgp.EntityList, error) {
+		return openpgp.ReadArmoredKeyRing(strings.NewReader(debianArchiveKeyring))
+	}
+	archive := newTestAptArchives(t)
+	err = aptRepository{archive.URL + "/debian", []string{"bookworm"}, []string{"main"}, debianKeys}.packages(context.Background(), "amd64", func(aptPackage) {})
+	if err == nil || !strings.Contains(err.Error(), "unable to verify the InRelease file") {
+		t.Errorf("Got: [ %v ] / Want: [ signed by an unknown key ]", err)
+	}
+}
+
+func TestDebianArchiveKeys(t *testing.T) {
+	keys, err := debianArchiveKeys(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if len(keys) != 8 {
+		t.Errorf("Got: [ %d keys ] / Want: [ the 8 keys of the archives of buster to trixie ]", len(keys))
+	}
+}
+
+func TestPinnedAptKeys(t *testing.T) {
+	key, err := ioutil.ReadFile(testArchiveKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(key)
+	}))
+	defer server.Close()
+	withHTTPClient(t, newRetryClient(context.Background(), time.Second, 1))
+	defaultKeyserverURL := aptKeyserverURL
+	aptKeyserverURL = server.URL + "/pks/lookup?op=get&search=0x"
+	t.Cleanup(func() { aptKeyserverURL = defaultKeyserverURL })
+
+	keys, err := pinnedAptKeys("8911982A4E66202F17D8CD352164E99E724DBB15")(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if len(keys) != 1 {
+		t.Errorf("Got: [ %d keys ] / Want: [ the pinned key ]", len(keys))
+	}
+	// the keyserver answers with a key of another fingerprint
+	if _, err := pinnedAptKeys("F6ECB3762474EDA9D21B7022871920D1991BC93C")(context.Background()); err == nil {
+		t.Errorf("Got: [ nil ] / Want: [ no key of the fingerprint ]")
+	}
+}
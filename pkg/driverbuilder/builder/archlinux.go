@@ -1,10 +1,11 @@
 package builder
 
 import (
-	"bytes"
+	"context"
 	_ "embed"
 	"fmt"
-	"text/template"
+	"strconv"
+	"strings"
 
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 )
@@ -15,8 +16,19 @@ var archlinuxTemplate string
 // TargetTypeArchlinux identifies the Archlinux target.
 const TargetTypeArchlinux Type = "archlinux"
 
+// TargetTypeArch identifies the Archlinux target too, it is an alias of TargetTypeArchlinux.
+const TargetTypeArch Type = "arch"
+
+// archlinuxFlavors are the kernel flavors, their uname carries the flavor as suffix (e.g. 6.6.8-zen1-1-zen).
+var archlinuxFlavors = []string{
+	"lts",
+	"zen",
+	"hardened",
+}
+
 func init() {
 	BuilderByTarget[TargetTypeArchlinux] = &archlinux{}
+	BuilderByTarget[TargetTypeArch] = &archlinux{}
 }
 
 // archlinux is a driverkit target.
@@ -24,66 +36,110 @@ type archlinux struct {
 }
 
 // Script compiles the script to build the kernel module and/or the eBPF probe.
-func (c archlinux) Script(cfg Config, kr kernelrelease.KernelRelease) (string, error) {
-	t := template.New(string(TargetTypeArchlinux))
-	parsed, err := t.Parse(archlinuxTemplate)
+func (c archlinux) Script(cfg Config, kr kernelrelease.KernelRelease, sources KernelSources) (string, error) {
+	parsed, err := parseTemplate(cfg, string(TargetTypeArchlinux), archlinuxTemplate, archlinuxTemplateData{})
 	if err != nil {
 		return "", err
 	}
+	return executeTemplate(parsed, sources.TemplateData)
+}
 
+// ResolveKernelSources implements Builder, resolving the kernel packages of the build.
+func (c archlinux) ResolveKernelSources(ctx context.Context, cfg Config, kr kernelrelease.KernelRelease) (KernelSources, error) {
 	var urls []string
+	var err error
 	if cfg.KernelUrls == nil {
 		// Check (and filter) existing kernels before continuing
-		urls, err = getResolvingURLs(fetchArchlinuxKernelURLS(kr, cfg.KernelVersion))
+		urls, err = getResolvingURLs(ctx, fetchArchlinuxKernelURLS(kr, cfg.KernelVersion))
 	} else {
-		urls, err = getResolvingURLs(cfg.KernelUrls)
+		urls, err = getResolvingURLs(ctx, cfg.KernelUrls)
 	}
 	if err != nil {
-		return "", err
+		return KernelSources{}, err
+	}
+
+	crossCompilePrefix, err := crossCompile(cfg)
+	if err != nil {
+		return KernelSources{}, err
 	}
 
 	td := archlinuxTemplateData{
 		DriverBuildDir:    DriverDirectory,
 		ModuleDownloadURL: moduleDownloadURL(cfg),
 		KernelDownloadURL: urls[0],
-		GCCVersion:        archlinuxGccVersionFromKernelRelease(kr),
+		GCCVersion:        gccVersion(cfg, archlinuxGccVersionFromKernelRelease(kr)),
 		ModuleDriverName:  cfg.DriverName,
 		ModuleFullPath:    ModuleFullPath,
 		BuildModule:       len(cfg.Build.ModuleFilePath) > 0,
 		BuildProbe:        len(cfg.Build.ProbeFilePath) > 0,
+		KernelArch:        kr.Architecture.ToKernel(),
+		LLVMVersion:       llvmVersion(cfg, "12"),
+		CrossCompile:      crossCompilePrefix,
 	}
+	td.buildTemplateData = newBuildTemplateData(cfg)
+	return KernelSources{URLs: urls[:1], TemplateData: td}, nil
+}
 
-	buf := bytes.NewBuffer(nil)
-	err = parsed.Execute(buf, td)
-	if err != nil {
-		return "", err
+// archlinuxPackageFromKernelRelease returns the package name, version and release of the kernel,
+// the package release is taken from the kernel version when missing from the kernel release.
+// Example: Input -> "6.6.8-zen1-1-zen", Output -> "linux-zen", "6.6.8.zen1", "1"
+// Example: Input -> "6.1.69-1-lts", Output -> "linux-lts", "6.1.69", "1"
+func archlinuxPackageFromKernelRelease(kr kernelrelease.KernelRelease, kv string) (string, string, string) {
+	name := "linux"
+	extra := strings.TrimPrefix(kr.FullExtraversion, "-")
+	for _, flavor := range archlinuxFlavors {
+		if strings.HasSuffix(extra, "-"+flavor) {
+			name = fmt.Sprintf("linux-%s", flavor)
+			extra = strings.TrimSuffix(extra, "-"+flavor)
+			break
+		}
+	}
+
+	pkgrel := kv
+	parts := strings.Split(extra, "-")
+	if _, err := strconv.Atoi(parts[len(parts)-1]); err == nil {
+		pkgrel = parts[len(parts)-1]
+		parts = parts[:len(parts)-1]
+	}
+
+	pkgver := kr.Fullversion
+	if local := strings.Join(parts, "-"); local != "" {
+		pkgver = fmt.Sprintf("%s.%s", kr.Fullversion, local)
 	}
-	return buf.String(), nil
+	return name, pkgver, pkgrel
 }
 
 func fetchArchlinuxKernelURLS(kr kernelrelease.KernelRelease, kv string) []string {
 	urls := []string{}
+	name, pkgver, pkgrel := archlinuxPackageFromKernelRelease(kr, kv)
 
-	if kr.Architecture == "amd64" {
-		urls = append(urls, fmt.Sprintf(
-			"https://archive.archlinux.org/packages/l/linux-headers/linux-headers-%s.%s-%s-%s.pkg.tar.xz",
-			kr.Fullversion,
-			kr.Extraversion,
-			kv,
-			kr.Architecture.ToNonDeb()))
-	} else {
-		urls = append(urls, fmt.Sprintf(
-			"http://tardis.tiny-vps.com/aarm/packages/l/linux-%s-headers/linux-%s-headers-%s-%s-%s.pkg.tar.xz",
-			kr.Architecture.ToNonDeb(),
-			kr.Architecture.ToNonDeb(),
-			kr.Fullversion,
-			kv,
-			kr.Architecture.ToNonDeb()))
+	// packages are compressed with zstd since 2020, with xz before
+	for _, ext := range []string{"zst", "xz"} {
+		if kr.Architecture == "amd64" {
+			urls = append(urls, fmt.Sprintf(
+				"https://archive.archlinux.org/packages/l/%s-headers/%s-headers-%s-%s-%s.pkg.tar.%s",
+				name,
+				name,
+				pkgver,
+				pkgrel,
+				kr.Architecture.ToNonDeb(),
+				ext))
+		} else {
+			urls = append(urls, fmt.Sprintf(
+				"http://tardis.tiny-vps.com/aarm/packages/l/linux-%s-headers/linux-%s-headers-%s-%s-%s.pkg.tar.%s",
+				kr.Architecture.ToNonDeb(),
+				kr.Architecture.ToNonDeb(),
+				kr.Fullversion,
+				pkgrel,
+				kr.Architecture.ToNonDeb(),
+				ext))
+		}
 	}
 	return urls
 }
 
 type archlinuxTemplateData struct {
+	buildTemplateData
 	DriverBuildDir    string
 	ModuleDownloadURL string
 	KernelDownloadURL string
@@ -92,6 +148,9 @@ type archlinuxTemplateData struct {
 	ModuleFullPath    string
 	BuildModule       bool
 	BuildProbe        bool
+	KernelArch        string
+	LLVMVersion       string
+	CrossCompile      string
 }
 
 func archlinuxGccVersionFromKernelRelease(kr kernelrelease.KernelRelease) string {
@@ -0,0 +1,174 @@
+package builder
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+//go:embed templates/bottlerocket.sh
+var bottlerocketTemplate string
+
+// TargetTypeBottlerocket identifies the Bottlerocket target.
+const TargetTypeBottlerocket Type = "bottlerocket"
+
+const bottlerocketRepoURL = "https://updates.bottlerocket.aws"
+
+// bottlerocketMetadataVersion is the TUF metadata layout version used by the Bottlerocket repositories.
+const bottlerocketMetadataVersion = "2020-07-07"
+
+func init() {
+	BuilderByTarget[TargetTypeBottlerocket] = &bottlerocket{}
+}
+
+// bottlerocket is a driverkit target.
+type bottlerocket struct {
+}
+
+// Metadata implements MetadataProvider, the kernel version tells the variant (eg. aws-k8s-1.24).
+// The builds use the toolchain of the kmod kit, not the cross ones of the builder image.
+func (c bottlerocket) Metadata() Metadata {
+	m := DefaultMetadata()
+	m.RequiresKernelVersion = true
+	m.CrossCompile = false
+	m.GCC = false
+	return m
+}
+
+type bottlerocketTemplateData struct {
+	buildTemplateData
+	DriverBuildDir    string
+	ModuleDownloadURL string
+	KernelDownloadURL string
+	KernelSHA256      string
+	KernelArch        string
+	LLVMVersion       string
+	CrossCompile      string
+	ModuleDriverName  string
+	ModuleFullPath    string
+	BuildModule       bool
+	BuildProbe        bool
+}
+
+// Script compiles the script to build the kernel module and/or the eBPF probe.
+//
+// The Bottlerocket version is expected in the kernel release (e.g. 1.13.1),
+// while the variant (e.g. aws-k8s-1.24) is expected in the kernel version.
+func (c bottlerocket) Script(cfg Config, kr kernelrelease.KernelRelease, sources KernelSources) (string, error) {
+	parsed, err := parseTemplate(cfg, string(TargetTypeBottlerocket), bottlerocketTemplate, bottlerocketTemplateData{})
+	if err != nil {
+		return "", err
+	}
+	return executeTemplate(parsed, sources.TemplateData)
+}
+
+// ResolveKernelSources implements Builder, resolving the kernel packages of the build.
+func (c bottlerocket) ResolveKernelSources(ctx context.Context, cfg Config, kr kernelrelease.KernelRelease) (KernelSources, error) {
+	var kitURL, kitSHA256 string
+	var err error
+	if cfg.KernelUrls == nil {
+		kitURL, kitSHA256, err = fetchBottlerocketKmodKitURL(ctx, kr.Architecture, cfg.KernelVersion, kr.Fullversion)
+	} else {
+		var urls []string
+		urls, err = getResolvingURLs(ctx, cfg.KernelUrls)
+		if err == nil {
+			kitURL = urls[0]
+		}
+	}
+	if err != nil {
+		return KernelSources{}, err
+	}
+
+	td := bottlerocketTemplateData{
+		DriverBuildDir:    DriverDirectory,
+		ModuleDownloadURL: moduleDownloadURL(cfg),
+		KernelDownloadURL: kitURL,
+		KernelSHA256:      kitSHA256,
+		KernelArch:        kr.Architecture.ToKernel(),
+		LLVMVersion:       llvmVersion(cfg, "12"),
+		CrossCompile:      fmt.Sprintf("%s-bottlerocket-linux-musl-", kr.Architecture.ToNonDeb()),
+		ModuleDriverName:  cfg.DriverName,
+		ModuleFullPath:    ModuleFullPath,
+		BuildModule:       len(cfg.Build.ModuleFilePath) > 0,
+		BuildProbe:        len(cfg.Build.ProbeFilePath) > 0,
+	}
+	td.buildTemplateData = newBuildTemplateData(cfg)
+	return KernelSources{URLs: []string{kitURL}, TemplateData: td}, nil
+}
+
+type bottlerocketMetaFile struct {
+	Version int `json:"version"`
+}
+
+type bottlerocketTarget struct {
+	Hashes struct {
+		SHA256 string `json:"sha256"`
+	} `json:"hashes"`
+}
+
+type bottlerocketMetadata struct {
+	Signed struct {
+		Meta    map[string]bottlerocketMetaFile `json:"meta"`
+		Targets map[string]bottlerocketTarget   `json:"targets"`
+	} `json:"signed"`
+}
+
+// bottlerocketKmodKitName returns the name of the kmod kit target in the TUF repository,
+// aarch64 variants publish their own kit.
+// Example: bottlerocket-aws-k8s-1.24-x86_64-kmod-kit-v1.13.1.tar.xz
+func bottlerocketKmodKitName(architecture kernelrelease.Architecture, variant, version string) string {
+	return fmt.Sprintf("bottlerocket-%s-%s-kmod-kit-v%s.tar.xz", variant, architecture.ToNonDeb(), version)
+}
+
+// fetchBottlerocketKmodKitURL walks the TUF metadata (timestamp -> snapshot -> targets) of the variant repository
+// to find the kmod kit target, returning its URL and its expected sha256.
+func fetchBottlerocketKmodKitURL(ctx context.Context, architecture kernelrelease.Architecture, variant, version string) (string, string, error) {
+	if variant == "" {
+		return "", "", fmt.Errorf("bottlerocket variant not provided")
+	}
+	metadataURL := fmt.Sprintf("%s/%s/%s/%s", bottlerocketRepoURL, bottlerocketMetadataVersion, variant, architecture.ToNonDeb())
+
+	timestamp, err := fetchBottlerocketMetadata(ctx, fmt.Sprintf("%s/timestamp.json", metadataURL))
+	if err != nil {
+		return "", "", err
+	}
+	snapshotMeta, ok := timestamp.Signed.Meta["snapshot.json"]
+	if !ok {
+		return "", "", fmt.Errorf("snapshot metadata not found for variant: %s", variant)
+	}
+
+	snapshot, err := fetchBottlerocketMetadata(ctx, fmt.Sprintf("%s/%d.snapshot.json", metadataURL, snapshotMeta.Version))
+	if err != nil {
+		return "", "", err
+	}
+	targetsMeta, ok := snapshot.Signed.Meta["targets.json"]
+	if !ok {
+		return "", "", fmt.Errorf("targets metadata not found for variant: %s", variant)
+	}
+
+	targets, err := fetchBottlerocketMetadata(ctx, fmt.Sprintf("%s/%d.targets.json", metadataURL, targetsMeta.Version))
+	if err != nil {
+		return "", "", err
+	}
+
+	name := bottlerocketKmodKitName(architecture, variant, version)
+	target, ok := targets.Signed.Targets[name]
+	if !ok {
+		return "", "", fmt.Errorf("kernel headers not found")
+	}
+
+	// targets are stored with consistent snapshots, prefixed by their hash
+	kitURL := fmt.Sprintf("%s/targets/%s.%s", bottlerocketRepoURL, target.Hashes.SHA256, name)
+	Logger(ctx).WithField("url", kitURL).Debug("kmod kit found")
+	return kitURL, target.Hashes.SHA256, nil
+}
+
+func fetchBottlerocketMetadata(ctx context.Context, u string) (*bottlerocketMetadata, error) {
+	metadata := bottlerocketMetadata{}
+	if err := getJSON(ctx, u, &metadata); err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
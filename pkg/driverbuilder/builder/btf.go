@@ -0,0 +1,119 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+// BTFFileName is the standard file name for the BTF of the kernel.
+const BTFFileName = "vmlinux.btf"
+
+// BTFFullPath is the standard path for the BTF of the kernel, the builders generating it place it at this location.
+var BTFFullPath = path.Join(DriverDirectory, "btf", BTFFileName)
+
+// resolveDebugPackage returns the first resolving candidate of the debug package of the kernel the BTF is generated from, with its checksum.
+// It returns none, with a warning, when none resolves or its checksum cannot be found: the build goes on without the BTF.
+func resolveDebugPackage(ctx context.Context, c Config, candidates []string, lookup checksumLookup) (string, string, error) {
+	if len(candidates) == 0 {
+		Logger(ctx).Warn("no debuginfo package known for the kernel, skipping its BTF")
+		return "", "", nil
+	}
+	urls, err := getResolvingURLs(ctx, candidates)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return "", "", ctxErr
+	}
+	if err != nil {
+		Logger(ctx).WithField("candidates", strings.Join(candidates, ", ")).Warn("no debuginfo package found for the kernel, skipping its BTF")
+		return "", "", nil
+	}
+	u := urls[0]
+	if c.SkipChecksum || isLocalURL(u) {
+		return u, "", nil
+	}
+	sum, ok := lookup(ctx, []string{u})[u]
+	if !ok {
+		Logger(ctx).WithField("url", u).Warn("unable to find the checksum of the debuginfo package, skipping the BTF of the kernel, use --skip-checksum to generate it anyway")
+		return "", "", nil
+	}
+	return u, sum, nil
+}
+
+// withDebugPackage appends the debug package of the kernel to its packages when the BTF is asked for and the package is found,
+// its checksum to theirs, also telling whether the BTF is generated.
+func withDebugPackage(ctx context.Context, c Config, urls []string, sums map[string]string, candidates func() []string, lookup checksumLookup) ([]string, map[string]string, bool, error) {
+	if len(c.BTFFilePath) == 0 {
+		return urls, sums, false, nil
+	}
+	u, sum, err := resolveDebugPackage(ctx, c, candidates(), lookup)
+	if err != nil || len(u) == 0 {
+		return urls, sums, false, err
+	}
+	if len(sum) > 0 {
+		if sums == nil {
+			sums = map[string]string{}
+		}
+		sums[u] = sum
+	}
+	return append(urls, u), sums, true, nil
+}
+
+// debianDebugURLs returns the candidates of the debug package of the kernel, next to its headers in the same pool and of the same version.
+// Example: linux-headers-5.10.0-27-amd64_5.10.205-2_amd64.deb -> linux-image-5.10.0-27-amd64-dbg_5.10.205-2_amd64.deb
+func debianDebugURLs(kr kernelrelease.KernelRelease, headersURLs []string) []string {
+	headers := "/linux-headers-" + kr.Fullversion + kr.FullExtraversion + "_"
+	for _, u := range headersURLs {
+		if strings.Contains(u, headers) {
+			return []string{strings.Replace(u, headers, "/linux-image-"+kr.Fullversion+kr.FullExtraversion+"-dbg_", 1)}
+		}
+	}
+	return nil
+}
+
+// ubuntuDebugBaseURL is the archive of the Ubuntu debug symbols packages, of every architecture.
+var ubuntuDebugBaseURL = "http://ddebs.ubuntu.com/pool/main/l"
+
+// ubuntuDebugURLs returns the candidates of the debug symbols package of the kernel, published by the source package of its headers,
+// either unsigned or not.
+// Example: .../linux-aws/linux-headers-5.15.0-1052-aws_5.15.0-1052.57_amd64.deb -> .../linux-aws/linux-image-unsigned-5.15.0-1052-aws-dbgsym_5.15.0-1052.57_amd64.ddeb
+func ubuntuDebugURLs(kr kernelrelease.KernelRelease, headersURLs []string) []string {
+	release := kr.Fullversion + kr.FullExtraversion
+	pattern := regexp.MustCompile(`/pool/main/l/([^/]+)/linux-headers-` + regexp.QuoteMeta(release) + `_([^_/]+)_([^_/]+)\.deb$`)
+	for _, u := range headersURLs {
+		if match := pattern.FindStringSubmatch(u); match != nil {
+			return []string{
+				fmt.Sprintf("%s/%s/linux-image-unsigned-%s-dbgsym_%s_%s.ddeb", ubuntuDebugBaseURL, match[1], release, match[2], match[3]),
+				fmt.Sprintf("%s/%s/linux-image-%s-dbgsym_%s_%s.ddeb", ubuntuDebugBaseURL, match[1], release, match[2], match[3]),
+			}
+		}
+	}
+	return nil
+}
+
+// centosDebugBaseURL is the archive of the CentOS debuginfo packages.
+var centosDebugBaseURL = "http://debuginfo.centos.org"
+
+// centosMajorPattern matches the major version of the dist tag of the CentOS kernel releases, e.g. the 7 of el7.
+var centosMajorPattern = regexp.MustCompile(`\.el(\d+)`)
+
+// centosDebugURLs returns the candidates of the debuginfo package of the kernel, the one shipping its vmlinux.
+// Example: 3.10.0-1160.el7.x86_64 -> http://debuginfo.centos.org/7/x86_64/kernel-debuginfo-3.10.0-1160.el7.x86_64.rpm
+func centosDebugURLs(kr kernelrelease.KernelRelease) []string {
+	match := centosMajorPattern.FindStringSubmatch(kr.FullExtraversion)
+	if match == nil {
+		return nil
+	}
+	arch := kr.Architecture.ToNonDeb()
+	release := kr.Fullversion + kr.FullExtraversion
+	if !strings.HasSuffix(release, "."+arch) {
+		release += "." + arch
+	}
+	return []string{
+		fmt.Sprintf("%s/%s/%s/kernel-debuginfo-%s.rpm", centosDebugBaseURL, match[1], arch, release),
+		fmt.Sprintf("%s/%s/%s/Packages/kernel-debuginfo-%s.rpm", centosDebugBaseURL, match[1], arch, release),
+	}
+}
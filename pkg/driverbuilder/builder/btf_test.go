@@ -0,0 +1,121 @@
+package builder
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+func TestDebugURLs(t *testing.T) {
+	tests := map[string]struct {
+		urls func() []string
+		want []string
+	}{
+		"debian": {
+			urls: func() []string {
+				kr := kernelrelease.FromString("5.10.0-27-amd64")
+				return debianDebugURLs(kr, []string{
+					"https://deb.debian.org/debian/pool/main/l/linux/linux-headers-5.10.0-27-common_5.10.205-2_all.deb",
+					"https://deb.debian.org/debian/pool/main/l/linux/linux-headers-5.10.0-27-amd64_5.10.205-2_amd64.deb",
+				})
+			},
+			want: []string{"https://deb.debian.org/debian/pool/main/l/linux/linux-image-5.10.0-27-amd64-dbg_5.10.205-2_amd64.deb"},
+		},
+		"debian without headers": {
+			urls: func() []string {
+				return debianDebugURLs(kernelrelease.FromString("5.10.0-27-amd64"), []string{"https://example.com/headers.deb"})
+			},
+		},
+		"ubuntu": {
+			urls: func() []string {
+				kr := kernelrelease.FromString("5.15.0-1052-aws")
+				return ubuntuDebugURLs(kr, []string{
+					"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-aws/linux-aws-headers-5.15.0-1052_5.15.0-1052.57_all.deb",
+					"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-aws/linux-headers-5.15.0-1052-aws_5.15.0-1052.57_amd64.deb",
+				})
+			},
+			want: []string{
+				"http://ddebs.ubuntu.com/pool/main/l/linux-aws/linux-image-unsigned-5.15.0-1052-aws-dbgsym_5.15.0-1052.57_amd64.ddeb",
+				"http://ddebs.ubuntu.com/pool/main/l/linux-aws/linux-image-5.15.0-1052-aws-dbgsym_5.15.0-1052.57_amd64.ddeb",
+			},
+		},
+		"centos": {
+			urls: func() []string {
+				kr := kernelrelease.FromString("3.10.0-1160.el7.x86_64")
+				kr.Architecture = "amd64"
+				return centosDebugURLs(kr)
+			},
+			want: []string{
+				"http://debuginfo.centos.org/7/x86_64/kernel-debuginfo-3.10.0-1160.el7.x86_64.rpm",
+				"http://debuginfo.centos.org/7/x86_64/Packages/kernel-debuginfo-3.10.0-1160.el7.x86_64.rpm",
+			},
+		},
+		"centos without arch": {
+			urls: func() []string {
+				kr := kernelrelease.FromString("4.18.0-305.el8")
+				kr.Architecture = "arm64"
+				return centosDebugURLs(kr)
+			},
+			want: []string{
+				"http://debuginfo.centos.org/8/aarch64/kernel-debuginfo-4.18.0-305.el8.aarch64.rpm",
+				"http://debuginfo.centos.org/8/aarch64/Packages/kernel-debuginfo-4.18.0-305.el8.aarch64.rpm",
+			},
+		},
+		"centos not el": {
+			urls: func() []string {
+				return centosDebugURLs(kernelrelease.FromString("5.14.10-300.fc35.x86_64"))
+			},
+		},
+	}
+	for name, test := range tests {
+		if got := test.urls(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Test Input: '%s' | Got: '%v' / Want: '%v'", name, got, test.want)
+		}
+	}
+}
+
+func TestWithDebugPackage(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/linux-image-dbg.deb", func(w http.ResponseWriter, r *http.Request) {})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	headers := []string{server.URL + "/linux-headers.deb"}
+	found := func() []string { return []string{server.URL + "/missing.deb", server.URL + "/linux-image-dbg.deb"} }
+	missing := func() []string { return []string{server.URL + "/missing.deb"} }
+	sum := func(_ context.Context, urls []string) map[string]string {
+		return map[string]string{urls[0]: "1111111111111111111111111111111111111111111111111111111111111111"}
+	}
+	none := func(context.Context, []string) map[string]string { return map[string]string{} }
+
+	tests := map[string]struct {
+		build      *Build
+		candidates func() []string
+		lookup     checksumLookup
+		urls       []string
+		btf        bool
+	}{
+		"not asked for": {&Build{}, found, sum, headers, false},
+		"found":         {&Build{BTFFilePath: "/tmp/vmlinux.btf"}, found, sum, append(headers, server.URL+"/linux-image-dbg.deb"), true},
+		"missing":       {&Build{BTFFilePath: "/tmp/vmlinux.btf"}, missing, sum, headers, false},
+		"unknown":       {&Build{BTFFilePath: "/tmp/vmlinux.btf"}, func() []string { return nil }, sum, headers, false},
+		"no checksum":   {&Build{BTFFilePath: "/tmp/vmlinux.btf"}, found, none, headers, false},
+		"skip checksum": {&Build{BTFFilePath: "/tmp/vmlinux.btf", SkipChecksum: true}, found, none, append(headers, server.URL+"/linux-image-dbg.deb"), true},
+	}
+	for name, test := range tests {
+		urls, sums, btf, err := withDebugPackage(context.Background(), Config{Build: test.build}, headers, nil, test.candidates, test.lookup)
+		if err != nil {
+			t.Fatalf("Unexpected error encountered | Test Input: '%s' | Error: '%s'", name, err)
+		}
+		if !reflect.DeepEqual(urls, test.urls) || btf != test.btf {
+			t.Errorf("Test Input: '%s' | Got: '%v %v' / Want: '%v %v'", name, urls, btf, test.urls, test.btf)
+		}
+		if btf && !test.build.SkipChecksum && len(sums[urls[len(urls)-1]]) == 0 {
+			t.Errorf("Test Input: '%s' | Got: '%v' / Want: [ the checksum of the debug package ]", name, sums)
+		}
+	}
+}
@@ -2,20 +2,99 @@ package builder
 
 import "github.com/falcosecurity/driverkit/pkg/kernelrelease"
 
+// NoKernelConfigData is the base64 encoded placeholder used when no kernel config data is provided.
+const NoKernelConfigData = "bm8tZGF0YQ==" // no-data
+
 // Build contains the info about the on-going build.
 type Build struct {
-	TargetType         Type
-	KernelConfigData   string
-	KernelRelease      string
-	KernelVersion      string
-	DriverVersion      string
-	Architecture       string
-	ModuleFilePath     string
-	ProbeFilePath      string
+	TargetType       Type
+	KernelConfigData string
+	KernelRelease    string
+	KernelVersion    string
+	DriverVersion    string
+	Architecture     string
+	// PackageArchitecture is the architecture of the kernel packages, the one of the build when empty,
+	// e.g. armel rather than armhf for the 32 bit arm builds, told by the flavor of the kernel release otherwise.
+	PackageArchitecture string
+	ModuleFilePath      string
+	ProbeFilePath       string
+	// ModernProbeFilePath is the path of the skeleton of the modern eBPF probe, if built.
+	// The probe is CO-RE, it needs the BTF of the kernel rather than its headers.
+	ModernProbeFilePath string
+	// BTFFilePath is the path of the raw BTF of the kernel, if generated from its debug package.
+	BTFFilePath string
+	// DKMSFilePath is the path of the DKMS source package of the kernel module, a .tar.gz, if assembled.
+	DKMSFilePath       string
 	ModuleDriverName   string
 	ModuleDeviceName   string
 	CustomBuilderImage string
 	KernelUrls         []string
+	LLVMVersion        string
+	GCCVersion         string
+	CacheDir           string
+	SkipChecksum       bool
+	LocalKernelDir     string
+	// TemplateOverride is the path of the template replacing the embedded one of the target, if any.
+	// It gets the same data as the embedded one.
+	TemplateOverride string
+	// ImageRepo is the repository the builder image is selected from, by target, architecture and gcc version,
+	// when no builder image other than the base one is given.
+	ImageRepo string
+	// CcacheDir is the ccache directory the kernel module is compiled with, kept between the builds:
+	// a directory of the docker host or of the kubernetes node, or a persistent volume claim as pvc:<name> on kubernetes.
+	CcacheDir string
+	// Env are the variables given to the build script, exported before building and set on the builder container or pod.
+	Env map[string]string
+	// MakeFlags are the extra flags of every make invocation of the build script, e.g. -j8 or KCFLAGS=-Wno-error.
+	MakeFlags string
+	// Checksum is the algorithm of the checksum files written next to the artifacts, either sha256 or sha512, none when empty.
+	Checksum string
+	// Compression is the algorithm the artifacts are compressed with once built, if any.
+	// The compressed artifacts get the extension of the algorithm appended to their path, e.g. .ko.gz.
+	Compression string
+	// ModuleSigningKey and ModuleSigningCert are the key pair signing the kernel module for Secure Boot, if any.
+	ModuleSigningKey  string
+	ModuleSigningCert string
+	// RHELEntitlementCert and RHELEntitlementKey are the entitlement certificate and key of a Red Hat subscription, e.g. the ones of /etc/pki/entitlement,
+	// the redhat target downloads the kernel from the Red Hat CDN with them when given.
+	RHELEntitlementCert string
+	RHELEntitlementKey  string
+	// KernelConfigFragments are merged into the kernel config data by the vanilla target, each one given inline,
+	// e.g. CONFIG_FTRACE_SYSCALLS=y, or as the path of its file.
+	KernelConfigFragments []string
+	// VerifyKernelSignature verifies the kernel.org tarball of the vanilla target against its detached signature,
+	// made by one of the pinned kernel.org keys.
+	VerifyKernelSignature bool
+	// DriverRepo is the GitHub repository, as owner/name, the driver sources are downloaded from at the driver version,
+	// DefaultDriverRepo when empty.
+	DriverRepo string
+	// DriverUseGit clones the driver sources at the driver version with git rather than downloading their tarball.
+	DriverUseGit bool
+	// LocalDriverDir is the local tree of the libs the driver is built from, instead of downloading its sources, e.g. a working copy.
+	LocalDriverDir string
+	// KernelURLsAuth, as user:password, and KernelURLsToken, a bearer token, authenticate the downloads of the kernel URLs,
+	// only the hosts of the custom ones get them.
+	KernelURLsAuth  string
+	KernelURLsToken string
+	// ModuleS3URL and ProbeS3URL are the templated s3:// URLs the artifacts are uploaded to, if any.
+	ModuleS3URL string
+	ProbeS3URL  string
+	// S3Endpoint is the S3 compatible storage to upload to instead of AWS, e.g. MinIO.
+	S3Endpoint string
+	// OCIRef is the templated reference the artifacts are pushed to as an OCI artifact, if any.
+	OCIRef string
+	// OCIInsecure allows pushing to registries over plain HTTP.
+	OCIInsecure bool
+	// BuildLogPath is the templated path the output of the build script is saved to, if any.
+	BuildLogPath string
+	// ForceEmulation runs the builds for another architecture emulated, on a builder image of their architecture,
+	// instead of cross compiling them from the amd64 one.
+	ForceEmulation bool
+	// Mirrors are the base URLs the kernel packages are looked for into, by target, probed before the built-in ones of the target,
+	// with their same layout, see MirrorsProvider.
+	Mirrors map[string][]string
+	// ReplaceMirrors probes the mirrors of the build instead of the built-in ones of their target.
+	ReplaceMirrors bool
 }
 
 func (b *Build) KernelReleaseFromBuildConfig() kernelrelease.KernelRelease {
@@ -23,3 +102,8 @@ func (b *Build) KernelReleaseFromBuildConfig() kernelrelease.KernelRelease {
 	kv.Architecture = kernelrelease.Architecture(b.Architecture)
 	return kv
 }
+
+// HasKernelConfigData tells whether the user provided the kernel config data.
+func (b *Build) HasKernelConfigData() bool {
+	return len(b.KernelConfigData) > 0 && b.KernelConfigData != NoKernelConfigData
+}
@@ -1,14 +1,20 @@
 package builder
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+	"github.com/falcosecurity/driverkit/pkg/metrics"
 	"log"
 	"net/http"
 	"net/url"
 	"path"
-
-	logger "github.com/sirupsen/logrus"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
 )
 
 // DriverDirectory is the directory the processor uses to store the driver.
@@ -20,23 +26,110 @@ const ModuleFileName = "module.ko"
 // ProbeFileName is the standard file name for the eBPF probe.
 const ProbeFileName = "probe.o"
 
+// ModernProbeFileName is the standard file name for the skeleton of the modern eBPF probe.
+const ModernProbeFileName = "bpf_probe.skel.h"
+
 // ModuleFullPath is the standard path for the kernel module. Builders must place the compiled module at this location.
 var ModuleFullPath = path.Join(DriverDirectory, ModuleFileName)
 
 // ProbeFullPath is the standard path for the eBPF probe. Builders must place the compiled probe at this location.
 var ProbeFullPath = path.Join(DriverDirectory, "bpf", ProbeFileName)
 
+// ModernProbeFullPath is the standard path for the skeleton of the modern eBPF probe, the build scripts place it at this location.
+var ModernProbeFullPath = path.Join(DriverDirectory, "modern_bpf", ModernProbeFileName)
+
+// ArtifactLogsDirectory is the directory the build scripts log the builds of the kernel module and of the eBPF probe into,
+// they are built apart so that the failure of one does not prevent the other artifacts from being built.
+const ArtifactLogsDirectory = DriverDirectory + "/logs"
+
+// ArtifactFailurePath is the file the build scripts write the tail of the build log of the artifact, module or probe, into when it fails to build.
+func ArtifactFailurePath(artifact string) string {
+	return path.Join(ArtifactLogsDirectory, artifact+".failed")
+}
+
+// LocalKernelDirectory is the directory the processors copy the packages of the local kernel directory to.
+const LocalKernelDirectory = "/tmp/driverkit-kernel"
+
+// LocalDriverDirectory is the directory the processors copy the sources of the local driver directory to, a tree of the libs.
+const LocalDriverDirectory = "/tmp/driverkit-driver"
+
+// CcacheDirectory is the directory the processors mount the ccache directory of the build at.
+const CcacheDirectory = "/tmp/driverkit-ccache"
+
+// CcachePVCPrefix prefixes the ccache directories that are persistent volume claims, e.g. pvc:driverkit-ccache.
+const CcachePVCPrefix = "pvc:"
+
 // Config contains all the configurations needed to build the kernel module or the eBPF probe.
 type Config struct {
 	DriverName      string
 	DeviceName      string
 	DownloadBaseURL string
+	// HTTPTimeout is the timeout of each request to the mirrors, DefaultHTTPTimeout when zero.
+	HTTPTimeout time.Duration
+	// HTTPRetries is the number of attempts of each request to the mirrors, DefaultHTTPRetries when zero.
+	HTTPRetries int
+	// HTTPConcurrency is the number of candidate URLs checked at the same time, DefaultHTTPConcurrency when zero.
+	HTTPConcurrency int
+	// ProxyURL is the proxy the requests to the mirrors go through.
+	ProxyURL string
+	// CABundle contains the PEM encoded certificates trusted in addition to the system ones.
+	CABundle []byte
+	// RHELEntitlementCert and RHELEntitlementKey are the PEM encoded entitlement of the build, if any, read by the processor.
+	// They must never be logged nor reported.
+	RHELEntitlementCert []byte
+	RHELEntitlementKey  []byte
+	// BuilderArchitecture is the architecture the script runs on, the one of the build when empty.
+	// The builders cross compile for the build when they differ.
+	BuilderArchitecture string
+	// indexFetcher fetches the index pages of the mirrors when resolving the kernel sources, the HTTP one when nil,
+	// e.g. the tests fetch the recorded ones.
+	indexFetcher indexFetcher
 	*Build
 }
 
-// Builder represents a builder capable of generating a script for a driverkit target.
+// Builder represents a builder capable of generating a script for a driverkit target, in two steps:
+// it resolves the kernel sources of the build, e.g. from the mirrors of the target, then renders the script from them without any further request.
 type Builder interface {
-	Script(c Config, kr kernelrelease.KernelRelease) (string, error)
+	ResolveKernelSources(ctx context.Context, c Config, kr kernelrelease.KernelRelease) (KernelSources, error)
+	Script(c Config, kr kernelrelease.KernelRelease, sources KernelSources) (string, error)
+}
+
+// KernelSources are what a builder resolved for a build, its script is rendered from them.
+type KernelSources struct {
+	// URLs are the kernel packages the script downloads, if any.
+	URLs []string
+	// TemplateData is the data the template of the target is rendered with.
+	TemplateData interface{}
+	// script is the one of the legacy builders, generated while resolving.
+	script string
+}
+
+// LegacyBuilder is a builder generating its script in one step, resolving the kernel packages meanwhile.
+//
+// Deprecated: implement Builder, the legacy builders are adapted to it with FromLegacy.
+type LegacyBuilder interface {
+	Script(ctx context.Context, c Config, kr kernelrelease.KernelRelease) (string, error)
+}
+
+// FromLegacy adapts the legacy builder to Builder: its script is generated when resolving the kernel sources, rendering returns it.
+func FromLegacy(b LegacyBuilder) Builder {
+	return legacyBuilder{legacy: b}
+}
+
+type legacyBuilder struct {
+	legacy LegacyBuilder
+}
+
+func (b legacyBuilder) ResolveKernelSources(ctx context.Context, c Config, kr kernelrelease.KernelRelease) (KernelSources, error) {
+	script, err := b.legacy.Script(ctx, c, kr)
+	if err != nil {
+		return KernelSources{}, err
+	}
+	return KernelSources{script: script}, nil
+}
+
+func (b legacyBuilder) Script(c Config, kr kernelrelease.KernelRelease, sources KernelSources) (string, error) {
+	return sources.script, nil
 }
 
 // Factory returns a builder for the given target.
@@ -48,42 +141,393 @@ func Factory(target Type) (Builder, error) {
 	return b, nil
 }
 
+// DefaultDriverRepo is the GitHub repository of the driver sources, unless the build tells another one.
+const DefaultDriverRepo = "falcosecurity/libs"
+
+// driverRefPattern matches the driver versions that are neither master nor a release, e.g. a commit or refs/pull/123/head.
+var driverRefPattern = regexp.MustCompile(`^([0-9a-f]{7,40}|refs/.+)$`)
+
+// moduleDownloadURL returns the tarball of the driver sources at the driver version: the archive of the release in the download base URL,
+// or the codeload tarball of the ref, e.g. a commit or a pull request branch, of the repository of the build.
+// It is empty when the driver sources are the local ones.
 func moduleDownloadURL(c Config) string {
+	if c.Build != nil && len(c.LocalDriverDir) > 0 {
+		return ""
+	}
+	if c.Build != nil && (len(c.DriverRepo) > 0 || driverRefPattern.MatchString(c.DriverVersion)) {
+		return fmt.Sprintf("https://codeload.github.com/%s/tar.gz/%s", driverRepo(c), c.DriverVersion)
+	}
 	return fmt.Sprintf("%s/%s.tar.gz", c.DownloadBaseURL, c.DriverVersion)
 }
 
+func driverRepo(c Config) string {
+	if c.Build == nil || len(c.DriverRepo) == 0 {
+		return DefaultDriverRepo
+	}
+	return c.DriverRepo
+}
+
+// ShortDriverVersion returns the driver version as named in the paths of the artifacts: the short hash of the commits,
+// the refs with dashes rather than slashes, e.g. refs-pull-123-head, the releases as they are.
+func ShortDriverVersion(version string) string {
+	if sha1Pattern.MatchString(version) {
+		return version[:7]
+	}
+	return strings.ReplaceAll(version, "/", "-")
+}
+
+// sha1Pattern matches the full and abbreviated commit hashes.
+var sha1Pattern = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// llvmVersion returns the LLVM version requested by the user, if any, otherwise the one computed by the builder.
+func llvmVersion(c Config, computed string) string {
+	if len(c.LLVMVersion) > 0 {
+		return c.LLVMVersion
+	}
+	return computed
+}
+
+// gccVersion returns the gcc version requested by the user, if any, otherwise the one computed by the builder.
+func gccVersion(c Config, computed string) string {
+	if len(c.GCCVersion) > 0 {
+		return c.GCCVersion
+	}
+	return computed
+}
+
+// LocalKernelURLs returns the URLs the build scripts install the local kernel packages from,
+// once copied into LocalKernelDirectory.
+func LocalKernelURLs(names []string) []string {
+	urls := make([]string, len(names))
+	for i, name := range names {
+		urls[i] = "file://" + path.Join(LocalKernelDirectory, name)
+	}
+	return urls
+}
+
+// isLocalURL tells whether the URL points to a package copied into the builder.
+func isLocalURL(u string) bool {
+	return strings.HasPrefix(u, "file://")
+}
+
 func resolveURLReference(u string) string {
 	uu, err := url.Parse(u)
 	if err != nil {
 		log.Fatal(err)
 	}
-	base, err := url.Parse(uu.Host)
+	// hosts with a port cannot be parsed as URLs on their own
+	base := &url.URL{Scheme: uu.Scheme, Host: uu.Host}
+	return base.ResolveReference(uu).String()
+}
+
+// urlAttempt is the check of a candidate URL, with the status it answered with or the error reaching it.
+type urlAttempt struct {
+	URL        string
+	StatusCode int
+	Err        error
+}
+
+// resolved tells whether the URL answered with 200, the local ones always do.
+func (a urlAttempt) resolved() bool {
+	return a.Err == nil && (a.StatusCode == http.StatusOK || isLocalURL(a.URL))
+}
+
+func (a urlAttempt) String() string {
+	u := RedactURL(a.URL)
+	switch {
+	case a.Err != nil:
+		return fmt.Sprintf("%s: %s", u, a.Err)
+	case a.StatusCode == 0:
+		return fmt.Sprintf("%s: not checked", u)
+	}
+	return fmt.Sprintf("%s: %d %s", u, a.StatusCode, http.StatusText(a.StatusCode))
+}
+
+// formatURLAttempts lists the attempts, one per line, for the error messages.
+func formatURLAttempts(attempts []urlAttempt) string {
+	lines := make([]string, len(attempts))
+	for i, a := range attempts {
+		lines[i] = a.String()
+	}
+	return strings.Join(lines, "\n  ")
+}
+
+// getResolvingURLs checks the candidate URLs concurrently,
+// the resolving ones are returned in the very same order they were given.
+// It stops as soon as the context is canceled, returning its error,
+// and fails when none resolves, telling why each of them did not.
+func getResolvingURLs(ctx context.Context, urls []string) ([]string, error) {
+	results, attempts, err := resolveURLs(ctx, urls)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	return base.ResolveReference(uu).String()
+	if len(results) == 0 {
+		return nil, fmt.Errorf("kernel not found, tried:\n  %s", formatURLAttempts(attempts))
+	}
+	return results, nil
 }
 
-func getResolvingURLs(urls []string) ([]string, error) {
-	results := []string{}
-	for _, u := range urls {
+// urlResolver checks the candidate URLs of the kernel packages, returning the resolving ones in the very same order they were given
+// together with the attempt of every one of them, in that order too.
+// It only fails when the context is canceled.
+type urlResolver interface {
+	resolve(ctx context.Context, urls []string) ([]string, []urlAttempt, error)
+}
+
+// kernelURLResolver is the resolver of the builders, the tests replace it not to reach the mirrors.
+var kernelURLResolver urlResolver = headURLResolver{}
+
+// resolveURLs checks the candidate URLs with the resolver of the builders, recording the resolving ones into the context.
+func resolveURLs(ctx context.Context, urls []string) ([]string, []urlAttempt, error) {
+	results, attempts, err := kernelURLResolver.resolve(ctx, urls)
+	if err != nil {
+		return nil, nil, err
+	}
+	if r, ok := ctx.Value(resolvedURLsKey{}).(*resolvedURLs); ok && len(results) > 0 {
+		r.add(results)
+	}
+	return results, attempts, nil
+}
+
+// headURLResolver checks the candidate URLs concurrently with HEAD requests, through the HTTP client of the build.
+type headURLResolver struct{}
+
+func (headURLResolver) resolve(ctx context.Context, urls []string) ([]string, []urlAttempt, error) {
+	c := httpClientFrom(ctx)
+	attempts := make([]urlAttempt, len(urls))
+	for i, u := range urls {
 		// in case url has some relative paths
 		// (kernel-crawler does not resolve them for us,
 		// neither it is expected, because they are effectively valid urls),
 		// resolve the absolute one.
 		// HEAD would fail otherwise.
-		u = resolveURLReference(u)
-		res, err := http.Head(u)
-		if err != nil {
+		attempts[i].URL = resolveURLReference(u)
+	}
+
+	indexes := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < c.concurrency && w < len(attempts); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				// local packages cannot be checked from here, they are copied into the builder by the processor
+				if isLocalURL(attempts[i].URL) {
+					continue
+				}
+				res, err := c.Head(attempts[i].URL)
+				if err != nil {
+					// the URL is already the one of the attempt
+					var urlErr *url.Error
+					if errors.As(err, &urlErr) {
+						err = urlErr.Err
+					}
+					attempts[i].Err = err
+					continue
+				}
+				res.Body.Close()
+				attempts[i].StatusCode = res.StatusCode
+			}
+		}()
+	}
+feed:
+	for i := range attempts {
+		select {
+		case indexes <- i:
+		case <-c.ctx.Done():
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	results := []string{}
+	for _, a := range attempts {
+		if a.resolved() {
+			results = append(results, a.URL)
+			Logger(ctx).WithField("url", RedactURL(a.URL)).Debug("kernel header url found")
+		} else {
+			Logger(ctx).WithField("attempt", a.String()).Debug("kernel header url not found")
+		}
+	}
+	return results, attempts, nil
+}
+
+// urlCategory is a kind of package the build needs one of, e.g. the kbuild package, told by the file name of its URLs.
+type urlCategory struct {
+	name  string
+	match func(name string) bool
+}
+
+// urlFileName is the file name of the package the URL points to.
+func urlFileName(u string) string {
+	if uu, err := url.Parse(u); err == nil {
+		return path.Base(uu.Path)
+	}
+	return path.Base(u)
+}
+
+// requireURLCategories fails unless one of the resolved URLs is of each of the categories,
+// every URL being of the first category it matches.
+// The error tells the missing categories, with the attempts of their candidates when given.
+// The local packages are named as the user wants, there must be one per category, whatever their names.
+func requireURLCategories(categories []urlCategory, resolved []string, attempts []urlAttempt) error {
+	local := 0
+	for _, u := range resolved {
+		if isLocalURL(u) {
+			local++
+		}
+	}
+	if local > 0 && local == len(resolved) {
+		if local < len(categories) {
+			return fmt.Errorf("specific kernel headers not found, %d local packages given, %d needed", local, len(categories))
+		}
+		return nil
+	}
+	categoryOf := func(u string) string {
+		name := urlFileName(u)
+		for _, c := range categories {
+			if c.match(name) {
+				return c.name
+			}
+		}
+		return ""
+	}
+	found := map[string]bool{}
+	for _, u := range resolved {
+		found[categoryOf(u)] = true
+	}
+	missing := []string{}
+	for _, c := range categories {
+		if found[c.name] {
 			continue
 		}
-		if res.StatusCode == http.StatusOK {
-			results = append(results, u)
-			logger.WithField("url", u).Debug("kernel header url found")
+		tried := []urlAttempt{}
+		for _, a := range attempts {
+			if categoryOf(a.URL) == c.name {
+				tried = append(tried, a)
+			}
+		}
+		if len(tried) == 0 {
+			missing = append(missing, fmt.Sprintf("%s: no candidate", c.name))
+			continue
 		}
+		missing = append(missing, fmt.Sprintf("%s, tried:\n    %s", c.name, strings.ReplaceAll(formatURLAttempts(tried), "\n  ", "\n    ")))
 	}
-	if len(results) == 0 {
-		return nil, fmt.Errorf("kernel not found")
+	if len(missing) > 0 {
+		return fmt.Errorf("specific kernel headers not found, missing:\n  %s", strings.Join(missing, "\n  "))
 	}
-	return results, nil
+	return nil
+}
+
+// RedactURL returns the URL with the password of the credentials it embeds, if any, redacted, e.g. to log it.
+func RedactURL(u string) string {
+	uu, err := url.Parse(u)
+	if err != nil || uu.User == nil {
+		return u
+	}
+	return uu.Redacted()
+}
+
+type resolvedURLsKey struct{}
+
+// resolvedURLs collects the URLs resolved while generating a build script.
+type resolvedURLs struct {
+	mu   sync.Mutex
+	urls []string
+}
+
+// add records the URLs, with the password of the credentials they embed, if any, redacted.
+func (r *resolvedURLs) add(urls []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, u := range urls {
+		r.urls = append(r.urls, RedactURL(u))
+	}
+}
+
+func (r *resolvedURLs) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string{}, r.urls...)
+}
+
+// WithResolvedURLs returns a context recording the URLs the builders resolve when generating the build script with it,
+// together with the function returning them.
+func WithResolvedURLs(ctx context.Context) (context.Context, func() []string) {
+	r := &resolvedURLs{}
+	return context.WithValue(ctx, resolvedURLsKey{}, r), r.get
+}
+
+type resolutionTimeKey struct{}
+
+// resolutionTime records the time spent generating the build scripts.
+type resolutionTime struct {
+	mu       sync.Mutex
+	duration time.Duration
+}
+
+func (r *resolutionTime) add(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.duration += d
+}
+
+func (r *resolutionTime) get() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.duration
+}
+
+// WithResolutionTime returns a context recording the time the builders take to resolve the kernel packages
+// with ResolveKernelSources, or when generating the build script with Script, together with the function returning it.
+func WithResolutionTime(ctx context.Context) (context.Context, func() time.Duration) {
+	r := &resolutionTime{}
+	return context.WithValue(ctx, resolutionTimeKey{}, r), r.get
+}
+
+// ResolveKernelSources resolves the kernel sources of the build with the builder, from the mirrors of the build if any, observing the time it takes into the resolution metrics.
+func ResolveKernelSources(ctx context.Context, b Builder, c Config, kr kernelrelease.KernelRelease) (KernelSources, error) {
+	if c.indexFetcher != nil {
+		ctx = withIndexFetcher(ctx, c.indexFetcher)
+	}
+	ctx = withBuildMirrors(ctx, c)
+	start := time.Now()
+	sources, err := b.ResolveKernelSources(ctx, c, kr)
+	elapsed := time.Since(start)
+	metrics.ResolutionDuration.WithLabelValues(c.TargetType.String()).Observe(elapsed.Seconds())
+	if r, ok := ctx.Value(resolutionTimeKey{}).(*resolutionTime); ok {
+		r.add(elapsed)
+	}
+	return sources, err
+}
+
+// Script generates the build script with the builder, once it resolved the kernel sources of the build,
+// building the modern eBPF probe and assembling the DKMS package too when asked to.
+func Script(ctx context.Context, b Builder, c Config, kr kernelrelease.KernelRelease) (string, error) {
+	sources, err := ResolveKernelSources(ctx, b, c, kr)
+	if err != nil {
+		return "", err
+	}
+	script, err := b.Script(c, kr, sources)
+	// the modern eBPF probe is built, and the DKMS package assembled, the same way whatever the target
+	if err == nil && len(c.ModernProbeFilePath) > 0 {
+		script, err = withModernProbe(script, c)
+	}
+	if err == nil && len(c.DKMSFilePath) > 0 {
+		script, err = withDKMS(script, c)
+	}
+	return script, err
+}
+
+// getJSON fetches the given URL and decodes its JSON body into v.
+func getJSON(ctx context.Context, u string, v interface{}) error {
+	body, err := getIndex(ctx, u)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
 }
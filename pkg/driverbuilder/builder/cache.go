@@ -0,0 +1,219 @@
+package builder
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+// DefaultCacheTTL is the time the mirror index pages are considered fresh for,
+// once expired they are revalidated with the mirror.
+const DefaultCacheTTL = 30 * time.Minute
+
+// indexCache caches the mirror index pages, in memory and optionally on disk, keyed by URL.
+type indexCache struct {
+	mu      sync.Mutex
+	dir     string
+	ttl     time.Duration
+	entries map[string]*indexCacheEntry
+	hits    int
+	misses  int
+}
+
+type indexCacheEntry struct {
+	URL          string    `json:"url"`
+	Body         []byte    `json:"body"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	FetchedAt    time.Time `json:"fetched_at"`
+}
+
+// indexes is the cache used by the builders, it is kept across builds.
+var indexes = newIndexCache("", DefaultCacheTTL)
+
+func newIndexCache(dir string, ttl time.Duration) *indexCache {
+	return &indexCache{
+		dir:     dir,
+		ttl:     ttl,
+		entries: map[string]*indexCacheEntry{},
+	}
+}
+
+// persistedIndexes are the caches persisted into the cache directories, by directory, the builds sharing one share its cache.
+var persistedIndexes = map[string]*indexCache{}
+var persistedIndexesMu sync.Mutex
+
+// persistedIndexCache returns the cache persisted into the directory, none when no directory is given.
+func persistedIndexCache(dir string) *indexCache {
+	if len(dir) == 0 {
+		return nil
+	}
+	persistedIndexesMu.Lock()
+	defer persistedIndexesMu.Unlock()
+	c, ok := persistedIndexes[dir]
+	if !ok {
+		c = newIndexCache(dir, DefaultCacheTTL)
+		persistedIndexes[dir] = c
+	}
+	return c
+}
+
+// indexFetcher fetches the index pages of the mirrors the kernel packages are looked for in,
+// e.g. their directory listings and repository metadata.
+type indexFetcher interface {
+	fetch(ctx context.Context, u string) ([]byte, error)
+}
+
+// httpIndexFetcher fetches the pages with the HTTP client of the build, fetching them only when not cached or expired
+// into the cache of the client. Expired pages are revalidated using their ETag and Last-Modified headers.
+type httpIndexFetcher struct{}
+
+func (httpIndexFetcher) fetch(ctx context.Context, u string) ([]byte, error) {
+	client := httpClientFrom(ctx)
+	if client.indexes != nil {
+		return client.indexes.get(client, u)
+	}
+	return indexes.get(client, u)
+}
+
+type indexFetcherKey struct{}
+
+// withIndexFetcher returns a context the builders fetch the index pages with the fetcher from.
+func withIndexFetcher(ctx context.Context, f indexFetcher) context.Context {
+	return context.WithValue(ctx, indexFetcherKey{}, f)
+}
+
+// getIndex returns the body of the page, fetched with the fetcher of the context, the HTTP one unless told otherwise, until the context is done.
+func getIndex(ctx context.Context, u string) ([]byte, error) {
+	if f, ok := ctx.Value(indexFetcherKey{}).(indexFetcher); ok {
+		return f.fetch(ctx, u)
+	}
+	return httpIndexFetcher{}.fetch(ctx, u)
+}
+
+// indexGetter returns getIndex bound to the context, for the functions fetching the indexes with a given getter.
+func indexGetter(ctx context.Context) func(u string) ([]byte, error) {
+	return func(u string) ([]byte, error) {
+		return getIndex(ctx, u)
+	}
+}
+
+func (c *indexCache) get(client *retryClient, u string) ([]byte, error) {
+	entry := c.lookup(u)
+	if entry != nil && time.Since(entry.FetchedAt) < c.ttl {
+		c.count(u, true)
+		return entry.Body, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if entry != nil {
+		if entry.ETag != "" {
+			req.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if entry != nil && res.StatusCode == http.StatusNotModified {
+		c.count(u, true)
+		revalidated := *entry
+		revalidated.FetchedAt = time.Now()
+		c.store(&revalidated)
+		return revalidated.Body, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to fetch %s: %s", u, res.Status)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	c.count(u, false)
+	c.store(&indexCacheEntry{
+		URL:          u,
+		Body:         body,
+		ETag:         res.Header.Get("ETag"),
+		LastModified: res.Header.Get("Last-Modified"),
+		FetchedAt:    time.Now(),
+	})
+	return body, nil
+}
+
+func (c *indexCache) lookup(u string) *indexCacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[u]; ok {
+		return entry
+	}
+	if c.dir == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(c.path(u))
+	if err != nil {
+		return nil
+	}
+	entry := &indexCacheEntry{}
+	if err := json.Unmarshal(data, entry); err != nil || entry.URL != u {
+		return nil
+	}
+	c.entries[u] = entry
+	return entry
+}
+
+func (c *indexCache) store(entry *indexCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[entry.URL] = entry
+	if c.dir == "" {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err == nil {
+		err = os.MkdirAll(c.dir, 0755)
+	}
+	if err == nil {
+		// write and rename, so that concurrent driverkit invocations never read partial entries
+		tmp := c.path(entry.URL) + ".tmp"
+		if err = ioutil.WriteFile(tmp, data, 0644); err == nil {
+			err = os.Rename(tmp, c.path(entry.URL))
+		}
+	}
+	if err != nil {
+		logger.WithError(err).WithField("dir", c.dir).Debug("unable to persist the cache entry")
+	}
+}
+
+func (c *indexCache) count(u string, hit bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if hit {
+		c.hits++
+	} else {
+		c.misses++
+	}
+	logger.WithField("url", u).WithField("hit", hit).WithField("hits", c.hits).WithField("misses", c.misses).Debug("index cache")
+}
+
+func (c *indexCache) path(u string) string {
+	sum := sha256.Sum256([]byte(u))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,151 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newIndexServer() (*httptest.Server, *int32, *int32) {
+	var requests, notModified int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprintf(w, "index of %s", r.URL.Path)
+	}))
+	return server, &requests, &notModified
+}
+
+func TestIndexCacheHit(t *testing.T) {
+	server, requests, _ := newIndexServer()
+	defer server.Close()
+
+	c := newIndexCache("", time.Hour)
+	client := newRetryClient(context.Background(), time.Second, 1)
+	for i := 0; i < 3; i++ {
+		body, err := c.get(client, server.URL+"/debian/")
+		if err != nil {
+			t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+		}
+		if string(body) != "index of /debian/" {
+			t.Errorf("Got: [ '%s' ] / Want: [ 'index of /debian/' ]", body)
+		}
+	}
+	if got := atomic.LoadInt32(requests); got != 1 {
+		t.Errorf("Requests | Got: [ %d ] / Want: [ 1 ]", got)
+	}
+	if c.hits != 2 || c.misses != 1 {
+		t.Errorf("Hits, misses | Got: [ %d, %d ] / Want: [ 2, 1 ]", c.hits, c.misses)
+	}
+}
+
+func TestIndexCacheRevalidate(t *testing.T) {
+	server, requests, notModified := newIndexServer()
+	defer server.Close()
+
+	c := newIndexCache("", 0)
+	client := newRetryClient(context.Background(), time.Second, 1)
+	for i := 0; i < 2; i++ {
+		body, err := c.get(client, server.URL+"/ubuntu/")
+		if err != nil {
+			t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+		}
+		if string(body) != "index of /ubuntu/" {
+			t.Errorf("Got: [ '%s' ] / Want: [ 'index of /ubuntu/' ]", body)
+		}
+	}
+	if got := atomic.LoadInt32(requests); got != 2 {
+		t.Errorf("Requests | Got: [ %d ] / Want: [ 2 ]", got)
+	}
+	if got := atomic.LoadInt32(notModified); got != 1 {
+		t.Errorf("Not modified | Got: [ %d ] / Want: [ 1 ]", got)
+	}
+}
+
+func TestIndexCacheOnDisk(t *testing.T) {
+	server, requests, _ := newIndexServer()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "driverkit-cache-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	client := newRetryClient(context.Background(), time.Second, 1)
+	if _, err := newIndexCache(dir, time.Hour).get(client, server.URL+"/debian/"); err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+
+	// a new cache, as a new driverkit invocation has, reads the persisted entry
+	c := newIndexCache(dir, time.Hour)
+	body, err := c.get(client, server.URL+"/debian/")
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if string(body) != "index of /debian/" {
+		t.Errorf("Got: [ '%s' ] / Want: [ 'index of /debian/' ]", body)
+	}
+	if got := atomic.LoadInt32(requests); got != 1 {
+		t.Errorf("Requests | Got: [ %d ] / Want: [ 1 ]", got)
+	}
+	if c.hits != 1 {
+		t.Errorf("Hits | Got: [ %d ] / Want: [ 1 ]", c.hits)
+	}
+}
+
+func TestIndexCacheConcurrent(t *testing.T) {
+	server, _, _ := newIndexServer()
+	defer server.Close()
+
+	c := newIndexCache("", time.Hour)
+	client := newRetryClient(context.Background(), time.Second, 1)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			u := fmt.Sprintf("%s/%d/", server.URL, i%4)
+			if _, err := c.get(client, u); err != nil {
+				t.Errorf("Unexpected error encountered | Error: '%s'", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if c.hits+c.misses != 16 {
+		t.Errorf("Lookups | Got: [ %d ] / Want: [ 16 ]", c.hits+c.misses)
+	}
+	if len(c.entries) != 4 {
+		t.Errorf("Entries | Got: [ %d ] / Want: [ 4 ]", len(c.entries))
+	}
+}
+
+func TestGetIndexCanceled(t *testing.T) {
+	server, requests, _ := newIndexServer()
+	defer server.Close()
+	withHTTPClient(t, newRetryClient(context.Background(), time.Second, 3))
+	defaultIndexes := indexes
+	defer func() { indexes = defaultIndexes }()
+	indexes = newIndexCache("", DefaultCacheTTL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := getIndex(ctx, server.URL+"/debian/"); err == nil {
+		t.Errorf("Got: [ nil ] / Want: [ context canceled ]")
+	}
+	if got := atomic.LoadInt32(requests); got != 0 {
+		t.Errorf("Requests | Got: [ %d ] / Want: [ 0 ]", got)
+	}
+}
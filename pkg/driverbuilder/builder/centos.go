@@ -1,10 +1,12 @@
 package builder
 
 import (
-	"bytes"
+	"context"
 	_ "embed"
 	"fmt"
-	"text/template"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 )
@@ -23,164 +25,259 @@ func init() {
 type centos struct {
 }
 
+// Metadata implements MetadataProvider, the ppc64le kernels are supported too
+// and the BTF is generated from the debuginfo packages.
+func (c centos) Metadata() Metadata {
+	m := DefaultMetadata()
+	m.BTF = true
+	m.Architectures = append(m.Architectures, "ppc64le")
+	return m
+}
+
 // Script compiles the script to build the kernel module and/or the eBPF probe.
-func (c centos) Script(cfg Config, kr kernelrelease.KernelRelease) (string, error) {
-	t := template.New(string(TargetTypeCentos))
-	parsed, err := t.Parse(centosTemplate)
+func (c centos) Script(cfg Config, kr kernelrelease.KernelRelease, sources KernelSources) (string, error) {
+	parsed, err := parseTemplate(cfg, string(TargetTypeCentos), centosTemplate, centosTemplateData{})
 	if err != nil {
 		return "", err
 	}
+	return executeTemplate(parsed, sources.TemplateData)
+}
 
+// ResolveKernelSources implements Builder, resolving the kernel packages of the build.
+func (c centos) ResolveKernelSources(ctx context.Context, cfg Config, kr kernelrelease.KernelRelease) (KernelSources, error) {
 	var urls []string
+	var err error
+	lookup := rpmChecksums
 	if cfg.KernelUrls == nil {
 		// Check (and filter) existing kernels before continuing
-		urls, err = getResolvingURLs(fetchCentosKernelURLS(kr))
+		var packages []rpmRepoPackage
+		packages, err = resolveCentosKernelPackages(ctx, centosKernelRepositories(kr), centosKernelQuery(kr))
+		if err != nil {
+			return KernelSources{}, err
+		}
+		candidates := make([]string, 0, len(packages))
+		for _, p := range packages {
+			candidates = append(candidates, p.URL)
+		}
+		urls, err = getResolvingURLs(ctx, candidates)
+		lookup = rpmPackageChecksums(packages)
 	} else {
-		urls, err = getResolvingURLs(cfg.KernelUrls)
+		urls, err = getResolvingURLs(ctx, cfg.KernelUrls)
 	}
 	if err != nil {
-		return "", err
+		return KernelSources{}, err
+	}
+
+	sums, err := kernelChecksums(ctx, cfg, urls[:1], lookup)
+	if err != nil {
+		return KernelSources{}, err
+	}
+
+	var debugURL, debugSum string
+	if len(cfg.Build.BTFFilePath) > 0 {
+		debugURL, debugSum, err = resolveDebugPackage(ctx, cfg, centosDebugURLs(kr), rpmChecksums)
+		if err != nil {
+			return KernelSources{}, err
+		}
+	}
+
+	crossCompilePrefix, err := crossCompile(cfg)
+	if err != nil {
+		return KernelSources{}, err
 	}
 
 	td := centosTemplateData{
 		DriverBuildDir:    DriverDirectory,
 		ModuleDownloadURL: moduleDownloadURL(cfg),
 		KernelDownloadURL: urls[0],
-		GCCVersion:        centosGccVersionFromKernelRelease(kr),
+		KernelChecksum:    sums[urls[0]],
+		DebugDownloadURL:  debugURL,
+		DebugChecksum:     debugSum,
+		GCCVersion:        gccVersion(cfg, centosGccVersionFromKernelRelease(kr)),
 		ModuleDriverName:  cfg.DriverName,
 		ModuleFullPath:    ModuleFullPath,
 		BuildModule:       len(cfg.Build.ModuleFilePath) > 0,
 		BuildProbe:        len(cfg.Build.ProbeFilePath) > 0,
+		BuildBTF:          len(debugURL) > 0,
+		BTFFullPath:       BTFFullPath,
+		KernelArch:        kr.Architecture.ToKernel(),
+		LLVMVersion:       llvmVersion(cfg, "7"),
+		CrossCompile:      crossCompilePrefix,
 	}
+	td.buildTemplateData = newBuildTemplateData(cfg)
+	return KernelSources{URLs: urls[:1], TemplateData: td}, nil
+}
 
-	buf := bytes.NewBuffer(nil)
-	err = parsed.Execute(buf, td)
-	if err != nil {
-		return "", err
+// centosRepository is a tier of the repositories the CentOS kernel packages are looked for in, with their base URLs.
+type centosRepository struct {
+	name  string
+	roots []string
+}
+
+// centosPointRelease is a point release of the vault, with the build number of the first kernel it shipped.
+type centosPointRelease struct {
+	release string
+	build   int
+}
+
+// centosPointReleases are the point releases of the vault by major version, in order.
+var centosPointReleases = map[string][]centosPointRelease{
+	"6": {
+		{"6.0", 71}, {"6.1", 131}, {"6.2", 220}, {"6.3", 279}, {"6.4", 358}, {"6.5", 431},
+		{"6.6", 504}, {"6.7", 573}, {"6.8", 642}, {"6.9", 696}, {"6.10", 754},
+	},
+	"7": {
+		{"7.0.1406", 123}, {"7.1.1503", 229}, {"7.2.1511", 327}, {"7.3.1611", 514}, {"7.4.1708", 693},
+		{"7.5.1804", 862}, {"7.6.1810", 957}, {"7.7.1908", 1062}, {"7.8.2003", 1127}, {"7.9.2009", 1160},
+	},
+	"8": {
+		{"8.0.1905", 80}, {"8.1.1911", 147}, {"8.2.2004", 193}, {"8.3.2011", 240}, {"8.4.2105", 305}, {"8.5.2111", 348},
+	},
+}
+
+// centosAltarchMinimumRelease is the first CentOS 7 point release of the vault having the altarch tree, the one of the architectures other than x86_64.
+const centosAltarchMinimumRelease = "7.3.1611"
+
+// centosVaultReleases returns the point releases of the vault the kernel may be found in: the one inferred from its build number first,
+// then the others of its major version, the newest first. All of them when the major version is unknown,
+// none when the vault does not have it, e.g. the Stream only el9.
+func centosVaultReleases(major string, build int) []string {
+	majors := []string{major}
+	if len(major) == 0 {
+		majors = []string{"6", "7", "8"}
+	}
+	inferred := ""
+	releases := []string{}
+	for _, m := range majors {
+		points := centosPointReleases[m]
+		for i := len(points) - 1; i >= 0; i-- {
+			if len(inferred) == 0 && m == major && build >= points[i].build {
+				inferred = points[i].release
+				continue
+			}
+			releases = append(releases, points[i].release)
+		}
+	}
+	if len(inferred) > 0 {
+		releases = append([]string{inferred}, releases...)
+	}
+	return releases
+}
+
+// centosKernelRepositories returns the repositories the kernel-devel package of the kernel may be found in, by tier:
+// the mirrors only keep the latest point release, the vault keeps the older ones
+// and the Stream composes the kernels never released in a point release.
+func centosKernelRepositories(kr kernelrelease.KernelRelease) []centosRepository {
+	arch := kr.Architecture.ToNonDeb()
+	major := ""
+	if match := centosMajorPattern.FindStringSubmatch(kr.FullExtraversion); match != nil {
+		major = match[1]
+	}
+	build, _ := strconv.Atoi(kr.Extraversion)
+
+	mirror := centosRepository{name: "mirror"}
+	for _, r := range []string{"6/os", "6/updates", "7/os", "7/updates"} {
+		mirror.roots = append(mirror.roots, fmt.Sprintf("https://mirrors.edge.kernel.org/centos/%s/%s", r, arch))
+	}
+	for _, r := range []string{"8/BaseOS", "8-stream/BaseOS"} {
+		mirror.roots = append(mirror.roots, fmt.Sprintf("https://mirrors.edge.kernel.org/centos/%s/%s/os", r, arch))
 	}
-	return buf.String(), nil
+
+	vault := centosRepository{name: "vault"}
+	for _, r := range centosVaultReleases(major, build) {
+		if strings.HasPrefix(r, "8.") {
+			vault.roots = append(vault.roots, fmt.Sprintf("http://vault.centos.org/%s/BaseOS/%s/os", r, arch))
+			continue
+		}
+		for _, repo := range []string{"os", "updates"} {
+			vault.roots = append(vault.roots, fmt.Sprintf("http://vault.centos.org/%s/%s/%s", r, repo, arch))
+			if arch != "x86_64" && strings.HasPrefix(r, "7.") && r >= centosAltarchMinimumRelease {
+				vault.roots = append(vault.roots, fmt.Sprintf("http://vault.centos.org/altarch/%s/%s/%s", r, repo, arch))
+			}
+		}
+	}
+
+	stream := centosRepository{name: "stream"}
+	var streamBaseURLs []string
+	switch major {
+	case "8":
+		streamBaseURLs = []string{
+			"http://vault.centos.org/8-stream",
+			"https://composes.centos.org/latest-CentOS-Stream-8/compose",
+		}
+	case "9":
+		streamBaseURLs = []string{
+			"https://mirror.stream.centos.org/9-stream",
+			"https://composes.stream.centos.org/production/latest-CentOS-Stream/compose",
+		}
+	}
+	for _, b := range streamBaseURLs {
+		for _, repo := range []string{"BaseOS", "AppStream"} {
+			stream.roots = append(stream.roots, fmt.Sprintf("%s/%s/%s/os", b, repo, arch))
+		}
+	}
+	return []centosRepository{mirror, vault, stream}
 }
 
-func fetchCentosKernelURLS(kr kernelrelease.KernelRelease) []string {
-	vaultReleases := []string{
-		"6.0/os",
-		"6.0/updates",
-		"6.1/os",
-		"6.1/updates",
-		"6.2/os",
-		"6.2/updates",
-		"6.3/os",
-		"6.3/updates",
-		"6.4/os",
-		"6.4/updates",
-		"6.5/os",
-		"6.5/updates",
-		"6.6/os",
-		"6.6/updates",
-		"6.7/os",
-		"6.7/updates",
-		"6.8/os",
-		"6.8/updates",
-		"6.9/os",
-		"6.9/updates",
-		"6.10/os",
-		"6.10/updates",
-		"7.0.1406/os",
-		"7.0.1406/updates",
-		"7.1.1503/os",
-		"7.1.1503/updates",
-		"7.2.1511/os",
-		"7.2.1511/updates",
-		"7.3.1611/os",
-		"7.3.1611/updates",
-		"7.4.1708/os",
-		"7.4.1708/updates",
-		"7.5.1804/os",
-		"7.5.1804/updates",
-		"7.6.1810/os",
-		"7.6.1810/updates",
-		"7.7.1908/os",
-		"7.7.1908/updates",
-		"7.8.2003/os",
-		"7.8.2003/updates",
-		"7.9.2009/os",
-		"7.9.2009/updates",
-		"8.0.1905/os",
-		"8.0.1905/updates",
-		"8.1.1911/os",
-		"8.1.1911/updates",
-	}
-
-	centos8VaultReleases := []string{
-		"8.0.1905/BaseOS",
-		"8.1.1911/BaseOS",
-		"8.2.2004/BaseOS",
-		"8.3.2011/BaseOS",
-		"8.4.2105/BaseOS",
-		"8.5.2111/BaseOS",
-	}
-
-	edgeReleases := []string{
-		"6/os",
-		"6/updates",
-		"7/os",
-		"7/updates",
-	}
-
-	streamReleases := []string{
-		"8/BaseOS",
-		"8-stream/BaseOS",
-	}
-
-	urls := []string{}
-	for _, r := range edgeReleases {
-		urls = append(urls, fmt.Sprintf(
-			"https://mirrors.edge.kernel.org/centos/%s/%s/Packages/kernel-devel-%s%s.rpm",
-			r,
-			kr.Architecture.ToNonDeb(),
-			kr.Fullversion,
-			kr.FullExtraversion,
-		))
-	}
-	for _, r := range streamReleases {
-		urls = append(urls, fmt.Sprintf(
-			"https://mirrors.edge.kernel.org/centos/%s/%s/os/Packages/kernel-devel-%s%s.rpm",
-			r,
-			kr.Architecture.ToNonDeb(),
-			kr.Fullversion,
-			kr.FullExtraversion,
-		))
-	}
-	for _, r := range vaultReleases {
-		urls = append(urls, fmt.Sprintf(
-			"http://vault.centos.org/%s/%s/Packages/kernel-devel-%s%s.rpm",
-			r,
-			kr.Architecture.ToNonDeb(),
-			kr.Fullversion,
-			kr.FullExtraversion,
-		))
-	}
-	for _, r := range centos8VaultReleases {
-		urls = append(urls, fmt.Sprintf(
-			"http://vault.centos.org/%s/%s/os/Packages/kernel-devel-%s%s.rpm",
-			r,
-			kr.Architecture.ToNonDeb(),
-			kr.Fullversion,
-			kr.FullExtraversion,
-		))
-	}
-	return urls
+// centosKernelDevelPattern matches the name of the kernel-devel package.
+var centosKernelDevelPattern = regexp.MustCompile(`^kernel-devel$`)
+
+// centosKernelQuery selects the kernel-devel package of the kernel.
+func centosKernelQuery(kr kernelrelease.KernelRelease) rpmQuery {
+	arch := kr.Architecture.ToNonDeb()
+	return rpmQuery{
+		name:    centosKernelDevelPattern,
+		version: kr.Fullversion,
+		release: strings.TrimPrefix(strings.TrimSuffix(kr.FullExtraversion, "."+arch), "-"),
+		arches:  []string{arch},
+	}
+}
+
+// resolveCentosKernelPackages looks for the kernel into the metadata of the repositories, tier after tier,
+// returning the packages of the first repository having it. The error enumerates every repository tried.
+func resolveCentosKernelPackages(ctx context.Context, repositories []centosRepository, query rpmQuery) ([]rpmRepoPackage, error) {
+	tried := []string{}
+	for _, r := range repositories {
+		for _, root := range r.roots {
+			Logger(ctx).WithField("repository", r.name).WithField("url", root).Debug("looking for the kernel into the repository")
+			tried = append(tried, root)
+			packages, err := rpmRepository{baseURLs: []string{root}}.find(ctx, indexGetter(ctx), query)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				Logger(ctx).WithError(err).WithField("repository", r.name).WithField("url", root).Debug("skipping repository")
+				continue
+			}
+			if len(packages) > 0 {
+				return packages, nil
+			}
+		}
+		if len(r.roots) > 0 {
+			Logger(ctx).WithField("repository", r.name).Debug("kernel not found in repository")
+		}
+	}
+	return nil, fmt.Errorf("kernel not found, tried the repositories:\n  %s", strings.Join(tried, "\n  "))
 }
 
 type centosTemplateData struct {
+	buildTemplateData
 	DriverBuildDir    string
 	ModuleDownloadURL string
 	KernelDownloadURL string
+	KernelChecksum    string
+	DebugDownloadURL  string
+	DebugChecksum     string
 	GCCVersion        string
 	ModuleDriverName  string
 	ModuleFullPath    string
 	BuildModule       bool
 	BuildProbe        bool
+	BuildBTF          bool
+	BTFFullPath       string
+	KernelArch        string
+	LLVMVersion       string
+	CrossCompile      string
 }
 
 func centosGccVersionFromKernelRelease(kr kernelrelease.KernelRelease) string {
@@ -0,0 +1,113 @@
+package builder
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+func TestCentosKernelRepositories(t *testing.T) {
+	tests := map[string]struct {
+		kernelrelease string
+		arch          kernelrelease.Architecture
+		vault         string
+		stream        string
+	}{
+		"el7 point release inferred": {
+			kernelrelease: "3.10.0-957.21.3.el7.x86_64",
+			arch:          "amd64",
+			vault:         "http://vault.centos.org/7.6.1810/os/x86_64",
+		},
+		"el7 altarch": {
+			kernelrelease: "3.10.0-957.el7.aarch64",
+			arch:          "arm64",
+			vault:         "http://vault.centos.org/7.6.1810/os/aarch64",
+		},
+		"el8 point release inferred": {
+			kernelrelease: "4.18.0-305.3.1.el8.x86_64",
+			arch:          "amd64",
+			vault:         "http://vault.centos.org/8.4.2105/BaseOS/x86_64/os",
+			stream:        "http://vault.centos.org/8-stream/BaseOS/x86_64/os",
+		},
+		"el9 stream only": {
+			kernelrelease: "5.14.0-427.el9.x86_64",
+			arch:          "amd64",
+			stream:        "https://mirror.stream.centos.org/9-stream/BaseOS/x86_64/os",
+		},
+	}
+
+	for name, test := range tests {
+		kr := kernelrelease.FromString(test.kernelrelease)
+		kr.Architecture = test.arch
+		repositories := centosKernelRepositories(kr)
+		if len(repositories) != 3 || repositories[0].name != "mirror" || repositories[1].name != "vault" || repositories[2].name != "stream" {
+			t.Fatalf("Unexpected repositories with Test Input: '%s' | Got: '%v' / Want: [ mirror vault stream ]", name, repositories)
+		}
+		if len(test.vault) == 0 {
+			if len(repositories[1].roots) > 0 {
+				t.Errorf("Unexpected vault urls with Test Input: '%s' | Got: '%v' / Want: none", name, repositories[1].roots)
+			}
+		} else if len(repositories[1].roots) == 0 || repositories[1].roots[0] != test.vault {
+			t.Errorf("Unexpected vault urls with Test Input: '%s' | Got: '%v' / Want: '%s' first", name, repositories[1].roots, test.vault)
+		}
+		if len(test.stream) == 0 {
+			if len(repositories[2].roots) > 0 {
+				t.Errorf("Unexpected stream urls with Test Input: '%s' | Got: '%v' / Want: none", name, repositories[2].roots)
+			}
+		} else if len(repositories[2].roots) == 0 || repositories[2].roots[0] != test.stream {
+			t.Errorf("Unexpected stream urls with Test Input: '%s' | Got: '%v' / Want: '%s' first", name, repositories[2].roots, test.stream)
+		}
+	}
+}
+
+func TestCentosVaultReleasesAltarch(t *testing.T) {
+	kr := kernelrelease.FromString("3.10.0-1160.el7.aarch64")
+	kr.Architecture = "arm64"
+	for _, u := range centosKernelRepositories(kr)[1].roots {
+		if strings.Contains(u, "/altarch/7.2.1511/") {
+			t.Fatalf("Got: '%s' / Want: no altarch before %s", u, centosAltarchMinimumRelease)
+		}
+	}
+}
+
+func TestResolveCentosKernelPackages(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	withHTTPClient(t, newRetryClient(context.Background(), time.Second, 1))
+	serveRPMRepo(t, mux, "/vault/8.6", ".gz", gzipped(t, testPrimary))
+	serveRPMRepo(t, mux, "/stream", ".gz", gzipped(t, strings.ReplaceAll(testPrimary, "425.3.1.el8_7", "448.el8")))
+
+	kr := kernelrelease.FromString("4.18.0-448.el8.x86_64")
+	kr.Architecture = "amd64"
+	repositories := []centosRepository{
+		{name: "mirror", roots: []string{server.URL + "/mirror"}},
+		{name: "vault", roots: []string{server.URL + "/vault/8.6", server.URL + "/vault/8.5"}},
+		{name: "stream", roots: []string{server.URL + "/stream"}},
+	}
+	got, err := resolveCentosKernelPackages(context.Background(), repositories, centosKernelQuery(kr))
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	want := server.URL + "/stream/Packages/k/kernel-devel-4.18.0-448.el8.x86_64.rpm"
+	if len(got) != 1 || got[0].URL != want {
+		t.Errorf("Got: '%v' / Want: [ '%s' ]", got, want)
+	}
+
+	_, err = resolveCentosKernelPackages(context.Background(), repositories[:2], centosKernelQuery(kr))
+	if err == nil {
+		t.Fatalf("Got: [ nil ] / Want: [ kernel not found ]")
+	}
+	for _, r := range repositories[:2] {
+		for _, root := range r.roots {
+			if !strings.Contains(err.Error(), root) {
+				t.Errorf("Got: '%s' / Want: '%s' enumerated", err, root)
+			}
+		}
+	}
+}
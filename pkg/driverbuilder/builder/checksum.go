@@ -0,0 +1,142 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// rpmRepositoryDepth is the number of parent directories of a package looked into for the repository metadata.
+const rpmRepositoryDepth = 4
+
+// checksumLookup returns the SHA256 sums of the packages published by their repositories, keyed by URL.
+type checksumLookup func(ctx context.Context, urls []string) map[string]string
+
+// kernelChecksums returns the SHA256 sums the build script verifies the downloaded packages against, keyed by URL.
+// It fails when the sum of any package cannot be found, unless the user asked to skip the verification.
+func kernelChecksums(ctx context.Context, c Config, urls []string, lookup checksumLookup) (map[string]string, error) {
+	if c.SkipChecksum {
+		Logger(ctx).Warn("skipping the verification of the kernel packages checksums")
+		return nil, nil
+	}
+	// local packages are provided by the user, there is nothing to verify them against
+	remote := []string{}
+	for _, u := range urls {
+		if !isLocalURL(u) {
+			remote = append(remote, u)
+		}
+	}
+	if len(remote) == 0 {
+		return nil, nil
+	}
+	sums := lookup(ctx, remote)
+	for _, u := range remote {
+		if _, ok := sums[u]; !ok {
+			return nil, fmt.Errorf("unable to find the checksum of %s, use --skip-checksum to build without verifying it", u)
+		}
+		Logger(ctx).WithField("url", u).WithField("sha256", sums[u]).Debug("kernel package checksum found")
+	}
+	return sums, nil
+}
+
+// debianChecksums looks for the packages into the Packages indexes of the suites of their archives, verified against the keys,
+// the suites of a package being the ones of the release it belongs to.
+// Archives are found from the pool URLs, e.g. http://deb.debian.org/debian/pool/main/l/linux/*.deb.
+func debianChecksums(arch string, keys aptKeys, suites func(u string) []string) checksumLookup {
+	return func(ctx context.Context, urls []string) map[string]string {
+		sums := map[string]string{}
+		wanted := map[string]bool{}
+		for _, u := range urls {
+			wanted[u] = true
+		}
+		for _, u := range urls {
+			if _, ok := sums[u]; ok {
+				continue
+			}
+			i := strings.Index(u, "/pool/")
+			if i < 0 {
+				continue
+			}
+			base := u[:i]
+			// the security archives pool the packages of the main component into updates/main
+			component := strings.SplitN(strings.TrimPrefix(u[i+len("/pool/"):], "updates/"), "/", 2)[0]
+			err := aptRepository{base, suites(u), []string{component}, keys}.packages(ctx, arch, func(p aptPackage) {
+				if wanted[p.URL] && len(p.SHA256) > 0 {
+					sums[p.URL] = p.SHA256
+				}
+			})
+			if err != nil {
+				Logger(ctx).WithError(err).WithField("url", base).Debug("skipping archive")
+			}
+		}
+		return sums
+	}
+}
+
+// rpmChecksums looks for the packages into the primary metadata of their repositories,
+// the repository of a package is the nearest parent directory having a repodata/repomd.xml.
+func rpmChecksums(ctx context.Context, urls []string) map[string]string {
+	return rpmChecksumsWith(indexGetter(ctx))(ctx, urls)
+}
+
+// rpmChecksumsWith is rpmChecksums fetching the repository metadata with the given function,
+// e.g. one authenticating to the repositories.
+func rpmChecksumsWith(get func(u string) ([]byte, error)) checksumLookup {
+	return func(ctx context.Context, urls []string) map[string]string {
+		sums := map[string]string{}
+		for _, u := range urls {
+			if _, ok := sums[u]; ok {
+				continue
+			}
+			for _, root := range rpmRepositoryRoots(u) {
+				packages, err := rpmPrimaryChecksums(get, root)
+				if err != nil {
+					Logger(ctx).WithError(err).WithField("url", root).Debug("skipping repository")
+					continue
+				}
+				for _, p := range urls {
+					if sum, ok := packages[strings.TrimPrefix(p, root+"/")]; ok {
+						sums[p] = sum
+					}
+				}
+				break
+			}
+		}
+		return sums
+	}
+}
+
+// rpmRepositoryRoots returns the parent directories of the package, from the nearest one.
+func rpmRepositoryRoots(u string) []string {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return nil
+	}
+	roots := []string{}
+	dir := path.Dir(pu.Path)
+	for i := 0; i < rpmRepositoryDepth && dir != "/" && dir != "."; i++ {
+		root := *pu
+		root.Path = dir
+		root.RawQuery = ""
+		roots = append(roots, root.String())
+		dir = path.Dir(dir)
+	}
+	return roots
+}
+
+// rpmPrimaryChecksums returns the SHA256 sums of the packages of the repository, keyed by their location.
+func rpmPrimaryChecksums(get func(u string) ([]byte, error), root string) (map[string]string, error) {
+	packages, err := rpmRepoMetadata(get, root)
+	if err != nil {
+		return nil, err
+	}
+	sums := map[string]string{}
+	for _, p := range packages {
+		if sum := p.sha256(); len(sum) > 0 {
+			sums[p.Location.Href] = sum
+		}
+	}
+	return sums, nil
+}
@@ -0,0 +1,108 @@
+package builder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func gzipped(t *testing.T, s string) []byte {
+	buf := bytes.NewBuffer(nil)
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDebianChecksums(t *testing.T) {
+	server := newTestAptArchives(t)
+
+	urls := []string{
+		server.URL + "/debian/pool/main/l/linux/linux-headers-6.1.0-13-amd64_6.1.55-1_amd64.deb",
+		server.URL + "/debian/pool/main/l/linux-base/linux-base_4.9_all.deb",
+		server.URL + "/debian/pool/main/l/linux/linux-kbuild-6.1_6.1.99-1_amd64.deb",
+	}
+	got := debianChecksums("amd64", debianArchiveKeys, func(string) []string { return []string{"bullseye", "bookworm"} })(context.Background(), urls)
+	want := map[string]string{
+		urls[0]: "51638e7ae1b2493e6f1fe119fcaf271ea95148102bf8c13c81cc7fa0c2b70138",
+		urls[1]: "a865e14a90bb915b0b29427b7e1fbe74a10eda973f0847a7918226c401f7b0d4",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Got: [ %v ] / Want: [ %v ]", got, want)
+	}
+	for u, sum := range want {
+		if got[u] != sum {
+			t.Errorf("Checksum of %s | Got: [ '%s' ] / Want: [ '%s' ]", u, got[u], sum)
+		}
+	}
+
+	// only the suites of the release of the kernel are looked into
+	if got := debianChecksums("amd64", debianArchiveKeys, func(string) []string { return []string{"bullseye"} })(context.Background(), urls); len(got) != 0 {
+		t.Errorf("Got: [ %v ] / Want: [ none out of the suites ]", got)
+	}
+}
+
+func TestRPMChecksums(t *testing.T) {
+	primary := gzipped(t, `<?xml version="1.0" encoding="UTF-8"?>
+<metadata xmlns="http://linux.duke.edu/metadata/common" packages="2">
+<package type="rpm">
+  <name>kernel-devel</name>
+  <checksum type="sha256" pkgid="YES">3333333333333333333333333333333333333333333333333333333333333333</checksum>
+  <location href="Packages/k/kernel-devel-4.18.0-425.3.1.el8_7.x86_64.rpm"/>
+</package>
+<package type="rpm">
+  <name>kernel-headers</name>
+  <checksum type="sha" pkgid="YES">4444444444444444444444444444444444444444</checksum>
+  <location href="Packages/k/kernel-headers-4.18.0-425.3.1.el8_7.x86_64.rpm"/>
+</package>
+</metadata>`)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rocky/8/BaseOS/x86_64/os/repodata/repomd.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>
+<repomd xmlns="http://linux.duke.edu/metadata/repo">
+  <data type="filelists"><location href="repodata/filelists.xml.gz"/></data>
+  <data type="primary"><location href="repodata/primary.xml.gz"/></data>
+</repomd>`)
+	})
+	mux.HandleFunc("/rocky/8/BaseOS/x86_64/os/repodata/primary.xml.gz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(primary)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	urls := []string{
+		server.URL + "/rocky/8/BaseOS/x86_64/os/Packages/k/kernel-devel-4.18.0-425.3.1.el8_7.x86_64.rpm",
+		server.URL + "/rocky/8/BaseOS/x86_64/os/Packages/k/kernel-headers-4.18.0-425.3.1.el8_7.x86_64.rpm",
+	}
+	got := rpmChecksums(context.Background(), urls)
+	if len(got) != 1 {
+		t.Fatalf("Got: [ %v ] / Want: [ only the sha256 checksum ]", got)
+	}
+	if want := "3333333333333333333333333333333333333333333333333333333333333333"; got[urls[0]] != want {
+		t.Errorf("Got: [ '%s' ] / Want: [ '%s' ]", got[urls[0]], want)
+	}
+}
+
+func TestKernelChecksums(t *testing.T) {
+	urls := []string{"https://example.com/kernel-devel.rpm"}
+	none := func(context.Context, []string) map[string]string { return map[string]string{} }
+
+	if _, err := kernelChecksums(context.Background(), Config{Build: &Build{}}, urls, none); err == nil {
+		t.Errorf("Expecting an error when the checksum is not found")
+	}
+	sums, err := kernelChecksums(context.Background(), Config{Build: &Build{SkipChecksum: true}}, urls, none)
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if len(sums) != 0 {
+		t.Errorf("Got: [ %v ] / Want: [ no checksums when skipping them ]", sums)
+	}
+}
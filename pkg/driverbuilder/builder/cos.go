@@ -0,0 +1,156 @@
+package builder
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+//go:embed templates/cos.sh
+var cosTemplate string
+
+// TargetTypeCos identifies the Container-Optimized OS target.
+const TargetTypeCos Type = "cos"
+
+// cosImagePattern matches COS image names like cos-101-17162-40-56.
+var cosImagePattern = regexp.MustCompile(`^cos-(?:[a-z]+-)?\d+-(\d+)-(\d+)-(\d+)$`)
+
+// cosBuildIDPattern matches COS build IDs like 17162.40.56.
+var cosBuildIDPattern = regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+
+func init() {
+	BuilderByTarget[TargetTypeCos] = &cos{}
+}
+
+// cos is a driverkit target.
+type cos struct {
+}
+
+// Metadata implements MetadataProvider, the kernel version tells the build ID or the image name.
+// The builds use the toolchain COS publishes for the architecture, they are not cross compiled.
+func (c cos) Metadata() Metadata {
+	m := DefaultMetadata()
+	m.RequiresKernelVersion = true
+	m.CrossCompile = false
+	m.GCC = false
+	return m
+}
+
+type cosTemplateData struct {
+	buildTemplateData
+	DriverBuildDir    string
+	ModuleDownloadURL string
+	KernelDownloadURL string
+	ToolchainURL      string
+	ToolchainEnvURL   string
+	ModuleDriverName  string
+	ModuleFullPath    string
+	BuildModule       bool
+	BuildProbe        bool
+}
+
+// Script compiles the script to build the kernel module and/or the eBPF probe.
+//
+// The COS build ID (e.g. 17162.40.56) or image name (e.g. cos-101-17162-40-56) is expected in the kernel version.
+func (c cos) Script(cfg Config, kr kernelrelease.KernelRelease, sources KernelSources) (string, error) {
+	parsed, err := parseTemplate(cfg, string(TargetTypeCos), cosTemplate, cosTemplateData{})
+	if err != nil {
+		return "", err
+	}
+	return executeTemplate(parsed, sources.TemplateData)
+}
+
+// ResolveKernelSources implements Builder, resolving the kernel packages of the build.
+func (c cos) ResolveKernelSources(ctx context.Context, cfg Config, kr kernelrelease.KernelRelease) (KernelSources, error) {
+	buildID, err := cosBuildIDFromKernelVersion(cfg.KernelVersion)
+	if err != nil {
+		return KernelSources{}, err
+	}
+	baseURL := cosToolsURL(kr.Architecture, buildID)
+
+	var urls []string
+	if cfg.KernelUrls == nil {
+		urls, err = getResolvingURLs(ctx, []string{fmt.Sprintf("%s/kernel-headers.tgz", baseURL)})
+	} else {
+		urls, err = getResolvingURLs(ctx, cfg.KernelUrls)
+	}
+	if err != nil {
+		return KernelSources{}, fmt.Errorf("kernel headers not found")
+	}
+
+	toolchainURL, err := fetchCosToolchainURL(ctx, baseURL)
+	if err != nil {
+		return KernelSources{}, err
+	}
+
+	td := cosTemplateData{
+		DriverBuildDir:    DriverDirectory,
+		ModuleDownloadURL: moduleDownloadURL(cfg),
+		KernelDownloadURL: urls[0],
+		ToolchainURL:      toolchainURL,
+		ToolchainEnvURL:   fmt.Sprintf("%s/toolchain_env", baseURL),
+		ModuleDriverName:  cfg.DriverName,
+		ModuleFullPath:    ModuleFullPath,
+		BuildModule:       len(cfg.Build.ModuleFilePath) > 0,
+		BuildProbe:        len(cfg.Build.ProbeFilePath) > 0,
+	}
+	td.buildTemplateData = newBuildTemplateData(cfg)
+	return KernelSources{URLs: urls[:1], TemplateData: td}, nil
+}
+
+// cosBuildIDFromKernelVersion returns the build ID, the image name is converted when needed.
+// Example: Input -> "cos-101-17162-40-56", Output -> "17162.40.56"
+func cosBuildIDFromKernelVersion(kernelVersion string) (string, error) {
+	if cosBuildIDPattern.MatchString(kernelVersion) {
+		return kernelVersion, nil
+	}
+	match := cosImagePattern.FindStringSubmatch(kernelVersion)
+	if len(match) != 4 {
+		return "", fmt.Errorf("unable to find the cos build id in the kernel version: %s", kernelVersion)
+	}
+	return strings.Join(match[1:], "."), nil
+}
+
+// cosToolsURL returns the location of the artifacts published for the build,
+// arm64 builds have their own bucket.
+func cosToolsURL(architecture kernelrelease.Architecture, buildID string) string {
+	bucket := "cos-tools"
+	if architecture == "arm64" {
+		bucket = "cos-tools-arm64"
+	}
+	return fmt.Sprintf("https://storage.googleapis.com/%s/%s", bucket, buildID)
+}
+
+// fetchCosToolchainURL returns the toolchain tarball of the build,
+// older builds only publish a file containing its URL.
+func fetchCosToolchainURL(ctx context.Context, baseURL string) (string, error) {
+	if urls, err := getResolvingURLs(ctx, []string{fmt.Sprintf("%s/toolchain.tar.xz", baseURL)}); err == nil {
+		return urls[0], nil
+	}
+
+	resp, err := httpClientFrom(ctx).Get(fmt.Sprintf("%s/toolchain_url", baseURL))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("toolchain not found")
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	toolchainURL := strings.TrimSpace(string(body))
+	// the toolchain_url file contains a gs:// path or a path relative to the bucket
+	toolchainURL = strings.TrimPrefix(toolchainURL, "gs://")
+	if !strings.HasPrefix(toolchainURL, "http") {
+		toolchainURL = fmt.Sprintf("https://storage.googleapis.com/%s", toolchainURL)
+	}
+	return toolchainURL, nil
+}
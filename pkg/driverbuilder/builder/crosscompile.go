@@ -0,0 +1,49 @@
+package builder
+
+import (
+	"fmt"
+	"strings"
+)
+
+// crossCompilePrefixes are the prefixes of the cross toolchains of the amd64 builder image, by architecture built for.
+var crossCompilePrefixes = map[string]string{
+	"arm64":   "aarch64-linux-gnu-",
+	"riscv64": "riscv64-linux-gnu-",
+	// the kernel modules do not depend on the float ABI, armhf and armel builds alike
+	"arm": "arm-linux-gnueabihf-",
+}
+
+// CanCrossCompile tells whether the build can be cross compiled from an amd64 builder instead of running emulated:
+// the target supports it and the amd64 builder image has a toolchain for the architecture of the build.
+// The builds of the modern eBPF probe are not, it is built for the architecture its builder runs on,
+// neither are the ones with a chosen gcc, the cross toolchains come in a single version.
+func CanCrossCompile(b *Build) bool {
+	if len(b.ModernProbeFilePath) > 0 || len(b.GCCVersion) > 0 {
+		return false
+	}
+	v, ok := BuilderByTarget[b.TargetType]
+	if !ok || !MetadataOf(v).CrossCompile {
+		return false
+	}
+	_, ok = crossCompilePrefixes[b.Architecture]
+	return ok
+}
+
+// crossCompile returns the CROSS_COMPILE prefix building for the architecture of the build from the one of the builder,
+// none when they are the same, an error when the builder cannot build for it.
+func crossCompile(c Config) (string, error) {
+	if len(c.BuilderArchitecture) == 0 || c.BuilderArchitecture == c.Architecture {
+		return "", nil
+	}
+	prefix, ok := crossCompilePrefixes[c.Architecture]
+	if !ok || c.BuilderArchitecture != "amd64" {
+		return "", fmt.Errorf("unsupported combination: the %s builds cannot run on a %s builder, there is no %s toolchain for it", c.Architecture, c.BuilderArchitecture, c.Architecture)
+	}
+	return prefix, nil
+}
+
+// triple returns the target triple of the CROSS_COMPILE prefix, e.g. aarch64-linux-gnu,
+// the clang target and the name of the cross gcc package.
+func triple(prefix string) string {
+	return strings.TrimSuffix(prefix, "-")
+}
@@ -0,0 +1,56 @@
+package builder
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCrossCompile(t *testing.T) {
+	tests := []struct {
+		arch        string
+		builderArch string
+		prefix      string
+		err         string
+	}{
+		{"amd64", "", "", ""},
+		{"arm64", "arm64", "", ""},
+		{"riscv64", "riscv64", "", ""},
+		{"riscv64", "amd64", "riscv64-linux-gnu-", ""},
+		{"riscv64", "arm64", "", "unsupported combination"},
+		{"arm64", "amd64", "aarch64-linux-gnu-", ""},
+		{"s390x", "amd64", "", "unsupported combination"},
+		{"arm", "amd64", "arm-linux-gnueabihf-", ""},
+	}
+	for _, test := range tests {
+		prefix, err := crossCompile(Config{Build: &Build{Architecture: test.arch}, BuilderArchitecture: test.builderArch})
+		switch {
+		case len(test.err) == 0 && err != nil:
+			t.Errorf("Unexpected error encountered | Test Input: '%s on %s' | Error: '%s'", test.arch, test.builderArch, err)
+		case len(test.err) > 0 && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("Test Input: '%s on %s' | Got: '%v' / Want: '%s'", test.arch, test.builderArch, err, test.err)
+		case prefix != test.prefix:
+			t.Errorf("Test Input: '%s on %s' | Got: '%s' / Want: '%s'", test.arch, test.builderArch, prefix, test.prefix)
+		}
+	}
+}
+
+func TestCanCrossCompile(t *testing.T) {
+	tests := []struct {
+		build *Build
+		want  bool
+	}{
+		{&Build{TargetType: TargetTypeVanilla, Architecture: "arm64"}, true},
+		{&Build{TargetType: TargetTypeDebian, Architecture: "riscv64"}, true},
+		{&Build{TargetType: TargetTypeDebian, Architecture: "s390x"}, false},
+		{&Build{TargetType: TargetTypeCos, Architecture: "arm64"}, false},
+		{&Build{TargetType: TargetTypeBottlerocket, Architecture: "arm64"}, false},
+		{&Build{TargetType: "unknown", Architecture: "arm64"}, false},
+		{&Build{TargetType: TargetTypeVanilla, Architecture: "arm64", ModernProbeFilePath: "/tmp/bpf_probe.skel.h"}, false},
+		{&Build{TargetType: TargetTypeVanilla, Architecture: "arm64", GCCVersion: "6"}, false},
+	}
+	for _, test := range tests {
+		if got := CanCrossCompile(test.build); got != test.want {
+			t.Errorf("Test Input: '%s %s' | Got: '%t' / Want: '%t'", test.build.TargetType, test.build.Architecture, got, test.want)
+		}
+	}
+}
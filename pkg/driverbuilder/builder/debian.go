@@ -1,14 +1,13 @@
 package builder
 
 import (
-	"bytes"
+	"context"
 	_ "embed"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"net/url"
 	"regexp"
+	"sort"
 	"strings"
-	"text/template"
 
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 )
@@ -27,65 +26,308 @@ func init() {
 type debian struct {
 }
 
-// Script compiles the script to build the kernel module and/or the eBPF probe.
-func (v debian) Script(c Config, kr kernelrelease.KernelRelease) (string, error) {
-	t := template.New(string(TargetTypeDebian))
+// Metadata implements MetadataProvider, the ppc64el, s390x, riscv64 and 32 bit arm kernels are supported too,
+// the BTF is generated from the debug packages.
+func (v debian) Metadata() Metadata {
+	m := DefaultMetadata()
+	m.BTF = true
+	m.Architectures = append(m.Architectures, "ppc64le", "s390x", "riscv64", "arm")
+	return m
+}
+
+// Mirrors implements MirrorsProvider, the pools of the kernel headers, the ones of the build being the pools of the kbuild package too.
+func (v debian) Mirrors(arch kernelrelease.Architecture) []string {
+	return debianHeadersBaseURLs
+}
+
+// Validate implements Validator, the packages are looked up by the ABI, the flavor and the architecture of the kernel release.
+func (v debian) Validate(c Config, kr kernelrelease.KernelRelease) error {
+	if c.KernelUrls != nil {
+		return nil
+	}
+	dkr, err := kernelrelease.ParseDebian(c.KernelRelease)
+	if err != nil {
+		return err
+	}
+	if arch := debianPackageArchitecture(c, kr); dkr.PackageArch != arch.ToDeb() {
+		return fmt.Errorf("the debian kernel release %s is not for the %s architecture, it must end with -%s", c.KernelRelease, arch, debianKernelArch(arch))
+	}
+	return nil
+}
 
-	debTemplateStr := fmt.Sprintf(debianTemplate, kr.Architecture.String())
-	parsed, err := t.Parse(debTemplateStr)
+// Script compiles the script to build the kernel module and/or the eBPF probe.
+func (v debian) Script(c Config, kr kernelrelease.KernelRelease, sources KernelSources) (string, error) {
+	parsed, err := parseTemplate(c, string(TargetTypeDebian), debianTemplate, debianTemplateData{})
 	if err != nil {
 		return "", err
 	}
+	return executeTemplate(parsed, sources.TemplateData)
+}
 
-	var urls []string
-	if c.KernelUrls == nil {
-		var kurls []string
-		kurls, err = fetchDebianKernelURLs(kr)
+// ResolveKernelSources implements Builder, resolving the kernel packages of the build.
+func (v debian) ResolveKernelSources(ctx context.Context, c Config, kr kernelrelease.KernelRelease) (KernelSources, error) {
+	var err error
+	kr.Architecture = debianPackageArchitecture(c, kr)
+	lookup := debianKernelChecksums(kr)
+	kurls := c.KernelUrls
+	if kurls == nil {
+		kurls, lookup, err = fetchDebianKernelURLs(ctx, kr, c.KernelVersion)
 		if err != nil {
-			return "", err
+			return KernelSources{}, err
 		}
-		urls, err = getResolvingURLs(kurls)
-	} else {
-		urls, err = getResolvingURLs(c.KernelUrls)
 	}
+	urls, attempts, err := resolveURLs(ctx, kurls)
 	if err != nil {
-		return "", err
+		return KernelSources{}, err
+	}
+	if err := requireURLCategories(debianURLCategories, urls, attempts); err != nil {
+		return KernelSources{}, err
+	}
+
+	sums, err := kernelChecksums(ctx, c, urls, lookup)
+	if err != nil {
+		return KernelSources{}, err
+	}
+	urls, sums, buildBTF, err := withDebugPackage(ctx, c, urls, sums, func() []string { return debianDebugURLs(kr, urls) }, debianKernelChecksums(kr))
+	if err != nil {
+		return KernelSources{}, err
 	}
-	// We need:
-	// kernel devel
-	// kernel devel common
-	// kbuild package
-	if len(urls) < 3 {
-		return "", fmt.Errorf("specific kernel headers not found")
+	crossCompilePrefix, err := crossCompile(c)
+	if err != nil {
+		return KernelSources{}, err
 	}
 
 	td := debianTemplateData{
 		DriverBuildDir:     DriverDirectory,
-		ModuleDownloadURL:  fmt.Sprintf("%s/%s.tar.gz", c.DownloadBaseURL, c.Build.DriverVersion),
+		ModuleDownloadURL:  moduleDownloadURL(c),
 		KernelDownloadURLS: urls,
+		KernelChecksums:    sums,
 		KernelLocalVersion: kr.FullExtraversion,
 		ModuleDriverName:   c.DriverName,
 		ModuleFullPath:     ModuleFullPath,
 		BuildModule:        len(c.Build.ModuleFilePath) > 0,
 		BuildProbe:         len(c.Build.ProbeFilePath) > 0,
-		LLVMVersion:        debianLLVMVersionFromKernelRelease(kr),
+		BuildBTF:           buildBTF,
+		BTFFullPath:        BTFFullPath,
+		LLVMVersion:        llvmVersion(c, debianLLVMVersionFromKernelRelease(kr)),
+		GCCVersion:         gccVersion(c, debianGCCVersionFromKernelRelease(kr)),
+		KernelArch:         kr.Architecture.ToKernel(),
+		CrossCompile:       crossCompilePrefix,
+	}
+	td.buildTemplateData = newBuildTemplateData(c)
+	return KernelSources{URLs: urls, TemplateData: td}, nil
+}
+
+// debianURLCategories are the packages a debian build needs one of each.
+// The eBPF probe needs them all as much as the kernel module does, both are built through the kbuild of the headers, make -C $KERNELDIR,
+// while the clang of the probe is only used by the script when it builds the probe.
+var debianURLCategories = []urlCategory{
+	{"kernel headers", func(name string) bool {
+		return strings.HasPrefix(name, "linux-headers-") && !isDebianCommonHeaders(name)
+	}},
+	{"kernel headers common", isDebianCommonHeaders},
+	{"kbuild package", func(name string) bool { return strings.HasPrefix(name, "linux-kbuild-") }},
+}
+
+// isDebianCommonHeaders tells whether the package is the arch independent headers, e.g. linux-headers-6.1.0-17-common_6.1.69-1_all.deb,
+// its rt flavor included.
+func isDebianCommonHeaders(name string) bool {
+	pkg := strings.SplitN(name, "_", 2)[0]
+	return strings.HasPrefix(pkg, "linux-headers-") && (strings.HasSuffix(pkg, "-common") || strings.HasSuffix(pkg, "-common-rt"))
+}
+
+// debianSnapshotURL is the snapshot.debian.org archive, it keeps every package ever uploaded.
+var debianSnapshotURL = "https://snapshot.debian.org"
+
+// fetchDebianKernelURLs looks for the kernel packages into the Packages indexes of the suites of the kernel first,
+// then into the pools, whose indexes are scraped, and at last into the snapshots.
+// The lookup returns the checksums of the packages, the ones of the Packages indexes they were found in when so.
+func fetchDebianKernelURLs(ctx context.Context, kr kernelrelease.KernelRelease, kv string) ([]string, checksumLookup, error) {
+	lookup := debianKernelChecksums(kr)
+	packages, err := fetchDebianAptKernelPackages(ctx, kr, kv)
+	if err == nil {
+		urls := make([]string, 0, len(packages))
+		for _, p := range packages {
+			urls = append(urls, p.URL)
+		}
+		return urls, aptPackageChecksums(packages), nil
+	}
+	Logger(ctx).WithError(err).Debug("kernel not found in the packages indexes, looking into the pools")
+
+	urls, err := fetchDebianPoolKernelURLs(ctx, kr, kv)
+	if err == nil {
+		return urls, lookup, nil
+	}
+
+	// superseded versions are removed from the pools, look for them into the snapshots
+	snapshotURLs, snapshotErr := fetchDebianSnapshotKernelURLs(ctx, kr)
+	if snapshotErr != nil {
+		return nil, nil, err
 	}
+	return snapshotURLs, lookup, nil
+}
+
+var (
+	// debianArchiveURL and debianSecurityURL are the archives whose Packages indexes are looked into.
+	debianArchiveURL  = "http://deb.debian.org/debian"
+	debianSecurityURL = "http://security.debian.org/debian-security"
+)
+
+// debianCodenames are the codenames of the Debian releases by the version of their kernel, and by their number for the backports.
+var debianCodenames = map[string]string{
+	"4.19": "buster",
+	"5.10": "bullseye",
+	"6.1":  "bookworm",
+	"6.12": "trixie",
+	"10":   "buster",
+	"11":   "bullseye",
+	"12":   "bookworm",
+	"13":   "trixie",
+}
 
-	buf := bytes.NewBuffer(nil)
-	err = parsed.Execute(buf, td)
+// debianBackportPattern matches the release number of the ABI of the backported kernels, e.g. 6.5.0-0.deb12.4-amd64.
+var debianBackportPattern = regexp.MustCompile(`^-0\.deb(\d+)\.`)
+
+// debianBackportRelease returns the number of the release the kernel is backported to, told by the deb token of its ABI.
+// Example: Input -> "6.1.0-0.deb11.17-amd64", Output -> "11"
+func debianBackportRelease(kr kernelrelease.KernelRelease) (string, bool) {
+	match := debianBackportPattern.FindStringSubmatch(kr.FullExtraversion)
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
+// debianPackageVersionPattern returns the pattern of the versions of the packages of the kernel:
+// any version, but for the backported kernels whose packages are versioned for their release,
+// as backports (e.g. 6.1.69-1~bpo11+1) or as security updates (e.g. 6.1.69-1~deb11u1).
+func debianPackageVersionPattern(kr kernelrelease.KernelRelease) string {
+	release, ok := debianBackportRelease(kr)
+	if !ok {
+		return `[^_"]+`
+	}
+	return fmt.Sprintf(`[^_"]+(?:~|%%7E)(?:bpo%s(?:\+|%%2B)\d+|deb%su\d+)`, release, release)
+}
+
+// debianPackageVersion returns the version of a package as escaped in the file names of the indexes,
+// e.g. 6.1.69-1~bpo11%2B1.
+func debianPackageVersion(escaped string) string {
+	if version, err := url.PathUnescape(escaped); err == nil {
+		return version
+	}
+	return strings.ReplaceAll(escaped, "%2B", "+")
+}
+
+// debianAptRepositories returns the repositories of the release the kernel belongs to: its suite, its point release updates
+// and its security updates, or its backports. None when the release is unknown.
+// Example: Input -> "6.1.0-17-amd64", Output -> bookworm and bookworm-updates of deb.debian.org, bookworm-security of security.debian.org
+func debianAptRepositories(kr kernelrelease.KernelRelease) []aptRepository {
+	if release, ok := debianBackportRelease(kr); ok {
+		codename, ok := debianCodenames[release]
+		if !ok {
+			return nil
+		}
+		return []aptRepository{{debianArchiveURL, []string{codename + "-backports"}, []string{"main"}, debianArchiveKeys}}
+	}
+	codename, ok := debianCodenames[fmt.Sprintf("%d.%d", kr.Version, kr.PatchLevel)]
+	if !ok {
+		return nil
+	}
+	security := codename + "-security"
+	// the security suites were named after the release updates before bullseye
+	if codename == "buster" {
+		security = codename + "/updates"
+	}
+	return []aptRepository{
+		{debianArchiveURL, []string{codename, codename + "-updates"}, []string{"main"}, debianArchiveKeys},
+		{debianSecurityURL, []string{security}, []string{"main"}, debianArchiveKeys},
+	}
+}
+
+// debianKernelChecksums looks for the packages of the kernel into the suites of its release, whichever archive they come from,
+// e.g. a mirror or a snapshot. None are found when the release is unknown.
+func debianKernelChecksums(kr kernelrelease.KernelRelease) checksumLookup {
+	suites := []string{}
+	for _, r := range debianAptRepositories(kr) {
+		suites = append(suites, r.suites...)
+	}
+	return debianChecksums(kr.Architecture.ToDeb(), debianArchiveKeys, func(string) []string { return suites })
+}
+
+// fetchDebianAptKernelPackages looks for the headers, the headers common and the linux-kbuild packages of the kernel
+// into the Packages indexes of its release, selecting them as the pools lookup does. When no kernel version is given,
+// the linux-kbuild package closest to the version of the headers is picked, see debianKernelVersion.
+func fetchDebianAptKernelPackages(ctx context.Context, kr kernelrelease.KernelRelease, kv string) ([]aptPackage, error) {
+	repositories := debianAptRepositories(kr)
+	if len(repositories) == 0 {
+		return nil, fmt.Errorf("no debian release known for the kernel %s%s", kr.Fullversion, kr.FullExtraversion)
+	}
+	extraVersionPartial, flavor, common := debianFlavorFromKernelRelease(kr)
+	arch := kr.Architecture.ToDeb()
+	headersName := fmt.Sprintf("linux-headers-%s%s-%s", kr.Fullversion, extraVersionPartial, flavor)
+	commonName := fmt.Sprintf("linux-headers-%s%s-%s", kr.Fullversion, extraVersionPartial, common)
+	kbuildPrefix := fmt.Sprintf("linux-kbuild-%d.%d", kr.Version, kr.PatchLevel)
+
+	found := map[string]aptPackage{}
+	headers, commons, kbuilds := []debianPackageCandidate{}, []debianPackageCandidate{}, []debianPackageCandidate{}
+	for _, r := range repositories {
+		err := r.packages(ctx, arch, func(p aptPackage) {
+			candidate := debianPackageCandidate{URL: p.URL, Name: p.Name, Version: p.Version}
+			switch {
+			case p.Name == headersName && p.Architecture == arch:
+				headers = append(headers, candidate)
+			case p.Name == commonName && p.Architecture == "all":
+				commons = append(commons, candidate)
+			case (p.Name == kbuildPrefix || strings.HasPrefix(p.Name, kbuildPrefix+".")) && p.Architecture == arch:
+				kbuilds = append(kbuilds, candidate)
+			default:
+				return
+			}
+			found[p.URL] = p
+		})
+		if err != nil {
+			Logger(ctx).WithError(err).WithField("url", r.baseURL).Debug("skipping archive")
+		}
+	}
+
+	urls, err := selectDebianHeaders(headers, commons)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return buf.String(), nil
+	if len(debianKernelVersion(kv)) == 0 {
+		kv = found[urls[0]].Version
+	}
+	kbuild, err := selectDebianKbuild(kr, kv, kbuilds)
+	if err != nil {
+		return nil, err
+	}
+	return []aptPackage{found[urls[0]], found[urls[1]], found[kbuild.URL]}, nil
 }
 
-func fetchDebianKernelURLs(kr kernelrelease.KernelRelease) ([]string, error) {
-	kbuildURL, err := debianKbuildURLFromRelease(kr)
+// debianKernelVersion returns the kernel version when it is the version of the debian package of the kernel, e.g. 6.1.69-1,
+// nothing otherwise, e.g. for the default one of the CLI.
+func debianKernelVersion(kv string) string {
+	if _, err := kernelrelease.ParseDebianPackageVersion(kv); err != nil {
+		return ""
+	}
+	return kv
+}
+
+// fetchDebianPoolKernelURLs looks for the kernel packages into the pools, the linux-kbuild package closest to the version
+// of the headers being picked when no kernel version is given.
+func fetchDebianPoolKernelURLs(ctx context.Context, kr kernelrelease.KernelRelease, kv string) ([]string, error) {
+	urls, err := debianHeadersURLFromRelease(ctx, kr)
 	if err != nil {
 		return nil, err
 	}
+	if len(debianKernelVersion(kv)) == 0 {
+		// e.g. linux-headers-6.1.0-17-amd64_6.1.69-1_amd64.deb
+		if parts := strings.Split(urlFileName(urls[0]), "_"); len(parts) == 3 {
+			kv = debianPackageVersion(parts[1])
+		}
+	}
 
-	urls, err := debianHeadersURLFromRelease(kr)
+	kbuildURL, err := debianKbuildURLFromRelease(ctx, kr, kv)
 	if err != nil {
 		return nil, err
 	}
@@ -95,131 +337,376 @@ func fetchDebianKernelURLs(kr kernelrelease.KernelRelease) ([]string, error) {
 }
 
 type debianTemplateData struct {
+	buildTemplateData
 	DriverBuildDir     string
 	ModuleDownloadURL  string
 	KernelDownloadURLS []string
+	KernelChecksums    map[string]string
 	KernelLocalVersion string
 	ModuleDriverName   string
 	ModuleFullPath     string
 	BuildModule        bool
 	BuildProbe         bool
+	BuildBTF           bool
+	BTFFullPath        string
 	LLVMVersion        string
+	GCCVersion         string
+	KernelArch         string
+	CrossCompile       string
 }
 
-func debianHeadersURLFromRelease(kr kernelrelease.KernelRelease) ([]string, error) {
-	baseURLS := []string{
-		"http://security-cdn.debian.org/pool/main/l/linux/",
-		"http://security-cdn.debian.org/pool/updates/main/l/linux/",
-		"https://mirrors.edge.kernel.org/debian/pool/main/l/linux/",
-	}
-
-	for _, u := range baseURLS {
-		urls, err := fetchDebianHeadersURLFromRelease(u, kr)
+// debianHeadersBaseURLs are the pools the kernel headers are looked for into.
+var debianHeadersBaseURLs = []string{
+	"http://security-cdn.debian.org/pool/main/l/linux/",
+	"http://security-cdn.debian.org/pool/updates/main/l/linux/",
+	"https://mirrors.edge.kernel.org/debian/pool/main/l/linux/",
+}
 
-		if err == nil {
-			return urls, err
+// debianHeadersURLFromRelease looks for the headers into every pool,
+// the same ABI can be uploaded more than once (e.g. security updates) so the newest upload is picked.
+func debianHeadersURLFromRelease(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
+	headers := []debianPackageCandidate{}
+	common := []debianPackageCandidate{}
+	for _, u := range mirrorsFrom(ctx, debianHeadersBaseURLs) {
+		h, c, err := fetchDebianHeadersCandidates(ctx, u, kr)
+		if err != nil {
+			continue
 		}
+		headers = append(headers, h...)
+		common = append(common, c...)
 	}
 
-	return nil, fmt.Errorf("kernel headers not found")
+	return selectDebianHeaders(headers, common)
 }
 
-func fetchDebianHeadersURLFromRelease(baseURL string, kr kernelrelease.KernelRelease) ([]string, error) {
-	extraVersionPartial := strings.TrimSuffix(kr.FullExtraversion, "-"+kr.Architecture.String())
-	matchExtraGroup := kr.Architecture.String()
-	rmatch := `href="(linux-headers-%d\.%d\.%d%s-(%s)_.*(%s|all)\.deb)"`
+// debianFlavorFromKernelRelease splits the extraversion into the ABI and the flavor,
+// also returning the flavor of the matching headers common package.
+// Example: Input -> "5.10.0-27-rt-amd64", Output -> "-27", "rt-amd64", "common-rt"
+// Example: Input -> "6.1.0-17-cloud-arm64", Output -> "-17", "cloud-arm64", "common"
+func debianFlavorFromKernelRelease(kr kernelrelease.KernelRelease) (string, string, string) {
+	dkr, err := kernelrelease.ParseDebian(kr.Fullversion + kr.FullExtraversion)
+	if err != nil {
+		return kr.FullExtraversion, debianKernelArch(kr.Architecture), "common"
+	}
 
-	// For urls like: http://security.debian.org/pool/updates/main/l/linux/linux-headers-5.10.0-12-amd64_5.10.103-1_amd64.deb
-	// when 5.10.103-1 is passed as kernel version
-	rmatchNew := `href="(linux-headers-[0-9]+\.[0-9]+\.[0-9]+-[0-9]+-(%s)_%d\.%d\.%d%s_(%s|all)\.deb)"`
+	// featuresets (e.g. rt) ship their own common package
+	common := "common"
+	if strings.HasPrefix(dkr.Flavor, "rt-") {
+		common = "common-rt"
+	}
+	return "-" + dkr.DistroRevision, dkr.Flavor, common
+}
 
-	matchExtraGroupCommon := "common"
+// debianKernelArch returns the architecture the Debian kernel releases end with,
+// the one of the packages except for ppc64el, e.g. 5.10.0-27-powerpc64le, and for the 32 bit arm ones, ending with their main flavor.
+func debianKernelArch(a kernelrelease.Architecture) string {
+	switch arch := a.ToDeb(); arch {
+	case "ppc64el":
+		return "powerpc64le"
+	case "armhf":
+		return "armmp"
+	case "armel":
+		return "marvell"
+	default:
+		return arch
+	}
+}
 
-	// match for kernel versions like 4.19.0-6-cloud-amd64
-	if strings.Contains(kr.FullExtraversion, "-cloud") {
-		extraVersionPartial = strings.TrimSuffix(extraVersionPartial, "-cloud")
-		matchExtraGroup = "cloud-" + matchExtraGroup
+// debianPackageArchitecture returns the architecture of the packages of the build, the one of the config when given.
+// The 32 bit arm builds get armhf or armel, as told by the flavor of the kernel release, e.g. armel for 5.10.0-27-marvell,
+// armhf when it does not tell.
+func debianPackageArchitecture(c Config, kr kernelrelease.KernelRelease) kernelrelease.Architecture {
+	if len(c.PackageArchitecture) > 0 {
+		return kernelrelease.Architecture(c.PackageArchitecture)
+	}
+	if kr.Architecture.ToDeb() != "arm" {
+		return kr.Architecture
+	}
+	if dkr, err := kernelrelease.ParseDebian(kr.Fullversion + kr.FullExtraversion); err == nil && (dkr.PackageArch == "armhf" || dkr.PackageArch == "armel") {
+		return kernelrelease.Architecture(dkr.PackageArch)
 	}
+	return "armhf"
+}
 
-	// download index
-	resp, err := http.Get(baseURL)
+func fetchDebianHeadersURLFromRelease(ctx context.Context, baseURL string, kr kernelrelease.KernelRelease) ([]string, error) {
+	headers, common, err := fetchDebianHeadersCandidates(ctx, baseURL, kr)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	return selectDebianHeaders(headers, common)
+}
+
+func fetchDebianHeadersCandidates(ctx context.Context, baseURL string, kr kernelrelease.KernelRelease) ([]debianPackageCandidate, []debianPackageCandidate, error) {
+	// download index
+	body, err := getIndex(ctx, baseURL)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	bodyStr := string(body)
-
-	// look for kernel headers
-	fullregex := fmt.Sprintf(rmatch, kr.Version, kr.PatchLevel, kr.Sublevel,
-		extraVersionPartial, matchExtraGroup, kr.Architecture.String())
-	pattern := regexp.MustCompile(fullregex)
-	matches := pattern.FindStringSubmatch(bodyStr)
-	if len(matches) < 1 {
-		fullregex = fmt.Sprintf(rmatchNew, matchExtraGroup, kr.Version, kr.PatchLevel, kr.Sublevel,
-			extraVersionPartial, kr.Architecture.String())
-		pattern = regexp.MustCompile(fullregex)
-		matches = pattern.FindStringSubmatch(bodyStr)
+
+	headers, common := debianHeadersCandidatesFromIndex(baseURL, string(body), kr)
+	return headers, common, nil
+}
+
+// debianHeadersCandidatesFromIndex lists the headers and headers common packages of the kernel release found in the index,
+// those of the backported kernels only when versioned for the release they are backported to.
+// Example: Input -> "5.10.0-27-amd64", Output -> linux-headers-5.10.0-27-amd64_5.10.205-2_amd64.deb, linux-headers-5.10.0-27-common_5.10.205-2_all.deb
+// Example: Input -> "6.1.0-0.deb11.17-amd64", Output -> linux-headers-6.1.0-0.deb11.17-amd64_6.1.69-1~bpo11+1_amd64.deb, linux-headers-6.1.0-0.deb11.17-common_6.1.69-1~bpo11+1_all.deb
+func debianHeadersCandidatesFromIndex(baseURL, body string, kr kernelrelease.KernelRelease) ([]debianPackageCandidate, []debianPackageCandidate) {
+	extraVersionPartial, matchExtraGroup, matchExtraGroupCommon := debianFlavorFromKernelRelease(kr)
+	// flavor packages are built for the architecture, common ones for all of them
+	arch := kr.Architecture.ToDeb()
+	rmatch := `href="(linux-headers-%d\.%d\.%d%s-(%s)_(%s)_(%s)\.deb)"`
+
+	// For urls like: http://security.debian.org/pool/updates/main/l/linux/linux-headers-5.10.0-12-amd64_5.10.103-1_amd64.deb
+	// when 5.10.103-1 is passed as kernel version, the ABI can be a backport one, e.g. 0.deb11.17
+	rmatchNew := `href="(linux-headers-[0-9]+\.[0-9]+\.[0-9]+-[0-9]+(?:\.[0-9a-z]+)*-(%s)_(%d\.%d\.%d%s)_(%s)\.deb)"`
+
+	find := func(flavor, arch string) []debianPackageCandidate {
+		pattern := regexp.MustCompile(fmt.Sprintf(rmatch, kr.Version, kr.PatchLevel, kr.Sublevel,
+			regexp.QuoteMeta(extraVersionPartial), regexp.QuoteMeta(flavor), debianPackageVersionPattern(kr), arch))
+		matches := pattern.FindAllStringSubmatch(body, -1)
 		if len(matches) < 1 {
-			return nil, fmt.Errorf("kernel headers not found")
+			pattern = regexp.MustCompile(fmt.Sprintf(rmatchNew, regexp.QuoteMeta(flavor), kr.Version, kr.PatchLevel, kr.Sublevel,
+				extraVersionPartial, arch))
+			matches = pattern.FindAllStringSubmatch(body, -1)
 		}
-	}
 
-	// look for kernel headers common
-	fullregexCommon := fmt.Sprintf(rmatch, kr.Version, kr.PatchLevel, kr.Sublevel,
-		extraVersionPartial, matchExtraGroupCommon, kr.Architecture.String())
-	patternCommon := regexp.MustCompile(fullregexCommon)
-	matchesCommon := patternCommon.FindStringSubmatch(bodyStr)
-	if len(matchesCommon) < 1 {
-		fullregexCommon = fmt.Sprintf(rmatchNew, matchExtraGroupCommon, kr.Version, kr.PatchLevel, kr.Sublevel,
-			extraVersionPartial, kr.Architecture.String())
-		patternCommon = regexp.MustCompile(fullregexCommon)
-		matchesCommon = patternCommon.FindStringSubmatch(bodyStr)
-		if len(matchesCommon) < 1 {
-			return nil, fmt.Errorf("kernel headers common not found")
+		candidates := []debianPackageCandidate{}
+		for _, match := range matches {
+			candidates = append(candidates, debianPackageCandidate{
+				URL:     fmt.Sprintf("%s%s", baseURL, match[1]),
+				Name:    strings.SplitN(match[1], "_", 2)[0],
+				Version: debianPackageVersion(match[3]),
+			})
 		}
+		return candidates
 	}
 
-	foundURLs := []string{fmt.Sprintf("%s%s", baseURL, matches[1])}
-	foundURLs = append(foundURLs, fmt.Sprintf("%s%s", baseURL, matchesCommon[1]))
+	return find(matchExtraGroup, arch), find(matchExtraGroupCommon, "all")
+}
+
+// selectDebianHeaders picks the newest headers together with the headers common of the same version,
+// falling back to the newest headers common when there is none.
+func selectDebianHeaders(headers, common []debianPackageCandidate) ([]string, error) {
+	if len(headers) == 0 {
+		return nil, fmt.Errorf("kernel headers not found")
+	}
+	if len(common) == 0 {
+		return nil, fmt.Errorf("kernel headers common not found")
+	}
+	sortDebianPackages(headers)
+	sortDebianPackages(common)
+
+	for i := len(headers) - 1; i >= 0; i-- {
+		for j := len(common) - 1; j >= 0; j-- {
+			if compareDebianVersions(headers[i].Version, common[j].Version) == 0 {
+				return []string{headers[i].URL, common[j].URL}, nil
+			}
+		}
+	}
+	return []string{headers[len(headers)-1].URL, common[len(common)-1].URL}, nil
+}
 
-	return foundURLs, nil
+// sortDebianPackages sorts the packages from the oldest to the newest version.
+func sortDebianPackages(candidates []debianPackageCandidate) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return compareDebianVersions(candidates[i].Version, candidates[j].Version) < 0
+	})
 }
 
-func debianKbuildURLFromRelease(kr kernelrelease.KernelRelease) (string, error) {
-	rmatch := `href="(linux-kbuild-%d\.%d.*%s\.deb)"`
+type debianPackageCandidate struct {
+	URL     string
+	Name    string
+	Version string
+}
 
-	kbuildPattern := regexp.MustCompile(fmt.Sprintf(rmatch, kr.Version, kr.PatchLevel, kr.Architecture.String()))
-	baseURL := "http://mirrors.kernel.org/debian/pool/main/l/linux/"
+// debianKbuildURLFromRelease looks for the linux-kbuild package matching the kernel release.
+// The upstream kernel version (e.g. 6.1.69-1, as found in `uname -v`) can be provided as kernel version
+// to pick the closest package, otherwise the kernel release is used.
+func debianKbuildURLFromRelease(ctx context.Context, kr kernelrelease.KernelRelease, kv string) (string, error) {
+	baseURLs := []string{
+		"http://mirrors.kernel.org/debian/pool/main/l/linux/",
+		// old backports have their own archive
+		"http://archive.debian.org/debian-backports/pool/main/l/linux/",
+	}
 	if kr.Version == 3 {
-		baseURL = "http://mirrors.kernel.org/debian/pool/main/l/linux-tools/"
+		baseURLs = []string{"http://mirrors.kernel.org/debian/pool/main/l/linux-tools/"}
+	} else {
+		baseURLs = mirrorsFrom(ctx, baseURLs)
 	}
 
-	resp, err := http.Get(baseURL)
-	if err != nil {
-		return "", err
+	candidates := []debianPackageCandidate{}
+	for _, baseURL := range baseURLs {
+		body, err := getIndex(ctx, baseURL)
+		if err != nil {
+			continue
+		}
+		candidates = append(candidates, debianKbuildCandidatesFromIndex(baseURL, string(body), kr)...)
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+
+	candidate, err := selectDebianKbuild(kr, kv, candidates)
 	if err != nil {
 		return "", err
 	}
-	match := kbuildPattern.FindStringSubmatch(string(body))
+	return candidate.URL, nil
+}
 
-	if len(match) != 2 {
-		return "", fmt.Errorf("kbuild not found")
+// debianKbuildCandidatesFromIndex lists the linux-kbuild packages of the kernel version and patch level found in the index.
+// Example: linux-kbuild-6.1_6.1.69-1_amd64.deb, linux-kbuild-6.5.0-0.deb12.4_6.5.10-1~bpo12+1_amd64.deb
+func debianKbuildCandidatesFromIndex(baseURL, body string, kr kernelrelease.KernelRelease) []debianPackageCandidate {
+	pattern := regexp.MustCompile(fmt.Sprintf(`href="((linux-kbuild-%d\.%d[^_"]*)_([^_"]+)_%s\.deb)"`, kr.Version, kr.PatchLevel, kr.Architecture.ToDeb()))
+
+	candidates := []debianPackageCandidate{}
+	for _, match := range pattern.FindAllStringSubmatch(body, -1) {
+		candidates = append(candidates, debianPackageCandidate{
+			URL:     fmt.Sprintf("%s%s", baseURL, match[1]),
+			Name:    match[2],
+			Version: debianPackageVersion(match[3]),
+		})
 	}
+	return candidates
+}
 
-	return fmt.Sprintf("%s%s", baseURL, match[1]), nil
+// selectDebianKbuild picks the package built for the very same ABI when there is one,
+// otherwise the package whose version is the closest to, and not older than, the kernel version,
+// the newest one when the kernel version is not the one of a debian package.
+func selectDebianKbuild(kr kernelrelease.KernelRelease, kv string, candidates []debianPackageCandidate) (*debianPackageCandidate, error) {
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("kbuild not found")
+	}
+	sortDebianPackages(candidates)
+
+	// packages like linux-kbuild-6.5.0-0.deb12.4 are tied to an ABI
+	extraVersionPartial, _, _ := debianFlavorFromKernelRelease(kr)
+	abi := fmt.Sprintf("linux-kbuild-%s%s", kr.Fullversion, extraVersionPartial)
+	for i := len(candidates) - 1; i >= 0; i-- {
+		if candidates[i].Name == abi {
+			return &candidates[i], nil
+		}
+	}
+
+	// only the upstream version of the kernel is compared to the ones of the packages
+	r, err := kernelrelease.ParseDebianPackageVersion(kv)
+	if err != nil {
+		return &candidates[len(candidates)-1], nil
+	}
+	reference := kernelrelease.FromString(r.Fullversion)
+	for i := range candidates {
+		if release, err := kernelrelease.ParseDebianPackageVersion(candidates[i].Version); err == nil && !release.LessThan(reference) {
+			return &candidates[i], nil
+		}
+	}
+	// every package is older than the kernel, the newest is the closest one
+	return &candidates[len(candidates)-1], nil
+}
+
+// compareDebianVersions compares two package versions (without epoch) the way dpkg does,
+// the upstream versions first and then the debian revisions, see kernelrelease.Compare.
+// Example: "6.1.69-1" > "6.1.8-1", "5.10.205-10" > "5.10.205-2", "6.1.8-1" > "6.1.8-1~bpo11+1"
+func compareDebianVersions(a, b string) int {
+	// the versions of the packages listed in the indexes always have an upstream version
+	releaseA, _ := kernelrelease.ParseDebianPackageVersion(a)
+	releaseB, _ := kernelrelease.ParseDebianPackageVersion(b)
+	return kernelrelease.Compare(releaseA, releaseB)
+}
+
+// debianLLVMVersions lists, from the newest, the clang releases used to build the eBPF probe
+// along with the oldest kernel each one is used for.
+var debianLLVMVersions = []struct {
+	version    int
+	patchLevel int
+	llvm       string
+}{
+	{6, 0, "14"},
+	{5, 0, "12"},
+	{4, 14, "7"},
+	{0, 0, "6.0"},
 }
 
 func debianLLVMVersionFromKernelRelease(kr kernelrelease.KernelRelease) string {
-	switch kr.Version {
-	case 5:
-		return "12"
+	for _, v := range debianLLVMVersions {
+		if kr.Version > v.version || (kr.Version == v.version && kr.PatchLevel >= v.patchLevel) {
+			return v.llvm
+		}
+	}
+	return debianLLVMVersions[len(debianLLVMVersions)-1].llvm
+}
+
+// debianGCCVersions lists, from the newest, the gcc releases of the builder image used to build the kernel module and the eBPF probe
+// along with the oldest kernel each one is used for: the kernels before 4.2 only know the gcc releases they have a compiler-gcc header for.
+var debianGCCVersions = []struct {
+	version    int
+	patchLevel int
+	gcc        string
+}{
+	{4, 2, "8"},
+	{0, 0, "4.8"},
+}
+
+func debianGCCVersionFromKernelRelease(kr kernelrelease.KernelRelease) string {
+	for _, v := range debianGCCVersions {
+		if kr.Version > v.version || (kr.Version == v.version && kr.PatchLevel >= v.patchLevel) {
+			return v.gcc
+		}
+	}
+	return debianGCCVersions[len(debianGCCVersions)-1].gcc
+}
+
+type debianSnapshotBinaryVersions struct {
+	Result []struct {
+		BinaryVersion string `json:"binary_version"`
+	} `json:"result"`
+}
+
+type debianSnapshotBinaryFiles struct {
+	Result []struct {
+		Architecture string `json:"architecture"`
+		Hash         string `json:"hash"`
+	} `json:"result"`
+}
+
+// fetchDebianSnapshotKernelURLs locates the linux-headers, linux-headers-common and linux-kbuild packages
+// of the kernel release using the snapshot.debian.org machine-readable API.
+// Example: Input -> "5.10.0-12-amd64", Output -> packages of the 5.10.103-1 linux source version
+func fetchDebianSnapshotKernelURLs(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
+	extraVersionPartial, _, common := debianFlavorFromKernelRelease(kr)
+
+	headers := fmt.Sprintf("linux-headers-%s%s", kr.Fullversion, kr.FullExtraversion)
+	versions := debianSnapshotBinaryVersions{}
+	if err := getJSON(ctx, fmt.Sprintf("%s/mr/binary/%s/", debianSnapshotURL, headers), &versions); err != nil {
+		return nil, err
+	}
+	if len(versions.Result) == 0 {
+		return nil, fmt.Errorf("kernel headers not found")
+	}
+	// the most recent version comes first
+	version := versions.Result[0].BinaryVersion
+
+	packages := []string{
+		headers,
+		fmt.Sprintf("linux-headers-%s%s-%s", kr.Fullversion, extraVersionPartial, common),
+		fmt.Sprintf("linux-kbuild-%d.%d", kr.Version, kr.PatchLevel),
+	}
+	urls := []string{}
+	for _, p := range packages {
+		u, err := fetchDebianSnapshotBinaryURL(ctx, p, version, kr.Architecture.ToDeb())
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, u)
+	}
+	return urls, nil
+}
+
+func fetchDebianSnapshotBinaryURL(ctx context.Context, name, version, arch string) (string, error) {
+	files := debianSnapshotBinaryFiles{}
+	if err := getJSON(ctx, fmt.Sprintf("%s/mr/binary/%s/%s/binfiles", debianSnapshotURL, name, version), &files); err != nil {
+		return "", err
+	}
+	for _, f := range files.Result {
+		if f.Architecture == arch || f.Architecture == "all" {
+			return fmt.Sprintf("%s/file/%s", debianSnapshotURL, f.Hash), nil
+		}
 	}
-	return "7"
+	return "", fmt.Errorf("%s %s not found", name, version)
 }
@@ -0,0 +1,820 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+func TestFetchDebianSnapshotKernelURLs(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/mr/binary/linux-headers-5.10.0-12-amd64/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"_comment":"foo","binary":"linux-headers-5.10.0-12-amd64","result":[{"binary_version":"5.10.103-1","name":"linux-headers-5.10.0-12-amd64","source":"linux","version":"5.10.103-1"}]}`)
+	})
+	mux.HandleFunc("/mr/binary/linux-headers-5.10.0-12-amd64/5.10.103-1/binfiles", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"_comment":"foo","binary":"linux-headers-5.10.0-12-amd64","binary_version":"5.10.103-1","result":[{"architecture":"amd64","hash":"aaaa"},{"architecture":"arm64","hash":"bbbb"}]}`)
+	})
+	mux.HandleFunc("/mr/binary/linux-headers-5.10.0-12-common/5.10.103-1/binfiles", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"_comment":"foo","binary":"linux-headers-5.10.0-12-common","binary_version":"5.10.103-1","result":[{"architecture":"all","hash":"cccc"}]}`)
+	})
+	mux.HandleFunc("/mr/binary/linux-kbuild-5.10/5.10.103-1/binfiles", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"_comment":"foo","binary":"linux-kbuild-5.10","binary_version":"5.10.103-1","result":[{"architecture":"arm64","hash":"dddd"},{"architecture":"amd64","hash":"eeee"}]}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	defaultSnapshotURL := debianSnapshotURL
+	debianSnapshotURL = server.URL
+	defer func() { debianSnapshotURL = defaultSnapshotURL }()
+
+	tests := map[string]struct {
+		kernelrelease string
+		expected      []string
+		err           error
+	}{
+		"available in the snapshots": {
+			kernelrelease: "5.10.0-12-amd64",
+			expected: []string{
+				fmt.Sprintf("%s/file/aaaa", server.URL),
+				fmt.Sprintf("%s/file/cccc", server.URL),
+				fmt.Sprintf("%s/file/eeee", server.URL),
+			},
+		},
+		"missing from the snapshots": {
+			kernelrelease: "5.10.0-13-amd64",
+			err:           fmt.Errorf("unable to fetch %s/mr/binary/linux-headers-5.10.0-13-amd64/: 404 Not Found", server.URL),
+		},
+	}
+
+	for name, test := range tests {
+		kr := kernelrelease.FromString(test.kernelrelease)
+		kr.Architecture = "amd64"
+
+		gotURLs, err := fetchDebianSnapshotKernelURLs(context.Background(), kr)
+		if test.err != nil {
+			if err == nil || err.Error() != test.err.Error() {
+				t.Fatalf("Unexpected error encountered with Test Input: '%s' | Got: '%v' / Want: '%s'", name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error encountered with Test Input: '%s' | Error: '%s'", name, err)
+		}
+
+		if len(gotURLs) != len(test.expected) {
+			t.Fatalf("Slice sizes don't match! Test Input: '%s' | Got: '%v' / Want: '%v'", name, gotURLs, test.expected)
+		}
+		for i, v := range gotURLs {
+			if v != test.expected[i] {
+				t.Fatalf("Slice values don't match! Test Input: '%s' | Got: '%v' / Want: '%v'", name, gotURLs, test.expected)
+			}
+		}
+	}
+}
+
+const debianKbuildIndex = `<html><body><pre>
+<a href="linux-kbuild-5.10_5.10.197-1_amd64.deb">linux-kbuild-5.10_5.10.197-1_amd64.deb</a>
+<a href="linux-kbuild-5.10_5.10.205-2_amd64.deb">linux-kbuild-5.10_5.10.205-2_amd64.deb</a>
+<a href="linux-kbuild-5.10_5.10.205-2_arm64.deb">linux-kbuild-5.10_5.10.205-2_arm64.deb</a>
+<a href="linux-kbuild-6.1_6.1.66-1_amd64.deb">linux-kbuild-6.1_6.1.66-1_amd64.deb</a>
+<a href="linux-kbuild-6.1_6.1.69-1_amd64.deb">linux-kbuild-6.1_6.1.69-1_amd64.deb</a>
+<a href="linux-kbuild-6.1_6.1.8-1~bpo11%2B1_amd64.deb">linux-kbuild-6.1_6.1.8-1~bpo11+1_amd64.deb</a>
+<a href="linux-kbuild-6.5.0-0.deb12.1_6.5.3-1~bpo12%2B1_amd64.deb">linux-kbuild-6.5.0-0.deb12.1_6.5.3-1~bpo12+1_amd64.deb</a>
+<a href="linux-kbuild-6.5.0-0.deb12.4_6.5.10-1~bpo12%2B1_amd64.deb">linux-kbuild-6.5.0-0.deb12.4_6.5.10-1~bpo12+1_amd64.deb</a>
+</pre></body></html>`
+
+func TestSelectDebianKbuild(t *testing.T) {
+	baseURL := "http://mirrors.kernel.org/debian/pool/main/l/linux/"
+	tests := map[string]struct {
+		kernelrelease string
+		kernelversion string
+		expected      string
+	}{
+		"bookworm": {
+			kernelrelease: "6.1.0-17-amd64",
+			kernelversion: "6.1.69-1",
+			expected:      "linux-kbuild-6.1_6.1.69-1_amd64.deb",
+		},
+		"bookworm without kernel version": {
+			kernelrelease: "6.1.0-17-amd64",
+			kernelversion: "1",
+			expected:      "linux-kbuild-6.1_6.1.69-1_amd64.deb",
+		},
+		"bookworm-backports": {
+			kernelrelease: "6.5.0-0.deb12.4-amd64",
+			kernelversion: "1",
+			expected:      "linux-kbuild-6.5.0-0.deb12.4_6.5.10-1~bpo12%2B1_amd64.deb",
+		},
+		"bookworm-backports cloud": {
+			kernelrelease: "6.5.0-0.deb12.1-cloud-amd64",
+			kernelversion: "1",
+			expected:      "linux-kbuild-6.5.0-0.deb12.1_6.5.3-1~bpo12%2B1_amd64.deb",
+		},
+		"bullseye": {
+			kernelrelease: "5.10.0-26-amd64",
+			kernelversion: "5.10.197-1",
+			expected:      "linux-kbuild-5.10_5.10.197-1_amd64.deb",
+		},
+		"bullseye newer than any package": {
+			kernelrelease: "5.10.0-28-amd64",
+			kernelversion: "5.10.209-2",
+			expected:      "linux-kbuild-5.10_5.10.205-2_amd64.deb",
+		},
+	}
+
+	for name, test := range tests {
+		kr := kernelrelease.FromString(test.kernelrelease)
+		kr.Architecture = "amd64"
+
+		candidates := debianKbuildCandidatesFromIndex(baseURL, debianKbuildIndex, kr)
+		got, err := selectDebianKbuild(kr, test.kernelversion, candidates)
+		if err != nil {
+			t.Fatalf("Unexpected error encountered with Test Input: '%s' | Error: '%s'", name, err)
+		}
+		if got.URL != baseURL+test.expected {
+			t.Errorf("Test Input: [ '%s' ] | Got: [ '%s' ] / Want: [ '%s' ]", name, got.URL, baseURL+test.expected)
+		}
+	}
+}
+
+func TestSelectDebianKbuildNotFound(t *testing.T) {
+	kr := kernelrelease.FromString("4.19.0-25-amd64")
+	kr.Architecture = "amd64"
+
+	candidates := debianKbuildCandidatesFromIndex("http://mirrors.kernel.org/debian/pool/main/l/linux/", debianKbuildIndex, kr)
+	if _, err := selectDebianKbuild(kr, "1", candidates); err == nil || err.Error() != "kbuild not found" {
+		t.Fatalf("Unexpected error encountered | Got: '%v' / Want: 'kbuild not found'", err)
+	}
+}
+
+func TestFetchDebianHeadersURLFromReleaseFlavors(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body><pre>
+<a href="linux-headers-5.10.0-27-amd64_5.10.205-2_amd64.deb">linux-headers-5.10.0-27-amd64_5.10.205-2_amd64.deb</a>
+<a href="linux-headers-5.10.0-27-common-rt_5.10.205-2_all.deb">linux-headers-5.10.0-27-common-rt_5.10.205-2_all.deb</a>
+<a href="linux-headers-5.10.0-27-common_5.10.205-2_all.deb">linux-headers-5.10.0-27-common_5.10.205-2_all.deb</a>
+<a href="linux-headers-5.10.0-27-rt-amd64_5.10.205-2_amd64.deb">linux-headers-5.10.0-27-rt-amd64_5.10.205-2_amd64.deb</a>
+<a href="linux-headers-6.1.0-17-arm64_6.1.69-1_arm64.deb">linux-headers-6.1.0-17-arm64_6.1.69-1_arm64.deb</a>
+<a href="linux-headers-6.1.0-17-cloud-arm64_6.1.69-1_arm64.deb">linux-headers-6.1.0-17-cloud-arm64_6.1.69-1_arm64.deb</a>
+<a href="linux-headers-6.1.0-17-common-rt_6.1.69-1_all.deb">linux-headers-6.1.0-17-common-rt_6.1.69-1_all.deb</a>
+<a href="linux-headers-6.1.0-17-common_6.1.69-1_all.deb">linux-headers-6.1.0-17-common_6.1.69-1_all.deb</a>
+</pre></body></html>`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	baseURL := server.URL + "/"
+
+	tests := map[string]struct {
+		kernelrelease string
+		arch          kernelrelease.Architecture
+		expected      []string
+	}{
+		"rt": {
+			kernelrelease: "5.10.0-27-rt-amd64",
+			arch:          "amd64",
+			expected: []string{
+				baseURL + "linux-headers-5.10.0-27-rt-amd64_5.10.205-2_amd64.deb",
+				baseURL + "linux-headers-5.10.0-27-common-rt_5.10.205-2_all.deb",
+			},
+		},
+		"cloud": {
+			kernelrelease: "6.1.0-17-cloud-arm64",
+			arch:          "arm64",
+			expected: []string{
+				baseURL + "linux-headers-6.1.0-17-cloud-arm64_6.1.69-1_arm64.deb",
+				baseURL + "linux-headers-6.1.0-17-common_6.1.69-1_all.deb",
+			},
+		},
+	}
+
+	for name, test := range tests {
+		kr := kernelrelease.FromString(test.kernelrelease)
+		kr.Architecture = test.arch
+
+		gotURLs, err := fetchDebianHeadersURLFromRelease(context.Background(), baseURL, kr)
+		if err != nil {
+			t.Fatalf("Unexpected error encountered with Test Input: '%s' | Error: '%s'", name, err)
+		}
+		if len(gotURLs) != len(test.expected) {
+			t.Fatalf("Slice sizes don't match! Test Input: '%s' | Got: '%v' / Want: '%v'", name, gotURLs, test.expected)
+		}
+		for i, v := range gotURLs {
+			if v != test.expected[i] {
+				t.Fatalf("Slice values don't match! Test Input: '%s' | Got: '%v' / Want: '%v'", name, gotURLs, test.expected)
+			}
+		}
+	}
+}
+
+func TestFetchDebianHeadersURLFromReleaseArchitectures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, filepath.Join("testdata", "debian-pool-index.html"))
+	}))
+	defer server.Close()
+	baseURL := server.URL + "/"
+
+	tests := map[string]struct {
+		kernelrelease string
+		arch          kernelrelease.Architecture
+		expected      []string
+	}{
+		"amd64": {
+			kernelrelease: "5.10.0-27-amd64",
+			arch:          "amd64",
+			expected: []string{
+				baseURL + "linux-headers-5.10.0-27-amd64_5.10.205-2_amd64.deb",
+				baseURL + "linux-headers-5.10.0-27-common_5.10.205-2_all.deb",
+			},
+		},
+		"arm64": {
+			kernelrelease: "5.10.0-27-arm64",
+			arch:          "arm64",
+			expected: []string{
+				baseURL + "linux-headers-5.10.0-27-arm64_5.10.205-2_arm64.deb",
+				baseURL + "linux-headers-5.10.0-27-common_5.10.205-2_all.deb",
+			},
+		},
+		"aarch64": {
+			kernelrelease: "5.10.0-27-rt-arm64",
+			arch:          "aarch64",
+			expected: []string{
+				baseURL + "linux-headers-5.10.0-27-rt-arm64_5.10.205-2_arm64.deb",
+				baseURL + "linux-headers-5.10.0-27-common-rt_5.10.205-2_all.deb",
+			},
+		},
+		"armhf": {
+			kernelrelease: "5.10.0-27-armmp",
+			arch:          "armhf",
+			expected: []string{
+				baseURL + "linux-headers-5.10.0-27-armmp_5.10.205-2_armhf.deb",
+				baseURL + "linux-headers-5.10.0-27-common_5.10.205-2_all.deb",
+			},
+		},
+		"armhf lpae": {
+			kernelrelease: "5.10.0-27-armmp-lpae",
+			arch:          "armhf",
+			expected: []string{
+				baseURL + "linux-headers-5.10.0-27-armmp-lpae_5.10.205-2_armhf.deb",
+				baseURL + "linux-headers-5.10.0-27-common_5.10.205-2_all.deb",
+			},
+		},
+		"ppc64el": {
+			kernelrelease: "5.10.0-27-powerpc64le",
+			arch:          "ppc64le",
+			expected: []string{
+				baseURL + "linux-headers-5.10.0-27-powerpc64le_5.10.205-2_ppc64el.deb",
+				baseURL + "linux-headers-5.10.0-27-common_5.10.205-2_all.deb",
+			},
+		},
+		"s390x": {
+			kernelrelease: "5.10.0-27-s390x",
+			arch:          "s390x",
+			expected: []string{
+				baseURL + "linux-headers-5.10.0-27-s390x_5.10.205-2_s390x.deb",
+				baseURL + "linux-headers-5.10.0-27-common_5.10.205-2_all.deb",
+			},
+		},
+	}
+
+	for name, test := range tests {
+		kr := kernelrelease.FromString(test.kernelrelease)
+		kr.Architecture = test.arch
+
+		gotURLs, err := fetchDebianHeadersURLFromRelease(context.Background(), baseURL, kr)
+		if err != nil {
+			t.Fatalf("Unexpected error encountered with Test Input: '%s' | Error: '%s'", name, err)
+		}
+		if len(gotURLs) != len(test.expected) {
+			t.Fatalf("Slice sizes don't match! Test Input: '%s' | Got: '%v' / Want: '%v'", name, gotURLs, test.expected)
+		}
+		for i, v := range gotURLs {
+			if v != test.expected[i] {
+				t.Fatalf("Slice values don't match! Test Input: '%s' | Got: '%v' / Want: '%v'", name, gotURLs, test.expected)
+			}
+		}
+	}
+}
+
+func TestFetchDebianHeadersURLFromReleaseArm(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body><pre>
+<a href="linux-headers-5.10.0-27-armmp-lpae_5.10.205-2_armhf.deb">linux-headers-5.10.0-27-armmp-lpae_5.10.205-2_armhf.deb</a>
+<a href="linux-headers-5.10.0-27-armmp_5.10.205-2_armhf.deb">linux-headers-5.10.0-27-armmp_5.10.205-2_armhf.deb</a>
+<a href="linux-headers-5.10.0-27-common-rt_5.10.205-2_all.deb">linux-headers-5.10.0-27-common-rt_5.10.205-2_all.deb</a>
+<a href="linux-headers-5.10.0-27-common_5.10.205-2_all.deb">linux-headers-5.10.0-27-common_5.10.205-2_all.deb</a>
+<a href="linux-headers-5.10.0-27-marvell_5.10.205-2_armel.deb">linux-headers-5.10.0-27-marvell_5.10.205-2_armel.deb</a>
+<a href="linux-headers-5.10.0-27-rt-armmp_5.10.205-2_armhf.deb">linux-headers-5.10.0-27-rt-armmp_5.10.205-2_armhf.deb</a>
+</pre></body></html>`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	baseURL := server.URL + "/"
+
+	tests := map[string]struct {
+		kernelrelease string
+		packageArch   string
+		expected      []string
+	}{
+		"armmp": {
+			kernelrelease: "5.10.0-27-armmp",
+			expected: []string{
+				baseURL + "linux-headers-5.10.0-27-armmp_5.10.205-2_armhf.deb",
+				baseURL + "linux-headers-5.10.0-27-common_5.10.205-2_all.deb",
+			},
+		},
+		"armmp-lpae": {
+			kernelrelease: "5.10.0-27-armmp-lpae",
+			expected: []string{
+				baseURL + "linux-headers-5.10.0-27-armmp-lpae_5.10.205-2_armhf.deb",
+				baseURL + "linux-headers-5.10.0-27-common_5.10.205-2_all.deb",
+			},
+		},
+		"rt-armmp": {
+			kernelrelease: "5.10.0-27-rt-armmp",
+			expected: []string{
+				baseURL + "linux-headers-5.10.0-27-rt-armmp_5.10.205-2_armhf.deb",
+				baseURL + "linux-headers-5.10.0-27-common-rt_5.10.205-2_all.deb",
+			},
+		},
+		"marvell": {
+			kernelrelease: "5.10.0-27-marvell",
+			expected: []string{
+				baseURL + "linux-headers-5.10.0-27-marvell_5.10.205-2_armel.deb",
+				baseURL + "linux-headers-5.10.0-27-common_5.10.205-2_all.deb",
+			},
+		},
+		"marvell hinted": {
+			kernelrelease: "5.10.0-27-marvell",
+			packageArch:   "armel",
+			expected: []string{
+				baseURL + "linux-headers-5.10.0-27-marvell_5.10.205-2_armel.deb",
+				baseURL + "linux-headers-5.10.0-27-common_5.10.205-2_all.deb",
+			},
+		},
+	}
+
+	for name, test := range tests {
+		kr := kernelrelease.FromString(test.kernelrelease)
+		kr.Architecture = "arm"
+		kr.Architecture = debianPackageArchitecture(Config{Build: &Build{PackageArchitecture: test.packageArch}}, kr)
+
+		gotURLs, err := fetchDebianHeadersURLFromRelease(context.Background(), baseURL, kr)
+		if err != nil {
+			t.Fatalf("Unexpected error encountered with Test Input: '%s' | Error: '%s'", name, err)
+		}
+		if len(gotURLs) != len(test.expected) {
+			t.Fatalf("Slice sizes don't match! Test Input: '%s' | Got: '%v' / Want: '%v'", name, gotURLs, test.expected)
+		}
+		for i, v := range gotURLs {
+			if v != test.expected[i] {
+				t.Fatalf("Slice values don't match! Test Input: '%s' | Got: '%v' / Want: '%v'", name, gotURLs, test.expected)
+			}
+		}
+	}
+}
+
+func TestDebianPackageArchitecture(t *testing.T) {
+	tests := map[string]struct {
+		kernelrelease string
+		arch          kernelrelease.Architecture
+		packageArch   string
+		expected      kernelrelease.Architecture
+	}{
+		"amd64":            {kernelrelease: "5.10.0-27-amd64", arch: "amd64", expected: "amd64"},
+		"arm armmp":        {kernelrelease: "5.10.0-27-armmp", arch: "arm", expected: "armhf"},
+		"arm armmp-lpae":   {kernelrelease: "5.10.0-27-armmp-lpae", arch: "arm", expected: "armhf"},
+		"arm marvell":      {kernelrelease: "5.10.0-27-marvell", arch: "arm", expected: "armel"},
+		"arm unknown":      {kernelrelease: "5.10.0-27", arch: "arm", expected: "armhf"},
+		"armv7l":           {kernelrelease: "5.10.0-27-armmp", arch: "armv7l", expected: "armv7l"},
+		"hinted":           {kernelrelease: "5.10.0-27-armmp", arch: "arm", packageArch: "armel", expected: "armel"},
+		"hinted over arch": {kernelrelease: "5.10.0-27-marvell", arch: "armhf", packageArch: "armel", expected: "armel"},
+	}
+	for name, test := range tests {
+		kr := kernelrelease.FromString(test.kernelrelease)
+		kr.Architecture = test.arch
+		if got := debianPackageArchitecture(Config{Build: &Build{PackageArchitecture: test.packageArch}}, kr); got != test.expected {
+			t.Errorf("Test Input: '%s' | Got: '%s' / Want: '%s'", name, got, test.expected)
+		}
+	}
+}
+
+func TestFetchDebianHeadersURLFromReleaseBackports(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body><pre>
+<a href="linux-headers-6.1.0-0.deb11.13-amd64_6.1.55-1~bpo11%2B1_amd64.deb">linux-headers-6.1.0-0.deb11.13-amd64_6.1.55-1~bpo11+1_amd64.deb</a>
+<a href="linux-headers-6.1.0-0.deb11.13-common_6.1.55-1~bpo11%2B1_all.deb">linux-headers-6.1.0-0.deb11.13-common_6.1.55-1~bpo11+1_all.deb</a>
+<a href="linux-headers-6.1.0-0.deb11.17-amd64_6.1.69-1~bpo11%2B1_amd64.deb">linux-headers-6.1.0-0.deb11.17-amd64_6.1.69-1~bpo11+1_amd64.deb</a>
+<a href="linux-headers-6.1.0-0.deb11.17-amd64_6.1.69-2_amd64.deb">linux-headers-6.1.0-0.deb11.17-amd64_6.1.69-2_amd64.deb</a>
+<a href="linux-headers-6.1.0-0.deb11.17-common_6.1.69-1~bpo11%2B1_all.deb">linux-headers-6.1.0-0.deb11.17-common_6.1.69-1~bpo11+1_all.deb</a>
+<a href="linux-headers-6.1.0-0.deb11.18-amd64_6.1.76-1~deb11u1_amd64.deb">linux-headers-6.1.0-0.deb11.18-amd64_6.1.76-1~deb11u1_amd64.deb</a>
+<a href="linux-headers-6.1.0-0.deb11.18-common_6.1.76-1~deb11u1_all.deb">linux-headers-6.1.0-0.deb11.18-common_6.1.76-1~deb11u1_all.deb</a>
+<a href="linux-headers-6.5.0-0.deb12.4-arm64_6.5.10-1%7Ebpo12%2B1_arm64.deb">linux-headers-6.5.0-0.deb12.4-arm64_6.5.10-1~bpo12+1_arm64.deb</a>
+<a href="linux-headers-6.5.0-0.deb12.4-common_6.5.10-1%7Ebpo12%2B1_all.deb">linux-headers-6.5.0-0.deb12.4-common_6.5.10-1~bpo12+1_all.deb</a>
+<a href="linux-headers-6.5.0-0.deb12.4-rt-amd64_6.5.10-1~bpo12%2B1_amd64.deb">linux-headers-6.5.0-0.deb12.4-rt-amd64_6.5.10-1~bpo12+1_amd64.deb</a>
+<a href="linux-headers-6.5.0-0.deb12.4-common-rt_6.5.10-1~bpo12%2B1_all.deb">linux-headers-6.5.0-0.deb12.4-common-rt_6.5.10-1~bpo12+1_all.deb</a>
+</pre></body></html>`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	baseURL := server.URL + "/"
+
+	tests := map[string]struct {
+		kernelrelease string
+		arch          kernelrelease.Architecture
+		expected      []string
+	}{
+		"bullseye backports": {
+			kernelrelease: "6.1.0-0.deb11.17-amd64",
+			arch:          "amd64",
+			expected: []string{
+				baseURL + "linux-headers-6.1.0-0.deb11.17-amd64_6.1.69-1~bpo11%2B1_amd64.deb",
+				baseURL + "linux-headers-6.1.0-0.deb11.17-common_6.1.69-1~bpo11%2B1_all.deb",
+			},
+		},
+		"bullseye security": {
+			kernelrelease: "6.1.0-0.deb11.18-amd64",
+			arch:          "amd64",
+			expected: []string{
+				baseURL + "linux-headers-6.1.0-0.deb11.18-amd64_6.1.76-1~deb11u1_amd64.deb",
+				baseURL + "linux-headers-6.1.0-0.deb11.18-common_6.1.76-1~deb11u1_all.deb",
+			},
+		},
+		"bookworm backports": {
+			kernelrelease: "6.5.0-0.deb12.4-arm64",
+			arch:          "arm64",
+			expected: []string{
+				baseURL + "linux-headers-6.5.0-0.deb12.4-arm64_6.5.10-1%7Ebpo12%2B1_arm64.deb",
+				baseURL + "linux-headers-6.5.0-0.deb12.4-common_6.5.10-1%7Ebpo12%2B1_all.deb",
+			},
+		},
+		"bookworm backports rt": {
+			kernelrelease: "6.5.0-0.deb12.4-rt-amd64",
+			arch:          "amd64",
+			expected: []string{
+				baseURL + "linux-headers-6.5.0-0.deb12.4-rt-amd64_6.5.10-1~bpo12%2B1_amd64.deb",
+				baseURL + "linux-headers-6.5.0-0.deb12.4-common-rt_6.5.10-1~bpo12%2B1_all.deb",
+			},
+		},
+	}
+
+	for name, test := range tests {
+		kr := kernelrelease.FromString(test.kernelrelease)
+		kr.Architecture = test.arch
+
+		gotURLs, err := fetchDebianHeadersURLFromRelease(context.Background(), baseURL, kr)
+		if err != nil {
+			t.Fatalf("Unexpected error encountered with Test Input: '%s' | Error: '%s'", name, err)
+		}
+		if len(gotURLs) != len(test.expected) {
+			t.Fatalf("Slice sizes don't match! Test Input: '%s' | Got: '%v' / Want: '%v'", name, gotURLs, test.expected)
+		}
+		for i, v := range gotURLs {
+			if v != test.expected[i] {
+				t.Fatalf("Slice values don't match! Test Input: '%s' | Got: '%v' / Want: '%v'", name, gotURLs, test.expected)
+			}
+		}
+	}
+}
+
+func TestDebianPackageVersion(t *testing.T) {
+	tests := map[string]string{
+		"6.1.69-1":             "6.1.69-1",
+		"6.1.69-1~bpo11%2B1":   "6.1.69-1~bpo11+1",
+		"6.5.10-1%7Ebpo12%2B1": "6.5.10-1~bpo12+1",
+	}
+	for escaped, expected := range tests {
+		if got := debianPackageVersion(escaped); got != expected {
+			t.Errorf("Test Input: '%s' | Got: '%s' / Want: '%s'", escaped, got, expected)
+		}
+	}
+}
+
+func TestDebianHeadersURLFromReleaseNewest(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pool/main/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body><pre>
+<a href="linux-headers-5.10.0-27-amd64_5.10.205-1_amd64.deb">linux-headers-5.10.0-27-amd64_5.10.205-1_amd64.deb</a>
+<a href="linux-headers-5.10.0-27-amd64_5.10.205-2_amd64.deb">linux-headers-5.10.0-27-amd64_5.10.205-2_amd64.deb</a>
+<a href="linux-headers-5.10.0-27-common_5.10.205-1_all.deb">linux-headers-5.10.0-27-common_5.10.205-1_all.deb</a>
+<a href="linux-headers-5.10.0-27-common_5.10.205-2_all.deb">linux-headers-5.10.0-27-common_5.10.205-2_all.deb</a>
+</pre></body></html>`)
+	})
+	mux.HandleFunc("/pool/updates/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body><pre>
+<a href="linux-headers-5.10.0-27-amd64_5.10.205-10_amd64.deb">linux-headers-5.10.0-27-amd64_5.10.205-10_amd64.deb</a>
+<a href="linux-headers-5.10.0-27-common_5.10.205-10_all.deb">linux-headers-5.10.0-27-common_5.10.205-10_all.deb</a>
+<a href="linux-headers-5.10.0-28-amd64_5.10.209-2_amd64.deb">linux-headers-5.10.0-28-amd64_5.10.209-2_amd64.deb</a>
+</pre></body></html>`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	defaultBaseURLs := debianHeadersBaseURLs
+	debianHeadersBaseURLs = []string{server.URL + "/pool/main/", server.URL + "/pool/updates/"}
+	defer func() { debianHeadersBaseURLs = defaultBaseURLs }()
+
+	kr := kernelrelease.FromString("5.10.0-27-amd64")
+	kr.Architecture = "amd64"
+
+	expected := []string{
+		server.URL + "/pool/updates/linux-headers-5.10.0-27-amd64_5.10.205-10_amd64.deb",
+		server.URL + "/pool/updates/linux-headers-5.10.0-27-common_5.10.205-10_all.deb",
+	}
+	gotURLs, err := debianHeadersURLFromRelease(context.Background(), kr)
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if len(gotURLs) != len(expected) || gotURLs[0] != expected[0] || gotURLs[1] != expected[1] {
+		t.Fatalf("Slice values don't match! Got: '%v' / Want: '%v'", gotURLs, expected)
+	}
+}
+
+func TestDebianHeadersURLFromReleaseMirrors(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debian/pool/main/l/linux/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body><pre>
+<a href="linux-headers-5.10.0-27-amd64_5.10.205-2_amd64.deb">linux-headers-5.10.0-27-amd64_5.10.205-2_amd64.deb</a>
+<a href="linux-headers-5.10.0-27-common_5.10.205-2_all.deb">linux-headers-5.10.0-27-common_5.10.205-2_all.deb</a>
+</pre></body></html>`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	kr := kernelrelease.FromString("5.10.0-27-amd64")
+	kr.Architecture = "amd64"
+	ctx := withBuildMirrors(context.Background(), Config{Build: &Build{
+		TargetType:     TargetTypeDebian,
+		Mirrors:        map[string][]string{"debian": {server.URL + "/debian/pool/main/l/linux"}},
+		ReplaceMirrors: true,
+	}})
+
+	expected := []string{
+		server.URL + "/debian/pool/main/l/linux/linux-headers-5.10.0-27-amd64_5.10.205-2_amd64.deb",
+		server.URL + "/debian/pool/main/l/linux/linux-headers-5.10.0-27-common_5.10.205-2_all.deb",
+	}
+	gotURLs, err := debianHeadersURLFromRelease(ctx, kr)
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if len(gotURLs) != len(expected) || gotURLs[0] != expected[0] || gotURLs[1] != expected[1] {
+		t.Fatalf("Slice values don't match! Got: '%v' / Want: '%v'", gotURLs, expected)
+	}
+}
+
+func TestCompareDebianVersions(t *testing.T) {
+	tests := []struct {
+		a        string
+		b        string
+		expected int
+	}{
+		{"6.1.69-1", "6.1.8-1", 1},
+		{"5.10.205-10", "5.10.205-2", 1},
+		{"5.10.205-2", "5.10.205-2", 0},
+		{"6.1.8-1~bpo11+1", "6.1.8-1", -1},
+		{"6.5.10-1~bpo12+1", "6.5.3-1~bpo12+1", 1},
+		{"4.19.304-1", "4.19.304-1+deb10u1", -1},
+		{"6.1.0", "6.1.8-1~bpo11+1", -1},
+	}
+
+	for _, test := range tests {
+		if got := compareDebianVersions(test.a, test.b); got != test.expected {
+			t.Errorf("Test Input: [ '%s', '%s' ] | Got: [ %d ] / Want: [ %d ]", test.a, test.b, got, test.expected)
+		}
+	}
+}
+
+func TestDebianLLVMVersionFromKernelRelease(t *testing.T) {
+	tests := map[string]string{
+		"3.16.0-11-amd64":       "6.0",
+		"4.9.0-19-amd64":        "6.0",
+		"4.19.0-26-amd64":       "7",
+		"5.10.0-27-amd64":       "12",
+		"6.1.0-17-amd64":        "14",
+		"6.5.0-0.deb12.4-amd64": "14",
+	}
+
+	for kernelRelease, expected := range tests {
+		kr := kernelrelease.FromString(kernelRelease)
+		if got := debianLLVMVersionFromKernelRelease(kr); got != expected {
+			t.Errorf("Test Input: [ '%s' ] | Got: [ '%s' ] / Want: [ '%s' ]", kernelRelease, got, expected)
+		}
+	}
+}
+
+func TestLLVMVersionOverride(t *testing.T) {
+	kr := kernelrelease.FromString("6.1.0-17-amd64")
+
+	if got := llvmVersion(Config{Build: &Build{}}, debianLLVMVersionFromKernelRelease(kr)); got != "14" {
+		t.Errorf("Got: [ '%s' ] / Want: [ '14' ]", got)
+	}
+	if got := llvmVersion(Config{Build: &Build{LLVMVersion: "12"}}, debianLLVMVersionFromKernelRelease(kr)); got != "12" {
+		t.Errorf("Got: [ '%s' ] / Want: [ '12' ]", got)
+	}
+}
+
+func TestDebianGCCVersionFromKernelRelease(t *testing.T) {
+	tests := map[string]string{
+		"3.16.0-11-amd64":       "4.8",
+		"4.1.0-1-amd64":         "4.8",
+		"4.2.0-1-amd64":         "8",
+		"4.19.0-26-amd64":       "8",
+		"6.5.0-0.deb12.4-amd64": "8",
+	}
+
+	for kernelRelease, expected := range tests {
+		kr := kernelrelease.FromString(kernelRelease)
+		if got := debianGCCVersionFromKernelRelease(kr); got != expected {
+			t.Errorf("Test Input: [ '%s' ] | Got: [ '%s' ] / Want: [ '%s' ]", kernelRelease, got, expected)
+		}
+	}
+}
+
+func TestGCCVersionOverride(t *testing.T) {
+	kr := kernelrelease.FromString("6.1.0-17-amd64")
+
+	if got := gccVersion(Config{Build: &Build{}}, debianGCCVersionFromKernelRelease(kr)); got != "8" {
+		t.Errorf("Got: [ '%s' ] / Want: [ '8' ]", got)
+	}
+	if got := gccVersion(Config{Build: &Build{GCCVersion: "6"}}, debianGCCVersionFromKernelRelease(kr)); got != "6" {
+		t.Errorf("Got: [ '%s' ] / Want: [ '6' ]", got)
+	}
+}
+
+func TestDebianAptRepositories(t *testing.T) {
+	tests := map[string]struct {
+		kernelrelease string
+		expected      []string
+	}{
+		"bookworm": {
+			kernelrelease: "6.1.0-17-amd64",
+			expected:      []string{"bookworm", "bookworm-updates", "bookworm-security"},
+		},
+		"buster security": {
+			kernelrelease: "4.19.0-26-amd64",
+			expected:      []string{"buster", "buster-updates", "buster/updates"},
+		},
+		"bookworm backports": {
+			kernelrelease: "6.5.0-0.deb12.4-amd64",
+			expected:      []string{"bookworm-backports"},
+		},
+		"bullseye backports": {
+			kernelrelease: "6.1.0-0.deb11.17-amd64",
+			expected:      []string{"bullseye-backports"},
+		},
+		"unknown": {
+			kernelrelease: "5.4.0-1-amd64",
+		},
+	}
+
+	for name, test := range tests {
+		suites := []string{}
+		for _, r := range debianAptRepositories(kernelrelease.FromString(test.kernelrelease)) {
+			suites = append(suites, r.suites...)
+		}
+		if len(suites) != len(test.expected) {
+			t.Fatalf("Slice sizes don't match! Test Input: '%s' | Got: '%v' / Want: '%v'", name, suites, test.expected)
+		}
+		for i, v := range suites {
+			if v != test.expected[i] {
+				t.Errorf("Slice values don't match! Test Input: '%s' | Got: '%v' / Want: '%v'", name, suites, test.expected)
+			}
+		}
+	}
+}
+
+func TestFetchDebianAptKernelPackages(t *testing.T) {
+	server := newTestAptArchives(t)
+
+	tests := map[string]struct {
+		kernelrelease string
+		kernelversion string
+		expected      []string
+	}{
+		"security update": {
+			kernelrelease: "6.1.0-17-amd64",
+			expected: []string{
+				server.URL + "/debian-security/pool/updates/main/l/linux/linux-headers-6.1.0-17-amd64_6.1.69-1_amd64.deb",
+				server.URL + "/debian-security/pool/updates/main/l/linux/linux-headers-6.1.0-17-common_6.1.69-1_all.deb",
+				server.URL + "/debian-security/pool/updates/main/l/linux/linux-kbuild-6.1_6.1.69-1_amd64.deb",
+			},
+		},
+		"security update with the default kernel version": {
+			kernelrelease: "6.1.0-17-amd64",
+			kernelversion: "1",
+			expected: []string{
+				server.URL + "/debian-security/pool/updates/main/l/linux/linux-headers-6.1.0-17-amd64_6.1.69-1_amd64.deb",
+				server.URL + "/debian-security/pool/updates/main/l/linux/linux-headers-6.1.0-17-common_6.1.69-1_all.deb",
+				server.URL + "/debian-security/pool/updates/main/l/linux/linux-kbuild-6.1_6.1.69-1_amd64.deb",
+			},
+		},
+		"point release rt": {
+			kernelrelease: "6.1.0-13-rt-amd64",
+			expected: []string{
+				server.URL + "/debian/pool/main/l/linux/linux-headers-6.1.0-13-rt-amd64_6.1.55-1_amd64.deb",
+				server.URL + "/debian/pool/main/l/linux/linux-headers-6.1.0-13-common-rt_6.1.55-1_all.deb",
+				server.URL + "/debian/pool/main/l/linux/linux-kbuild-6.1_6.1.55-1_amd64.deb",
+			},
+		},
+		"kernel version": {
+			kernelrelease: "6.1.0-13-cloud-amd64",
+			kernelversion: "6.1.69-1",
+			expected: []string{
+				server.URL + "/debian/pool/main/l/linux/linux-headers-6.1.0-13-cloud-amd64_6.1.55-1_amd64.deb",
+				server.URL + "/debian/pool/main/l/linux/linux-headers-6.1.0-13-common_6.1.55-1_all.deb",
+				server.URL + "/debian-security/pool/updates/main/l/linux/linux-kbuild-6.1_6.1.69-1_amd64.deb",
+			},
+		},
+	}
+
+	for name, test := range tests {
+		kr := kernelrelease.FromString(test.kernelrelease)
+		kr.Architecture = "amd64"
+		packages, err := fetchDebianAptKernelPackages(context.Background(), kr, test.kernelversion)
+		if err != nil {
+			t.Errorf("Unexpected error encountered with Test Input: '%s' | Error: '%s'", name, err)
+			continue
+		}
+		if len(packages) != len(test.expected) {
+			t.Fatalf("Slice sizes don't match! Test Input: '%s' | Got: '%v' / Want: '%v'", name, packages, test.expected)
+		}
+		for i, p := range packages {
+			if p.URL != test.expected[i] || len(p.SHA256) == 0 {
+				t.Errorf("Slice values don't match! Test Input: '%s' | Got: '%v' / Want: '%v'", name, packages, test.expected)
+			}
+		}
+	}
+
+	kr := kernelrelease.FromString("6.1.0-99-amd64")
+	kr.Architecture = "amd64"
+	if _, err := fetchDebianAptKernelPackages(context.Background(), kr, ""); err == nil {
+		t.Errorf("Got: [ nil ] / Want: [ kernel headers not found ]")
+	}
+}
+
+func TestDebianScriptMissingKbuild(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	withHTTPClient(t, newRetryClient(context.Background(), time.Second, 1))
+	headers := []string{
+		server.URL + "/a/linux-headers-6.1.0-17-amd64_6.1.69-1_amd64.deb",
+		server.URL + "/b/linux-headers-6.1.0-17-amd64_6.1.69-1_amd64.deb",
+		server.URL + "/a/linux-headers-6.1.0-17-common_6.1.69-1_all.deb",
+	}
+	for _, u := range headers {
+		mux.HandleFunc(strings.TrimPrefix(u, server.URL), func(w http.ResponseWriter, r *http.Request) {})
+	}
+	kbuild := server.URL + "/a/linux-kbuild-6.1_6.1.69-1_amd64.deb"
+
+	kr := kernelrelease.FromString("6.1.0-17-amd64")
+	kr.Architecture = "amd64"
+	want := "missing:\n  kbuild package, tried:\n    " + kbuild + ": 404 Not Found"
+	// the eBPF probe is built through kbuild too, the probe only builds need it as well
+	builds := map[string]*Build{
+		"module": {KernelRelease: "6.1.0-17-amd64", ModuleFilePath: "/tmp/falco.ko", KernelUrls: append(headers, kbuild), SkipChecksum: true},
+		"probe":  {KernelRelease: "6.1.0-17-amd64", ProbeFilePath: "/tmp/falco.o", KernelUrls: append(headers, kbuild), SkipChecksum: true},
+	}
+	for name, b := range builds {
+		_, err := Script(context.Background(), debian{}, Config{Build: b}, kr)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Test Input: '%s' | Got: '%v' / Want: '%s' in it", name, err, want)
+		}
+	}
+}
+
+func TestDebianScriptProbeOnly(t *testing.T) {
+	c := Config{Build: &Build{KernelRelease: "6.1.0-17-amd64", ProbeFilePath: "/tmp/falco.o", SkipChecksum: true, KernelUrls: []string{
+		"file:///tmp/driverkit-kernel/linux-headers-6.1.0-17-amd64_6.1.69-1_amd64.deb",
+		"file:///tmp/driverkit-kernel/linux-headers-6.1.0-17-common_6.1.69-1_all.deb",
+		"file:///tmp/driverkit-kernel/linux-kbuild-6.1_6.1.69-1_amd64.deb",
+	}}}
+	kr := kernelrelease.FromString("6.1.0-17-amd64")
+	kr.Architecture = "amd64"
+	script, err := Script(context.Background(), debian{}, c, kr)
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if !strings.Contains(script, "# Build the eBPF probe") || strings.Contains(script, "# Build the module") {
+		t.Errorf("Got: '%s' / Want: the eBPF probe built, not the module", script)
+	}
+
+	// and the module only builds do not need clang
+	c.ProbeFilePath, c.ModuleFilePath = "", "/tmp/falco.ko"
+	script, err = Script(context.Background(), debian{}, c, kr)
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if strings.Contains(script, "clang") || strings.Contains(script, "llc") {
+		t.Errorf("Got: '%s' / Want: the module built without clang", script)
+	}
+}
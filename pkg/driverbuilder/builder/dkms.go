@@ -0,0 +1,62 @@
+package builder
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+)
+
+//go:embed templates/dkms.sh
+var dkmsTemplate string
+
+// DKMSFileName is the standard file name for the DKMS source package of the kernel module.
+const DKMSFileName = "dkms.tar.gz"
+
+// DKMSFullPath is the standard path for the DKMS source package of the kernel module, the build scripts place it at this location.
+var DKMSFullPath = path.Join(DriverDirectory, "dkms", DKMSFileName)
+
+type dkmsTemplateData struct {
+	DriverBuildDir string
+	DKMSPackageDir string
+	DKMSConf       string
+	DKMSDir        string
+	DKMSFullPath   string
+}
+
+// dkmsConf returns the dkms.conf of the kernel module, built by DKMS against the kernel it is installed for
+// with the kbuild of the module sources, rather than their Makefile building into the driver directory.
+func dkmsConf(c Config) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "PACKAGE_NAME=%q\n", c.DriverName)
+	fmt.Fprintf(&b, "PACKAGE_VERSION=%q\n", ShortDriverVersion(c.DriverVersion))
+	fmt.Fprintf(&b, "BUILT_MODULE_NAME[0]=%q\n", c.DriverName)
+	b.WriteString(`DEST_MODULE_LOCATION[0]="/kernel/extra"` + "\n")
+	b.WriteString(`MAKE[0]="make -C ${kernel_source_dir} M=${dkms_tree}/${PACKAGE_NAME}/${PACKAGE_VERSION}/build modules"` + "\n")
+	b.WriteString(`CLEAN="make -C ${kernel_source_dir} M=${dkms_tree}/${PACKAGE_NAME}/${PACKAGE_VERSION}/build clean"` + "\n")
+	b.WriteString(`AUTOINSTALL="yes"` + "\n")
+	return b.String()
+}
+
+// withDKMS appends the assembly of the DKMS source package of the kernel module to the build script of the target:
+// the sources the script prepared, once configured, with the dkms.conf of the module, laid out as /usr/src/<name>-<version>.
+func withDKMS(script string, c Config) (string, error) {
+	parsed, err := template.New("dkms").Option("missingkey=error").Parse(dkmsTemplate)
+	if err != nil {
+		return "", err
+	}
+	td := dkmsTemplateData{
+		DriverBuildDir: DriverDirectory,
+		DKMSPackageDir: c.DriverName + "-" + ShortDriverVersion(c.DriverVersion),
+		DKMSConf:       dkmsConf(c),
+		DKMSDir:        path.Dir(DKMSFullPath),
+		DKMSFullPath:   DKMSFullPath,
+	}
+	buf := bytes.NewBufferString(script)
+	if err := parsed.Execute(buf, td); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
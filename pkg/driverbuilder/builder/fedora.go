@@ -0,0 +1,190 @@
+package builder
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+//go:embed templates/fedora.sh
+var fedoraTemplate string
+
+// TargetTypeFedora identifies the Fedora target.
+const TargetTypeFedora Type = "fedora"
+
+var fedoraReleasePattern = regexp.MustCompile(`\.fc(\d+)`)
+
+func init() {
+	BuilderByTarget[TargetTypeFedora] = &fedora{}
+}
+
+// fedora is a driverkit target.
+type fedora struct {
+}
+
+// fedoraBaseURLs are the roots of the releases, the current ones are served by the mirrors, EOL ones are moved to the archive.
+var fedoraBaseURLs = []string{
+	"https://dl.fedoraproject.org/pub/fedora/linux",
+	"https://archives.fedoraproject.org/pub/archive/fedora/linux",
+}
+
+// Mirrors implements MirrorsProvider, the packages built by koji are looked for into koji once not found there.
+func (c fedora) Mirrors(arch kernelrelease.Architecture) []string {
+	return fedoraBaseURLs
+}
+
+type fedoraTemplateData struct {
+	buildTemplateData
+	DriverBuildDir    string
+	ModuleDownloadURL string
+	KernelDownloadURL string
+	KernelChecksum    string
+	GCCVersion        string
+	LLVMVersion       string
+	ModuleDriverName  string
+	ModuleFullPath    string
+	BuildModule       bool
+	BuildProbe        bool
+	KernelArch        string
+	CrossCompile      string
+}
+
+// Script compiles the script to build the kernel module and/or the eBPF probe.
+func (c fedora) Script(cfg Config, kr kernelrelease.KernelRelease, sources KernelSources) (string, error) {
+	parsed, err := parseTemplate(cfg, string(TargetTypeFedora), fedoraTemplate, fedoraTemplateData{})
+	if err != nil {
+		return "", err
+	}
+	return executeTemplate(parsed, sources.TemplateData)
+}
+
+// ResolveKernelSources implements Builder, resolving the kernel packages of the build.
+func (c fedora) ResolveKernelSources(ctx context.Context, cfg Config, kr kernelrelease.KernelRelease) (KernelSources, error) {
+	var urls []string
+	var err error
+	if cfg.KernelUrls == nil {
+		var mirrorURLs []string
+		mirrorURLs, err = fetchFedoraKernelURLS(ctx, kr)
+		if err != nil {
+			return KernelSources{}, err
+		}
+		// Check (and filter) existing kernels before continuing
+		urls, err = getResolvingURLs(ctx, mirrorURLs)
+		if err != nil {
+			// the mirrors only keep the GA and the latest update of each package,
+			// every build ever done is still available in koji though
+			Logger(ctx).WithField("kernelrelease", kr.Fullversion+kr.FullExtraversion).Debug("kernel not found on mirrors, falling back to koji")
+			urls, err = getResolvingURLs(ctx, fetchFedoraKojiKernelURLS(kr))
+		}
+	} else {
+		urls, err = getResolvingURLs(ctx, cfg.KernelUrls)
+	}
+	if err != nil {
+		return KernelSources{}, err
+	}
+
+	sums, err := kernelChecksums(ctx, cfg, urls[:1], rpmChecksums)
+	if err != nil {
+		return KernelSources{}, err
+	}
+
+	crossCompilePrefix, err := crossCompile(cfg)
+	if err != nil {
+		return KernelSources{}, err
+	}
+
+	td := fedoraTemplateData{
+		DriverBuildDir:    DriverDirectory,
+		ModuleDownloadURL: moduleDownloadURL(cfg),
+		KernelDownloadURL: urls[0],
+		KernelChecksum:    sums[urls[0]],
+		GCCVersion:        gccVersion(cfg, fedoraGccVersionFromKernelRelease(kr)),
+		LLVMVersion:       llvmVersion(cfg, fedoraLLVMVersionFromKernelRelease(kr)),
+		ModuleDriverName:  cfg.DriverName,
+		ModuleFullPath:    ModuleFullPath,
+		BuildModule:       len(cfg.Build.ModuleFilePath) > 0,
+		BuildProbe:        len(cfg.Build.ProbeFilePath) > 0,
+		KernelArch:        kr.Architecture.ToKernel(),
+		CrossCompile:      crossCompilePrefix,
+	}
+	td.buildTemplateData = newBuildTemplateData(cfg)
+	return KernelSources{URLs: urls[:1], TemplateData: td}, nil
+}
+
+// fedoraReleaseFromKernelRelease extracts the Fedora release number from the extraversion.
+// Example: Input -> "-300.fc38.x86_64", Output -> "38"
+func fedoraReleaseFromKernelRelease(kr kernelrelease.KernelRelease) (string, error) {
+	match := fedoraReleasePattern.FindStringSubmatch(kr.FullExtraversion)
+	if len(match) != 2 {
+		return "", fmt.Errorf("unable to find the fedora release in the kernel release: %s", kr.FullExtraversion)
+	}
+	return match[1], nil
+}
+
+// fedoraPackageRelease returns the rpm release of the kernel package, without the architecture.
+// Example: Input -> "-300.fc38.x86_64", Output -> "300.fc38"
+func fedoraPackageRelease(kr kernelrelease.KernelRelease) string {
+	rel := strings.TrimPrefix(kr.FullExtraversion, "-")
+	return strings.TrimSuffix(rel, "."+kr.Architecture.ToNonDeb())
+}
+
+func fetchFedoraKernelURLS(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
+	release, err := fedoraReleaseFromKernelRelease(kr)
+	if err != nil {
+		return nil, err
+	}
+
+	packageName := fmt.Sprintf("kernel-devel-%s-%s.%s.rpm", kr.Fullversion, fedoraPackageRelease(kr), kr.Architecture.ToNonDeb())
+
+	urls := []string{}
+	for _, b := range mirrorsFrom(ctx, fedoraBaseURLs) {
+		urls = append(urls, fmt.Sprintf(
+			"%s/releases/%s/Everything/%s/os/Packages/k/%s",
+			b,
+			release,
+			kr.Architecture.ToNonDeb(),
+			packageName,
+		))
+		urls = append(urls, fmt.Sprintf(
+			"%s/updates/%s/Everything/%s/Packages/k/%s",
+			b,
+			release,
+			kr.Architecture.ToNonDeb(),
+			packageName,
+		))
+	}
+	return urls, nil
+}
+
+func fetchFedoraKojiKernelURLS(kr kernelrelease.KernelRelease) []string {
+	rel := fedoraPackageRelease(kr)
+	return []string{fmt.Sprintf(
+		"https://kojipkgs.fedoraproject.org/packages/kernel/%s/%s/%s/kernel-devel-%s-%s.%s.rpm",
+		kr.Fullversion,
+		rel,
+		kr.Architecture.ToNonDeb(),
+		kr.Fullversion,
+		rel,
+		kr.Architecture.ToNonDeb(),
+	)}
+}
+
+func fedoraGccVersionFromKernelRelease(kr kernelrelease.KernelRelease) string {
+	switch kr.Version {
+	case 3:
+		return "5"
+	}
+	return "8"
+}
+
+func fedoraLLVMVersionFromKernelRelease(kr kernelrelease.KernelRelease) string {
+	switch kr.Version {
+	case 3, 4:
+		return "7"
+	}
+	return "12"
+}
@@ -1,13 +1,10 @@
 package builder
 
 import (
-	"bytes"
+	"context"
 	_ "embed"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"strings"
-	"text/template"
 
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 )
@@ -27,80 +24,94 @@ type flatcar struct {
 }
 
 // Script compiles the script to build the kernel module and/or the eBPF probe.
-func (c flatcar) Script(cfg Config, kr kernelrelease.KernelRelease) (string, error) {
-	t := template.New(string(TargetTypeFlatcar))
-	parsed, err := t.Parse(flatcarTemplate)
+func (c flatcar) Script(cfg Config, kr kernelrelease.KernelRelease, sources KernelSources) (string, error) {
+	parsed, err := parseTemplate(cfg, string(TargetTypeFlatcar), flatcarTemplate, flatcarTemplateData{})
 	if err != nil {
 		return "", err
 	}
+	return executeTemplate(parsed, sources.TemplateData)
+}
 
+// ResolveKernelSources implements Builder, resolving the kernel packages of the build.
+func (c flatcar) ResolveKernelSources(ctx context.Context, cfg Config, kr kernelrelease.KernelRelease) (KernelSources, error) {
 	if kr.Extraversion != "" {
-		return "", fmt.Errorf("unexpected extraversion: %s", kr.Extraversion)
+		return KernelSources{}, fmt.Errorf("unexpected extraversion: %s", kr.Extraversion)
 	}
 
 	// convert string to int
 	if kr.Version < 1500 {
-		return "", fmt.Errorf("not a valid flatcar release version: %d", kr.Version)
+		return KernelSources{}, fmt.Errorf("not a valid flatcar release version: %d", kr.Version)
 	}
 	flatcarVersion := kr.Fullversion
-	flatcarInfo, err := fetchFlatcarMetadata(kr)
-	if err != nil {
-		return "", err
-	}
-
-	kconfUrls, err := getResolvingURLs(fetchFlatcarKernelConfigURL(kr.Architecture, flatcarInfo.Channel, kr.Fullversion))
+	flatcarInfo, err := fetchFlatcarMetadata(ctx, kr, flatcarChannelsFromKernelVersion(cfg.KernelVersion))
 	if err != nil {
-		return "", err
+		return KernelSources{}, err
 	}
 
 	var urls []string
 	if cfg.KernelUrls == nil {
-		// Check (and filter) existing kernels before continuing
-		urls, err = getResolvingURLs(fetchFlatcarKernelURLS(flatcarInfo.KernelVersion))
+		// Check (and filter) existing developer containers before continuing
+		urls, err = getResolvingURLs(ctx, fetchFlatcarDeveloperContainerURLS(kr.Architecture, flatcarInfo.Channel, flatcarVersion))
 	} else {
-		urls, err = getResolvingURLs(cfg.KernelUrls)
+		urls, err = getResolvingURLs(ctx, cfg.KernelUrls)
 	}
 	if err != nil {
-		return "", err
+		return KernelSources{}, fmt.Errorf("kernel headers not found")
+	}
+
+	crossCompilePrefix, err := crossCompile(cfg)
+	if err != nil {
+		return KernelSources{}, err
 	}
 
 	td := flatcarTemplateData{
 		DriverBuildDir:    DriverDirectory,
 		ModuleDownloadURL: moduleDownloadURL(cfg),
 		KernelDownloadURL: urls[0],
-		GCCVersion:        flatcarGccVersion(flatcarInfo.GCCVersion),
+		KernelRelease:     fmt.Sprintf("%s-flatcar", flatcarInfo.KernelVersion),
+		GCCVersion:        gccVersion(cfg, flatcarGccVersion(flatcarInfo.GCCVersion)),
 		FlatcarVersion:    flatcarVersion,
 		FlatcarChannel:    flatcarInfo.Channel,
-		KernelConfigURL:   kconfUrls[0],
 		ModuleDriverName:  cfg.DriverName,
 		ModuleFullPath:    ModuleFullPath,
 		BuildModule:       len(cfg.Build.ModuleFilePath) > 0,
 		BuildProbe:        len(cfg.Build.ProbeFilePath) > 0,
+		KernelArch:        kr.Architecture.ToKernel(),
+		LLVMVersion:       llvmVersion(cfg, "12"),
+		CrossCompile:      crossCompilePrefix,
 	}
+	td.buildTemplateData = newBuildTemplateData(cfg)
+	return KernelSources{URLs: urls[:1], TemplateData: td}, nil
+}
 
-	buf := bytes.NewBuffer(nil)
-	err = parsed.Execute(buf, td)
-	if err != nil {
-		return "", err
+// flatcarChannels are the Flatcar release channels, in lookup order.
+var flatcarChannels = []string{
+	"stable",
+	"beta",
+	"alpha",
+}
+
+// flatcarChannelsFromKernelVersion restricts the lookup to the channel given as kernel version, if any,
+// otherwise every channel is tried.
+func flatcarChannelsFromKernelVersion(kernelVersion string) []string {
+	for _, channel := range flatcarChannels {
+		if kernelVersion == channel {
+			return []string{channel}
+		}
 	}
-	return buf.String(), nil
+	return flatcarChannels
 }
 
-func fetchFlatcarMetadata(kr kernelrelease.KernelRelease) (*flatcarReleaseInfo, error) {
+func fetchFlatcarMetadata(ctx context.Context, kr kernelrelease.KernelRelease, channels []string) (*flatcarReleaseInfo, error) {
 	flatcarInfo := flatcarReleaseInfo{}
 	flatcarVersion := kr.Fullversion
-	packageIndexUrl, err := getResolvingURLs(fetchFlatcarPackageListURL(kr.Architecture, flatcarVersion))
+	packageIndexUrl, err := getResolvingURLs(ctx, fetchFlatcarPackageListURL(kr.Architecture, channels, flatcarVersion))
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("kernel headers not found")
 	}
 	// first part of the URL is the channel
 	flatcarInfo.Channel = strings.Split(packageIndexUrl[0], ".")[0][len("https://"):]
-	resp, err := http.Get(packageIndexUrl[0])
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	packageListBytes, err := ioutil.ReadAll(resp.Body)
+	packageListBytes, err := getIndex(ctx, packageIndexUrl[0])
 	if err != nil {
 		return nil, err
 	}
@@ -130,13 +141,8 @@ func fetchFlatcarMetadata(kr kernelrelease.KernelRelease) (*flatcarReleaseInfo,
 	return &flatcarInfo, nil
 }
 
-func fetchFlatcarPackageListURL(architecture kernelrelease.Architecture, flatcarVersion string) []string {
+func fetchFlatcarPackageListURL(architecture kernelrelease.Architecture, channels []string, flatcarVersion string) []string {
 	pattern := "https://%s.release.flatcar-linux.net/%s-usr/%s/flatcar_production_image_packages.txt"
-	channels := []string{
-		"stable",
-		"beta",
-		"alpha",
-	}
 	urls := []string{}
 	for _, channel := range channels {
 		urls = append(urls, fmt.Sprintf(pattern, channel, architecture.String(), flatcarVersion))
@@ -144,13 +150,10 @@ func fetchFlatcarPackageListURL(architecture kernelrelease.Architecture, flatcar
 	return urls
 }
 
-func fetchFlatcarKernelConfigURL(architecture kernelrelease.Architecture, flatcarChannel, flatcarVersion string) []string {
-	return []string{fmt.Sprintf("https://%s.release.flatcar-linux.net/%s-usr/%s/flatcar_production_image_kernel_config.txt", flatcarChannel, architecture.String(), flatcarVersion)}
-}
-
-func fetchFlatcarKernelURLS(kernelVersion string) []string {
-	kv := kernelrelease.FromString(kernelVersion)
-	return []string{fmt.Sprintf("https://cdn.kernel.org/pub/linux/kernel/v%d.x/linux-%s.tar.xz", kv.Version, kv.Fullversion)}
+// fetchFlatcarDeveloperContainerURLS returns the developer container image of the release,
+// it ships the kernel build tree under /usr/lib/modules.
+func fetchFlatcarDeveloperContainerURLS(architecture kernelrelease.Architecture, flatcarChannel, flatcarVersion string) []string {
+	return []string{fmt.Sprintf("https://%s.release.flatcar-linux.net/%s-usr/%s/flatcar_developer_container.bin.bz2", flatcarChannel, architecture.String(), flatcarVersion)}
 }
 
 type flatcarReleaseInfo struct {
@@ -160,17 +163,21 @@ type flatcarReleaseInfo struct {
 }
 
 type flatcarTemplateData struct {
+	buildTemplateData
 	DriverBuildDir    string
 	ModuleDownloadURL string
 	KernelDownloadURL string
+	KernelRelease     string
 	GCCVersion        string
 	FlatcarVersion    string
 	FlatcarChannel    string
-	KernelConfigURL   string
 	ModuleDriverName  string
 	ModuleFullPath    string
 	BuildModule       bool
 	BuildProbe        bool
+	KernelArch        string
+	LLVMVersion       string
+	CrossCompile      string
 }
 
 func flatcarGccVersion(gccVersion string) string {
@@ -0,0 +1,145 @@
+package builder
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"strconv"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+//go:embed templates/gentoo.sh
+var gentooTemplate string
+
+// TargetTypeGentoo identifies the Gentoo target.
+const TargetTypeGentoo Type = "gentoo"
+
+const gentooGenpatchesURL = "https://dev.gentoo.org/~mpagano/genpatches/tarballs"
+
+func init() {
+	BuilderByTarget[TargetTypeGentoo] = &gentoo{}
+}
+
+// gentoo is a driverkit target.
+type gentoo struct {
+}
+
+// Metadata implements MetadataProvider, the kernel is configured with the kernel config data
+// and patched with the genpatches of the revision given as the kernel version.
+func (g gentoo) Metadata() Metadata {
+	m := DefaultMetadata()
+	m.RequiresKernelVersion = true
+	m.RequiresKernelConfigData = true
+	return m
+}
+
+// Validate implements Validator, the genpatches revision cannot be the default kernel version.
+func (g gentoo) Validate(c Config, kr kernelrelease.KernelRelease) error {
+	if c.KernelVersion == "1" {
+		return fmt.Errorf("target gentoo requires the genpatches revision of the gentoo-sources as the kernel version, e.g. 76")
+	}
+	return nil
+}
+
+type gentooTemplateData struct {
+	buildTemplateData
+	DriverBuildDir     string
+	ModuleDownloadURL  string
+	KernelDownloadURL  string
+	GenpatchesURLs     []string
+	KernelLocalVersion string
+	ModuleDriverName   string
+	ModuleFullPath     string
+	BuildModule        bool
+	BuildProbe         bool
+	KernelArch         string
+	LLVMVersion        string
+	GCCVersion         string
+	CrossCompile       string
+}
+
+// Script compiles the script to build the kernel module and/or the eBPF probe.
+//
+// Like vanilla, it requires the kernel config data.
+// The genpatches revision of the gentoo-sources (e.g. 76) is expected in the kernel version.
+func (g gentoo) Script(c Config, kv kernelrelease.KernelRelease, sources KernelSources) (string, error) {
+	parsed, err := parseTemplate(c, string(TargetTypeGentoo), gentooTemplate, gentooTemplateData{})
+	if err != nil {
+		return "", err
+	}
+	return executeTemplate(parsed, sources.TemplateData)
+}
+
+// ResolveKernelSources implements Builder, resolving the kernel packages of the build.
+func (g gentoo) ResolveKernelSources(ctx context.Context, c Config, kv kernelrelease.KernelRelease) (KernelSources, error) {
+	if !c.HasKernelConfigData() {
+		return KernelSources{}, fmt.Errorf("kernel config data is required when target is gentoo")
+	}
+
+	var urls []string
+	var err error
+	if c.KernelUrls == nil {
+		// Check (and filter) existing kernels before continuing
+		urls, err = getResolvingURLs(ctx, []string{fetchVanillaKernelURLFromKernelVersion(kv)})
+	} else {
+		urls, err = getResolvingURLs(ctx, c.KernelUrls)
+	}
+	if err != nil {
+		return KernelSources{}, err
+	}
+
+	genpatches, err := fetchGentooGenpatchesURLs(ctx, kv, c.KernelVersion)
+	if err != nil {
+		return KernelSources{}, err
+	}
+
+	crossCompilePrefix, err := crossCompile(c)
+	if err != nil {
+		return KernelSources{}, err
+	}
+
+	td := gentooTemplateData{
+		DriverBuildDir:     DriverDirectory,
+		ModuleDownloadURL:  moduleDownloadURL(c),
+		KernelDownloadURL:  urls[0],
+		GenpatchesURLs:     genpatches,
+		KernelLocalVersion: kv.FullExtraversion,
+		ModuleDriverName:   c.DriverName,
+		ModuleFullPath:     ModuleFullPath,
+		BuildModule:        len(c.Build.ModuleFilePath) > 0,
+		BuildProbe:         len(c.Build.ProbeFilePath) > 0,
+		KernelArch:         kv.Architecture.ToKernel(),
+		LLVMVersion:        llvmVersion(c, "12"),
+		GCCVersion:         gccVersion(c, vanillaGCCVersionFromKernelRelease(kv)),
+		CrossCompile:       crossCompilePrefix,
+	}
+	td.buildTemplateData = newBuildTemplateData(c)
+	return KernelSources{URLs: append([]string{urls[0]}, genpatches...), TemplateData: td}, nil
+}
+
+// fetchGentooGenpatchesURLs returns the base and extras genpatches tarballs of the revision.
+// Example: Input -> "6.1.67-gentoo", "76", Output -> [".../genpatches-6.1-76.base.tar.xz", ".../genpatches-6.1-76.extras.tar.xz"]
+func fetchGentooGenpatchesURLs(ctx context.Context, kv kernelrelease.KernelRelease, revision string) ([]string, error) {
+	if _, err := strconv.Atoi(revision); err != nil {
+		return nil, fmt.Errorf("not a valid genpatches revision: %s", revision)
+	}
+
+	urls := []string{}
+	for _, kind := range []string{"base", "extras"} {
+		urls = append(urls, fmt.Sprintf(
+			"%s/genpatches-%d.%d-%s.%s.tar.xz",
+			gentooGenpatchesURL,
+			kv.Version,
+			kv.PatchLevel,
+			revision,
+			kind,
+		))
+	}
+
+	resolved, err := getResolvingURLs(ctx, urls)
+	if err != nil || len(resolved) != len(urls) {
+		return nil, fmt.Errorf("genpatches %d.%d-%s not found", kv.Version, kv.PatchLevel, revision)
+	}
+	return resolved, nil
+}
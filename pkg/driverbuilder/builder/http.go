@@ -0,0 +1,266 @@
+package builder
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// DefaultHTTPTimeout is the timeout of the requests issued to resolve the kernel URLs and fetch the mirror indexes.
+const DefaultHTTPTimeout = 60 * time.Second
+
+// DefaultHTTPRetries is the number of attempts made for each request.
+const DefaultHTTPRetries = 3
+
+// DefaultHTTPConcurrency is the number of candidate URLs checked at the same time.
+const DefaultHTTPConcurrency = 8
+
+// KernelURLsAuthDirectory is where the processors copy the curl config authenticating the downloads of the custom kernel URLs into the builder,
+// the credentials are never written into the build script.
+const KernelURLsAuthDirectory = "/tmp/driverkit-kernelurls-auth"
+
+// KernelURLsCurlConfigPath is the curl config of the credentials of the kernel URLs into the builder.
+var KernelURLsCurlConfigPath = path.Join(KernelURLsAuthDirectory, "curlrc")
+
+// defaultHTTPBackoff is the time waited after the first failed attempt, it doubles at every attempt.
+const defaultHTTPBackoff = 500 * time.Millisecond
+
+// retryClient is an HTTP client retrying requests on network errors and server errors.
+type retryClient struct {
+	ctx         context.Context
+	client      *http.Client
+	attempts    int
+	backoff     time.Duration
+	concurrency int
+	auth        *kernelURLsAuth
+	// indexes caches the index pages fetched by the client, the default cache when nil.
+	indexes *indexCache
+}
+
+// httpClient is the client of the builds not given one by WithHTTPClient.
+var httpClient = newRetryClient(context.Background(), DefaultHTTPTimeout, DefaultHTTPRetries)
+
+func newRetryClient(ctx context.Context, timeout time.Duration, attempts int) *retryClient {
+	if timeout <= 0 {
+		timeout = DefaultHTTPTimeout
+	}
+	if attempts <= 0 {
+		attempts = DefaultHTTPRetries
+	}
+	return &retryClient{
+		ctx:         ctx,
+		client:      &http.Client{Timeout: timeout},
+		attempts:    attempts,
+		backoff:     defaultHTTPBackoff,
+		concurrency: DefaultHTTPConcurrency,
+	}
+}
+
+type httpClientKey struct{}
+
+// WithHTTPClient returns a context the builders issue the requests of the build from with an HTTP client of its own,
+// set up from its config: the proxy, the CA bundle, the timeout and retries, the credentials of the kernel URLs and the cache directory.
+// Processors must generate the build script within it, the builds running concurrently do not share their settings.
+func WithHTTPClient(ctx context.Context, c Config) (context.Context, error) {
+	transport, err := newHTTPTransport(c.ProxyURL, c.CABundle)
+	if err != nil {
+		return ctx, err
+	}
+	client := newRetryClient(ctx, c.HTTPTimeout, c.HTTPRetries)
+	client.client.Transport = transport
+	if c.HTTPConcurrency > 0 {
+		client.concurrency = c.HTTPConcurrency
+	}
+	client.auth = newKernelURLsAuth(c.Build)
+	if c.Build != nil {
+		client.indexes = persistedIndexCache(c.CacheDir)
+	}
+	return context.WithValue(ctx, httpClientKey{}, client), nil
+}
+
+// httpClientFrom returns the client of the build of the context, the default one otherwise, stopping its requests when the context is done.
+func httpClientFrom(ctx context.Context) *retryClient {
+	client, ok := ctx.Value(httpClientKey{}).(*retryClient)
+	if !ok {
+		client = httpClient
+	}
+	return client.withContext(ctx)
+}
+
+// withContext returns a copy of the client whose requests are stopped when the given context is canceled.
+func (r *retryClient) withContext(ctx context.Context) *retryClient {
+	c := *r
+	c.ctx = ctx
+	return &c
+}
+
+// withClientCertificate returns a copy of the client authenticating with the certificate, e.g. to the Red Hat CDN.
+func (r *retryClient) withClientCertificate(cert tls.Certificate) *retryClient {
+	transport, ok := r.client.Transport.(*http.Transport)
+	if !ok || transport == nil {
+		transport = http.DefaultTransport.(*http.Transport)
+	}
+	transport = transport.Clone()
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	client := *r.client
+	client.Transport = transport
+	c := *r
+	c.client = &client
+	return &c
+}
+
+// kernelURLsAuth authenticates the requests to the hosts of the custom kernel URLs of a build, the requests to the other hosts are left untouched.
+type kernelURLsAuth struct {
+	hosts    map[string]bool
+	user     string
+	password string
+	token    string
+}
+
+// hasKernelURLsAuth tells whether the build authenticates the downloads of its custom kernel URLs.
+func hasKernelURLsAuth(b *Build) bool {
+	return b != nil && len(b.KernelUrls) > 0 && (len(b.KernelURLsAuth) > 0 || len(b.KernelURLsToken) > 0)
+}
+
+func newKernelURLsAuth(b *Build) *kernelURLsAuth {
+	if !hasKernelURLsAuth(b) {
+		return nil
+	}
+	a := &kernelURLsAuth{hosts: map[string]bool{}, token: b.KernelURLsToken}
+	a.user, a.password = splitKernelURLsAuth(b.KernelURLsAuth)
+	for _, u := range b.KernelUrls {
+		if uu, err := url.Parse(u); err == nil && len(uu.Host) > 0 {
+			a.hosts[uu.Scheme+"://"+uu.Host] = true
+		}
+	}
+	return a
+}
+
+// splitKernelURLsAuth splits user:password, the password being everything after the first colon.
+func splitKernelURLsAuth(auth string) (string, string) {
+	i := strings.Index(auth, ":")
+	if i < 0 {
+		return auth, ""
+	}
+	return auth[:i], auth[i+1:]
+}
+
+// authorize sets the credentials of the request when it goes to the host of one of the kernel URLs.
+func (a *kernelURLsAuth) authorize(req *http.Request) {
+	if a == nil || !a.hosts[req.URL.Scheme+"://"+req.URL.Host] {
+		return
+	}
+	if len(a.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+		return
+	}
+	req.SetBasicAuth(a.user, a.password)
+}
+
+// KernelURLsCurlConfig returns the curl config authenticating the downloads of the custom kernel URLs of the build, nil when there are none to authenticate.
+// curl sends the credentials to the hosts of the URLs only, not to the ones they redirect to.
+func KernelURLsCurlConfig(b *Build) []byte {
+	if !hasKernelURLsAuth(b) {
+		return nil
+	}
+	if len(b.KernelURLsToken) > 0 {
+		return []byte("header = " + curlConfigQuote("Authorization: Bearer "+b.KernelURLsToken) + "\n")
+	}
+	return []byte("user = " + curlConfigQuote(b.KernelURLsAuth) + "\n")
+}
+
+// curlConfigQuote quotes the value for a curl config file.
+func curlConfigQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// newHTTPTransport returns a transport going through the proxy, when given, otherwise honoring the HTTP(S)_PROXY variables.
+// The certificates of the PEM encoded CA bundle, when given, are trusted in addition to the system ones.
+func newHTTPTransport(proxyURL string, caBundle []byte) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if len(proxyURL) > 0 {
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy url: %s", err)
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+
+	if len(caBundle) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("no certificates found in the CA bundle")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return transport, nil
+}
+
+// Do sends the request, retrying with an exponential backoff when it fails with a network error or a 5xx status.
+// The response of the last attempt is returned.
+func (r *retryClient) Do(req *http.Request) (*http.Response, error) {
+	req = req.WithContext(r.ctx)
+	r.auth.authorize(req)
+	backoff := r.backoff
+	for attempt := 1; ; attempt++ {
+		res, err := r.client.Do(req)
+		if err == nil && res.StatusCode < http.StatusInternalServerError {
+			return res, nil
+		}
+		if attempt >= r.attempts || isPermanentError(err) {
+			return res, err
+		}
+
+		if err == nil {
+			res.Body.Close()
+			err = fmt.Errorf("%s", res.Status)
+		}
+		Logger(r.ctx).WithError(err).WithField("url", req.URL.Redacted()).WithField("attempt", attempt).Debug("request failed, retrying")
+		select {
+		case <-r.ctx.Done():
+			return nil, r.ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// isPermanentError tells whether retrying the request is pointless, e.g. the host does not exist.
+func isPermanentError(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.Is(err, context.Canceled) || (errors.As(err, &dnsErr) && dnsErr.IsNotFound)
+}
+
+// Get issues a GET to the specified URL.
+func (r *retryClient) Get(u string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	return r.Do(req)
+}
+
+// Head issues a HEAD to the specified URL.
+func (r *retryClient) Head(u string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodHead, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	return r.Do(req)
+}
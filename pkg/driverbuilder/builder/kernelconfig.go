@@ -0,0 +1,69 @@
+package builder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// kernelConfigSetPattern and kernelConfigUnsetPattern match the lines of a kernel config, e.g. CONFIG_FTRACE_SYSCALLS=y
+	// and # CONFIG_FTRACE_SYSCALLS is not set.
+	kernelConfigSetPattern   = regexp.MustCompile(`^(CONFIG_[A-Za-z0-9_]+)=(.*)$`)
+	kernelConfigUnsetPattern = regexp.MustCompile(`^# (CONFIG_[A-Za-z0-9_]+) is not set$`)
+)
+
+// kernelConfigFragments returns the content of the kernel config fragments, given inline, e.g. CONFIG_FTRACE_SYSCALLS=y,
+// or as the path of their file.
+// It fails when a fragment is not a kernel config or when the fragments set a symbol to different values, listing them.
+func kernelConfigFragments(fragments []string) ([]string, error) {
+	contents := make([]string, 0, len(fragments))
+	values := map[string]map[string]bool{}
+	for _, f := range fragments {
+		content := f
+		if !isInlineKernelConfig(f) {
+			data, err := ioutil.ReadFile(f)
+			if err != nil {
+				return nil, fmt.Errorf("invalid kernel config fragment %s, it must be a file or CONFIG_<symbol>=<value>: %s", f, err)
+			}
+			content = string(data)
+		}
+		for _, line := range strings.Split(content, "\n") {
+			line = strings.TrimSpace(line)
+			symbol, value := "", ""
+			if match := kernelConfigSetPattern.FindStringSubmatch(line); match != nil {
+				symbol, value = match[1], match[2]
+			} else if match := kernelConfigUnsetPattern.FindStringSubmatch(line); match != nil {
+				symbol, value = match[1], "n"
+			} else if len(line) == 0 || strings.HasPrefix(line, "#") {
+				continue
+			} else {
+				return nil, fmt.Errorf("invalid line of the kernel config fragment %s: %s", f, line)
+			}
+			if values[symbol] == nil {
+				values[symbol] = map[string]bool{}
+			}
+			values[symbol][value] = true
+		}
+		contents = append(contents, strings.TrimRight(content, "\n"))
+	}
+
+	conflicts := []string{}
+	for symbol, v := range values {
+		if len(v) > 1 {
+			conflicts = append(conflicts, symbol)
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return nil, fmt.Errorf("conflicting kernel config fragments, they set different values to: %s", strings.Join(conflicts, ", "))
+	}
+	return contents, nil
+}
+
+func isInlineKernelConfig(fragment string) bool {
+	line := strings.TrimSpace(strings.SplitN(fragment, "\n", 2)[0])
+	return kernelConfigSetPattern.MatchString(line) || kernelConfigUnsetPattern.MatchString(line)
+}
@@ -0,0 +1,69 @@
+package builder
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKernelConfigFragments(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "ftrace.config")
+	if err := ioutil.WriteFile(file, []byte("# tracing\nCONFIG_FTRACE=y\nCONFIG_FTRACE_SYSCALLS=y\n# CONFIG_DEBUG_INFO is not set\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conflicting := filepath.Join(dir, "conflicting.config")
+	if err := ioutil.WriteFile(conflicting, []byte("CONFIG_FTRACE=n\nCONFIG_DEBUG_INFO=y\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		fragments []string
+		expected  []string
+		err       string
+	}{
+		"inline": {
+			fragments: []string{"CONFIG_FTRACE_SYSCALLS=y", "# CONFIG_DEBUG_INFO is not set"},
+			expected:  []string{"CONFIG_FTRACE_SYSCALLS=y", "# CONFIG_DEBUG_INFO is not set"},
+		},
+		"file": {
+			fragments: []string{file, "CONFIG_FTRACE=y"},
+			expected:  []string{"# tracing\nCONFIG_FTRACE=y\nCONFIG_FTRACE_SYSCALLS=y\n# CONFIG_DEBUG_INFO is not set", "CONFIG_FTRACE=y"},
+		},
+		"conflicting": {
+			fragments: []string{file, conflicting},
+			err:       "conflicting kernel config fragments, they set different values to: CONFIG_DEBUG_INFO, CONFIG_FTRACE",
+		},
+		"missing file": {
+			fragments: []string{filepath.Join(dir, "missing.config")},
+			err:       "invalid kernel config fragment",
+		},
+		"invalid line": {
+			fragments: []string{"CONFIG_FTRACE=y\nFTRACE_SYSCALLS=y"},
+			err:       "invalid line of the kernel config fragment CONFIG_FTRACE=y\nFTRACE_SYSCALLS=y: FTRACE_SYSCALLS=y",
+		},
+	}
+
+	for name, test := range tests {
+		got, err := kernelConfigFragments(test.fragments)
+		if len(test.err) > 0 {
+			if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+				t.Errorf("Test Input: '%s' | Got: '%v' / Want: '%s'", name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error encountered with Test Input: '%s' | Error: '%s'", name, err)
+			continue
+		}
+		if len(got) != len(test.expected) {
+			t.Fatalf("Slice sizes don't match! Test Input: '%s' | Got: '%v' / Want: '%v'", name, got, test.expected)
+		}
+		for i, v := range got {
+			if v != test.expected[i] {
+				t.Errorf("Slice values don't match! Test Input: '%s' | Got: '%v' / Want: '%v'", name, got, test.expected)
+			}
+		}
+	}
+}
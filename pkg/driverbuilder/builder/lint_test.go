@@ -0,0 +1,283 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// lintKernel is a kernel of a target the build scripts are rendered for, with the packages its builder resolves.
+type lintKernel struct {
+	kernelrelease string
+	kernelversion string
+	urls          []string
+	// optional are the required fields of the template data the builder leaves empty for the kernel.
+	optional []string
+}
+
+// lintKernels has a kernel for every target, the targets added without one fail the lint.
+var lintKernels = map[Type]lintKernel{
+	TargetTypeAlmaLinux:       {kernelrelease: "4.18.0-513.9.1.el8_9.x86_64", urls: []string{"https://repo.almalinux.org/almalinux/8/BaseOS/x86_64/os/Packages/kernel-devel-4.18.0-513.9.1.el8_9.x86_64.rpm"}},
+	TargetTypeAlpine:          {kernelrelease: "6.1.69-0-lts", urls: []string{"https://dl-cdn.alpinelinux.org/alpine/v3.18/main/x86_64/linux-lts-dev-6.1.69-r0.apk"}},
+	TargetTypeAmazonLinux2023: {kernelrelease: "6.1.66-91.160.amzn2023.x86_64", urls: []string{"https://cdn.amazonlinux.com/al2023/core/guids/1/x86_64/kernel-devel-6.1.66-91.160.amzn2023.x86_64.rpm"}},
+	TargetTypeAmazonLinux2022: {kernelrelease: "5.15.73-45.135.amzn2022.x86_64", urls: []string{"https://cdn.amazonlinux.com/al2022/core/guids/1/x86_64/kernel-devel-5.15.73-45.135.amzn2022.x86_64.rpm"}},
+	TargetTypeAmazonLinux2:    {kernelrelease: "5.10.192-183.736.amzn2.x86_64", urls: []string{"https://amazonlinux.us-east-1.amazonaws.com/blobstore/1/kernel-devel-5.10.192-183.736.amzn2.x86_64.rpm"}},
+	TargetTypeAmazonLinux:     {kernelrelease: "4.14.322-170.535.amzn1.x86_64", urls: []string{"http://packages.us-east-1.amazonaws.com/2018.03/updates/1/x86_64/Packages/kernel-devel-4.14.322-170.535.amzn1.x86_64.rpm"}},
+	TargetTypeArch:            {kernelrelease: "6.6.8-arch1-1", urls: []string{"https://archive.archlinux.org/packages/l/linux-headers/linux-headers-6.6.8.arch1-1-x86_64.pkg.tar.zst"}},
+	TargetTypeArchlinux:       {kernelrelease: "6.6.8-arch1-1", urls: []string{"https://archive.archlinux.org/packages/l/linux-headers/linux-headers-6.6.8.arch1-1-x86_64.pkg.tar.zst"}},
+	TargetTypeBottlerocket:    {kernelrelease: "1.15.1", kernelversion: "aws-k8s-1.28", urls: []string{"https://updates.bottlerocket.aws/targets/x86_64-aws-k8s-1.28-kmod-kit-v1.15.1.tar.xz"}},
+	TargetTypeCentos:          {kernelrelease: "4.18.0-513.9.1.el8_9.x86_64", urls: []string{"https://vault.centos.org/8-stream/BaseOS/x86_64/os/Packages/kernel-devel-4.18.0-513.9.1.el8_9.x86_64.rpm"}},
+	TargetTypeCos:             {kernelrelease: "5.15.120+", kernelversion: "16108.403.47", urls: []string{"https://storage.googleapis.com/cos-tools/16108.403.47/kernel-headers.tgz"}},
+	TargetTypeDebian: {kernelrelease: "6.1.0-17-amd64", kernelversion: "1", urls: []string{
+		"http://deb.debian.org/debian-security/pool/updates/main/l/linux/linux-headers-6.1.0-17-amd64_6.1.69-1_amd64.deb",
+		"http://deb.debian.org/debian-security/pool/updates/main/l/linux/linux-headers-6.1.0-17-common_6.1.69-1_all.deb",
+		"http://deb.debian.org/debian-security/pool/updates/main/l/linux/linux-kbuild-6.1_6.1.69-1_amd64.deb",
+	}},
+	TargetTypeFedora:      {kernelrelease: "6.6.8-200.fc39.x86_64", urls: []string{"https://dl.fedoraproject.org/pub/fedora/linux/updates/39/Everything/x86_64/Packages/k/kernel-devel-6.6.8-200.fc39.x86_64.rpm"}},
+	TargetTypeFlatcar:     {kernelrelease: "3602.2.3", kernelversion: "stable", urls: []string{"https://stable.release.flatcar-linux.net/amd64-usr/3602.2.3/flatcar_developer_container.bin.bz2"}},
+	TargetTypeGentoo:      {kernelrelease: "6.1.67-gentoo", kernelversion: "73", urls: []string{"https://cdn.kernel.org/pub/linux/kernel/v6.x/linux-6.1.67.tar.xz"}},
+	TargetTypeMariner:     {kernelrelease: "5.15.138.1-4.cm2", urls: []string{"https://packages.microsoft.com/cbl-mariner/2.0/prod/base/x86_64/Packages/k/kernel-devel-5.15.138.1-4.cm2.x86_64.rpm"}},
+	TargetTypeOpenEuler:   {kernelrelease: "5.10.0-60.18.0.50.oe2203.x86_64", urls: []string{"https://repo.openeuler.org/openEuler-22.03-LTS/everything/x86_64/Packages/kernel-devel-5.10.0-60.18.0.50.oe2203.x86_64.rpm"}},
+	TargetTypeOracleLinux: {kernelrelease: "5.15.0-200.131.27.el9uek.x86_64", urls: []string{"https://yum.oracle.com/repo/OracleLinux/OL9/UEKR7/x86_64/getPackage/kernel-uek-devel-5.15.0-200.131.27.el9uek.x86_64.rpm"}},
+	TargetTypePhoton:      {kernelrelease: "5.10.201-1.ph4", urls: []string{"https://packages.vmware.com/photon/4.0/photon_updates_4.0_x86_64/x86_64/linux-devel-5.10.201-1.ph4.x86_64.rpm"}},
+	TargetTypePhotonOS:    {kernelrelease: "5.10.201-1.ph4", urls: []string{"https://packages.vmware.com/photon/4.0/photon_updates_4.0_x86_64/x86_64/linux-devel-5.10.201-1.ph4.x86_64.rpm"}},
+	TargetTypeRaspios:     {kernelrelease: "6.1.21-v8+", kernelversion: "1", urls: []string{"http://archive.raspberrypi.org/debian/pool/main/r/raspberrypi-firmware/raspberrypi-kernel-headers_1.20230405-1_arm64.deb"}},
+	// the kernel package is downloaded by the script from the entitled repositories
+	TargetTypeRedhat: {kernelrelease: "4.18.0-513.9.1.el8_9.x86_64", optional: []string{"KernelDownloadURL"}},
+	TargetTypeRocky:  {kernelrelease: "4.18.0-513.9.1.el8_9.x86_64", urls: []string{"https://download.rockylinux.org/pub/rocky/8/BaseOS/x86_64/os/Packages/k/kernel-devel-4.18.0-513.9.1.el8_9.x86_64.rpm"}},
+	TargetTypeSuse: {kernelrelease: "5.14.21-150500.55.39-default", urls: []string{
+		"https://download.opensuse.org/update/leap/15.5/sle/noarch/kernel-devel-5.14.21-150500.55.39.1.noarch.rpm",
+		"https://download.opensuse.org/update/leap/15.5/sle/x86_64/kernel-default-devel-5.14.21-150500.55.39.1.x86_64.rpm",
+	}},
+	TargetTypeTalos: {kernelrelease: "6.1.58-talos", kernelversion: "v1.5.5", urls: []string{"https://cdn.kernel.org/pub/linux/kernel/v6.x/linux-6.1.58.tar.xz"}},
+	TargetTypeUbuntu: {kernelrelease: "5.15.0-91-generic", kernelversion: "101", urls: []string{
+		"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.15.0-91-generic_5.15.0-91.101_amd64.deb",
+		"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.15.0-91_5.15.0-91.101_all.deb",
+	}},
+	TargetTypeUbuntuGeneric: {kernelrelease: "5.15.0-91-generic", kernelversion: "101", urls: []string{
+		"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.15.0-91-generic_5.15.0-91.101_amd64.deb",
+		"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.15.0-91_5.15.0-91.101_all.deb",
+	}},
+	TargetTypeUbuntuAWS: {kernelrelease: "6.2.0-1017-aws", kernelversion: "17~22.04.1", urls: []string{
+		"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-aws-6.2/linux-headers-6.2.0-1017-aws_6.2.0-1017.17~22.04.1_amd64.deb",
+		"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-aws-6.2/linux-aws-6.2-headers-6.2.0-1017_6.2.0-1017.17~22.04.1_all.deb",
+	}},
+	TargetTypeVanilla: {kernelrelease: "6.1.69", urls: []string{"https://cdn.kernel.org/pub/linux/kernel/v6.x/linux-6.1.69.tar.xz"}},
+}
+
+// lintBuilds are the builds the scripts of every target are rendered for, from the build of both the kernel module and the eBPF probe.
+var lintBuilds = map[string]func(c *Config, m Metadata) bool{
+	"module and probe": func(c *Config, m Metadata) bool { return true },
+	"module only": func(c *Config, m Metadata) bool {
+		c.ProbeFilePath = ""
+		return m.Module
+	},
+	"probe only": func(c *Config, m Metadata) bool {
+		c.ModuleFilePath = ""
+		return m.Probe
+	},
+	"tuned": func(c *Config, m Metadata) bool {
+		c.Env = map[string]string{"KCFLAGS": "-Wno-error", "EXTRA_PATH": "/opt/tools bin"}
+		c.MakeFlags = "-j8 V=1"
+		c.CcacheDir = "/var/cache/driverkit"
+		c.DriverUseGit = true
+		c.KernelURLsToken = "token"
+		c.KernelConfigFragments = []string{"CONFIG_FTRACE_SYSCALLS=y"}
+		c.VerifyKernelSignature = true
+		return true
+	},
+	"modern probe and dkms": func(c *Config, m Metadata) bool {
+		c.ModernProbeFilePath = "/tmp/bpf_probe.skel.h"
+		c.DKMSFilePath = "/tmp/falco-dkms.tar.gz"
+		return true
+	},
+	"arm64 cross compiled": func(c *Config, m Metadata) bool {
+		c.Architecture = "arm64"
+		c.BuilderArchitecture = "amd64"
+		return m.CrossCompile && containsString(m.Architectures, "arm64")
+	},
+}
+
+// lintRequiredFields are the fields of the template data every build script needs, when the template data has them.
+var lintRequiredFields = []string{
+	"DriverBuildDir",
+	"ModuleDownloadURL",
+	"ModuleDriverName",
+	"ModuleFullPath",
+	"KernelDownloadURL",
+	"KernelDownloadURLS",
+	"KernelDownloadURLs",
+	"KernelArch",
+	"LLVMVersion",
+}
+
+// resolvingURLs resolves any URL without requesting it.
+type resolvingURLs struct{}
+
+func (resolvingURLs) resolve(ctx context.Context, urls []string) ([]string, []urlAttempt, error) {
+	attempts := make([]urlAttempt, 0, len(urls))
+	for _, u := range urls {
+		attempts = append(attempts, urlAttempt{URL: u, StatusCode: http.StatusOK})
+	}
+	return urls, attempts, nil
+}
+
+func withURLResolver(t *testing.T, r urlResolver) {
+	defaultResolver := kernelURLResolver
+	kernelURLResolver = r
+	t.Cleanup(func() { kernelURLResolver = defaultResolver })
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// withoutNetwork fails the test on any request the builders make, the scripts are rendered from the resolved URLs
+// and the indexes of the cache only.
+func withoutNetwork(t *testing.T) {
+	withURLResolver(t, resolvingURLs{})
+	c := newRetryClient(context.Background(), time.Second, 1)
+	c.client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		t.Errorf("Unexpected request: '%s'", req.URL)
+		return nil, fmt.Errorf("no network")
+	})
+	withHTTPClient(t, c)
+
+	defaultIndexes := indexes
+	indexes = newIndexCache("", DefaultCacheTTL)
+	t.Cleanup(func() { indexes = defaultIndexes })
+	for _, arch := range []string{"amd64", "arm64"} {
+		for _, u := range fetchFlatcarPackageListURL(kernelrelease.Architecture(arch), flatcarChannels, "3602.2.3") {
+			indexes.store(&indexCacheEntry{
+				URL:       u,
+				Body:      []byte("sys-devel/gcc-12.3.1_p20230526::portage-stable\nsys-kernel/coreos-kernel-6.1.73::coreos-overlay\n"),
+				FetchedAt: time.Now(),
+			})
+		}
+	}
+}
+
+// lintScript parses the build script as the shell of its shebang does, and checks the tests of the variables quote them,
+// the [ ] tests of the unquoted variables break when they are empty.
+func lintScript(name string, script string) error {
+	lang := syntax.LangBash
+	if strings.HasPrefix(script, "#!/bin/sh\n") {
+		lang = syntax.LangPOSIX
+	}
+	f, err := syntax.NewParser(syntax.Variant(lang)).Parse(strings.NewReader(script), name)
+	if err != nil {
+		return err
+	}
+	unquoted := []string{}
+	syntax.Walk(f, func(node syntax.Node) bool {
+		call, ok := node.(*syntax.CallExpr)
+		if !ok || len(call.Args) == 0 {
+			return true
+		}
+		if cmd := call.Args[0].Lit(); cmd != "[" && cmd != "test" {
+			return true
+		}
+		for _, arg := range call.Args[1:] {
+			for _, part := range arg.Parts {
+				switch part.(type) {
+				case *syntax.ParamExp, *syntax.CmdSubst:
+					var buf bytes.Buffer
+					syntax.NewPrinter().Print(&buf, arg)
+					unquoted = append(unquoted, fmt.Sprintf("%s: %s", arg.Pos(), buf.String()))
+				}
+			}
+		}
+		return true
+	})
+	if len(unquoted) > 0 {
+		return fmt.Errorf("unquoted expansions in tests: %s", strings.Join(unquoted, ", "))
+	}
+	return nil
+}
+
+// emptyFields returns the required fields the template data has, and leaves empty.
+func emptyFields(td interface{}) []string {
+	v := reflect.Indirect(reflect.ValueOf(td))
+	empty := []string{}
+	for _, name := range lintRequiredFields {
+		f := v.FieldByName(name)
+		if f.IsValid() && f.Len() == 0 {
+			empty = append(empty, name)
+		}
+	}
+	return empty
+}
+
+func TestLintBuildScripts(t *testing.T) {
+	withoutNetwork(t)
+
+	for target, b := range BuilderByTarget {
+		kernel, ok := lintKernels[target]
+		if !ok {
+			t.Errorf("Test Input: '%s' | Got: no kernel / Want: a kernel to render the build scripts for", target)
+			continue
+		}
+		for build, configure := range lintBuilds {
+			name := fmt.Sprintf("%s %s", target, build)
+			c := Config{
+				DriverName:      "falco",
+				DeviceName:      "falco",
+				DownloadBaseURL: "https://download.falco.org/driver",
+				Build: &Build{
+					TargetType:       target,
+					KernelRelease:    kernel.kernelrelease,
+					KernelVersion:    kernel.kernelversion,
+					DriverVersion:    "7.0.0+driver",
+					Architecture:     "amd64",
+					ModuleFilePath:   "/tmp/falco.ko",
+					ProbeFilePath:    "/tmp/falco.o",
+					ModuleDriverName: "falco",
+					ModuleDeviceName: "falco",
+					KernelUrls:       kernel.urls,
+					KernelConfigData: "Q09ORklHX0ZUUkFDRV9TWVNDQUxMUz15Cg==",
+					SkipChecksum:     true,
+				},
+			}
+			if !configure(&c, MetadataOf(b)) {
+				continue
+			}
+			kr := c.KernelReleaseFromBuildConfig()
+
+			sources, err := b.ResolveKernelSources(context.Background(), c, kr)
+			if err != nil {
+				t.Errorf("Unexpected error encountered with Test Input: '%s' | Error: '%s'", name, err)
+				continue
+			}
+			if sources.TemplateData == nil || (len(sources.URLs) == 0 && len(kernel.urls) > 0) {
+				t.Errorf("Test Input: '%s' | Got: '%v' / Want: the template data and the kernel packages", name, sources)
+			}
+			for _, field := range emptyFields(sources.TemplateData) {
+				if !containsString(kernel.optional, field) {
+					t.Errorf("Test Input: '%s' | Got: empty %s / Want: not empty", name, field)
+				}
+			}
+
+			script, err := Script(context.Background(), b, c, kr)
+			if err != nil {
+				t.Errorf("Unexpected error encountered with Test Input: '%s' | Error: '%s'", name, err)
+				continue
+			}
+			if strings.Contains(script, "<no value>") {
+				t.Errorf("Test Input: '%s' | Got: '<no value>' in the script / Want: every variable rendered", name)
+			}
+			if err := lintScript(name, script); err != nil {
+				t.Errorf("Test Input: '%s' | Error: '%s'", name, err)
+			}
+		}
+	}
+}
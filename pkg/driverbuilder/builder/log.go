@@ -0,0 +1,22 @@
+package builder
+
+import (
+	"context"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+type loggerKey struct{}
+
+// WithLogger returns a context whose builds log with the logger, e.g. one with the fields of the build.
+func WithLogger(ctx context.Context, l logger.FieldLogger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// Logger returns the logger of the context, the standard one when it has none.
+func Logger(ctx context.Context) logger.FieldLogger {
+	if l, ok := ctx.Value(loggerKey{}).(logger.FieldLogger); ok {
+		return l
+	}
+	return logger.StandardLogger()
+}
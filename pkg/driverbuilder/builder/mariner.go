@@ -0,0 +1,144 @@
+package builder
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"regexp"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+//go:embed templates/mariner.sh
+var marinerTemplate string
+
+// TargetTypeMariner identifies the CBL-Mariner / Azure Linux target.
+const TargetTypeMariner Type = "mariner"
+
+// marinerReleasePattern matches the release suffix in kernel releases like 5.15.138.1-1.cm2 or 6.6.29.1-4.azl3.
+var marinerReleasePattern = regexp.MustCompile(`\.(cm|azl)(\d+)`)
+
+func init() {
+	BuilderByTarget[TargetTypeMariner] = &mariner{}
+}
+
+// mariner is a driverkit target.
+type mariner struct {
+}
+
+type marinerTemplateData struct {
+	buildTemplateData
+	DriverBuildDir     string
+	ModuleDownloadURL  string
+	KernelDownloadURLs []string
+	KernelChecksums    map[string]string
+	GCCVersion         string
+	ModuleDriverName   string
+	ModuleFullPath     string
+	BuildModule        bool
+	BuildProbe         bool
+	KernelArch         string
+	LLVMVersion        string
+	CrossCompile       string
+}
+
+// Script compiles the script to build the kernel module and/or the eBPF probe.
+func (c mariner) Script(cfg Config, kr kernelrelease.KernelRelease, sources KernelSources) (string, error) {
+	parsed, err := parseTemplate(cfg, string(TargetTypeMariner), marinerTemplate, marinerTemplateData{})
+	if err != nil {
+		return "", err
+	}
+	return executeTemplate(parsed, sources.TemplateData)
+}
+
+// ResolveKernelSources implements Builder, resolving the kernel packages of the build.
+func (c mariner) ResolveKernelSources(ctx context.Context, cfg Config, kr kernelrelease.KernelRelease) (KernelSources, error) {
+	var urls []string
+	var err error
+	if cfg.KernelUrls == nil {
+		urls, err = fetchMarinerKernelURLS(ctx, kr)
+	} else {
+		urls, err = getResolvingURLs(ctx, cfg.KernelUrls)
+	}
+	if err != nil {
+		return KernelSources{}, err
+	}
+
+	sums, err := kernelChecksums(ctx, cfg, urls, rpmChecksums)
+	if err != nil {
+		return KernelSources{}, err
+	}
+
+	crossCompilePrefix, err := crossCompile(cfg)
+	if err != nil {
+		return KernelSources{}, err
+	}
+
+	td := marinerTemplateData{
+		DriverBuildDir:     DriverDirectory,
+		ModuleDownloadURL:  moduleDownloadURL(cfg),
+		KernelDownloadURLs: urls,
+		KernelChecksums:    sums,
+		GCCVersion:         gccVersion(cfg, "8"),
+		ModuleDriverName:   cfg.DriverName,
+		ModuleFullPath:     ModuleFullPath,
+		BuildModule:        len(cfg.Build.ModuleFilePath) > 0,
+		BuildProbe:         len(cfg.Build.ProbeFilePath) > 0,
+		KernelArch:         kr.Architecture.ToKernel(),
+		LLVMVersion:        llvmVersion(cfg, "12"),
+		CrossCompile:       crossCompilePrefix,
+	}
+	td.buildTemplateData = newBuildTemplateData(cfg)
+	return KernelSources{URLs: urls, TemplateData: td}, nil
+}
+
+// marinerRepoURL returns the base URL of the repositories of the release.
+// Example: Input -> "-1.cm2", Output -> "https://packages.microsoft.com/cbl-mariner/2.0/prod"
+func marinerRepoURL(kr kernelrelease.KernelRelease) (string, error) {
+	match := marinerReleasePattern.FindStringSubmatch(kr.FullExtraversion)
+	if len(match) != 3 {
+		return "", fmt.Errorf("unable to find the mariner release in the kernel release: %s", kr.FullExtraversion)
+	}
+	distro := "cbl-mariner"
+	if match[1] == "azl" {
+		distro = "azurelinux"
+	}
+	return fmt.Sprintf("https://packages.microsoft.com/%s/%s.0/prod", distro, match[2]), nil
+}
+
+func fetchMarinerKernelURLS(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
+	baseURL, err := marinerRepoURL(kr)
+	if err != nil {
+		return nil, err
+	}
+	repos := []string{"base", "update"}
+
+	// We need:
+	// kernel-devel (build tree)
+	// kernel-headers (uapi headers)
+	found := []string{}
+	for _, pkg := range []string{"kernel-devel", "kernel-headers"} {
+		urls := []string{}
+		for _, r := range repos {
+			// kernel-headers is built as noarch on some releases
+			for _, arch := range []string{kr.Architecture.ToNonDeb(), "noarch"} {
+				urls = append(urls, fmt.Sprintf(
+					"%s/%s/%s/Packages/k/%s-%s%s.%s.rpm",
+					baseURL,
+					r,
+					kr.Architecture.ToNonDeb(),
+					pkg,
+					kr.Fullversion,
+					kr.FullExtraversion,
+					arch,
+				))
+			}
+		}
+		resolved, err := getResolvingURLs(ctx, urls)
+		if err != nil {
+			return nil, fmt.Errorf("%s not found", pkg)
+		}
+		found = append(found, resolved[0])
+	}
+	return found, nil
+}
@@ -0,0 +1,43 @@
+package builder
+
+// Metadata describes what a builder supports and the inputs it requires.
+type Metadata struct {
+	Architectures            []string `json:"architectures" yaml:"architectures"`
+	RequiresKernelVersion    bool     `json:"requires_kernelversion" yaml:"requires_kernelversion"`
+	RequiresKernelConfigData bool     `json:"requires_kernelconfigdata" yaml:"requires_kernelconfigdata"`
+	Module                   bool     `json:"module" yaml:"module"`
+	Probe                    bool     `json:"probe" yaml:"probe"`
+	// CrossCompile tells whether the builds for another architecture can be cross compiled from an amd64 builder,
+	// they run emulated otherwise.
+	CrossCompile bool `json:"cross_compile" yaml:"cross_compile"`
+	// BTF tells whether the builder can generate the BTF of the kernel, from its debug package.
+	BTF bool `json:"btf" yaml:"btf"`
+	// GCC tells whether the gcc of the builds can be chosen, it cannot when they use the toolchain of the kernel.
+	GCC bool `json:"gcc" yaml:"gcc"`
+}
+
+// MetadataProvider is implemented by the builders declaring what they support,
+// the others get DefaultMetadata.
+type MetadataProvider interface {
+	Metadata() Metadata
+}
+
+// DefaultMetadata returns the metadata of the builders not declaring any:
+// both architectures, no further inputs required, both the kernel module and the eBPF probe, cross compiled, with the chosen gcc.
+func DefaultMetadata() Metadata {
+	return Metadata{
+		Architectures: []string{"amd64", "arm64"},
+		Module:        true,
+		Probe:         true,
+		CrossCompile:  true,
+		GCC:           true,
+	}
+}
+
+// MetadataOf returns the metadata of the builder.
+func MetadataOf(b Builder) Metadata {
+	if p, ok := b.(MetadataProvider); ok {
+		return p.Metadata()
+	}
+	return DefaultMetadata()
+}
@@ -0,0 +1,66 @@
+package builder
+
+import (
+	"context"
+	"strings"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+// MirrorsProvider is implemented by the builders looking for the kernel packages into a list of base URLs, the mirrors,
+// the ones of the build, see Build.Mirrors, preceding or replacing them.
+type MirrorsProvider interface {
+	// Mirrors returns the built-in base URLs of the packages of the architecture, in the order they are probed.
+	Mirrors(arch kernelrelease.Architecture) []string
+}
+
+// Mirrors returns the mirrors the builder probes for the build, false when the builder has none.
+func Mirrors(b Builder, build *Build) ([]string, bool) {
+	p, ok := b.(MirrorsProvider)
+	if !ok {
+		return nil, false
+	}
+	return effectiveMirrors(p.Mirrors(kernelrelease.Architecture(build.Architecture)), build.Mirrors[build.TargetType.String()], build.ReplaceMirrors), true
+}
+
+// effectiveMirrors returns the built-in mirrors preceded, or replaced, by the given ones,
+// which get the trailing slash of the built-in ones, the layouts being the same.
+func effectiveMirrors(builtin []string, mirrors []string, replace bool) []string {
+	if len(mirrors) == 0 {
+		return builtin
+	}
+	effective := []string{}
+	for _, m := range mirrors {
+		m = strings.TrimSuffix(m, "/")
+		if len(builtin) > 0 && strings.HasSuffix(builtin[0], "/") {
+			m += "/"
+		}
+		effective = append(effective, m)
+	}
+	if replace {
+		return effective
+	}
+	return append(effective, builtin...)
+}
+
+type mirrorsKey struct{}
+
+// buildMirrors are the mirrors of the build of its target.
+type buildMirrors struct {
+	urls    []string
+	replace bool
+}
+
+// withBuildMirrors returns a context the builders look for the kernel packages into the mirrors of the build from, if any.
+func withBuildMirrors(ctx context.Context, c Config) context.Context {
+	if c.Build == nil || len(c.Mirrors[c.TargetType.String()]) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, mirrorsKey{}, buildMirrors{urls: c.Mirrors[c.TargetType.String()], replace: c.ReplaceMirrors})
+}
+
+// mirrorsFrom returns the mirrors to probe: the built-in ones, preceded or replaced by the ones of the build of the context.
+func mirrorsFrom(ctx context.Context, builtin []string) []string {
+	m, _ := ctx.Value(mirrorsKey{}).(buildMirrors)
+	return effectiveMirrors(builtin, m.urls, m.replace)
+}
@@ -0,0 +1,361 @@
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordMirrors tells the tests to record the pages of the live mirrors into testdata/mirrors, rather than reading them from there:
+//
+//	go test ./pkg/driverbuilder/builder/... -run TestResolveKernelSources -args -record-mirrors
+var recordMirrors = flag.Bool("record-mirrors", false, "record the pages of the live mirrors into testdata/mirrors")
+
+// mirrorsDir is the directory the pages of the mirrors are recorded into, by host and path,
+// with the status of the HEAD requests of the kernel packages in its heads.json.
+const mirrorsDir = "testdata/mirrors"
+
+// mirrorPagePath returns the file the page is recorded into, the directories are recorded into their index.html.
+func mirrorPagePath(dir string, u string) (string, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "", err
+	}
+	p := path.Clean("/" + parsed.Path)
+	if strings.HasSuffix(parsed.Path, "/") {
+		p = path.Join(p, "index.html")
+	}
+	if len(parsed.RawQuery) > 0 {
+		p += "@" + url.QueryEscape(parsed.RawQuery)
+	}
+	return filepath.Join(dir, parsed.Host, filepath.FromSlash(p)), nil
+}
+
+func readMirrorHeads(dir string) (map[string]int, error) {
+	heads := map[string]int{}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "heads.json"))
+	if os.IsNotExist(err) {
+		return heads, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return heads, json.Unmarshal(data, &heads)
+}
+
+// recordedMirrors serves the pages recorded in the directory, the others are not found,
+// and resolves the URLs whose HEAD request was recorded as found.
+type recordedMirrors struct {
+	dir   string
+	heads map[string]int
+}
+
+func (m recordedMirrors) fetch(ctx context.Context, u string) ([]byte, error) {
+	p, err := mirrorPagePath(m.dir, u)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("unexpected status code %d fetching %s", http.StatusNotFound, u)
+	}
+	return body, err
+}
+
+func (m recordedMirrors) resolve(ctx context.Context, urls []string) ([]string, []urlAttempt, error) {
+	resolved := []string{}
+	attempts := make([]urlAttempt, 0, len(urls))
+	for _, u := range urls {
+		a := urlAttempt{URL: resolveURLReference(u), StatusCode: http.StatusNotFound}
+		if status, ok := m.heads[a.URL]; ok {
+			a.StatusCode = status
+		}
+		if a.resolved() {
+			resolved = append(resolved, a.URL)
+		}
+		attempts = append(attempts, a)
+	}
+	return resolved, attempts, nil
+}
+
+// recordingMirrors fetches the pages and resolves the URLs with the live mirrors, recording them into the directory.
+type recordingMirrors struct {
+	dir   string
+	mu    sync.Mutex
+	heads map[string]int
+}
+
+func (m *recordingMirrors) fetch(ctx context.Context, u string) ([]byte, error) {
+	body, err := httpIndexFetcher{}.fetch(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	p, err := mirrorPagePath(m.dir, u)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(p), 0755)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(p, body, 0644)
+	}
+	return body, err
+}
+
+func (m *recordingMirrors) resolve(ctx context.Context, urls []string) ([]string, []urlAttempt, error) {
+	resolved, attempts, err := headURLResolver{}.resolve(ctx, urls)
+	if err != nil {
+		return nil, nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, a := range attempts {
+		if a.Err == nil {
+			m.heads[a.URL] = a.StatusCode
+		}
+	}
+	return resolved, attempts, nil
+}
+
+func (m *recordingMirrors) save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// only the found packages are kept, the others are not found when replayed
+	found := map[string]int{}
+	for u, status := range m.heads {
+		if status == http.StatusOK {
+			found[u] = status
+		}
+	}
+	data, err := json.MarshalIndent(found, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(m.dir, "heads.json"), append(data, '\n'), 0644)
+}
+
+// withMirrors returns the fetcher of the recorded pages of the mirrors, resolving the URLs with them too,
+// any other request fails the test. With -record-mirrors they are recorded from the live mirrors instead.
+func withMirrors(t *testing.T) indexFetcher {
+	heads, err := readMirrorHeads(mirrorsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultIndexes := indexes
+	indexes = newIndexCache("", DefaultCacheTTL)
+	t.Cleanup(func() { indexes = defaultIndexes })
+
+	if *recordMirrors {
+		m := &recordingMirrors{dir: mirrorsDir, heads: heads}
+		withURLResolver(t, m)
+		t.Cleanup(func() {
+			if err := m.save(); err != nil {
+				t.Error(err)
+			}
+		})
+		return m
+	}
+
+	// the recorded InRelease files are signed with the test key when the archives were not recorded live
+	withTestArchiveKeys(t)
+	m := recordedMirrors{dir: mirrorsDir, heads: heads}
+	withURLResolver(t, m)
+	c := newRetryClient(context.Background(), time.Second, 1)
+	c.client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		t.Errorf("Unexpected request: '%s'", req.URL)
+		return nil, fmt.Errorf("no network")
+	})
+	withHTTPClient(t, c)
+	return m
+}
+
+func TestResolveKernelSources(t *testing.T) {
+	mirrors := withMirrors(t)
+
+	tests := map[string]struct {
+		target        Type
+		kernelrelease string
+		kernelversion string
+		architecture  string
+		expected      []string
+		err           string
+	}{
+		"debian bookworm": {
+			target:        TargetTypeDebian,
+			kernelrelease: "6.1.0-13-amd64",
+			kernelversion: "1",
+			architecture:  "amd64",
+			expected: []string{
+				"http://deb.debian.org/debian/pool/main/l/linux/linux-headers-6.1.0-13-amd64_6.1.55-1_amd64.deb",
+				"http://deb.debian.org/debian/pool/main/l/linux/linux-headers-6.1.0-13-common_6.1.55-1_all.deb",
+				"http://deb.debian.org/debian/pool/main/l/linux/linux-kbuild-6.1_6.1.55-1_amd64.deb",
+			},
+		},
+		"debian bookworm security": {
+			target:        TargetTypeDebian,
+			kernelrelease: "6.1.0-17-amd64",
+			kernelversion: "1",
+			architecture:  "amd64",
+			expected: []string{
+				"http://security.debian.org/debian-security/pool/updates/main/l/linux/linux-headers-6.1.0-17-amd64_6.1.69-1_amd64.deb",
+				"http://security.debian.org/debian-security/pool/updates/main/l/linux/linux-headers-6.1.0-17-common_6.1.69-1_all.deb",
+				"http://security.debian.org/debian-security/pool/updates/main/l/linux/linux-kbuild-6.1_6.1.69-1_amd64.deb",
+			},
+		},
+		"debian bookworm not found": {
+			target:        TargetTypeDebian,
+			kernelrelease: "6.1.0-99-amd64",
+			kernelversion: "1",
+			architecture:  "amd64",
+			err:           "kernel headers not found",
+		},
+		"ubuntu": {
+			target:        TargetTypeUbuntu,
+			kernelrelease: "5.15.0-91-generic",
+			kernelversion: "101",
+			architecture:  "amd64",
+			expected: []string{
+				"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.15.0-91_5.15.0-91.101_all.deb",
+				"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.15.0-91-generic_5.15.0-91.101_amd64.deb",
+			},
+		},
+		"ubuntu kernel version mismatch": {
+			target:        TargetTypeUbuntu,
+			kernelrelease: "5.15.0-91-generic",
+			kernelversion: "102",
+			architecture:  "amd64",
+			err: "kernel headers not found in: https://mirrors.edge.kernel.org/ubuntu/pool/main/l, http://security.ubuntu.com/ubuntu/pool/main/l, " +
+				"http://old-releases.ubuntu.com/ubuntu/pool/main/l, https://api.launchpad.net/1.0/ubuntu/+archive/primary",
+		},
+		"centos stream 9": {
+			target:        TargetTypeCentos,
+			kernelrelease: "5.14.0-503.el9.x86_64",
+			architecture:  "amd64",
+			expected: []string{
+				"https://mirror.stream.centos.org/9-stream/BaseOS/x86_64/os/Packages/kernel-devel-5.14.0-503.el9.x86_64.rpm",
+			},
+		},
+		"centos stream 9 not found": {
+			target:        TargetTypeCentos,
+			kernelrelease: "5.14.0-999.el9.x86_64",
+			architecture:  "amd64",
+			err: "kernel not found, tried the repositories:\n" +
+				"  https://mirrors.edge.kernel.org/centos/6/os/x86_64\n" +
+				"  https://mirrors.edge.kernel.org/centos/6/updates/x86_64\n" +
+				"  https://mirrors.edge.kernel.org/centos/7/os/x86_64\n" +
+				"  https://mirrors.edge.kernel.org/centos/7/updates/x86_64\n" +
+				"  https://mirrors.edge.kernel.org/centos/8/BaseOS/x86_64/os\n" +
+				"  https://mirrors.edge.kernel.org/centos/8-stream/BaseOS/x86_64/os\n" +
+				"  https://mirror.stream.centos.org/9-stream/BaseOS/x86_64/os\n" +
+				"  https://mirror.stream.centos.org/9-stream/AppStream/x86_64/os\n" +
+				"  https://composes.stream.centos.org/production/latest-CentOS-Stream/compose/BaseOS/x86_64/os\n" +
+				"  https://composes.stream.centos.org/production/latest-CentOS-Stream/compose/AppStream/x86_64/os",
+		},
+		"amazonlinux2 extras": {
+			target:        TargetTypeAmazonLinux2,
+			kernelrelease: "5.10.192-183.736.amzn2.x86_64",
+			architecture:  "amd64",
+			expected: []string{
+				"http://amazonlinux.us-east-1.amazonaws.com/2/extras/kernel-5.10/stable/x86_64/9b4b8b6eb7b8ac6a1bbf0ba8a4d2a4d02bce8ed7d6c86f93b8d6b1b1ba3e0d31/Packages/kernel-devel-5.10.192-183.736.amzn2.x86_64.rpm",
+			},
+		},
+		"amazonlinux2 core": {
+			target:        TargetTypeAmazonLinux2,
+			kernelrelease: "4.14.322-244.536.amzn2.x86_64",
+			architecture:  "amd64",
+			expected: []string{
+				"http://amazonlinux.us-east-1.amazonaws.com/2/core/2.0/x86_64/6b0225ccc542f3834c95733dcf321ab9f1e77e6ca6817469771a8af7c49efe6c/Packages/kernel-devel-4.14.322-244.536.amzn2.x86_64.rpm",
+			},
+		},
+		"amazonlinux2 release mismatch": {
+			target:        TargetTypeAmazonLinux2,
+			kernelrelease: "5.10.192-184.1.amzn2.x86_64",
+			architecture:  "amd64",
+			err:           "kernel headers not found, closest available kernel-devel versions: 5.10.186-179.751.amzn2, 5.10.192-183.736.amzn2",
+		},
+		"debian architecture mismatch": {
+			target:        TargetTypeDebian,
+			kernelrelease: "6.1.0-17-amd64",
+			kernelversion: "1",
+			architecture:  "arm64",
+			err:           "kernel headers not found",
+		},
+	}
+
+	names := make([]string, 0, len(tests))
+	for name := range tests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		test := tests[name]
+		c := Config{
+			DriverName:   "falco",
+			indexFetcher: mirrors,
+			Build: &Build{
+				TargetType:     test.target,
+				KernelRelease:  test.kernelrelease,
+				KernelVersion:  test.kernelversion,
+				Architecture:   test.architecture,
+				DriverVersion:  "7.0.0+driver",
+				ModuleFilePath: "/tmp/falco.ko",
+				SkipChecksum:   true,
+			},
+		}
+		sources, err := ResolveKernelSources(context.Background(), BuilderByTarget[test.target], c, c.KernelReleaseFromBuildConfig())
+		if len(test.err) > 0 {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("Test Input: '%s' | Got: '%v' / Want: '%s'", name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error encountered with Test Input: '%s' | Error: '%s'", name, err)
+			continue
+		}
+		if strings.Join(sources.URLs, " ") != strings.Join(test.expected, " ") {
+			t.Errorf("Test Input: '%s' | Got: '%v' / Want: '%v'", name, sources.URLs, test.expected)
+		}
+	}
+}
+
+func TestMirrors(t *testing.T) {
+	build := &Build{
+		TargetType:   TargetTypeDebian,
+		Architecture: "amd64",
+		Mirrors:      map[string][]string{"debian": {"http://mirror.local/debian/pool/main/l/linux"}, "ubuntu": {"http://mirror.local/ubuntu/pool/main/l/"}},
+	}
+	got, ok := Mirrors(&debian{}, build)
+	want := append([]string{"http://mirror.local/debian/pool/main/l/linux/"}, debianHeadersBaseURLs...)
+	if !ok || !reflect.DeepEqual(got, want) {
+		t.Errorf("Got: [ %v ] / Want: [ %v ]", got, want)
+	}
+
+	build.TargetType = TargetTypeUbuntu
+	build.ReplaceMirrors = true
+	got, _ = Mirrors(&ubuntu{}, build)
+	if want := []string{"http://mirror.local/ubuntu/pool/main/l"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Got: [ %v ] / Want: [ %v ]", got, want)
+	}
+
+	build.Architecture = "arm64"
+	build.TargetType = TargetTypeUbuntuAWS
+	if got, _ = Mirrors(&ubuntu{}, build); !reflect.DeepEqual(got, ubuntuPortsBaseURLs) {
+		t.Errorf("Got: [ %v ] / Want: [ the built-in ports pools, no ubuntu-aws mirror given ]", got)
+	}
+
+	if _, ok := Mirrors(&centos{}, build); ok {
+		t.Errorf("Got: [ centos mirrors ] / Want: [ none ]")
+	}
+}
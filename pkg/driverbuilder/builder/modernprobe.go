@@ -0,0 +1,84 @@
+package builder
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/base64"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+//go:embed templates/modern_probe.sh
+var modernProbeTemplate string
+
+// modernProbeLLVMVersion is the clang the modern eBPF probe is built with, whatever the target, it needs clang >= 12.
+const modernProbeLLVMVersion = "14"
+
+// modernProbeArchitectures are the architectures the modern eBPF probe can be built for, the ones of the pinned bpftool.
+var modernProbeArchitectures = []string{"amd64", "arm64"}
+
+// modernProbeMinimumKernel is the oldest kernel the modern eBPF probe runs on, where the BPF ring buffer appeared.
+var modernProbeMinimumKernel = kernelrelease.FromString("5.8.0")
+
+// kernelBTFPattern matches the kernel config enabling the BTF of the kernel.
+var kernelBTFPattern = regexp.MustCompile(`(?m)^CONFIG_DEBUG_INFO_BTF=y$`)
+
+type modernProbeTemplateData struct {
+	buildTemplateData
+	ModuleDownloadURL    string
+	LocalDriverDirectory string
+	LLVMVersion          string
+	ModernProbeDir       string
+	ModernProbeFileName  string
+	ModernProbeFullPath  string
+}
+
+// withModernProbe appends the build of the modern eBPF probe to the build script of the target:
+// it only needs the sources of the driver and the pinned clang and bpftool, not the kernel headers the script installs.
+func withModernProbe(script string, c Config) (string, error) {
+	parsed, err := template.New("modern_probe").Option("missingkey=error").Parse(modernProbeTemplate)
+	if err != nil {
+		return "", err
+	}
+	td := modernProbeTemplateData{
+		ModuleDownloadURL:    moduleDownloadURL(c),
+		LocalDriverDirectory: LocalDriverDirectory,
+		LLVMVersion:          modernProbeLLVMVersion,
+		ModernProbeDir:       path.Dir(ModernProbeFullPath),
+		ModernProbeFileName:  ModernProbeFileName,
+		ModernProbeFullPath:  ModernProbeFullPath,
+	}
+	td.buildTemplateData = newBuildTemplateData(c)
+	buf := bytes.NewBufferString(script)
+	if err := parsed.Execute(buf, td); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// validateModernProbe fails when the modern eBPF probe cannot be built for the kernel:
+// it must be recent enough and, when the kernel config data tells it, have its BTF.
+func validateModernProbe(c Config, kr kernelrelease.KernelRelease) error {
+	if !containsString(modernProbeArchitectures, c.Architecture) {
+		return fmt.Errorf("the modern eBPF probe cannot be built for the %s architecture, only for %s", c.Architecture, strings.Join(modernProbeArchitectures, ", "))
+	}
+	if kr.LessThan(modernProbeMinimumKernel) {
+		return fmt.Errorf("the modern eBPF probe requires a kernel >= %s with BTF, %s is older", modernProbeMinimumKernel.Fullversion, c.KernelRelease)
+	}
+	if !c.HasKernelConfigData() {
+		return nil
+	}
+	config, err := base64.StdEncoding.DecodeString(c.KernelConfigData)
+	if err != nil {
+		return fmt.Errorf("invalid kernel config data, it must be base64 encoded: %s", err)
+	}
+	if !kernelBTFPattern.Match(config) {
+		return fmt.Errorf("the modern eBPF probe requires the BTF of the kernel, CONFIG_DEBUG_INFO_BTF is not enabled by its kernel config data")
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package builder
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWithModernProbe(t *testing.T) {
+	c := Config{
+		DownloadBaseURL: "https://github.com/falcosecurity/libs/archive",
+		Build:           &Build{DriverVersion: "master", ModernProbeFilePath: "/tmp/bpf_probe.skel.h"},
+	}
+	script, err := withModernProbe("#!/bin/bash\nset -xeuo pipefail\n", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"#!/bin/bash\nset -xeuo pipefail\n",
+		"curl --silent -SL https://github.com/falcosecurity/libs/archive/master.tar.gz",
+		"-DMODERN_CLANG_EXE=/usr/bin/clang-14",
+		"make ProbeSkeleton",
+		"-name bpf_probe.skel.h | head -n 1) /tmp/driver/modern_bpf/bpf_probe.skel.h",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("Got: '%s' / Want: '%s' in it", script, want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+// TargetTypeOpenEuler identifies the openEuler target.
+const TargetTypeOpenEuler Type = "openeuler"
+
+// openEulerReleasePattern matches the release suffix in kernel releases like 5.10.0-136.12.0.86.oe2203sp1.x86_64.
+var openEulerReleasePattern = regexp.MustCompile(`\.oe(\d+)(sp\d+)?`)
+
+func init() {
+	BuilderByTarget[TargetTypeOpenEuler] = &openeuler{}
+}
+
+// openeuler is a driverkit target.
+type openeuler struct {
+}
+
+// Script compiles the script to build the kernel module and/or the eBPF probe.
+func (c openeuler) Script(cfg Config, kr kernelrelease.KernelRelease, sources KernelSources) (string, error) {
+	return elCloneScript(TargetTypeOpenEuler, cfg, sources)
+}
+
+// ResolveKernelSources implements Builder, resolving the kernel packages of the build.
+func (c openeuler) ResolveKernelSources(ctx context.Context, cfg Config, kr kernelrelease.KernelRelease) (KernelSources, error) {
+	return resolveELCloneKernelSources(ctx, cfg, kr, fetchOpenEulerKernelURLS)
+}
+
+// openEulerReleases maps the release suffixes to the repositories that could ship the kernel.
+// The 20.03 LTS service packs after SP1 kept the "oe1" suffix, making it ambiguous:
+// every candidate is tried, kernel urls can be used to pick a specific one.
+var openEulerReleases = map[string][]string{
+	"1":       {"openEuler-20.03-LTS-SP3", "openEuler-20.03-LTS-SP2", "openEuler-20.03-LTS-SP1", "openEuler-20.03-LTS"},
+	"2003":    {"openEuler-20.03-LTS"},
+	"2003sp1": {"openEuler-20.03-LTS-SP1"},
+	"2003sp2": {"openEuler-20.03-LTS-SP2"},
+	"2003sp3": {"openEuler-20.03-LTS-SP3"},
+	"2003sp4": {"openEuler-20.03-LTS-SP4"},
+	"2203":    {"openEuler-22.03-LTS"},
+	"2203sp1": {"openEuler-22.03-LTS-SP1"},
+	"2203sp2": {"openEuler-22.03-LTS-SP2"},
+	"2203sp3": {"openEuler-22.03-LTS-SP3"},
+	"2203sp4": {"openEuler-22.03-LTS-SP4"},
+	"2403":    {"openEuler-24.03-LTS"},
+	"2403sp1": {"openEuler-24.03-LTS-SP1"},
+}
+
+// openEulerReleasesFromKernelRelease returns the release repositories for the kernel release.
+// Example: Input -> "-136.12.0.86.oe2203sp1.x86_64", Output -> ["openEuler-22.03-LTS-SP1"]
+func openEulerReleasesFromKernelRelease(kr kernelrelease.KernelRelease) ([]string, error) {
+	match := openEulerReleasePattern.FindStringSubmatch(kr.FullExtraversion)
+	if len(match) != 3 {
+		return nil, fmt.Errorf("unable to find the openeuler release in the kernel release: %s", kr.FullExtraversion)
+	}
+	releases, ok := openEulerReleases[match[1]+match[2]]
+	if !ok {
+		return nil, fmt.Errorf("unsupported openeuler release: oe%s%s, kernel urls must be provided", match[1], match[2])
+	}
+	return releases, nil
+}
+
+func fetchOpenEulerKernelURLS(kr kernelrelease.KernelRelease) ([]string, error) {
+	releases, err := openEulerReleasesFromKernelRelease(kr)
+	if err != nil {
+		return nil, err
+	}
+	repos := []string{"update", "OS", "everything"}
+
+	urls := []string{}
+	for _, r := range releases {
+		for _, repo := range repos {
+			urls = append(urls, fmt.Sprintf(
+				"https://repo.openeuler.org/%s/%s/%s/Packages/kernel-devel-%s%s.rpm",
+				r,
+				repo,
+				kr.Architecture.ToNonDeb(),
+				kr.Fullversion,
+				kr.FullExtraversion,
+			))
+		}
+	}
+	return urls, nil
+}
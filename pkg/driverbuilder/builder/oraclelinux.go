@@ -0,0 +1,110 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+// TargetTypeOracleLinux identifies the Oracle Linux target.
+const TargetTypeOracleLinux Type = "oraclelinux"
+
+// oracleLinuxRepoURLs are the roots of the repositories of the releases.
+var oracleLinuxRepoURLs = []string{"https://yum.oracle.com/repo/OracleLinux"}
+
+func init() {
+	BuilderByTarget[TargetTypeOracleLinux] = &oraclelinux{}
+}
+
+// oraclelinux is a driverkit target.
+type oraclelinux struct {
+}
+
+// Mirrors implements MirrorsProvider.
+func (c oraclelinux) Mirrors(arch kernelrelease.Architecture) []string {
+	return oracleLinuxRepoURLs
+}
+
+// Script compiles the script to build the kernel module and/or the eBPF probe.
+func (c oraclelinux) Script(cfg Config, kr kernelrelease.KernelRelease, sources KernelSources) (string, error) {
+	return elCloneScript(TargetTypeOracleLinux, cfg, sources)
+}
+
+// ResolveKernelSources implements Builder, resolving the kernel packages of the build.
+func (c oraclelinux) ResolveKernelSources(ctx context.Context, cfg Config, kr kernelrelease.KernelRelease) (KernelSources, error) {
+	return resolveELCloneKernelSources(ctx, cfg, kr, func(kr kernelrelease.KernelRelease) ([]string, error) {
+		return fetchOracleLinuxKernelURLS(ctx, kr)
+	})
+}
+
+// oracleLinuxRHCKRepos are the repositories shipping the Red Hat Compatible Kernel.
+var oracleLinuxRHCKRepos = map[string][]string{
+	"7": {"latest", "MODRHCK"},
+	"8": {"baseos/latest", "appstream"},
+	"9": {"baseos/latest", "appstream"},
+}
+
+// oracleLinuxUEKRepos are the repositories shipping the Unbreakable Enterprise Kernel.
+var oracleLinuxUEKRepos = map[string][]string{
+	"7": {"UEKR6", "UEKR5", "UEKR4", "UEKR3", "latest"},
+	"8": {"UEKR7", "UEKR6", "baseos/latest"},
+	"9": {"UEKR8", "UEKR7", "baseos/latest"},
+}
+
+// oracleLinuxKernelPackage returns the name of the package shipping the kernel headers,
+// UEK kernels are marked with "uek" in the release.
+// Example: Input -> "5.15.0-101.103.2.1.el9uek.x86_64", Output -> "kernel-uek-devel-5.15.0-101.103.2.1.el9uek.x86_64.rpm"
+func oracleLinuxKernelPackage(kr kernelrelease.KernelRelease) string {
+	pkg := "kernel-devel"
+	if strings.Contains(kr.FullExtraversion, "uek") {
+		pkg = "kernel-uek-devel"
+	}
+	return fmt.Sprintf("%s-%s%s.rpm", pkg, kr.Fullversion, kr.FullExtraversion)
+}
+
+func fetchOracleLinuxKernelURLS(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
+	match := elReleasePattern.FindStringSubmatch(kr.FullExtraversion)
+	if len(match) != 3 {
+		return nil, fmt.Errorf("unable to find the el release in the kernel release: %s", kr.FullExtraversion)
+	}
+	release := match[1]
+
+	repos := oracleLinuxRHCKRepos[release]
+	if strings.Contains(kr.FullExtraversion, "uek") {
+		repos = oracleLinuxUEKRepos[release]
+	}
+	if len(repos) == 0 {
+		return nil, fmt.Errorf("unsupported oracle linux release: %s", release)
+	}
+
+	pkg := oracleLinuxKernelPackage(kr)
+	urls := []string{}
+	for _, b := range mirrorsFrom(ctx, oracleLinuxRepoURLs) {
+		for _, r := range repos {
+			repoURL := fmt.Sprintf("%s/OL%s/%s/%s", b, release, r, kr.Architecture.ToNonDeb())
+			found, err := oracleLinuxRepoIndexContains(ctx, repoURL, pkg)
+			if err != nil {
+				Logger(ctx).WithError(err).WithField("url", repoURL).Debug("skipping repository")
+				continue
+			}
+			if found {
+				urls = append(urls, fmt.Sprintf("%s/getPackage/%s", repoURL, pkg))
+			}
+		}
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("kernel headers not found")
+	}
+	return urls, nil
+}
+
+// oracleLinuxRepoIndexContains scrapes the index page of the repository looking for the given package.
+func oracleLinuxRepoIndexContains(ctx context.Context, repoURL, pkg string) (bool, error) {
+	body, err := getIndex(ctx, fmt.Sprintf("%s/index.html", repoURL))
+	if err != nil {
+		return false, err
+	}
+	return strings.Contains(string(body), fmt.Sprintf("getPackage/%s", pkg)), nil
+}
@@ -1,21 +1,30 @@
 package builder
 
 import (
-	"bytes"
+	"context"
 	_ "embed"
 	"fmt"
+	"regexp"
+	"strings"
+
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
-	"text/template"
 )
 
 // TargetTypePhoton identifies the Photon target.
 const TargetTypePhoton Type = "photon"
 
+// TargetTypePhotonOS identifies the Photon target too, it is an alias of TargetTypePhoton.
+const TargetTypePhotonOS Type = "photonos"
+
 //go:embed templates/photonos.sh
 var photonTemplate string
 
+// photonReleasePattern matches the release suffix in kernel releases like 5.10.83-7.ph4-esx.
+var photonReleasePattern = regexp.MustCompile(`\.ph(\d+)(?:-([a-z]+))?$`)
+
 func init() {
 	BuilderByTarget[TargetTypePhoton] = &photon{}
+	BuilderByTarget[TargetTypePhotonOS] = &photon{}
 }
 
 // photon is a driverkit target.
@@ -23,92 +32,119 @@ type photon struct {
 }
 
 // Script compiles the script to build the kernel module and/or the eBPF probe.
-func (c photon) Script(cfg Config, kr kernelrelease.KernelRelease) (string, error) {
-	t := template.New(string(TargetTypePhoton))
-	parsed, err := t.Parse(photonTemplate)
+func (c photon) Script(cfg Config, kr kernelrelease.KernelRelease, sources KernelSources) (string, error) {
+	parsed, err := parseTemplate(cfg, string(TargetTypePhoton), photonTemplate, photonTemplateData{})
 	if err != nil {
 		return "", err
 	}
-	
-	// Check (and filter) existing kernels before continuing
-	urls, err := getResolvingURLs(fetchPhotonKernelURLS(kr))
+	return executeTemplate(parsed, sources.TemplateData)
+}
+
+// ResolveKernelSources implements Builder, resolving the kernel packages of the build.
+func (c photon) ResolveKernelSources(ctx context.Context, cfg Config, kr kernelrelease.KernelRelease) (KernelSources, error) {
+	var urls []string
+	var err error
+	if cfg.KernelUrls == nil {
+		release, flavor, err := photonReleaseFromKernelRelease(kr)
+		if err != nil {
+			return KernelSources{}, err
+		}
+		// Check (and filter) existing kernels before continuing
+		urls, err = getResolvingURLs(ctx, fetchPhotonKernelURLS(kr, release, flavor))
+		if err != nil {
+			return KernelSources{}, fmt.Errorf("kernel headers not found")
+		}
+	} else {
+		urls, err = getResolvingURLs(ctx, cfg.KernelUrls)
+		if err != nil {
+			return KernelSources{}, err
+		}
+	}
+
+	sums, err := kernelChecksums(ctx, cfg, urls[:1], rpmChecksums)
+	if err != nil {
+		return KernelSources{}, err
+	}
+
+	crossCompilePrefix, err := crossCompile(cfg)
 	if err != nil {
-		return "", err
+		return KernelSources{}, err
 	}
 
 	td := photonTemplateData{
 		DriverBuildDir:    DriverDirectory,
 		ModuleDownloadURL: moduleDownloadURL(cfg),
 		KernelDownloadURL: urls[0],
-		GCCVersion:        photonGccVersionFromKernelRelease(kr),
+		KernelChecksum:    sums[urls[0]],
+		GCCVersion:        gccVersion(cfg, photonGccVersionFromKernelRelease(kr)),
 		ModuleDriverName:  cfg.DriverName,
 		ModuleFullPath:    ModuleFullPath,
 		BuildModule:       len(cfg.Build.ModuleFilePath) > 0,
 		BuildProbe:        len(cfg.Build.ProbeFilePath) > 0,
+		KernelArch:        kr.Architecture.ToKernel(),
+		LLVMVersion:       llvmVersion(cfg, "7"),
+		CrossCompile:      crossCompilePrefix,
 	}
+	td.buildTemplateData = newBuildTemplateData(cfg)
+	return KernelSources{URLs: urls[:1], TemplateData: td}, nil
+}
 
-	buf := bytes.NewBuffer(nil)
-	err = parsed.Execute(buf, td)
-	if err != nil {
-		return "", err
+// photonReleaseFromKernelRelease returns the Photon release and the kernel flavor,
+// the flavor is empty for the generic kernel.
+// Example: Input -> "5.10.83-7.ph4-esx", Output -> "4.0", "esx"
+func photonReleaseFromKernelRelease(kr kernelrelease.KernelRelease) (string, string, error) {
+	match := photonReleasePattern.FindStringSubmatch(kr.FullExtraversion)
+	if len(match) != 3 {
+		return "", "", fmt.Errorf("unable to infer the photon release from the kernel release: %s", kr.Fullversion+kr.FullExtraversion)
 	}
-	return buf.String(), nil
+	return fmt.Sprintf("%s.0", match[1]), match[2], nil
 }
 
-func fetchPhotonKernelURLS(kr kernelrelease.KernelRelease) []string {
-	photonReleases := []string{
-		"3.0",
-		"4.0",
+func fetchPhotonKernelURLS(kr kernelrelease.KernelRelease, release, flavor string) []string {
+	arch := kr.Architecture.ToNonDeb()
+	pkg := "linux-devel"
+	if flavor != "" {
+		pkg = fmt.Sprintf("linux-%s-devel", flavor)
+	}
+	// the package release does not carry the flavor
+	pkgRelease := strings.TrimSuffix(kr.FullExtraversion, "-"+flavor)
+
+	repos := []string{
+		fmt.Sprintf("photon_updates_%s_%s", release, arch),
+		fmt.Sprintf("photon_release_%s_%s", release, arch),
+		fmt.Sprintf("photon_%s_%s", release, arch),
 	}
 
 	urls := []string{}
-	for _, r := range photonReleases {
-		switch r {
-		case "3.0":
-			urls = append(urls, fmt.Sprintf(
-				"https://packages.vmware.com/photon/%s/photon_updates_%s_x86_64/x86_64/linux-devel-%s%s.x86_64.rpm",
-				r,
-				r,
-				kr.Fullversion,
-				kr.FullExtraversion,
-			))
-			urls = append(urls, fmt.Sprintf(
-				"https://packages.vmware.com/photon/%s/photon_release_%s_x86_64/x86_64/linux-devel-%s%s.x86_64.rpm",
-				r,
-				r,
-				kr.Fullversion,
-				kr.FullExtraversion,
-			))
-
-		case "4.0":
-			urls = append(urls, fmt.Sprintf(
-				"https://packages.vmware.com/photon/%s/photon_%s_x86_64/x86_64/linux-devel-%s%s.x86_64.rpm",
-				r,
-				r,
-				kr.Fullversion,
-				kr.FullExtraversion,
-			))
-			urls = append(urls, fmt.Sprintf(
-				"https://packages.vmware.com/photon/%s/photon_release_%s_x86_64/x86_64/linux-devel-%s%s.x86_64.rpm",
-				r,
-				r,
-				kr.Fullversion,
-				kr.FullExtraversion,
-			))
-		}
+	for _, r := range repos {
+		urls = append(urls, fmt.Sprintf(
+			"https://packages.vmware.com/photon/%s/%s/%s/%s-%s%s.%s.rpm",
+			release,
+			r,
+			arch,
+			pkg,
+			kr.Fullversion,
+			pkgRelease,
+			arch,
+		))
 	}
 	return urls
 }
 
 type photonTemplateData struct {
+	buildTemplateData
 	DriverBuildDir    string
 	ModuleDownloadURL string
 	KernelDownloadURL string
+	KernelChecksum    string
 	GCCVersion        string
 	ModuleDriverName  string
 	ModuleFullPath    string
 	BuildModule       bool
 	BuildProbe        bool
+	KernelArch        string
+	LLVMVersion       string
+	CrossCompile      string
 }
 
 func photonGccVersionFromKernelRelease(kr kernelrelease.KernelRelease) string {
@@ -0,0 +1,205 @@
+package builder
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+//go:embed templates/raspios.sh
+var raspiosTemplate string
+
+// TargetTypeRaspios identifies the Raspberry Pi OS target.
+const TargetTypeRaspios Type = "raspios"
+
+// raspiosPoolURLs are the pools of the kernel headers packages.
+var raspiosPoolURLs = []string{"http://archive.raspberrypi.org/debian/pool/main/r/raspberrypi-firmware/"}
+
+// raspiosSuites are the suites of the Raspberry Pi archive, one by Raspberry Pi OS release, a kernel headers package can be in several.
+var raspiosSuites = []string{"buster", "bullseye", "bookworm", "trixie"}
+
+func init() {
+	BuilderByTarget[TargetTypeRaspios] = &raspios{}
+}
+
+// raspios is a driverkit target.
+type raspios struct {
+}
+
+// Metadata implements MetadataProvider, the Raspberry Pi OS kernels are arm ones.
+func (c raspios) Metadata() Metadata {
+	m := DefaultMetadata()
+	m.Architectures = []string{"arm64"}
+	return m
+}
+
+// Mirrors implements MirrorsProvider, the headers are looked for into the first pool having some.
+func (c raspios) Mirrors(arch kernelrelease.Architecture) []string {
+	return raspiosPoolURLs
+}
+
+type raspiosTemplateData struct {
+	buildTemplateData
+	DriverBuildDir     string
+	ModuleDownloadURL  string
+	KernelDownloadURLS []string
+	KernelChecksums    map[string]string
+	KernelRelease      string
+	ModuleDriverName   string
+	ModuleFullPath     string
+	BuildModule        bool
+	BuildProbe         bool
+	KernelArch         string
+	CrossCompile       string
+	LLVMVersion        string
+	GCCVersion         string
+}
+
+// Script compiles the script to build the kernel module and/or the eBPF probe.
+//
+// A single raspberrypi-kernel-headers package ships the build trees of every kernel flavor (e.g. v7, v7l, v8),
+// its version (e.g. 1.20230405-1) can be provided in the kernel version, otherwise the latest one is used.
+func (c raspios) Script(cfg Config, kr kernelrelease.KernelRelease, sources KernelSources) (string, error) {
+	parsed, err := parseTemplate(cfg, string(TargetTypeRaspios), raspiosTemplate, raspiosTemplateData{})
+	if err != nil {
+		return "", err
+	}
+	return executeTemplate(parsed, sources.TemplateData)
+}
+
+// ResolveKernelSources implements Builder, resolving the kernel packages of the build.
+func (c raspios) ResolveKernelSources(ctx context.Context, cfg Config, kr kernelrelease.KernelRelease) (KernelSources, error) {
+	var urls []string
+	var err error
+	if cfg.KernelUrls == nil {
+		urls, err = fetchRaspiosKernelURLs(ctx, kr, cfg.KernelVersion)
+	} else {
+		urls, err = getResolvingURLs(ctx, cfg.KernelUrls)
+	}
+	if err != nil {
+		return KernelSources{}, err
+	}
+
+	sums, err := kernelChecksums(ctx, cfg, urls, debianChecksums(kr.Architecture.ToDeb(), raspiosArchiveKeys, func(string) []string { return raspiosSuites }))
+	if err != nil {
+		return KernelSources{}, err
+	}
+
+	crossCompilePrefix, err := crossCompile(cfg)
+	if err != nil {
+		return KernelSources{}, err
+	}
+
+	td := raspiosTemplateData{
+		DriverBuildDir:     DriverDirectory,
+		ModuleDownloadURL:  moduleDownloadURL(cfg),
+		KernelDownloadURLS: urls,
+		KernelChecksums:    sums,
+		KernelRelease:      raspiosKernelRelease(kr),
+		ModuleDriverName:   cfg.DriverName,
+		ModuleFullPath:     ModuleFullPath,
+		BuildModule:        len(cfg.Build.ModuleFilePath) > 0,
+		BuildProbe:         len(cfg.Build.ProbeFilePath) > 0,
+		KernelArch:         kr.Architecture.ToKernel(),
+		CrossCompile:       crossCompilePrefix,
+		LLVMVersion:        llvmVersion(cfg, debianLLVMVersionFromKernelRelease(kr)),
+		GCCVersion:         gccVersion(cfg, debianGCCVersionFromKernelRelease(kr)),
+	}
+	td.buildTemplateData = newBuildTemplateData(cfg)
+	return KernelSources{URLs: urls, TemplateData: td}, nil
+}
+
+// raspiosKernelRelease returns the name of the build tree of the kernel flavor,
+// Raspberry Pi OS kernels always carry the "+" local version marker.
+// Example: Input -> "6.1.21-v8+", Output -> "6.1.21-v8+"
+func raspiosKernelRelease(kr kernelrelease.KernelRelease) string {
+	return fmt.Sprintf("%s%s+", kr.Fullversion, kr.FullExtraversion)
+}
+
+func fetchRaspiosKernelURLs(ctx context.Context, kr kernelrelease.KernelRelease, packageVersion string) ([]string, error) {
+	var err error
+	for _, poolURL := range mirrorsFrom(ctx, raspiosPoolURLs) {
+		var body []byte
+		if body, err = getIndex(ctx, poolURL); err != nil {
+			continue
+		}
+		var urls []string
+		if urls, err = raspiosKernelURLsFromIndex(ctx, poolURL, string(body), kr, packageVersion); err == nil {
+			return urls, nil
+		}
+	}
+	return nil, err
+}
+
+// raspiosFirmwareURL is the repository of the firmware releases, tagged by the version the headers packages are built from,
+// e.g. 1.20230405 for 1.20230405-1, with the kernel releases they ship in their extra/uname_string* files.
+var raspiosFirmwareURL = "https://raw.githubusercontent.com/raspberrypi/firmware"
+
+// raspiosUnameFiles are the files of the firmware releases telling the kernel release of every flavor.
+var raspiosUnameFiles = map[string]string{
+	"":     "uname_string",
+	"v7":   "uname_string7",
+	"v7l":  "uname_string7l",
+	"v8":   "uname_string8",
+	"2712": "uname_string_2712",
+}
+
+// raspiosUnamePattern matches the kernel release of a uname string, e.g. Linux version 6.1.21-v8+ (dom@buildbot) ...
+var raspiosUnamePattern = regexp.MustCompile(`^Linux version (\S+)`)
+
+// raspiosKernelURLsFromIndex picks the headers package of the version among the ones of the index of the pool,
+// unless told the latest one shipping the kernel release, failing with the versions found when none does.
+func raspiosKernelURLsFromIndex(ctx context.Context, poolURL, body string, kr kernelrelease.KernelRelease, packageVersion string) ([]string, error) {
+
+	pattern := regexp.MustCompile(fmt.Sprintf(`href="(raspberrypi-kernel-headers_([^_"]+)_%s\.deb)"`, kr.Architecture.String()))
+	packages := map[string]string{}
+	versions := []string{}
+	for _, match := range pattern.FindAllStringSubmatch(body, -1) {
+		if _, ok := packages[match[2]]; !ok {
+			versions = append(versions, match[2])
+		}
+		packages[match[2]] = match[1]
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("kernel headers not found")
+	}
+
+	if packageVersion != "" && packageVersion != "1" {
+		if _, ok := packages[packageVersion]; !ok {
+			return nil, fmt.Errorf("kernel headers not found")
+		}
+		return []string{fmt.Sprintf("%s%s", poolURL, packages[packageVersion])}, nil
+	}
+
+	// package versions are date based (e.g. 1.20230405-1), the latest one sorts last
+	sort.Strings(versions)
+	for i := len(versions) - 1; i >= 0; i-- {
+		if raspiosShipsKernelRelease(ctx, versions[i], kr) {
+			return []string{fmt.Sprintf("%s%s", poolURL, packages[versions[i]])}, nil
+		}
+	}
+	return nil, fmt.Errorf("no raspberrypi-kernel-headers package ships the kernel %s, give the version of the one to use as the kernel version, among %s",
+		raspiosKernelRelease(kr), strings.Join(versions, ", "))
+}
+
+// raspiosShipsKernelRelease tells whether the firmware release the headers package version is built from ships the kernel release.
+func raspiosShipsKernelRelease(ctx context.Context, packageVersion string, kr kernelrelease.KernelRelease) bool {
+	file, ok := raspiosUnameFiles[strings.TrimPrefix(kr.FullExtraversion, "-")]
+	if !ok {
+		return false
+	}
+	tag := strings.SplitN(packageVersion, "-", 2)[0]
+	u := fmt.Sprintf("%s/%s/extra/%s", raspiosFirmwareURL, tag, file)
+	body, err := getIndex(ctx, u)
+	if err != nil {
+		Logger(ctx).WithError(err).WithField("url", u).Debug("skipping firmware release")
+		return false
+	}
+	match := raspiosUnamePattern.FindStringSubmatch(string(body))
+	return match != nil && match[1] == raspiosKernelRelease(kr)
+}
@@ -0,0 +1,85 @@
+package builder
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+func TestRaspiosKernelURLsFromIndex(t *testing.T) {
+	firmware := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/1.20230405/extra/uname_string8":
+			w.Write([]byte("Linux version 6.1.21-v8+ (dom@buildbot) (aarch64-linux-gnu-gcc-8 (Ubuntu/Linaro 8.4.0-3ubuntu1) 8.4.0) #1642 SMP PREEMPT\n"))
+		case "/1.20230509/extra/uname_string8":
+			w.Write([]byte("Linux version 6.1.27-v8+ (dom@buildbot) (aarch64-linux-gnu-gcc-8 (Ubuntu/Linaro 8.4.0-3ubuntu1) 8.4.0) #1647 SMP PREEMPT\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer firmware.Close()
+	oldFirmwareURL := raspiosFirmwareURL
+	raspiosFirmwareURL = firmware.URL
+	t.Cleanup(func() { raspiosFirmwareURL = oldFirmwareURL })
+
+	poolURL := "http://archive.raspberrypi.org/debian/pool/main/r/raspberrypi-firmware/"
+	index := `<a href="raspberrypi-kernel-headers_1.20230405-1_arm64.deb">raspberrypi-kernel-headers_1.20230405-1_arm64.deb</a>
+<a href="raspberrypi-kernel-headers_1.20230509-1_arm64.deb">raspberrypi-kernel-headers_1.20230509-1_arm64.deb</a>
+<a href="raspberrypi-kernel-headers_1.20230509-1_armhf.deb">raspberrypi-kernel-headers_1.20230509-1_armhf.deb</a>`
+
+	tests := map[string]struct {
+		kernelrelease  string
+		packageVersion string
+		want           string
+		wantErr        string
+	}{
+		"latest release": {
+			kernelrelease:  "6.1.27-v8+",
+			packageVersion: "1",
+			want:           poolURL + "raspberrypi-kernel-headers_1.20230509-1_arm64.deb",
+		},
+		"older release": {
+			kernelrelease:  "6.1.21-v8+",
+			packageVersion: "1",
+			want:           poolURL + "raspberrypi-kernel-headers_1.20230405-1_arm64.deb",
+		},
+		"given package version": {
+			kernelrelease:  "6.1.27-v8+",
+			packageVersion: "1.20230405-1",
+			want:           poolURL + "raspberrypi-kernel-headers_1.20230405-1_arm64.deb",
+		},
+		"release not shipped": {
+			kernelrelease:  "6.1.19-v8+",
+			packageVersion: "1",
+			wantErr:        "among 1.20230405-1, 1.20230509-1",
+		},
+		"flavor not shipped": {
+			kernelrelease:  "6.1.21-v7l+",
+			packageVersion: "1",
+			wantErr:        "no raspberrypi-kernel-headers package ships the kernel 6.1.21-v7l+",
+		},
+	}
+
+	for name, test := range tests {
+		kr := kernelrelease.FromString(test.kernelrelease)
+		kr.Architecture = "arm64"
+		urls, err := raspiosKernelURLsFromIndex(context.Background(), poolURL, index, kr, test.packageVersion)
+		if len(test.wantErr) > 0 {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("Unexpected error with Test Input: '%s' | Got: '%v' / Want: '%s'", name, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error with Test Input: '%s' | Got: '%v'", name, err)
+			continue
+		}
+		if len(urls) != 1 || urls[0] != test.want {
+			t.Errorf("Test Input: '%s' | Got: '%v' / Want: '%s'", name, urls, test.want)
+		}
+	}
+}
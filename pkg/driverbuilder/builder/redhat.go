@@ -1,10 +1,18 @@
 package builder
 
 import (
-	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	_ "embed"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
-	"text/template"
 )
 
 //go:embed templates/redhat.sh
@@ -13,6 +21,22 @@ var redhatTemplate string
 // TargetTypeRedhat identifies the redhat target.
 const TargetTypeRedhat Type = "redhat"
 
+// RHELEntitlementDirectory is where the processors copy the entitlement of the build into the builder,
+// the build script removes it once the kernel is downloaded.
+const RHELEntitlementDirectory = "/tmp/driverkit-entitlement"
+
+var (
+	// RHELEntitlementCertPath and RHELEntitlementKeyPath are the files of the entitlement into the builder.
+	RHELEntitlementCertPath = path.Join(RHELEntitlementDirectory, "entitlement.pem")
+	RHELEntitlementKeyPath  = path.Join(RHELEntitlementDirectory, "entitlement-key.pem")
+)
+
+// redhatCDNURL is the Red Hat CDN, the one serving the packages of the subscriptions.
+var redhatCDNURL = "https://cdn.redhat.com"
+
+// redhatReleasePattern matches the major and, if any, the minor version of the dist tag of the RHEL kernel releases, e.g. el8_6.
+var redhatReleasePattern = regexp.MustCompile(`\.el(\d+)(?:_(\d+))?`)
+
 // redhat is a driverkit target.
 type redhat struct {
 }
@@ -21,37 +45,178 @@ func init() {
 	BuilderByTarget[TargetTypeRedhat] = &redhat{}
 }
 
+// Metadata implements MetadataProvider, the builds run on the registered builder image, which has no cross toolchain.
+func (v redhat) Metadata() Metadata {
+	m := DefaultMetadata()
+	m.CrossCompile = false
+	return m
+}
+
 type redhatTemplateData struct {
-	DriverBuildDir    string
-	KernelPackage     string
-	ModuleDownloadURL string
-	ModuleDriverName  string
-	ModuleFullPath    string
-	BuildModule       bool
-	BuildProbe        bool
-}
-
-func (v redhat) Script(cfg Config, kr kernelrelease.KernelRelease) (string, error) {
-	t := template.New(string(TargetTypeRedhat))
-	parsed, err := t.Parse(redhatTemplate)
+	buildTemplateData
+	DriverBuildDir          string
+	KernelPackage           string
+	KernelDownloadURL       string
+	KernelChecksum          string
+	RHELEntitlementDir      string
+	RHELEntitlementCertPath string
+	RHELEntitlementKeyPath  string
+	ModuleDownloadURL       string
+	ModuleDriverName        string
+	ModuleFullPath          string
+	BuildModule             bool
+	BuildProbe              bool
+	GCCVersion              string
+}
+
+// Script compiles the script to build the kernel module and/or the eBPF probe.
+// With an entitlement the kernel is downloaded from the Red Hat CDN, otherwise it is installed with the repositories of the builder image.
+func (v redhat) Script(cfg Config, kr kernelrelease.KernelRelease, sources KernelSources) (string, error) {
+	parsed, err := parseTemplate(cfg, string(TargetTypeRedhat), redhatTemplate, redhatTemplateData{})
 	if err != nil {
 		return "", err
 	}
+	return executeTemplate(parsed, sources.TemplateData)
+}
 
+// ResolveKernelSources implements Builder, resolving the kernel package from the Red Hat CDN when the build is entitled to.
+func (v redhat) ResolveKernelSources(ctx context.Context, cfg Config, kr kernelrelease.KernelRelease) (KernelSources, error) {
 	td := redhatTemplateData{
-		DriverBuildDir:    DriverDirectory,
-		KernelPackage:     kr.Fullversion + kr.FullExtraversion,
-		ModuleDownloadURL: moduleDownloadURL(cfg),
-		ModuleDriverName:  cfg.DriverName,
-		ModuleFullPath:    ModuleFullPath,
-		BuildModule:       len(cfg.Build.ModuleFilePath) > 0,
-		BuildProbe:        len(cfg.Build.ProbeFilePath) > 0,
+		DriverBuildDir:          DriverDirectory,
+		KernelPackage:           kr.Fullversion + kr.FullExtraversion,
+		RHELEntitlementDir:      RHELEntitlementDirectory,
+		RHELEntitlementCertPath: RHELEntitlementCertPath,
+		RHELEntitlementKeyPath:  RHELEntitlementKeyPath,
+		ModuleDownloadURL:       moduleDownloadURL(cfg),
+		ModuleDriverName:        cfg.DriverName,
+		ModuleFullPath:          ModuleFullPath,
+		BuildModule:             len(cfg.Build.ModuleFilePath) > 0,
+		BuildProbe:              len(cfg.Build.ProbeFilePath) > 0,
+		GCCVersion:              cfg.GCCVersion,
+	}
+	td.buildTemplateData = newBuildTemplateData(cfg)
+
+	if len(cfg.RHELEntitlementCert) > 0 {
+		cert, err := loadRHELEntitlement(cfg.RHELEntitlementCert, cfg.RHELEntitlementKey, time.Now())
+		if err != nil {
+			return KernelSources{}, err
+		}
+		client := httpClientFrom(ctx).withClientCertificate(cert)
+		if cfg.KernelUrls == nil {
+			td.KernelDownloadURL, err = resolveRedhatKernelURL(ctx, client, redhatKernelURLs(kr))
+		} else {
+			td.KernelDownloadURL, err = resolveRedhatKernelURL(ctx, client, cfg.KernelUrls)
+		}
+		if err != nil {
+			return KernelSources{}, err
+		}
+		lookup := rpmChecksumsWith(func(u string) ([]byte, error) { return indexes.get(client, u) })
+		sums, err := kernelChecksums(ctx, cfg, []string{td.KernelDownloadURL}, lookup)
+		if err != nil {
+			return KernelSources{}, err
+		}
+		td.KernelChecksum = sums[td.KernelDownloadURL]
 	}
+	sources := KernelSources{TemplateData: td}
+	if len(td.KernelDownloadURL) > 0 {
+		sources.URLs = []string{td.KernelDownloadURL}
+	}
+	return sources, nil
+}
 
-	buf := bytes.NewBuffer(nil)
-	err = parsed.Execute(buf, td)
+// loadRHELEntitlement returns the key pair of the entitlement, failing when it is expired at the given time.
+// The errors never hold the entitlement.
+func loadRHELEntitlement(certPEM, keyPEM []byte, now time.Time) (tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
 	if err != nil {
-		return "", err
+		return tls.Certificate{}, fmt.Errorf("invalid RHEL entitlement, the certificate and the key must be the PEM encoded ones of /etc/pki/entitlement: %s", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("invalid RHEL entitlement certificate: %s", err)
+	}
+	if now.After(leaf.NotAfter) {
+		return tls.Certificate{}, fmt.Errorf("the RHEL entitlement expired on %s, refresh it with 'subscription-manager refresh' on the subscribed system", leaf.NotAfter.UTC().Format(time.RFC3339))
+	}
+	if now.Before(leaf.NotBefore) {
+		return tls.Certificate{}, fmt.Errorf("the RHEL entitlement is not valid before %s", leaf.NotBefore.UTC().Format(time.RFC3339))
+	}
+	return cert, nil
+}
+
+// redhatKernelURLs returns the candidates of the kernel-devel package of the kernel on the Red Hat CDN:
+// the repositories of its minor release first, the EUS ones then the GA ones, then the ones of its major release.
+// Example: 4.18.0-372.9.1.el8_6.x86_64 -> https://cdn.redhat.com/content/eus/rhel8/8.6/x86_64/baseos/os/Packages/k/kernel-devel-4.18.0-372.9.1.el8_6.x86_64.rpm
+func redhatKernelURLs(kr kernelrelease.KernelRelease) []string {
+	match := redhatReleasePattern.FindStringSubmatch(kr.FullExtraversion)
+	if match == nil {
+		return nil
+	}
+	major, minor := match[1], match[2]
+	arch := kr.Architecture.ToNonDeb()
+	pkg := fmt.Sprintf("kernel-devel-%s%s.rpm", kr.Fullversion, kr.FullExtraversion)
+
+	// the RHEL 7 repositories have their own layout, without BaseOS and AppStream
+	if major == "7" {
+		releases := []string{"7Server"}
+		if len(minor) > 0 {
+			releases = append([]string{"7." + minor}, releases...)
+		}
+		urls := []string{}
+		for _, r := range releases {
+			urls = append(urls, fmt.Sprintf("%s/content/dist/rhel/server/7/%s/%s/os/Packages/k/%s", redhatCDNURL, r, arch, pkg))
+		}
+		return urls
+	}
+
+	roots := []string{}
+	if len(minor) > 0 {
+		roots = append(roots,
+			fmt.Sprintf("content/eus/rhel%s/%s.%s", major, major, minor),
+			fmt.Sprintf("content/dist/rhel%s/%s.%s", major, major, minor),
+		)
+	}
+	roots = append(roots, fmt.Sprintf("content/dist/rhel%s/%s", major, major))
+	urls := []string{}
+	for _, root := range roots {
+		for _, repo := range []string{"baseos", "appstream"} {
+			urls = append(urls, fmt.Sprintf("%s/%s/%s/%s/os/Packages/k/%s", redhatCDNURL, root, arch, repo, pkg))
+		}
+	}
+	return urls
+}
+
+// resolveRedhatKernelURL returns the first candidate found on the Red Hat CDN, with the client authenticating with the entitlement.
+// It fails with an error of its own when the entitlement is denied the access to every candidate.
+func resolveRedhatKernelURL(ctx context.Context, client *retryClient, candidates []string) (string, error) {
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("no candidate of the kernel on the Red Hat CDN, the kernel release must end with the .elN or .elN_M dist tag")
+	}
+	denied := 0
+	for _, u := range candidates {
+		Logger(ctx).WithField("url", RedactURL(u)).Debug("trying kernel url")
+		res, err := client.Head(u)
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			Logger(ctx).WithError(err).WithField("url", RedactURL(u)).Debug("unable to reach the kernel url")
+			continue
+		}
+		res.Body.Close()
+		switch res.StatusCode {
+		case http.StatusOK:
+			Logger(ctx).WithField("url", RedactURL(u)).Debug("kernel header url found")
+			if r, ok := ctx.Value(resolvedURLsKey{}).(*resolvedURLs); ok {
+				r.add([]string{u})
+			}
+			return u, nil
+		case http.StatusUnauthorized, http.StatusForbidden:
+			denied++
+		}
+	}
+	if denied == len(candidates) {
+		return "", fmt.Errorf("the RHEL entitlement is denied the access to the kernel packages, it must be the one of a subscription covering the release of the kernel")
 	}
-	return buf.String(), nil
+	return "", fmt.Errorf("kernel not found on the Red Hat CDN, tried:\n  %s", strings.Join(candidates, "\n  "))
 }
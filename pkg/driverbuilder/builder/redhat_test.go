@@ -0,0 +1,226 @@
+package builder
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+// newTestEntitlement returns a self-signed entitlement valid from notBefore to notAfter, PEM encoded.
+func newTestEntitlement(t *testing.T, notBefore, notAfter time.Time) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "entitlement"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestRedhatKernelURLs(t *testing.T) {
+	tests := map[string]struct {
+		kernelrelease string
+		arch          kernelrelease.Architecture
+		expected      []string
+	}{
+		"rhel 8 minor release": {
+			kernelrelease: "4.18.0-372.9.1.el8_6.x86_64",
+			arch:          "amd64",
+			expected: []string{
+				"https://cdn.redhat.com/content/eus/rhel8/8.6/x86_64/baseos/os/Packages/k/kernel-devel-4.18.0-372.9.1.el8_6.x86_64.rpm",
+				"https://cdn.redhat.com/content/eus/rhel8/8.6/x86_64/appstream/os/Packages/k/kernel-devel-4.18.0-372.9.1.el8_6.x86_64.rpm",
+				"https://cdn.redhat.com/content/dist/rhel8/8.6/x86_64/baseos/os/Packages/k/kernel-devel-4.18.0-372.9.1.el8_6.x86_64.rpm",
+				"https://cdn.redhat.com/content/dist/rhel8/8.6/x86_64/appstream/os/Packages/k/kernel-devel-4.18.0-372.9.1.el8_6.x86_64.rpm",
+				"https://cdn.redhat.com/content/dist/rhel8/8/x86_64/baseos/os/Packages/k/kernel-devel-4.18.0-372.9.1.el8_6.x86_64.rpm",
+				"https://cdn.redhat.com/content/dist/rhel8/8/x86_64/appstream/os/Packages/k/kernel-devel-4.18.0-372.9.1.el8_6.x86_64.rpm",
+			},
+		},
+		"rhel 9 major release": {
+			kernelrelease: "5.14.0-70.13.1.el9.aarch64",
+			arch:          "arm64",
+			expected: []string{
+				"https://cdn.redhat.com/content/dist/rhel9/9/aarch64/baseos/os/Packages/k/kernel-devel-5.14.0-70.13.1.el9.aarch64.rpm",
+				"https://cdn.redhat.com/content/dist/rhel9/9/aarch64/appstream/os/Packages/k/kernel-devel-5.14.0-70.13.1.el9.aarch64.rpm",
+			},
+		},
+		"rhel 7": {
+			kernelrelease: "3.10.0-1160.el7.x86_64",
+			arch:          "amd64",
+			expected: []string{
+				"https://cdn.redhat.com/content/dist/rhel/server/7/7Server/x86_64/os/Packages/k/kernel-devel-3.10.0-1160.el7.x86_64.rpm",
+			},
+		},
+		"not el": {
+			kernelrelease: "5.14.10-300.fc35.x86_64",
+			arch:          "amd64",
+		},
+	}
+
+	for name, test := range tests {
+		kr := kernelrelease.FromString(test.kernelrelease)
+		kr.Architecture = test.arch
+		got := redhatKernelURLs(kr)
+		if len(got) != len(test.expected) {
+			t.Fatalf("Slice sizes don't match! Test Input: '%s' | Got: '%v' / Want: '%v'", name, got, test.expected)
+		}
+		for i, v := range got {
+			if v != test.expected[i] {
+				t.Errorf("Slice values don't match! Test Input: '%s' | Got: '%v' / Want: '%v'", name, got, test.expected)
+			}
+		}
+	}
+}
+
+func TestLoadRHELEntitlement(t *testing.T) {
+	now := time.Now()
+	validCert, validKey := newTestEntitlement(t, now.Add(-time.Hour), now.Add(time.Hour))
+	expiredCert, expiredKey := newTestEntitlement(t, now.Add(-2*time.Hour), now.Add(-time.Hour))
+	_, otherKey := newTestEntitlement(t, now.Add(-time.Hour), now.Add(time.Hour))
+
+	tests := map[string]struct {
+		cert []byte
+		key  []byte
+		err  string
+	}{
+		"valid":         {validCert, validKey, ""},
+		"expired":       {expiredCert, expiredKey, "the RHEL entitlement expired on "},
+		"mismatch":      {validCert, otherKey, "invalid RHEL entitlement"},
+		"not pem":       {[]byte("not a certificate"), validKey, "invalid RHEL entitlement"},
+		"not yet valid": {validCert, validKey, "the RHEL entitlement is not valid before "},
+	}
+	for name, test := range tests {
+		at := now
+		if name == "not yet valid" {
+			at = now.Add(-2 * time.Hour)
+		}
+		_, err := loadRHELEntitlement(test.cert, test.key, at)
+		if len(test.err) == 0 {
+			if err != nil {
+				t.Errorf("Unexpected error encountered with Test Input: '%s' | Error: '%s'", name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Test Input: '%s' | Got: '%v' / Want: '%s'", name, err, test.err)
+			continue
+		}
+		if strings.Contains(err.Error(), "BEGIN") {
+			t.Errorf("Test Input: '%s' | The error holds the entitlement: '%s'", name, err)
+		}
+	}
+}
+
+// newTestCDN returns a TLS server requiring a client certificate, serving the kernel packages of the given paths and denying the others.
+func newTestCDN(t *testing.T, paths ...string) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		for _, p := range paths {
+			if r.URL.Path == p {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	c := newRetryClient(context.Background(), time.Second, 1)
+	c.client.Transport = server.Client().Transport
+	withHTTPClient(t, c)
+	return server
+}
+
+func TestResolveRedhatKernelURL(t *testing.T) {
+	server := newTestCDN(t, "/dist/kernel-devel.rpm")
+	cert, key := newTestEntitlement(t, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	entitlement, err := loadRHELEntitlement(cert, key, time.Now())
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	entitled := httpClient.withClientCertificate(entitlement)
+
+	got, err := resolveRedhatKernelURL(context.Background(), entitled, []string{server.URL + "/eus/kernel-devel.rpm", server.URL + "/dist/kernel-devel.rpm"})
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if want := server.URL + "/dist/kernel-devel.rpm"; got != want {
+		t.Errorf("Got: '%s' / Want: '%s'", got, want)
+	}
+
+	// the CDN denies the requests without an entitlement
+	_, err = resolveRedhatKernelURL(context.Background(), httpClient, []string{server.URL + "/dist/kernel-devel.rpm"})
+	if err == nil || !strings.Contains(err.Error(), "the RHEL entitlement is denied the access") {
+		t.Errorf("Got: '%v' / Want: 'the RHEL entitlement is denied the access ...'", err)
+	}
+}
+
+func TestRedhatScriptEntitlement(t *testing.T) {
+	server := newTestCDN(t, "/kernel-devel-4.18.0-372.9.1.el8_6.x86_64.rpm")
+	cert, key := newTestEntitlement(t, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	kernelURL := server.URL + "/kernel-devel-4.18.0-372.9.1.el8_6.x86_64.rpm"
+	cfg := Config{
+		DriverName:          "falco",
+		DownloadBaseURL:     "https://github.com/falcosecurity/libs/archive",
+		RHELEntitlementCert: cert,
+		RHELEntitlementKey:  key,
+		Build: &Build{
+			TargetType:     TargetTypeRedhat,
+			KernelRelease:  "4.18.0-372.9.1.el8_6.x86_64",
+			Architecture:   "amd64",
+			DriverVersion:  "master",
+			ModuleFilePath: "/tmp/falco.ko",
+			KernelUrls:     []string{kernelURL},
+			SkipChecksum:   true,
+		},
+	}
+	script, err := Script(context.Background(), redhat{}, cfg, cfg.KernelReleaseFromBuildConfig())
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	for _, want := range []string{
+		"--cert " + RHELEntitlementCertPath + " --key " + RHELEntitlementKeyPath,
+		kernelURL,
+		"rm -rf " + RHELEntitlementDirectory,
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("Script does not contain: [ '%s' ]\n%s", want, script)
+		}
+	}
+	if strings.Contains(script, "yum install -y --downloadonly") {
+		t.Errorf("Script installs the kernel from the repositories of the builder image\n%s", script)
+	}
+	if strings.Contains(script, "BEGIN") {
+		t.Errorf("Script holds the entitlement\n%s", script)
+	}
+}
@@ -1,10 +1,10 @@
 package builder
 
 import (
-	"bytes"
+	"context"
 	_ "embed"
 	"fmt"
-	"text/template"
+	"regexp"
 
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 )
@@ -15,6 +15,9 @@ var rockyTemplate string
 // TargetTypeRocky identifies the Rocky target.
 const TargetTypeRocky Type = "rocky"
 
+// elReleasePattern matches the EL release in kernel releases like 4.18.0-425.3.1.el8_7.x86_64.
+var elReleasePattern = regexp.MustCompile(`\.el(\d+)(?:_(\d+))?`)
+
 func init() {
 	BuilderByTarget[TargetTypeRocky] = &rocky{}
 }
@@ -23,72 +26,150 @@ func init() {
 type rocky struct {
 }
 
+// rockyBaseURLs are the roots of the releases, the older minor ones are moved to the vault.
+var rockyBaseURLs = []string{
+	"https://download.rockylinux.org/pub/rocky",
+	"https://dl.rockylinux.org/vault/rocky",
+}
+
+// Mirrors implements MirrorsProvider.
+func (c rocky) Mirrors(arch kernelrelease.Architecture) []string {
+	return rockyBaseURLs
+}
+
 // Script compiles the script to build the kernel module and/or the eBPF probe.
-func (c rocky) Script(cfg Config, kr kernelrelease.KernelRelease) (string, error) {
-	t := template.New(string(TargetTypeRocky))
-	parsed, err := t.Parse(rockyTemplate)
+func (c rocky) Script(cfg Config, kr kernelrelease.KernelRelease, sources KernelSources) (string, error) {
+	return elCloneScript(TargetTypeRocky, cfg, sources)
+}
+
+// ResolveKernelSources implements Builder, resolving the kernel packages of the build.
+func (c rocky) ResolveKernelSources(ctx context.Context, cfg Config, kr kernelrelease.KernelRelease) (KernelSources, error) {
+	return resolveELCloneKernelSources(ctx, cfg, kr, func(kr kernelrelease.KernelRelease) ([]string, error) {
+		return fetchRockyKernelURLS(ctx, kr)
+	})
+}
+
+// elCloneScript compiles the script for the RHEL clones (Rocky, AlmaLinux, Oracle Linux)
+// and the distros sharing their kernel-devel layout (openEuler),
+// they only differ in the way their repositories are laid out.
+func elCloneScript(target Type, cfg Config, sources KernelSources) (string, error) {
+	parsed, err := parseTemplate(cfg, string(target), rockyTemplate, rockyTemplateData{})
 	if err != nil {
 		return "", err
 	}
+	return executeTemplate(parsed, sources.TemplateData)
+}
 
+// resolveELCloneKernelSources returns the data of the template of the RHEL clones, resolving the kernel package among the candidates of fetchURLs.
+func resolveELCloneKernelSources(ctx context.Context, cfg Config, kr kernelrelease.KernelRelease, fetchURLs func(kernelrelease.KernelRelease) ([]string, error)) (KernelSources, error) {
 	var urls []string
+	var err error
 	if cfg.KernelUrls == nil {
+		var kurls []string
+		kurls, err = fetchURLs(kr)
+		if err != nil {
+			return KernelSources{}, err
+		}
 		// Check (and filter) existing kernels before continuing
-		urls, err = getResolvingURLs(fetchRockyKernelURLS(kr))
+		urls, err = getResolvingURLs(ctx, kurls)
 	} else {
-		urls, err = getResolvingURLs(cfg.KernelUrls)
+		urls, err = getResolvingURLs(ctx, cfg.KernelUrls)
 	}
 	if err != nil {
-		return "", err
+		return KernelSources{}, err
+	}
+
+	sums, err := kernelChecksums(ctx, cfg, urls[:1], rpmChecksums)
+	if err != nil {
+		return KernelSources{}, err
+	}
+
+	crossCompilePrefix, err := crossCompile(cfg)
+	if err != nil {
+		return KernelSources{}, err
 	}
 
 	td := rockyTemplateData{
 		DriverBuildDir:    DriverDirectory,
 		ModuleDownloadURL: moduleDownloadURL(cfg),
 		KernelDownloadURL: urls[0],
-		GCCVersion:        rockyGccVersionFromKernelRelease(kr),
+		KernelChecksum:    sums[urls[0]],
+		GCCVersion:        gccVersion(cfg, rockyGccVersionFromKernelRelease(kr)),
 		ModuleDriverName:  cfg.DriverName,
 		ModuleFullPath:    ModuleFullPath,
 		BuildModule:       len(cfg.Build.ModuleFilePath) > 0,
 		BuildProbe:        len(cfg.Build.ProbeFilePath) > 0,
+		KernelArch:        kr.Architecture.ToKernel(),
+		LLVMVersion:       llvmVersion(cfg, "7"),
+		CrossCompile:      crossCompilePrefix,
 	}
+	td.buildTemplateData = newBuildTemplateData(cfg)
+	return KernelSources{URLs: urls[:1], TemplateData: td}, nil
+}
 
-	buf := bytes.NewBuffer(nil)
-	err = parsed.Execute(buf, td)
-	if err != nil {
-		return "", err
+// elReleasesFromKernelRelease returns the repository releases that could contain the kernel,
+// ordered from the most specific one.
+// Example: Input -> "-425.3.1.el8_7.x86_64", Output -> ["8.7", "8"]
+// When the minor release is missing from the kernel release, every known minor release is returned.
+func elReleasesFromKernelRelease(kr kernelrelease.KernelRelease, knownReleases map[string][]string) ([]string, error) {
+	match := elReleasePattern.FindStringSubmatch(kr.FullExtraversion)
+	if len(match) != 3 {
+		return nil, fmt.Errorf("unable to find the el release in the kernel release: %s", kr.FullExtraversion)
+	}
+	major, minor := match[1], match[2]
+	if minor != "" {
+		return []string{fmt.Sprintf("%s.%s", major, minor), major}, nil
 	}
-	return buf.String(), nil
+	return append([]string{major}, knownReleases[major]...), nil
+}
+
+var rockyVaultReleases = map[string][]string{
+	"8": {"8.10", "8.9", "8.8", "8.7", "8.6", "8.5", "8.4", "8.3"},
+	"9": {"9.4", "9.3", "9.2", "9.1", "9.0"},
 }
 
-func fetchRockyKernelURLS(kr kernelrelease.KernelRelease) []string {
-	rockyReleases := []string{
-		"8",
-		"8.5",
+func fetchRockyKernelURLS(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
+	releases, err := elReleasesFromKernelRelease(kr, rockyVaultReleases)
+	if err != nil {
+		return nil, err
 	}
 
+	// kernel-devel is shipped in BaseOS on el8 and in AppStream on el9
+	repos := []string{"BaseOS", "AppStream"}
+
 	urls := []string{}
-	for _, r := range rockyReleases {
-		urls = append(urls, fmt.Sprintf(
-			"https://download.rockylinux.org/pub/rocky/%s/BaseOS/%s/os/Packages/k/kernel-devel-%s%s.rpm",
-			r,
-			kr.Architecture.ToNonDeb(),
-			kr.Fullversion,
-			kr.FullExtraversion,
-		))
+	for _, b := range mirrorsFrom(ctx, rockyBaseURLs) {
+		for _, r := range releases {
+			for _, repo := range repos {
+				urls = append(urls, fmt.Sprintf(
+					"%s/%s/%s/%s/os/Packages/k/kernel-devel-%s%s.rpm",
+					b,
+					r,
+					repo,
+					kr.Architecture.ToNonDeb(),
+					kr.Fullversion,
+					kr.FullExtraversion,
+				))
+			}
+		}
 	}
-	return urls
+	return urls, nil
 }
 
 type rockyTemplateData struct {
+	buildTemplateData
 	DriverBuildDir    string
 	ModuleDownloadURL string
 	KernelDownloadURL string
+	KernelChecksum    string
 	GCCVersion        string
 	ModuleDriverName  string
 	ModuleFullPath    string
 	BuildModule       bool
 	BuildProbe        bool
+	KernelArch        string
+	LLVMVersion       string
+	CrossCompile      string
 }
 
 func rockyGccVersionFromKernelRelease(kr kernelrelease.KernelRelease) string {
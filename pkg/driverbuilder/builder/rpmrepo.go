@@ -0,0 +1,297 @@
+package builder
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// rpmRepository is a yum repository, given by the base URLs of the mirrors serving it, in order, or by their metalink.
+type rpmRepository struct {
+	baseURLs []string
+	metalink string
+}
+
+func (r rpmRepository) String() string {
+	if len(r.metalink) > 0 {
+		return r.metalink
+	}
+	return strings.Join(r.baseURLs, ", ")
+}
+
+// rpmQuery selects packages by NEVRA, the empty fields match any value.
+type rpmQuery struct {
+	name    *regexp.Regexp
+	version string
+	release string
+	arches  []string
+}
+
+func (q rpmQuery) matches(p rpmPrimaryPackage) bool {
+	if q.name != nil && !q.name.MatchString(p.Name) {
+		return false
+	}
+	if (len(q.version) > 0 && p.Version.Ver != q.version) || (len(q.release) > 0 && p.Version.Rel != q.release) {
+		return false
+	}
+	if len(q.arches) == 0 {
+		return true
+	}
+	for _, a := range q.arches {
+		if p.Arch == a {
+			return true
+		}
+	}
+	return false
+}
+
+// rpmRepoPackage is a package of a repository, with its URL.
+type rpmRepoPackage struct {
+	rpmPrimaryPackage
+	URL string
+}
+
+type rpmRepomd struct {
+	Data []struct {
+		Type     string `xml:"type,attr"`
+		Location struct {
+			Href string `xml:"href,attr"`
+		} `xml:"location"`
+	} `xml:"data"`
+}
+
+type rpmPrimaryPackage struct {
+	Name    string `xml:"name"`
+	Arch    string `xml:"arch"`
+	Version struct {
+		Ver string `xml:"ver,attr"`
+		Rel string `xml:"rel,attr"`
+	} `xml:"version"`
+	Checksum struct {
+		Type  string `xml:"type,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"checksum"`
+	Location struct {
+		Href string `xml:"href,attr"`
+	} `xml:"location"`
+}
+
+// sha256 returns the SHA256 sum of the package, if the primary metadata has it.
+func (p rpmPrimaryPackage) sha256() string {
+	if p.Checksum.Type != "sha256" {
+		return ""
+	}
+	return strings.TrimSpace(p.Checksum.Value)
+}
+
+type rpmMetalink struct {
+	Files []struct {
+		Name string `xml:"name,attr"`
+		URLs []struct {
+			Protocol   string `xml:"protocol,attr"`
+			Preference int    `xml:"preference,attr"`
+			Value      string `xml:",chardata"`
+		} `xml:"resources>url"`
+	} `xml:"files>file"`
+}
+
+// roots returns the base URLs of the repository, the mirrors of its metalink by preference.
+func (r rpmRepository) roots(get func(u string) ([]byte, error)) ([]string, error) {
+	if len(r.metalink) == 0 {
+		roots := make([]string, 0, len(r.baseURLs))
+		for _, u := range r.baseURLs {
+			roots = append(roots, strings.TrimSuffix(u, "/"))
+		}
+		return roots, nil
+	}
+	body, err := get(r.metalink)
+	if err != nil {
+		return nil, err
+	}
+	metalink := rpmMetalink{}
+	if err := xml.Unmarshal(body, &metalink); err != nil {
+		return nil, err
+	}
+	roots := []string{}
+	for _, f := range metalink.Files {
+		if f.Name != "repomd.xml" {
+			continue
+		}
+		urls := f.URLs
+		sort.SliceStable(urls, func(i, j int) bool { return urls[i].Preference > urls[j].Preference })
+		for _, u := range urls {
+			if u.Protocol != "http" && u.Protocol != "https" {
+				continue
+			}
+			roots = append(roots, strings.TrimSuffix(strings.TrimSpace(u.Value), "/repodata/repomd.xml"))
+		}
+	}
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("no mirror found in %s", r.metalink)
+	}
+	return roots, nil
+}
+
+// packages returns the base URL of the repository and its packages, trying its mirrors in order.
+func (r rpmRepository) packages(ctx context.Context, get func(u string) ([]byte, error)) (string, []rpmPrimaryPackage, error) {
+	roots, err := r.roots(get)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(roots) == 0 {
+		return "", nil, fmt.Errorf("repository without mirrors")
+	}
+	var lastErr error
+	for _, root := range roots {
+		packages, err := rpmRepoMetadata(get, root)
+		if err == nil {
+			return root, packages, nil
+		}
+		if ctx.Err() != nil {
+			return "", nil, ctx.Err()
+		}
+		Logger(ctx).WithError(err).WithField("url", root).Debug("skipping mirror")
+		lastErr = err
+	}
+	if len(roots) > 1 {
+		return "", nil, fmt.Errorf("no mirror of %s serves its metadata, the last one failed with: %s", r, lastErr)
+	}
+	return "", nil, lastErr
+}
+
+// find returns the packages of the repository matching the query, none when it has no such package.
+func (r rpmRepository) find(ctx context.Context, get func(u string) ([]byte, error), q rpmQuery) ([]rpmRepoPackage, error) {
+	root, packages, err := r.packages(ctx, get)
+	if err != nil {
+		return nil, err
+	}
+	found := []rpmRepoPackage{}
+	for _, p := range packages {
+		if q.matches(p) {
+			found = append(found, rpmRepoPackage{p, resolveURLReference(root + "/" + p.Location.Href)})
+		}
+	}
+	return found, nil
+}
+
+// rpmPackageChecksums looks for the packages into the ones found in their repositories.
+func rpmPackageChecksums(packages []rpmRepoPackage) checksumLookup {
+	return func(ctx context.Context, urls []string) map[string]string {
+		sums := map[string]string{}
+		for _, p := range packages {
+			if sum := p.sha256(); len(sum) > 0 {
+				sums[p.URL] = sum
+			}
+		}
+		return sums
+	}
+}
+
+// rpmMetadata caches the packages of the primary metadata of the repositories for the lifetime of the process,
+// keyed by the URL of the primary metadata: the repomd.xml is fetched again, with the index cache, and points to a new one once the repository changes.
+var rpmMetadata = struct {
+	sync.Mutex
+	packages map[string][]rpmPrimaryPackage
+}{packages: map[string][]rpmPrimaryPackage{}}
+
+// rpmRepoMetadata returns the packages of the primary metadata of the repository, the one its repodata/repomd.xml points to.
+// The zchunk metadata are never used, the repositories always have the primary one too.
+func rpmRepoMetadata(get func(u string) ([]byte, error), root string) ([]rpmPrimaryPackage, error) {
+	body, err := get(root + "/repodata/repomd.xml")
+	if err != nil {
+		return nil, err
+	}
+	repomd := rpmRepomd{}
+	if err := xml.Unmarshal(body, &repomd); err != nil {
+		return nil, err
+	}
+	href := ""
+	for _, d := range repomd.Data {
+		if d.Type == "primary" {
+			href = d.Location.Href
+		}
+	}
+	if href == "" {
+		return nil, fmt.Errorf("primary metadata not found")
+	}
+	primaryURL := root + "/" + href
+
+	rpmMetadata.Lock()
+	packages, ok := rpmMetadata.packages[primaryURL]
+	rpmMetadata.Unlock()
+	if ok {
+		return packages, nil
+	}
+
+	body, err = get(primaryURL)
+	if err != nil {
+		return nil, err
+	}
+	packages = []rpmPrimaryPackage{}
+	err = rpmPrimaryPackages(bytes.NewReader(body), path.Ext(href), func(p rpmPrimaryPackage) {
+		packages = append(packages, p)
+	})
+	if err != nil {
+		return nil, err
+	}
+	rpmMetadata.Lock()
+	rpmMetadata.packages[primaryURL] = packages
+	rpmMetadata.Unlock()
+	return packages, nil
+}
+
+// rpmPrimaryPackages calls visit with every package of the primary metadata, compressed as its extension tells.
+func rpmPrimaryPackages(r io.Reader, ext string, visit func(p rpmPrimaryPackage)) error {
+	switch ext {
+	case ".gz":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	case ".bz2":
+		r = bzip2.NewReader(r)
+	case ".zst":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	case ".xml":
+	default:
+		return fmt.Errorf("unsupported primary metadata compression: %s", ext)
+	}
+
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "package" {
+			continue
+		}
+		p := rpmPrimaryPackage{}
+		if err := decoder.DecodeElement(&p, &start); err != nil {
+			return err
+		}
+		visit(p)
+	}
+}
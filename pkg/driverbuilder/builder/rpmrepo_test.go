@@ -0,0 +1,157 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const testPrimary = `<?xml version="1.0" encoding="UTF-8"?>
+<metadata xmlns="http://linux.duke.edu/metadata/common" packages="3">
+<package type="rpm">
+  <name>kernel-devel</name>
+  <arch>x86_64</arch>
+  <version epoch="0" ver="4.18.0" rel="425.3.1.el8_7"/>
+  <checksum type="sha256" pkgid="YES">3333333333333333333333333333333333333333333333333333333333333333</checksum>
+  <location href="Packages/k/kernel-devel-4.18.0-425.3.1.el8_7.x86_64.rpm"/>
+</package>
+<package type="rpm">
+  <name>kernel-devel</name>
+  <arch>x86_64</arch>
+  <version epoch="0" ver="4.18.0" rel="372.9.1.el8_6"/>
+  <checksum type="sha256" pkgid="YES">4444444444444444444444444444444444444444444444444444444444444444</checksum>
+  <location href="Packages/k/kernel-devel-4.18.0-372.9.1.el8_6.x86_64.rpm"/>
+</package>
+<package type="rpm">
+  <name>kernel-headers</name>
+  <arch>x86_64</arch>
+  <version epoch="0" ver="4.18.0" rel="425.3.1.el8_7"/>
+  <checksum type="sha256" pkgid="YES">5555555555555555555555555555555555555555555555555555555555555555</checksum>
+  <location href="Packages/k/kernel-headers-4.18.0-425.3.1.el8_7.x86_64.rpm"/>
+</package>
+</metadata>`
+
+func zstded(t *testing.T, s string) []byte {
+	w, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	return w.EncodeAll([]byte(s), nil)
+}
+
+// serveRPMRepo serves a repository having the primary metadata, compressed as the extension tells, and its zchunk one, never to be fetched.
+// It returns the number of times the primary metadata is fetched.
+func serveRPMRepo(t *testing.T, mux *http.ServeMux, root string, ext string, primary []byte) *int {
+	fetched := new(int)
+	mux.HandleFunc(root+"/repodata/repomd.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<repomd xmlns="http://linux.duke.edu/metadata/repo">
+  <data type="primary_zck"><location href="repodata/primary.xml.zck"/></data>
+  <data type="primary"><location href="repodata/primary.xml%s"/></data>
+</repomd>`, ext)
+	})
+	mux.HandleFunc(root+"/repodata/primary.xml"+ext, func(w http.ResponseWriter, r *http.Request) {
+		*fetched++
+		w.Write(primary)
+	})
+	mux.HandleFunc(root+"/repodata/primary.xml.zck", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Unexpected request of the zchunk metadata: '%s'", r.URL)
+	})
+	return fetched
+}
+
+func TestRPMRepositoryFind(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	withHTTPClient(t, newRetryClient(context.Background(), time.Second, 1))
+	serveRPMRepo(t, mux, "/gz", ".gz", gzipped(t, testPrimary))
+	serveRPMRepo(t, mux, "/zst", ".zst", zstded(t, testPrimary))
+	serveRPMRepo(t, mux, "/xml", "", []byte(testPrimary))
+
+	query := rpmQuery{
+		name:    regexp.MustCompile(`^kernel-devel$`),
+		version: "4.18.0",
+		release: "425.3.1.el8_7",
+		arches:  []string{"x86_64"},
+	}
+	for _, root := range []string{"/gz", "/zst", "/xml"} {
+		got, err := rpmRepository{baseURLs: []string{server.URL + root + "/"}}.find(context.Background(), indexGetter(context.Background()), query)
+		if err != nil {
+			t.Errorf("Unexpected error encountered with Test Input: '%s' | Error: '%s'", root, err)
+			continue
+		}
+		want := server.URL + root + "/Packages/k/kernel-devel-4.18.0-425.3.1.el8_7.x86_64.rpm"
+		if len(got) != 1 || got[0].URL != want {
+			t.Errorf("Test Input: '%s' | Got: '%v' / Want: [ '%s' ]", root, got, want)
+			continue
+		}
+		if sum := "3333333333333333333333333333333333333333333333333333333333333333"; got[0].sha256() != sum {
+			t.Errorf("Test Input: '%s' | Got: '%s' / Want: '%s'", root, got[0].sha256(), sum)
+		}
+	}
+
+	query.arches = []string{"aarch64"}
+	got, err := rpmRepository{baseURLs: []string{server.URL + "/gz"}}.find(context.Background(), indexGetter(context.Background()), query)
+	if err != nil || len(got) > 0 {
+		t.Errorf("Got: '%v', '%v' / Want: no package", got, err)
+	}
+}
+
+func TestRPMRepositoryMetalink(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	withHTTPClient(t, newRetryClient(context.Background(), time.Second, 1))
+	serveRPMRepo(t, mux, "/mirror", ".gz", gzipped(t, testPrimary))
+	mux.HandleFunc("/metalink", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>
+<metalink version="3.0" xmlns="http://www.metalinker.org/">
+ <files>
+  <file name="repomd.xml">
+   <resources maxconnections="1">
+    <url protocol="rsync" type="rsync" location="US" preference="100">rsync://mirror.example.com/repodata/repomd.xml</url>
+    <url protocol="https" type="https" location="US" preference="99">%[1]s/mirror/repodata/repomd.xml</url>
+    <url protocol="https" type="https" location="DE" preference="100">%[1]s/broken/repodata/repomd.xml</url>
+   </resources>
+  </file>
+ </files>
+</metalink>`, server.URL)
+	})
+
+	root, packages, err := rpmRepository{metalink: server.URL + "/metalink"}.packages(context.Background(), indexGetter(context.Background()))
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if root != server.URL+"/mirror" || len(packages) != 3 {
+		t.Errorf("Got: '%s' with %d packages / Want: '%s' with 3 packages", root, len(packages), server.URL+"/mirror")
+	}
+}
+
+func TestRPMRepoMetadataCache(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	withHTTPClient(t, newRetryClient(context.Background(), time.Second, 1))
+	fetched := serveRPMRepo(t, mux, "/cached", ".gz", gzipped(t, testPrimary))
+	defaultIndexes := indexes
+	defer func() { indexes = defaultIndexes }()
+
+	for i := 0; i < 2; i++ {
+		// the primary metadata is parsed once even when the index cache no longer has it
+		indexes = newIndexCache("", DefaultCacheTTL)
+		if _, err := rpmRepoMetadata(indexGetter(context.Background()), server.URL+"/cached"); err != nil {
+			t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+		}
+	}
+	if *fetched != 1 {
+		t.Errorf("Primary metadata fetched | Got: %d times / Want: once", *fetched)
+	}
+}
@@ -0,0 +1,204 @@
+package builder
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+//go:embed templates/suse.sh
+var suseTemplate string
+
+// TargetTypeSuse identifies the openSUSE/SLES target.
+const TargetTypeSuse Type = "suse"
+
+// susePatchlevelPattern matches the SLE code stream in kernel releases like 5.14.21-150400.24.46-default.
+var susePatchlevelPattern = regexp.MustCompile(`^(\d{2})(\d{2})\d{2}$`)
+
+func init() {
+	BuilderByTarget[TargetTypeSuse] = &suse{}
+}
+
+// suse is a driverkit target.
+type suse struct {
+}
+
+// suseBaseURLs are the roots of the openSUSE Leap repositories, the SLE kernels are published there too.
+var suseBaseURLs = []string{"https://download.opensuse.org"}
+
+// Mirrors implements MirrorsProvider, the repositories of the Leap release matching the SLE code stream are looked for into.
+func (c suse) Mirrors(arch kernelrelease.Architecture) []string {
+	return suseBaseURLs
+}
+
+type suseTemplateData struct {
+	buildTemplateData
+	DriverBuildDir     string
+	ModuleDownloadURL  string
+	KernelDownloadURLs []string
+	KernelChecksums    map[string]string
+	KernelFlavor       string
+	GCCVersion         string
+	ModuleDriverName   string
+	ModuleFullPath     string
+	BuildModule        bool
+	BuildProbe         bool
+	KernelArch         string
+	LLVMVersion        string
+	CrossCompile       string
+}
+
+// Script compiles the script to build the kernel module and/or the eBPF probe.
+func (c suse) Script(cfg Config, kr kernelrelease.KernelRelease, sources KernelSources) (string, error) {
+	parsed, err := parseTemplate(cfg, string(TargetTypeSuse), suseTemplate, suseTemplateData{})
+	if err != nil {
+		return "", err
+	}
+	return executeTemplate(parsed, sources.TemplateData)
+}
+
+// ResolveKernelSources implements Builder, resolving the kernel packages of the build.
+func (c suse) ResolveKernelSources(ctx context.Context, cfg Config, kr kernelrelease.KernelRelease) (KernelSources, error) {
+	release, flavor, err := parseSuseExtraVersion(kr.FullExtraversion)
+	if err != nil {
+		return KernelSources{}, err
+	}
+
+	var urls []string
+	var attempts []urlAttempt
+	if cfg.KernelUrls == nil {
+		urls, err = suseKernelURLsFromRelease(ctx, kr, release, flavor)
+	} else {
+		urls, attempts, err = resolveURLs(ctx, cfg.KernelUrls)
+	}
+	if err != nil {
+		return KernelSources{}, err
+	}
+	if err := requireURLCategories(suseURLCategories, urls, attempts); err != nil {
+		return KernelSources{}, err
+	}
+
+	sums, err := kernelChecksums(ctx, cfg, urls, rpmChecksums)
+	if err != nil {
+		return KernelSources{}, err
+	}
+
+	crossCompilePrefix, err := crossCompile(cfg)
+	if err != nil {
+		return KernelSources{}, err
+	}
+
+	td := suseTemplateData{
+		DriverBuildDir:     DriverDirectory,
+		ModuleDownloadURL:  moduleDownloadURL(cfg),
+		KernelDownloadURLs: urls,
+		KernelChecksums:    sums,
+		KernelFlavor:       flavor,
+		GCCVersion:         gccVersion(cfg, suseGccVersionFromKernelRelease(kr)),
+		ModuleDriverName:   cfg.DriverName,
+		ModuleFullPath:     ModuleFullPath,
+		BuildModule:        len(cfg.Build.ModuleFilePath) > 0,
+		BuildProbe:         len(cfg.Build.ProbeFilePath) > 0,
+		KernelArch:         kr.Architecture.ToKernel(),
+		LLVMVersion:        llvmVersion(cfg, "12"),
+		CrossCompile:       crossCompilePrefix,
+	}
+	td.buildTemplateData = newBuildTemplateData(cfg)
+	return KernelSources{URLs: urls, TemplateData: td}, nil
+}
+
+// parseSuseExtraVersion splits the full extraversion into the package release and the kernel flavor.
+// Example: Input -> "-150400.24.46-default", Output -> "150400.24.46", "default"
+func parseSuseExtraVersion(fullExtraversion string) (string, string, error) {
+	idx := strings.LastIndex(fullExtraversion, "-")
+	if idx <= 0 {
+		return "", "", fmt.Errorf("unable to parse flavor from suse kernel release extraversion: %s", fullExtraversion)
+	}
+	return strings.TrimPrefix(fullExtraversion[:idx], "-"), fullExtraversion[idx+1:], nil
+}
+
+// suseLeapReleaseFromPackageRelease maps the SLE code stream to the matching openSUSE Leap release,
+// which shares the very same kernel binaries since Leap 15.3.
+// Example: Input -> "150400.24.46", Output -> "15.4"
+func suseLeapReleaseFromPackageRelease(release string) (string, error) {
+	match := susePatchlevelPattern.FindStringSubmatch(strings.Split(release, ".")[0])
+	if len(match) != 3 {
+		return "", fmt.Errorf("unable to find the suse release in the kernel release: %s", release)
+	}
+	major, _ := strconv.Atoi(match[1])
+	sp, _ := strconv.Atoi(match[2])
+	return fmt.Sprintf("%d.%d", major, sp), nil
+}
+
+func suseKernelURLsFromRelease(ctx context.Context, kr kernelrelease.KernelRelease, release, flavor string) ([]string, error) {
+	leap, err := suseLeapReleaseFromPackageRelease(release)
+	if err != nil {
+		return nil, err
+	}
+
+	baseURLs := []string{}
+	for _, m := range mirrorsFrom(ctx, suseBaseURLs) {
+		baseURLs = append(baseURLs,
+			fmt.Sprintf("%s/update/leap/%s/sle", m, leap),
+			fmt.Sprintf("%s/update/leap/%s/oss", m, leap),
+			fmt.Sprintf("%s/distribution/leap/%s/repo/oss", m, leap),
+		)
+	}
+
+	// the rpm release has an additional build counter compared to the uname one
+	develURLs := []string{}
+	flavorDevelURLs := []string{}
+	for _, b := range baseURLs {
+		develURLs = append(develURLs, fmt.Sprintf(
+			"%s/noarch/kernel-devel-%s-%s.1.noarch.rpm",
+			b,
+			kr.Fullversion,
+			release,
+		))
+		flavorDevelURLs = append(flavorDevelURLs, fmt.Sprintf(
+			"%s/%s/kernel-%s-devel-%s-%s.1.%s.rpm",
+			b,
+			kr.Architecture.ToNonDeb(),
+			flavor,
+			kr.Fullversion,
+			release,
+			kr.Architecture.ToNonDeb(),
+		))
+	}
+
+	devel, attempts, err := resolveURLs(ctx, develURLs)
+	if err != nil {
+		return nil, err
+	}
+	if len(devel) == 0 {
+		return nil, fmt.Errorf("kernel-devel not found, tried:\n  %s", formatURLAttempts(attempts))
+	}
+	flavorDevel, attempts, err := resolveURLs(ctx, flavorDevelURLs)
+	if err != nil {
+		return nil, err
+	}
+	if len(flavorDevel) == 0 {
+		return nil, fmt.Errorf("kernel-%s-devel not found, tried:\n  %s", flavor, formatURLAttempts(attempts))
+	}
+	return []string{devel[0], flavorDevel[0]}, nil
+}
+
+// suseURLCategories are the packages a suse build needs one of each:
+// the arch independent sources, e.g. kernel-devel-5.14.21-150400.24.46.1.noarch.rpm, and the build tree of the flavor.
+var suseURLCategories = []urlCategory{
+	{"kernel-devel", func(name string) bool { return strings.HasSuffix(name, ".noarch.rpm") }},
+	{"kernel flavor devel", func(name string) bool { return !strings.HasSuffix(name, ".noarch.rpm") }},
+}
+
+func suseGccVersionFromKernelRelease(kr kernelrelease.KernelRelease) string {
+	switch kr.Version {
+	case 3:
+		return "5"
+	}
+	return "8"
+}
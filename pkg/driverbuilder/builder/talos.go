@@ -0,0 +1,122 @@
+package builder
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+//go:embed templates/talos.sh
+var talosTemplate string
+
+// TargetTypeTalos identifies the Talos target.
+const TargetTypeTalos Type = "talos"
+
+// talosVersionPattern matches Talos versions like v1.5.0.
+var talosVersionPattern = regexp.MustCompile(`^v?(\d+)\.(\d+)\.\d+`)
+
+func init() {
+	BuilderByTarget[TargetTypeTalos] = &talos{}
+}
+
+// talos is a driverkit target.
+type talos struct {
+}
+
+type talosTemplateData struct {
+	buildTemplateData
+	DriverBuildDir     string
+	ModuleDownloadURL  string
+	KernelDownloadURL  string
+	KernelConfigURL    string
+	KernelLocalVersion string
+	ModuleDriverName   string
+	ModuleFullPath     string
+	BuildModule        bool
+	BuildProbe         bool
+	KernelArch         string
+	LLVMVersion        string
+	GCCVersion         string
+	CrossCompile       string
+}
+
+// Script compiles the script to build the kernel module and/or the eBPF probe.
+//
+// The Talos version (e.g. v1.5.0) is expected in the kernel version,
+// the kernel config is then fetched from the siderolabs/pkgs repository.
+// When it cannot be fetched the kernel config data must be provided.
+func (t talos) Script(c Config, kv kernelrelease.KernelRelease, sources KernelSources) (string, error) {
+	parsed, err := parseTemplate(c, string(TargetTypeTalos), talosTemplate, talosTemplateData{})
+	if err != nil {
+		return "", err
+	}
+	return executeTemplate(parsed, sources.TemplateData)
+}
+
+// ResolveKernelSources implements Builder, resolving the kernel packages of the build.
+func (t talos) ResolveKernelSources(ctx context.Context, c Config, kv kernelrelease.KernelRelease) (KernelSources, error) {
+	var urls []string
+	var err error
+	if c.KernelUrls == nil {
+		// Talos kernels are vanilla ones
+		urls, err = getResolvingURLs(ctx, []string{fetchVanillaKernelURLFromKernelVersion(kv)})
+	} else {
+		urls, err = getResolvingURLs(ctx, c.KernelUrls)
+	}
+	if err != nil {
+		return KernelSources{}, err
+	}
+
+	kernelConfigURL := ""
+	if u, err := talosKernelConfigURL(kv.Architecture, c.KernelVersion); err == nil {
+		if kconfURLs, err := getResolvingURLs(ctx, []string{u}); err == nil {
+			kernelConfigURL = kconfURLs[0]
+		}
+	}
+	if kernelConfigURL == "" && !c.HasKernelConfigData() {
+		return KernelSources{}, fmt.Errorf("unable to fetch the talos kernel config, kernel config data is required")
+	}
+
+	crossCompilePrefix, err := crossCompile(c)
+	if err != nil {
+		return KernelSources{}, err
+	}
+
+	td := talosTemplateData{
+		DriverBuildDir:     DriverDirectory,
+		ModuleDownloadURL:  moduleDownloadURL(c),
+		KernelDownloadURL:  urls[0],
+		KernelConfigURL:    kernelConfigURL,
+		KernelLocalVersion: kv.FullExtraversion,
+		ModuleDriverName:   c.DriverName,
+		ModuleFullPath:     ModuleFullPath,
+		BuildModule:        len(c.Build.ModuleFilePath) > 0,
+		BuildProbe:         len(c.Build.ProbeFilePath) > 0,
+		KernelArch:         kv.Architecture.ToKernel(),
+		LLVMVersion:        llvmVersion(c, "12"),
+		GCCVersion:         gccVersion(c, vanillaGCCVersionFromKernelRelease(kv)),
+		CrossCompile:       crossCompilePrefix,
+	}
+	td.buildTemplateData = newBuildTemplateData(c)
+	return KernelSources{URLs: urls[:1], TemplateData: td}, nil
+}
+
+// talosKernelConfigURL returns the kernel config published in the siderolabs/pkgs release matching the Talos one,
+// pkgs are released alongside each Talos minor release.
+// Example: Input -> "v1.5.3", Output -> "https://raw.githubusercontent.com/siderolabs/pkgs/release-1.5/kernel/build/config-amd64"
+func talosKernelConfigURL(architecture kernelrelease.Architecture, talosVersion string) (string, error) {
+	match := talosVersionPattern.FindStringSubmatch(strings.TrimSpace(talosVersion))
+	if len(match) != 3 {
+		return "", fmt.Errorf("unable to find the talos version in the kernel version: %s", talosVersion)
+	}
+	return fmt.Sprintf(
+		"https://raw.githubusercontent.com/siderolabs/pkgs/release-%s.%s/kernel/build/config-%s",
+		match[1],
+		match[2],
+		architecture.String(),
+	), nil
+}
@@ -0,0 +1,220 @@
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// templateFuncs are the functions the templates can use besides the builtin ones.
+var templateFuncs = template.FuncMap{
+	"triple":     triple,
+	"shellquote": shellQuote,
+}
+
+// sharedTemplates are the templates every build script can use, getting its data:
+// the download of the driver sources into /tmp/module-download, as the tarball of the driver version or cloned with git,
+// unless they are the local ones the processor copied into LocalDriverDirectory,
+// and the build of an artifact apart from the others, between artifact-begin and artifact-end given its type:
+// its output is logged into ArtifactLogsDirectory, the tail of it recorded when it fails, and the script goes on with the other artifacts.
+const sharedTemplates = `
+{{- define "module-download" -}}
+{{ if .LocalDriverSources -}}
+# The driver sources are the local ones, copied into the builder
+mkdir -p /tmp/module-download/local
+cp -R ` + LocalDriverDirectory + `/driver /tmp/module-download/local/driver
+{{- else if .DriverGitURL -}}
+# Clone the driver sources at the driver version, installing git unless the builder image comes with it
+command -v git >/dev/null || (apt-get update && apt-get install -y --no-install-recommends git ca-certificates)
+git init -q /tmp/module-download/libs
+git -C /tmp/module-download/libs fetch --depth 1 {{ .DriverGitURL }} {{ .DriverGitRef }}
+git -C /tmp/module-download/libs checkout -q FETCH_HEAD
+{{- else -}}
+curl --silent -SL {{ .ModuleDownloadURL }} | tar -xzf - -C /tmp/module-download
+{{- end }}
+{{- end -}}
+{{- define "artifact-begin" -}}
+mkdir -p ` + ArtifactLogsDirectory + `
+set +e
+(
+set -e
+{{- end -}}
+{{- define "artifact-end" -}}
+) 2>&1 | tee ` + ArtifactLogsDirectory + `/{{ . }}.log
+[[ ${PIPESTATUS[0]} -eq 0 ]] || tail -n 50 ` + ArtifactLogsDirectory + `/{{ . }}.log > ` + ArtifactLogsDirectory + `/{{ . }}.failed
+set -e
+{{- end -}}`
+
+// buildTemplateData is the data of every template, embedded into the one of its target: the tuning of the build given by the user,
+// empty unless given.
+type buildTemplateData struct {
+	// Env are the variables the script exports before building, e.g. KCFLAGS.
+	Env map[string]string
+	// MakeFlags are given to every make invocation of the build, e.g. -j8.
+	MakeFlags string
+	// UseCcache tells the kernel module is compiled through ccache, with the cache the processor mounts at CcacheDirectory.
+	UseCcache bool
+	// DriverGitURL is the repository the driver sources are cloned from at DriverGitRef, rather than downloaded as a tarball, when asked to.
+	DriverGitURL string
+	DriverGitRef string
+	// LocalDriverSources tells the driver sources are the local ones the processor copied into LocalDriverDirectory, rather than downloaded.
+	LocalDriverSources bool
+	// KernelCurlConfig is the curl config the processor copied the credentials of the custom kernel URLs into, if any,
+	// the kernel downloads read it.
+	KernelCurlConfig string
+}
+
+func newBuildTemplateData(c Config) buildTemplateData {
+	if c.Build == nil {
+		return buildTemplateData{}
+	}
+	td := buildTemplateData{Env: c.Env, MakeFlags: c.MakeFlags, UseCcache: len(c.CcacheDir) > 0}
+	if len(c.LocalDriverDir) > 0 {
+		td.LocalDriverSources = true
+	} else if c.DriverUseGit {
+		td.DriverGitURL, td.DriverGitRef = fmt.Sprintf("https://github.com/%s.git", driverRepo(c)), c.DriverVersion
+	}
+	if hasKernelURLsAuth(c.Build) {
+		td.KernelCurlConfig = KernelURLsCurlConfigPath
+	}
+	return td
+}
+
+// shellQuote quotes the value for the shell, e.g. the values of the variables the scripts export.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// parseTemplate parses the template of the target, the one of the build replacing the embedded one when given.
+// The fields the template uses are checked against its data, a zero value of the data struct of the target,
+// so that a typo fails before the kernel packages are downloaded rather than once the script is rendered.
+func parseTemplate(c Config, target string, embedded string, data interface{}) (*template.Template, error) {
+	name, text := target, embedded
+	if c.Build != nil && len(c.TemplateOverride) > 0 {
+		content, err := ioutil.ReadFile(c.TemplateOverride)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the builder template: %s", err)
+		}
+		// the errors tell the lines of the file
+		name, text = c.TemplateOverride, string(content)
+	}
+	parsed, err := template.New(name).Option("missingkey=error").Funcs(templateFuncs).Parse(sharedTemplates)
+	if err != nil {
+		return nil, err
+	}
+	if parsed, err = parsed.Parse(text); err != nil {
+		return nil, err
+	}
+	if err := checkTemplateFields(parsed, reflect.TypeOf(data)); err != nil {
+		return nil, err
+	}
+	return parsed, nil
+}
+
+// executeTemplate renders the parsed template of the target with its data.
+func executeTemplate(parsed *template.Template, data interface{}) (string, error) {
+	buf := bytes.NewBuffer(nil)
+	if err := parsed.Execute(buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// checkTemplateFields fails on the first field the template uses that the data type does not have.
+func checkTemplateFields(t *template.Template, typ reflect.Type) error {
+	for _, tmpl := range t.Templates() {
+		if tmpl.Tree == nil {
+			continue
+		}
+		if err := checkNodeFields(tmpl.Tree, tmpl.Tree.Root, typ, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkNodeFields checks the fields of the node and of its children,
+// those of the dot only while the dot is the data, e.g. not within range and with.
+func checkNodeFields(tree *parse.Tree, node parse.Node, typ reflect.Type, dotIsData bool) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := checkNodeFields(tree, child, typ, dotIsData); err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		return checkNodeFields(tree, n.Pipe, typ, dotIsData)
+	case *parse.TemplateNode:
+		return checkNodeFields(tree, n.Pipe, typ, dotIsData)
+	case *parse.IfNode:
+		return checkBranchFields(tree, &n.BranchNode, typ, dotIsData, dotIsData)
+	case *parse.RangeNode:
+		return checkBranchFields(tree, &n.BranchNode, typ, dotIsData, false)
+	case *parse.WithNode:
+		return checkBranchFields(tree, &n.BranchNode, typ, dotIsData, false)
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+		for _, cmd := range n.Cmds {
+			if err := checkNodeFields(tree, cmd, typ, dotIsData); err != nil {
+				return err
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if err := checkNodeFields(tree, arg, typ, dotIsData); err != nil {
+				return err
+			}
+		}
+	case *parse.FieldNode:
+		if dotIsData {
+			return checkFieldChain(tree, n, typ, n.Ident)
+		}
+	case *parse.VariableNode:
+		// $ is the data wherever the dot is
+		if len(n.Ident) > 1 && n.Ident[0] == "$" {
+			return checkFieldChain(tree, n, typ, n.Ident[1:])
+		}
+	}
+	return nil
+}
+
+// checkBranchFields checks the fields of if, range and with: their pipeline and their else run with the dot they are in,
+// their body with the dot they set.
+func checkBranchFields(tree *parse.Tree, n *parse.BranchNode, typ reflect.Type, dotIsData bool, bodyDotIsData bool) error {
+	if err := checkNodeFields(tree, n.Pipe, typ, dotIsData); err != nil {
+		return err
+	}
+	if err := checkNodeFields(tree, n.List, typ, bodyDotIsData); err != nil {
+		return err
+	}
+	return checkNodeFields(tree, n.ElseList, typ, dotIsData)
+}
+
+// checkFieldChain checks the chain of fields, e.g. .A.B, as long as they are fields of structs.
+func checkFieldChain(tree *parse.Tree, node parse.Node, typ reflect.Type, idents []string) error {
+	for _, ident := range idents {
+		for typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		if _, ok := reflect.PtrTo(typ).MethodByName(ident); ok || typ.Kind() != reflect.Struct {
+			return nil
+		}
+		field, ok := typ.FieldByName(ident)
+		if !ok {
+			location, _ := tree.ErrorContext(node)
+			return fmt.Errorf("template: %s: unknown field %s, it is not part of the data of the template", location, ident)
+		}
+		typ = field.Type
+	}
+	return nil
+}
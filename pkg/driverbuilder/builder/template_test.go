@@ -0,0 +1,454 @@
+package builder
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTemplateOverride(t *testing.T) {
+	tests := []struct {
+		template string
+		err      string
+	}{
+		{"#!/bin/bash\n{{ range $url := .KernelDownloadURLS }}curl {{ $url }} {{ $.ModuleDriverName }}\n{{ end }}", ""},
+		{"#!/bin/bash\n{{ if .BuildModule }}\nmake {{ .ModuleDriverNam }}\n{{ end }}", "template: %s:3:8: unknown field ModuleDriverNam"},
+		{"#!/bin/bash\n\n{{ range .KernelDownloadURLS }}{{ $.Missing }}{{ end }}", "template: %s:3:35: unknown field Missing"},
+		{"#!/bin/bash\n{{ if .BuildModule }}\nmake\n", "template: %s:4: unexpected EOF"},
+	}
+	for _, test := range tests {
+		name := filepath.Join(t.TempDir(), "custom.sh")
+		if err := ioutil.WriteFile(name, []byte(test.template), 0644); err != nil {
+			t.Fatal(err)
+		}
+		c := Config{Build: &Build{TemplateOverride: name}}
+		parsed, err := parseTemplate(c, string(TargetTypeDebian), debianTemplate, debianTemplateData{})
+		if len(test.err) > 0 {
+			if want := strings.Replace(test.err, "%s", name, 1); err == nil || !strings.HasPrefix(err.Error(), want) {
+				t.Errorf("Got: '%v' / Want: '%s'", err, want)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+		}
+		var buf bytes.Buffer
+		if err := parsed.Execute(&buf, debianTemplateData{KernelDownloadURLS: []string{"https://example.com/headers.deb"}, ModuleDriverName: "falco"}); err != nil {
+			t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+		}
+		if want := "#!/bin/bash\ncurl https://example.com/headers.deb falco\n"; buf.String() != want {
+			t.Errorf("Got: '%s' / Want: '%s'", buf.String(), want)
+		}
+	}
+}
+
+func TestTemplateKernelArch(t *testing.T) {
+	tests := map[string]struct {
+		parse func() (string, error)
+		want  string
+	}{
+		"debian": {
+			parse: func() (string, error) {
+				parsed, err := parseTemplate(Config{Build: &Build{}}, string(TargetTypeDebian), debianTemplate, debianTemplateData{})
+				if err != nil {
+					return "", err
+				}
+				var buf bytes.Buffer
+				err = parsed.Execute(&buf, debianTemplateData{BuildModule: true, KernelArch: "s390", GCCVersion: "8"})
+				return buf.String(), err
+			},
+			want: "make ARCH=s390 CC=/usr/bin/gcc-8 KERNELDIR=$sourcedir",
+		},
+		"debian cross compiled": {
+			parse: func() (string, error) {
+				parsed, err := parseTemplate(Config{Build: &Build{}}, string(TargetTypeDebian), debianTemplate, debianTemplateData{})
+				if err != nil {
+					return "", err
+				}
+				var buf bytes.Buffer
+				err = parsed.Execute(&buf, debianTemplateData{BuildModule: true, KernelArch: "riscv", CrossCompile: "riscv64-linux-gnu-", GCCVersion: "8"})
+				return buf.String(), err
+			},
+			want: "make ARCH=riscv CROSS_COMPILE=riscv64-linux-gnu- CC=/usr/bin/riscv64-linux-gnu-gcc-8 KERNELDIR=$sourcedir",
+		},
+		"ubuntu cross compiled": {
+			parse: func() (string, error) {
+				parsed, err := parseTemplate(Config{Build: &Build{}}, string(TargetTypeUbuntu), ubuntuTemplate, ubuntuTemplateData{})
+				if err != nil {
+					return "", err
+				}
+				var buf bytes.Buffer
+				err = parsed.Execute(&buf, ubuntuTemplateData{BuildModule: true, KernelArch: "riscv", CrossCompile: "riscv64-linux-gnu-"})
+				return buf.String(), err
+			},
+			want: "make ARCH=riscv CROSS_COMPILE=riscv64-linux-gnu- KERNELDIR=$sourcedir",
+		},
+		"vanilla cross compiled probe": {
+			parse: func() (string, error) {
+				parsed, err := parseTemplate(Config{Build: &Build{}}, string(TargetTypeVanilla), vanillaTemplate, vanillaTemplateData{})
+				if err != nil {
+					return "", err
+				}
+				var buf bytes.Buffer
+				err = parsed.Execute(&buf, vanillaTemplateData{BuildProbe: true, KernelArch: "arm64", CrossCompile: "aarch64-linux-gnu-", GCCVersion: "8", LLVMVersion: "7"})
+				return buf.String(), err
+			},
+			want: `make ARCH=arm64 CROSS_COMPILE=aarch64-linux-gnu- LLC=/usr/bin/llc-7 CLANG="/usr/bin/clang-7 --target=aarch64-linux-gnu" CC=/usr/bin/aarch64-linux-gnu-gcc-8 KERNELDIR=/tmp/kernel`,
+		},
+		"centos chosen llvm": {
+			parse: func() (string, error) {
+				parsed, err := parseTemplate(Config{Build: &Build{}}, string(TargetTypeCentos), centosTemplate, centosTemplateData{})
+				if err != nil {
+					return "", err
+				}
+				var buf bytes.Buffer
+				err = parsed.Execute(&buf, centosTemplateData{BuildProbe: true, KernelArch: "x86_64", LLVMVersion: "14"})
+				return buf.String(), err
+			},
+			want: `make ARCH=x86_64 LLC=/usr/bin/llc-14 CLANG="/usr/bin/clang-14" CC=/usr/bin/gcc KERNELDIR=/tmp/kernel`,
+		},
+		"ubuntu chosen llvm": {
+			parse: func() (string, error) {
+				parsed, err := parseTemplate(Config{Build: &Build{}}, string(TargetTypeUbuntu), ubuntuTemplate, ubuntuTemplateData{})
+				if err != nil {
+					return "", err
+				}
+				var buf bytes.Buffer
+				err = parsed.Execute(&buf, ubuntuTemplateData{BuildProbe: true, KernelArch: "x86_64", LLVMVersion: "12", LLVMVersionChosen: true})
+				return buf.String(), err
+			},
+			want: "LLC_BIN=/usr/bin/llc-12\nCLANG_BIN=/usr/bin/clang-12\n",
+		},
+		"vanilla cross toolchain": {
+			parse: func() (string, error) {
+				parsed, err := parseTemplate(Config{Build: &Build{}}, string(TargetTypeVanilla), vanillaTemplate, vanillaTemplateData{})
+				if err != nil {
+					return "", err
+				}
+				var buf bytes.Buffer
+				err = parsed.Execute(&buf, vanillaTemplateData{KernelArch: "arm64", CrossCompile: "aarch64-linux-gnu-"})
+				return buf.String(), err
+			},
+			want: "command -v aarch64-linux-gnu-gcc >/dev/null || (apt-get update && apt-get install -y --no-install-recommends gcc-aarch64-linux-gnu)",
+		},
+		"debian cross gcc": {
+			parse: func() (string, error) {
+				parsed, err := parseTemplate(Config{Build: &Build{}}, string(TargetTypeDebian), debianTemplate, debianTemplateData{})
+				if err != nil {
+					return "", err
+				}
+				var buf bytes.Buffer
+				err = parsed.Execute(&buf, debianTemplateData{BuildModule: true, KernelArch: "arm64", CrossCompile: "aarch64-linux-gnu-", GCCVersion: "6"})
+				return buf.String(), err
+			},
+			want: "command -v aarch64-linux-gnu-gcc-6 >/dev/null || (apt-get update && apt-get install -y --no-install-recommends gcc-6-aarch64-linux-gnu)",
+		},
+		"ubuntu gcc": {
+			parse: func() (string, error) {
+				parsed, err := parseTemplate(Config{Build: &Build{}}, string(TargetTypeUbuntu), ubuntuTemplate, ubuntuTemplateData{})
+				if err != nil {
+					return "", err
+				}
+				var buf bytes.Buffer
+				err = parsed.Execute(&buf, ubuntuTemplateData{BuildModule: true, KernelArch: "x86_64", GCCVersion: "10"})
+				return buf.String(), err
+			},
+			want: "command -v gcc-10 >/dev/null || (apt-get update && apt-get install -y --no-install-recommends gcc-10)\nln -sf /usr/bin/gcc-10 /usr/bin/gcc",
+		},
+		"redhat gcc": {
+			parse: func() (string, error) {
+				parsed, err := parseTemplate(Config{Build: &Build{}}, string(TargetTypeRedhat), redhatTemplate, redhatTemplateData{})
+				if err != nil {
+					return "", err
+				}
+				var buf bytes.Buffer
+				err = parsed.Execute(&buf, redhatTemplateData{BuildModule: true, GCCVersion: "9"})
+				return buf.String(), err
+			},
+			want: "yum install -y gcc-toolset-9-gcc || yum install -y devtoolset-9-gcc",
+		},
+	}
+	for name, test := range tests {
+		script, err := test.parse()
+		if err != nil {
+			t.Fatalf("Unexpected error encountered with Test Input: '%s' | Error: '%s'", name, err)
+		}
+		if !strings.Contains(script, test.want) {
+			t.Errorf("Test Input: '%s' | Got: '%s' / Want: a script running '%s'", name, script, test.want)
+		}
+	}
+}
+
+func TestTemplateBuildData(t *testing.T) {
+	td := newBuildTemplateData(Config{Build: &Build{Env: map[string]string{"KCFLAGS": "-Wno-error", "CUSTOM": "it's"}, MakeFlags: "-j8", CcacheDir: "/var/cache/driverkit"}})
+	tests := map[string]struct {
+		template string
+		data     interface{}
+	}{
+		"alpine":       {alpineTemplate, alpineTemplateData{buildTemplateData: td, BuildModule: true}},
+		"amazonlinux":  {amazonlinuxTemplate, amazonlinuxTemplateData{buildTemplateData: td, BuildModule: true}},
+		"archlinux":    {archlinuxTemplate, archlinuxTemplateData{buildTemplateData: td, BuildModule: true}},
+		"bottlerocket": {bottlerocketTemplate, bottlerocketTemplateData{buildTemplateData: td, BuildModule: true}},
+		"centos":       {centosTemplate, centosTemplateData{buildTemplateData: td, BuildModule: true}},
+		"cos":          {cosTemplate, cosTemplateData{buildTemplateData: td, BuildModule: true}},
+		"debian":       {debianTemplate, debianTemplateData{buildTemplateData: td, BuildModule: true}},
+		"fedora":       {fedoraTemplate, fedoraTemplateData{buildTemplateData: td, BuildModule: true}},
+		"flatcar":      {flatcarTemplate, flatcarTemplateData{buildTemplateData: td, BuildModule: true}},
+		"gentoo":       {gentooTemplate, gentooTemplateData{buildTemplateData: td, BuildModule: true}},
+		"mariner":      {marinerTemplate, marinerTemplateData{buildTemplateData: td, BuildModule: true}},
+		"photon":       {photonTemplate, photonTemplateData{buildTemplateData: td, BuildModule: true}},
+		"raspios":      {raspiosTemplate, raspiosTemplateData{buildTemplateData: td, BuildModule: true}},
+		"redhat":       {redhatTemplate, redhatTemplateData{buildTemplateData: td, BuildModule: true}},
+		"rocky":        {rockyTemplate, rockyTemplateData{buildTemplateData: td, BuildModule: true}},
+		"suse":         {suseTemplate, suseTemplateData{buildTemplateData: td, BuildModule: true}},
+		"talos":        {talosTemplate, talosTemplateData{buildTemplateData: td, BuildModule: true}},
+		"ubuntu":       {ubuntuTemplate, ubuntuTemplateData{buildTemplateData: td, BuildModule: true}},
+		"vanilla":      {vanillaTemplate, vanillaTemplateData{buildTemplateData: td, BuildModule: true}},
+	}
+	for name, test := range tests {
+		parsed, err := parseTemplate(Config{Build: &Build{}}, name, test.template, test.data)
+		if err != nil {
+			t.Fatalf("Unexpected error encountered with Test Input: '%s' | Error: '%s'", name, err)
+		}
+		var buf bytes.Buffer
+		if err := parsed.Execute(&buf, test.data); err != nil {
+			t.Fatalf("Unexpected error encountered with Test Input: '%s' | Error: '%s'", name, err)
+		}
+		script := buf.String()
+		for _, want := range []string{
+			"set -xeuo pipefail\nexport CUSTOM='it'\\''s'\nexport KCFLAGS='-Wno-error'\n",
+			"make -j8 ",
+			`CC="ccache `,
+		} {
+			if !strings.Contains(script, want) {
+				t.Errorf("Test Input: '%s' | Got: '%s' / Want: '%s' in it", name, script, want)
+			}
+		}
+	}
+
+	// the kernel downloads read the credentials of the custom kernel urls from the curl config
+	td = newBuildTemplateData(Config{Build: &Build{KernelUrls: []string{"https://artifacts.example.com/k.rpm"}, KernelURLsToken: "t0k3n"}})
+	if td.KernelCurlConfig != KernelURLsCurlConfigPath {
+		t.Errorf("Got: '%s' / Want: '%s'", td.KernelCurlConfig, KernelURLsCurlConfigPath)
+	}
+	for name, test := range tests {
+		v := reflect.New(reflect.TypeOf(test.data)).Elem()
+		v.Set(reflect.ValueOf(test.data))
+		v.FieldByName("KernelCurlConfig").SetString(td.KernelCurlConfig)
+		if f := v.FieldByName("KernelDownloadURL"); f.IsValid() {
+			f.SetString("https://artifacts.example.com/k.rpm")
+		}
+		for _, field := range []string{"KernelDownloadURLS", "KernelDownloadURLs"} {
+			if f := v.FieldByName(field); f.IsValid() {
+				f.Set(reflect.ValueOf([]string{"https://artifacts.example.com/k.rpm"}))
+			}
+		}
+		parsed, err := parseTemplate(Config{Build: &Build{}}, name, test.template, v.Interface())
+		if err != nil {
+			t.Fatalf("Unexpected error encountered with Test Input: '%s' | Error: '%s'", name, err)
+		}
+		var buf bytes.Buffer
+		if err := parsed.Execute(&buf, v.Interface()); err != nil {
+			t.Fatalf("Unexpected error encountered with Test Input: '%s' | Error: '%s'", name, err)
+		}
+		if script := buf.String(); !strings.Contains(script, "curl --silent --config "+KernelURLsCurlConfigPath+" ") || strings.Contains(script, "t0k3n") {
+			t.Errorf("Test Input: '%s' | Got: '%s' / Want: the kernel downloaded with the curl config, without the token", name, script)
+		}
+	}
+
+	// the scripts are unchanged without them
+	parsed, err := parseTemplate(Config{Build: &Build{}}, string(TargetTypeDebian), debianTemplate, debianTemplateData{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := parsed.Execute(&buf, debianTemplateData{BuildModule: true}); err != nil {
+		t.Fatal(err)
+	}
+	if script := buf.String(); !strings.HasPrefix(script, "#!/bin/bash\nset -xeuo pipefail\n\nrm -Rf") || strings.Contains(script, "export") || strings.Contains(script, "ccache") || strings.Contains(script, "--config") || !strings.Contains(script, "\nmake ARCH=") {
+		t.Errorf("Got: '%s' / Want: the script without variables, make flags nor ccache", script)
+	}
+}
+
+func TestTemplateDriverName(t *testing.T) {
+	tests := map[string]struct {
+		template string
+		data     interface{}
+	}{
+		"alpine":       {alpineTemplate, alpineTemplateData{BuildModule: true}},
+		"amazonlinux":  {amazonlinuxTemplate, amazonlinuxTemplateData{BuildModule: true}},
+		"archlinux":    {archlinuxTemplate, archlinuxTemplateData{BuildModule: true}},
+		"bottlerocket": {bottlerocketTemplate, bottlerocketTemplateData{BuildModule: true}},
+		"centos":       {centosTemplate, centosTemplateData{BuildModule: true}},
+		"cos":          {cosTemplate, cosTemplateData{BuildModule: true}},
+		"debian":       {debianTemplate, debianTemplateData{BuildModule: true}},
+		"fedora":       {fedoraTemplate, fedoraTemplateData{BuildModule: true}},
+		"flatcar":      {flatcarTemplate, flatcarTemplateData{BuildModule: true}},
+		"gentoo":       {gentooTemplate, gentooTemplateData{BuildModule: true}},
+		"mariner":      {marinerTemplate, marinerTemplateData{BuildModule: true}},
+		"photon":       {photonTemplate, photonTemplateData{BuildModule: true}},
+		"raspios":      {raspiosTemplate, raspiosTemplateData{BuildModule: true}},
+		"redhat":       {redhatTemplate, redhatTemplateData{BuildModule: true}},
+		"rocky":        {rockyTemplate, rockyTemplateData{BuildModule: true}},
+		"suse":         {suseTemplate, suseTemplateData{BuildModule: true}},
+		"talos":        {talosTemplate, talosTemplateData{BuildModule: true}},
+		"ubuntu":       {ubuntuTemplate, ubuntuTemplateData{BuildModule: true}},
+		"vanilla":      {vanillaTemplate, vanillaTemplateData{BuildModule: true}},
+	}
+	for name, test := range tests {
+		// the data of every target names the kernel module, its other fields are left empty
+		v := reflect.New(reflect.TypeOf(test.data)).Elem()
+		v.Set(reflect.ValueOf(test.data))
+		v.FieldByName("ModuleDriverName").SetString("acme")
+		v.FieldByName("ModuleFullPath").SetString(ModuleFullPath)
+		parsed, err := parseTemplate(Config{Build: &Build{}}, name, test.template, v.Interface())
+		if err != nil {
+			t.Fatalf("Unexpected error encountered with Test Input: '%s' | Error: '%s'", name, err)
+		}
+		var buf bytes.Buffer
+		if err := parsed.Execute(&buf, v.Interface()); err != nil {
+			t.Fatalf("Unexpected error encountered with Test Input: '%s' | Error: '%s'", name, err)
+		}
+		script := buf.String()
+		if want := "mv acme.ko " + ModuleFullPath + "\n"; !strings.Contains(script, want) {
+			t.Errorf("Test Input: '%s' | Got: '%s' / Want: '%s' in it", name, script, want)
+		}
+		if strings.Contains(strings.ReplaceAll(script, "falcosecurity", ""), "falco") {
+			t.Errorf("Test Input: '%s' | Got: '%s' / Want: no falco left in it", name, script)
+		}
+	}
+
+	conf := dkmsConf(Config{DriverName: "acme", Build: &Build{DriverVersion: "7.0.0+driver"}})
+	for _, want := range []string{`PACKAGE_NAME="acme"`, `BUILT_MODULE_NAME[0]="acme"`} {
+		if !strings.Contains(conf, want) {
+			t.Errorf("Got: '%s' / Want: '%s' in it", conf, want)
+		}
+	}
+}
+
+func TestModuleDownloadURL(t *testing.T) {
+	tests := map[string]struct {
+		build *Build
+		want  string
+	}{
+		"release":      {&Build{DriverVersion: "7.0.0+driver"}, "https://github.com/falcosecurity/libs/archive/7.0.0+driver.tar.gz"},
+		"master":       {&Build{DriverVersion: "master"}, "https://github.com/falcosecurity/libs/archive/master.tar.gz"},
+		"commit":       {&Build{DriverVersion: "2b1e7d6a0c5a0f8f3f4c7c2c6d4fd3a8e5c1b9a0"}, "https://codeload.github.com/falcosecurity/libs/tar.gz/2b1e7d6a0c5a0f8f3f4c7c2c6d4fd3a8e5c1b9a0"},
+		"pull request": {&Build{DriverVersion: "refs/pull/123/head"}, "https://codeload.github.com/falcosecurity/libs/tar.gz/refs/pull/123/head"},
+		"fork":         {&Build{DriverVersion: "master", DriverRepo: "someone/libs"}, "https://codeload.github.com/someone/libs/tar.gz/master"},
+		"fork release": {&Build{DriverVersion: "7.0.0+driver", DriverRepo: "someone/libs"}, "https://codeload.github.com/someone/libs/tar.gz/7.0.0+driver"},
+		"fork branch":  {&Build{DriverVersion: "refs/heads/fix", DriverRepo: "someone/libs"}, "https://codeload.github.com/someone/libs/tar.gz/refs/heads/fix"},
+		"short commit": {&Build{DriverVersion: "2b1e7d6"}, "https://codeload.github.com/falcosecurity/libs/tar.gz/2b1e7d6"},
+	}
+	for name, test := range tests {
+		got := moduleDownloadURL(Config{DownloadBaseURL: "https://github.com/falcosecurity/libs/archive", Build: test.build})
+		if got != test.want {
+			t.Errorf("Test Input: '%s' | Got: '%s' / Want: '%s'", name, got, test.want)
+		}
+	}
+}
+
+func TestShortDriverVersion(t *testing.T) {
+	tests := map[string]string{
+		"2b1e7d6a0c5a0f8f3f4c7c2c6d4fd3a8e5c1b9a0": "2b1e7d6",
+		"2b1e7d6":            "2b1e7d6",
+		"refs/pull/123/head": "refs-pull-123-head",
+		"7.0.0+driver":       "7.0.0+driver",
+		"master":             "master",
+	}
+	for version, want := range tests {
+		if got := ShortDriverVersion(version); got != want {
+			t.Errorf("Test Input: '%s' | Got: '%s' / Want: '%s'", version, got, want)
+		}
+	}
+}
+
+func TestTemplateModuleDownload(t *testing.T) {
+	tests := map[string]struct {
+		build *Build
+		want  []string
+	}{
+		"tarball": {
+			build: &Build{DriverVersion: "refs/pull/123/head"},
+			want:  []string{"curl --silent -SL https://codeload.github.com/falcosecurity/libs/tar.gz/refs/pull/123/head | tar -xzf - -C /tmp/module-download\nmv /tmp/module-download/*/driver/*"},
+		},
+		"local": {
+			build: &Build{DriverVersion: "master", LocalDriverDir: "/src/libs"},
+			want:  []string{"mkdir -p /tmp/module-download/local\ncp -R /tmp/driverkit-driver/driver /tmp/module-download/local/driver\nmv /tmp/module-download/*/driver/*"},
+		},
+		"git": {
+			build: &Build{DriverVersion: "refs/pull/123/head", DriverRepo: "someone/libs", DriverUseGit: true},
+			want: []string{
+				"git init -q /tmp/module-download/libs\n",
+				"git -C /tmp/module-download/libs fetch --depth 1 https://github.com/someone/libs.git refs/pull/123/head\n",
+				"git -C /tmp/module-download/libs checkout -q FETCH_HEAD\nmv /tmp/module-download/*/driver/*",
+			},
+		},
+	}
+	for name, test := range tests {
+		c := Config{DownloadBaseURL: "https://github.com/falcosecurity/libs/archive", Build: test.build}
+		td := alpineTemplateData{buildTemplateData: newBuildTemplateData(c), ModuleDownloadURL: moduleDownloadURL(c), BuildModule: true}
+		parsed, err := parseTemplate(c, string(TargetTypeAlpine), alpineTemplate, td)
+		if err != nil {
+			t.Fatalf("Unexpected error encountered with Test Input: '%s' | Error: '%s'", name, err)
+		}
+		var buf bytes.Buffer
+		if err := parsed.Execute(&buf, td); err != nil {
+			t.Fatalf("Unexpected error encountered with Test Input: '%s' | Error: '%s'", name, err)
+		}
+		for _, want := range test.want {
+			if !strings.Contains(buf.String(), want) {
+				t.Errorf("Test Input: '%s' | Got: '%s' / Want: '%s' in it", name, buf.String(), want)
+			}
+		}
+	}
+}
+
+func TestTemplateArtifactFailures(t *testing.T) {
+	// the kernel module fails to build, the eBPF probe and what follows are built nonetheless
+	embedded := `#!/bin/bash
+set -xeuo pipefail
+{{ template "artifact-begin" "module" }}
+echo "main.c:1: error: expected expression"
+false
+echo module > /tmp/driver/module.ko
+{{ template "artifact-end" "module" }}
+{{ template "artifact-begin" "probe" }}
+echo probe > /tmp/driver/probe.o
+{{ template "artifact-end" "probe" }}
+echo btf > /tmp/driver/btf`
+	parsed, err := parseTemplate(Config{}, "test", embedded, alpineTemplateData{})
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	var buf bytes.Buffer
+	if err := parsed.Execute(&buf, alpineTemplateData{}); err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	dir := t.TempDir()
+	script := strings.ReplaceAll(buf.String(), DriverDirectory, dir)
+	if out, err := exec.Command("/bin/bash", "-c", script).CombinedOutput(); err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'\n%s", err, out)
+	}
+
+	relocate := func(name string) string { return strings.Replace(name, DriverDirectory, dir, 1) }
+	failure, err := ioutil.ReadFile(relocate(ArtifactFailurePath("module")))
+	if err != nil || !strings.Contains(string(failure), "main.c:1: error: expected expression") {
+		t.Errorf("Got: [ '%s', %v ] / Want: [ the tail of the module build log ]", failure, err)
+	}
+	for _, name := range []string{ArtifactFailurePath("probe"), filepath.Join(DriverDirectory, "module.ko")} {
+		if _, err := os.Stat(relocate(name)); !os.IsNotExist(err) {
+			t.Errorf("Test Input: '%s' | Got: [ %v ] / Want: [ not found ]", name, err)
+		}
+	}
+	for _, name := range []string{"probe.o", "btf"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("Test Input: '%s' | Got: [ %v ] / Want: [ built ]", name, err)
+		}
+	}
+}
@@ -1,22 +1,35 @@
 #!/bin/bash
 set -xeuo pipefail
+{{- range $name, $value := .Env }}
+export {{ $name }}={{ shellquote $value }}
+{{- end }}
 
 rm -Rf {{ .DriverBuildDir }}
 mkdir {{ .DriverBuildDir }}
 rm -Rf /tmp/module-download
 mkdir -p /tmp/module-download
 
-curl --silent -SL {{ .ModuleDownloadURL }} | tar -xzf - -C /tmp/module-download
+{{ template "module-download" . }}
 mv /tmp/module-download/*/driver/* {{ .DriverBuildDir }}
 
 cp /driverkit/module-Makefile {{ .DriverBuildDir }}/Makefile
 bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
 
+{{ with .CrossCompile }}
+# Install the cross toolchain, unless the builder image comes with it
+command -v {{ . }}gcc >/dev/null || (apt-get update && apt-get install -y --no-install-recommends gcc-{{ triple . }})
+{{ end }}
+
+# Change current gcc, installing it unless the builder image comes with it
+command -v gcc-{{ .GCCVersion }} >/dev/null || (apt-get update && apt-get install -y --no-install-recommends gcc-{{ .GCCVersion }})
+ln -sf /usr/bin/gcc-{{ .GCCVersion }} /usr/bin/gcc
+
 # Fetch the kernel
 mkdir /tmp/kernel-download
 cd /tmp/kernel-download
 {{ range $url := .KernelDownloadURLs }}
-curl --silent -o kernel.rpm -SL {{ $url }}
+curl --silent {{ with $.KernelCurlConfig }}--config {{ . }} {{ end }}-o kernel.rpm -SL {{ $url }}
+{{ with index $.KernelChecksums $url }}echo "{{ . }}  kernel.rpm" | sha256sum -c -{{ end }}
 rpm2cpio kernel.rpm | cpio --extract --make-directories
 rm -rf kernel.rpm
 {{ end }}
@@ -25,18 +38,22 @@ mkdir -p /tmp/kernel
 mv usr/src/kernels/*/* /tmp/kernel
 
 {{ if .BuildModule }}
+{{ template "artifact-begin" "module" }}
 # Build the kernel module
 cd {{ .DriverBuildDir }}
 
-make KERNELDIR=/tmp/kernel CC=/usr/bin/gcc LD=/usr/bin/ld.bfd CROSS_COMPILE=""
+make {{ with .MakeFlags }}{{ . }} {{ end }}ARCH={{ .KernelArch }} KERNELDIR=/tmp/kernel CC={{ if .UseCcache }}"ccache /usr/bin/{{ .CrossCompile }}gcc"{{ else }}/usr/bin/{{ .CrossCompile }}gcc{{ end }} LD=/usr/bin/{{ .CrossCompile }}ld.bfd CROSS_COMPILE={{ .CrossCompile }}
 mv {{ .ModuleDriverName }}.ko {{ .ModuleFullPath }}
 # Print results
 modinfo {{ .ModuleFullPath }}
+{{ template "artifact-end" "module" }}
 {{ end }}
 
 {{ if .BuildProbe }}
+{{ template "artifact-begin" "probe" }}
 # Build the eBPF probe
 cd {{ .DriverBuildDir }}/bpf
-make LLC=/usr/bin/llc-{{ .LLVMVersion }} CLANG=/usr/bin/clang-{{ .LLVMVersion }} CC=/usr/bin/gcc KERNELDIR=/tmp/kernel
+make {{ with .MakeFlags }}{{ . }} {{ end }}ARCH={{ .KernelArch }}{{ with .CrossCompile }} CROSS_COMPILE={{ . }}{{ end }} LLC=/usr/bin/llc-{{ .LLVMVersion }} CLANG="/usr/bin/clang-{{ .LLVMVersion }}{{ with .CrossCompile }} --target={{ triple . }}{{ end }}" CC=/usr/bin/{{ .CrossCompile }}gcc KERNELDIR=/tmp/kernel
 ls -l probe.o
+{{ template "artifact-end" "probe" }}
 {{ end }}
\ No newline at end of file
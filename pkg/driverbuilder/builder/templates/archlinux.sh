@@ -1,42 +1,62 @@
 #!/bin/bash
 set -xeuo pipefail
+{{- range $name, $value := .Env }}
+export {{ $name }}={{ shellquote $value }}
+{{- end }}
 
 rm -Rf {{ .DriverBuildDir }}
 mkdir {{ .DriverBuildDir }}
 rm -Rf /tmp/module-download
 mkdir -p /tmp/module-download
 
-curl --silent -SL {{ .ModuleDownloadURL }} | tar -xzf - -C /tmp/module-download
+{{ template "module-download" . }}
 mv /tmp/module-download/*/driver/* {{ .DriverBuildDir }}
 
 cp /driverkit/module-Makefile {{ .DriverBuildDir }}/Makefile
 bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
 
+{{ with .CrossCompile }}
+# Install the cross toolchain, unless the builder image comes with it
+command -v {{ . }}gcc >/dev/null || (apt-get update && apt-get install -y --no-install-recommends gcc-{{ triple . }})
+{{ end }}
+
 # Fetch the kernel
 mkdir /tmp/kernel-download
 cd /tmp/kernel-download
-curl --silent -o kernel-devel.pkg.tar.xz -SL {{ .KernelDownloadURL }}
-tar -xf kernel-devel.pkg.tar.xz
+curl --silent {{ with $.KernelCurlConfig }}--config {{ . }} {{ end }}-o kernel-devel.pkg.tar -SL {{ .KernelDownloadURL }}
+case "{{ .KernelDownloadURL }}" in
+*.zst)
+	zstd -dc kernel-devel.pkg.tar | tar -xf -
+	;;
+*)
+	tar -xf kernel-devel.pkg.tar
+	;;
+esac
 rm -Rf /tmp/kernel
 mkdir -p /tmp/kernel
 mv usr/lib/modules/*/build/* /tmp/kernel
 
-# Change current gcc
+# Change current gcc, installing it unless the builder image comes with it
+command -v gcc-{{ .GCCVersion }} >/dev/null || (apt-get update && apt-get install -y --no-install-recommends gcc-{{ .GCCVersion }})
 ln -sf /usr/bin/gcc-{{ .GCCVersion }} /usr/bin/gcc
 
 {{ if .BuildModule }}
+{{ template "artifact-begin" "module" }}
 # Build the module
 cd {{ .DriverBuildDir }}
-make KERNELDIR=/tmp/kernel
+make {{ with .MakeFlags }}{{ . }} {{ end }}ARCH={{ .KernelArch }}{{ with .CrossCompile }} CROSS_COMPILE={{ . }}{{ end }} KERNELDIR=/tmp/kernel{{ if .UseCcache }} CC="ccache {{ .CrossCompile }}gcc"{{ end }}
 mv {{ .ModuleDriverName }}.ko {{ .ModuleFullPath }}
-strip -g {{ .ModuleFullPath }}
+{{ .CrossCompile }}strip -g {{ .ModuleFullPath }}
 # Print results
 modinfo {{ .ModuleFullPath }}
+{{ template "artifact-end" "module" }}
 {{ end }}
 
 {{ if .BuildProbe }}
+{{ template "artifact-begin" "probe" }}
 # Build the eBPF probe
 cd {{ .DriverBuildDir }}/bpf
-make LLC=/usr/bin/llc-7 CLANG=/usr/bin/clang-7 CC=/usr/bin/gcc KERNELDIR=/tmp/kernel
+make {{ with .MakeFlags }}{{ . }} {{ end }}ARCH={{ .KernelArch }}{{ with .CrossCompile }} CROSS_COMPILE={{ . }}{{ end }} LLC=/usr/bin/llc-{{ .LLVMVersion }} CLANG="/usr/bin/clang-{{ .LLVMVersion }}{{ with .CrossCompile }} --target={{ triple . }}{{ end }}" CC=/usr/bin/{{ .CrossCompile }}gcc KERNELDIR=/tmp/kernel
 ls -l probe.o
+{{ template "artifact-end" "probe" }}
 {{ end }}
\ No newline at end of file
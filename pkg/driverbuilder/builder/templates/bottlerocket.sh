@@ -0,0 +1,53 @@
+#!/bin/bash
+set -xeuo pipefail
+{{- range $name, $value := .Env }}
+export {{ $name }}={{ shellquote $value }}
+{{- end }}
+
+rm -Rf {{ .DriverBuildDir }}
+mkdir {{ .DriverBuildDir }}
+rm -Rf /tmp/module-download
+mkdir -p /tmp/module-download
+
+{{ template "module-download" . }}
+mv /tmp/module-download/*/driver/* {{ .DriverBuildDir }}
+
+cp /driverkit/module-Makefile {{ .DriverBuildDir }}/Makefile
+bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
+
+# Fetch the kmod kit
+mkdir /tmp/kernel-download
+cd /tmp/kernel-download
+curl --silent {{ with $.KernelCurlConfig }}--config {{ . }} {{ end }}-o kmod-kit.tar.xz -SL {{ .KernelDownloadURL }}
+{{ if .KernelSHA256 }}
+echo "{{ .KernelSHA256 }}  kmod-kit.tar.xz" | sha256sum -c -
+{{ end }}
+tar -xf kmod-kit.tar.xz
+kitdir=$(find /tmp/kernel-download -maxdepth 1 -type d -name "*-kmod-kit-*" | head -n 1)
+rm -Rf /tmp/kernel
+mkdir -p /tmp/kernel
+tar -xf $kitdir/kernel-devel.tar.xz -C /tmp/kernel --strip-components=1
+
+# Use the toolchain shipped with the kit
+export PATH=$kitdir/toolchain/usr/bin:$PATH
+
+{{ if .BuildModule }}
+{{ template "artifact-begin" "module" }}
+# Build the module
+cd {{ .DriverBuildDir }}
+make {{ with .MakeFlags }}{{ . }} {{ end }}KERNELDIR=/tmp/kernel ARCH={{ .KernelArch }} CROSS_COMPILE={{ .CrossCompile }}{{ if .UseCcache }} CC="ccache {{ .CrossCompile }}gcc"{{ end }}
+mv {{ .ModuleDriverName }}.ko {{ .ModuleFullPath }}
+{{ .CrossCompile }}strip -g {{ .ModuleFullPath }}
+# Print results
+modinfo {{ .ModuleFullPath }}
+{{ template "artifact-end" "module" }}
+{{ end }}
+
+{{ if .BuildProbe }}
+{{ template "artifact-begin" "probe" }}
+# Build the eBPF probe
+cd {{ .DriverBuildDir }}/bpf
+make {{ with .MakeFlags }}{{ . }} {{ end }}LLC=/usr/bin/llc-{{ .LLVMVersion }} CLANG=/usr/bin/clang-{{ .LLVMVersion }} CC=/usr/bin/gcc KERNELDIR=/tmp/kernel ARCH={{ .KernelArch }}
+ls -l probe.o
+{{ template "artifact-end" "probe" }}
+{{ end }}
@@ -1,42 +1,70 @@
 #!/bin/bash
 set -xeuo pipefail
+{{- range $name, $value := .Env }}
+export {{ $name }}={{ shellquote $value }}
+{{- end }}
 
 rm -Rf {{ .DriverBuildDir }}
 mkdir {{ .DriverBuildDir }}
 rm -Rf /tmp/module-download
 mkdir -p /tmp/module-download
 
-curl --silent -SL {{ .ModuleDownloadURL }} | tar -xzf - -C /tmp/module-download
+{{ template "module-download" . }}
 mv /tmp/module-download/*/driver/* {{ .DriverBuildDir }}
 
 cp /driverkit/module-Makefile {{ .DriverBuildDir }}/Makefile
 bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
 
+{{ with .CrossCompile }}
+# Install the cross toolchain, unless the builder image comes with it
+command -v {{ . }}gcc >/dev/null || (apt-get update && apt-get install -y --no-install-recommends gcc-{{ triple . }})
+{{ end }}
+
 # Fetch the kernel
 mkdir /tmp/kernel-download
 cd /tmp/kernel-download
-curl --silent -o kernel-devel.rpm -SL {{ .KernelDownloadURL }}
+curl --silent {{ with $.KernelCurlConfig }}--config {{ . }} {{ end }}-o kernel-devel.rpm -SL {{ .KernelDownloadURL }}
+{{ with .KernelChecksum }}echo "{{ . }}  kernel-devel.rpm" | sha256sum -c -{{ end }}
 rpm2cpio kernel-devel.rpm | cpio --extract --make-directories
 rm -Rf /tmp/kernel
 mkdir -p /tmp/kernel
 mv usr/src/kernels/*/* /tmp/kernel
+{{ with .DebugDownloadURL }}
+curl --silent -o kernel-debuginfo.rpm -SL {{ . }}
+{{ with $.DebugChecksum }}echo "{{ . }}  kernel-debuginfo.rpm" | sha256sum -c -{{ end }}
+rpm2cpio kernel-debuginfo.rpm | cpio --extract --make-directories
+{{ end }}
 
-# Change current gcc
+# Change current gcc, installing it unless the builder image comes with it
+command -v gcc-{{ .GCCVersion }} >/dev/null || (apt-get update && apt-get install -y --no-install-recommends gcc-{{ .GCCVersion }})
 ln -sf /usr/bin/gcc-{{ .GCCVersion }} /usr/bin/gcc
 
 {{ if .BuildModule }}
+{{ template "artifact-begin" "module" }}
 # Build the module
 cd {{ .DriverBuildDir }}
-make KERNELDIR=/tmp/kernel
+make {{ with .MakeFlags }}{{ . }} {{ end }}ARCH={{ .KernelArch }}{{ with .CrossCompile }} CROSS_COMPILE={{ . }}{{ end }} KERNELDIR=/tmp/kernel{{ if .UseCcache }} CC="ccache {{ .CrossCompile }}gcc"{{ end }}
 mv {{ .ModuleDriverName }}.ko {{ .ModuleFullPath }}
-strip -g {{ .ModuleFullPath }}
+{{ .CrossCompile }}strip -g {{ .ModuleFullPath }}
 # Print results
 modinfo {{ .ModuleFullPath }}
+{{ template "artifact-end" "module" }}
 {{ end }}
 
 {{ if .BuildProbe }}
+{{ template "artifact-begin" "probe" }}
 # Build the eBPF probe
 cd {{ .DriverBuildDir }}/bpf
-make LLC=/usr/bin/llc-7 CLANG=/usr/bin/clang-7 CC=/usr/bin/gcc KERNELDIR=/tmp/kernel
+make {{ with .MakeFlags }}{{ . }} {{ end }}ARCH={{ .KernelArch }}{{ with .CrossCompile }} CROSS_COMPILE={{ . }}{{ end }} LLC=/usr/bin/llc-{{ .LLVMVersion }} CLANG="/usr/bin/clang-{{ .LLVMVersion }}{{ with .CrossCompile }} --target={{ triple . }}{{ end }}" CC=/usr/bin/{{ .CrossCompile }}gcc KERNELDIR=/tmp/kernel
 ls -l probe.o
+{{ template "artifact-end" "probe" }}
+{{ end }}
+
+{{ if .BuildBTF }}
+# Generate the BTF of the kernel from the vmlinux of its debug package
+vmlinux=$(find /tmp/kernel-download -path "*/debug/*" -type f \( -name vmlinux -o -name "vmlinux-*" \) | head -n 1)
+mkdir -p $(dirname {{ .BTFFullPath }})
+{{ .CrossCompile }}readelf -S $vmlinux | grep -q "\.BTF" || pahole -J $vmlinux
+{{ .CrossCompile }}objcopy --only-section=.BTF --set-section-flags .BTF=alloc -O binary $vmlinux {{ .BTFFullPath }}
+ls -l {{ .BTFFullPath }}
 {{ end }}
\ No newline at end of file
@@ -0,0 +1,58 @@
+#!/bin/bash
+set -xeuo pipefail
+{{- range $name, $value := .Env }}
+export {{ $name }}={{ shellquote $value }}
+{{- end }}
+
+rm -Rf {{ .DriverBuildDir }}
+mkdir {{ .DriverBuildDir }}
+rm -Rf /tmp/module-download
+mkdir -p /tmp/module-download
+
+{{ template "module-download" . }}
+mv /tmp/module-download/*/driver/* {{ .DriverBuildDir }}
+
+cp /driverkit/module-Makefile {{ .DriverBuildDir }}/Makefile
+bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
+
+# Fetch the kernel
+mkdir /tmp/kernel-download
+cd /tmp/kernel-download
+curl --silent {{ with $.KernelCurlConfig }}--config {{ . }} {{ end }}-SL {{ .KernelDownloadURL }} | tar -xzf - -C /tmp/kernel-download
+rm -Rf /tmp/kernel
+mkdir -p /tmp/kernel
+mv usr/src/linux-headers-*/* /tmp/kernel
+
+# Fetch the toolchain the kernel has been built with
+rm -Rf /tmp/toolchain
+mkdir -p /tmp/toolchain
+curl --silent -SL {{ .ToolchainURL }} | tar -xJf - -C /tmp/toolchain
+export PATH=/tmp/toolchain/bin:$PATH
+
+# The toolchain env pins the compilers (eg. CC=x86_64-cros-linux-gnu-clang)
+CC=gcc
+LD=ld
+if curl --silent -f -o /tmp/toolchain_env -SL {{ .ToolchainEnvURL }}; then
+	source /tmp/toolchain_env
+fi
+
+{{ if .BuildModule }}
+{{ template "artifact-begin" "module" }}
+# Build the module
+cd {{ .DriverBuildDir }}
+make {{ with .MakeFlags }}{{ . }} {{ end }}KERNELDIR=/tmp/kernel CC={{ if .UseCcache }}"ccache ${CC}"{{ else }}${CC}{{ end }} LD=${LD}
+mv {{ .ModuleDriverName }}.ko {{ .ModuleFullPath }}
+strip -g {{ .ModuleFullPath }}
+# Print results
+modinfo {{ .ModuleFullPath }}
+{{ template "artifact-end" "module" }}
+{{ end }}
+
+{{ if .BuildProbe }}
+{{ template "artifact-begin" "probe" }}
+# Build the eBPF probe
+cd {{ .DriverBuildDir }}/bpf
+make {{ with .MakeFlags }}{{ . }} {{ end }}LLC=/tmp/toolchain/bin/llc CLANG=/tmp/toolchain/bin/clang CC=${CC} KERNELDIR=/tmp/kernel
+ls -l probe.o
+{{ template "artifact-end" "probe" }}
+{{ end }}
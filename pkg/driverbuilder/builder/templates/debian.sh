@@ -1,22 +1,34 @@
 #!/bin/bash
 set -xeuo pipefail
+{{- range $name, $value := .Env }}
+export {{ $name }}={{ shellquote $value }}
+{{- end }}
 
 rm -Rf {{ .DriverBuildDir }}
 mkdir {{ .DriverBuildDir }}
 rm -Rf /tmp/module-download
 mkdir -p /tmp/module-download
 
-curl --silent -SL {{ .ModuleDownloadURL }} | tar -xzf - -C /tmp/module-download
+{{ template "module-download" . }}
 mv /tmp/module-download/*/driver/* {{ .DriverBuildDir }}
 
 cp /driverkit/module-Makefile {{ .DriverBuildDir }}/Makefile
 bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
 
+{{ with .CrossCompile }}
+# Install the cross toolchain, unless the builder image comes with it
+command -v {{ . }}gcc >/dev/null || (apt-get update && apt-get install -y --no-install-recommends gcc-{{ triple . }})
+{{ end }}
+
+# Install the gcc of the build, unless the builder image comes with it
+command -v {{ .CrossCompile }}gcc-{{ .GCCVersion }} >/dev/null || (apt-get update && apt-get install -y --no-install-recommends gcc-{{ .GCCVersion }}{{ with .CrossCompile }}-{{ triple . }}{{ end }})
+
 # Fetch the kernel
 mkdir /tmp/kernel-download
 cd /tmp/kernel-download
 {{ range $url := .KernelDownloadURLS }}
-curl --silent -o kernel.deb -SL {{ $url }}
+curl --silent {{ with $.KernelCurlConfig }}--config {{ . }} {{ end }}-o kernel.deb -SL {{ $url }}
+{{ with index $.KernelChecksums $url }}echo "{{ . }}  kernel.deb" | sha256sum -c -{{ end }}
 ar x kernel.deb
 tar -xvf data.tar.xz
 {{ end }}
@@ -27,21 +39,35 @@ cp -r usr/* /usr
 cp -r lib/* /lib
 
 cd /usr/src
-sourcedir=$(find . -type d -name "linux-headers-*%s" | head -n 1 | xargs readlink -f)
+# flavors are not always named after the architecture (e.g. armmp on armhf), skip the common headers instead
+sourcedir=$(find . -maxdepth 1 -type d -name "linux-headers-*" ! -name "*-common*" | head -n 1 | xargs readlink -f)
 
 {{ if .BuildModule }}
+{{ template "artifact-begin" "module" }}
 # Build the module
 cd {{ .DriverBuildDir }}
-make CC=/usr/bin/gcc-8 KERNELDIR=$sourcedir
+make {{ with .MakeFlags }}{{ . }} {{ end }}ARCH={{ .KernelArch }}{{ with .CrossCompile }} CROSS_COMPILE={{ . }}{{ end }} CC={{ if .UseCcache }}"ccache /usr/bin/{{ .CrossCompile }}gcc-{{ .GCCVersion }}"{{ else }}/usr/bin/{{ .CrossCompile }}gcc-{{ .GCCVersion }}{{ end }} KERNELDIR=$sourcedir
 mv {{ .ModuleDriverName }}.ko {{ .ModuleFullPath }}
-strip -g {{ .ModuleFullPath }}
+{{ .CrossCompile }}strip -g {{ .ModuleFullPath }}
 # Print results
 modinfo {{ .ModuleFullPath }}
+{{ template "artifact-end" "module" }}
 {{ end }}
 
 {{ if .BuildProbe }}
+{{ template "artifact-begin" "probe" }}
 # Build the eBPF probe
 cd {{ .DriverBuildDir }}/bpf
-make LLC=/usr/bin/llc-{{ .LLVMVersion }} CLANG=/usr/bin/clang-{{ .LLVMVersion }} CC=/usr/bin/gcc-8 KERNELDIR=$sourcedir
+make {{ with .MakeFlags }}{{ . }} {{ end }}ARCH={{ .KernelArch }}{{ with .CrossCompile }} CROSS_COMPILE={{ . }}{{ end }} LLC=/usr/bin/llc-{{ .LLVMVersion }} CLANG="/usr/bin/clang-{{ .LLVMVersion }}{{ with .CrossCompile }} --target={{ triple . }}{{ end }}" CC=/usr/bin/{{ .CrossCompile }}gcc-{{ .GCCVersion }} KERNELDIR=$sourcedir
 ls -l probe.o
+{{ template "artifact-end" "probe" }}
+{{ end }}
+
+{{ if .BuildBTF }}
+# Generate the BTF of the kernel from the vmlinux of its debug package
+vmlinux=$(find /tmp/kernel-download -path "*/debug/*" -type f \( -name vmlinux -o -name "vmlinux-*" \) | head -n 1)
+mkdir -p $(dirname {{ .BTFFullPath }})
+{{ .CrossCompile }}readelf -S $vmlinux | grep -q "\.BTF" || pahole -J $vmlinux
+{{ .CrossCompile }}objcopy --only-section=.BTF --set-section-flags .BTF=alloc -O binary $vmlinux {{ .BTFFullPath }}
+ls -l {{ .BTFFullPath }}
 {{ end }}
\ No newline at end of file
@@ -0,0 +1,11 @@
+
+# Assemble the DKMS source package of the kernel module, for the nodes to rebuild it on their kernel upgrades
+rm -Rf /tmp/dkms
+mkdir -p /tmp/dkms/{{ .DKMSPackageDir }} {{ .DKMSDir }}
+cp {{ .DriverBuildDir }}/*.c {{ .DriverBuildDir }}/*.h {{ .DriverBuildDir }}/Makefile /tmp/dkms/{{ .DKMSPackageDir }}
+# the sources generated by the build of the module are not part of the package
+rm -f /tmp/dkms/{{ .DKMSPackageDir }}/*.mod.c
+cat > /tmp/dkms/{{ .DKMSPackageDir }}/dkms.conf <<'DKMSCONF'
+{{ .DKMSConf }}DKMSCONF
+tar -czf {{ .DKMSFullPath }} -C /tmp/dkms {{ .DKMSPackageDir }}
+tar -tzf {{ .DKMSFullPath }}
@@ -0,0 +1,56 @@
+#!/bin/bash
+set -xeuo pipefail
+{{- range $name, $value := .Env }}
+export {{ $name }}={{ shellquote $value }}
+{{- end }}
+
+rm -Rf {{ .DriverBuildDir }}
+mkdir {{ .DriverBuildDir }}
+rm -Rf /tmp/module-download
+mkdir -p /tmp/module-download
+
+{{ template "module-download" . }}
+mv /tmp/module-download/*/driver/* {{ .DriverBuildDir }}
+
+cp /driverkit/module-Makefile {{ .DriverBuildDir }}/Makefile
+bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
+
+{{ with .CrossCompile }}
+# Install the cross toolchain, unless the builder image comes with it
+command -v {{ . }}gcc >/dev/null || (apt-get update && apt-get install -y --no-install-recommends gcc-{{ triple . }})
+{{ end }}
+
+# Fetch the kernel
+mkdir /tmp/kernel-download
+cd /tmp/kernel-download
+curl --silent {{ with $.KernelCurlConfig }}--config {{ . }} {{ end }}-o kernel-devel.rpm -SL {{ .KernelDownloadURL }}
+{{ with .KernelChecksum }}echo "{{ . }}  kernel-devel.rpm" | sha256sum -c -{{ end }}
+rpm2cpio kernel-devel.rpm | cpio --extract --make-directories
+rm -Rf /tmp/kernel
+mkdir -p /tmp/kernel
+mv usr/src/kernels/*/* /tmp/kernel
+
+# Change current gcc, installing it unless the builder image comes with it
+command -v gcc-{{ .GCCVersion }} >/dev/null || (apt-get update && apt-get install -y --no-install-recommends gcc-{{ .GCCVersion }})
+ln -sf /usr/bin/gcc-{{ .GCCVersion }} /usr/bin/gcc
+
+{{ if .BuildModule }}
+{{ template "artifact-begin" "module" }}
+# Build the module
+cd {{ .DriverBuildDir }}
+make {{ with .MakeFlags }}{{ . }} {{ end }}ARCH={{ .KernelArch }}{{ with .CrossCompile }} CROSS_COMPILE={{ . }}{{ end }} KERNELDIR=/tmp/kernel{{ if .UseCcache }} CC="ccache {{ .CrossCompile }}gcc"{{ end }}
+mv {{ .ModuleDriverName }}.ko {{ .ModuleFullPath }}
+{{ .CrossCompile }}strip -g {{ .ModuleFullPath }}
+# Print results
+modinfo {{ .ModuleFullPath }}
+{{ template "artifact-end" "module" }}
+{{ end }}
+
+{{ if .BuildProbe }}
+{{ template "artifact-begin" "probe" }}
+# Build the eBPF probe
+cd {{ .DriverBuildDir }}/bpf
+make {{ with .MakeFlags }}{{ . }} {{ end }}ARCH={{ .KernelArch }}{{ with .CrossCompile }} CROSS_COMPILE={{ . }}{{ end }} LLC=/usr/bin/llc-{{ .LLVMVersion }} CLANG="/usr/bin/clang-{{ .LLVMVersion }}{{ with .CrossCompile }} --target={{ triple . }}{{ end }}" CC=/usr/bin/{{ .CrossCompile }}gcc KERNELDIR=/tmp/kernel
+ls -l probe.o
+{{ template "artifact-end" "probe" }}
+{{ end }}
\ No newline at end of file
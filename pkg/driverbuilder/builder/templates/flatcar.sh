@@ -1,48 +1,66 @@
 #!/bin/bash
 set -xeuo pipefail
+{{- range $name, $value := .Env }}
+export {{ $name }}={{ shellquote $value }}
+{{- end }}
 
 rm -Rf {{ .DriverBuildDir }}
 mkdir {{ .DriverBuildDir }}
 rm -Rf /tmp/module-download
 mkdir -p /tmp/module-download
 
-curl --silent -SL {{ .ModuleDownloadURL }} | tar -xzf - -C /tmp/module-download
+{{ template "module-download" . }}
 mv /tmp/module-download/*/driver/* {{ .DriverBuildDir }}
 
 cp /driverkit/module-Makefile {{ .DriverBuildDir }}/Makefile
 bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
 
-# Fetch the kernel
+{{ with .CrossCompile }}
+# Install the cross toolchain, unless the builder image comes with it
+command -v {{ . }}gcc >/dev/null || (apt-get update && apt-get install -y --no-install-recommends gcc-{{ triple . }})
+{{ end }}
+
+# Fetch the developer container
 mkdir /tmp/kernel-download
 cd /tmp/kernel-download
-curl --silent -SL {{ .KernelDownloadURL }} | tar -Jxf - -C /tmp/kernel-download
+curl --silent {{ with $.KernelCurlConfig }}--config {{ . }} {{ end }}-SL {{ .KernelDownloadURL }} | bunzip2 > developer.bin
+# Extract the root partition of the image, the filesystem is read without mounting it
+start=$(sfdisk -J developer.bin | jq '.partitiontable.partitions | max_by(.size) | .start')
+dd if=developer.bin of=developer.img bs=512 skip=$start status=none
+rm -f developer.bin
+rm -Rf /tmp/developer
+mkdir -p /tmp/developer
+debugfs -R "rdump /usr/lib/modules /usr/src /tmp/developer" developer.img
+rm -f developer.img
+
+kerneldir=/tmp/developer/modules/{{ .KernelRelease }}/build
+if [ -L "$kerneldir" ]; then
+	kerneldir=/tmp/developer/src/$(readlink "$kerneldir" | sed -e 's|^.*src/||')
+fi
 rm -Rf /tmp/kernel
-mkdir -p /tmp/kernel
-mv /tmp/kernel-download/*/* /tmp/kernel
+ln -s "$kerneldir" /tmp/kernel
 
-# Change current gcc
+# Change current gcc, installing it unless the builder image comes with it
+command -v gcc-{{ .GCCVersion }} >/dev/null || (apt-get update && apt-get install -y --no-install-recommends gcc-{{ .GCCVersion }})
 ln -sf /usr/bin/gcc-{{ .GCCVersion }} /usr/bin/gcc
 
-curl --silent -o /tmp/kernel.config -SL {{ .KernelConfigURL }}
-
-cd /tmp/kernel
-sed -i -e 's|^\(EXTRAVERSION =\).*|\1 -flatcar|' Makefile
-make KCONFIG_CONFIG=/tmp/kernel.config oldconfig
-make KCONFIG_CONFIG=/tmp/kernel.config modules_prepare
-
 {{ if .BuildModule }}
+{{ template "artifact-begin" "module" }}
 # Build the module
 cd {{ .DriverBuildDir }}
-make KERNELDIR=/tmp/kernel
+make {{ with .MakeFlags }}{{ . }} {{ end }}ARCH={{ .KernelArch }}{{ with .CrossCompile }} CROSS_COMPILE={{ . }}{{ end }} KERNELDIR=/tmp/kernel{{ if .UseCcache }} CC="ccache {{ .CrossCompile }}gcc"{{ end }}
 mv {{ .ModuleDriverName }}.ko {{ .ModuleFullPath }}
-strip -g {{ .ModuleFullPath }}
+{{ .CrossCompile }}strip -g {{ .ModuleFullPath }}
 # Print results
 modinfo {{ .ModuleFullPath }}
+{{ template "artifact-end" "module" }}
 {{ end }}
 
 {{ if .BuildProbe }}
+{{ template "artifact-begin" "probe" }}
 # Build the eBPF probe
 cd {{ .DriverBuildDir }}/bpf
-make LLC=/usr/bin/llc-12 CLANG=/usr/bin/clang-12 CC=/usr/bin/gcc KERNELDIR=/tmp/kernel
+make {{ with .MakeFlags }}{{ . }} {{ end }}ARCH={{ .KernelArch }}{{ with .CrossCompile }} CROSS_COMPILE={{ . }}{{ end }} LLC=/usr/bin/llc-{{ .LLVMVersion }} CLANG="/usr/bin/clang-{{ .LLVMVersion }}{{ with .CrossCompile }} --target={{ triple . }}{{ end }}" CC=/usr/bin/{{ .CrossCompile }}gcc KERNELDIR=/tmp/kernel
 ls -l probe.o
+{{ template "artifact-end" "probe" }}
 {{ end }}
\ No newline at end of file
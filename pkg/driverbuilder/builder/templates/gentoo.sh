@@ -0,0 +1,81 @@
+#!/bin/bash
+set -xeuo pipefail
+{{- range $name, $value := .Env }}
+export {{ $name }}={{ shellquote $value }}
+{{- end }}
+
+rm -Rf {{ .DriverBuildDir }}
+mkdir {{ .DriverBuildDir }}
+rm -Rf /tmp/module-download
+mkdir -p /tmp/module-download
+
+{{ template "module-download" . }}
+mv /tmp/module-download/*/driver/* {{ .DriverBuildDir }}
+
+cp /driverkit/module-Makefile {{ .DriverBuildDir }}/Makefile
+bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
+
+{{ with .CrossCompile }}
+# Install the cross toolchain, unless the builder image comes with it
+command -v {{ . }}gcc >/dev/null || (apt-get update && apt-get install -y --no-install-recommends gcc-{{ triple . }})
+{{ end }}
+
+# Change current gcc, installing it unless the builder image comes with it
+command -v {{ .CrossCompile }}gcc-{{ .GCCVersion }} >/dev/null || (apt-get update && apt-get install -y --no-install-recommends gcc-{{ .GCCVersion }}{{ with .CrossCompile }}-{{ triple . }}{{ end }})
+ln -sf /usr/bin/gcc-{{ .GCCVersion }} /usr/bin/gcc
+
+# Fetch the kernel
+cd /tmp
+mkdir /tmp/kernel-download
+curl --silent {{ with $.KernelCurlConfig }}--config {{ . }} {{ end }}-SL {{ .KernelDownloadURL }} | tar -Jxf - -C /tmp/kernel-download
+rm -Rf /tmp/kernel
+mkdir -p /tmp/kernel
+mv /tmp/kernel-download/*/* /tmp/kernel
+
+# Apply the genpatches
+rm -Rf /tmp/genpatches
+mkdir -p /tmp/genpatches
+{{ range $url := .GenpatchesURLs }}
+curl --silent -SL {{ $url }} | tar -xJf - -C /tmp/genpatches
+{{ end }}
+cd /tmp/kernel
+for p in $(find /tmp/genpatches -name "*.patch" | sort); do
+	# 1000-1499 are the stable releases, already part of the sources
+	if [ "$(basename $p | cut -d_ -f1)" -lt 1500 ]; then
+		continue
+	fi
+	patch -p1 --forward < $p
+done
+
+# Prepare the kernel
+cd /tmp/kernel
+cp /driverkit/kernel.config /tmp/kernel.config
+
+{{ if .KernelLocalVersion}}
+sed -i 's/^CONFIG_LOCALVERSION=.*$/CONFIG_LOCALVERSION="{{ .KernelLocalVersion }}"/' /tmp/kernel.config
+{{ end }}
+
+make {{ with .MakeFlags }}{{ . }} {{ end }}ARCH={{ .KernelArch }}{{ with .CrossCompile }} CROSS_COMPILE={{ . }}{{ end }} KCONFIG_CONFIG=/tmp/kernel.config oldconfig
+make {{ with .MakeFlags }}{{ . }} {{ end }}ARCH={{ .KernelArch }}{{ with .CrossCompile }} CROSS_COMPILE={{ . }}{{ end }} KCONFIG_CONFIG=/tmp/kernel.config prepare
+make {{ with .MakeFlags }}{{ . }} {{ end }}ARCH={{ .KernelArch }}{{ with .CrossCompile }} CROSS_COMPILE={{ . }}{{ end }} KCONFIG_CONFIG=/tmp/kernel.config modules_prepare
+
+{{ if .BuildModule }}
+{{ template "artifact-begin" "module" }}
+# Build the kernel module
+cd {{ .DriverBuildDir }}
+make {{ with .MakeFlags }}{{ . }} {{ end }}ARCH={{ .KernelArch }}{{ with .CrossCompile }} CROSS_COMPILE={{ . }}{{ end }} KERNELDIR=/tmp/kernel{{ if .UseCcache }} CC="ccache {{ .CrossCompile }}gcc"{{ end }}
+mv {{ .ModuleDriverName }}.ko {{ .ModuleFullPath }}
+{{ .CrossCompile }}strip -g {{ .ModuleFullPath }}
+# Print results
+modinfo {{ .ModuleFullPath }}
+{{ template "artifact-end" "module" }}
+{{ end }}
+
+{{ if .BuildProbe }}
+{{ template "artifact-begin" "probe" }}
+# Build the eBPF probe
+cd {{ .DriverBuildDir }}/bpf
+make {{ with .MakeFlags }}{{ . }} {{ end }}ARCH={{ .KernelArch }}{{ with .CrossCompile }} CROSS_COMPILE={{ . }}{{ end }} LLC=/usr/bin/llc-{{ .LLVMVersion }} CLANG="/usr/bin/clang-{{ .LLVMVersion }}{{ with .CrossCompile }} --target={{ triple . }}{{ end }}" CC=/usr/bin/{{ .CrossCompile }}gcc-{{ .GCCVersion }} KERNELDIR=/tmp/kernel
+ls -l probe.o
+{{ template "artifact-end" "probe" }}
+{{ end }}
\ No newline at end of file
@@ -0,0 +1,20 @@
+
+# Build the modern eBPF probe: it is CO-RE, it needs the BTF of the kernel it runs on rather than its headers
+rm -Rf /tmp/modern-probe
+mkdir -p /tmp/modern-probe/libs /tmp/modern-probe/build
+{{- if .LocalDriverSources }}
+cp -R {{ .LocalDriverDirectory }}/. /tmp/modern-probe/libs
+{{- else if .DriverGitURL }}
+git init -q /tmp/modern-probe/libs
+git -C /tmp/modern-probe/libs fetch --depth 1 {{ .DriverGitURL }} {{ .DriverGitRef }}
+git -C /tmp/modern-probe/libs checkout -q FETCH_HEAD
+{{- else }}
+curl --silent -SL {{ .ModuleDownloadURL }} | tar -xzf - --strip-components=1 -C /tmp/modern-probe/libs
+{{- end }}
+cd /tmp/modern-probe/build
+cmake -DUSE_BUNDLED_DEPS=ON -DBUILD_LIBSCAP_MODERN_BPF=ON -DBUILD_DRIVER=OFF -DBUILD_BPF=OFF -DCREATE_TEST_TARGETS=OFF \
+	-DMODERN_CLANG_EXE=/usr/bin/clang-{{ .LLVMVersion }} -DMODERN_BPFTOOL_EXE=$(command -v bpftool) /tmp/modern-probe/libs
+make {{ with .MakeFlags }}{{ . }} {{ end }}ProbeSkeleton
+mkdir -p {{ .ModernProbeDir }}
+cp $(find /tmp/modern-probe/build -name {{ .ModernProbeFileName }} | head -n 1) {{ .ModernProbeFullPath }}
+ls -l {{ .ModernProbeFullPath }}
@@ -0,0 +1,65 @@
+#!/bin/bash
+set -xeuo pipefail
+{{- range $name, $value := .Env }}
+export {{ $name }}={{ shellquote $value }}
+{{- end }}
+
+rm -Rf {{ .DriverBuildDir }}
+mkdir {{ .DriverBuildDir }}
+rm -Rf /tmp/module-download
+mkdir -p /tmp/module-download
+
+{{ template "module-download" . }}
+mv /tmp/module-download/*/driver/* {{ .DriverBuildDir }}
+
+cp /driverkit/module-Makefile {{ .DriverBuildDir }}/Makefile
+bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
+
+{{ with .CrossCompile }}
+# Install the cross toolchain, unless the builder image comes with it
+command -v {{ . }}gcc >/dev/null || (apt-get update && apt-get install -y --no-install-recommends gcc-{{ triple . }})
+{{ end }}
+
+# Install the gcc of the build, unless the builder image comes with it
+command -v {{ .CrossCompile }}gcc-{{ .GCCVersion }} >/dev/null || (apt-get update && apt-get install -y --no-install-recommends gcc-{{ .GCCVersion }}{{ with .CrossCompile }}-{{ triple . }}{{ end }})
+
+# Fetch the kernel
+mkdir /tmp/kernel-download
+cd /tmp/kernel-download
+{{ range $url := .KernelDownloadURLS }}
+curl --silent {{ with $.KernelCurlConfig }}--config {{ . }} {{ end }}-o kernel.deb -SL {{ $url }}
+{{ with index $.KernelChecksums $url }}echo "{{ . }}  kernel.deb" | sha256sum -c -{{ end }}
+ar x kernel.deb
+tar -xf data.tar.*
+rm -f data.tar.* control.tar.* debian-binary kernel.deb
+{{ end }}
+
+# The package ships the build trees of all the kernel flavors
+if [ ! -d usr/src/linux-headers-{{ .KernelRelease }} ]; then
+	echo "kernel headers for {{ .KernelRelease }} not found, available ones:" $(ls usr/src)
+	exit 1
+fi
+rm -Rf /tmp/kernel
+mkdir -p /tmp/kernel
+mv usr/src/linux-headers-{{ .KernelRelease }}/* /tmp/kernel
+
+{{ if .BuildModule }}
+{{ template "artifact-begin" "module" }}
+# Build the module
+cd {{ .DriverBuildDir }}
+make {{ with .MakeFlags }}{{ . }} {{ end }}ARCH={{ .KernelArch }}{{ with .CrossCompile }} CROSS_COMPILE={{ . }}{{ end }} CC={{ if .UseCcache }}"ccache /usr/bin/{{ .CrossCompile }}gcc-{{ .GCCVersion }}"{{ else }}/usr/bin/{{ .CrossCompile }}gcc-{{ .GCCVersion }}{{ end }} KERNELDIR=/tmp/kernel
+mv {{ .ModuleDriverName }}.ko {{ .ModuleFullPath }}
+{{ .CrossCompile }}strip -g {{ .ModuleFullPath }}
+# Print results
+modinfo {{ .ModuleFullPath }}
+{{ template "artifact-end" "module" }}
+{{ end }}
+
+{{ if .BuildProbe }}
+{{ template "artifact-begin" "probe" }}
+# Build the eBPF probe
+cd {{ .DriverBuildDir }}/bpf
+make {{ with .MakeFlags }}{{ . }} {{ end }}ARCH={{ .KernelArch }}{{ with .CrossCompile }} CROSS_COMPILE={{ . }}{{ end }} LLC=/usr/bin/llc-{{ .LLVMVersion }} CLANG="/usr/bin/clang-{{ .LLVMVersion }}{{ with .CrossCompile }} --target={{ triple . }}{{ end }}" CC=/usr/bin/{{ .CrossCompile }}gcc-{{ .GCCVersion }} KERNELDIR=/tmp/kernel
+ls -l probe.o
+{{ template "artifact-end" "probe" }}
+{{ end }}
@@ -1,12 +1,15 @@
 #!/bin/bash
 set -xeuo pipefail
+{{- range $name, $value := .Env }}
+export {{ $name }}={{ shellquote $value }}
+{{- end }}
 
 rm -Rf {{ .DriverBuildDir }}
 mkdir {{ .DriverBuildDir }}
 rm -Rf /tmp/module-download
 mkdir -p /tmp/module-download
 
-curl --silent -SL {{ .ModuleDownloadURL }} | tar -xzf - -C /tmp/module-download
+{{ template "module-download" . }}
 mv /tmp/module-download/*/driver/* {{ .DriverBuildDir }}
 
 cp /driverkit/module-Makefile {{ .DriverBuildDir }}/Makefile
@@ -16,26 +19,45 @@ bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
 rm -Rf /tmp/kernel-download
 mkdir /tmp/kernel-download
 cd /tmp/kernel-download
+{{- if .KernelDownloadURL }}
+# Download the kernel from the Red Hat CDN with the entitlement, removed as soon as it is done with
+redhatcacert=""
+if [ -f /etc/rhsm/ca/redhat-uep.pem ]; then redhatcacert="--cacert /etc/rhsm/ca/redhat-uep.pem"; fi
+curl --silent {{ with $.KernelCurlConfig }}--config {{ . }} {{ end }}-SL $redhatcacert --cert {{ .RHELEntitlementCertPath }} --key {{ .RHELEntitlementKeyPath }} -o kernel-devel-{{ .KernelPackage }}.rpm {{ .KernelDownloadURL }} || { rm -rf {{ .RHELEntitlementDir }} 2>/dev/null; exit 1; }
+rm -rf {{ .RHELEntitlementDir }} 2>/dev/null || true
+{{ with .KernelChecksum }}echo "{{ . }}  kernel-devel-{{ $.KernelPackage }}.rpm" | sha256sum -c -{{ end }}
+{{- else }}
 yum install -y --downloadonly --downloaddir=/tmp/kernel-download kernel-devel-0:{{ .KernelPackage }}
+{{- end }}
 rpm2cpio kernel-devel-{{ .KernelPackage }}.rpm | cpio --extract --make-directories
 
 rm -Rf /tmp/kernel
 mkdir -p /tmp/kernel
 mv usr/src/kernels/*/* /tmp/kernel
 
+{{ with .GCCVersion }}
+# Change current gcc, the one of the gcc-toolset or devtoolset Software Collection of the version
+yum install -y gcc-toolset-{{ . }}-gcc || yum install -y devtoolset-{{ . }}-gcc
+if [ -f /opt/rh/gcc-toolset-{{ . }}/enable ]; then source /opt/rh/gcc-toolset-{{ . }}/enable; else source /opt/rh/devtoolset-{{ . }}/enable; fi
+{{ end }}
+
 {{ if .BuildModule }}
+{{ template "artifact-begin" "module" }}
 # Build the module
 cd {{ .DriverBuildDir }}
-make KERNELDIR=/tmp/kernel
+make {{ with .MakeFlags }}{{ . }} {{ end }}KERNELDIR=/tmp/kernel{{ if .UseCcache }} CC="ccache gcc"{{ end }}
 mv {{ .ModuleDriverName }}.ko {{ .ModuleFullPath }}
 strip -g {{ .ModuleFullPath }}
 # Print results
 modinfo {{ .ModuleFullPath }}
+{{ template "artifact-end" "module" }}
 {{ end }}
 
 {{ if .BuildProbe }}
+{{ template "artifact-begin" "probe" }}
 # Build the eBPF probe
 cd {{ .DriverBuildDir }}/bpf
-make LLC=/usr/bin/llc CLANG=/usr/bin/clang CC=/usr/bin/gcc KERNELDIR=/tmp/kernel
+make {{ with .MakeFlags }}{{ . }} {{ end }}LLC=/usr/bin/llc CLANG=/usr/bin/clang CC=/usr/bin/gcc KERNELDIR=/tmp/kernel
 ls -l probe.o
+{{ template "artifact-end" "probe" }}
 {{ end }}
\ No newline at end of file
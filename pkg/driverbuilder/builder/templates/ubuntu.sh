@@ -1,22 +1,31 @@
 #!/bin/bash
 set -xeuo pipefail
+{{- range $name, $value := .Env }}
+export {{ $name }}={{ shellquote $value }}
+{{- end }}
 
 rm -Rf {{ .DriverBuildDir }}
 mkdir {{ .DriverBuildDir }}
 rm -Rf /tmp/module-download
 mkdir -p /tmp/module-download
 
-curl --silent -SL {{ .ModuleDownloadURL }} | tar -xzf - -C /tmp/module-download
+{{ template "module-download" . }}
 mv /tmp/module-download/*/driver/* {{ .DriverBuildDir }}
 
 cp /driverkit/module-Makefile {{ .DriverBuildDir }}/Makefile
 bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
 
+{{ with .CrossCompile }}
+# Install the cross toolchain, unless the builder image comes with it
+command -v {{ . }}gcc >/dev/null || (apt-get update && apt-get install -y --no-install-recommends gcc-{{ triple . }})
+{{ end }}
+
 # Fetch the kernel
 mkdir /tmp/kernel-download
 cd /tmp/kernel-download
 {{range $url := .KernelDownloadURLS}}
-curl --silent -o kernel.deb -SL {{ $url }}
+curl --silent {{ with $.KernelCurlConfig }}--config {{ . }} {{ end }}-o kernel.deb -SL {{ $url }}
+{{ with index $.KernelChecksums $url }}echo "{{ . }}  kernel.deb" | sha256sum -c -{{ end }}
 ar x kernel.deb
 tar -xf data.tar.*
 {{end}}
@@ -24,34 +33,53 @@ tar -xf data.tar.*
 cd /tmp/kernel-download/usr/src/
 sourcedir=$(find . -type d -name "{{ .KernelHeadersPattern }}" | head -n 1 | xargs readlink -f)
 
-# Change current gcc
+# Change current gcc, installing it unless the builder image comes with it
+command -v gcc-{{ .GCCVersion }} >/dev/null || (apt-get update && apt-get install -y --no-install-recommends gcc-{{ .GCCVersion }})
 ln -sf /usr/bin/gcc-{{ .GCCVersion }} /usr/bin/gcc
 
 {{ if .BuildModule }}
+{{ template "artifact-begin" "module" }}
 # Build the module
 cd {{ .DriverBuildDir }}
-make KERNELDIR=$sourcedir
+make {{ with .MakeFlags }}{{ . }} {{ end }}ARCH={{ .KernelArch }}{{ with .CrossCompile }} CROSS_COMPILE={{ . }}{{ end }} KERNELDIR=$sourcedir{{ if .UseCcache }} CC="ccache {{ .CrossCompile }}gcc"{{ end }}
 mv {{ .ModuleDriverName }}.ko {{ .ModuleFullPath }}
-strip -g {{ .ModuleFullPath }}
+{{ .CrossCompile }}strip -g {{ .ModuleFullPath }}
 # Print results
 modinfo {{ .ModuleFullPath }}
+{{ template "artifact-end" "module" }}
 {{ end }}
 
 {{ if .BuildProbe }}
+{{ template "artifact-begin" "probe" }}
 # Build the eBPF probe
 cd {{ .DriverBuildDir }}/bpf
+{{ if .LLVMVersionChosen }}
+LLC_BIN=/usr/bin/llc-{{ .LLVMVersion }}
+CLANG_BIN=/usr/bin/clang-{{ .LLVMVersion }}
+{{ else }}
 if [[ -x /usr/bin/llc ]]; then
 	LLC_BIN=/usr/bin/llc
 else
-	LLC_BIN=/usr/bin/llc-7
+	LLC_BIN=/usr/bin/llc-{{ .LLVMVersion }}
 fi
 
 if [[ -x /usr/bin/clang ]]; then
 	CLANG_BIN=/usr/bin/clang
 else
-	CLANG_BIN=/usr/bin/clang-7
+	CLANG_BIN=/usr/bin/clang-{{ .LLVMVersion }}
 fi
+{{ end }}
 
-make LLC=$LLC_BIN CLANG=$CLANG_BIN CC=/usr/bin/gcc-8 KERNELDIR=$sourcedir
+make {{ with .MakeFlags }}{{ . }} {{ end }}ARCH={{ .KernelArch }}{{ with .CrossCompile }} CROSS_COMPILE={{ . }}{{ end }} LLC=$LLC_BIN CLANG="$CLANG_BIN{{ with .CrossCompile }} --target={{ triple . }}{{ end }}" CC=/usr/bin/{{ .CrossCompile }}gcc-8 KERNELDIR=$sourcedir
 ls -l probe.o
+{{ template "artifact-end" "probe" }}
+{{ end }}
+
+{{ if .BuildBTF }}
+# Generate the BTF of the kernel from the vmlinux of its debug package
+vmlinux=$(find /tmp/kernel-download -path "*/debug/*" -type f \( -name vmlinux -o -name "vmlinux-*" \) | head -n 1)
+mkdir -p $(dirname {{ .BTFFullPath }})
+{{ .CrossCompile }}readelf -S $vmlinux | grep -q "\.BTF" || pahole -J $vmlinux
+{{ .CrossCompile }}objcopy --only-section=.BTF --set-section-flags .BTF=alloc -O binary $vmlinux {{ .BTFFullPath }}
+ls -l {{ .BTFFullPath }}
 {{ end }}
\ No newline at end of file
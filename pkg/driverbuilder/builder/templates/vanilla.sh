@@ -1,21 +1,43 @@
 #!/bin/bash
 set -xeuo pipefail
+{{- range $name, $value := .Env }}
+export {{ $name }}={{ shellquote $value }}
+{{- end }}
 
 rm -Rf {{ .DriverBuildDir }}
 mkdir {{ .DriverBuildDir }}
 rm -Rf /tmp/module-download
 mkdir -p /tmp/module-download
 
-curl --silent -SL {{ .ModuleDownloadURL }} | tar -xzf - -C /tmp/module-download
+{{ template "module-download" . }}
 mv /tmp/module-download/*/driver/* {{ .DriverBuildDir }}
 
 cp /driverkit/module-Makefile {{ .DriverBuildDir }}/Makefile
 bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
 
+{{ with .CrossCompile }}
+# Install the cross toolchain, unless the builder image comes with it
+command -v {{ . }}gcc >/dev/null || (apt-get update && apt-get install -y --no-install-recommends gcc-{{ triple . }})
+{{ end }}
+
+# Change current gcc, installing it unless the builder image comes with it
+command -v {{ .CrossCompile }}gcc-{{ .GCCVersion }} >/dev/null || (apt-get update && apt-get install -y --no-install-recommends gcc-{{ .GCCVersion }}{{ with .CrossCompile }}-{{ triple . }}{{ end }})
+ln -sf /usr/bin/gcc-{{ .GCCVersion }} /usr/bin/gcc
+
 # Fetch the kernel
 cd /tmp
 mkdir /tmp/kernel-download
-curl --silent -SL {{ .KernelDownloadURL }} | tar -Jxf - -C /tmp/kernel-download
+curl --silent {{ with $.KernelCurlConfig }}--config {{ . }} {{ end }}-SL {{ .KernelDownloadURL }} -o /tmp/{{ .KernelTarball }}
+{{ with .KernelSignatureURL }}
+# Verify the kernel against its detached signature, made by one of the pinned kernel.org keys
+command -v gpg >/dev/null || (apt-get update && apt-get install -y --no-install-recommends gnupg)
+export GNUPGHOME=$(mktemp -d)
+gpg --batch --keyserver hkps://keyserver.ubuntu.com --recv-keys {{ $.KernelSigningKeys }}
+gpg --batch --export {{ $.KernelSigningKeys }} > /tmp/kernel-keys.gpg
+curl --silent -SL {{ . }} -o /tmp/kernel.tar.sign
+xz -dc /tmp/{{ $.KernelTarball }} | gpgv --keyring /tmp/kernel-keys.gpg /tmp/kernel.tar.sign -
+{{ end }}
+tar -xf /tmp/{{ .KernelTarball }} -C /tmp/kernel-download
 rm -Rf /tmp/kernel
 mkdir -p /tmp/kernel
 mv /tmp/kernel-download/*/* /tmp/kernel
@@ -28,23 +50,39 @@ cp /driverkit/kernel.config /tmp/kernel.config
 sed -i 's/^CONFIG_LOCALVERSION=.*$/CONFIG_LOCALVERSION="{{ .KernelLocalVersion }}"/' /tmp/kernel.config
 {{ end }}
 
-make KCONFIG_CONFIG=/tmp/kernel.config oldconfig
-make KCONFIG_CONFIG=/tmp/kernel.config prepare
-make KCONFIG_CONFIG=/tmp/kernel.config modules_prepare
+{{- if .KernelConfigFragments }}
+# Merge the kernel config fragments into the kernel config
+rm -Rf /tmp/kernel-config-fragments /tmp/kernel-config-merge
+mkdir -p /tmp/kernel-config-fragments /tmp/kernel-config-merge
+{{- range $i, $fragment := .KernelConfigFragments }}
+printf '%s\n' {{ shellquote $fragment }} > /tmp/kernel-config-fragments/{{ $i }}.config
+{{- end }}
+bash scripts/kconfig/merge_config.sh -m -O /tmp/kernel-config-merge /tmp/kernel.config{{ range $i, $fragment := .KernelConfigFragments }} /tmp/kernel-config-fragments/{{ $i }}.config{{ end }}
+cp /tmp/kernel-config-merge/.config /tmp/kernel.config
+cat /tmp/kernel.config
+{{- end }}
+
+make {{ with .MakeFlags }}{{ . }} {{ end }}ARCH={{ .KernelArch }}{{ with .CrossCompile }} CROSS_COMPILE={{ . }}{{ end }} KCONFIG_CONFIG=/tmp/kernel.config {{ if .KernelConfigFragments }}olddefconfig{{ else }}oldconfig{{ end }}
+make {{ with .MakeFlags }}{{ . }} {{ end }}ARCH={{ .KernelArch }}{{ with .CrossCompile }} CROSS_COMPILE={{ . }}{{ end }} KCONFIG_CONFIG=/tmp/kernel.config prepare
+make {{ with .MakeFlags }}{{ . }} {{ end }}ARCH={{ .KernelArch }}{{ with .CrossCompile }} CROSS_COMPILE={{ . }}{{ end }} KCONFIG_CONFIG=/tmp/kernel.config modules_prepare
 
 {{ if .BuildModule }}
+{{ template "artifact-begin" "module" }}
 # Build the kernel module
 cd {{ .DriverBuildDir }}
-make KERNELDIR=/tmp/kernel
+make {{ with .MakeFlags }}{{ . }} {{ end }}ARCH={{ .KernelArch }}{{ with .CrossCompile }} CROSS_COMPILE={{ . }}{{ end }} KERNELDIR=/tmp/kernel{{ if .UseCcache }} CC="ccache {{ .CrossCompile }}gcc"{{ end }}
 mv {{ .ModuleDriverName }}.ko {{ .ModuleFullPath }}
-strip -g {{ .ModuleFullPath }}
+{{ .CrossCompile }}strip -g {{ .ModuleFullPath }}
 # Print results
 modinfo {{ .ModuleFullPath }}
+{{ template "artifact-end" "module" }}
 {{ end }}
 
 {{ if .BuildProbe }}
+{{ template "artifact-begin" "probe" }}
 # Build the eBPF probe
 cd {{ .DriverBuildDir }}/bpf
-make LLC=/usr/bin/llc-7 CLANG=/usr/bin/clang-7 CC=/usr/bin/gcc-8 KERNELDIR=/tmp/kernel
+make {{ with .MakeFlags }}{{ . }} {{ end }}ARCH={{ .KernelArch }}{{ with .CrossCompile }} CROSS_COMPILE={{ . }}{{ end }} LLC=/usr/bin/llc-{{ .LLVMVersion }} CLANG="/usr/bin/clang-{{ .LLVMVersion }}{{ with .CrossCompile }} --target={{ triple . }}{{ end }}" CC=/usr/bin/{{ .CrossCompile }}gcc-{{ .GCCVersion }} KERNELDIR=/tmp/kernel
 ls -l probe.o
+{{ template "artifact-end" "probe" }}
 {{ end }}
\ No newline at end of file
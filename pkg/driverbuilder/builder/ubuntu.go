@@ -1,12 +1,13 @@
 package builder
 
 import (
-	"bytes"
+	"context"
 	_ "embed"
 	"fmt"
+	"net/url"
+	"path"
 	"regexp"
 	"strings"
-	"text/template"
 
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 )
@@ -32,42 +33,93 @@ func init() {
 // ubuntu is a driverkit target.
 type ubuntu struct{}
 
+// Metadata implements MetadataProvider, the kernel version tells the package version of the kernel
+// and the BTF is generated from the debug symbols packages.
+func (v ubuntu) Metadata() Metadata {
+	m := DefaultMetadata()
+	m.BTF = true
+	m.Architectures = append(m.Architectures, "ppc64le", "s390x", "riscv64")
+	m.RequiresKernelVersion = true
+	return m
+}
+
+// Mirrors implements MirrorsProvider, the pools of the ports for the architectures other than amd64.
+func (v ubuntu) Mirrors(arch kernelrelease.Architecture) []string {
+	if arch.String() != "amd64" {
+		return ubuntuPortsBaseURLs
+	}
+	return ubuntuBaseURLs
+}
+
+// ubuntuExtraversionPattern matches the extraversions of the Ubuntu kernels, <abi>[-<flavor>], e.g. 25-generic or 1019-aws.
+var ubuntuExtraversionPattern = regexp.MustCompile(`^\d+(-[a-z-]+[a-z](-*\d.*)?)?$`)
+
+// Validate implements Validator, the packages are looked up by the ABI and the flavor of the kernel release.
+func (v ubuntu) Validate(c Config, kr kernelrelease.KernelRelease) error {
+	if c.KernelUrls != nil {
+		return nil
+	}
+	if !ubuntuExtraversionPattern.MatchString(kr.Extraversion) {
+		return fmt.Errorf("invalid ubuntu kernel release %s, it must end with -<abi>-<flavor>, e.g. 5.15.0-25-generic or 4.15.0-1129-aws", c.KernelRelease)
+	}
+	return nil
+}
+
 // ubuntuTemplateData stores information to be templated into the shell script
 type ubuntuTemplateData struct {
+	buildTemplateData
 	DriverBuildDir       string
 	ModuleDownloadURL    string
 	KernelDownloadURLS   []string
+	KernelChecksums      map[string]string
 	KernelLocalVersion   string
 	KernelHeadersPattern string
 	ModuleDriverName     string
 	ModuleFullPath       string
 	BuildProbe           bool
 	BuildModule          bool
+	BuildBTF             bool
+	BTFFullPath          string
 	GCCVersion           string
+	KernelArch           string
+	LLVMVersion          string
+	CrossCompile         string
+	// LLVMVersionChosen tells whether the LLVM version is given, the unversioned clang of the builder image is preferred otherwise.
+	LLVMVersionChosen bool
 }
 
-// Script compiles the script to build the kernel module and/or the eBPF probe.
-func (v ubuntu) Script(c Config, kr kernelrelease.KernelRelease) (string, error) {
-
-	t := template.New(string(TargetTypeUbuntu))
+// ubuntuURLCategories are the packages an ubuntu build needs one of each:
+// the headers of the flavor, of the architecture, and the arch independent ones, e.g. linux-aws-headers-4.15.0-1129_4.15.0-1129.138_all.deb.
+var ubuntuURLCategories = []urlCategory{
+	{"kernel headers", func(name string) bool { return !strings.HasSuffix(name, "_all.deb") }},
+	{"kernel headers common", func(name string) bool { return strings.HasSuffix(name, "_all.deb") }},
+}
 
-	parsed, err := t.Parse(ubuntuTemplate)
+// Script compiles the script to build the kernel module and/or the eBPF probe.
+func (v ubuntu) Script(c Config, kr kernelrelease.KernelRelease, sources KernelSources) (string, error) {
+	parsed, err := parseTemplate(c, string(TargetTypeUbuntu), ubuntuTemplate, ubuntuTemplateData{})
 	if err != nil {
 		return "", err
 	}
+	return executeTemplate(parsed, sources.TemplateData)
+}
 
+// ResolveKernelSources implements Builder, resolving the kernel packages of the build.
+func (v ubuntu) ResolveKernelSources(ctx context.Context, c Config, kr kernelrelease.KernelRelease) (KernelSources, error) {
 	var urls []string
+	var attempts []urlAttempt
+	var err error
 	if c.KernelUrls == nil {
-		urls, err = ubuntuHeadersURLFromRelease(kr, c.Build.KernelVersion)
+		urls, err = ubuntuHeadersURLFromRelease(ctx, kr, c.Build.KernelVersion)
 	} else {
-		urls, err = getResolvingURLs(c.KernelUrls)
+		urls, attempts, err = resolveURLs(ctx, c.KernelUrls)
 	}
 	// if there was an error
 	if err != nil {
-		return "", err
+		return KernelSources{}, err
 	}
-	if len(urls) < 2 {
-		return "", fmt.Errorf("specific kernel headers not found")
+	if err := requireURLCategories(ubuntuURLCategories, urls, attempts); err != nil {
+		return KernelSources{}, err
 	}
 
 	// parse the flavor out of the kernelrelease extraversion
@@ -82,43 +134,124 @@ func (v ubuntu) Script(c Config, kr kernelrelease.KernelRelease) (string, error)
 		headersPattern = fmt.Sprintf("linux-headers*%s", flavor)
 	}
 
+	sums, err := kernelChecksums(ctx, c, urls, debianChecksums(kr.Architecture.ToDeb(), ubuntuArchiveKeys, ubuntuSuites(kr)))
+	if err != nil {
+		return KernelSources{}, err
+	}
+	urls, sums, buildBTF, err := withDebugPackage(ctx, c, urls, sums, func() []string { return ubuntuDebugURLs(kr, urls) }, debianChecksums(kr.Architecture.ToDeb(), ubuntuArchiveKeys, ubuntuSuites(kr)))
+	if err != nil {
+		return KernelSources{}, err
+	}
+	crossCompilePrefix, err := crossCompile(c)
+	if err != nil {
+		return KernelSources{}, err
+	}
+
 	td := ubuntuTemplateData{
 		DriverBuildDir:       DriverDirectory,
 		ModuleDownloadURL:    moduleDownloadURL(c),
 		KernelDownloadURLS:   urls,
+		KernelChecksums:      sums,
 		KernelLocalVersion:   kr.FullExtraversion,
 		KernelHeadersPattern: headersPattern,
 		ModuleDriverName:     c.Build.ModuleDriverName,
 		ModuleFullPath:       ModuleFullPath,
 		BuildModule:          len(c.Build.ModuleFilePath) > 0,
 		BuildProbe:           len(c.Build.ProbeFilePath) > 0,
-		GCCVersion:           ubuntuGCCVersionFromKernelRelease(kr),
+		BuildBTF:             buildBTF,
+		BTFFullPath:          BTFFullPath,
+		GCCVersion:           gccVersion(c, ubuntuGCCVersionFromKernelRelease(kr)),
+		KernelArch:           kr.Architecture.ToKernel(),
+		LLVMVersion:          llvmVersion(c, "7"),
+		LLVMVersionChosen:    len(c.LLVMVersion) > 0,
+		CrossCompile:         crossCompilePrefix,
 	}
-
-	buf := bytes.NewBuffer(nil)
-	err = parsed.Execute(buf, td)
-	if err != nil {
-		return "", err
-	}
-	return buf.String(), nil
+	td.buildTemplateData = newBuildTemplateData(c)
+	return KernelSources{URLs: urls, TemplateData: td}, nil
 }
 
-func ubuntuHeadersURLFromRelease(kr kernelrelease.KernelRelease, kv string) ([]string, error) {
+// ubuntuCodenames are the codenames of the Ubuntu releases by their number, and by the version of the kernel they ship.
+var ubuntuCodenames = map[string]string{
+	"3.13":  "trusty",
+	"4.4":   "xenial",
+	"4.15":  "bionic",
+	"4.18":  "cosmic",
+	"5.0":   "disco",
+	"5.3":   "eoan",
+	"5.4":   "focal",
+	"5.8":   "groovy",
+	"5.11":  "hirsute",
+	"5.13":  "impish",
+	"5.15":  "jammy",
+	"5.19":  "kinetic",
+	"6.2":   "lunar",
+	"6.5":   "mantic",
+	"6.8":   "noble",
+	"6.11":  "oracular",
+	"6.14":  "plucky",
+	"6.17":  "questing",
+	"14.04": "trusty",
+	"16.04": "xenial",
+	"18.04": "bionic",
+	"18.10": "cosmic",
+	"19.04": "disco",
+	"19.10": "eoan",
+	"20.04": "focal",
+	"20.10": "groovy",
+	"21.04": "hirsute",
+	"21.10": "impish",
+	"22.04": "jammy",
+	"22.10": "kinetic",
+	"23.04": "lunar",
+	"23.10": "mantic",
+	"24.04": "noble",
+	"24.10": "oracular",
+	"25.04": "plucky",
+	"25.10": "questing",
+}
 
-	// decide which mirrors to use based on the architecture passed in
-	baseURLs := []string{}
-	if kr.Architecture.String() == "amd64" {
-		baseURLs = []string{
-			"https://mirrors.edge.kernel.org/ubuntu/pool/main/l",
-			"http://security.ubuntu.com/ubuntu/pool/main/l",
+// ubuntuSuites returns the suites of the release a package of the kernel belongs to:
+// the one it was backported to, or else the one shipping the version of the kernel. None when the release is unknown.
+// Example: Input -> linux-headers-4.18.0-24-generic_4.18.0-24.25~18.04.1_amd64.deb, Output -> bionic, bionic-updates and bionic-security
+func ubuntuSuites(kr kernelrelease.KernelRelease) func(u string) []string {
+	return func(u string) []string {
+		release := fmt.Sprintf("%d.%d", kr.Version, kr.PatchLevel)
+		if match := ubuntuSeriesPattern.FindStringSubmatch(path.Base(u)); match != nil {
+			release = match[1]
 		}
-	} else {
-		baseURLs = []string{
-			// arm64 and others are hosted on ports.ubuntu.com
-			// but they will resolve for amd64 without this if logic
-			"http://ports.ubuntu.com/ubuntu-ports/pool/main/l",
+		codename, ok := ubuntuCodenames[release]
+		if !ok {
+			return nil
 		}
+		return []string{codename, codename + "-updates", codename + "-security"}
+	}
+}
+
+// ubuntuLaunchpadArchiveURL is the Launchpad API endpoint of the Ubuntu primary archive,
+// it keeps track of every package ever published, even when removed from the mirrors.
+const ubuntuLaunchpadArchiveURL = "https://api.launchpad.net/1.0/ubuntu/+archive/primary"
+
+var (
+	// ubuntuBaseURLs are the pools of the amd64 packages.
+	ubuntuBaseURLs = []string{
+		"https://mirrors.edge.kernel.org/ubuntu/pool/main/l",
+		"http://security.ubuntu.com/ubuntu/pool/main/l",
+		// EOL releases are moved here
+		"http://old-releases.ubuntu.com/ubuntu/pool/main/l",
+	}
+	// ubuntuPortsBaseURLs are the pools of the packages of the other architectures, e.g. arm64, ppc64el, s390x or riscv64,
+	// the amd64 ones would resolve there too.
+	ubuntuPortsBaseURLs = []string{
+		"http://ports.ubuntu.com/ubuntu-ports/pool/main/l",
+		// EOL releases are moved here
+		"http://old-releases.ubuntu.com/ubuntu/pool/main/l",
 	}
+)
+
+func ubuntuHeadersURLFromRelease(ctx context.Context, kr kernelrelease.KernelRelease, kv string) ([]string, error) {
+
+	// decide which mirrors to use based on the architecture passed in
+	baseURLs := mirrorsFrom(ctx, ubuntu{}.Mirrors(kr.Architecture))
 
 	for _, url := range baseURLs {
 		// get all possible URLs
@@ -127,15 +260,93 @@ func ubuntuHeadersURLFromRelease(kr kernelrelease.KernelRelease, kv string) ([]s
 			return nil, err
 		}
 		// try resolving the URLs
-		urls, err := getResolvingURLs(possibleURLs)
+		urls, err := getResolvingURLs(ctx, possibleURLs)
 		// there should be 2 urls returned - the _all.deb package and the _{arch}.deb package
 		if err == nil && len(urls) == 2 {
 			return urls, err
 		}
+		// HWE kernels are built from sources of their own
+		urls, err = fetchUbuntuHWEKernelURLs(ctx, url, kr, kv)
+		if err == nil {
+			return getResolvingURLs(ctx, urls)
+		}
+	}
+
+	// last resort, ask Launchpad where the packages are
+	urls, err := fetchUbuntuLaunchpadKernelURLs(ctx, kr, kv)
+	if err == nil && len(urls) == 2 {
+		return urls, nil
 	}
 
 	// packages weren't found, return error out
-	return nil, fmt.Errorf("kernel headers not found")
+	return nil, fmt.Errorf("kernel headers not found in: %s", strings.Join(append(baseURLs, ubuntuLaunchpadArchiveURL), ", "))
+}
+
+type ubuntuLaunchpadBinaries struct {
+	Entries []struct {
+		SelfLink             string `json:"self_link"`
+		DistroArchSeriesLink string `json:"distro_arch_series_link"`
+		ArchitectureSpecific bool   `json:"architecture_specific"`
+		BinaryPackageVersion string `json:"binary_package_version"`
+	} `json:"entries"`
+}
+
+// fetchUbuntuLaunchpadKernelURLs looks for the published header packages using the Launchpad API,
+// returning the librarian URLs of the _{arch}.deb package and of the _all.deb package.
+func fetchUbuntuLaunchpadKernelURLs(ctx context.Context, kr kernelrelease.KernelRelease, kernelVersion string) ([]string, error) {
+	firstExtra, ubuntuFlavor := parseUbuntuExtraVersion(kr.Extraversion)
+	version := fmt.Sprintf("%s-%s.%s", kr.Fullversion, firstExtra, kernelVersion)
+
+	archPackages := []string{
+		fmt.Sprintf("linux-headers-%s-%s-%s", kr.Fullversion, firstExtra, ubuntuFlavor),
+	}
+	allPackages := []string{
+		fmt.Sprintf("linux-headers-%s-%s", kr.Fullversion, firstExtra),
+		fmt.Sprintf("linux-%s-headers-%s-%s", ubuntuFlavor, kr.Fullversion, firstExtra),
+		fmt.Sprintf("linux-%s-%d.%d-headers-%s-%s", ubuntuFlavor, kr.Version, kr.PatchLevel, kr.Fullversion, firstExtra),
+	}
+
+	urls := []string{}
+	for _, candidates := range [][]string{archPackages, allPackages} {
+		for _, name := range candidates {
+			u, err := fetchUbuntuLaunchpadBinaryURL(ctx, name, version, kr.Architecture.ToDeb())
+			if err == nil {
+				urls = append(urls, u)
+				break
+			}
+		}
+	}
+	return urls, nil
+}
+
+func fetchUbuntuLaunchpadBinaryURL(ctx context.Context, name, version, arch string) (string, error) {
+	q := url.Values{}
+	q.Set("ws.op", "getPublishedBinaries")
+	q.Set("binary_name", name)
+	q.Set("version", version)
+	q.Set("exact_match", "true")
+
+	binaries := ubuntuLaunchpadBinaries{}
+	if err := getJSON(ctx, fmt.Sprintf("%s?%s", ubuntuLaunchpadArchiveURL, q.Encode()), &binaries); err != nil {
+		return "", err
+	}
+
+	for _, e := range binaries.Entries {
+		// _all.deb packages are published for every architecture
+		if e.ArchitectureSpecific && !strings.HasSuffix(e.DistroArchSeriesLink, "/"+arch) {
+			continue
+		}
+		files := []string{}
+		if err := getJSON(ctx, fmt.Sprintf("%s?ws.op=binaryFileUrls", e.SelfLink), &files); err != nil {
+			return "", err
+		}
+		for _, f := range files {
+			if strings.HasSuffix(f, fmt.Sprintf("_%s.deb", arch)) || strings.HasSuffix(f, "_all.deb") {
+				return f, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("package %s not found", name)
 }
 
 func fetchUbuntuKernelURL(baseURL string, kr kernelrelease.KernelRelease, kernelVersion string) ([]string, error) {
@@ -166,15 +377,19 @@ func fetchUbuntuKernelURL(baseURL string, kr kernelrelease.KernelRelease, kernel
 
 	// piece together all possible naming patterns for packages
 	// 2 urls should resolve: an _{arch}.deb package and an _all.deb package
+	// the _all.deb package is named after the source package the flavor is built from
+	// examples:
+	// 		linux-headers-4.15.0-188_4.15.0-188.199_all.deb (generic, lowlatency < 5.15)
+	// 		linux-aws-headers-5.15.0-1052_5.15.0-1052.57_all.deb
+	// 		linux-azure-5.15-headers-5.15.0-1052_5.15.0-1052.60~20.04.1_all.deb
 	packageNamePatterns := []string{
 		fmt.Sprintf(
-			"linux-headers-%s%s_%s-%s.%s_%s_all.deb",
+			"linux-headers-%s-%s_%s-%s.%s_all.deb",
 			kr.Fullversion,
-			kr.FullExtraversion,
+			firstExtra,
 			kr.Fullversion,
 			firstExtra,
 			kernelVersion,
-			kr.Architecture.String(),
 		),
 		fmt.Sprintf(
 			"linux-headers-%s-%s-%s_%s-%s.%s_%s.deb",
@@ -184,7 +399,7 @@ func fetchUbuntuKernelURL(baseURL string, kr kernelrelease.KernelRelease, kernel
 			kr.Fullversion,
 			firstExtra,
 			kernelVersion,
-			kr.Architecture.String(),
+			kr.Architecture.ToDeb(),
 		),
 		fmt.Sprintf(
 			"linux-%s-headers-%s-%s_%s-%s.%s_all.deb",
@@ -195,6 +410,17 @@ func fetchUbuntuKernelURL(baseURL string, kr kernelrelease.KernelRelease, kernel
 			firstExtra,
 			kernelVersion,
 		),
+		fmt.Sprintf(
+			"linux-%s-%d.%d-headers-%s-%s_%s-%s.%s_all.deb",
+			ubuntuFlavor,
+			kr.Version,
+			kr.PatchLevel,
+			kr.Fullversion,
+			firstExtra,
+			kr.Fullversion,
+			firstExtra,
+			kernelVersion,
+		),
 		fmt.Sprintf(
 			"linux-headers-%s%s_%s-%s.%s_%s.deb",
 			kr.Fullversion,
@@ -202,7 +428,7 @@ func fetchUbuntuKernelURL(baseURL string, kr kernelrelease.KernelRelease, kernel
 			kr.Fullversion,
 			firstExtra,
 			kernelVersion,
-			kr.Architecture.String(),
+			kr.Architecture.ToDeb(),
 		),
 	}
 
@@ -221,6 +447,83 @@ func fetchUbuntuKernelURL(baseURL string, kr kernelrelease.KernelRelease, kernel
 	return deduplicateURLs(packageFullURLs), nil
 }
 
+// ubuntuGAKernels are the versions of the kernels the LTS series are released with, the other kernels of a series are HWE ones.
+var ubuntuGAKernels = map[string]string{
+	"14.04": "3.13",
+	"16.04": "4.4",
+	"18.04": "4.15",
+	"20.04": "5.4",
+	"22.04": "5.15",
+	"24.04": "6.8",
+}
+
+// ubuntuSeriesPattern matches the series the kernel version of a backported kernel ends with, e.g. 101~20.04.1.
+var ubuntuSeriesPattern = regexp.MustCompile(`~(\d+\.\d+)`)
+
+// ubuntuHWESources returns the source packages the HWE kernel may be built from, in order, none when the kernel version
+// tells the kernel is the GA one of its series. The linux-signed-* sources are not among them, they only ship the signed images.
+// Example: Input -> "5.15.0-91-generic", Output -> linux-hwe-5.15, linux-hwe, linux-hwe-5.15-edge, linux-hwe-edge
+func ubuntuHWESources(kr kernelrelease.KernelRelease, kernelVersion string) []string {
+	version := fmt.Sprintf("%d.%d", kr.Version, kr.PatchLevel)
+	if match := ubuntuSeriesPattern.FindStringSubmatch(kernelVersion); match != nil && ubuntuGAKernels[match[1]] == version {
+		return nil
+	}
+	_, flavor := parseUbuntuExtraVersion(kr.Extraversion)
+	sources := []string{
+		fmt.Sprintf("linux-hwe-%s", version),
+		"linux-hwe",
+		fmt.Sprintf("linux-hwe-%s-edge", version),
+		"linux-hwe-edge",
+	}
+	// e.g. linux-lowlatency-hwe-6.8
+	if flavor != "generic" && !strings.HasSuffix(flavor, "hwe") {
+		sources = append(sources, fmt.Sprintf("linux-%s-hwe-%s", flavor, version))
+	}
+	return sources
+}
+
+// fetchUbuntuHWEKernelURLs looks for the headers of the HWE kernel into the pools of its sources, listing them
+// since the versions of the packages end with the series the kernel is backported to, which the kernel version may omit.
+// Example: linux-hwe-5.15/linux-headers-5.15.0-91-generic_5.15.0-91.101~20.04.1_amd64.deb, linux-hwe-5.15/linux-hwe-5.15-headers-5.15.0-91_5.15.0-91.101~20.04.1_all.deb
+func fetchUbuntuHWEKernelURLs(ctx context.Context, baseURL string, kr kernelrelease.KernelRelease, kernelVersion string) ([]string, error) {
+	for _, source := range ubuntuHWESources(kr, kernelVersion) {
+		poolURL := fmt.Sprintf("%s/%s/", baseURL, source)
+		body, err := getIndex(ctx, poolURL)
+		if err != nil {
+			continue
+		}
+		if urls := ubuntuHWEKernelURLsFromIndex(poolURL, string(body), source, kr, kernelVersion); len(urls) == 2 {
+			return urls, nil
+		}
+	}
+	return nil, fmt.Errorf("kernel headers not found")
+}
+
+// ubuntuHWEKernelURLsFromIndex returns the _{arch}.deb and the _all.deb headers packages of the kernel listed in the index of the pool of the source,
+// the newest upload when the kernel version has no series.
+func ubuntuHWEKernelURLsFromIndex(poolURL, body, source string, kr kernelrelease.KernelRelease, kernelVersion string) []string {
+	firstExtra, flavor := parseUbuntuExtraVersion(kr.Extraversion)
+	abi := fmt.Sprintf("%s-%s", kr.Fullversion, firstExtra)
+	version := fmt.Sprintf(`%s\.%s(?:(?:~|%%7[Ee])[0-9.]+)?`, regexp.QuoteMeta(abi), regexp.QuoteMeta(kernelVersion))
+	archPattern := regexp.MustCompile(fmt.Sprintf(`href="(linux-headers-%s-%s_(%s)_%s\.deb)"`, regexp.QuoteMeta(abi), regexp.QuoteMeta(flavor), version, kr.Architecture.ToDeb()))
+
+	var urls []string
+	newest := ""
+	for _, match := range archPattern.FindAllStringSubmatch(body, -1) {
+		// the _all.deb package is named after the source
+		all := fmt.Sprintf("%s-headers-%s_%s_all.deb", source, abi, match[2])
+		if !strings.Contains(body, fmt.Sprintf(`href="%s"`, all)) {
+			continue
+		}
+		v := strings.NewReplacer("%7E", "~", "%7e", "~").Replace(match[2])
+		if urls == nil || compareDebianVersions(v, newest) > 0 {
+			urls = []string{poolURL + match[1], poolURL + all}
+			newest = v
+		}
+	}
+	return urls
+}
+
 // deduplicate the array of URLs to ensure we are
 // only get unique resolving URLs for packages
 func deduplicateURLs(urls []string) []string {
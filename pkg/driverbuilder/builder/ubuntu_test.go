@@ -1,8 +1,17 @@
 package builder
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 )
@@ -39,11 +48,11 @@ var tests = []struct {
 			err         error
 		}{
 			headersURLs: []string{},
-			urls:        []string{"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-4.15.0-188_4.15.0-188.199_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-4.15.0-188-generic_4.15.0-188.199_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-generic-headers-4.15.0-188_4.15.0-188.199_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-4.15.0-188_4.15.0-188.199_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-headers-4.15.0-188_4.15.0-188.199_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-headers-4.15.0-188-generic_4.15.0-188.199_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-generic-headers-4.15.0-188_4.15.0-188.199_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-headers-4.15.0-188_4.15.0-188.199_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-4.15/linux-headers-4.15.0-188_4.15.0-188.199_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-4.15/linux-headers-4.15.0-188-generic_4.15.0-188.199_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-4.15/linux-generic-headers-4.15.0-188_4.15.0-188.199_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-4.15/linux-headers-4.15.0-188_4.15.0-188.199_amd64.deb"},
+			urls:        []string{"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-4.15.0-188_4.15.0-188.199_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-4.15.0-188-generic_4.15.0-188.199_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-generic-headers-4.15.0-188_4.15.0-188.199_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-generic-4.15-headers-4.15.0-188_4.15.0-188.199_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-4.15.0-188_4.15.0-188.199_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-headers-4.15.0-188_4.15.0-188.199_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-headers-4.15.0-188-generic_4.15.0-188.199_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-generic-headers-4.15.0-188_4.15.0-188.199_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-generic-4.15-headers-4.15.0-188_4.15.0-188.199_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-headers-4.15.0-188_4.15.0-188.199_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-4.15/linux-headers-4.15.0-188_4.15.0-188.199_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-4.15/linux-headers-4.15.0-188-generic_4.15.0-188.199_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-4.15/linux-generic-headers-4.15.0-188_4.15.0-188.199_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-4.15/linux-generic-4.15-headers-4.15.0-188_4.15.0-188.199_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-4.15/linux-headers-4.15.0-188_4.15.0-188.199_amd64.deb"},
 			gccVersion:  "8",
 			firstExtra:  "188",
 			flavor:      "generic",
-			err:         fmt.Errorf("kernel headers not found"),
+			err:         fmt.Errorf("kernel headers not found in: https://mirrors.edge.kernel.org/ubuntu/pool/main/l, http://security.ubuntu.com/ubuntu/pool/main/l, http://old-releases.ubuntu.com/ubuntu/pool/main/l, https://api.launchpad.net/1.0/ubuntu/+archive/primary"),
 		},
 	},
 	{
@@ -66,7 +75,7 @@ var tests = []struct {
 			err         error
 		}{
 			headersURLs: []string{"http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws/linux-headers-4.15.0-1129-aws_4.15.0-1129.138_arm64.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws/linux-aws-headers-4.15.0-1129_4.15.0-1129.138_all.deb"},
-			urls:        []string{"http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux/linux-headers-4.15.0-1129-aws_4.15.0-1129.138_arm64_all.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux/linux-headers-4.15.0-1129-aws_4.15.0-1129.138_arm64.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux/linux-aws-headers-4.15.0-1129_4.15.0-1129.138_all.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws/linux-headers-4.15.0-1129-aws_4.15.0-1129.138_arm64_all.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws/linux-headers-4.15.0-1129-aws_4.15.0-1129.138_arm64.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws/linux-aws-headers-4.15.0-1129_4.15.0-1129.138_all.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws-4.15/linux-headers-4.15.0-1129-aws_4.15.0-1129.138_arm64_all.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws-4.15/linux-headers-4.15.0-1129-aws_4.15.0-1129.138_arm64.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws-4.15/linux-aws-headers-4.15.0-1129_4.15.0-1129.138_all.deb"},
+			urls:        []string{"http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux/linux-headers-4.15.0-1129_4.15.0-1129.138_all.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux/linux-headers-4.15.0-1129-aws_4.15.0-1129.138_arm64.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux/linux-aws-headers-4.15.0-1129_4.15.0-1129.138_all.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux/linux-aws-4.15-headers-4.15.0-1129_4.15.0-1129.138_all.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws/linux-headers-4.15.0-1129_4.15.0-1129.138_all.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws/linux-headers-4.15.0-1129-aws_4.15.0-1129.138_arm64.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws/linux-aws-headers-4.15.0-1129_4.15.0-1129.138_all.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws/linux-aws-4.15-headers-4.15.0-1129_4.15.0-1129.138_all.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws-4.15/linux-headers-4.15.0-1129_4.15.0-1129.138_all.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws-4.15/linux-headers-4.15.0-1129-aws_4.15.0-1129.138_arm64.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws-4.15/linux-aws-headers-4.15.0-1129_4.15.0-1129.138_all.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws-4.15/linux-aws-4.15-headers-4.15.0-1129_4.15.0-1129.138_all.deb"},
 			gccVersion:  "8",
 			firstExtra:  "1129",
 			flavor:      "aws",
@@ -93,7 +102,7 @@ var tests = []struct {
 			err         error
 		}{
 			headersURLs: []string{"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg/linux-intel-iotg-headers-5.15.0-1004_5.15.0-1004.6_all.deb"},
-			urls:        []string{"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-intel-iotg-headers-5.15.0-1004_5.15.0-1004.6_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg/linux-intel-iotg-headers-5.15.0-1004_5.15.0-1004.6_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg-5.15/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg-5.15/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg-5.15/linux-intel-iotg-headers-5.15.0-1004_5.15.0-1004.6_all.deb"},
+			urls:        []string{"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.15.0-1004_5.15.0-1004.6_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-intel-iotg-headers-5.15.0-1004_5.15.0-1004.6_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-intel-iotg-5.15-headers-5.15.0-1004_5.15.0-1004.6_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg/linux-headers-5.15.0-1004_5.15.0-1004.6_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg/linux-intel-iotg-headers-5.15.0-1004_5.15.0-1004.6_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg/linux-intel-iotg-5.15-headers-5.15.0-1004_5.15.0-1004.6_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg-5.15/linux-headers-5.15.0-1004_5.15.0-1004.6_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg-5.15/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg-5.15/linux-intel-iotg-headers-5.15.0-1004_5.15.0-1004.6_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg-5.15/linux-intel-iotg-5.15-headers-5.15.0-1004_5.15.0-1004.6_all.deb"},
 			gccVersion:  "10",
 			firstExtra:  "1004",
 			flavor:      "intel-iotg",
@@ -120,11 +129,11 @@ var tests = []struct {
 			err         error
 		}{
 			headersURLs: []string{},
-			urls:        []string{"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.15.0-24-lowlatency-hwe-5.15_5.15.0-24.24~20.04.3_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.15.0-24-lowlatency-hwe_5.15.0-24.24~20.04.3_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-lowlatency-hwe-headers-5.15.0-24_5.15.0-24.24~20.04.3_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.15.0-24-lowlatency-hwe-5.15_5.15.0-24.24~20.04.3_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe/linux-headers-5.15.0-24-lowlatency-hwe-5.15_5.15.0-24.24~20.04.3_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe/linux-headers-5.15.0-24-lowlatency-hwe_5.15.0-24.24~20.04.3_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe/linux-lowlatency-hwe-headers-5.15.0-24_5.15.0-24.24~20.04.3_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe/linux-headers-5.15.0-24-lowlatency-hwe-5.15_5.15.0-24.24~20.04.3_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe-5.15/linux-headers-5.15.0-24-lowlatency-hwe-5.15_5.15.0-24.24~20.04.3_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe-5.15/linux-headers-5.15.0-24-lowlatency-hwe_5.15.0-24.24~20.04.3_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe-5.15/linux-lowlatency-hwe-headers-5.15.0-24_5.15.0-24.24~20.04.3_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe-5.15/linux-headers-5.15.0-24-lowlatency-hwe-5.15_5.15.0-24.24~20.04.3_amd64.deb"},
+			urls:        []string{"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.15.0-24_5.15.0-24.24~20.04.3_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.15.0-24-lowlatency-hwe_5.15.0-24.24~20.04.3_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-lowlatency-hwe-headers-5.15.0-24_5.15.0-24.24~20.04.3_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-lowlatency-hwe-5.15-headers-5.15.0-24_5.15.0-24.24~20.04.3_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.15.0-24-lowlatency-hwe-5.15_5.15.0-24.24~20.04.3_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe/linux-headers-5.15.0-24_5.15.0-24.24~20.04.3_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe/linux-headers-5.15.0-24-lowlatency-hwe_5.15.0-24.24~20.04.3_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe/linux-lowlatency-hwe-headers-5.15.0-24_5.15.0-24.24~20.04.3_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe/linux-lowlatency-hwe-5.15-headers-5.15.0-24_5.15.0-24.24~20.04.3_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe/linux-headers-5.15.0-24-lowlatency-hwe-5.15_5.15.0-24.24~20.04.3_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe-5.15/linux-headers-5.15.0-24_5.15.0-24.24~20.04.3_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe-5.15/linux-headers-5.15.0-24-lowlatency-hwe_5.15.0-24.24~20.04.3_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe-5.15/linux-lowlatency-hwe-headers-5.15.0-24_5.15.0-24.24~20.04.3_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe-5.15/linux-lowlatency-hwe-5.15-headers-5.15.0-24_5.15.0-24.24~20.04.3_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe-5.15/linux-headers-5.15.0-24-lowlatency-hwe-5.15_5.15.0-24.24~20.04.3_amd64.deb"},
 			gccVersion:  "10",
 			firstExtra:  "24",
 			flavor:      "lowlatency-hwe",
-			err:         fmt.Errorf("kernel headers not found"),
+			err:         fmt.Errorf("kernel headers not found in: https://mirrors.edge.kernel.org/ubuntu/pool/main/l, http://security.ubuntu.com/ubuntu/pool/main/l, http://old-releases.ubuntu.com/ubuntu/pool/main/l, https://api.launchpad.net/1.0/ubuntu/+archive/primary"),
 		},
 	},
 	{
@@ -147,7 +156,7 @@ var tests = []struct {
 			err         error
 		}{
 			headersURLs: []string{},
-			urls:        []string{"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-3.13.0-100_3.13.0-100.147_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-3.13.0-100-generic_3.13.0-100.147_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-generic-headers-3.13.0-100_3.13.0-100.147_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-3.13.0-100_3.13.0-100.147_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-headers-3.13.0-100_3.13.0-100.147_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-headers-3.13.0-100-generic_3.13.0-100.147_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-generic-headers-3.13.0-100_3.13.0-100.147_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-headers-3.13.0-100_3.13.0-100.147_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-3.13/linux-headers-3.13.0-100_3.13.0-100.147_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-3.13/linux-headers-3.13.0-100-generic_3.13.0-100.147_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-3.13/linux-generic-headers-3.13.0-100_3.13.0-100.147_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-3.13/linux-headers-3.13.0-100_3.13.0-100.147_amd64.deb"},
+			urls:        []string{"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-3.13.0-100_3.13.0-100.147_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-3.13.0-100-generic_3.13.0-100.147_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-generic-headers-3.13.0-100_3.13.0-100.147_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-generic-3.13-headers-3.13.0-100_3.13.0-100.147_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-3.13.0-100_3.13.0-100.147_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-headers-3.13.0-100_3.13.0-100.147_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-headers-3.13.0-100-generic_3.13.0-100.147_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-generic-headers-3.13.0-100_3.13.0-100.147_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-generic-3.13-headers-3.13.0-100_3.13.0-100.147_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-headers-3.13.0-100_3.13.0-100.147_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-3.13/linux-headers-3.13.0-100_3.13.0-100.147_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-3.13/linux-headers-3.13.0-100-generic_3.13.0-100.147_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-3.13/linux-generic-headers-3.13.0-100_3.13.0-100.147_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-3.13/linux-generic-3.13-headers-3.13.0-100_3.13.0-100.147_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-3.13/linux-headers-3.13.0-100_3.13.0-100.147_amd64.deb"},
 			gccVersion:  "4.8",
 			firstExtra:  "100",
 			flavor:      "generic",
@@ -174,7 +183,7 @@ var tests = []struct {
 			err         error
 		}{
 			headersURLs: []string{},
-			urls:        []string{"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-3.16.0-38-lts-utopic_3.16.0-38.52~14.04.1_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-3.16.0-38-lts-utopic_3.16.0-38.52~14.04.1_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-lts-utopic-headers-3.16.0-38_3.16.0-38.52~14.04.1_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic/linux-headers-3.16.0-38-lts-utopic_3.16.0-38.52~14.04.1_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic/linux-headers-3.16.0-38-lts-utopic_3.16.0-38.52~14.04.1_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic/linux-lts-utopic-headers-3.16.0-38_3.16.0-38.52~14.04.1_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic-3.16/linux-headers-3.16.0-38-lts-utopic_3.16.0-38.52~14.04.1_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic-3.16/linux-headers-3.16.0-38-lts-utopic_3.16.0-38.52~14.04.1_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic-3.16/linux-lts-utopic-headers-3.16.0-38_3.16.0-38.52~14.04.1_all.deb"},
+			urls:        []string{"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-3.16.0-38_3.16.0-38.52~14.04.1_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-3.16.0-38-lts-utopic_3.16.0-38.52~14.04.1_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-lts-utopic-headers-3.16.0-38_3.16.0-38.52~14.04.1_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-lts-utopic-3.16-headers-3.16.0-38_3.16.0-38.52~14.04.1_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic/linux-headers-3.16.0-38_3.16.0-38.52~14.04.1_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic/linux-headers-3.16.0-38-lts-utopic_3.16.0-38.52~14.04.1_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic/linux-lts-utopic-headers-3.16.0-38_3.16.0-38.52~14.04.1_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic/linux-lts-utopic-3.16-headers-3.16.0-38_3.16.0-38.52~14.04.1_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic-3.16/linux-headers-3.16.0-38_3.16.0-38.52~14.04.1_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic-3.16/linux-headers-3.16.0-38-lts-utopic_3.16.0-38.52~14.04.1_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic-3.16/linux-lts-utopic-headers-3.16.0-38_3.16.0-38.52~14.04.1_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic-3.16/linux-lts-utopic-3.16-headers-3.16.0-38_3.16.0-38.52~14.04.1_all.deb"},
 			gccVersion:  "6",
 			firstExtra:  "38",
 			flavor:      "lts-utopic",
@@ -201,7 +210,7 @@ var tests = []struct {
 			err         error
 		}{
 			headersURLs: []string{"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm/linux-headers-5.18.0-1001-kvm_5.18.0-1001.1_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm/linux-kvm-headers-5.18.0-1001_5.18.0-1001.1_all.deb"},
-			urls:        []string{"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.18.0-1001-kvm_5.18.0-1001.1_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.18.0-1001-kvm_5.18.0-1001.1_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-kvm-headers-5.18.0-1001_5.18.0-1001.1_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm/linux-headers-5.18.0-1001-kvm_5.18.0-1001.1_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm/linux-headers-5.18.0-1001-kvm_5.18.0-1001.1_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm/linux-kvm-headers-5.18.0-1001_5.18.0-1001.1_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm-5.18/linux-headers-5.18.0-1001-kvm_5.18.0-1001.1_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm-5.18/linux-headers-5.18.0-1001-kvm_5.18.0-1001.1_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm-5.18/linux-kvm-headers-5.18.0-1001_5.18.0-1001.1_all.deb"},
+			urls:        []string{"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.18.0-1001_5.18.0-1001.1_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.18.0-1001-kvm_5.18.0-1001.1_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-kvm-headers-5.18.0-1001_5.18.0-1001.1_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-kvm-5.18-headers-5.18.0-1001_5.18.0-1001.1_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm/linux-headers-5.18.0-1001_5.18.0-1001.1_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm/linux-headers-5.18.0-1001-kvm_5.18.0-1001.1_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm/linux-kvm-headers-5.18.0-1001_5.18.0-1001.1_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm/linux-kvm-5.18-headers-5.18.0-1001_5.18.0-1001.1_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm-5.18/linux-headers-5.18.0-1001_5.18.0-1001.1_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm-5.18/linux-headers-5.18.0-1001-kvm_5.18.0-1001.1_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm-5.18/linux-kvm-headers-5.18.0-1001_5.18.0-1001.1_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm-5.18/linux-kvm-5.18-headers-5.18.0-1001_5.18.0-1001.1_all.deb"},
 			gccVersion:  "11",
 			firstExtra:  "1001",
 			flavor:      "kvm",
@@ -224,7 +233,7 @@ func TestUbuntuHeadersURLFromRelease(t *testing.T) {
 		}
 
 		// call function
-		gotURLs, err := ubuntuHeadersURLFromRelease(input.config, input.kv)
+		gotURLs, err := ubuntuHeadersURLFromRelease(context.Background(), input.config, input.kv)
 		// compare errors
 		// there are no official errors, so comparing fmt.Errorf() doesn't really work
 		// compare error message text instead
@@ -333,3 +342,369 @@ func TestParseUbuntuExtraVersion(t *testing.T) {
 		}
 	}
 }
+
+func TestFetchUbuntuKernelURLFlavors(t *testing.T) {
+	baseURL := "https://mirrors.edge.kernel.org/ubuntu/pool/main/l"
+	flavorTests := map[string]struct {
+		kernelrelease string
+		kernelversion string
+		expected      []string
+	}{
+		"aws": {
+			kernelrelease: "5.15.0-1052-aws",
+			kernelversion: "57",
+			expected: []string{
+				"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-aws/linux-headers-5.15.0-1052-aws_5.15.0-1052.57_amd64.deb",
+				"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-aws/linux-aws-headers-5.15.0-1052_5.15.0-1052.57_all.deb",
+			},
+		},
+		"azure backport": {
+			kernelrelease: "5.15.0-1052-azure",
+			kernelversion: "60~20.04.1",
+			expected: []string{
+				"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-azure-5.15/linux-headers-5.15.0-1052-azure_5.15.0-1052.60~20.04.1_amd64.deb",
+				"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-azure-5.15/linux-azure-5.15-headers-5.15.0-1052_5.15.0-1052.60~20.04.1_all.deb",
+			},
+		},
+		"gcp": {
+			kernelrelease: "5.15.0-1048-gcp",
+			kernelversion: "56",
+			expected: []string{
+				"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-gcp/linux-headers-5.15.0-1048-gcp_5.15.0-1048.56_amd64.deb",
+				"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-gcp/linux-gcp-headers-5.15.0-1048_5.15.0-1048.56_all.deb",
+			},
+		},
+		"oracle": {
+			kernelrelease: "5.15.0-1047-oracle",
+			kernelversion: "53",
+			expected: []string{
+				"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-oracle/linux-headers-5.15.0-1047-oracle_5.15.0-1047.53_amd64.deb",
+				"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-oracle/linux-oracle-headers-5.15.0-1047_5.15.0-1047.53_all.deb",
+			},
+		},
+		"lowlatency": {
+			kernelrelease: "5.15.0-91-lowlatency",
+			kernelversion: "101",
+			expected: []string{
+				"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency/linux-headers-5.15.0-91-lowlatency_5.15.0-91.101_amd64.deb",
+				"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency/linux-lowlatency-headers-5.15.0-91_5.15.0-91.101_all.deb",
+			},
+		},
+		"lowlatency from the generic sources": {
+			kernelrelease: "4.15.0-213-lowlatency",
+			kernelversion: "224",
+			expected: []string{
+				"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-4.15.0-213-lowlatency_4.15.0-213.224_amd64.deb",
+				"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-4.15.0-213_4.15.0-213.224_all.deb",
+			},
+		},
+		"kvm": {
+			kernelrelease: "5.15.0-1049-kvm",
+			kernelversion: "54",
+			expected: []string{
+				"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm/linux-headers-5.15.0-1049-kvm_5.15.0-1049.54_amd64.deb",
+				"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm/linux-kvm-headers-5.15.0-1049_5.15.0-1049.54_all.deb",
+			},
+		},
+	}
+
+	for name, test := range flavorTests {
+		kr := kernelrelease.FromString(test.kernelrelease)
+		kr.Architecture = "amd64"
+
+		gotURLs, err := fetchUbuntuKernelURL(baseURL, kr, test.kernelversion)
+		if err != nil {
+			t.Fatalf("Unexpected error encountered with Test Input: '%s' | Error: '%s'", name, err)
+		}
+
+		for _, want := range test.expected {
+			found := false
+			for _, got := range gotURLs {
+				if got == want {
+					found = true
+					break
+				}
+			}
+			if !found {
+				t.Errorf("Missing URL! Test Input: '%s' | Got: '%v' / Want: '%s'", name, gotURLs, want)
+			}
+		}
+	}
+}
+
+func TestUbuntuHeadersURLFromReleaseArchitectures(t *testing.T) {
+	index, err := ioutil.ReadFile(filepath.Join("testdata", "ubuntu-ports-index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the packages listed by the index of the pool resolve
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dir, name := path.Split(r.URL.Path)
+		switch {
+		case dir == "/linux/" && len(name) == 0:
+			w.Write(index)
+		case dir == "/linux/" && strings.Contains(string(index), fmt.Sprintf(`href="%s"`, name)):
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	defaultBaseURLs := ubuntuPortsBaseURLs
+	ubuntuPortsBaseURLs = []string{server.URL}
+	defer func() { ubuntuPortsBaseURLs = defaultBaseURLs }()
+
+	tests := map[string]struct {
+		arch     kernelrelease.Architecture
+		expected []string
+	}{
+		"arm64": {
+			arch: "arm64",
+			expected: []string{
+				server.URL + "/linux/linux-headers-5.15.0-25_5.15.0-25.25_all.deb",
+				server.URL + "/linux/linux-headers-5.15.0-25-generic_5.15.0-25.25_arm64.deb",
+			},
+		},
+		"ppc64le": {
+			arch: "ppc64le",
+			expected: []string{
+				server.URL + "/linux/linux-headers-5.15.0-25_5.15.0-25.25_all.deb",
+				server.URL + "/linux/linux-headers-5.15.0-25-generic_5.15.0-25.25_ppc64el.deb",
+			},
+		},
+		"s390x": {
+			arch: "s390x",
+			expected: []string{
+				server.URL + "/linux/linux-headers-5.15.0-25_5.15.0-25.25_all.deb",
+				server.URL + "/linux/linux-headers-5.15.0-25-generic_5.15.0-25.25_s390x.deb",
+			},
+		},
+		"riscv64": {
+			arch: "riscv64",
+			expected: []string{
+				server.URL + "/linux/linux-headers-5.15.0-25_5.15.0-25.25_all.deb",
+				server.URL + "/linux/linux-headers-5.15.0-25-generic_5.15.0-25.25_riscv64.deb",
+			},
+		},
+	}
+
+	for name, test := range tests {
+		kr := kernelrelease.FromString("5.15.0-25-generic")
+		kr.Architecture = test.arch
+
+		gotURLs, err := ubuntuHeadersURLFromRelease(context.Background(), kr, "25")
+		if err != nil {
+			t.Fatalf("Unexpected error encountered with Test Input: '%s' | Error: '%s'", name, err)
+		}
+		if len(gotURLs) != len(test.expected) {
+			t.Fatalf("Slice sizes don't match! Test Input: '%s' | Got: '%v' / Want: '%v'", name, gotURLs, test.expected)
+		}
+		for i, v := range gotURLs {
+			if v != test.expected[i] {
+				t.Fatalf("Slice values don't match! Test Input: '%s' | Got: '%v' / Want: '%v'", name, gotURLs, test.expected)
+			}
+		}
+	}
+}
+
+// newTestUbuntuMirror serves the pools of the source packages, listing their packages, which resolve.
+func newTestUbuntuMirror(t *testing.T, pools map[string][]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dir, name := path.Split(r.URL.Path)
+		packages, ok := pools[strings.Trim(dir, "/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if len(name) == 0 {
+			fmt.Fprint(w, "<html><body><pre>\n")
+			for _, p := range packages {
+				fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", p, p)
+			}
+			fmt.Fprint(w, "</pre></body></html>")
+			return
+		}
+		for _, p := range packages {
+			if p == name {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(server.Close)
+	defaultBaseURLs := ubuntuBaseURLs
+	ubuntuBaseURLs = []string{server.URL}
+	t.Cleanup(func() { ubuntuBaseURLs = defaultBaseURLs })
+	withHTTPClient(t, newRetryClient(context.Background(), time.Second, 1))
+	return server
+}
+
+func TestUbuntuHeadersURLFromReleaseHWE(t *testing.T) {
+	server := newTestUbuntuMirror(t, map[string][]string{
+		"linux": {
+			"linux-headers-5.15.0-91_5.15.0-91.101_all.deb",
+			"linux-headers-5.15.0-91-generic_5.15.0-91.101_amd64.deb",
+		},
+		"linux-hwe-5.15": {
+			"linux-headers-5.15.0-91-generic_5.15.0-91.101~20.04.1_amd64.deb",
+			"linux-headers-5.15.0-91-lowlatency_5.15.0-91.101~20.04.1_amd64.deb",
+			"linux-hwe-5.15-headers-5.15.0-91_5.15.0-91.101~20.04.1_all.deb",
+			"linux-headers-5.15.0-89-generic_5.15.0-89.99~20.04.1_amd64.deb",
+			"linux-hwe-5.15-headers-5.15.0-89_5.15.0-89.99~20.04.1_all.deb",
+		},
+		"linux-hwe-5.4": {
+			"linux-headers-5.4.0-150-generic_5.4.0-150.167~18.04.1_amd64.deb",
+			"linux-hwe-5.4-headers-5.4.0-150_5.4.0-150.167~18.04.1_all.deb",
+		},
+		"linux-hwe": {
+			"linux-headers-4.18.0-25-generic_4.18.0-25.26~18.04.1_amd64.deb",
+			"linux-hwe-headers-4.18.0-25_4.18.0-25.26~18.04.1_all.deb",
+		},
+		"linux-hwe-edge": {
+			"linux-headers-5.3.0-19-generic_5.3.0-19.20~18.04.2_amd64.deb",
+			"linux-hwe-edge-headers-5.3.0-19_5.3.0-19.20~18.04.2_all.deb",
+		},
+	})
+
+	tests := map[string]struct {
+		kernelrelease string
+		kernelversion string
+		expected      []string
+	}{
+		"20.04 hwe": {
+			kernelrelease: "5.15.0-91-generic",
+			kernelversion: "101~20.04.1",
+			expected: []string{
+				server.URL + "/linux-hwe-5.15/linux-headers-5.15.0-91-generic_5.15.0-91.101~20.04.1_amd64.deb",
+				server.URL + "/linux-hwe-5.15/linux-hwe-5.15-headers-5.15.0-91_5.15.0-91.101~20.04.1_all.deb",
+			},
+		},
+		"20.04 hwe lowlatency": {
+			kernelrelease: "5.15.0-91-lowlatency",
+			kernelversion: "101~20.04.1",
+			expected: []string{
+				server.URL + "/linux-hwe-5.15/linux-headers-5.15.0-91-lowlatency_5.15.0-91.101~20.04.1_amd64.deb",
+				server.URL + "/linux-hwe-5.15/linux-hwe-5.15-headers-5.15.0-91_5.15.0-91.101~20.04.1_all.deb",
+			},
+		},
+		"22.04 ga": {
+			kernelrelease: "5.15.0-91-generic",
+			kernelversion: "101",
+			expected: []string{
+				server.URL + "/linux/linux-headers-5.15.0-91_5.15.0-91.101_all.deb",
+				server.URL + "/linux/linux-headers-5.15.0-91-generic_5.15.0-91.101_amd64.deb",
+			},
+		},
+		"18.04 hwe": {
+			kernelrelease: "5.4.0-150-generic",
+			kernelversion: "167~18.04.1",
+			expected: []string{
+				server.URL + "/linux-hwe-5.4/linux-headers-5.4.0-150-generic_5.4.0-150.167~18.04.1_amd64.deb",
+				server.URL + "/linux-hwe-5.4/linux-hwe-5.4-headers-5.4.0-150_5.4.0-150.167~18.04.1_all.deb",
+			},
+		},
+		"18.04 hwe without the series": {
+			kernelrelease: "4.18.0-25-generic",
+			kernelversion: "26",
+			expected: []string{
+				server.URL + "/linux-hwe/linux-headers-4.18.0-25-generic_4.18.0-25.26~18.04.1_amd64.deb",
+				server.URL + "/linux-hwe/linux-hwe-headers-4.18.0-25_4.18.0-25.26~18.04.1_all.deb",
+			},
+		},
+		"18.04 hwe edge": {
+			kernelrelease: "5.3.0-19-generic",
+			kernelversion: "20~18.04.2",
+			expected: []string{
+				server.URL + "/linux-hwe-edge/linux-headers-5.3.0-19-generic_5.3.0-19.20~18.04.2_amd64.deb",
+				server.URL + "/linux-hwe-edge/linux-hwe-edge-headers-5.3.0-19_5.3.0-19.20~18.04.2_all.deb",
+			},
+		},
+	}
+
+	for name, test := range tests {
+		kr := kernelrelease.FromString(test.kernelrelease)
+		kr.Architecture = "amd64"
+
+		gotURLs, err := ubuntuHeadersURLFromRelease(context.Background(), kr, test.kernelversion)
+		if err != nil {
+			t.Errorf("Unexpected error encountered with Test Input: '%s' | Error: '%s'", name, err)
+			continue
+		}
+		if len(gotURLs) != len(test.expected) {
+			t.Fatalf("Slice sizes don't match! Test Input: '%s' | Got: '%v' / Want: '%v'", name, gotURLs, test.expected)
+		}
+		for _, want := range test.expected {
+			found := false
+			for _, got := range gotURLs {
+				found = found || got == want
+			}
+			if !found {
+				t.Errorf("Missing URL! Test Input: '%s' | Got: '%v' / Want: '%s'", name, gotURLs, want)
+			}
+		}
+	}
+}
+
+func TestUbuntuHWESources(t *testing.T) {
+	tests := map[string]struct {
+		kernelrelease string
+		kernelversion string
+		expected      []string
+	}{
+		"hwe": {
+			kernelrelease: "5.15.0-91-generic",
+			kernelversion: "101~20.04.1",
+			expected:      []string{"linux-hwe-5.15", "linux-hwe", "linux-hwe-5.15-edge", "linux-hwe-edge"},
+		},
+		"ga": {
+			kernelrelease: "5.15.0-91-generic",
+			kernelversion: "101~22.04.1",
+		},
+		"lowlatency": {
+			kernelrelease: "6.8.0-45-lowlatency",
+			kernelversion: "45.45~22.04.1",
+			expected:      []string{"linux-hwe-6.8", "linux-hwe", "linux-hwe-6.8-edge", "linux-hwe-edge", "linux-lowlatency-hwe-6.8"},
+		},
+	}
+	for name, test := range tests {
+		got := ubuntuHWESources(kernelrelease.FromString(test.kernelrelease), test.kernelversion)
+		if len(got) != len(test.expected) {
+			t.Fatalf("Slice sizes don't match! Test Input: '%s' | Got: '%v' / Want: '%v'", name, got, test.expected)
+		}
+		for i, v := range got {
+			if v != test.expected[i] {
+				t.Errorf("Slice values don't match! Test Input: '%s' | Got: '%v' / Want: '%v'", name, got, test.expected)
+			}
+		}
+	}
+}
+
+func TestUbuntuSuites(t *testing.T) {
+	tests := map[string]struct {
+		kernelrelease string
+		url           string
+		expected      []string
+	}{
+		"ga": {
+			kernelrelease: "5.15.0-91-generic",
+			url:           "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.15.0-91-generic_5.15.0-91.101_amd64.deb",
+			expected:      []string{"jammy", "jammy-updates", "jammy-security"},
+		},
+		"hwe": {
+			kernelrelease: "4.18.0-24-generic",
+			url:           "http://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-hwe/linux-headers-4.18.0-24-generic_4.18.0-24.25~18.04.1_amd64.deb",
+			expected:      []string{"bionic", "bionic-updates", "bionic-security"},
+		},
+		"unknown": {
+			kernelrelease: "3.2.0-23-generic",
+			url:           "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-3.2.0-23-generic_3.2.0-23.36_amd64.deb",
+		},
+	}
+	for name, test := range tests {
+		got := ubuntuSuites(kernelrelease.FromString(test.kernelrelease))(test.url)
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("Test Input: '%s' | Got: '%v' / Want: '%v'", name, got, test.expected)
+		}
+	}
+}
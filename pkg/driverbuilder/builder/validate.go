@@ -0,0 +1,61 @@
+package builder
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+// Validator is implemented by the builders checking the build against the specifics of their target,
+// e.g. the shape of the kernel release, before anything is downloaded.
+type Validator interface {
+	Validate(c Config, kr kernelrelease.KernelRelease) error
+}
+
+// Validate checks that the builder can do the build, against its metadata first and then, when it is a Validator, against the builder itself.
+func Validate(b Builder, c Config, kr kernelrelease.KernelRelease) error {
+	if len(kr.Fullversion) == 0 {
+		return fmt.Errorf("invalid kernel release %s, it must be the output of 'uname -r', e.g. 5.10.0-21-amd64", c.KernelRelease)
+	}
+	m := MetadataOf(b)
+	if !containsString(m.Architectures, c.Architecture) {
+		return fmt.Errorf("target %s does not support the %s architecture, only %s", c.TargetType, c.Architecture, strings.Join(m.Architectures, ", "))
+	}
+	if m.RequiresKernelVersion && len(c.KernelVersion) == 0 {
+		return fmt.Errorf("target %s requires the kernel version, the numeric value after the hash of 'uname -v'", c.TargetType)
+	}
+	if m.RequiresKernelConfigData && !c.HasKernelConfigData() {
+		return fmt.Errorf("target %s requires the kernel config data, e.g. the base64 encoded content of /proc/config.gz once uncompressed", c.TargetType)
+	}
+	if !m.Module && len(c.ModuleFilePath) > 0 {
+		return fmt.Errorf("target %s cannot build the kernel module, build the eBPF probe only", c.TargetType)
+	}
+	if !m.Probe && len(c.ProbeFilePath) > 0 {
+		return fmt.Errorf("target %s cannot build the eBPF probe, build the kernel module only", c.TargetType)
+	}
+	if !m.BTF && len(c.BTFFilePath) > 0 {
+		return fmt.Errorf("target %s cannot generate the BTF of the kernel, its debug package is unknown", c.TargetType)
+	}
+	if !m.GCC && len(c.GCCVersion) > 0 {
+		return fmt.Errorf("target %s cannot build with gcc %s, it builds with the toolchain of the kernel", c.TargetType, c.GCCVersion)
+	}
+	if len(c.ModernProbeFilePath) > 0 {
+		if err := validateModernProbe(c, kr); err != nil {
+			return err
+		}
+	}
+	if v, ok := b.(Validator); ok {
+		return v.Validate(c, kr)
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
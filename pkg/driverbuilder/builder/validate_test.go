@@ -0,0 +1,62 @@
+package builder
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		build *Build
+		err   string
+	}{
+		{&Build{TargetType: TargetTypeDebian, Architecture: "amd64", KernelRelease: "5.10.0-21-amd64"}, ""},
+		{&Build{TargetType: TargetTypeDebian, Architecture: "arm64", KernelRelease: "6.1.0-17-cloud-arm64"}, ""},
+		{&Build{TargetType: TargetTypeDebian, Architecture: "amd64", KernelRelease: "5.10.0-0.deb10.16-amd64"}, ""},
+		{&Build{TargetType: TargetTypeDebian, Architecture: "amd64", KernelRelease: "5.10.0-21"}, "invalid debian kernel release"},
+		{&Build{TargetType: TargetTypeDebian, Architecture: "amd64", KernelRelease: "5.10.0-21-arm64"}, "not for the amd64 architecture"},
+		{&Build{TargetType: TargetTypeDebian, Architecture: "ppc64le", KernelRelease: "5.10.0-21-powerpc64le"}, ""},
+		{&Build{TargetType: TargetTypeDebian, Architecture: "ppc64le", KernelRelease: "5.10.0-21-amd64"}, "it must end with -powerpc64le"},
+		{&Build{TargetType: TargetTypeDebian, Architecture: "s390x", KernelRelease: "5.10.0-21-s390x"}, ""},
+		{&Build{TargetType: TargetTypeDebian, Architecture: "riscv64", KernelRelease: "6.12.6-1-riscv64"}, ""},
+		{&Build{TargetType: TargetTypeDebian, Architecture: "amd64", KernelRelease: "5.10.0-21", KernelUrls: []string{"https://example.com/headers.deb"}}, ""},
+		{&Build{TargetType: TargetTypeUbuntu, Architecture: "amd64", KernelRelease: "5.15.0-25-generic", KernelVersion: "25"}, ""},
+		{&Build{TargetType: TargetTypeUbuntu, Architecture: "amd64", KernelRelease: "5.15.0-1019-intel-iotg-5.15", KernelVersion: "19"}, ""},
+		{&Build{TargetType: TargetTypeUbuntu, Architecture: "ppc64le", KernelRelease: "5.15.0-25-generic", KernelVersion: "25"}, ""},
+		{&Build{TargetType: TargetTypeUbuntu, Architecture: "s390x", KernelRelease: "5.15.0-25-generic", KernelVersion: "25"}, ""},
+		{&Build{TargetType: TargetTypeCentos, Architecture: "s390x", KernelRelease: "4.18.0-305.el8.s390x"}, "does not support the s390x architecture"},
+		{&Build{TargetType: TargetTypeFedora, Architecture: "ppc64le", KernelRelease: "5.14.10-300.fc35.ppc64le"}, "does not support the ppc64le architecture"},
+		{&Build{TargetType: TargetTypeUbuntu, Architecture: "amd64", KernelRelease: "5.15.0-25-generic"}, "requires the kernel version"},
+		{&Build{TargetType: TargetTypeUbuntu, Architecture: "amd64", KernelRelease: "5.15.0-generic", KernelVersion: "25"}, "invalid ubuntu kernel release"},
+		{&Build{TargetType: TargetTypeVanilla, Architecture: "amd64", KernelRelease: "5.10.0"}, "requires the kernel config data"},
+		{&Build{TargetType: TargetTypeVanilla, Architecture: "amd64", KernelRelease: "5.10.0", KernelConfigData: "Q09ORklHX0JQRj15"}, ""},
+		{&Build{TargetType: TargetTypeGentoo, Architecture: "amd64", KernelRelease: "6.1.67-gentoo", KernelVersion: "73", KernelConfigData: "Q09ORklHX0JQRj15"}, ""},
+		{&Build{TargetType: TargetTypeGentoo, Architecture: "amd64", KernelRelease: "6.1.67-gentoo", KernelVersion: "1", KernelConfigData: "Q09ORklHX0JQRj15"}, "requires the genpatches revision"},
+		{&Build{TargetType: TargetTypeGentoo, Architecture: "amd64", KernelRelease: "6.1.67-gentoo", KernelConfigData: "Q09ORklHX0JQRj15"}, "requires the kernel version"},
+		{&Build{TargetType: TargetTypeRaspios, Architecture: "amd64", KernelRelease: "5.10.103-v8+"}, "does not support the amd64 architecture"},
+		{&Build{TargetType: TargetTypeCentos, Architecture: "amd64", KernelRelease: "not-a-release"}, "invalid kernel release"},
+		{&Build{TargetType: TargetTypeDebian, Architecture: "amd64", KernelRelease: "5.10.0-21-amd64", ModernProbeFilePath: "/tmp/bpf_probe.skel.h"}, ""},
+		{&Build{TargetType: TargetTypeUbuntu, Architecture: "arm64", KernelRelease: "5.8.0-25-generic", KernelVersion: "25", ModernProbeFilePath: "/tmp/bpf_probe.skel.h"}, ""},
+		{&Build{TargetType: TargetTypeUbuntu, Architecture: "amd64", KernelRelease: "5.4.0-1086-azure", KernelVersion: "96", ModernProbeFilePath: "/tmp/bpf_probe.skel.h"}, "requires a kernel >= 5.8.0 with BTF"},
+		{&Build{TargetType: TargetTypeDebian, Architecture: "s390x", KernelRelease: "5.10.0-21-s390x", ModernProbeFilePath: "/tmp/bpf_probe.skel.h"}, "cannot be built for the s390x architecture"},
+		{&Build{TargetType: TargetTypeVanilla, Architecture: "amd64", KernelRelease: "5.10.0", KernelConfigData: "Q09ORklHX0JQRj15CkNPTkZJR19ERUJVR19JTkZPX0JURj15Cg==", ModernProbeFilePath: "/tmp/bpf_probe.skel.h"}, ""},
+		{&Build{TargetType: TargetTypeVanilla, Architecture: "amd64", KernelRelease: "5.10.0", KernelConfigData: "Q09ORklHX0JQRj15", ModernProbeFilePath: "/tmp/bpf_probe.skel.h"}, "CONFIG_DEBUG_INFO_BTF is not enabled"},
+		{&Build{TargetType: TargetTypeCentos, Architecture: "amd64", KernelRelease: "3.10.0-1160.el7.x86_64", BTFFilePath: "/tmp/vmlinux.btf"}, ""},
+		{&Build{TargetType: TargetTypeFedora, Architecture: "amd64", KernelRelease: "5.14.10-300.fc35.x86_64", BTFFilePath: "/tmp/vmlinux.btf"}, "cannot generate the BTF of the kernel"},
+		{&Build{TargetType: TargetTypeVanilla, Architecture: "amd64", KernelRelease: "5.10.0", KernelConfigData: "Q09ORklHX0JQRj15", GCCVersion: "6"}, ""},
+		{&Build{TargetType: TargetTypeCos, Architecture: "amd64", KernelRelease: "5.10.123+", KernelVersion: "16108.403.42", GCCVersion: "6"}, "cannot build with gcc 6"},
+	}
+	for _, test := range tests {
+		b, err := Factory(test.build.TargetType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = Validate(b, Config{Build: test.build}, test.build.KernelReleaseFromBuildConfig())
+		switch {
+		case len(test.err) == 0 && err != nil:
+			t.Errorf("Unexpected error encountered | Test Input: '%s %s' | Error: '%s'", test.build.TargetType, test.build.KernelRelease, err)
+		case len(test.err) > 0 && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("Test Input: '%s %s' | Got: '%v' / Want: '%s'", test.build.TargetType, test.build.KernelRelease, err, test.err)
+		}
+	}
+}
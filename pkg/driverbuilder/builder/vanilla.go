@@ -1,10 +1,12 @@
 package builder
 
 import (
-	"bytes"
+	"context"
 	_ "embed"
 	"fmt"
-	"text/template"
+	"path"
+	"regexp"
+	"strings"
 
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 )
@@ -16,6 +18,13 @@ var vanillaTemplate string
 type vanilla struct {
 }
 
+// Metadata implements MetadataProvider, the kernel is configured with the kernel config data.
+func (v vanilla) Metadata() Metadata {
+	m := DefaultMetadata()
+	m.RequiresKernelConfigData = true
+	return m
+}
+
 // TargetTypeVanilla identifies the Vanilla target.
 const TargetTypeVanilla Type = "vanilla"
 
@@ -24,54 +33,166 @@ func init() {
 }
 
 type vanillaTemplateData struct {
-	DriverBuildDir     string
-	ModuleDownloadURL  string
-	KernelDownloadURL  string
+	buildTemplateData
+	DriverBuildDir    string
+	ModuleDownloadURL string
+	KernelDownloadURL string
+	// KernelTarball is the file the kernel tarball is downloaded into, named after it so that tar detects its compression.
+	KernelTarball string
+	// KernelSignatureURL is the detached signature of the uncompressed tarball, verified with the KernelSigningKeys, when asked to.
+	KernelSignatureURL string
+	KernelSigningKeys  string
 	KernelLocalVersion string
-	ModuleDriverName   string
-	ModuleFullPath     string
-	BuildModule        bool
-	BuildProbe         bool
+	// KernelConfigFragments are the kernel config fragments merged into the kernel config, in order.
+	KernelConfigFragments []string
+	ModuleDriverName      string
+	ModuleFullPath        string
+	BuildModule           bool
+	BuildProbe            bool
+	KernelArch            string
+	LLVMVersion           string
+	GCCVersion            string
+	CrossCompile          string
+}
+
+// Validate implements Validator, the kernel config fragments are checked before anything is downloaded.
+func (v vanilla) Validate(c Config, kr kernelrelease.KernelRelease) error {
+	_, err := kernelConfigFragments(c.KernelConfigFragments)
+	return err
 }
 
 // Script compiles the script to build the kernel module and/or the eBPF probe.
-func (v vanilla) Script(c Config, kv kernelrelease.KernelRelease) (string, error) {
-	t := template.New(string(TargetTypeVanilla))
-	parsed, err := t.Parse(vanillaTemplate)
+func (v vanilla) Script(c Config, kv kernelrelease.KernelRelease, sources KernelSources) (string, error) {
+	parsed, err := parseTemplate(c, string(TargetTypeVanilla), vanillaTemplate, vanillaTemplateData{})
 	if err != nil {
 		return "", err
 	}
+	return executeTemplate(parsed, sources.TemplateData)
+}
 
+// ResolveKernelSources implements Builder, resolving the kernel packages of the build.
+func (v vanilla) ResolveKernelSources(ctx context.Context, c Config, kv kernelrelease.KernelRelease) (KernelSources, error) {
 	var urls []string
+	var err error
 	if c.KernelUrls == nil {
 		// Check (and filter) existing kernels before continuing
-		urls, err = getResolvingURLs([]string{fetchVanillaKernelURLFromKernelVersion(kv)})
+		urls, err = getResolvingURLs(ctx, fetchVanillaKernelURLsFromKernelVersion(kv))
 	} else {
-		urls, err = getResolvingURLs(c.KernelUrls)
+		urls, err = getResolvingURLs(ctx, c.KernelUrls)
 	}
 	if err != nil {
-		return "", err
+		return KernelSources{}, err
 	}
 
-	td := vanillaTemplateData{
-		DriverBuildDir:     DriverDirectory,
-		ModuleDownloadURL:  moduleDownloadURL(c),
-		KernelDownloadURL:  urls[0],
-		KernelLocalVersion: kv.FullExtraversion,
-		ModuleDriverName:   c.DriverName,
-		ModuleFullPath:     ModuleFullPath,
-		BuildModule:        len(c.Build.ModuleFilePath) > 0,
-		BuildProbe:         len(c.Build.ProbeFilePath) > 0,
+	fragments, err := kernelConfigFragments(c.KernelConfigFragments)
+	if err != nil {
+		return KernelSources{}, err
 	}
 
-	buf := bytes.NewBuffer(nil)
-	err = parsed.Execute(buf, td)
+	signatureURL := ""
+	if c.Build != nil && c.VerifyKernelSignature {
+		signatureURL, err = vanillaSignatureURL(urls[0])
+		if err != nil {
+			return KernelSources{}, err
+		}
+	}
+
+	crossCompilePrefix, err := crossCompile(c)
 	if err != nil {
-		return "", err
+		return KernelSources{}, err
+	}
+
+	td := vanillaTemplateData{
+		DriverBuildDir:        DriverDirectory,
+		ModuleDownloadURL:     moduleDownloadURL(c),
+		KernelDownloadURL:     urls[0],
+		KernelTarball:         path.Base(urls[0]),
+		KernelSignatureURL:    signatureURL,
+		KernelSigningKeys:     strings.Join(vanillaSigningKeys, " "),
+		KernelLocalVersion:    vanillaLocalVersion(kv),
+		KernelConfigFragments: fragments,
+		ModuleDriverName:      c.DriverName,
+		ModuleFullPath:        ModuleFullPath,
+		BuildModule:           len(c.Build.ModuleFilePath) > 0,
+		BuildProbe:            len(c.Build.ProbeFilePath) > 0,
+		KernelArch:            kv.Architecture.ToKernel(),
+		LLVMVersion:           llvmVersion(c, "7"),
+		GCCVersion:            gccVersion(c, vanillaGCCVersionFromKernelRelease(kv)),
+		CrossCompile:          crossCompilePrefix,
 	}
-	return buf.String(), nil
+	td.buildTemplateData = newBuildTemplateData(c)
+	return KernelSources{URLs: urls[:1], TemplateData: td}, nil
+}
+
+var (
+	// vanillaCIPPattern matches the extraversion of the Civil Infrastructure Platform kernels, e.g. cip110 or cip110-rt47.
+	vanillaCIPPattern = regexp.MustCompile(`^cip[0-9]+(-rt[0-9]+)?`)
+	// vanillaExtraversionPattern matches the part of the extraversion the sources set themselves, in their Makefile,
+	// the rest being the local version of the kernel.
+	vanillaExtraversionPattern = regexp.MustCompile(`^-(rc[0-9]+|cip[0-9]+(-rt[0-9]+)?)`)
+)
+
+// vanillaSigningKeys are the fingerprints of the keys the kernel.org tarballs are signed with, the only ones trusted:
+// the Linus Torvalds one for the mainline releases and candidates, the Greg Kroah-Hartman and Sasha Levin ones for the stable releases,
+// and the kernel.org checksum autosigner one.
+var vanillaSigningKeys = []string{
+	"ABAF11C65A2970B130ABE3C479BE3E4300411886",
+	"647F28654894E3BD457199BE38DBBDC86092693E",
+	"E27E5D8A3403A2EF66873BBCDEA66FF797772CDC",
+	"B8868C80BA62A1FFFAF5FDA9632D3A06589DA6B1",
 }
 
 func fetchVanillaKernelURLFromKernelVersion(kv kernelrelease.KernelRelease) string {
 	return fmt.Sprintf("https://cdn.kernel.org/pub/linux/kernel/v%d.x/linux-%s.tar.xz", kv.Version, kv.Fullversion)
 }
+
+// fetchVanillaKernelURLsFromKernelVersion returns the candidate tarballs of the kernel sources, in order:
+// the kernel.org ones of the releases and of the release candidates, then the git snapshots generated by git.kernel.org,
+// the only tarballs of the Civil Infrastructure Platform kernels and of the recent release candidates.
+func fetchVanillaKernelURLsFromKernelVersion(kv kernelrelease.KernelRelease) []string {
+	if cip := vanillaCIPPattern.FindString(kv.Extraversion); len(cip) > 0 {
+		return []string{
+			fmt.Sprintf("https://git.kernel.org/pub/scm/linux/kernel/git/cip/linux-cip.git/snapshot/linux-cip-%s-%s.tar.gz", kv.Fullversion, cip),
+		}
+	}
+	if kv.RC > 0 {
+		version := fmt.Sprintf("%d.%d-rc%d", kv.Version, kv.PatchLevel, kv.RC)
+		return []string{
+			fmt.Sprintf("https://cdn.kernel.org/pub/linux/kernel/v%d.x/testing/linux-%s.tar.xz", kv.Version, version),
+			fmt.Sprintf("https://git.kernel.org/torvalds/t/linux-%s.tar.gz", version),
+		}
+	}
+	// the first release of a series is named without its sublevel, e.g. linux-6.8.tar.xz
+	version := kv.Fullversion
+	if kv.Sublevel == 0 {
+		version = fmt.Sprintf("%d.%d", kv.Version, kv.PatchLevel)
+	}
+	return []string{
+		fmt.Sprintf("https://cdn.kernel.org/pub/linux/kernel/v%d.x/linux-%s.tar.xz", kv.Version, version),
+		fmt.Sprintf("https://git.kernel.org/pub/scm/linux/kernel/git/stable/linux.git/snapshot/linux-%s.tar.gz", version),
+	}
+}
+
+// vanillaSignatureURL returns the detached signature of the kernel.org tarball, the one of its uncompressed tar.
+// The git snapshots are generated on demand and never signed.
+func vanillaSignatureURL(u string) (string, error) {
+	if !strings.HasPrefix(u, "https://cdn.kernel.org/pub/linux/kernel/") || !strings.HasSuffix(u, ".tar.xz") {
+		return "", fmt.Errorf("unable to verify the signature of %s, only the kernel.org tarballs are signed, build without --verify-kernel-signature to use it", u)
+	}
+	return strings.TrimSuffix(u, ".xz") + ".sign", nil
+}
+
+// vanillaLocalVersion returns the local version the kernel is configured with, its extraversion but the part set by the sources,
+// e.g. -amd64 for 6.8.0-rc3-amd64.
+func vanillaLocalVersion(kv kernelrelease.KernelRelease) string {
+	return vanillaExtraversionPattern.ReplaceAllString(kv.FullExtraversion, "")
+}
+
+// vanillaGCCVersionFromKernelRelease returns the gcc building the kernels from the kernel.org sources,
+// the ones before 4.2 need a compiler-gcc header for it, only the oldest gcc of the builder image has one.
+func vanillaGCCVersionFromKernelRelease(kv kernelrelease.KernelRelease) string {
+	if kv.Version < 4 || (kv.Version == 4 && kv.PatchLevel < 2) {
+		return "4.8"
+	}
+	return "8"
+}
@@ -0,0 +1,140 @@
+package builder
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+func TestVanillaGCCVersionFromKernelRelease(t *testing.T) {
+	tests := map[string]string{
+		"3.10.108": "4.8",
+		"4.1.52":   "4.8",
+		"4.2.0":    "8",
+		"5.10.0":   "8",
+		"6.6.8":    "8",
+	}
+
+	for kernelRelease, expected := range tests {
+		kr := kernelrelease.FromString(kernelRelease)
+		if got := vanillaGCCVersionFromKernelRelease(kr); got != expected {
+			t.Errorf("Test Input: [ '%s' ] | Got: [ '%s' ] / Want: [ '%s' ]", kernelRelease, got, expected)
+		}
+	}
+}
+
+func TestFetchVanillaKernelURLsFromKernelVersion(t *testing.T) {
+	tests := map[string][]string{
+		"6.6.8": {
+			"https://cdn.kernel.org/pub/linux/kernel/v6.x/linux-6.6.8.tar.xz",
+			"https://git.kernel.org/pub/scm/linux/kernel/git/stable/linux.git/snapshot/linux-6.6.8.tar.gz",
+		},
+		"6.8.0": {
+			"https://cdn.kernel.org/pub/linux/kernel/v6.x/linux-6.8.tar.xz",
+			"https://git.kernel.org/pub/scm/linux/kernel/git/stable/linux.git/snapshot/linux-6.8.tar.gz",
+		},
+		"6.8-rc3": {
+			"https://cdn.kernel.org/pub/linux/kernel/v6.x/testing/linux-6.8-rc3.tar.xz",
+			"https://git.kernel.org/torvalds/t/linux-6.8-rc3.tar.gz",
+		},
+		"6.8.0-rc3-custom": {
+			"https://cdn.kernel.org/pub/linux/kernel/v6.x/testing/linux-6.8-rc3.tar.xz",
+			"https://git.kernel.org/torvalds/t/linux-6.8-rc3.tar.gz",
+		},
+		"4.19.306-cip110": {
+			"https://git.kernel.org/pub/scm/linux/kernel/git/cip/linux-cip.git/snapshot/linux-cip-4.19.306-cip110.tar.gz",
+		},
+		"5.10.209-cip44-rt18": {
+			"https://git.kernel.org/pub/scm/linux/kernel/git/cip/linux-cip.git/snapshot/linux-cip-5.10.209-cip44-rt18.tar.gz",
+		},
+	}
+
+	for kernelRelease, expected := range tests {
+		got := fetchVanillaKernelURLsFromKernelVersion(kernelrelease.FromString(kernelRelease))
+		if len(got) != len(expected) {
+			t.Fatalf("Slice sizes don't match! Test Input: '%s' | Got: '%v' / Want: '%v'", kernelRelease, got, expected)
+		}
+		for i, v := range got {
+			if v != expected[i] {
+				t.Errorf("Slice values don't match! Test Input: '%s' | Got: '%v' / Want: '%v'", kernelRelease, got, expected)
+			}
+		}
+	}
+}
+
+func TestVanillaLocalVersion(t *testing.T) {
+	tests := map[string]string{
+		"6.6.8":               "",
+		"6.6.8-custom":        "-custom",
+		"6.8-rc3":             "",
+		"6.8.0-rc3-custom":    "-custom",
+		"4.19.306-cip110":     "",
+		"5.10.209-cip44-rt18": "",
+	}
+
+	for kernelRelease, expected := range tests {
+		if got := vanillaLocalVersion(kernelrelease.FromString(kernelRelease)); got != expected {
+			t.Errorf("Test Input: [ '%s' ] | Got: [ '%s' ] / Want: [ '%s' ]", kernelRelease, got, expected)
+		}
+	}
+}
+
+func TestVanillaSignatureURL(t *testing.T) {
+	got, err := vanillaSignatureURL("https://cdn.kernel.org/pub/linux/kernel/v6.x/testing/linux-6.8-rc3.tar.xz")
+	if want := "https://cdn.kernel.org/pub/linux/kernel/v6.x/testing/linux-6.8-rc3.tar.sign"; err != nil || got != want {
+		t.Errorf("Got: [ '%s', '%v' ] / Want: [ '%s' ]", got, err, want)
+	}
+	if _, err := vanillaSignatureURL("https://git.kernel.org/torvalds/t/linux-6.8-rc3.tar.gz"); err == nil {
+		t.Errorf("Got: [ nil ] / Want: [ not signed ]")
+	}
+}
+
+func TestVanillaTemplateSignature(t *testing.T) {
+	parsed, err := parseTemplate(Config{Build: &Build{}}, string(TargetTypeVanilla), vanillaTemplate, vanillaTemplateData{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, signatureURL := range []string{"", "https://cdn.kernel.org/pub/linux/kernel/v6.x/linux-6.6.8.tar.sign"} {
+		var buf bytes.Buffer
+		err := parsed.Execute(&buf, vanillaTemplateData{
+			KernelDownloadURL:  "https://cdn.kernel.org/pub/linux/kernel/v6.x/linux-6.6.8.tar.xz",
+			KernelTarball:      "linux-6.6.8.tar.xz",
+			KernelSignatureURL: signatureURL,
+			KernelSigningKeys:  strings.Join(vanillaSigningKeys, " "),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		script := buf.String()
+		verified := strings.Contains(script, "xz -dc /tmp/linux-6.6.8.tar.xz | gpgv --keyring /tmp/kernel-keys.gpg /tmp/kernel.tar.sign -")
+		if verified != (len(signatureURL) > 0) || !strings.Contains(script, "tar -xf /tmp/linux-6.6.8.tar.xz -C /tmp/kernel-download") {
+			t.Errorf("Test Input: '%s' | Got: '%s' / Want: the signature verified when given", signatureURL, script)
+		}
+	}
+}
+
+func TestVanillaTemplateKernelConfigFragments(t *testing.T) {
+	parsed, err := parseTemplate(Config{Build: &Build{}}, string(TargetTypeVanilla), vanillaTemplate, vanillaTemplateData{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err = parsed.Execute(&buf, vanillaTemplateData{KernelArch: "x86_64", KernelConfigFragments: []string{"CONFIG_FTRACE_SYSCALLS=y", "# CONFIG_DEBUG_INFO is not set"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := buf.String()
+	for _, want := range []string{
+		"printf '%s\\n' 'CONFIG_FTRACE_SYSCALLS=y' > /tmp/kernel-config-fragments/0.config\n",
+		"printf '%s\\n' '# CONFIG_DEBUG_INFO is not set' > /tmp/kernel-config-fragments/1.config\n",
+		"bash scripts/kconfig/merge_config.sh -m -O /tmp/kernel-config-merge /tmp/kernel.config /tmp/kernel-config-fragments/0.config /tmp/kernel-config-fragments/1.config\n",
+		"cat /tmp/kernel.config\n",
+		"make ARCH=x86_64 KCONFIG_CONFIG=/tmp/kernel.config olddefconfig\n",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("Got: '%s' / Want: '%s' in it", script, want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package driverbuilder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	logger "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+type buildLogKey struct{}
+
+// WithBuildLog returns a context whose builds also write the output of their build script into w, line by line,
+// e.g. to expose the logs of each build of a long-lived service.
+// The builds write into w from their own goroutines, it must be safe to use concurrently.
+func WithBuildLog(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, buildLogKey{}, w)
+}
+
+// BuildLog returns the writer the output of the build script is copied into, if any.
+func BuildLog(ctx context.Context) io.Writer {
+	w, _ := ctx.Value(buildLogKey{}).(io.Writer)
+	return w
+}
+
+type buildIDKey struct{}
+
+type buildLoggerKey struct{}
+
+// WithBuildID returns a context whose builds have the ID, in their report and in the fields of their log lines,
+// rather than a generated one, e.g. to correlate them with the requests they come from.
+func WithBuildID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, buildIDKey{}, id)
+}
+
+// withBuildLogger returns a context logging with the fields of the build, unless it already does, together with the build ID.
+func withBuildLogger(ctx context.Context, b *builder.Build) (context.Context, string) {
+	id, ok := ctx.Value(buildIDKey{}).(string)
+	if !ok {
+		id = string(uuid.NewUUID())
+		ctx = WithBuildID(ctx, id)
+	}
+	if ctx.Value(buildLoggerKey{}) != nil {
+		return ctx, id
+	}
+	l := builder.Logger(ctx).WithFields(buildFields(id, b))
+	return context.WithValue(builder.WithLogger(ctx, l), buildLoggerKey{}, true), id
+}
+
+// buildFields returns the fields of the log lines of the build.
+func buildFields(id string, b *builder.Build) logger.Fields {
+	return logger.Fields{
+		"build":         id,
+		"target":        b.TargetType,
+		"arch":          b.Architecture,
+		"kernelrelease": b.KernelRelease,
+	}
+}
+
+// buildLogFile saves the output of the build script into a file, after a header describing the build.
+// It is not buffered, so that the builds killed midway still leave the lines written so far.
+type buildLogFile struct {
+	mu      sync.Mutex
+	file    *os.File
+	header  func() string
+	started bool
+	closed  bool
+}
+
+func (w *buildLogFile) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// the late lines of the builds completed already are dropped
+	if w.closed {
+		return len(p), nil
+	}
+	w.writeHeader()
+	return w.file.Write(p)
+}
+
+// writeHeader writes the header once, before the first line, when the kernel URLs are resolved already.
+func (w *buildLogFile) writeHeader() {
+	if !w.started {
+		w.started = true
+		io.WriteString(w.file, w.header())
+	}
+}
+
+// close ends the file with the outcome of the build, given its error if any.
+func (w *buildLogFile) close(err error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.writeHeader()
+	w.closed = true
+	outcome := "succeeded"
+	if err != nil {
+		outcome = "failed: " + err.Error()
+	}
+	fmt.Fprintf(w.file, "# build %s at %s\n", outcome, time.Now().Format(time.RFC3339))
+	return w.file.Close()
+}
+
+// withBuildLogFile returns a context whose build log is also saved into the rendered build log path of the build, if any,
+// and the file it is saved into. The build runs all the same when the file cannot be created, it is only logged.
+func withBuildLogFile(ctx context.Context, b *builder.Build, r *BuildReport, resolved func() []string) (context.Context, *buildLogFile) {
+	if len(b.BuildLogPath) == 0 {
+		return ctx, nil
+	}
+	name, err := renderBuildTemplate(b.BuildLogPath, r)
+	if err == nil {
+		var file *os.File
+		if file, err = os.Create(name); err == nil {
+			r.BuildLog = name
+			w := &buildLogFile{file: file, header: func() string { return buildLogHeader(b, r, resolved()) }}
+			if next := BuildLog(ctx); next != nil {
+				return WithBuildLog(ctx, io.MultiWriter(next, w)), w
+			}
+			return WithBuildLog(ctx, w), w
+		}
+	}
+	builder.Logger(ctx).WithError(err).Warn("unable to save the build log")
+	return ctx, nil
+}
+
+// buildLogHeader returns the header of the build log, with the details of the build and the kernel URLs it resolved.
+func buildLogHeader(b *builder.Build, r *BuildReport, urls []string) string {
+	var sb strings.Builder
+	line := func(name string, value string) {
+		if len(value) > 0 {
+			fmt.Fprintf(&sb, "# %s: %s\n", name, value)
+		}
+	}
+	line("build", r.ID)
+	line("started at", r.StartedAt.Format(time.RFC3339))
+	line("target", r.Target)
+	line("architecture", r.Architecture)
+	line("kernel release", r.KernelRelease)
+	line("kernel version", r.KernelVersion)
+	line("driver version", r.DriverVersion)
+	line("builder image", r.BuilderImage)
+	line("builder template", r.BuilderTemplate)
+	line("kernel module", b.ModuleFilePath)
+	line("eBPF probe", b.ProbeFilePath)
+	line("modern eBPF probe", b.ModernProbeFilePath)
+	line("BTF of the kernel", b.BTFFilePath)
+	line("DKMS package", b.DKMSFilePath)
+	for _, u := range urls {
+		line("kernel url", u)
+	}
+	sb.WriteString("\n")
+	return sb.String()
+}
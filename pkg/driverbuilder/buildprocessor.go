@@ -1,12 +1,359 @@
 package driverbuilder
 
 import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
 	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	logger "github.com/sirupsen/logrus"
 )
 
 var BuilderBaseImage = "falcosecurity/driverkit-builder:latest" // This is overwritten when using the Makefile to build
 
+// BuildProcessor runs the builds, stopping them and cleaning up when the context is canceled.
+// The report of the build is returned even when it fails.
 type BuildProcessor interface {
-	Start(b *builder.Build) error
+	Start(ctx context.Context, b *builder.Build) (*BuildReport, error)
 	String() string
 }
+
+// ProcessorOption configures a processor.
+type ProcessorOption func(o *processorOptions)
+
+// processorOptions are the options all the processors have.
+type processorOptions struct {
+	logger logger.FieldLogger
+}
+
+// WithLogger makes the processor log with the logger rather than with the standard one,
+// the lines of each build get its ID, target, architecture and kernel release as fields.
+func WithLogger(l logger.FieldLogger) ProcessorOption {
+	return func(o *processorOptions) {
+		o.logger = l
+	}
+}
+
+func newProcessorOptions(opts []ProcessorOption) processorOptions {
+	o := processorOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// withLogger returns a context logging with the logger of the processor, if any,
+// unless it already logs with the fields of a build.
+func (o processorOptions) withLogger(ctx context.Context) context.Context {
+	if o.logger == nil || ctx.Value(buildLoggerKey{}) != nil {
+		return ctx
+	}
+	return builder.WithLogger(ctx, o.logger)
+}
+
+// DryRunner is implemented by the processors able to stop the builds right before running them.
+// DryRun validates the build and writes the script it would run, byte for byte, the report has no artifacts.
+type DryRunner interface {
+	DryRun(ctx context.Context, b *builder.Build, w io.Writer) (*BuildReport, error)
+}
+
+// writeScript writes the script of a dry run.
+func writeScript(w io.Writer, script string) error {
+	_, err := io.WriteString(w, script)
+	return err
+}
+
+// builderImageOf returns the builder image the build runs into: the one given, the one of the image repository
+// when only the base one is given, see BuilderImageTag, the base one otherwise.
+func builderImageOf(b *builder.Build) string {
+	if len(b.CustomBuilderImage) > 0 && (b.CustomBuilderImage != BuilderBaseImage || len(b.ImageRepo) == 0) {
+		return b.CustomBuilderImage
+	}
+	if len(b.ImageRepo) > 0 {
+		return b.ImageRepo + ":" + BuilderImageTag(b.TargetType, b.Architecture, b.GCCVersion)
+	}
+	return BuilderBaseImage
+}
+
+// BuilderImageTag returns the tag of the builder image of an image repository for the target, the architecture built for
+// and the gcc version chosen, if any, e.g. debian-amd64 or, with gcc 6, debian-gcc6-amd64.
+func BuilderImageTag(target builder.Type, arch string, gccVersion string) string {
+	if len(gccVersion) > 0 {
+		return fmt.Sprintf("%s-gcc%s-%s", target, gccVersion, arch)
+	}
+	return fmt.Sprintf("%s-%s", target, arch)
+}
+
+// crossCompiledArchitectures are the architectures without a builder image, always built from the amd64 one.
+var crossCompiledArchitectures = map[string]bool{
+	"riscv64": true,
+	"arm":     true,
+}
+
+// builderImageLLVMVersions are the LLVM releases the base builder image ships, see build/builder.Dockerfile.
+var builderImageLLVMVersions = []string{"6.0", "7", "12", "14"}
+
+// builderArchitectureOf returns the architecture the builder image runs for on the host:
+// the one of the host when the build is cross compiled from it, the one of the build, emulated if need be, otherwise.
+func builderArchitectureOf(b *builder.Build, host string) string {
+	if crossCompiledArchitectures[b.Architecture] {
+		return "amd64"
+	}
+	if host == "amd64" && !b.ForceEmulation && builder.CanCrossCompile(b) {
+		return host
+	}
+	return b.Architecture
+}
+
+// buildEnvNames returns the names of the variables of the build, sorted, the processors set them on the builder in this order.
+func buildEnvNames(b *builder.Build) []string {
+	names := make([]string, 0, len(b.Env))
+	for name := range b.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// artifactsFailedError is the error of the builds whose kernel module or eBPF probe failed to build, the tail of its build log by artifact type.
+// The other artifacts of the build were built nonetheless.
+type artifactsFailedError map[string]string
+
+func (e artifactsFailedError) Error() string {
+	names := []string{}
+	for _, typ := range []string{ArtifactModule, ArtifactProbe} {
+		if _, failed := e[typ]; failed {
+			names = append(names, artifactNames[typ])
+		}
+	}
+	return fmt.Sprintf("the %s failed to build", strings.Join(names, " and the "))
+}
+
+// readArtifactFailures reads the failures the build script recorded for the kernel module and the eBPF probe of the build, if any,
+// with read reading a file of the builder.
+func readArtifactFailures(b *builder.Build, read func(name string) ([]byte, error)) artifactsFailedError {
+	failures := artifactsFailedError{}
+	for typ, output := range map[string]string{ArtifactModule: b.ModuleFilePath, ArtifactProbe: b.ProbeFilePath} {
+		if len(output) == 0 {
+			continue
+		}
+		if tail, err := read(builder.ArtifactFailurePath(typ)); err == nil {
+			failures[typ] = string(tail)
+		}
+	}
+	return failures
+}
+
+// copyArtifacts copies the artifacts of the build from the builder to their output paths with copy,
+// but the ones that failed to build: their failures are returned once the other artifacts are copied.
+// The BTF is skipped by the builders when the debug package of the kernel is not found, its copy does not fail the build.
+func copyArtifacts(ctx context.Context, b *builder.Build, failures artifactsFailedError, copy func(from, to string) error) error {
+	for _, a := range []struct {
+		typ  string
+		from string
+		to   string
+	}{
+		{ArtifactModule, builder.ModuleFullPath, b.ModuleFilePath},
+		{ArtifactProbe, builder.ProbeFullPath, b.ProbeFilePath},
+		{ArtifactModernProbe, builder.ModernProbeFullPath, b.ModernProbeFilePath},
+		{ArtifactDKMS, builder.DKMSFullPath, b.DKMSFilePath},
+		{ArtifactBTF, builder.BTFFullPath, b.BTFFilePath},
+	} {
+		if len(a.to) == 0 {
+			continue
+		}
+		if _, failed := failures[a.typ]; failed {
+			builder.Logger(ctx).Errorf("%s failed to build", artifactNames[a.typ])
+			continue
+		}
+		if err := copy(a.from, a.to); err != nil {
+			if a.typ == ArtifactBTF {
+				builder.Logger(ctx).WithError(err).Warn("BTF of the kernel not generated")
+				continue
+			}
+			return err
+		}
+		builder.Logger(ctx).WithField("path", a.to).Infof("%s available", artifactNames[a.typ])
+	}
+	if len(failures) > 0 {
+		return failures
+	}
+	return nil
+}
+
+// readCABundle reads the CA bundle, if any.
+func readCABundle(caCert string) ([]byte, error) {
+	if len(caCert) == 0 {
+		return nil, nil
+	}
+	return ioutil.ReadFile(caCert)
+}
+
+// readModuleSigningKey reads the key pair signing the kernel module, if any and if the build has a kernel module.
+func readModuleSigningKey(b *builder.Build) ([]byte, []byte, error) {
+	if len(b.ModuleSigningKey) == 0 || len(b.ModuleFilePath) == 0 {
+		return nil, nil, nil
+	}
+	key, err := ioutil.ReadFile(b.ModuleSigningKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := ioutil.ReadFile(b.ModuleSigningCert)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, cert, nil
+}
+
+// readRHELEntitlement reads the entitlement certificate and key of the build, if any.
+func readRHELEntitlement(b *builder.Build) ([]byte, []byte, error) {
+	if len(b.RHELEntitlementCert) == 0 {
+		return nil, nil, nil
+	}
+	read := func(name, kind string) ([]byte, error) {
+		content, err := ioutil.ReadFile(name)
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("the RHEL entitlement %s %s is missing, the entitlements of a subscribed system are in /etc/pki/entitlement", kind, name)
+		}
+		return content, err
+	}
+	cert, err := read(b.RHELEntitlementCert, "cert")
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := read(b.RHELEntitlementKey, "key")
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// withLocalKernel returns a copy of the build installing the packages of the local kernel directory, if any,
+// together with their names. The processor must copy them into builder.LocalKernelDirectory.
+func withLocalKernel(b *builder.Build) (*builder.Build, []string, error) {
+	if len(b.LocalKernelDir) == 0 {
+		return b, nil, nil
+	}
+	entries, err := ioutil.ReadDir(b.LocalKernelDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	names := []string{}
+	for _, e := range entries {
+		if e.Mode().IsRegular() {
+			names = append(names, e.Name())
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil, fmt.Errorf("no kernel packages found in %s", b.LocalKernelDir)
+	}
+	build := *b
+	build.KernelUrls = builder.LocalKernelURLs(names)
+	return &build, names, nil
+}
+
+// checkLocalDriver fails unless the local driver directory, if any, is a tree of the libs, having the sources of the driver.
+// The processor must copy it into builder.LocalDriverDirectory.
+func checkLocalDriver(b *builder.Build) error {
+	if len(b.LocalDriverDir) == 0 {
+		return nil
+	}
+	info, err := os.Stat(filepath.Join(b.LocalDriverDir, "driver"))
+	if err != nil || !info.IsDir() {
+		return fmt.Errorf("the local driver directory %s is not a tree of the libs, it has no driver directory", b.LocalDriverDir)
+	}
+	return nil
+}
+
+// walkLocalDriver calls visit with the files and the directories of the local driver directory, by their path relative to it.
+// The symlinks and the .git directories, of the working copies, are skipped.
+func walkLocalDriver(dir string, visit func(name, rel string, info os.FileInfo) error) error {
+	return filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Name() == ".git" && name != dir {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+		return visit(name, filepath.ToSlash(rel), info)
+	})
+}
+
+// tarLocalDriver writes the sources of the local driver directory into the archive, under the to directory,
+// streaming them since the trees can be big.
+func tarLocalDriver(w io.Writer, dir string, to string) error {
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+	return tarLocalDriverFiles(tw, dir, to)
+}
+
+func tarLocalDriverFiles(tw *tar.Writer, dir string, to string) error {
+	return walkLocalDriver(dir, func(name, rel string, info os.FileInfo) error {
+		if info.IsDir() {
+			return tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: path.Join(to, rel) + "/", Mode: 0755})
+		}
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		// keep the scripts of the tree executable
+		hdr := &tar.Header{Name: path.Join(to, rel), Mode: int64(info.Mode().Perm() | 0644), Size: info.Size()}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, f)
+		return err
+	})
+}
+
+// tarLocalKernel writes the packages of the local kernel directory into the archive, under the to directory.
+func tarLocalKernel(w io.Writer, dir string, names []string, to string) error {
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+	for _, name := range names {
+		if err := tarFile(tw, filepath.Join(dir, name), path.Join(to, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func tarFile(tw *tar.Writer, from, to string) error {
+	f, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{
+		Name: to,
+		Mode: 0644,
+		Size: info.Size(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
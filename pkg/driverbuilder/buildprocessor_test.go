@@ -0,0 +1,135 @@
+package driverbuilder
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+)
+
+func TestBuilderArchitecture(t *testing.T) {
+	tests := []struct {
+		build *builder.Build
+		host  string
+		want  string
+	}{
+		{&builder.Build{TargetType: builder.TargetTypeVanilla, Architecture: "amd64"}, "amd64", "amd64"},
+		{&builder.Build{TargetType: builder.TargetTypeVanilla, Architecture: "arm64"}, "arm64", "arm64"},
+		{&builder.Build{TargetType: builder.TargetTypeVanilla, Architecture: "arm64"}, "amd64", "amd64"},
+		{&builder.Build{TargetType: builder.TargetTypeVanilla, Architecture: "arm64", ForceEmulation: true}, "amd64", "arm64"},
+		{&builder.Build{TargetType: builder.TargetTypeCos, Architecture: "arm64"}, "amd64", "arm64"},
+		{&builder.Build{TargetType: builder.TargetTypeUbuntu, Architecture: "s390x"}, "amd64", "s390x"},
+		{&builder.Build{TargetType: builder.TargetTypeUbuntu, Architecture: "riscv64"}, "riscv64", "amd64"},
+		{&builder.Build{TargetType: builder.TargetTypeDebian, Architecture: "arm"}, "arm64", "amd64"},
+	}
+	for _, test := range tests {
+		if got := builderArchitectureOf(test.build, test.host); got != test.want {
+			t.Errorf("Test Input: '%s %s on %s' | Got: [ '%s' ] / Want: [ '%s' ]", test.build.TargetType, test.build.Architecture, test.host, got, test.want)
+		}
+	}
+}
+
+func TestBuilderImage(t *testing.T) {
+	tests := []struct {
+		build *builder.Build
+		want  string
+	}{
+		{&builder.Build{TargetType: builder.TargetTypeDebian, Architecture: "amd64"}, BuilderBaseImage},
+		{&builder.Build{TargetType: builder.TargetTypeDebian, Architecture: "amd64", CustomBuilderImage: "registry.example.com/builder:1.0"}, "registry.example.com/builder:1.0"},
+		{&builder.Build{TargetType: builder.TargetTypeDebian, Architecture: "arm64", ImageRepo: "registry.example.com/driverkit"}, "registry.example.com/driverkit:debian-arm64"},
+		{&builder.Build{TargetType: builder.TargetTypeDebian, Architecture: "amd64", CustomBuilderImage: BuilderBaseImage, ImageRepo: "registry.example.com/driverkit", GCCVersion: "6"}, "registry.example.com/driverkit:debian-gcc6-amd64"},
+	}
+	for _, test := range tests {
+		if got := builderImageOf(test.build); got != test.want {
+			t.Errorf("Test Input: '%s %s from %s' | Got: [ '%s' ] / Want: [ '%s' ]", test.build.TargetType, test.build.Architecture, test.build.ImageRepo, got, test.want)
+		}
+	}
+}
+
+func TestReadRHELEntitlement(t *testing.T) {
+	dir := t.TempDir()
+	cert := filepath.Join(dir, "1234.pem")
+	key := filepath.Join(dir, "1234-key.pem")
+	if err := ioutil.WriteFile(cert, []byte("cert"), 0600); err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+
+	if _, _, err := readRHELEntitlement(&builder.Build{RHELEntitlementCert: cert, RHELEntitlementKey: key}); err == nil || !strings.Contains(err.Error(), "the RHEL entitlement key "+key+" is missing") {
+		t.Errorf("Got: '%v' / Want: 'the RHEL entitlement key %s is missing ...'", err, key)
+	}
+	if err := ioutil.WriteFile(key, []byte("key"), 0600); err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	gotCert, gotKey, err := readRHELEntitlement(&builder.Build{RHELEntitlementCert: cert, RHELEntitlementKey: key})
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if string(gotCert) != "cert" || string(gotKey) != "key" {
+		t.Errorf("Got: [ '%s', '%s' ] / Want: [ 'cert', 'key' ]", gotCert, gotKey)
+	}
+}
+
+func TestTarLocalDriver(t *testing.T) {
+	dir := t.TempDir()
+	for name, body := range map[string]string{
+		"driver/main.c":         "main",
+		"driver/bpf/probe.c":    "probe",
+		".git/HEAD":             "ref: refs/heads/master",
+		"driver/.git":           "gitdir: ../.git/worktrees/driver",
+		"userspace/libscap/a.c": "a",
+	} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("/etc/passwd", filepath.Join(dir, "driver", "passwd")); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkLocalDriver(&builder.Build{LocalDriverDir: dir}); err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if err := checkLocalDriver(&builder.Build{LocalDriverDir: filepath.Join(dir, "driver")}); err == nil {
+		t.Errorf("Got: [ nil ] / Want: [ not a tree of the libs ]")
+	}
+
+	var buf bytes.Buffer
+	if err := tarLocalDriver(&buf, dir, "tmp/driverkit-driver"); err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	got := map[string]string{}
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+		}
+		body, _ := ioutil.ReadAll(tr)
+		got[hdr.Name] = string(body)
+	}
+	want := map[string]string{
+		"tmp/driverkit-driver/":                      "",
+		"tmp/driverkit-driver/driver/":               "",
+		"tmp/driverkit-driver/driver/bpf/":           "",
+		"tmp/driverkit-driver/driver/bpf/probe.c":    "probe",
+		"tmp/driverkit-driver/driver/main.c":         "main",
+		"tmp/driverkit-driver/userspace/":            "",
+		"tmp/driverkit-driver/userspace/libscap/":    "",
+		"tmp/driverkit-driver/userspace/libscap/a.c": "a",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got: [ %v ] / Want: [ %v ]", got, want)
+	}
+}
@@ -0,0 +1,93 @@
+package driverbuilder
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CcacheReport is the ccache hit statistics of a build compiling through ccache.
+type CcacheReport struct {
+	Hits   int `json:"hits" yaml:"hits"`
+	Misses int `json:"misses" yaml:"misses"`
+}
+
+var (
+	// ccache 3.x prints a line per kind of hit, e.g. cache hit (direct)    12
+	ccache3HitPattern  = regexp.MustCompile(`cache hit \((?:direct|preprocessed)\)\s+(\d+)`)
+	ccache3MissPattern = regexp.MustCompile(`cache miss\s+(\d+)`)
+	// ccache 4.x prints the hits of the local and remote storages too, the first ones are the totals, e.g. Hits: 12 / 20 (60.00 %)
+	ccache4HitPattern  = regexp.MustCompile(`Hits:\s+(\d+)`)
+	ccache4MissPattern = regexp.MustCompile(`Misses:\s+(\d+)`)
+)
+
+// parseCcacheStats parses the output of ccache -s, of either ccache 3.x or 4.x, returning nil when it holds no statistics.
+func parseCcacheStats(output string) *CcacheReport {
+	var report CcacheReport
+	var found, hits, misses bool
+	atoi := func(m []string) int {
+		n, _ := strconv.Atoi(m[1])
+		return n
+	}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := ccache3HitPattern.FindStringSubmatch(line); m != nil {
+			report.Hits += atoi(m)
+			found = true
+		} else if m := ccache3MissPattern.FindStringSubmatch(line); m != nil {
+			report.Misses += atoi(m)
+			found = true
+		} else if m := ccache4HitPattern.FindStringSubmatch(line); m != nil && !hits {
+			report.Hits, hits, found = atoi(m), true, true
+		} else if m := ccache4MissPattern.FindStringSubmatch(line); m != nil && !misses {
+			report.Misses, misses, found = atoi(m), true, true
+		}
+	}
+	if !found {
+		return nil
+	}
+	return &report
+}
+
+// ccacheStatsWriter keeps the output of the build script following the ccache statistics marker,
+// forwarding everything to the build log it wraps, if any.
+type ccacheStatsWriter struct {
+	mu     sync.Mutex
+	next   io.Writer
+	marked bool
+	stats  bytes.Buffer
+}
+
+func (w *ccacheStatsWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	if w.marked {
+		w.stats.Write(p)
+	} else if i := bytes.Index(p, []byte(ccacheStatsMarker)); i >= 0 {
+		w.marked = true
+		w.stats.Write(p[i+len(ccacheStatsMarker):])
+	}
+	w.mu.Unlock()
+	if w.next != nil {
+		return w.next.Write(p)
+	}
+	return len(p), nil
+}
+
+// report returns the ccache statistics printed by the build script, nil when it printed none.
+func (w *ccacheStatsWriter) report() *CcacheReport {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return parseCcacheStats(w.stats.String())
+}
+
+// withCcacheStats returns a context whose build log also collects the ccache statistics printed by the build script.
+func withCcacheStats(ctx context.Context) (context.Context, *ccacheStatsWriter) {
+	w := &ccacheStatsWriter{next: BuildLog(ctx)}
+	return WithBuildLog(ctx, w), w
+}
@@ -0,0 +1,68 @@
+package driverbuilder
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestParseCcacheStats(t *testing.T) {
+	tests := map[string]struct {
+		output string
+		want   *CcacheReport
+	}{
+		"ccache 3": {
+			output: `cache directory                     /tmp/driverkit-ccache
+primary config                      /tmp/driverkit-ccache/ccache.conf
+cache hit (direct)                    12
+cache hit (preprocessed)               3
+cache miss                             5
+cache hit rate                     75.00 %
+`,
+			want: &CcacheReport{Hits: 15, Misses: 5},
+		},
+		"ccache 4": {
+			output: `Cacheable calls:    20 / 22 (90.91%)
+  Hits:             15 / 20 (75.00%)
+    Direct:         12 / 15 (80.00%)
+    Preprocessed:    3 / 15 (20.00%)
+  Misses:            5 / 20 (25.00%)
+Local storage:
+  Cache size (GB): 0.1 / 5.0 ( 2.00%)
+  Hits:             15 / 20 (75.00%)
+  Misses:            5 / 20 (25.00%)
+`,
+			want: &CcacheReport{Hits: 15, Misses: 5},
+		},
+		"no statistics": {
+			output: "bash: ccache: command not found\n",
+		},
+	}
+	for name, test := range tests {
+		if got := parseCcacheStats(test.output); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Test Input: '%s' | Got: '%+v' / Want: '%+v'", name, got, test.want)
+		}
+	}
+}
+
+func TestWithCcacheStats(t *testing.T) {
+	var buildLog bytes.Buffer
+	ctx, w := withCcacheStats(WithBuildLog(context.Background(), &buildLog))
+	lines := []string{
+		"+ make CC=\"ccache gcc\"\n",
+		// the docker logs are multiplexed, the lines start with the header of their stream
+		"\x01\x00\x00\x00\x00\x00\x00\x1d" + ccacheStatsMarker + "\n",
+		"cache hit (direct)                    12\n",
+		"cache miss                             5\n",
+	}
+	for _, line := range lines {
+		BuildLog(ctx).Write([]byte(line))
+	}
+	if got, want := w.report(), (&CcacheReport{Hits: 12, Misses: 5}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got: '%+v' / Want: '%+v'", got, want)
+	}
+	if got := buildLog.Len(); got == 0 {
+		t.Errorf("Got: [ nothing ] / Want: [ the build log forwarded ]")
+	}
+}
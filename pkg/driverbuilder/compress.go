@@ -0,0 +1,68 @@
+package driverbuilder
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ulikunitz/xz"
+)
+
+// CompressionExtensions are the extensions of the artifacts compressed with each algorithm.
+var CompressionExtensions = map[string]string{
+	"gzip": ".gz",
+	"xz":   ".xz",
+}
+
+// compressArtifact replaces the artifact with its compressed version, with the extension of the algorithm appended to its path.
+func compressArtifact(a *ArtifactReport, algorithm string) error {
+	extension, ok := CompressionExtensions[algorithm]
+	if !ok {
+		return fmt.Errorf("unsupported compression algorithm %s", algorithm)
+	}
+	in, err := os.Open(a.Path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	name := a.Path + extension
+	out, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := compress(out, in, algorithm); err != nil {
+		out.Close()
+		os.Remove(name)
+		return fmt.Errorf("unable to compress %s: %s", a.Path, err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(name)
+		return err
+	}
+	// only the compressed artifact is left
+	if err := os.Remove(a.Path); err != nil {
+		return err
+	}
+	a.Path = name
+	return nil
+}
+
+func compress(w io.Writer, r io.Reader, algorithm string) error {
+	var cw io.WriteCloser
+	switch algorithm {
+	case "gzip":
+		cw = gzip.NewWriter(w)
+	case "xz":
+		var err error
+		if cw, err = xz.NewWriter(w); err != nil {
+			return err
+		}
+	}
+	if _, err := io.Copy(cw, r); err != nil {
+		cw.Close()
+		return err
+	}
+	return cw.Close()
+}
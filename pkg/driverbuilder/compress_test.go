@@ -0,0 +1,69 @@
+package driverbuilder
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/ulikunitz/xz"
+)
+
+func TestBuildReportCompression(t *testing.T) {
+	for algorithm, extension := range CompressionExtensions {
+		t.Run(algorithm, func(t *testing.T) {
+			dir := t.TempDir()
+			b := &builder.Build{
+				TargetType:     builder.TargetTypeVanilla,
+				KernelRelease:  "5.10.0",
+				ModuleFilePath: filepath.Join(dir, "falco.ko"),
+				Checksum:       "sha256",
+				Compression:    algorithm,
+			}
+			if err := ioutil.WriteFile(b.ModuleFilePath, []byte("module"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			_, reporter := startReport(context.Background(), b, BuilderBaseImage)
+			report, err := reporter.complete(context.Background(), b, nil)
+			if err != nil {
+				t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+			}
+			a := report.Artifacts[0]
+			if a.Path != b.ModuleFilePath+extension || a.Compression != algorithm || a.ChecksumFile != a.Path+".sha256" {
+				t.Errorf("Got: [ %+v ] / Want: [ the compressed module ]", a)
+			}
+			if _, err := os.Stat(b.ModuleFilePath); !os.IsNotExist(err) {
+				t.Errorf("Got: [ %v ] / Want: [ the uncompressed module removed ]", err)
+			}
+
+			content, err := ioutil.ReadFile(a.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sum := sha256.Sum256(content); a.SHA256 != hex.EncodeToString(sum[:]) {
+				t.Errorf("Got: [ %s ] / Want: [ the checksum of the compressed module ]", a.SHA256)
+			}
+			f, _ := os.Open(a.Path)
+			defer f.Close()
+			var r io.Reader
+			if algorithm == "gzip" {
+				r, err = gzip.NewReader(f)
+			} else {
+				r, err = xz.NewReader(f)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, err := ioutil.ReadAll(r); err != nil || string(got) != "module" {
+				t.Errorf("Got: [ '%s', %v ] / Want: [ 'module' ]", got, err)
+			}
+		})
+	}
+}
@@ -12,15 +12,21 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"os"
+	"path"
+	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
-	"github.com/falcosecurity/driverkit/pkg/signals"
+	homedir "github.com/mitchellh/go-homedir"
 	logger "github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/util/uuid"
 )
@@ -28,17 +34,45 @@ import (
 // DockerBuildProcessorName is a constant containing the docker name.
 const DockerBuildProcessorName = "docker"
 
+// reusedContainerLabel marks the builder containers reused across builds.
+const reusedContainerLabel = "org.falcosecurity/driverkit-reuse"
+
+// reusedContainerWorkDirectory is the directory of the reused builder containers the builds get their working directories into.
+const reusedContainerWorkDirectory = "/driverkit-builds"
+
 type DockerBuildProcessor struct {
-	clean   bool
-	timeout int
-	proxy   string
+	processorOptions
+	// pullMu makes the builds running concurrently pull the builder image once
+	pullMu         sync.Mutex
+	timeout        int
+	proxy          string
+	caCert         string
+	registry       RegistryCredentials
+	host           DockerHost
+	reuseContainer string
+}
+
+// DockerHost is the daemon the docker processor talks to, overriding the DOCKER_HOST, DOCKER_TLS_VERIFY and DOCKER_CERT_PATH variables.
+// The daemon is verified against the CA when TLS is enabled, the certificates default to the ones of DOCKER_CERT_PATH or ~/.docker.
+type DockerHost struct {
+	Host      string
+	TLSVerify bool
+	TLSCACert string
+	TLSCert   string
+	TLSKey    string
 }
 
 // NewDockerBuildProcessor ...
-func NewDockerBuildProcessor(timeout int, proxy string) *DockerBuildProcessor {
+// When reuseContainer is given, the builds run into that long-lived builder container, one at a time.
+func NewDockerBuildProcessor(timeout int, proxy string, caCert string, registry RegistryCredentials, host DockerHost, reuseContainer string, opts ...ProcessorOption) *DockerBuildProcessor {
 	return &DockerBuildProcessor{
-		timeout: timeout,
-		proxy:   proxy,
+		processorOptions: newProcessorOptions(opts),
+		timeout:          timeout,
+		proxy:            proxy,
+		caCert:           caCert,
+		registry:         registry,
+		host:             host,
+		reuseContainer:   reuseContainer,
 	}
 }
 
@@ -46,20 +80,32 @@ func (bp *DockerBuildProcessor) String() string {
 	return DockerBuildProcessorName
 }
 
-func mustCheckArchUseQemu(ctx context.Context, b *builder.Build, cli *client.Client) {
-	var err error
-	if b.Architecture == runtime.GOARCH {
+// daemonArchitecture returns the architecture of the daemon, the local one when there is no client.
+func daemonArchitecture(ctx context.Context, cli *client.Client) string {
+	if cli == nil {
+		return runtime.GOARCH
+	}
+	// the daemon may be remote, its architecture is the one that matters
+	version, err := cli.ServerVersion(ctx)
+	if err == nil && version.Arch != "" {
+		return version.Arch
+	}
+	return runtime.GOARCH
+}
+
+func mustCheckArchUseQemu(ctx context.Context, builderArch string, arch string, cli *client.Client) {
+	if builderArch == arch {
 		// Nothing to do
 		return
 	}
 
-	if runtime.GOARCH != "amd64" {
+	if arch != "amd64" {
 		log.Fatal("qemu-user-static image is only available for x86_64 hosts: https://github.com/multiarch/qemu-user-static#supported-host-architectures")
 	}
 
-	logger.Debug("using qemu for cross build")
-	if _, _, err = cli.ImageInspectWithRaw(ctx, "multiarch/qemu-user-static"); client.IsErrNotFound(err) {
-		logger.WithField("image", "multiarch/qemu-user-static").Debug("pulling qemu static image")
+	builder.Logger(ctx).Debug("using qemu for cross build")
+	if _, _, err := cli.ImageInspectWithRaw(ctx, "multiarch/qemu-user-static"); client.IsErrNotFound(err) {
+		builder.Logger(ctx).WithField("image", "multiarch/qemu-user-static").Debug("pulling qemu static image")
 		pullRes, err := cli.ImagePull(ctx, "multiarch/qemu-user-static", types.ImagePullOptions{})
 		if err != nil {
 			log.Fatal(err)
@@ -103,31 +149,143 @@ func mustCheckArchUseQemu(ctx context.Context, b *builder.Build, cli *client.Cli
 }
 
 // Start the docker processor
-func (bp *DockerBuildProcessor) Start(b *builder.Build) error {
-	logger.Debug("doing a new docker build")
-	cli, err := client.NewClientWithOpts(client.FromEnv)
+func (bp *DockerBuildProcessor) Start(ctx context.Context, b *builder.Build) (*BuildReport, error) {
+	ctx, reporter := startReport(bp.withLogger(ctx), b, builderImageOf(b))
+	err := bp.start(ctx, b)
+	return reporter.complete(ctx, b, err)
+}
+
+// start runs the build.
+func (bp *DockerBuildProcessor) start(ctx context.Context, b *builder.Build) error {
+	builder.Logger(ctx).Debug("doing a new docker build")
+	cli, err := dockerClient(bp.host)
 	if err != nil {
 		return err
 	}
+	builder.Logger(ctx).WithField("host", cli.DaemonHost()).Debug("connecting to docker")
+	return bp.build(ctx, cli, b, nil)
+}
+
+// DryRun implements DryRunner, the build stops right before creating the container.
+func (bp *DockerBuildProcessor) DryRun(ctx context.Context, b *builder.Build, w io.Writer) (*BuildReport, error) {
+	ctx, reporter := startReport(bp.withLogger(ctx), b, builderImageOf(b))
+	err := bp.build(ctx, nil, b, w)
+	return reporter.completeWithoutArtifacts(err)
+}
+
+// dockerClient returns a client of the daemon given by the environment, unless overridden.
+func dockerClient(h DockerHost) (*client.Client, error) {
+	opts := []client.Opt{client.FromEnv, client.WithAPIVersionNegotiation()}
+	if h.TLSVerify || h.TLSCACert != "" || h.TLSCert != "" || h.TLSKey != "" {
+		certPath := os.Getenv("DOCKER_CERT_PATH")
+		if certPath == "" {
+			home, err := homedir.Dir()
+			if err != nil {
+				return nil, err
+			}
+			certPath = filepath.Join(home, ".docker")
+		}
+		withDefault := func(name, def string) string {
+			if name != "" {
+				return name
+			}
+			return filepath.Join(certPath, def)
+		}
+		opts = append(opts, client.WithTLSClientConfig(withDefault(h.TLSCACert, "ca.pem"), withDefault(h.TLSCert, "cert.pem"), withDefault(h.TLSKey, "key.pem")))
+	}
+	if h.Host != "" {
+		opts = append(opts, client.WithHost(h.Host))
+	}
+	return client.NewClientWithOpts(opts...)
+}
 
+// build runs the build against the daemon the client talks to,
+// any daemon serving the docker API (e.g. podman) works.
+// The build is stopped, and its container removed, when the context is canceled or the timeout expires.
+// When the script writer is given, the script is written to it instead, without talking to the daemon.
+func (bp *DockerBuildProcessor) build(ctx context.Context, cli *client.Client, b *builder.Build, scriptOut io.Writer) error {
 	// create a builder based on the choosen build type
 	v, err := builder.Factory(b.TargetType)
 	if err != nil {
 		return err
 	}
+	if err := bp.validateCcacheDir(b); err != nil {
+		return err
+	}
+	caBundle, err := readCABundle(bp.caCert)
+	if err != nil {
+		return err
+	}
+	signingKey, signingCert, err := readModuleSigningKey(b)
+	if err != nil {
+		return err
+	}
+	entitlementCert, entitlementKey, err := readRHELEntitlement(b)
+	if err != nil {
+		return err
+	}
+	b, localKernel, err := withLocalKernel(b)
+	if err != nil {
+		return err
+	}
+	if err := checkLocalDriver(b); err != nil {
+		return err
+	}
+	daemonArch := daemonArchitecture(ctx, cli)
+	builderArch := builderArchitectureOf(b, daemonArch)
 	c := builder.Config{
-		DriverName:      b.ModuleDriverName,
-		DeviceName:      b.ModuleDeviceName,
-		DownloadBaseURL: "https://github.com/falcosecurity/libs/archive",
-		Build:           b,
+		DriverName:          b.ModuleDriverName,
+		DeviceName:          b.ModuleDeviceName,
+		DownloadBaseURL:     "https://github.com/falcosecurity/libs/archive",
+		ProxyURL:            bp.proxy,
+		CABundle:            caBundle,
+		RHELEntitlementCert: entitlementCert,
+		RHELEntitlementKey:  entitlementKey,
+		Build:               b,
+		// the builder runs on the architecture of the build, emulated if need be, unless cross compiled
+		BuilderArchitecture: builderArch,
+	}
+
+	// fail fast when the target cannot do the build, before downloading anything
+	kr := c.Build.KernelReleaseFromBuildConfig()
+	if err := builder.Validate(v, c, kr); err != nil {
+		return err
+	}
+
+	ctx, err = builder.WithHTTPClient(ctx, c)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(bp.timeout)*time.Second)
+	defer cancel()
+
+	// fail fast when the builder image cannot be pulled, before generating the build script
+	builderImage := builderImageOf(b)
+	if scriptOut == nil {
+		mustCheckArchUseQemu(ctx, builderArch, daemonArch, cli)
+		if err := bp.pullBuilderImage(ctx, cli, builderImage, builderArch); err != nil {
+			return fmt.Errorf("unable to pull the builder image %s: %s", builderImage, err)
+		}
 	}
 
 	// Generate the build script from the builder
-	kr := c.Build.KernelReleaseFromBuildConfig()
-	driverkitScript, err := v.Script(c, kr)
+	driverkitScript, err := builder.Script(ctx, v, c, kr)
 	if err != nil {
 		return err
 	}
+	if len(b.CcacheDir) > 0 {
+		driverkitScript = withCcache(driverkitScript)
+	}
+	if len(caBundle) > 0 {
+		driverkitScript = withTrustedCABundle(driverkitScript)
+	}
+	if len(signingKey) > 0 {
+		driverkitScript = withModuleSigning(driverkitScript)
+	}
+	driverkitScript = withArtifactFailures(driverkitScript)
+	if scriptOut != nil {
+		return writeScript(scriptOut, driverkitScript)
+	}
 
 	// Prepare driver config template
 	bufFillDriverConfig := bytes.NewBuffer(nil)
@@ -148,75 +306,88 @@ func (bp *DockerBuildProcessor) Start(b *builder.Build) error {
 		return err
 	}
 
-	builderImage := BuilderBaseImage
-	if len(b.CustomBuilderImage) > 0 {
-		builderImage = b.CustomBuilderImage
+	// The paths the build uses, moved into a working directory of its own when the container is reused
+	paths := strings.NewReplacer()
+	var containerID string
+	// the container is stopped either once the build is done or as soon as it is canceled
+	var stopOnce sync.Once
+	stopContainer := func() {
+		stopOnce.Do(func() { bp.cleanup(builder.Logger(ctx), cli, containerID) })
 	}
-
-	// Create the container
-	ctx := context.Background()
-	ctx = signals.WithStandardSignals(ctx)
-
-	mustCheckArchUseQemu(ctx, b, cli)
-
-	var inspect types.ImageInspect
-	if inspect, _, err = cli.ImageInspectWithRaw(ctx, builderImage); client.IsErrNotFound(err) ||
-		inspect.Architecture != b.Architecture {
-
-		logger.
-			WithField("image", builderImage).
-			WithField("arch", b.Architecture).
-			Debug("pulling builder image")
-
-		pullRes, err := cli.ImagePull(ctx, builderImage, types.ImagePullOptions{Platform: b.Architecture})
+	if len(bp.reuseContainer) > 0 {
+		containerID, err = bp.reusedContainer(ctx, cli, builderImage, builderArch)
 		if err != nil {
 			return err
 		}
-		defer pullRes.Close()
-		_, err = io.Copy(ioutil.Discard, pullRes)
+		release, err := lockReusedContainer(ctx, cli, containerID, bp.reuseContainer)
 		if err != nil {
 			return err
 		}
-	}
+		defer release()
+		workDir := path.Join(reusedContainerWorkDirectory, string(uuid.NewUUID()))
+		paths = localPaths(workDir)
+		defer func() {
+			if _, err := execInContainer(context.Background(), cli, containerID, []string{"rm", "-rf", workDir}); err != nil {
+				builder.Logger(ctx).WithError(err).WithField("dir", workDir).Error("error removing the working directory")
+			}
+		}()
+		driverkitScript = paths.Replace(driverkitScript)
+		bufMakefile = bytes.NewBufferString(paths.Replace(bufMakefile.String()))
+		bufFillDriverConfig = bytes.NewBufferString(paths.Replace(bufFillDriverConfig.String()))
+	} else {
+		containerCfg := &container.Config{
+			Tty:   true,
+			Cmd:   []string{"/bin/sleep", strconv.Itoa(bp.timeout)},
+			Image: builderImage,
+		}
 
-	containerCfg := &container.Config{
-		Tty:   true,
-		Cmd:   []string{"/bin/sleep", strconv.Itoa(bp.timeout)},
-		Image: builderImage,
-	}
+		hostCfg := &container.HostConfig{
+			AutoRemove: true,
+		}
+		if len(b.CcacheDir) > 0 {
+			hostCfg.Binds = []string{b.CcacheDir + ":" + builder.CcacheDirectory}
+		}
+		uid := uuid.NewUUID()
+		name := fmt.Sprintf("driverkit-%s", string(uid))
 
-	hostCfg := &container.HostConfig{
-		AutoRemove: true,
-	}
-	uid := uuid.NewUUID()
-	name := fmt.Sprintf("driverkit-%s", string(uid))
+		cdata, err := cli.ContainerCreate(ctx, containerCfg, hostCfg, nil, &v1.Platform{Architecture: builderArch, OS: "linux"}, name)
+		if err != nil {
+			return err
+		}
+		containerID = cdata.ID
 
-	cdata, err := cli.ContainerCreate(ctx, containerCfg, hostCfg, nil, &v1.Platform{Architecture: b.Architecture, OS: "linux"}, name)
-	if err != nil {
-		return err
-	}
+		defer stopContainer()
 
-	defer bp.cleanup(cli, cdata.ID)
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				bp.cleanup(cli, cdata.ID)
-				return
-			}
+		err = cli.ContainerStart(ctx, cdata.ID, types.ContainerStartOptions{})
+		if err != nil {
+			return err
 		}
-	}()
-
-	err = cli.ContainerStart(ctx, cdata.ID, types.ContainerStartOptions{})
-	if err != nil {
-		return err
 	}
 
 	files := []dockerCopyFile{
-		{"/driverkit/driverkit.sh", driverkitScript},
-		{"/driverkit/kernel.config", string(configDecoded)},
-		{"/driverkit/module-Makefile", bufMakefile.String()},
-		{"/driverkit/fill-driver-config.sh", bufFillDriverConfig.String()},
+		{paths.Replace("/driverkit/driverkit.sh"), driverkitScript},
+		{paths.Replace("/driverkit/kernel.config"), string(configDecoded)},
+		{paths.Replace("/driverkit/module-Makefile"), bufMakefile.String()},
+		{paths.Replace("/driverkit/fill-driver-config.sh"), bufFillDriverConfig.String()},
+	}
+	if len(caBundle) > 0 {
+		files = append(files, dockerCopyFile{paths.Replace(CABundlePath), string(caBundle)})
+	}
+	// the key pair is copied into the container only, never into an image
+	if len(signingKey) > 0 {
+		files = append(files,
+			dockerCopyFile{paths.Replace(moduleSigningKeyPath), string(signingKey)},
+			dockerCopyFile{paths.Replace(moduleSigningCertPath), string(signingCert)},
+		)
+	}
+	if len(entitlementCert) > 0 {
+		files = append(files,
+			dockerCopyFile{paths.Replace(builder.RHELEntitlementCertPath), string(entitlementCert)},
+			dockerCopyFile{paths.Replace(builder.RHELEntitlementKeyPath), string(entitlementKey)},
+		)
+	}
+	if kernelCurlConfig := builder.KernelURLsCurlConfig(b); len(kernelCurlConfig) > 0 {
+		files = append(files, dockerCopyFile{paths.Replace(builder.KernelURLsCurlConfigPath), string(kernelCurlConfig)})
 	}
 
 	var buf bytes.Buffer
@@ -225,10 +396,34 @@ func (bp *DockerBuildProcessor) Start(b *builder.Build) error {
 		return err
 	}
 	// Copy the needed files to the container
-	err = cli.CopyToContainer(ctx, cdata.ID, "/", &buf, types.CopyToContainerOptions{})
+	err = cli.CopyToContainer(ctx, containerID, "/", &buf, types.CopyToContainerOptions{})
 	if err != nil {
 		return err
 	}
+	// Copy the local kernel packages, streaming them since they can be big
+	if len(localKernel) > 0 {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(tarLocalKernel(pw, b.LocalKernelDir, localKernel, paths.Replace(builder.LocalKernelDirectory)))
+		}()
+		err = cli.CopyToContainer(ctx, containerID, "/", pr, types.CopyToContainerOptions{})
+		pr.Close()
+		if err != nil {
+			return err
+		}
+	}
+	// Copy the local driver sources, streaming them too
+	if len(b.LocalDriverDir) > 0 {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(tarLocalDriver(pw, b.LocalDriverDir, paths.Replace(builder.LocalDriverDirectory)))
+		}()
+		err = cli.CopyToContainer(ctx, containerID, "/", pr, types.CopyToContainerOptions{})
+		pr.Close()
+		if err != nil {
+			return err
+		}
+	}
 
 	// Construct environment variable array of string
 	var envs []string
@@ -239,8 +434,18 @@ func (bp *DockerBuildProcessor) Start(b *builder.Build) error {
 			fmt.Sprintf("https_proxy=%s", bp.proxy),
 		)
 	}
+	// the variables of the build are exported by the script too, set them for the tools run outside of it
+	for _, name := range buildEnvNames(b) {
+		envs = append(envs, name+"="+b.Env[name])
+	}
 
-	edata, err := cli.ContainerExecCreate(ctx, cdata.ID, types.ExecConfig{
+	buildCmd := []string{"/bin/bash", paths.Replace("/driverkit/driverkit.sh")}
+	pidFile := paths.Replace("/driverkit/driverkit.pid")
+	if len(bp.reuseContainer) > 0 {
+		// the container outlives the build, it cannot bound its duration nor be stopped to cancel it
+		buildCmd = []string{"/bin/bash", "-c", fmt.Sprintf("echo $$ > %s && exec timeout %d %s", pidFile, bp.timeout, strings.Join(buildCmd, " "))}
+	}
+	edata, err := cli.ContainerExecCreate(ctx, containerID, types.ExecConfig{
 		Privileged:   false,
 		Tty:          false,
 		AttachStdin:  false,
@@ -248,10 +453,7 @@ func (bp *DockerBuildProcessor) Start(b *builder.Build) error {
 		AttachStdout: true,
 		Detach:       true,
 		Env:          envs,
-		Cmd: []string{
-			"/bin/bash",
-			"/driverkit/driverkit.sh",
-		},
+		Cmd:          buildCmd,
 	})
 
 	if err != nil {
@@ -264,22 +466,170 @@ func (bp *DockerBuildProcessor) Start(b *builder.Build) error {
 	}
 	defer hr.Close()
 
-	forwardLogs(hr.Reader)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			builder.Logger(ctx).WithError(ctx.Err()).Info("stopping the build")
+			if len(bp.reuseContainer) > 0 {
+				if _, err := execInContainer(context.Background(), cli, containerID, []string{"/bin/bash", "-c", fmt.Sprintf("kill $(cat %s)", pidFile)}); err != nil {
+					builder.Logger(ctx).WithError(err).WithField("container", bp.reuseContainer).Error("error stopping the build")
+				}
+			} else {
+				stopContainer()
+			}
+			hr.Close()
+		case <-done:
+		}
+	}()
+
+	// the output of the exec is multiplexed, the stdout and stderr frames are forwarded as they come
+	logs, logsWriter := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(logsWriter, logsWriter, hr.Reader)
+		logsWriter.CloseWithError(err)
+	}()
+	forwardLogs(ctx, logs)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-	if len(b.ModuleFilePath) > 0 {
-		if err := copyFromContainer(ctx, cli, cdata.ID, builder.ModuleFullPath, b.ModuleFilePath); err != nil {
-			return err
+	// the failure of the kernel module or of the eBPF probe does not prevent the other artifacts from being copied
+	failures := readArtifactFailures(b, func(name string) ([]byte, error) {
+		return readFromContainer(ctx, cli, containerID, paths.Replace(name))
+	})
+	return copyArtifacts(ctx, b, failures, func(from, to string) error {
+		return copyFromContainer(ctx, cli, containerID, paths.Replace(from), to)
+	})
+}
+
+// pullBuilderImage pulls the builder image for the architecture, unless available already.
+func (bp *DockerBuildProcessor) pullBuilderImage(ctx context.Context, cli *client.Client, image string, arch string) error {
+	bp.pullMu.Lock()
+	defer bp.pullMu.Unlock()
+
+	inspect, _, err := cli.ImageInspectWithRaw(ctx, image)
+	if err == nil && inspect.Architecture == arch {
+		return nil
+	}
+	builder.Logger(ctx).
+		WithField("image", image).
+		WithField("arch", arch).
+		Debug("pulling builder image")
+
+	auth, err := registryAuth(image, bp.registry)
+	if err != nil {
+		return err
+	}
+	pullRes, err := cli.ImagePull(ctx, image, types.ImagePullOptions{Platform: arch, RegistryAuth: auth})
+	if err != nil {
+		return err
+	}
+	defer pullRes.Close()
+	_, err = io.Copy(ioutil.Discard, pullRes)
+	return err
+}
+
+// reusedContainer returns the reused builder container, creating or starting it if needed.
+func (bp *DockerBuildProcessor) reusedContainer(ctx context.Context, cli *client.Client, image string, arch string) (string, error) {
+	name := bp.reuseContainer
+	inspect, err := cli.ContainerInspect(ctx, name)
+	if client.IsErrNotFound(err) {
+		builder.Logger(ctx).WithField("container", name).Info("creating the reused builder container")
+		containerCfg := &container.Config{
+			Cmd:    []string{"/bin/sleep", "infinity"},
+			Image:  image,
+			Labels: map[string]string{reusedContainerLabel: "true"},
 		}
-		logger.WithField("path", b.ModuleFilePath).Info("kernel module available")
+		cdata, err := cli.ContainerCreate(ctx, containerCfg, &container.HostConfig{}, nil, &v1.Platform{Architecture: arch, OS: "linux"}, name)
+		if err != nil {
+			return "", err
+		}
+		return cdata.ID, cli.ContainerStart(ctx, cdata.ID, types.ContainerStartOptions{})
+	}
+	if err != nil {
+		return "", err
+	}
+	if _, ok := inspect.Config.Labels[reusedContainerLabel]; !ok {
+		return "", fmt.Errorf("container %s was not created by driverkit, it cannot be reused", name)
+	}
+	if inspect.Config.Image != image {
+		return "", fmt.Errorf("container %s runs the %s builder image rather than %s, remove it with --cleanup", name, inspect.Config.Image, image)
 	}
+	if !inspect.State.Running {
+		builder.Logger(ctx).WithField("container", name).Info("starting the reused builder container")
+		if err := cli.ContainerStart(ctx, inspect.ID, types.ContainerStartOptions{}); err != nil {
+			return "", err
+		}
+	}
+	return inspect.ID, nil
+}
 
-	if len(b.ProbeFilePath) > 0 {
-		if err := copyFromContainer(ctx, cli, cdata.ID, builder.ProbeFullPath, b.ProbeFilePath); err != nil {
-			return err
+// lockReusedContainer makes sure no other build is running into the reused container, returning the function releasing it.
+func lockReusedContainer(ctx context.Context, cli *client.Client, id string, name string) (func(), error) {
+	lock := reusedContainerWorkDirectory + ".lock"
+	code, err := execInContainer(ctx, cli, id, []string{"mkdir", lock})
+	if err != nil {
+		return nil, err
+	}
+	if code != 0 {
+		return nil, fmt.Errorf("container %s is already running another build, builds against a reused container cannot run concurrently (remove %s from it if no build is running)", name, lock)
+	}
+	return func() {
+		if _, err := execInContainer(context.Background(), cli, id, []string{"rmdir", lock}); err != nil {
+			builder.Logger(ctx).WithError(err).WithField("container", name).Error("error releasing the reused builder container")
 		}
-		logger.WithField("path", b.ProbeFilePath).Info("eBPF probe available")
+	}, nil
+}
+
+// execInContainer runs the command into the container, discarding its output, and returns its exit code.
+func execInContainer(ctx context.Context, cli *client.Client, id string, cmd []string) (int, error) {
+	edata, err := cli.ContainerExecCreate(ctx, id, types.ExecConfig{
+		AttachStderr: true,
+		AttachStdout: true,
+		Cmd:          cmd,
+	})
+	if err != nil {
+		return 0, err
+	}
+	hr, err := cli.ContainerExecAttach(ctx, edata.ID, types.ExecStartCheck{})
+	if err != nil {
+		return 0, err
 	}
+	defer hr.Close()
+	if _, err := io.Copy(ioutil.Discard, hr.Reader); err != nil {
+		return 0, err
+	}
+	inspect, err := cli.ContainerExecInspect(ctx, edata.ID)
+	if err != nil {
+		return 0, err
+	}
+	return inspect.ExitCode, nil
+}
 
+// Cleanup removes the reused builder container.
+func (bp *DockerBuildProcessor) Cleanup() error {
+	cli, err := dockerClient(bp.host)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	inspect, err := cli.ContainerInspect(ctx, bp.reuseContainer)
+	if client.IsErrNotFound(err) {
+		logger.WithField("container", bp.reuseContainer).Info("no reused builder container to remove")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, ok := inspect.Config.Labels[reusedContainerLabel]; !ok {
+		return fmt.Errorf("container %s was not created by driverkit, refusing to remove it", bp.reuseContainer)
+	}
+	if err := cli.ContainerRemove(ctx, inspect.ID, types.ContainerRemoveOptions{Force: true}); err != nil {
+		return err
+	}
+	logger.WithField("container", bp.reuseContainer).Info("reused builder container removed")
 	return nil
 }
 
@@ -299,14 +649,29 @@ func copyFromContainer(ctx context.Context, cli *client.Client, ID, from, to str
 	return archive.CopyTo(preArchive, srcInfo, to)
 }
 
-func (bp *DockerBuildProcessor) cleanup(cli *client.Client, ID string) {
-	if !bp.clean {
-		bp.clean = true
-		logger.Debug("context canceled")
-		duration := time.Second
-		if err := cli.ContainerStop(context.Background(), ID, &duration); err != nil && !client.IsErrNotFound(err) {
-			logger.WithError(err).WithField("container_id", ID).Error("error stopping container")
-		}
+// readFromContainer reads the file of the container.
+func readFromContainer(ctx context.Context, cli *client.Client, ID, from string) ([]byte, error) {
+	content, _, err := cli.CopyFromContainer(ctx, ID, from)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+	tr := tar.NewReader(content)
+	if _, err := tr.Next(); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(tr)
+}
+
+// containerStopGracePeriod is the time the builder container is given to stop once the build is done or canceled, before being killed.
+const containerStopGracePeriod = time.Second
+
+// cleanup stops the builder container, which is removed once stopped.
+func (bp *DockerBuildProcessor) cleanup(log logger.FieldLogger, cli *client.Client, ID string) {
+	log.Debug("context canceled")
+	duration := containerStopGracePeriod
+	if err := cli.ContainerStop(context.Background(), ID, &duration); err != nil && !client.IsErrNotFound(err) {
+		log.WithError(err).WithField("container_id", ID).Error("error stopping container")
 	}
 }
 
@@ -334,19 +699,40 @@ func tarWriterFiles(buf io.Writer, files []dockerCopyFile) error {
 	return nil
 }
 
-func forwardLogs(logPipe io.Reader) {
+// validateCcacheDir fails when the ccache directory of the build cannot be bind-mounted into its container:
+// it must be a directory of the docker host and the container cannot be a reused one, already running.
+func (bp *DockerBuildProcessor) validateCcacheDir(b *builder.Build) error {
+	if len(b.CcacheDir) == 0 {
+		return nil
+	}
+	if strings.HasPrefix(b.CcacheDir, builder.CcachePVCPrefix) {
+		return fmt.Errorf("the ccache directory %s is a persistent volume claim, only the kubernetes processor mounts them", b.CcacheDir)
+	}
+	if len(bp.reuseContainer) > 0 {
+		return fmt.Errorf("the ccache directory cannot be mounted into the reused container %s", bp.reuseContainer)
+	}
+	return nil
+}
+
+// forwardLogs forwards the output of the build script to the logger, and to the build log of the context if any.
+func forwardLogs(ctx context.Context, logPipe io.Reader) {
+	buildLog := BuildLog(ctx)
 	lineReader := bufio.NewReader(logPipe)
 	for {
 		line, err := lineReader.ReadBytes('\n')
 		if len(line) > 0 {
-			logger.Debugf("%s", line)
+			builder.Logger(ctx).Debugf("%s", line)
+			if buildLog != nil {
+				buildLog.Write(line)
+			}
 		}
 		if err == io.EOF {
-			logger.WithError(err).Debug("log pipe close")
+			builder.Logger(ctx).WithError(err).Debug("log pipe close")
 			return
 		}
 		if err != nil {
-			logger.WithError(err).Error("log pipe error")
+			builder.Logger(ctx).WithError(err).Error("log pipe error")
+			return
 		}
 	}
 }
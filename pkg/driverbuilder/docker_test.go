@@ -0,0 +1,34 @@
+package driverbuilder
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestDockerClient(t *testing.T) {
+	withEnv(t, "DOCKER_HOST", "unix:///run/user/1000/docker.sock")
+	withEnv(t, "DOCKER_CERT_PATH", "")
+
+	cli, err := dockerClient(DockerHost{})
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if got := cli.DaemonHost(); got != "unix:///run/user/1000/docker.sock" {
+		t.Errorf("Got: [ '%s' ] / Want: [ the DOCKER_HOST one ]", got)
+	}
+
+	cli, err = dockerClient(DockerHost{Host: "tcp://build-host:2375"})
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if got := cli.DaemonHost(); got != "tcp://build-host:2375" {
+		t.Errorf("Got: [ '%s' ] / Want: [ 'tcp://build-host:2375' ]", got)
+	}
+
+	certPath := t.TempDir()
+	withEnv(t, "DOCKER_CERT_PATH", certPath)
+	_, err = dockerClient(DockerHost{Host: "tcp://build-host:2376", TLSVerify: true})
+	if err == nil {
+		t.Fatalf("Expecting an error for the missing certificates of %s", filepath.Join(certPath, "ca.pem"))
+	}
+}
@@ -0,0 +1,110 @@
+package driverbuilder
+
+import (
+	"context"
+	"io"
+	"runtime"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+)
+
+// DryRunBuildProcessorName is a constant containing the dry-run name.
+const DryRunBuildProcessorName = "dry-run"
+
+// DryRunBuildProcessor validates the builds and resolves the kernel packages they would use, without building anything.
+// The processors being DryRunners also write the scripts the builds would run.
+type DryRunBuildProcessor struct {
+	processorOptions
+	processor BuildProcessor
+	timeout   int
+	proxy     string
+	caCert    string
+	scriptOut io.Writer
+}
+
+// NewDryRunBuildProcessor constructs a DryRunBuildProcessor running the builds of the processor as dry runs,
+// the scripts are written to scriptOut.
+func NewDryRunBuildProcessor(processor BuildProcessor, timeout int, proxy string, caCert string, scriptOut io.Writer, opts ...ProcessorOption) *DryRunBuildProcessor {
+	return &DryRunBuildProcessor{
+		processorOptions: newProcessorOptions(opts),
+		processor:        processor,
+		timeout:          timeout,
+		proxy:            proxy,
+		caCert:           caCert,
+		scriptOut:        scriptOut,
+	}
+}
+
+func (bp *DryRunBuildProcessor) String() string {
+	return DryRunBuildProcessorName
+}
+
+// Start validates the build and resolves its kernel URLs, the report has no artifacts.
+func (bp *DryRunBuildProcessor) Start(ctx context.Context, b *builder.Build) (*BuildReport, error) {
+	var report *BuildReport
+	var err error
+	// the wrapped processor logs with the fields of the build as well
+	ctx, _ = withBuildLogger(bp.withLogger(ctx), b)
+	if r, ok := bp.processor.(DryRunner); ok {
+		report, err = r.DryRun(ctx, b, bp.scriptOut)
+	} else {
+		var reporter *buildReporter
+		ctx, reporter = startReport(ctx, b, builderImageOf(b))
+		report, err = reporter.completeWithoutArtifacts(bp.start(ctx, b))
+	}
+	if err != nil {
+		return report, err
+	}
+	for _, u := range report.KernelURLs {
+		builder.Logger(ctx).WithField("url", u).Info("kernel package resolved")
+	}
+	builder.Logger(ctx).Info("the build is valid, nothing was built")
+	return report, nil
+}
+
+// start validates the build, the script is generated for the kernel URLs it resolves, then thrown away.
+func (bp *DryRunBuildProcessor) start(ctx context.Context, b *builder.Build) error {
+	v, err := builder.Factory(b.TargetType)
+	if err != nil {
+		return err
+	}
+	caBundle, err := readCABundle(bp.caCert)
+	if err != nil {
+		return err
+	}
+	entitlementCert, entitlementKey, err := readRHELEntitlement(b)
+	if err != nil {
+		return err
+	}
+	b, _, err = withLocalKernel(b)
+	if err != nil {
+		return err
+	}
+	c := builder.Config{
+		DriverName:          b.ModuleDriverName,
+		DeviceName:          b.ModuleDeviceName,
+		DownloadBaseURL:     "https://github.com/falcosecurity/libs/archive",
+		ProxyURL:            bp.proxy,
+		CABundle:            caBundle,
+		RHELEntitlementCert: entitlementCert,
+		RHELEntitlementKey:  entitlementKey,
+		Build:               b,
+		BuilderArchitecture: builderArchitectureOf(b, runtime.GOARCH),
+	}
+
+	kr := c.Build.KernelReleaseFromBuildConfig()
+	if err := builder.Validate(v, c, kr); err != nil {
+		return err
+	}
+
+	ctx, err = builder.WithHTTPClient(ctx, c)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(bp.timeout)*time.Second)
+	defer cancel()
+
+	_, err = builder.Script(ctx, v, c, kr)
+	return err
+}
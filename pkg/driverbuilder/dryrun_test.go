@@ -0,0 +1,107 @@
+package driverbuilder
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	logger "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestDryRunLocalKernel(t *testing.T) {
+	dir := t.TempDir()
+	kernelDir := filepath.Join(dir, "kernel")
+	if err := os.Mkdir(kernelDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"linux-headers-5.15.0-25-generic.deb", "linux-headers-5.15.0-25.deb"} {
+		if err := ioutil.WriteFile(filepath.Join(kernelDir, name), []byte("headers"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b := &builder.Build{
+		TargetType:     builder.TargetTypeUbuntu,
+		Architecture:   "amd64",
+		KernelRelease:  "5.15.0-25-generic",
+		KernelVersion:  "25",
+		DriverVersion:  "master",
+		ModuleFilePath: filepath.Join(dir, "falco.ko"),
+		LocalKernelDir: kernelDir,
+		SkipChecksum:   true,
+	}
+
+	report, err := NewDryRunBuildProcessor(NewLocalBuildProcessor(60, "", "", nil, false), 60, "", "", ioutil.Discard).Start(context.Background(), b)
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if !report.Success || len(report.Artifacts) != 0 {
+		t.Errorf("Got: [ %+v ] / Want: [ a successful report without artifacts ]", report)
+	}
+	if _, err := os.Stat(b.ModuleFilePath); !os.IsNotExist(err) {
+		t.Errorf("Got: [ %v ] / Want: [ nothing built ]", err)
+	}
+}
+
+func TestDryRunInvalidBuild(t *testing.T) {
+	b := &builder.Build{
+		TargetType:     builder.TargetTypeVanilla,
+		Architecture:   "amd64",
+		KernelRelease:  "5.10.0",
+		KernelVersion:  "1",
+		ModuleFilePath: filepath.Join(t.TempDir(), "falco.ko"),
+	}
+
+	report, err := NewDryRunBuildProcessor(NewLocalBuildProcessor(60, "", "", nil, false), 60, "", "", ioutil.Discard).Start(context.Background(), b)
+	if err == nil || report.Success || report.Error != err.Error() {
+		t.Errorf("Got: [ %+v, %v ] / Want: [ the build failing for the missing kernel config data ]", report, err)
+	}
+}
+
+func TestDryRunLogger(t *testing.T) {
+	dir := t.TempDir()
+	kernelDir := filepath.Join(dir, "kernel")
+	if err := os.Mkdir(kernelDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"linux-headers-5.15.0-25-generic.deb", "linux-headers-5.15.0-25.deb"} {
+		if err := ioutil.WriteFile(filepath.Join(kernelDir, name), []byte("headers"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b := &builder.Build{
+		TargetType:     builder.TargetTypeUbuntu,
+		Architecture:   "amd64",
+		KernelRelease:  "5.15.0-25-generic",
+		KernelVersion:  "25",
+		DriverVersion:  "master",
+		ModuleFilePath: filepath.Join(dir, "falco.ko"),
+		LocalKernelDir: kernelDir,
+		SkipChecksum:   true,
+	}
+	l, hook := test.NewNullLogger()
+	l.SetLevel(logger.DebugLevel)
+
+	bp := NewDryRunBuildProcessor(NewLocalBuildProcessor(60, "", "", nil, false), 60, "", "", ioutil.Discard, WithLogger(l))
+	report, err := bp.Start(WithBuildID(context.Background(), "build-id"), b)
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if report.ID != "build-id" {
+		t.Errorf("Report ID | Got: [ %s ] / Want: [ %s ]", report.ID, "build-id")
+	}
+	if len(hook.AllEntries()) == 0 {
+		t.Fatal("Got: [ no log lines ] / Want: [ the log lines of the build ]")
+	}
+	want := logger.Fields{"build": "build-id", "target": b.TargetType, "arch": "amd64", "kernelrelease": "5.15.0-25-generic"}
+	for _, entry := range hook.AllEntries() {
+		for k, v := range want {
+			if entry.Data[k] != v {
+				t.Errorf("Field %s of %q | Got: [ %v ] / Want: [ %v ]", k, entry.Message, entry.Data[k], v)
+			}
+		}
+	}
+}
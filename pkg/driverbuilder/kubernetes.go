@@ -1,24 +1,34 @@
 package driverbuilder
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"github.com/falcosecurity/driverkit/pkg/signals"
 	"io"
+	"io/ioutil"
 	"os"
+	"path"
+	"strings"
+	"sync"
 	"time"
 
 	logger "github.com/sirupsen/logrus"
 
 	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	typedbatchv1 "k8s.io/client-go/kubernetes/typed/batch/v1"
 	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	restclient "k8s.io/client-go/rest"
 	"k8s.io/kubectl/pkg/cmd/exec"
@@ -29,45 +39,144 @@ const KubernetesBuildProcessorName = "kubernetes"
 
 const falcoBuilderUIDLabel = "org.falcosecurity/driverkit-uid"
 
+// kubernetesArchLabel is the well known label the nodes are selected by architecture with.
+const kubernetesArchLabel = "kubernetes.io/arch"
+
+// buildRequiredCapabilities are the capabilities the build script needs to install the kernel packages.
+var buildRequiredCapabilities = []corev1.Capability{"CHOWN", "DAC_OVERRIDE", "FOWNER"}
+
+// ArtifactTransferExec, ArtifactTransferPVC and ArtifactTransferS3 are the ways the kernel module is transferred out of the build pod:
+// streamed through exec, the default, or uploaded by the build pod to a persistent volume claim or to S3 once built, where the processor reads it from.
+const (
+	ArtifactTransferExec = "exec"
+	ArtifactTransferPVC  = "pvc"
+	ArtifactTransferS3   = "s3"
+)
+
+// ErrArtifactTransfer is wrapped by the errors of the kubernetes builds that succeeded, but whose kernel module could not be transferred out of the build pod.
+var ErrArtifactTransfer = errors.New("artifact transfer failed")
+
+// failedPodLogLines is the number of lines of the logs of a failed build pod reported into the error.
+const failedPodLogLines = 20
+
+// KubernetesPodOptions are the resources, the placement, the image pulling and the lifecycle of the build pod.
+// The RegistryConfig docker config, e.g. a mounted secret when running in-cluster,
+// is turned into a pull secret owned by the build pod.
+// The logs of the pod are forwarded at debug level, or at info level when VerboseLogs is set.
+// The pod is deleted once done, unless it failed and KeepFailedPod is set: in that case it keeps running
+// until the timeout elapses to let users exec into it.
+// The security contexts must leave the build script running as root, with the capabilities the packages installation needs.
+// The pod is run by a Job, which retries it BackoffLimit times, e.g. when evicted, and which the cluster deletes
+// TTLAfterFinished after finishing when driverkit could not, or as a bare pod when BarePod is set.
+// The build jobs older than OrphanJobsAge, left over by the previous runs, are deleted before the first build, unless it is zero.
+// The kernel module is transferred out of the pod as ArtifactTransfer tells, through the ArtifactPVC claim
+// or under the ArtifactS3URL s3://bucket[/prefix] URL when not through exec.
+type KubernetesPodOptions struct {
+	Resources          corev1.ResourceRequirements
+	NodeSelector       map[string]string
+	Tolerations        []corev1.Toleration
+	Affinity           *corev1.Affinity
+	PriorityClassName  string
+	ImagePullSecrets   []string
+	ImagePullPolicy    corev1.PullPolicy
+	RegistryConfig     string
+	VerboseLogs        bool
+	KeepFailedPod      bool
+	ServiceAccount     string
+	PodSecurityContext *corev1.PodSecurityContext
+	SecurityContext    *corev1.SecurityContext
+	BarePod            bool
+	BackoffLimit       int32
+	TTLAfterFinished   time.Duration
+	OrphanJobsAge      time.Duration
+	ArtifactTransfer   string
+	ArtifactPVC        string
+	ArtifactS3URL      string
+}
+
+// DefaultKubernetesPodOptions returns the options the build pod gets when not customized.
+func DefaultKubernetesPodOptions() KubernetesPodOptions {
+	return KubernetesPodOptions{
+		ImagePullPolicy:  corev1.PullIfNotPresent,
+		BackoffLimit:     1,
+		TTLAfterFinished: 10 * time.Minute,
+		OrphanJobsAge:    24 * time.Hour,
+		ArtifactTransfer: ArtifactTransferExec,
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("1000m"),
+				corev1.ResourceMemory: resource.MustParse("2000Mi"),
+			},
+			Limits: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("4"),
+				corev1.ResourceMemory: resource.MustParse("4G"),
+			},
+		},
+	}
+}
+
 type KubernetesBuildProcessor struct {
-	coreV1Client v1.CoreV1Interface
-	clientConfig *restclient.Config
-	namespace    string
-	timeout      int
-	proxy        string
+	processorOptions
+	coreV1Client  v1.CoreV1Interface
+	batchV1Client typedbatchv1.BatchV1Interface
+	clientConfig  *restclient.Config
+	namespace     string
+	timeout       int
+	proxy         string
+	caCert        string
+	podOptions    KubernetesPodOptions
+	orphans       sync.Once
 }
 
 // NewKubernetesBuildProcessor constructs a KubernetesBuildProcessor
 // starting from a kubernetes.Clientset. bufferSize represents the length of the
 // channel we use to do the builds. A bigger bufferSize will mean that we can save more Builds
 // for processing, however setting this to a big value will have impacts
-func NewKubernetesBuildProcessor(corev1Client v1.CoreV1Interface, clientConfig *restclient.Config, namespace string, timeout int, proxy string) *KubernetesBuildProcessor {
-	return &KubernetesBuildProcessor{
-		coreV1Client: corev1Client,
-		clientConfig: clientConfig,
-		namespace:    namespace,
-		timeout:      timeout,
-		proxy:        proxy,
+func NewKubernetesBuildProcessor(client kubernetes.Interface, clientConfig *restclient.Config, namespace string, timeout int, proxy string, caCert string, podOptions KubernetesPodOptions, opts ...ProcessorOption) *KubernetesBuildProcessor {
+	bp := &KubernetesBuildProcessor{
+		processorOptions: newProcessorOptions(opts),
+		clientConfig:     clientConfig,
+		namespace:        namespace,
+		timeout:          timeout,
+		proxy:            proxy,
+		caCert:           caCert,
+		podOptions:       podOptions,
+	}
+	if client != nil {
+		bp.coreV1Client = client.CoreV1()
+		bp.batchV1Client = client.BatchV1()
 	}
+	return bp
 }
 
 func (bp *KubernetesBuildProcessor) String() string {
 	return KubernetesBuildProcessorName
 }
 
-func (bp *KubernetesBuildProcessor) Start(b *builder.Build) error {
-	logger.Debug("doing a new kubernetes build")
-	return bp.buildModule(b)
+func (bp *KubernetesBuildProcessor) Start(ctx context.Context, b *builder.Build) (*BuildReport, error) {
+	ctx, reporter := startReport(bp.withLogger(ctx), b, builderImageOf(b))
+	builder.Logger(ctx).Debug("doing a new kubernetes build")
+	err := bp.buildModule(ctx, b, nil)
+	return reporter.complete(ctx, b, err)
 }
 
-func (bp *KubernetesBuildProcessor) buildModule(build *builder.Build) error {
-	deadline := int64(bp.timeout)
+// DryRun implements DryRunner, the build stops right before creating anything in the cluster.
+func (bp *KubernetesBuildProcessor) DryRun(ctx context.Context, b *builder.Build, w io.Writer) (*BuildReport, error) {
+	ctx, reporter := startReport(bp.withLogger(ctx), b, builderImageOf(b))
+	err := bp.buildModule(ctx, b, w)
+	return reporter.completeWithoutArtifacts(err)
+}
+
+// buildModule runs the build into a pod, of a job unless bare, which is deleted when the context is canceled or the timeout expires.
+// When the script writer is given, the script is written to it instead, without talking to the cluster.
+func (bp *KubernetesBuildProcessor) buildModule(ctx context.Context, build *builder.Build, scriptOut io.Writer) error {
 	namespace := bp.namespace
 	uid := uuid.NewUUID()
 	name := fmt.Sprintf("driverkit-%s", string(uid))
 
 	podClient := bp.coreV1Client.Pods(namespace)
 	configClient := bp.coreV1Client.ConfigMaps(namespace)
+	secretClient := bp.coreV1Client.Secrets(namespace)
 
 	// create a builder based on the chosen build type
 	v, err := builder.Factory(build.TargetType)
@@ -75,27 +184,106 @@ func (bp *KubernetesBuildProcessor) buildModule(build *builder.Build) error {
 		return err
 	}
 
+	if err := checkKubernetesOutputs(build); err != nil {
+		return err
+	}
+
+	if err := checkArtifactTransfer(bp.podOptions, build); err != nil {
+		return err
+	}
+
+	if err := checkSecurityContext(bp.podOptions); err != nil {
+		if len(build.CustomBuilderImage) == 0 {
+			return err
+		}
+		// custom builder images may be prepared to build otherwise
+		builder.Logger(ctx).WithError(err).Warn("the security context may not be compatible with the build script")
+	}
+
+	caBundle, err := readCABundle(bp.caCert)
+	if err != nil {
+		return err
+	}
+	entitlementCert, entitlementKey, err := readRHELEntitlement(build)
+	if err != nil {
+		return err
+	}
+	signingKey, signingCert, err := readModuleSigningKey(build)
+	if err != nil {
+		return err
+	}
+	build, localKernel, err := withLocalKernel(build)
+	if err != nil {
+		return err
+	}
+	if err := checkLocalDriver(build); err != nil {
+		return err
+	}
 	c := builder.Config{
-		DriverName:      build.ModuleDriverName,
-		DeviceName:      build.ModuleDeviceName,
-		DownloadBaseURL: "https://github.com/falcosecurity/libs/archive", // TODO: make this configurable
-		Build:           build,
+		DriverName:          build.ModuleDriverName,
+		DeviceName:          build.ModuleDeviceName,
+		DownloadBaseURL:     "https://github.com/falcosecurity/libs/archive", // TODO: make this configurable
+		ProxyURL:            bp.proxy,
+		CABundle:            caBundle,
+		RHELEntitlementCert: entitlementCert,
+		RHELEntitlementKey:  entitlementKey,
+		Build:               build,
+		// the build pod runs on the nodes of the architecture of the build, unless cross compiled
+		BuilderArchitecture: builderArchitectureOf(build, build.Architecture),
 	}
 
-	// generate the build script from the builder
+	// fail fast when the target cannot do the build, before downloading anything
 	kr := c.Build.KernelReleaseFromBuildConfig()
-	res, err := v.Script(c, kr)
+	if err := builder.Validate(v, c, kr); err != nil {
+		return err
+	}
+
+	ctx, err = builder.WithHTTPClient(ctx, c)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(bp.timeout)*time.Second)
+	defer cancel()
+
+	// generate the build script from the builder
+	res, err := builder.Script(ctx, v, c, kr)
 	if err != nil {
 		return err
 	}
+	if len(build.CcacheDir) > 0 {
+		res = withCcache(res)
+	}
+	if len(caBundle) > 0 {
+		res = withTrustedCABundle(res)
+	}
+	if len(localKernel) > 0 {
+		res = withLocalKernelWait(res)
+	}
+	if len(build.LocalDriverDir) > 0 {
+		res = withLocalDriverWait(res)
+	}
+	moduleDownloader := waitForModuleAndCat
+	if len(signingKey) > 0 {
+		// the module is downloaded once signed
+		res = withModuleSigning(res)
+		moduleDownloader = waitForSignedModuleAndCat
+	}
 
-	// Append a script to the entrypoint to wait
-	// for the module to be ready before exiting PID 1
-	res = fmt.Sprintf("%s\n%s", res, waitForModuleScript)
+	// the kernel module is the only artifact, its failure fails the pod before waiting for it
+	res = withArtifactFailures(res)
 
-	buildCmd := []string{
-		"/bin/bash",
-		"/driverkit/driverkit.sh",
+	switch bp.podOptions.ArtifactTransfer {
+	case ArtifactTransferPVC:
+		res = fmt.Sprintf("%s\n%s", res, transferModuleToPVCScript(path.Join(artifactsDirectory, name)))
+	case ArtifactTransferS3:
+		res = fmt.Sprintf("%s\n%s", res, transferModuleToS3Script)
+	default:
+		// Append a script to the entrypoint to wait
+		// for the module to be ready before exiting PID 1
+		res = fmt.Sprintf("%s\n%s", res, waitForModuleScript)
+	}
+	if scriptOut != nil {
+		return writeScript(scriptOut, res)
 	}
 
 	commonMeta := metav1.ObjectMeta{
@@ -132,9 +320,12 @@ func (bp *KubernetesBuildProcessor) buildModule(build *builder.Build) error {
 			"kernel.config":         string(configDecoded),
 			"module-Makefile":       bufMakefile.String(),
 			"fill-driver-config.sh": bufFillDriverConfig.String(),
-			"module-downloader.sh":  waitForModuleAndCat,
+			"module-downloader.sh":  moduleDownloader,
 		},
 	}
+	if len(caBundle) > 0 {
+		cm.Data[path.Base(CABundlePath)] = string(caBundle)
+	}
 	// Construct environment variable array of corev1.EnvVar
 	var envs []corev1.EnvVar
 	// Add http_porxy and https_proxy environment variable
@@ -150,35 +341,318 @@ func (bp *KubernetesBuildProcessor) buildModule(build *builder.Build) error {
 			},
 		)
 	}
+	// the variables of the build are exported by the script too, set them for the tools run outside of it
+	for _, name := range buildEnvNames(build) {
+		envs = append(envs, corev1.EnvVar{Name: name, Value: build.Env[name]})
+	}
+
+	var retrieve moduleRetriever
+	if bp.podOptions.ArtifactTransfer == ArtifactTransferS3 {
+		transfer, err := newS3Transfer(build.S3Endpoint, bp.podOptions.ArtifactS3URL, name)
+		if err != nil {
+			return err
+		}
+		// the build pod may run until the timeout, the download follows
+		url, err := transfer.presign(time.Duration(bp.timeout) * time.Second)
+		if err != nil {
+			return err
+		}
+		envs = append(envs, corev1.EnvVar{Name: artifactURLEnv, Value: url})
+		retrieve = transfer.retrieve
+	}
+
+	pod := bp.buildPod(commonMeta, builderImageOf(build), envs, builderArchitectureOf(build, build.Architecture), len(localKernel) > 0, len(build.LocalDriverDir) > 0, build.CcacheDir)
+	if bp.podOptions.ArtifactTransfer == ArtifactTransferPVC {
+		withArtifactPVC(pod, bp.podOptions.ArtifactPVC)
+		retrieve = bp.pvcRetriever(pod)
+	}
+
+	var registrySecret *corev1.Secret
+	if len(bp.podOptions.RegistryConfig) > 0 {
+		dockerConfig, err := ioutil.ReadFile(bp.podOptions.RegistryConfig)
+		if err != nil {
+			return err
+		}
+		registrySecret = &corev1.Secret{
+			ObjectMeta: commonMeta,
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{
+				corev1.DockerConfigJsonKey: dockerConfig,
+			},
+		}
+		pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, corev1.LocalObjectReference{Name: registrySecret.Name})
+	}
+
+	// The key pair does not belong to the config map, it is mounted from a secret of its own
+	var signingSecret *corev1.Secret
+	if len(signingKey) > 0 {
+		signingMeta := commonMeta
+		signingMeta.Name = name + "-signing"
+		signingSecret = &corev1.Secret{
+			ObjectMeta: signingMeta,
+			Type:       corev1.SecretTypeOpaque,
+			Data: map[string][]byte{
+				path.Base(moduleSigningKeyPath):  signingKey,
+				path.Base(moduleSigningCertPath): signingCert,
+			},
+		}
+		pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      "driverkit-signing",
+			MountPath: ModuleSigningDirectory,
+			ReadOnly:  true,
+		})
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: "driverkit-signing",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: signingSecret.Name},
+			},
+		})
+	}
+
+	// so does the entitlement, the build script cannot remove it from the read-only volume
+	var entitlementSecret *corev1.Secret
+	if len(entitlementCert) > 0 {
+		entitlementMeta := commonMeta
+		entitlementMeta.Name = name + "-entitlement"
+		entitlementSecret = &corev1.Secret{
+			ObjectMeta: entitlementMeta,
+			Type:       corev1.SecretTypeOpaque,
+			Data: map[string][]byte{
+				path.Base(builder.RHELEntitlementCertPath): entitlementCert,
+				path.Base(builder.RHELEntitlementKeyPath):  entitlementKey,
+			},
+		}
+		pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      "driverkit-entitlement",
+			MountPath: builder.RHELEntitlementDirectory,
+			ReadOnly:  true,
+		})
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: "driverkit-entitlement",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: entitlementSecret.Name},
+			},
+		})
+	}
+
+	// and the credentials of the kernel URLs
+	var kernelURLsSecret *corev1.Secret
+	if kernelCurlConfig := builder.KernelURLsCurlConfig(build); len(kernelCurlConfig) > 0 {
+		kernelURLsMeta := commonMeta
+		kernelURLsMeta.Name = name + "-kernelurls-auth"
+		kernelURLsSecret = &corev1.Secret{
+			ObjectMeta: kernelURLsMeta,
+			Type:       corev1.SecretTypeOpaque,
+			Data: map[string][]byte{
+				path.Base(builder.KernelURLsCurlConfigPath): kernelCurlConfig,
+			},
+		}
+		pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      "driverkit-kernelurls-auth",
+			MountPath: builder.KernelURLsAuthDirectory,
+			ReadOnly:  true,
+		})
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: "driverkit-kernelurls-auth",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: kernelURLsSecret.Name},
+			},
+		})
+	}
+
+	bp.deleteOrphanJobs(ctx, namespace)
+	_, err = configClient.Create(ctx, cm, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	if registrySecret != nil {
+		_, err = secretClient.Create(ctx, registrySecret, metav1.CreateOptions{})
+		if err != nil {
+			return err
+		}
+	}
+	if signingSecret != nil {
+		_, err = secretClient.Create(ctx, signingSecret, metav1.CreateOptions{})
+		if err != nil {
+			return err
+		}
+		// the key pair must not outlive the build, kept failed pods included
+		defer func() {
+			if err := secretClient.Delete(context.Background(), signingSecret.Name, metav1.DeleteOptions{}); err != nil {
+				builder.Logger(ctx).WithError(err).WithField("secret", signingSecret.Name).Warn("unable to delete the module signing secret")
+			}
+		}()
+	}
+	if entitlementSecret != nil {
+		_, err = secretClient.Create(ctx, entitlementSecret, metav1.CreateOptions{})
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := secretClient.Delete(context.Background(), entitlementSecret.Name, metav1.DeleteOptions{}); err != nil {
+				builder.Logger(ctx).WithError(err).WithField("secret", entitlementSecret.Name).Warn("unable to delete the RHEL entitlement secret")
+			}
+		}()
+	}
+	if kernelURLsSecret != nil {
+		_, err = secretClient.Create(ctx, kernelURLsSecret, metav1.CreateOptions{})
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := secretClient.Delete(context.Background(), kernelURLsSecret.Name, metav1.DeleteOptions{}); err != nil {
+				builder.Logger(ctx).WithError(err).WithField("secret", kernelURLsSecret.Name).Warn("unable to delete the kernel urls auth secret")
+			}
+		}()
+	}
+	var owner metav1.OwnerReference
+	if bp.podOptions.BarePod {
+		created, err := podClient.Create(ctx, pod, metav1.CreateOptions{})
+		if err != nil {
+			return err
+		}
+		owner = metav1.OwnerReference{APIVersion: "v1", Kind: "Pod", Name: created.Name, UID: created.UID}
+	} else {
+		created, err := bp.batchV1Client.Jobs(namespace).Create(ctx, bp.buildJob(pod), metav1.CreateOptions{})
+		if err != nil {
+			return err
+		}
+		owner = metav1.OwnerReference{APIVersion: "batch/v1", Kind: "Job", Name: created.Name, UID: created.UID}
+		// the config map goes along with the job when the cluster deletes it past its TTL
+		cm.OwnerReferences = []metav1.OwnerReference{owner}
+		_, err = configClient.Update(ctx, cm, metav1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+	}
+	if registrySecret != nil {
+		// The credentials must not outlive the build, have them deleted along with the pod
+		registrySecret.OwnerReferences = []metav1.OwnerReference{owner}
+		_, err = secretClient.Update(ctx, registrySecret, metav1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+	}
+
+	out, err := os.Create(build.ModuleFilePath)
+
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	err = bp.copyModuleFromPodWithUID(ctx, out, namespace, string(uid), build.LocalKernelDir, localKernel, build.LocalDriverDir, retrieve)
+	// canceled builds are not failed ones, they are never kept, nor the ones holding the key pair signing the module or the entitlement
+	if err != nil && ctx.Err() == nil && bp.podOptions.KeepFailedPod && signingSecret == nil && entitlementSecret == nil {
+		kind := "job"
+		if bp.podOptions.BarePod {
+			kind = "pod"
+		}
+		builder.Logger(ctx).WithField(kind, name).WithField("namespace", namespace).Info("keeping the failed build pod, delete it once done")
+		return err
+	}
+	bp.cleanup(builder.Logger(ctx), namespace, name)
+	return err
+}
+
+// cleanup deletes the build job, or the bare build pod, and its config map, the pull secret is owned by either.
+func (bp *KubernetesBuildProcessor) cleanup(log logger.FieldLogger, namespace string, name string) {
+	// the build context may be cancelled already
+	ctx := context.Background()
+	if bp.podOptions.BarePod {
+		if err := bp.coreV1Client.Pods(namespace).Delete(ctx, name, metav1.DeleteOptions{}); err != nil {
+			log.WithError(err).WithField("pod", name).Warn("unable to delete the build pod")
+		}
+	} else if err := bp.deleteJob(ctx, namespace, name); err != nil {
+		log.WithError(err).WithField("job", name).Warn("unable to delete the build job")
+	}
+	if err := bp.coreV1Client.ConfigMaps(namespace).Delete(ctx, name, metav1.DeleteOptions{}); err != nil {
+		log.WithError(err).WithField("configmap", name).Warn("unable to delete the build config map")
+	}
+}
+
+// deleteJob deletes the build job along with its pods.
+func (bp *KubernetesBuildProcessor) deleteJob(ctx context.Context, namespace string, name string) error {
+	propagation := metav1.DeletePropagationBackground
+	return bp.batchV1Client.Jobs(namespace).Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+}
+
+// deleteOrphanJobs deletes, once, the build jobs older than OrphanJobsAge, left over by the previous runs killed before cleaning up.
+func (bp *KubernetesBuildProcessor) deleteOrphanJobs(ctx context.Context, namespace string) {
+	if bp.podOptions.BarePod || bp.podOptions.OrphanJobsAge <= 0 {
+		return
+	}
+	bp.orphans.Do(func() {
+		log := builder.Logger(ctx)
+		jobs, err := bp.batchV1Client.Jobs(namespace).List(ctx, metav1.ListOptions{LabelSelector: falcoBuilderUIDLabel})
+		if err != nil {
+			log.WithError(err).Warn("unable to list the build jobs left over")
+			return
+		}
+		for _, j := range jobs.Items {
+			if time.Since(j.CreationTimestamp.Time) < bp.podOptions.OrphanJobsAge {
+				continue
+			}
+			log.WithField("job", j.Name).Info("deleting the build job left over")
+			if err := bp.deleteJob(ctx, namespace, j.Name); err != nil && !apierrors.IsNotFound(err) {
+				log.WithError(err).WithField("job", j.Name).Warn("unable to delete the build job left over")
+			}
+		}
+	})
+}
+
+// buildJob returns the job running the build pod, bounded by the timeout whatever the retries.
+func (bp *KubernetesBuildProcessor) buildJob(pod *corev1.Pod) *batchv1.Job {
+	spec := pod.Spec.DeepCopy()
+	spec.ActiveDeadlineSeconds = nil
+	job := &batchv1.Job{
+		ObjectMeta: pod.ObjectMeta,
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          pointer.Int32Ptr(bp.podOptions.BackoffLimit),
+			ActiveDeadlineSeconds: pointer.Int64Ptr(int64(bp.timeout)),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: pod.Labels},
+				Spec:       *spec,
+			},
+		},
+	}
+	if bp.podOptions.TTLAfterFinished > 0 {
+		job.Spec.TTLSecondsAfterFinished = pointer.Int32Ptr(int32(bp.podOptions.TTLAfterFinished / time.Second))
+	}
+	return job
+}
 
-	builderImage := BuilderBaseImage
-	if len(build.CustomBuilderImage) > 0 {
-		builderImage = build.CustomBuilderImage
+// buildPod returns the pod running the build script of the config map named as the pod.
+// The ccache directory, if any, is either a persistent volume claim, prefixed by pvc:, or a directory of the node.
+func (bp *KubernetesBuildProcessor) buildPod(meta metav1.ObjectMeta, image string, envs []corev1.EnvVar, arch string, localKernel bool, localDriver bool, ccacheDir string) *corev1.Pod {
+	nodeSelector := map[string]string{}
+	if arch != "" {
+		nodeSelector[kubernetesArchLabel] = kubernetesArch(arch)
+	}
+	for k, v := range bp.podOptions.NodeSelector {
+		nodeSelector[k] = v
 	}
 
 	pod := &corev1.Pod{
-		ObjectMeta: commonMeta,
+		ObjectMeta: meta,
 		Spec: corev1.PodSpec{
-			ActiveDeadlineSeconds: pointer.Int64Ptr(deadline),
+			ActiveDeadlineSeconds: pointer.Int64Ptr(int64(bp.timeout)),
 			RestartPolicy:         corev1.RestartPolicyNever,
+			NodeSelector:          nodeSelector,
+			Tolerations:           bp.podOptions.Tolerations,
+			Affinity:              bp.podOptions.Affinity,
+			PriorityClassName:     bp.podOptions.PriorityClassName,
+			ServiceAccountName:    bp.podOptions.ServiceAccount,
+			SecurityContext:       bp.podOptions.PodSecurityContext,
 			Containers: []corev1.Container{
 				{
-					Name:            name,
-					Image:           builderImage,
-					Command:         buildCmd,
+					Name:            meta.Name,
+					Image:           image,
+					Command:         bp.buildCommand(),
 					Env:             envs,
-					ImagePullPolicy: corev1.PullIfNotPresent,
-
-					Resources: corev1.ResourceRequirements{
-						Requests: corev1.ResourceList{
-							corev1.ResourceCPU:    resource.MustParse("1000m"),
-							corev1.ResourceMemory: resource.MustParse("2000Mi"),
-						},
-						Limits: corev1.ResourceList{
-							corev1.ResourceCPU:    resource.MustParse("4"),
-							corev1.ResourceMemory: resource.MustParse("4G"),
-						},
-					},
+					ImagePullPolicy: bp.podOptions.ImagePullPolicy,
+					Resources:       bp.podOptions.Resources,
+					SecurityContext: bp.podOptions.SecurityContext,
 					VolumeMounts: []corev1.VolumeMount{
 						{
 							Name:      "driverkit",
@@ -194,7 +668,7 @@ func (bp *KubernetesBuildProcessor) buildModule(build *builder.Build) error {
 					VolumeSource: corev1.VolumeSource{
 						ConfigMap: &corev1.ConfigMapVolumeSource{
 							LocalObjectReference: corev1.LocalObjectReference{
-								Name: cm.Name,
+								Name: meta.Name,
 							},
 						},
 					},
@@ -203,65 +677,573 @@ func (bp *KubernetesBuildProcessor) buildModule(build *builder.Build) error {
 		},
 	}
 
-	ctx := context.Background()
-	ctx = signals.WithStandardSignals(ctx)
-	_, err = configClient.Create(ctx, cm, metav1.CreateOptions{})
-	if err != nil {
-		return err
+	for _, secret := range bp.podOptions.ImagePullSecrets {
+		pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, corev1.LocalObjectReference{Name: secret})
 	}
-	_, err = podClient.Create(ctx, pod, metav1.CreateOptions{})
-	if err != nil {
-		return err
+
+	// The local kernel packages do not fit a config map, they are copied into the pod once running
+	if localKernel {
+		pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      "driverkit-kernel",
+			MountPath: builder.LocalKernelDirectory,
+		})
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: "driverkit-kernel",
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
+			},
+		})
+	}
+	// The local driver sources neither
+	if localDriver {
+		pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      "driverkit-driver",
+			MountPath: builder.LocalDriverDirectory,
+		})
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: "driverkit-driver",
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
+			},
+		})
 	}
 
-	out, err := os.Create(build.ModuleFilePath)
+	if len(ccacheDir) > 0 {
+		source := corev1.VolumeSource{}
+		if claim := strings.TrimPrefix(ccacheDir, builder.CcachePVCPrefix); claim != ccacheDir {
+			source.PersistentVolumeClaim = &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim}
+		} else {
+			hostPathType := corev1.HostPathDirectoryOrCreate
+			source.HostPath = &corev1.HostPathVolumeSource{Path: ccacheDir, Type: &hostPathType}
+		}
+		pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      "driverkit-ccache",
+			MountPath: builder.CcacheDirectory,
+		})
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name:         "driverkit-ccache",
+			VolumeSource: source,
+		})
+	}
+	return pod
+}
 
-	if err != nil {
-		return err
+// checkSecurityContext fails when the security contexts prevent the build script from installing the kernel packages,
+// the container one overriding the pod one as kubernetes does.
+func checkSecurityContext(opts KubernetesPodOptions) error {
+	var runAsUser *int64
+	var runAsNonRoot *bool
+	if p := opts.PodSecurityContext; p != nil {
+		runAsUser, runAsNonRoot = p.RunAsUser, p.RunAsNonRoot
 	}
-	defer out.Close()
+	c := opts.SecurityContext
+	if c != nil && c.RunAsUser != nil {
+		runAsUser = c.RunAsUser
+	}
+	if c != nil && c.RunAsNonRoot != nil {
+		runAsNonRoot = c.RunAsNonRoot
+	}
+	if runAsUser != nil && *runAsUser != 0 {
+		return fmt.Errorf("the build script must run as root, not as user %d", *runAsUser)
+	}
+	if runAsNonRoot != nil && *runAsNonRoot {
+		return fmt.Errorf("the build script must run as root, runAsNonRoot cannot be set")
+	}
+
+	if c == nil || c.Capabilities == nil {
+		return nil
+	}
+	added := map[corev1.Capability]bool{}
+	for _, capability := range c.Capabilities.Add {
+		added[normalizeCapability(capability)] = true
+	}
+	dropped := map[corev1.Capability]bool{}
+	for _, capability := range c.Capabilities.Drop {
+		dropped[normalizeCapability(capability)] = true
+	}
+	missing := []string{}
+	for _, capability := range buildRequiredCapabilities {
+		if (dropped["ALL"] || dropped[capability]) && !added[capability] {
+			missing = append(missing, string(capability))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("the build script needs the %s capabilities, add them back", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// normalizeCapability returns the capability as named by kubernetes, e.g. CHOWN for cap_chown.
+func normalizeCapability(capability corev1.Capability) corev1.Capability {
+	return corev1.Capability(strings.TrimPrefix(strings.ToUpper(string(capability)), "CAP_"))
+}
+
+// buildCommand returns the command of the build container,
+// which is kept running on failure when the failed pods are kept.
+func (bp *KubernetesBuildProcessor) buildCommand() []string {
+	if bp.podOptions.KeepFailedPod {
+		return []string{"/bin/bash", "-c", keepFailedPodScript}
+	}
+	return []string{"/bin/bash", "/driverkit/driverkit.sh"}
+}
 
-	return bp.copyModuleFromPodWithUID(ctx, out, namespace, string(uid))
+// kubernetesArch returns the architecture as named by the nodes label, that is the Go one.
+func kubernetesArch(arch string) string {
+	switch arch {
+	case "armv7l":
+		return "arm"
+	case "ppc64le":
+		return "ppc64le"
+	}
+	return kernelrelease.Architecture(arch).ToDeb()
 }
 
-func (bp *KubernetesBuildProcessor) copyModuleFromPodWithUID(ctx context.Context, out io.Writer, namespace string, falcoBuilderUID string) error {
-	namespacedClient := bp.coreV1Client.Pods(namespace)
-	watch, err := namespacedClient.Watch(ctx, metav1.ListOptions{
+// copyModuleFromPodWithUID waits for the build pod to run, then copies the local sources into it and the module out of it,
+// or, given the retriever of the module the pod transfers on its own, waits for the pod to succeed and retrieves it.
+// The failed pods of a build job are waited for to be retried, up to its backoff limit, the job failing e.g. past its deadline too.
+func (bp *KubernetesBuildProcessor) copyModuleFromPodWithUID(ctx context.Context, out *os.File, namespace string, falcoBuilderUID string, localKernelDir string, localKernel []string, localDriverDir string, retrieve moduleRetriever) error {
+	selector := metav1.ListOptions{
 		LabelSelector: fmt.Sprintf("%s=%s", falcoBuilderUIDLabel, falcoBuilderUID),
-	})
+	}
+	podWatch, err := bp.coreV1Client.Pods(namespace).Watch(ctx, selector)
 	if err != nil {
 		return err
 	}
-	// Give it ten minutes to complete, if it doesn't give an error
-	// TODO(fntlnz): maybe pass this from the outside?
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
-	defer cancel()
+	defer podWatch.Stop()
+	// the bare pods have no job to watch, the nil channel never receives
+	var jobEvents <-chan watch.Event
+	if !bp.podOptions.BarePod {
+		jobWatch, err := bp.batchV1Client.Jobs(namespace).Watch(ctx, selector)
+		if err != nil {
+			return err
+		}
+		defer jobWatch.Stop()
+		jobEvents = jobWatch.ResultChan()
+	}
+	failed := map[string]bool{}
+	following := map[string]bool{}
 	for {
 		select {
 		case <-ctx.Done():
-			return errors.New("module copy from pod interrupted before the copy was complete")
-		default:
-			event := <-watch.ResultChan()
+			return fmt.Errorf("module copy from pod interrupted before the copy was complete: %w", ctx.Err())
+		case event := <-jobEvents:
+			j, ok := event.Object.(*batchv1.Job)
+			if !ok {
+				builder.Logger(ctx).Error("unexpected type when watching jobs")
+				continue
+			}
+			if err := jobFailure(j); err != nil {
+				return err
+			}
+		case event := <-podWatch.ResultChan():
 			p, ok := event.Object.(*corev1.Pod)
 			if !ok {
-				logger.Error("unexpected type when watching pods")
+				builder.Logger(ctx).Error("unexpected type when watching pods")
 				continue
 			}
-			if p.Status.Phase == corev1.PodPending {
+			if failed[p.Name] || p.Status.Phase == corev1.PodPending {
 				continue
 			}
+			if p.Status.Phase == corev1.PodFailed {
+				if bp.retried(ctx, p, failed) {
+					continue
+				}
+				return bp.podFailure(ctx, p.Namespace, p.Name, nil)
+			}
+			if retrieve != nil {
+				switch p.Status.Phase {
+				case corev1.PodRunning:
+					if !following[p.Name] {
+						following[p.Name] = true
+						go bp.forwardPodLogs(ctx, p.Namespace, p.Name)
+					}
+					continue
+				case corev1.PodSucceeded:
+					if !following[p.Name] {
+						bp.forwardPodLogs(ctx, p.Namespace, p.Name)
+					}
+					// the build succeeded whatever happens now
+					if err := retrieve(ctx, out); err != nil {
+						return fmt.Errorf("%w: the kernel module was built, but could not be retrieved: %s", ErrArtifactTransfer, err)
+					}
+					builder.Logger(ctx).WithField(falcoBuilderUIDLabel, falcoBuilderUID).Info("completed retrieving module")
+				}
+				return nil
+			}
 			if p.Status.Phase == corev1.PodRunning {
-				logger.WithField(falcoBuilderUIDLabel, falcoBuilderUID).Info("start downloading module from pod")
-				err = copySingleFileFromPod(out, bp.coreV1Client, bp.clientConfig, p.Namespace, p.Name)
-				if err != nil {
-					return err
+				if err := bp.copyWithPod(ctx, out, p, falcoBuilderUID, localKernelDir, localKernel, localDriverDir); err != nil {
+					if bp.retried(ctx, p, failed) {
+						// the next pod writes the module again
+						if _, err := out.Seek(0, io.SeekStart); err != nil {
+							return err
+						}
+						if err := out.Truncate(0); err != nil {
+							return err
+						}
+						continue
+					}
+					return bp.podFailure(ctx, p.Namespace, p.Name, err)
 				}
-				logger.WithField(falcoBuilderUIDLabel, falcoBuilderUID).Info("completed downloading module from pod")
 			}
 			return nil
 		}
+	}
+}
+
+// moduleRetriever retrieves the kernel module the build pod transferred on its own once succeeded.
+type moduleRetriever func(ctx context.Context, out *os.File) error
+
+// checkArtifactTransfer fails when the kernel module cannot be transferred out of the build pod as the options tell,
+// the pods without exec can neither get the local kernel packages and driver sources nor be exec'd into once kept.
+// checkKubernetesOutputs fails for the outputs other than the kernel module, only the module is streamed out of the build pod.
+func checkKubernetesOutputs(b *builder.Build) error {
+	outputs := []struct {
+		path string
+		name string
+	}{
+		{b.ProbeFilePath, "eBPF probe"},
+		{b.ModernProbeFilePath, "modern eBPF probe"},
+		{b.BTFFilePath, "BTF of the kernel"},
+		{b.DKMSFilePath, "DKMS package"},
+	}
+	for _, output := range outputs {
+		if len(output.path) > 0 {
+			return fmt.Errorf("the %s cannot be retrieved from the build pod, use the docker, local or ssh processor", output.name)
+		}
+	}
+	if len(b.ModuleFilePath) == 0 {
+		return fmt.Errorf("the kubernetes processor builds the kernel module only, its output path is required")
+	}
+	return nil
+}
+
+func checkArtifactTransfer(opts KubernetesPodOptions, b *builder.Build) error {
+	switch opts.ArtifactTransfer {
+	case "", ArtifactTransferExec:
+		return nil
+	case ArtifactTransferPVC:
+		if len(opts.ArtifactPVC) == 0 {
+			return fmt.Errorf("the pvc artifact transfer needs the persistent volume claim to transfer through")
+		}
+	case ArtifactTransferS3:
+		if len(opts.ArtifactS3URL) == 0 {
+			return fmt.Errorf("the s3 artifact transfer needs the s3 URL to transfer under")
+		}
+	default:
+		return fmt.Errorf("invalid artifact transfer %s, it must be one of %s, %s or %s", opts.ArtifactTransfer, ArtifactTransferExec, ArtifactTransferPVC, ArtifactTransferS3)
+	}
+	if len(b.LocalKernelDir) > 0 || len(b.LocalDriverDir) > 0 {
+		return fmt.Errorf("the local kernel packages and driver sources are copied into the build pod through exec, they need the %s artifact transfer", ArtifactTransferExec)
+	}
+	if opts.KeepFailedPod {
+		return fmt.Errorf("the failed build pods are kept to exec into them, they need the %s artifact transfer", ArtifactTransferExec)
+	}
+	return nil
+}
+
+// withArtifactPVC mounts the claim the kernel module is transferred through into the build pod.
+func withArtifactPVC(pod *corev1.Pod, claim string) {
+	pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+		Name:      "driverkit-artifacts",
+		MountPath: artifactsDirectory,
+	})
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: "driverkit-artifacts",
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+		},
+	})
+}
+
+// artifactReaderPod returns the short-lived pod printing the kernel module the build pod copied into the claim, placed and run as the build pod.
+// It is not labeled as the build pods, which are watched by label.
+func artifactReaderPod(pod *corev1.Pod, claim string) *corev1.Pod {
+	container := pod.Spec.Containers[0]
+	reader := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pod.Name + "-reader",
+			Namespace: pod.Namespace,
+		},
+		Spec: corev1.PodSpec{
+			ActiveDeadlineSeconds: pod.Spec.ActiveDeadlineSeconds,
+			RestartPolicy:         corev1.RestartPolicyNever,
+			NodeSelector:          pod.Spec.NodeSelector,
+			Tolerations:           pod.Spec.Tolerations,
+			Affinity:              pod.Spec.Affinity,
+			PriorityClassName:     pod.Spec.PriorityClassName,
+			ServiceAccountName:    pod.Spec.ServiceAccountName,
+			SecurityContext:       pod.Spec.SecurityContext,
+			ImagePullSecrets:      pod.Spec.ImagePullSecrets,
+			Containers: []corev1.Container{
+				{
+					Name:            "reader",
+					Image:           container.Image,
+					Command:         []string{"/bin/sh", "-c", readModuleFromPVCScript(path.Join(artifactsDirectory, pod.Name))},
+					ImagePullPolicy: container.ImagePullPolicy,
+					SecurityContext: container.SecurityContext,
+				},
+			},
+		},
+	}
+	withArtifactPVC(reader, claim)
+	return reader
+}
+
+// pvcRetriever returns the retriever of the kernel module the build pod copies into the claim:
+// it runs the reader pod once the build pod succeeded, reading the module out of its logs rather than through exec.
+func (bp *KubernetesBuildProcessor) pvcRetriever(pod *corev1.Pod) moduleRetriever {
+	return func(ctx context.Context, out *os.File) error {
+		reader := artifactReaderPod(pod, bp.podOptions.ArtifactPVC)
+		podClient := bp.coreV1Client.Pods(reader.Namespace)
+		readerWatch, err := podClient.Watch(ctx, metav1.ListOptions{FieldSelector: "metadata.name=" + reader.Name})
+		if err != nil {
+			return err
+		}
+		defer readerWatch.Stop()
+		if _, err := podClient.Create(ctx, reader, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		defer func() {
+			if err := podClient.Delete(context.Background(), reader.Name, metav1.DeleteOptions{}); err != nil {
+				builder.Logger(ctx).WithError(err).WithField("pod", reader.Name).Warn("unable to delete the artifact reader pod")
+			}
+		}()
+		for done := false; !done; {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case event := <-readerWatch.ResultChan():
+				p, ok := event.Object.(*corev1.Pod)
+				if !ok || p.Name != reader.Name {
+					continue
+				}
+				switch p.Status.Phase {
+				case corev1.PodSucceeded:
+					done = true
+				case corev1.PodFailed:
+					reason, _ := containerTermination(p)
+					return fmt.Errorf("artifact reader pod %s failed: %s", p.Name, reason)
+				}
+			}
+		}
+		stream, err := podClient.GetLogs(reader.Name, &corev1.PodLogOptions{}).Stream(ctx)
+		if err != nil {
+			return err
+		}
+		defer stream.Close()
+		// the decoder skips the new lines base64 wraps its output with
+		if _, err := io.Copy(out, base64.NewDecoder(base64.StdEncoding, stream)); err != nil {
+			return fmt.Errorf("unable to read the kernel module out of the logs of the artifact reader pod %s: %s", reader.Name, err)
+		}
+		return nil
+	}
+}
+
+// copyWithPod copies the local kernel packages and driver sources into the running build pod, then the module out of it.
+func (bp *KubernetesBuildProcessor) copyWithPod(ctx context.Context, out io.Writer, p *corev1.Pod, falcoBuilderUID string, localKernelDir string, localKernel []string, localDriverDir string) error {
+	logsCtx, stopLogs := context.WithCancel(ctx)
+	defer stopLogs()
+	go bp.forwardPodLogs(logsCtx, p.Namespace, p.Name)
+
+	if len(localKernel) > 0 {
+		builder.Logger(ctx).WithField(falcoBuilderUIDLabel, falcoBuilderUID).Info("start copying local kernel packages to pod")
+		err := untilDone(ctx, func() error {
+			return copyLocalKernelToPod(bp.coreV1Client, bp.clientConfig, p.Namespace, p.Name, localKernelDir, localKernel)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if len(localDriverDir) > 0 {
+		builder.Logger(ctx).WithField(falcoBuilderUIDLabel, falcoBuilderUID).Info("start copying local driver sources to pod")
+		err := untilDone(ctx, func() error {
+			return copyLocalDriverToPod(bp.coreV1Client, bp.clientConfig, p.Namespace, p.Name, localDriverDir)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	builder.Logger(ctx).WithField(falcoBuilderUIDLabel, falcoBuilderUID).Info("start downloading module from pod")
+	err := untilDone(ctx, func() error {
+		return copySingleFileFromPod(out, bp.coreV1Client, bp.clientConfig, p.Namespace, p.Name)
+	})
+	if err != nil {
+		return err
+	}
+	builder.Logger(ctx).WithField(falcoBuilderUIDLabel, falcoBuilderUID).Info("completed downloading module from pod")
+	return nil
+}
+
+// retried tells whether the build job retries the pod, which failed, or was deleted e.g. by draining its node, and is counted as such.
+// The bare pods are never retried, nor the ones running still, e.g. when the copy failed on its own.
+func (bp *KubernetesBuildProcessor) retried(ctx context.Context, p *corev1.Pod, failed map[string]bool) bool {
+	if bp.podOptions.BarePod || ctx.Err() != nil {
+		return false
+	}
+	current, err := bp.coreV1Client.Pods(p.Namespace).Get(ctx, p.Name, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) || err == nil && current.Status.Phase != corev1.PodFailed {
+		return false
+	}
+	failed[p.Name] = true
+	if int32(len(failed)) > bp.podOptions.BackoffLimit {
+		return false
+	}
+	builder.Logger(ctx).WithField("pod", p.Name).Warnf("build pod failed, retried by the build job (%d/%d)", len(failed), bp.podOptions.BackoffLimit)
+	return true
+}
+
+// jobFailure returns the error of the build job once failed, e.g. past its deadline or its backoff limit.
+func jobFailure(j *batchv1.Job) error {
+	for _, c := range j.Status.Conditions {
+		if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue {
+			return fmt.Errorf("build job %s failed: %s: %s", j.Name, c.Reason, c.Message)
+		}
+	}
+	return nil
+}
+
+// untilDone runs f, returning early with the context error when it is canceled.
+// The execs into the pod cannot be canceled, they are stopped by deleting the pod.
+func untilDone(ctx context.Context, f func() error) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- f()
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-errCh:
+		return err
+	}
+}
+
+// forwardPodLogs forwards the logs of the build container to the logger, and to the build log of the context if any,
+// line by line, until the context is done.
+func (bp *KubernetesBuildProcessor) forwardPodLogs(ctx context.Context, namespace string, name string) {
+	stream, err := bp.coreV1Client.Pods(namespace).GetLogs(name, &corev1.PodLogOptions{Follow: true}).Stream(ctx)
+	if err != nil {
+		builder.Logger(ctx).WithError(err).WithField("pod", name).Debug("unable to stream the build pod logs")
+		return
+	}
+	defer stream.Close()
+	log := builder.Logger(ctx).WithField("pod", name)
+	buildLog := BuildLog(ctx)
+	scanner := bufio.NewScanner(stream)
+	for scanner.Scan() {
+		if bp.podOptions.VerboseLogs {
+			log.Info(scanner.Text())
+		} else {
+			log.Debug(scanner.Text())
+		}
+		if buildLog != nil {
+			fmt.Fprintln(buildLog, scanner.Text())
+		}
+	}
+}
+
+// podFailure returns the error of a failed build, with the termination reason of the build container and the tail of its logs.
+// The cause is the error the build was noticed failing with, if any.
+func (bp *KubernetesBuildProcessor) podFailure(ctx context.Context, namespace string, name string, cause error) error {
+	// canceled builds did not fail, there is nothing to explain
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	podClient := bp.coreV1Client.Pods(namespace)
+	// the build container may be still terminating when the copy fails
+	reason := "the build did not complete"
+	for i := 0; i < 10; i++ {
+		p, err := podClient.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			break
+		}
+		if r, ok := containerTermination(p); ok {
+			reason = r
+			break
+		}
+		if bp.podOptions.KeepFailedPod {
+			reason = "the build script failed"
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	if cause != nil {
+		reason = fmt.Sprintf("%s (%s)", reason, cause)
+	}
+
+	tail := int64(failedPodLogLines)
+	logs, err := podClient.GetLogs(name, &corev1.PodLogOptions{TailLines: &tail}).DoRaw(ctx)
+	if err != nil {
+		return fmt.Errorf("build pod %s failed: %s", name, reason)
+	}
+	return fmt.Errorf("build pod %s failed: %s, last %d lines of logs:\n%s", name, reason, tail, strings.TrimRight(string(logs), "\n"))
+}
+
+// containerTermination describes how the build container terminated, if it did.
+func containerTermination(p *corev1.Pod) (string, bool) {
+	for _, s := range p.Status.ContainerStatuses {
+		if t := s.State.Terminated; t != nil {
+			reason := fmt.Sprintf("%s, exit code %d", t.Reason, t.ExitCode)
+			if t.Message != "" {
+				reason = fmt.Sprintf("%s: %s", reason, t.Message)
+			}
+			return reason, true
+		}
+	}
+	if p.Status.Reason != "" {
+		return fmt.Sprintf("%s: %s", p.Status.Reason, p.Status.Message), true
+	}
+	return "", false
+}
+
+// copyLocalKernelToPod extracts the local kernel packages into the pod, then tells the build script they are complete.
+func copyLocalKernelToPod(podClient v1.PodsGetter, clientConfig *restclient.Config, namespace, podName string, localKernelDir string, localKernel []string) error {
+	return extractIntoPod(podClient, clientConfig, namespace, podName, localKernelCompletePath, func(w io.Writer) error {
+		return tarLocalKernel(w, localKernelDir, localKernel, builder.LocalKernelDirectory)
+	})
+}
+
+// copyLocalDriverToPod extracts the local driver sources into the pod, then tells the build script they are complete.
+func copyLocalDriverToPod(podClient v1.PodsGetter, clientConfig *restclient.Config, namespace, podName string, localDriverDir string) error {
+	return extractIntoPod(podClient, clientConfig, namespace, podName, localDriverCompletePath, func(w io.Writer) error {
+		return tarLocalDriver(w, localDriverDir, builder.LocalDriverDirectory)
+	})
+}
 
+// extractIntoPod streams the archive written by tarFn into the root of the pod, then creates the complete file.
+func extractIntoPod(podClient v1.PodsGetter, clientConfig *restclient.Config, namespace, podName string, complete string, tarFn func(w io.Writer) error) error {
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() {
+		pw.CloseWithError(tarFn(pw))
+	}()
+
+	options := &exec.ExecOptions{
+		PodClient: podClient,
+		Config:    clientConfig,
+		StreamOptions: exec.StreamOptions{
+			IOStreams: genericclioptions.IOStreams{
+				In:     pr,
+				Out:    bytes.NewBuffer([]byte{}),
+				ErrOut: bytes.NewBuffer([]byte{}),
+			},
+			Stdin: true,
+
+			Namespace: namespace,
+			PodName:   podName,
+		},
+
+		Command: []string{
+			"/bin/bash",
+			"-c",
+			fmt.Sprintf("tar -xf - -C / && touch %s", complete),
+		},
+		Executor: &exec.DefaultRemoteExecutor{},
+	}
+
+	if err := options.Validate(); err != nil {
+		return err
 	}
+	return options.Run()
 }
 
 func copySingleFileFromPod(out io.Writer, podClient v1.PodsGetter, clientConfig *restclient.Config, namespace, podName string) error {
@@ -0,0 +1,747 @@
+package driverbuilder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestBuildPodDefaults(t *testing.T) {
+	bp := NewKubernetesBuildProcessor(nil, nil, "default", 60, "", "", DefaultKubernetesPodOptions())
+	pod := bp.buildPod(metav1.ObjectMeta{Name: "driverkit-uid"}, BuilderBaseImage, nil, "x86_64", false, false, "")
+
+	if got := pod.Spec.NodeSelector; !reflect.DeepEqual(got, map[string]string{kubernetesArchLabel: "amd64"}) {
+		t.Errorf("Got: [ %v ] / Want: [ the amd64 node selector ]", got)
+	}
+	if got := *pod.Spec.ActiveDeadlineSeconds; got != 60 {
+		t.Errorf("Got: [ %d ] / Want: [ 60 ]", got)
+	}
+	container := pod.Spec.Containers[0]
+	if got := container.Resources.Requests[corev1.ResourceMemory]; got.String() != "2000Mi" {
+		t.Errorf("Got: [ '%s' ] / Want: [ '2000Mi' ]", got.String())
+	}
+	if got := container.Resources.Limits[corev1.ResourceCPU]; got.String() != "4" {
+		t.Errorf("Got: [ '%s' ] / Want: [ '4' ]", got.String())
+	}
+	if got := container.ImagePullPolicy; got != corev1.PullIfNotPresent {
+		t.Errorf("Got: [ '%s' ] / Want: [ '%s' ]", got, corev1.PullIfNotPresent)
+	}
+	if len(pod.Spec.Tolerations) != 0 || pod.Spec.Affinity != nil || pod.Spec.PriorityClassName != "" {
+		t.Errorf("Got: [ %v ] / Want: [ no tolerations, affinity nor priority class ]", pod.Spec)
+	}
+	if len(pod.Spec.Volumes) != 1 || pod.Spec.Volumes[0].ConfigMap.Name != "driverkit-uid" {
+		t.Errorf("Got: [ %v ] / Want: [ only the config map volume ]", pod.Spec.Volumes)
+	}
+}
+
+func TestBuildPodOptions(t *testing.T) {
+	opts := KubernetesPodOptions{
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")},
+		},
+		NodeSelector: map[string]string{
+			kubernetesArchLabel: "arm64",
+			"pool":              "builds",
+		},
+		Tolerations: []corev1.Toleration{
+			{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "builds", Effect: corev1.TaintEffectNoSchedule},
+		},
+		Affinity: &corev1.Affinity{
+			NodeAffinity: &corev1.NodeAffinity{
+				PreferredDuringSchedulingIgnoredDuringExecution: []corev1.PreferredSchedulingTerm{
+					{
+						Weight: 1,
+						Preference: corev1.NodeSelectorTerm{
+							MatchExpressions: []corev1.NodeSelectorRequirement{
+								{Key: "zone", Operator: corev1.NodeSelectorOpIn, Values: []string{"a"}},
+							},
+						},
+					},
+				},
+			},
+		},
+		PriorityClassName: "low",
+		ImagePullSecrets:  []string{"registry"},
+		ImagePullPolicy:   corev1.PullAlways,
+		ServiceAccount:    "driverkit",
+		PodSecurityContext: &corev1.PodSecurityContext{
+			SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+		},
+	}
+	bp := NewKubernetesBuildProcessor(nil, nil, "default", 60, "", "", opts)
+	pod := bp.buildPod(metav1.ObjectMeta{Name: "driverkit-uid"}, BuilderBaseImage, nil, "x86_64", true, true, "")
+
+	wantSelector := map[string]string{kubernetesArchLabel: "arm64", "pool": "builds"}
+	if got := pod.Spec.NodeSelector; !reflect.DeepEqual(got, wantSelector) {
+		t.Errorf("Got: [ %v ] / Want: [ %v ]", got, wantSelector)
+	}
+	if got := pod.Spec.Containers[0].Resources; !reflect.DeepEqual(got, opts.Resources) {
+		t.Errorf("Got: [ %v ] / Want: [ %v ]", got, opts.Resources)
+	}
+	if got := pod.Spec.Tolerations; !reflect.DeepEqual(got, opts.Tolerations) {
+		t.Errorf("Got: [ %v ] / Want: [ %v ]", got, opts.Tolerations)
+	}
+	if got := pod.Spec.Affinity; !reflect.DeepEqual(got, opts.Affinity) {
+		t.Errorf("Got: [ %v ] / Want: [ %v ]", got, opts.Affinity)
+	}
+	if got := pod.Spec.PriorityClassName; got != "low" {
+		t.Errorf("Got: [ '%s' ] / Want: [ 'low' ]", got)
+	}
+	if got := pod.Spec.ServiceAccountName; got != "driverkit" {
+		t.Errorf("Got: [ '%s' ] / Want: [ 'driverkit' ]", got)
+	}
+	if got := pod.Spec.SecurityContext; !reflect.DeepEqual(got, opts.PodSecurityContext) {
+		t.Errorf("Got: [ %v ] / Want: [ %v ]", got, opts.PodSecurityContext)
+	}
+	if got := pod.Spec.ImagePullSecrets; len(got) != 1 || got[0].Name != "registry" {
+		t.Errorf("Got: [ %v ] / Want: [ the registry pull secret ]", got)
+	}
+	if got := pod.Spec.Containers[0].ImagePullPolicy; got != corev1.PullAlways {
+		t.Errorf("Got: [ '%s' ] / Want: [ '%s' ]", got, corev1.PullAlways)
+	}
+	if got := pod.Spec.Containers[0].VolumeMounts; len(got) != 3 || got[1].MountPath != builder.LocalKernelDirectory || got[2].MountPath != builder.LocalDriverDirectory {
+		t.Errorf("Got: [ %v ] / Want: [ the local kernel packages and driver sources mounts ]", got)
+	}
+}
+
+func TestBuildPodCcache(t *testing.T) {
+	bp := NewKubernetesBuildProcessor(nil, nil, "default", 60, "", "", DefaultKubernetesPodOptions())
+	tests := map[string]struct {
+		ccacheDir string
+		claim     string
+		hostPath  string
+	}{
+		"persistent volume claim": {ccacheDir: "pvc:driverkit-ccache", claim: "driverkit-ccache"},
+		"host path":               {ccacheDir: "/var/cache/driverkit", hostPath: "/var/cache/driverkit"},
+	}
+	for name, test := range tests {
+		pod := bp.buildPod(metav1.ObjectMeta{Name: "driverkit-uid"}, BuilderBaseImage, nil, "x86_64", false, false, test.ccacheDir)
+		mounts := pod.Spec.Containers[0].VolumeMounts
+		if len(mounts) != 2 || mounts[1].MountPath != builder.CcacheDirectory {
+			t.Errorf("Test Input: '%s' | Got: [ %v ] / Want: [ the ccache directory mount ]", name, mounts)
+			continue
+		}
+		source := pod.Spec.Volumes[1].VolumeSource
+		var claim, hostPath string
+		if source.PersistentVolumeClaim != nil {
+			claim = source.PersistentVolumeClaim.ClaimName
+		}
+		if source.HostPath != nil {
+			hostPath = source.HostPath.Path
+		}
+		if claim != test.claim || hostPath != test.hostPath {
+			t.Errorf("Test Input: '%s' | Got: [ '%s' '%s' ] / Want: [ '%s' '%s' ]", name, claim, hostPath, test.claim, test.hostPath)
+		}
+	}
+}
+
+func TestKubernetesArch(t *testing.T) {
+	tests := map[string]string{
+		"x86_64":  "amd64",
+		"aarch64": "arm64",
+		"armv7l":  "arm",
+		"ppc64le": "ppc64le",
+		"s390x":   "s390x",
+		"amd64":   "amd64",
+	}
+	for arch, want := range tests {
+		if got := kubernetesArch(arch); got != want {
+			t.Errorf("Architecture %s | Got: [ '%s' ] / Want: [ '%s' ]", arch, got, want)
+		}
+	}
+}
+
+func TestBuildPodKeepFailed(t *testing.T) {
+	opts := DefaultKubernetesPodOptions()
+	opts.KeepFailedPod = true
+	bp := NewKubernetesBuildProcessor(nil, nil, "default", 60, "", "", opts)
+	pod := bp.buildPod(metav1.ObjectMeta{Name: "driverkit-uid"}, BuilderBaseImage, nil, "x86_64", false, false, "")
+
+	want := []string{"/bin/bash", "-c", keepFailedPodScript}
+	if got := pod.Spec.Containers[0].Command; !reflect.DeepEqual(got, want) {
+		t.Errorf("Got: [ %v ] / Want: [ %v ]", got, want)
+	}
+}
+
+func TestPodFailure(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "driverkit-uid", Namespace: "default"},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodFailed,
+			ContainerStatuses: []corev1.ContainerStatus{
+				{
+					State: corev1.ContainerState{
+						Terminated: &corev1.ContainerStateTerminated{Reason: "Error", ExitCode: 2},
+					},
+				},
+			},
+		},
+	}
+	client := fake.NewSimpleClientset(pod)
+	bp := NewKubernetesBuildProcessor(client, nil, "default", 60, "", "", DefaultKubernetesPodOptions())
+
+	err := bp.podFailure(context.Background(), "default", "driverkit-uid", nil)
+	if err == nil {
+		t.Fatalf("Expecting an error for a failed pod")
+	}
+	// the fake client logs are always "fake logs"
+	want := "build pod driverkit-uid failed: Error, exit code 2, last 20 lines of logs:\nfake logs"
+	if err.Error() != want {
+		t.Errorf("Got: [ '%s' ] / Want: [ '%s' ]", err, want)
+	}
+}
+
+func TestBuildModuleCanceled(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "linux.tar.xz"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	b := &builder.Build{
+		TargetType:       builder.TargetTypeVanilla,
+		KernelRelease:    "5.10.0",
+		KernelVersion:    "1",
+		DriverVersion:    "master",
+		Architecture:     "amd64",
+		KernelConfigData: "Q09ORklHX0JQRj15Cg==", // CONFIG_BPF=y
+		ModuleFilePath:   filepath.Join(dir, "falco.ko"),
+		ModuleDriverName: "falco",
+		ModuleDeviceName: "falco",
+		LocalKernelDir:   dir,
+	}
+
+	// the fake pods never start, the build waits for them until the timeout expires
+	for _, bare := range []bool{false, true} {
+		client := fake.NewSimpleClientset()
+		opts := DefaultKubernetesPodOptions()
+		opts.BarePod = bare
+		bp := NewKubernetesBuildProcessor(client, nil, "default", 60, "", "", opts)
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		report, err := bp.Start(ctx, b)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Test Input: 'bare pod %t' | Got: [ %v ] / Want: [ %v ]", bare, err, context.DeadlineExceeded)
+		}
+
+		if report.Success || len(report.Artifacts) != 1 || report.Artifacts[0].Success {
+			t.Errorf("Test Input: 'bare pod %t' | Got: [ %+v ] / Want: [ the failed build report ]", bare, report)
+		}
+
+		pods, err := client.CoreV1().Pods("default").List(context.Background(), metav1.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		jobs, err := client.BatchV1().Jobs("default").List(context.Background(), metav1.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		configMaps, err := client.CoreV1().ConfigMaps("default").List(context.Background(), metav1.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pods.Items) != 0 || len(jobs.Items) != 0 || len(configMaps.Items) != 0 {
+			t.Errorf("Test Input: 'bare pod %t' | Got: [ %d pods, %d jobs, %d config maps ] / Want: [ the build pod or job and config map deleted ]", bare, len(pods.Items), len(jobs.Items), len(configMaps.Items))
+		}
+	}
+}
+
+func TestBuildJob(t *testing.T) {
+	bp := NewKubernetesBuildProcessor(nil, nil, "default", 60, "", "", DefaultKubernetesPodOptions())
+	pod := bp.buildPod(metav1.ObjectMeta{Name: "driverkit-uid", Labels: map[string]string{falcoBuilderUIDLabel: "uid"}}, BuilderBaseImage, nil, "x86_64", false, false, "")
+	job := bp.buildJob(pod)
+
+	if got := *job.Spec.BackoffLimit; got != 1 {
+		t.Errorf("Got: [ %d ] / Want: [ 1 ]", got)
+	}
+	if got := *job.Spec.ActiveDeadlineSeconds; got != 60 {
+		t.Errorf("Got: [ %d ] / Want: [ 60 ]", got)
+	}
+	if got := *job.Spec.TTLSecondsAfterFinished; got != 600 {
+		t.Errorf("Got: [ %d ] / Want: [ 600 ]", got)
+	}
+	// the job deadline bounds the retries, not each pod one
+	if got := job.Spec.Template.Spec.ActiveDeadlineSeconds; got != nil {
+		t.Errorf("Got: [ %d ] / Want: [ no pod deadline ]", *got)
+	}
+	if got := job.Spec.Template.Labels[falcoBuilderUIDLabel]; got != "uid" {
+		t.Errorf("Got: [ '%s' ] / Want: [ 'uid' ]", got)
+	}
+	if got := job.Spec.Template.Spec.RestartPolicy; got != corev1.RestartPolicyNever {
+		t.Errorf("Got: [ '%s' ] / Want: [ '%s' ]", got, corev1.RestartPolicyNever)
+	}
+	if pod.Spec.ActiveDeadlineSeconds == nil {
+		t.Errorf("Got: [ no deadline ] / Want: [ the bare pod deadline left untouched ]")
+	}
+
+	opts := DefaultKubernetesPodOptions()
+	opts.TTLAfterFinished = 0
+	bp = NewKubernetesBuildProcessor(nil, nil, "default", 60, "", "", opts)
+	if got := bp.buildJob(pod).Spec.TTLSecondsAfterFinished; got != nil {
+		t.Errorf("Got: [ %d ] / Want: [ no TTL ]", *got)
+	}
+}
+
+func TestDeleteOrphanJobs(t *testing.T) {
+	job := func(name string, age time.Duration, labels map[string]string) *batchv1.Job {
+		return &batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "default",
+			Labels:            labels,
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-age)),
+		}}
+	}
+	label := map[string]string{falcoBuilderUIDLabel: "uid"}
+	client := fake.NewSimpleClientset(
+		job("driverkit-old", 48*time.Hour, label),
+		job("driverkit-recent", time.Hour, label),
+		job("other-old", 48*time.Hour, map[string]string{"app": "other"}),
+	)
+	bp := NewKubernetesBuildProcessor(client, nil, "default", 60, "", "", DefaultKubernetesPodOptions())
+	bp.deleteOrphanJobs(context.Background(), "default")
+	// the second call does nothing, even once the recent job is old enough
+	bp.podOptions.OrphanJobsAge = time.Minute
+	bp.deleteOrphanJobs(context.Background(), "default")
+
+	jobs, err := client.BatchV1().Jobs("default").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, j := range jobs.Items {
+		got = append(got, j.Name)
+	}
+	sort.Strings(got)
+	if want := []string{"driverkit-recent", "other-old"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Got: [ %v ] / Want: [ %v ]", got, want)
+	}
+}
+
+func TestRetried(t *testing.T) {
+	pod := func(name string, phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Status:     corev1.PodStatus{Phase: phase},
+		}
+	}
+	client := fake.NewSimpleClientset(pod("first", corev1.PodFailed), pod("second", corev1.PodFailed), pod("running", corev1.PodRunning))
+	bp := NewKubernetesBuildProcessor(client, nil, "default", 60, "", "", DefaultKubernetesPodOptions())
+	failed := map[string]bool{}
+
+	// the copy failing on its own fails the build
+	if bp.retried(context.Background(), pod("running", corev1.PodRunning), failed) {
+		t.Errorf("Test Input: 'running' | Got: [ retried ] / Want: [ not retried ]")
+	}
+	if !bp.retried(context.Background(), pod("first", corev1.PodFailed), failed) {
+		t.Errorf("Test Input: 'first' | Got: [ not retried ] / Want: [ retried ]")
+	}
+	// past the backoff limit of 1
+	if bp.retried(context.Background(), pod("second", corev1.PodFailed), failed) {
+		t.Errorf("Test Input: 'second' | Got: [ retried ] / Want: [ not retried ]")
+	}
+
+	bp.podOptions.BarePod = true
+	if bp.retried(context.Background(), pod("first", corev1.PodFailed), map[string]bool{}) {
+		t.Errorf("Test Input: 'bare pod' | Got: [ retried ] / Want: [ not retried ]")
+	}
+}
+
+func TestJobFailure(t *testing.T) {
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "driverkit-uid"}}
+	if err := jobFailure(job); err != nil {
+		t.Errorf("Got: [ %v ] / Want: [ no error for a running job ]", err)
+	}
+	job.Status.Conditions = []batchv1.JobCondition{
+		{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: "DeadlineExceeded", Message: "Job was active longer than specified deadline"},
+	}
+	want := "build job driverkit-uid failed: DeadlineExceeded: Job was active longer than specified deadline"
+	if err := jobFailure(job); err == nil || err.Error() != want {
+		t.Errorf("Got: [ %v ] / Want: [ '%s' ]", err, want)
+	}
+}
+
+func TestCheckArtifactTransfer(t *testing.T) {
+	tests := []struct {
+		descr   string
+		opts    KubernetesPodOptions
+		build   builder.Build
+		wantErr bool
+	}{
+		{"exec", KubernetesPodOptions{ArtifactTransfer: ArtifactTransferExec}, builder.Build{LocalKernelDir: "/tmp"}, false},
+		{"default", KubernetesPodOptions{KeepFailedPod: true}, builder.Build{}, false},
+		{"pvc", KubernetesPodOptions{ArtifactTransfer: ArtifactTransferPVC, ArtifactPVC: "artifacts"}, builder.Build{}, false},
+		{"pvc without claim", KubernetesPodOptions{ArtifactTransfer: ArtifactTransferPVC}, builder.Build{}, true},
+		{"s3", KubernetesPodOptions{ArtifactTransfer: ArtifactTransferS3, ArtifactS3URL: "s3://drivers"}, builder.Build{}, false},
+		{"s3 without url", KubernetesPodOptions{ArtifactTransfer: ArtifactTransferS3}, builder.Build{}, true},
+		{"unknown", KubernetesPodOptions{ArtifactTransfer: "sidecar"}, builder.Build{}, true},
+		{"s3 with local kernel", KubernetesPodOptions{ArtifactTransfer: ArtifactTransferS3, ArtifactS3URL: "s3://drivers"}, builder.Build{LocalKernelDir: "/tmp"}, true},
+		{"pvc with local driver", KubernetesPodOptions{ArtifactTransfer: ArtifactTransferPVC, ArtifactPVC: "artifacts"}, builder.Build{LocalDriverDir: "/tmp"}, true},
+		{"pvc keeping failed pods", KubernetesPodOptions{ArtifactTransfer: ArtifactTransferPVC, ArtifactPVC: "artifacts", KeepFailedPod: true}, builder.Build{}, true},
+	}
+	for _, test := range tests {
+		b := test.build
+		if err := checkArtifactTransfer(test.opts, &b); (err != nil) != test.wantErr {
+			t.Errorf("Test Input: '%s' | Got: [ %v ] / Want: [ error %t ]", test.descr, err, test.wantErr)
+		}
+	}
+}
+
+func TestCheckKubernetesOutputs(t *testing.T) {
+	tests := []struct {
+		descr   string
+		build   builder.Build
+		wantErr bool
+	}{
+		{"module", builder.Build{ModuleFilePath: "/tmp/falco.ko"}, false},
+		{"probe", builder.Build{ModuleFilePath: "/tmp/falco.ko", ProbeFilePath: "/tmp/falco.o"}, true},
+		{"probe only", builder.Build{ProbeFilePath: "/tmp/falco.o"}, true},
+		{"modern probe", builder.Build{ModuleFilePath: "/tmp/falco.ko", ModernProbeFilePath: "/tmp/bpf_probe.skel.h"}, true},
+		{"btf", builder.Build{ModuleFilePath: "/tmp/falco.ko", BTFFilePath: "/tmp/vmlinux.btf"}, true},
+		{"dkms", builder.Build{ModuleFilePath: "/tmp/falco.ko", DKMSFilePath: "/tmp/falco-dkms.tar.gz"}, true},
+		{"no module", builder.Build{}, true},
+	}
+	for _, test := range tests {
+		b := test.build
+		if err := checkKubernetesOutputs(&b); (err != nil) != test.wantErr {
+			t.Errorf("Test Input: '%s' | Got: [ %v ] / Want: [ error %t ]", test.descr, err, test.wantErr)
+		}
+	}
+}
+
+func TestArtifactReaderPod(t *testing.T) {
+	opts := DefaultKubernetesPodOptions()
+	opts.ImagePullSecrets = []string{"registry"}
+	bp := NewKubernetesBuildProcessor(nil, nil, "default", 60, "", "", opts)
+	pod := bp.buildPod(metav1.ObjectMeta{Name: "driverkit-uid", Namespace: "builds", Labels: map[string]string{falcoBuilderUIDLabel: "uid"}}, BuilderBaseImage, nil, "x86_64", false, false, "")
+	withArtifactPVC(pod, "artifacts")
+	reader := artifactReaderPod(pod, "artifacts")
+
+	if reader.Name != "driverkit-uid-reader" || reader.Namespace != "builds" || len(reader.Labels) != 0 {
+		t.Errorf("Got: [ %+v ] / Want: [ the unlabeled reader pod of the build ]", reader.ObjectMeta)
+	}
+	container := reader.Spec.Containers[0]
+	want := []string{"/bin/sh", "-c", "base64 /driverkit-artifacts/driverkit-uid/module.ko && rm -rf /driverkit-artifacts/driverkit-uid"}
+	if !reflect.DeepEqual(container.Command, want) {
+		t.Errorf("Got: [ %v ] / Want: [ %v ]", container.Command, want)
+	}
+	if container.Image != BuilderBaseImage || !reflect.DeepEqual(reader.Spec.NodeSelector, pod.Spec.NodeSelector) || !reflect.DeepEqual(reader.Spec.ImagePullSecrets, pod.Spec.ImagePullSecrets) {
+		t.Errorf("Got: [ %+v ] / Want: [ the reader pod placed and pulled as the build pod ]", reader.Spec)
+	}
+	if len(reader.Spec.Volumes) != 1 || reader.Spec.Volumes[0].PersistentVolumeClaim.ClaimName != "artifacts" || container.VolumeMounts[0].MountPath != artifactsDirectory {
+		t.Errorf("Got: [ %+v ] / Want: [ only the claim mounted ]", reader.Spec.Volumes)
+	}
+}
+
+func TestBuildModuleArtifactPVC(t *testing.T) {
+	// the kernel sources are only checked to exist, the build pod downloads them
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	dir := t.TempDir()
+	b := &builder.Build{
+		TargetType:       builder.TargetTypeVanilla,
+		KernelRelease:    "5.10.0",
+		KernelVersion:    "1",
+		DriverVersion:    "master",
+		Architecture:     "amd64",
+		KernelConfigData: "Q09ORklHX0JQRj15Cg==", // CONFIG_BPF=y
+		KernelUrls:       []string{srv.URL + "/linux-5.10.tar.xz"},
+		ModuleFilePath:   filepath.Join(dir, "falco.ko"),
+		ModuleDriverName: "falco",
+		ModuleDeviceName: "falco",
+	}
+
+	client := fake.NewSimpleClientset()
+	opts := DefaultKubernetesPodOptions()
+	opts.ArtifactTransfer = ArtifactTransferPVC
+	opts.ArtifactPVC = "artifacts"
+	bp := NewKubernetesBuildProcessor(client, nil, "default", 60, "", "", opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the fake cluster has no controllers: run the build pod of the job, then the reader pod, up to their success
+	go func() {
+		podClient := client.CoreV1().Pods("default")
+		for ctx.Err() == nil {
+			time.Sleep(10 * time.Millisecond)
+			jobs, err := client.BatchV1().Jobs("default").List(ctx, metav1.ListOptions{})
+			if err != nil || len(jobs.Items) == 0 {
+				continue
+			}
+			job := jobs.Items[0]
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: job.Name + "-abcde", Namespace: "default", Labels: job.Spec.Template.Labels},
+				Status:     corev1.PodStatus{Phase: corev1.PodSucceeded},
+			}
+			if _, err := podClient.Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+				t.Error(err)
+				return
+			}
+			for ctx.Err() == nil {
+				time.Sleep(10 * time.Millisecond)
+				reader, err := podClient.Get(ctx, job.Name+"-reader", metav1.GetOptions{})
+				if err != nil {
+					continue
+				}
+				reader.Status.Phase = corev1.PodSucceeded
+				if _, err := podClient.UpdateStatus(ctx, reader, metav1.UpdateOptions{}); err != nil {
+					t.Error(err)
+				}
+				return
+			}
+		}
+	}()
+
+	// the fake reader pod logs are "fake logs", which is not base64, the module built cannot be retrieved
+	report, err := bp.Start(ctx, b)
+	if !errors.Is(err, ErrArtifactTransfer) {
+		t.Fatalf("Got: [ %v ] / Want: [ %v ]", err, ErrArtifactTransfer)
+	}
+	if report.Success || report.Artifacts[0].Success {
+		t.Errorf("Got: [ %+v ] / Want: [ the failed build report ]", report)
+	}
+
+	var job *batchv1.Job
+	var cm *corev1.ConfigMap
+	var reader *corev1.Pod
+	for _, action := range client.Actions() {
+		if create, ok := action.(k8stesting.CreateAction); ok {
+			switch obj := create.GetObject().(type) {
+			case *batchv1.Job:
+				job = obj
+			case *corev1.ConfigMap:
+				cm = obj
+			case *corev1.Pod:
+				if strings.HasSuffix(obj.Name, "-reader") {
+					reader = obj
+				}
+			}
+		}
+	}
+	if script := cm.Data["driverkit.sh"]; !strings.Contains(script, "cp "+builder.ModuleFullPath+" "+artifactsDirectory+"/"+job.Name+"/module.ko") || strings.Contains(script, "module-download.lock") {
+		t.Errorf("Got: [ %s ] / Want: [ the script copying the module into the claim, not waiting for its download ]", script)
+	}
+	mounted := false
+	for _, v := range job.Spec.Template.Spec.Volumes {
+		mounted = mounted || (v.PersistentVolumeClaim != nil && v.PersistentVolumeClaim.ClaimName == "artifacts")
+	}
+	if !mounted {
+		t.Errorf("Got: [ %+v ] / Want: [ the claim mounted into the build pod ]", job.Spec.Template.Spec.Volumes)
+	}
+	if reader == nil {
+		t.Fatalf("Expecting the reader pod to be created")
+	}
+	if _, err := client.CoreV1().Pods("default").Get(context.Background(), reader.Name, metav1.GetOptions{}); err == nil {
+		t.Errorf("Got: [ the reader pod ] / Want: [ the reader pod deleted ]")
+	}
+}
+
+func TestBuildModuleSigningSecret(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"linux.tar.xz", "signing_key.pem", "signing_key.x509"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b := &builder.Build{
+		TargetType:        builder.TargetTypeVanilla,
+		KernelRelease:     "5.10.0",
+		KernelVersion:     "1",
+		DriverVersion:     "master",
+		Architecture:      "amd64",
+		KernelConfigData:  "Q09ORklHX0JQRj15Cg==", // CONFIG_BPF=y
+		ModuleFilePath:    filepath.Join(dir, "falco.ko"),
+		ModuleDriverName:  "falco",
+		ModuleDeviceName:  "falco",
+		LocalKernelDir:    dir,
+		ModuleSigningKey:  filepath.Join(dir, "signing_key.pem"),
+		ModuleSigningCert: filepath.Join(dir, "signing_key.x509"),
+	}
+
+	client := fake.NewSimpleClientset()
+	opts := DefaultKubernetesPodOptions()
+	opts.KeepFailedPod = true
+	bp := NewKubernetesBuildProcessor(client, nil, "default", 60, "", "", opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := bp.Start(ctx, b); err == nil {
+		t.Fatalf("Expecting an error")
+	}
+
+	var secret *corev1.Secret
+	var job *batchv1.Job
+	var cm *corev1.ConfigMap
+	for _, action := range client.Actions() {
+		if create, ok := action.(k8stesting.CreateAction); ok {
+			switch obj := create.GetObject().(type) {
+			case *corev1.Secret:
+				secret = obj
+			case *batchv1.Job:
+				job = obj
+			case *corev1.ConfigMap:
+				cm = obj
+			}
+		}
+	}
+	if secret == nil || string(secret.Data["signing_key.pem"]) != "signing_key.pem" || string(secret.Data["signing_key.x509"]) != "signing_key.x509" {
+		t.Fatalf("Got: [ %+v ] / Want: [ the key pair secret ]", secret)
+	}
+	if _, ok := cm.Data["signing_key.pem"]; ok || !strings.Contains(cm.Data["driverkit.sh"], "$signfile sha256 "+moduleSigningKeyPath) || !strings.Contains(cm.Data["module-downloader.sh"], moduleSignedPath) {
+		t.Errorf("Got: [ %+v ] / Want: [ the config map signing the module, without the key pair ]", cm.Data)
+	}
+	mounted := false
+	for _, v := range job.Spec.Template.Spec.Volumes {
+		mounted = mounted || (v.Secret != nil && v.Secret.SecretName == secret.Name)
+	}
+	if !mounted {
+		t.Errorf("Got: [ %+v ] / Want: [ the key pair secret mounted ]", job.Spec.Template.Spec.Volumes)
+	}
+
+	secrets, err := client.CoreV1().Secrets("default").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets.Items) != 0 {
+		t.Errorf("Got: [ %d secrets ] / Want: [ the key pair secret deleted ]", len(secrets.Items))
+	}
+}
+
+func TestCheckSecurityContext(t *testing.T) {
+	root := int64(0)
+	user := int64(1000)
+	yes := true
+	no := false
+	tests := []struct {
+		descr   string
+		opts    KubernetesPodOptions
+		wantErr bool
+	}{
+		{
+			descr: "defaults",
+		},
+		{
+			descr: "restricted seccomp profile and no privilege escalation",
+			opts: KubernetesPodOptions{
+				PodSecurityContext: &corev1.PodSecurityContext{
+					SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+				},
+				SecurityContext: &corev1.SecurityContext{AllowPrivilegeEscalation: &no},
+			},
+		},
+		{
+			descr:   "non root user",
+			opts:    KubernetesPodOptions{PodSecurityContext: &corev1.PodSecurityContext{RunAsUser: &user}},
+			wantErr: true,
+		},
+		{
+			descr: "root container overriding the pod user",
+			opts: KubernetesPodOptions{
+				PodSecurityContext: &corev1.PodSecurityContext{RunAsUser: &user},
+				SecurityContext:    &corev1.SecurityContext{RunAsUser: &root},
+			},
+		},
+		{
+			descr:   "run as non root",
+			opts:    KubernetesPodOptions{SecurityContext: &corev1.SecurityContext{RunAsNonRoot: &yes}},
+			wantErr: true,
+		},
+		{
+			descr: "all capabilities dropped",
+			opts: KubernetesPodOptions{SecurityContext: &corev1.SecurityContext{
+				Capabilities: &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+			}},
+			wantErr: true,
+		},
+		{
+			descr: "all capabilities dropped but the required ones",
+			opts: KubernetesPodOptions{SecurityContext: &corev1.SecurityContext{
+				Capabilities: &corev1.Capabilities{
+					Drop: []corev1.Capability{"ALL"},
+					Add:  []corev1.Capability{"CHOWN", "cap_dac_override", "FOWNER"},
+				},
+			}},
+		},
+		{
+			descr: "unrelated capabilities dropped",
+			opts: KubernetesPodOptions{SecurityContext: &corev1.SecurityContext{
+				Capabilities: &corev1.Capabilities{Drop: []corev1.Capability{"NET_RAW", "MKNOD"}},
+			}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.descr, func(t *testing.T) {
+			err := checkSecurityContext(test.opts)
+			if test.wantErr && err == nil {
+				t.Errorf("Expecting an error")
+			}
+			if !test.wantErr && err != nil {
+				t.Errorf("Unexpected error encountered | Error: '%s'", err)
+			}
+		})
+	}
+}
+
+func TestBuildModuleDryRun(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "linux.tar.xz"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	b := &builder.Build{
+		TargetType:       builder.TargetTypeVanilla,
+		KernelRelease:    "5.10.0",
+		KernelVersion:    "1",
+		DriverVersion:    "master",
+		Architecture:     "amd64",
+		KernelConfigData: "Q09ORklHX0JQRj15Cg==", // CONFIG_BPF=y
+		ModuleFilePath:   filepath.Join(dir, "falco.ko"),
+		ModuleDriverName: "falco",
+		ModuleDeviceName: "falco",
+		LocalKernelDir:   dir,
+	}
+
+	client := fake.NewSimpleClientset()
+	bp := NewKubernetesBuildProcessor(client, nil, "default", 60, "", "", DefaultKubernetesPodOptions())
+	var script bytes.Buffer
+	report, err := bp.DryRun(context.Background(), b, &script)
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if !report.Success || len(report.Artifacts) != 0 || len(report.KernelURLs) != 1 {
+		t.Errorf("Got: [ %+v ] / Want: [ a successful report with the local kernel package and no artifacts ]", report)
+	}
+	if actions := client.Actions(); len(actions) != 0 {
+		t.Errorf("Got: [ %v ] / Want: [ nothing done in the cluster ]", actions)
+	}
+
+	// the script of the dry run is the one of the build
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := bp.Start(ctx, b); err == nil {
+		t.Fatalf("Expecting an error")
+	}
+	var cm *corev1.ConfigMap
+	for _, action := range client.Actions() {
+		if create, ok := action.(k8stesting.CreateAction); ok {
+			if obj, ok := create.GetObject().(*corev1.ConfigMap); ok {
+				cm = obj
+			}
+		}
+	}
+	if cm == nil || cm.Data["driverkit.sh"] != script.String() {
+		t.Errorf("Got: [ %s ] / Want: [ the script of the build ]", script.String())
+	}
+}
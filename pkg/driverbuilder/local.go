@@ -0,0 +1,404 @@
+package driverbuilder
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+)
+
+// LocalBuildProcessorName is a constant containing the local name.
+const LocalBuildProcessorName = "local"
+
+// localRequiredTools are the tools the build scripts always need on the host.
+var localRequiredTools = []string{"gcc", "make", "curl"}
+
+// localProbeRequiredTools are the tools the build scripts need on the host to build the eBPF probe.
+var localProbeRequiredTools = []string{"clang", "llc"}
+
+// localModernProbeRequiredTools are the tools the build scripts need on the host to build the modern eBPF probe.
+var localModernProbeRequiredTools = []string{"cmake", "clang-14", "bpftool"}
+
+// localCcacheRequiredTools are the tools the build scripts need on the host to compile through ccache.
+var localCcacheRequiredTools = []string{"ccache"}
+
+// systemCABundlePath is the system CA bundle the CA bundle is trusted in addition to.
+const systemCABundlePath = "/etc/ssl/certs/ca-certificates.crt"
+
+// gccLinkPattern matches the build scripts switching the system gcc.
+var gccLinkPattern = regexp.MustCompile(`(?m)^ln -sf (\S+) /usr/bin/gcc$`)
+
+// localInstallPattern matches the build scripts installing a tool when it is missing.
+var localInstallPattern = regexp.MustCompile(`(command -v (\S+) >/dev/null) \|\| \(apt-get update && apt-get install -y --no-install-recommends ([^)]+)\)`)
+
+// localSystemInstallPattern matches the build scripts installing packages, or extracting them into the root.
+var localSystemInstallPattern = regexp.MustCompile(`(?m)^.*(\b(apt-get|yum|dnf|tdnf|zypper|apk|pacman) install\b|\s-C /\s).*$`)
+
+// localSystemPathPattern matches the system paths the build scripts copy the kernel headers into.
+var localSystemPathPattern = regexp.MustCompile(`(^|[\s="'])(/usr/src|/lib|/usr)\b`)
+
+// LocalBuildProcessor runs the build script directly on the host, into a temporary working directory.
+type LocalBuildProcessor struct {
+	processorOptions
+	timeout   int
+	proxy     string
+	caCert    string
+	env       []string
+	allowRoot bool
+}
+
+// NewLocalBuildProcessor constructs a LocalBuildProcessor.
+// The env variables, in the NAME=VALUE form or just NAME to pass the host one, are given to the build script.
+func NewLocalBuildProcessor(timeout int, proxy string, caCert string, env []string, allowRoot bool, opts ...ProcessorOption) *LocalBuildProcessor {
+	return &LocalBuildProcessor{
+		processorOptions: newProcessorOptions(opts),
+		timeout:          timeout,
+		proxy:            proxy,
+		caCert:           caCert,
+		env:              env,
+		allowRoot:        allowRoot,
+	}
+}
+
+func (bp *LocalBuildProcessor) String() string {
+	return LocalBuildProcessorName
+}
+
+// Start the local processor
+func (bp *LocalBuildProcessor) Start(ctx context.Context, b *builder.Build) (*BuildReport, error) {
+	ctx, reporter := startReport(bp.withLogger(ctx), b, "")
+	err := bp.start(ctx, b)
+	return reporter.complete(ctx, b, err)
+}
+
+// start runs the build.
+func (bp *LocalBuildProcessor) start(ctx context.Context, b *builder.Build) error {
+	builder.Logger(ctx).Debug("doing a new local build")
+	if os.Geteuid() == 0 && !bp.allowRoot {
+		return fmt.Errorf("refusing to run the build script as root, use --allow-root to do it anyway")
+	}
+	if strings.HasPrefix(b.CcacheDir, builder.CcachePVCPrefix) {
+		return fmt.Errorf("the ccache directory %s is a persistent volume claim, only the kubernetes processor mounts them", b.CcacheDir)
+	}
+	if err := checkLocalTools(len(b.ProbeFilePath) > 0, len(b.ModernProbeFilePath) > 0, len(b.CcacheDir) > 0); err != nil {
+		return err
+	}
+
+	// create a builder based on the chosen build type
+	v, err := builder.Factory(b.TargetType)
+	if err != nil {
+		return err
+	}
+	caBundle, err := readCABundle(bp.caCert)
+	if err != nil {
+		return err
+	}
+	entitlementCert, entitlementKey, err := readRHELEntitlement(b)
+	if err != nil {
+		return err
+	}
+	signingKey, signingCert, err := readModuleSigningKey(b)
+	if err != nil {
+		return err
+	}
+	b, localKernel, err := withLocalKernel(b)
+	if err != nil {
+		return err
+	}
+	if err := checkLocalDriver(b); err != nil {
+		return err
+	}
+	c := builder.Config{
+		DriverName:          b.ModuleDriverName,
+		DeviceName:          b.ModuleDeviceName,
+		DownloadBaseURL:     "https://github.com/falcosecurity/libs/archive",
+		ProxyURL:            bp.proxy,
+		CABundle:            caBundle,
+		RHELEntitlementCert: entitlementCert,
+		RHELEntitlementKey:  entitlementKey,
+		Build:               b,
+		// the build runs on the host, cross compiled if the build is for another architecture
+		BuilderArchitecture: runtime.GOARCH,
+	}
+
+	// fail fast when the target cannot do the build, before downloading anything
+	kr := c.Build.KernelReleaseFromBuildConfig()
+	if err := builder.Validate(v, c, kr); err != nil {
+		return err
+	}
+
+	ctx, err = builder.WithHTTPClient(ctx, c)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(bp.timeout)*time.Second)
+	defer cancel()
+
+	// Generate the build script from the builder
+	driverkitScript, err := builder.Script(ctx, v, c, kr)
+	if err != nil {
+		return err
+	}
+	if len(b.CcacheDir) > 0 {
+		driverkitScript = withCcache(driverkitScript)
+	}
+	if len(signingKey) > 0 {
+		driverkitScript = withModuleSigning(driverkitScript)
+	}
+	driverkitScript = withArtifactFailures(driverkitScript)
+
+	// Prepare driver config template
+	bufFillDriverConfig := bytes.NewBuffer(nil)
+	err = renderFillDriverConfig(bufFillDriverConfig, driverConfigData{DriverVersion: c.DriverVersion, DriverName: c.DriverName, DeviceName: c.DeviceName})
+	if err != nil {
+		return err
+	}
+
+	// Prepare makefile template
+	bufMakefile := bytes.NewBuffer(nil)
+	err = renderMakefile(bufMakefile, makefileData{ModuleName: c.DriverName, ModuleBuildDir: builder.DriverDirectory})
+	if err != nil {
+		return err
+	}
+
+	configDecoded, err := base64.StdEncoding.DecodeString(b.KernelConfigData)
+	if err != nil {
+		return err
+	}
+
+	workDir, err := ioutil.TempDir("", "driverkit-local-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+	builder.Logger(ctx).WithField("dir", workDir).Debug("using working directory")
+
+	// The scripts are meant to run into the builder, move all the paths they use into the working directory
+	paths := localPaths(workDir)
+	env := bp.environ(workDir)
+	script, err := localScript(driverkitScript, workDir)
+	if err != nil {
+		return err
+	}
+	if len(b.CcacheDir) > 0 {
+		// the cache is the directory of the host itself, it outlives the working directory
+		script = strings.ReplaceAll(script, paths.Replace(builder.CcacheDirectory), b.CcacheDir)
+	}
+	files := map[string]string{
+		"/driverkit/driverkit.sh":          script,
+		"/driverkit/kernel.config":         string(configDecoded),
+		"/driverkit/module-Makefile":       paths.Replace(bufMakefile.String()),
+		"/driverkit/fill-driver-config.sh": paths.Replace(bufFillDriverConfig.String()),
+	}
+	if len(caBundle) > 0 {
+		// Trust the CA bundle in addition to the system one, without touching the latter
+		system, _ := ioutil.ReadFile(systemCABundlePath)
+		files[CABundlePath] = string(system) + "\n" + string(caBundle)
+		env = append(env, "CURL_CA_BUNDLE="+paths.Replace(CABundlePath), "SSL_CERT_FILE="+paths.Replace(CABundlePath))
+	}
+	// the key pair lives in the working directory, removed once done
+	if len(signingKey) > 0 {
+		files[moduleSigningKeyPath] = string(signingKey)
+		files[moduleSigningCertPath] = string(signingCert)
+	}
+	if len(entitlementCert) > 0 {
+		files[builder.RHELEntitlementCertPath] = string(entitlementCert)
+		files[builder.RHELEntitlementKeyPath] = string(entitlementKey)
+	}
+	if kernelCurlConfig := builder.KernelURLsCurlConfig(b); len(kernelCurlConfig) > 0 {
+		files[builder.KernelURLsCurlConfigPath] = string(kernelCurlConfig)
+	}
+	for name, body := range files {
+		if err := writeLocalFile(paths.Replace(name), body); err != nil {
+			return err
+		}
+	}
+	for _, name := range localKernel {
+		from := filepath.Join(b.LocalKernelDir, name)
+		if err := copyLocalFile(from, paths.Replace(filepath.Join(builder.LocalKernelDirectory, name))); err != nil {
+			return err
+		}
+	}
+	if len(b.LocalDriverDir) > 0 {
+		to := paths.Replace(builder.LocalDriverDirectory)
+		err := walkLocalDriver(b.LocalDriverDir, func(name, rel string, info os.FileInfo) error {
+			if info.IsDir() {
+				return os.MkdirAll(filepath.Join(to, rel), 0755)
+			}
+			if err := copyLocalFile(name, filepath.Join(to, rel)); err != nil {
+				return err
+			}
+			return os.Chmod(filepath.Join(to, rel), info.Mode().Perm()|0644)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	cmd := exec.CommandContext(ctx, "/bin/bash", paths.Replace("/driverkit/driverkit.sh"))
+	cmd.Dir = workDir
+	cmd.Env = env
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	waitErr := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		pw.Close()
+		waitErr <- err
+	}()
+	forwardLogs(ctx, pr)
+	failures := artifactsFailedError{}
+	if err := <-waitErr; err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// the failure of the kernel module or of the eBPF probe does not prevent the other artifacts from being copied
+		if failures = readArtifactFailures(b, func(name string) ([]byte, error) { return ioutil.ReadFile(paths.Replace(name)) }); len(failures) == 0 {
+			return fmt.Errorf("build script failed: %s", err)
+		}
+	}
+
+	return copyArtifacts(ctx, b, failures, func(from, to string) error {
+		return copyLocalFile(paths.Replace(from), to)
+	})
+}
+
+// environ returns the environment of the build script: the bare minimum to run, the proxy and the user provided variables.
+func (bp *LocalBuildProcessor) environ(workDir string) []string {
+	env := []string{
+		"PATH=" + filepath.Join(workDir, "bin") + ":" + os.Getenv("PATH"),
+		"HOME=" + os.Getenv("HOME"),
+	}
+	if bp.proxy != "" {
+		env = append(env,
+			fmt.Sprintf("http_proxy=%s", bp.proxy),
+			fmt.Sprintf("https_proxy=%s", bp.proxy),
+		)
+	}
+	for _, e := range bp.env {
+		if !strings.Contains(e, "=") {
+			value, ok := os.LookupEnv(e)
+			if !ok {
+				continue
+			}
+			e = e + "=" + value
+		}
+		env = append(env, e)
+	}
+	return env
+}
+
+// checkLocalTools fails when any of the tools the build script needs is missing from the host.
+func checkLocalTools(probe bool, modernProbe bool, ccache bool) error {
+	tools := localRequiredTools
+	if probe {
+		tools = append(tools, localProbeRequiredTools...)
+	}
+	if modernProbe {
+		tools = append(tools, localModernProbeRequiredTools...)
+	}
+	if ccache {
+		tools = append(tools, localCcacheRequiredTools...)
+	}
+	missing := []string{}
+	for _, t := range tools {
+		if _, err := exec.LookPath(t); err != nil {
+			missing = append(missing, t)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("required tools not found on the host: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// localPaths moves the paths the build scripts use into the working directory.
+func localPaths(workDir string) *strings.Replacer {
+	return strings.NewReplacer(
+		"/driverkit/", filepath.Join(workDir, "driverkit")+"/",
+		"/tmp/", workDir+"/",
+	)
+}
+
+// localScript adapts the build script to run on the host: its paths are moved into the working directory,
+// the kernel headers too rather than into the system ones, and gcc is switched by the means of the PATH rather than
+// replacing the system one. The tools the builder image installs when missing fail the build instead, and the
+// scripts installing into the system of the host are refused.
+func localScript(script string, workDir string) (string, error) {
+	bin := filepath.Join(workDir, "bin")
+	script = localPaths(workDir).Replace(script)
+	script = gccLinkPattern.ReplaceAllString(script, "ln -sf $1 "+filepath.Join(bin, "gcc"))
+	script = localInstallPattern.ReplaceAllString(script, `$1 || (echo "$2 not found on the host, install $3" >&2 && exit 1)`)
+	if install := localSystemInstallPattern.FindString(script); len(install) > 0 {
+		return "", fmt.Errorf("refusing to run the build script, it installs into the system of the host: %s", strings.TrimSpace(install))
+	}
+	lines := strings.Split(script, "\n")
+	for i, line := range lines {
+		// debugfs reads the paths of the image, not the ones of the host
+		if !strings.HasPrefix(line, "debugfs ") {
+			lines[i] = localSystemPaths(line, workDir)
+		}
+	}
+	return afterShebang(strings.Join(lines, "\n"), fmt.Sprintf("\nmkdir -p %s\n", bin)), nil
+}
+
+// localSystemPaths moves the system paths the build scripts copy the kernel headers into to the working directory,
+// /usr alone being moved since the other paths below it are the tools of the host.
+func localSystemPaths(line string, workDir string) string {
+	b := strings.Builder{}
+	last := 0
+	for _, m := range localSystemPathPattern.FindAllStringSubmatchIndex(line, -1) {
+		start, end := m[4], m[5]
+		if line[start:end] == "/usr" && end < len(line) && line[end] == '/' {
+			continue
+		}
+		b.WriteString(line[last:start])
+		b.WriteString(filepath.Join(workDir, line[start:end]))
+		last = end
+	}
+	b.WriteString(line[last:])
+	return b.String()
+}
+
+func writeLocalFile(name, body string) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, []byte(body), 0644)
+}
+
+func copyLocalFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,291 @@
+package driverbuilder
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+func TestLocalScript(t *testing.T) {
+	script := `#!/bin/bash
+set -xeuo pipefail
+
+rm -Rf /tmp/driver
+mkdir /tmp/driver
+cp /driverkit/module-Makefile /tmp/driver/Makefile
+ln -sf /usr/bin/gcc-8 /usr/bin/gcc
+make KERNELDIR=/tmp/kernel`
+
+	got, err := localScript(script, "/tmp/driverkit-local-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"#!/bin/bash\n\nmkdir -p /tmp/driverkit-local-1/bin\n",
+		"rm -Rf /tmp/driverkit-local-1/driver\n",
+		"cp /tmp/driverkit-local-1/driverkit/module-Makefile /tmp/driverkit-local-1/driver/Makefile\n",
+		"ln -sf /usr/bin/gcc-8 /tmp/driverkit-local-1/bin/gcc\n",
+		"make KERNELDIR=/tmp/driverkit-local-1/kernel",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Script does not contain: [ '%s' ]\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, " /usr/bin/gcc\n") {
+		t.Errorf("Script replaces the system gcc\n%s", got)
+	}
+}
+
+func TestLocalScriptDebian(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	v, err := builder.Factory(builder.TargetTypeDebian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &builder.Build{
+		TargetType:       builder.TargetTypeDebian,
+		Architecture:     "amd64",
+		KernelRelease:    "6.1.0-17-amd64",
+		KernelVersion:    "1",
+		DriverVersion:    "7.0.0+driver",
+		ModuleDriverName: "falco",
+		ModuleFilePath:   "/tmp/falco.ko",
+		ProbeFilePath:    "/tmp/falco.o",
+		SkipChecksum:     true,
+		KernelUrls: []string{
+			srv.URL + "/linux-headers-6.1.0-17-amd64_6.1.69-1_amd64.deb",
+			srv.URL + "/linux-headers-6.1.0-17-common_6.1.69-1_all.deb",
+			srv.URL + "/linux-kbuild-6.1_6.1.69-1_amd64.deb",
+		},
+	}
+	c := builder.Config{DriverName: "falco", DownloadBaseURL: "https://github.com/falcosecurity/libs/archive", Build: b}
+	script, err := builder.Script(context.Background(), v, c, b.KernelReleaseFromBuildConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	workDir := "/tmp/driverkit-local-1"
+	got, err := localScript(script, workDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"cp -r usr/* " + workDir + "/usr\n",
+		"cp -r lib/* " + workDir + "/lib\n",
+		"cd " + workDir + "/usr/src\n",
+		"KERNELDIR=$sourcedir",
+		`command -v gcc-8 >/dev/null || (echo "gcc-8 not found on the host, install gcc-8" >&2 && exit 1)`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Script does not contain: [ '%s' ]\n%s", want, got)
+		}
+	}
+	// the only system paths left are the tools of the host, run but never written
+	for _, path := range regexp.MustCompile(`(^|[\s="'(])(/[^\s"';)]*)`).FindAllStringSubmatch(got, -1) {
+		if !strings.HasPrefix(path[2], workDir) && !strings.HasPrefix(path[2], "/usr/bin/") && path[2] != "/dev/null" {
+			t.Errorf("Script contains the system path: [ '%s' ]\n%s", path[2], got)
+		}
+	}
+	if strings.Contains(got, "apt-get") {
+		t.Errorf("Script installs packages\n%s", got)
+	}
+}
+
+func TestLocalScriptRefused(t *testing.T) {
+	script := `#!/bin/bash
+set -xeuo pipefail
+
+yum install -y kernel-devel`
+
+	_, err := localScript(script, "/tmp/driverkit-local-1")
+	if want := "refusing to run the build script, it installs into the system of the host: yum install -y kernel-devel"; err == nil || err.Error() != want {
+		t.Errorf("Got: [ %v ] / Want: [ '%s' ]", err, want)
+	}
+}
+
+// mustLocalScript adapts the build script to run on the host, failing the test when refused.
+func mustLocalScript(t *testing.T, script string, workDir string) string {
+	t.Helper()
+	s, err := localScript(script, workDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestLocalEnviron(t *testing.T) {
+	withEnv(t, "DRIVERKIT_TEST_PASSED", "passed")
+	bp := NewLocalBuildProcessor(60, "http://proxy:3128", "", []string{"CC=gcc-12", "DRIVERKIT_TEST_PASSED", "DRIVERKIT_TEST_UNSET"}, false)
+
+	env := strings.Join(bp.environ("/tmp/driverkit-local-1"), "\n")
+	for _, want := range []string{
+		"PATH=/tmp/driverkit-local-1/bin:",
+		"https_proxy=http://proxy:3128",
+		"CC=gcc-12",
+		"DRIVERKIT_TEST_PASSED=passed",
+	} {
+		if !strings.Contains(env, want) {
+			t.Errorf("Environment does not contain: [ '%s' ]\n%s", want, env)
+		}
+	}
+	if strings.Contains(env, "DRIVERKIT_TEST_UNSET") {
+		t.Errorf("Environment contains an unset variable\n%s", env)
+	}
+}
+
+func TestLocalScriptModuleSigning(t *testing.T) {
+	workDir := t.TempDir()
+	// a sign-file appending the signature the way the kernel one does
+	signFile := "#!/bin/bash\necho \"$1 $2 $3\" >> $4\nprintf '" + strings.TrimSuffix(moduleSignatureMagic, "\n") + "\\n' >> $4\n"
+	for name, body := range map[string]string{
+		"/tmp/kernel/scripts/sign-file": signFile,
+		moduleSigningKeyPath:            "key",
+		moduleSigningCertPath:           "cert",
+	} {
+		if err := writeLocalFile(localPaths(workDir).Replace(name), body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chmod(localPaths(workDir).Replace("/tmp/kernel/scripts/sign-file"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	script := `#!/bin/bash
+set -xeuo pipefail
+
+rm -Rf /tmp/driver
+mkdir /tmp/driver
+echo module > /tmp/driver/module.ko`
+
+	got, err := exec.Command("/bin/bash", "-c", mustLocalScript(t, withModuleSigning(script), workDir)).CombinedOutput()
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'\n%s", err, got)
+	}
+	module := localPaths(workDir).Replace(builder.ModuleFullPath)
+	if signed, err := isModuleSigned(module); err != nil || !signed {
+		t.Errorf("Got: [ %v, %v ] / Want: [ the module signed ]", signed, err)
+	}
+	if _, err := os.Stat(localPaths(workDir).Replace(moduleSignedPath)); err != nil {
+		t.Errorf("Got: [ %v ] / Want: [ the module marked as signed ]", err)
+	}
+	if _, err := os.Stat(localPaths(workDir).Replace(ModuleSigningDirectory)); !os.IsNotExist(err) {
+		t.Errorf("Got: [ %v ] / Want: [ the key pair removed ]", err)
+	}
+}
+
+// scriptBuilder is a legacy builder whose build script is given.
+type scriptBuilder string
+
+func (s scriptBuilder) Script(context.Context, builder.Config, kernelrelease.KernelRelease) (string, error) {
+	return string(s), nil
+}
+
+func TestLocalScriptDKMS(t *testing.T) {
+	workDir := t.TempDir()
+	// the sources of the driver once the module is built
+	v := scriptBuilder(`#!/bin/bash
+set -xeuo pipefail
+
+rm -Rf /tmp/driver
+mkdir -p /tmp/driver/bpf
+echo main > /tmp/driver/main.c
+echo config > /tmp/driver/driver_config.h
+echo makefile > /tmp/driver/Makefile
+echo generated > /tmp/driver/falco.mod.c
+echo module > /tmp/driver/module.ko
+echo probe > /tmp/driver/bpf/probe.c`)
+	c := builder.Config{DriverName: "falco", Build: &builder.Build{DriverVersion: "7.0.0+driver", DKMSFilePath: "/tmp/falco-dkms.tar.gz"}}
+	script, err := builder.Script(context.Background(), builder.FromLegacy(v), c, kernelrelease.FromString("5.10.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := exec.Command("/bin/bash", "-c", mustLocalScript(t, script, workDir)).CombinedOutput(); err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'\n%s", err, got)
+	}
+
+	f, err := os.Open(localPaths(workDir).Replace(builder.DKMSFullPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	var conf string
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, h.Name)
+		if h.Name == "falco-7.0.0+driver/dkms.conf" {
+			content, _ := ioutil.ReadAll(tr)
+			conf = string(content)
+		}
+	}
+	sort.Strings(names)
+	want := []string{"falco-7.0.0+driver/", "falco-7.0.0+driver/Makefile", "falco-7.0.0+driver/dkms.conf", "falco-7.0.0+driver/driver_config.h", "falco-7.0.0+driver/main.c"}
+	if strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Errorf("Got: [ %v ] / Want: [ %v ]", names, want)
+	}
+	for _, field := range []string{
+		`PACKAGE_NAME="falco"`,
+		`PACKAGE_VERSION="7.0.0+driver"`,
+		`BUILT_MODULE_NAME[0]="falco"`,
+		`AUTOINSTALL="yes"`,
+	} {
+		if !strings.Contains(conf, field+"\n") {
+			t.Errorf("Got: '%s' / Want: '%s' in the dkms.conf", conf, field)
+		}
+	}
+}
+
+func TestLocalScriptArtifactFailures(t *testing.T) {
+	workDir := t.TempDir()
+	// the eBPF probe failed to build, the kernel module was built
+	script := `#!/bin/bash
+set -xeuo pipefail
+
+rm -Rf /tmp/driver
+mkdir -p /tmp/driver/logs
+echo module > /tmp/driver/module.ko
+echo "probe.c:1: error: expected expression" > /tmp/driver/logs/probe.failed`
+
+	if got, err := exec.Command("/bin/bash", "-c", mustLocalScript(t, withArtifactFailures(script), workDir)).CombinedOutput(); err == nil {
+		t.Fatalf("Got: [ no error ] / Want: [ the build failed ]\n%s", got)
+	}
+
+	dir := t.TempDir()
+	b := &builder.Build{ModuleFilePath: filepath.Join(dir, "falco.ko"), ProbeFilePath: filepath.Join(dir, "falco.o")}
+	paths := localPaths(workDir)
+	failures := readArtifactFailures(b, func(name string) ([]byte, error) { return ioutil.ReadFile(paths.Replace(name)) })
+	if len(failures) != 1 || !strings.Contains(failures[ArtifactProbe], "probe.c:1: error") {
+		t.Fatalf("Got: [ %v ] / Want: [ the probe failure ]", failures)
+	}
+	err := copyArtifacts(context.Background(), b, failures, func(from, to string) error { return copyLocalFile(paths.Replace(from), to) })
+	if want := "the eBPF probe failed to build"; err == nil || err.Error() != want {
+		t.Errorf("Got: [ %v ] / Want: [ '%s' ]", err, want)
+	}
+	if content, err := ioutil.ReadFile(b.ModuleFilePath); err != nil || string(content) != "module\n" {
+		t.Errorf("Got: [ '%s', %v ] / Want: [ the module copied ]", content, err)
+	}
+	if _, err := os.Stat(b.ProbeFilePath); !os.IsNotExist(err) {
+		t.Errorf("Got: [ %v ] / Want: [ no probe copied ]", err)
+	}
+}
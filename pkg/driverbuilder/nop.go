@@ -1,6 +1,10 @@
 package driverbuilder
 
-import "github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+import (
+	"context"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+)
 
 type NopBuildProcessor struct {
 }
@@ -13,6 +17,7 @@ func (bp *NopBuildProcessor) String() string {
 	return "no-op"
 }
 
-func (bp *NopBuildProcessor) Start(b *builder.Build) error {
-	return nil
+// Start does nothing, there is no report.
+func (bp *NopBuildProcessor) Start(ctx context.Context, b *builder.Build) (*BuildReport, error) {
+	return nil, nil
 }
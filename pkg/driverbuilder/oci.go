@@ -0,0 +1,121 @@
+package driverbuilder
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// The media types of the OCI artifacts of the drivers.
+const (
+	OCIConfigMediaType types.MediaType = "application/vnd.falcosecurity.driver.config.v1+json"
+	OCIModuleMediaType types.MediaType = "application/vnd.falcosecurity.driver.kmod.v1"
+	OCIProbeMediaType  types.MediaType = "application/vnd.falcosecurity.driver.ebpf.v1"
+	// OCIModernProbeMediaType is the one of the skeleton of the modern eBPF probe.
+	OCIModernProbeMediaType types.MediaType = "application/vnd.falcosecurity.driver.modern-ebpf.v1"
+	// OCIBTFMediaType is the one of the raw BTF of the kernel.
+	OCIBTFMediaType types.MediaType = "application/vnd.falcosecurity.driver.btf.v1"
+	// OCIDKMSMediaType is the one of the DKMS source package of the kernel module, gzip compressed already.
+	OCIDKMSMediaType types.MediaType = "application/vnd.falcosecurity.driver.dkms.v1.tar+gzip"
+)
+
+// ociAnnotationPrefix prefixes the annotations of the OCI artifacts of the drivers describing their build.
+const ociAnnotationPrefix = "io.falcosecurity.driver."
+
+// ociTitleAnnotation is the standard annotation of the file names of the layers.
+const ociTitleAnnotation = "org.opencontainers.image.title"
+
+// newDriverArtifact returns the OCI artifact wrapping the artifacts of the build, one layer each.
+func newDriverArtifact(r *BuildReport, artifacts []ArtifactReport) (v1.Image, error) {
+	adds := []mutate.Addendum{}
+	for _, a := range artifacts {
+		content, err := ioutil.ReadFile(a.Path)
+		if err != nil {
+			return nil, err
+		}
+		mediaType := OCIModuleMediaType
+		switch a.Type {
+		case ArtifactProbe:
+			mediaType = OCIProbeMediaType
+		case ArtifactModernProbe:
+			mediaType = OCIModernProbeMediaType
+		case ArtifactBTF:
+			mediaType = OCIBTFMediaType
+		case ArtifactDKMS:
+			mediaType = OCIDKMSMediaType
+		}
+		// the media types of the compressed artifacts have the suffix of their algorithm, e.g. +gzip
+		if len(a.Compression) > 0 {
+			mediaType += types.MediaType("+" + a.Compression)
+		}
+		adds = append(adds, mutate.Addendum{
+			Layer:       static.NewLayer(content, mediaType),
+			Annotations: map[string]string{ociTitleAnnotation: filepath.Base(a.Path)},
+			MediaType:   mediaType,
+		})
+	}
+	img, err := mutate.Append(empty.Image, adds...)
+	if err != nil {
+		return nil, err
+	}
+	img = mutate.MediaType(img, types.OCIManifestSchema1)
+	img = mutate.ConfigMediaType(img, OCIConfigMediaType)
+	return mutate.Annotations(img, map[string]string{
+		ociAnnotationPrefix + "target":        r.Target,
+		ociAnnotationPrefix + "arch":          r.Architecture,
+		ociAnnotationPrefix + "kernelrelease": r.KernelRelease,
+		ociAnnotationPrefix + "kernelversion": r.KernelVersion,
+		ociAnnotationPrefix + "driverversion": r.DriverVersion,
+	}).(v1.Image), nil
+}
+
+// ociReference returns the reference the artifacts of the build are pushed to, rendered from the template.
+func ociReference(tmpl string, insecure bool, r *BuildReport) (name.Reference, error) {
+	rendered, err := renderBuildTemplate(tmpl, r)
+	if err != nil {
+		return nil, err
+	}
+	opts := []name.Option{}
+	if insecure {
+		opts = append(opts, name.Insecure)
+	}
+	ref, err := name.ParseReference(rendered, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid oci reference %s: %s", rendered, err)
+	}
+	return ref, nil
+}
+
+// pushOCI pushes the artifacts of the build as a single OCI artifact to the templated reference,
+// with the credentials of the docker config and its credential helpers.
+// It returns the reference pushed and the digest of the artifact.
+func pushOCI(ctx context.Context, tmpl string, insecure bool, r *BuildReport, artifacts []ArtifactReport) (string, string, error) {
+	ref, err := ociReference(tmpl, insecure, r)
+	if err != nil {
+		return "", "", err
+	}
+	img, err := newDriverArtifact(r, artifacts)
+	if err != nil {
+		return "", "", err
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		return "", "", err
+	}
+	if err := remote.Write(ref, img, remote.WithContext(ctx), remote.WithAuthFromKeychain(authn.DefaultKeychain)); err != nil {
+		return "", "", fmt.Errorf("unable to push %s: %s", ref, err)
+	}
+	builder.Logger(ctx).WithField("ref", ref.String()).WithField("digest", digest.String()).Info("oci artifact pushed")
+	return ref.String(), digest.String(), nil
+}
@@ -0,0 +1,136 @@
+package driverbuilder
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/metrics"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestBuildReportOCI(t *testing.T) {
+	withEnv(t, "DOCKER_CONFIG", t.TempDir())
+	srv := httptest.NewServer(registry.New(registry.Logger(log.New(ioutil.Discard, "", 0))))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	dir := t.TempDir()
+	b := &builder.Build{
+		TargetType:     builder.TargetTypeVanilla,
+		KernelRelease:  "5.10.0",
+		KernelVersion:  "1",
+		DriverVersion:  "master",
+		Architecture:   "amd64",
+		ModuleFilePath: filepath.Join(dir, "falco.ko"),
+		ProbeFilePath:  filepath.Join(dir, "falco.o"),
+		OCIRef:         host + "/falcosecurity/driver:{{ .DriverVersion }}-{{ .KernelRelease }}",
+		OCIInsecure:    true,
+	}
+	for _, name := range []string{b.ModuleFilePath, b.ProbeFilePath} {
+		if err := ioutil.WriteFile(name, []byte(filepath.Ext(name)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, reporter := startReport(context.Background(), b, BuilderBaseImage)
+	report, err := reporter.complete(context.Background(), b, nil)
+	if err != nil || !report.Success {
+		t.Fatalf("Unexpected error encountered | Error: '%v'", err)
+	}
+	if want := host + "/falcosecurity/driver:master-5.10.0"; report.OCIRef != want || !strings.HasPrefix(report.OCIDigest, "sha256:") {
+		t.Errorf("Got: [ '%s', '%s' ] / Want: [ '%s', its digest ]", report.OCIRef, report.OCIDigest, want)
+	}
+
+	ref, err := name.ParseReference(report.OCIRef, name.Insecure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := remote.Image(ref)
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Config.MediaType != OCIConfigMediaType || manifest.Annotations[ociAnnotationPrefix+"kernelrelease"] != "5.10.0" || manifest.Annotations[ociAnnotationPrefix+"target"] != "vanilla" {
+		t.Errorf("Got: [ %+v ] / Want: [ the driver config and annotations ]", manifest)
+	}
+	if len(manifest.Layers) != 2 || manifest.Layers[0].MediaType != OCIModuleMediaType || manifest.Layers[1].MediaType != OCIProbeMediaType || manifest.Layers[1].Annotations[ociTitleAnnotation] != "falco.o" {
+		t.Errorf("Got: [ %+v ] / Want: [ the module and probe layers ]", manifest.Layers)
+	}
+}
+
+func TestBuildReportOCIFailure(t *testing.T) {
+	withEnv(t, "DOCKER_CONFIG", t.TempDir())
+	srv := httptest.NewServer(registry.New(registry.Logger(log.New(ioutil.Discard, "", 0))))
+	host := strings.TrimPrefix(srv.URL, "http://")
+	srv.Close()
+
+	dir := t.TempDir()
+	b := &builder.Build{
+		TargetType:     builder.TargetTypeVanilla,
+		KernelRelease:  "5.10.0",
+		ModuleFilePath: filepath.Join(dir, "falco.ko"),
+		OCIRef:         host + "/falcosecurity/driver:latest",
+		OCIInsecure:    true,
+	}
+	if err := ioutil.WriteFile(b.ModuleFilePath, []byte("module"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, reporter := startReport(ctx, b, BuilderBaseImage)
+	report, err := reporter.complete(ctx, b, nil)
+	if err == nil || report.Success || report.OCIRef != "" {
+		t.Errorf("Got: [ %v, %v, '%s' ] / Want: [ a failure pushing to the registry down ]", err, report.Success, report.OCIRef)
+	}
+	if _, err := ioutil.ReadFile(b.ModuleFilePath); err != nil {
+		t.Errorf("Got: [ %v ] / Want: [ the module left in place ]", err)
+	}
+}
+
+func TestBuildReportOCIFailureMetrics(t *testing.T) {
+	withEnv(t, "DOCKER_CONFIG", t.TempDir())
+	srv := httptest.NewServer(registry.New(registry.Logger(log.New(ioutil.Discard, "", 0))))
+	host := strings.TrimPrefix(srv.URL, "http://")
+	srv.Close()
+
+	dir := t.TempDir()
+	b := &builder.Build{
+		TargetType:     builder.TargetTypeVanilla,
+		KernelRelease:  "5.10.0",
+		Architecture:   "amd64",
+		ModuleFilePath: filepath.Join(dir, "falco.ko"),
+		OCIRef:         host + "/falcosecurity/driver:latest",
+		OCIInsecure:    true,
+	}
+	if err := ioutil.WriteFile(b.ModuleFilePath, []byte("module"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	succeeded := metrics.BuildsTotal.WithLabelValues("vanilla", "amd64", metrics.StatusSuccess)
+	failed := metrics.BuildsTotal.WithLabelValues("vanilla", "amd64", metrics.StatusFailure)
+	before, beforeFailed := testutil.ToFloat64(succeeded), testutil.ToFloat64(failed)
+
+	// the build succeeded, pushing its artifacts did not
+	ctx, reporter := startReport(context.Background(), b, BuilderBaseImage)
+	if _, err := reporter.complete(ctx, b, nil); err == nil {
+		t.Fatalf("Got: [ nil ] / Want: [ a failure pushing to the registry down ]")
+	}
+	if got := testutil.ToFloat64(failed); got != beforeFailed+1 {
+		t.Errorf("Failed | Got: [ %v ] / Want: [ %v ]", got, beforeFailed+1)
+	}
+	if got := testutil.ToFloat64(succeeded); got != before {
+		t.Errorf("Succeeded | Got: [ %v ] / Want: [ %v ]", got, before)
+	}
+}
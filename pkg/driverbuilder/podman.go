@@ -0,0 +1,81 @@
+package driverbuilder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/client"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+)
+
+// PodmanBuildProcessorName is a constant containing the podman name.
+const PodmanBuildProcessorName = "podman"
+
+// podmanHostEnv is the variable podman reads the URL of the service from.
+const podmanHostEnv = "CONTAINER_HOST"
+
+// PodmanBuildProcessor builds against a podman service, through its docker compatible API.
+type PodmanBuildProcessor struct {
+	docker *DockerBuildProcessor
+}
+
+// NewPodmanBuildProcessor ...
+func NewPodmanBuildProcessor(timeout int, proxy string, caCert string, registry RegistryCredentials, opts ...ProcessorOption) *PodmanBuildProcessor {
+	return &PodmanBuildProcessor{
+		docker: NewDockerBuildProcessor(timeout, proxy, caCert, registry, DockerHost{}, "", opts...),
+	}
+}
+
+func (bp *PodmanBuildProcessor) String() string {
+	return PodmanBuildProcessorName
+}
+
+// Start the podman processor
+func (bp *PodmanBuildProcessor) Start(ctx context.Context, b *builder.Build) (*BuildReport, error) {
+	ctx, reporter := startReport(bp.docker.withLogger(ctx), b, builderImageOf(b))
+	err := bp.start(ctx, b)
+	return reporter.complete(ctx, b, err)
+}
+
+// start runs the build.
+func (bp *PodmanBuildProcessor) start(ctx context.Context, b *builder.Build) error {
+	builder.Logger(ctx).Debug("doing a new podman build")
+	host, err := podmanHost()
+	if err != nil {
+		return err
+	}
+	builder.Logger(ctx).WithField("host", host).Debug("connecting to podman")
+	cli, err := client.NewClientWithOpts(client.WithHost(host), client.WithAPIVersionNegotiation())
+	if err != nil {
+		return err
+	}
+	return bp.docker.build(ctx, cli, b, nil)
+}
+
+// DryRun implements DryRunner, the build stops right before creating the container.
+func (bp *PodmanBuildProcessor) DryRun(ctx context.Context, b *builder.Build, w io.Writer) (*BuildReport, error) {
+	return bp.docker.DryRun(ctx, b, w)
+}
+
+// podmanHost returns the URL of the podman service: the one in CONTAINER_HOST, when set,
+// otherwise the socket of the rootless service of the user or the one of the rootful service when running as root.
+func podmanHost() (string, error) {
+	if host := os.Getenv(podmanHostEnv); len(host) > 0 {
+		if strings.HasPrefix(host, "ssh://") {
+			return "", fmt.Errorf("ssh connections to podman are not supported, forward the remote socket (e.g. ssh -L) and set %s to its unix:// or tcp:// URL", podmanHostEnv)
+		}
+		return host, nil
+	}
+	if os.Geteuid() == 0 {
+		return "unix:///run/podman/podman.sock", nil
+	}
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if len(runtimeDir) == 0 {
+		runtimeDir = fmt.Sprintf("/run/user/%d", os.Getuid())
+	}
+	return "unix://" + filepath.Join(runtimeDir, "podman", "podman.sock"), nil
+}
@@ -0,0 +1,54 @@
+//go:build integration
+// +build integration
+
+package driverbuilder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/client"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+)
+
+// Run with: go test -tags integration -run Podman ./pkg/driverbuilder
+// against a running podman service (e.g. systemctl --user start podman.socket).
+func TestPodmanBuildProcessorIntegration(t *testing.T) {
+	host, err := podmanHost()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli, err := client.NewClientWithOpts(client.WithHost(host), client.WithAPIVersionNegotiation())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cli.Ping(context.Background()); err != nil {
+		t.Fatalf("podman service not reachable at %s | Error: '%s'", host, err)
+	}
+
+	dir, err := os.MkdirTemp("", "driverkit-podman-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b := &builder.Build{
+		TargetType:       builder.TargetTypeDebian,
+		KernelRelease:    "5.10.0-12-amd64",
+		KernelVersion:    "1",
+		DriverVersion:    "master",
+		Architecture:     "amd64",
+		KernelConfigData: builder.NoKernelConfigData,
+		ProbeFilePath:    filepath.Join(dir, "falco.o"),
+		ModuleDriverName: "falco",
+		ModuleDeviceName: "falco",
+	}
+	if _, err := NewPodmanBuildProcessor(600, "", "", RegistryCredentials{}).Start(context.Background(), b); err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if _, err := os.Stat(b.ProbeFilePath); err != nil {
+		t.Errorf("eBPF probe not copied out of the builder | Error: '%s'", err)
+	}
+}
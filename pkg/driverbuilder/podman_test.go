@@ -0,0 +1,40 @@
+package driverbuilder
+
+import (
+	"os"
+	"testing"
+)
+
+func withEnv(t *testing.T, key, value string) {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func TestPodmanHost(t *testing.T) {
+	withEnv(t, podmanHostEnv, "tcp://build-host:8888")
+	if got, err := podmanHost(); err != nil || got != "tcp://build-host:8888" {
+		t.Errorf("Got: [ '%s', %v ] / Want: [ 'tcp://build-host:8888' ]", got, err)
+	}
+
+	withEnv(t, podmanHostEnv, "ssh://core@build-host/run/podman/podman.sock")
+	if _, err := podmanHost(); err == nil {
+		t.Errorf("Expecting an error for ssh connections")
+	}
+
+	withEnv(t, podmanHostEnv, "")
+	withEnv(t, "XDG_RUNTIME_DIR", "/run/user/1000")
+	want := "unix:///run/user/1000/podman/podman.sock"
+	if os.Geteuid() == 0 {
+		want = "unix:///run/podman/podman.sock"
+	}
+	if got, err := podmanHost(); err != nil || got != want {
+		t.Errorf("Got: [ '%s', %v ] / Want: [ '%s' ]", got, err, want)
+	}
+}
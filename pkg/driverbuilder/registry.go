@@ -0,0 +1,131 @@
+package driverbuilder
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// dockerHubRegistry is the key the docker config stores the Docker Hub credentials with.
+const dockerHubRegistry = "https://index.docker.io/v1/"
+
+// RegistryCredentials are the credentials the builder image is pulled with.
+// The username and password win over the docker config, the default one is used when no file is given.
+type RegistryCredentials struct {
+	Username   string
+	Password   string
+	ConfigFile string
+}
+
+// dockerConfig is the part of the docker config file holding the registries credentials.
+type dockerConfig struct {
+	Auths map[string]types.AuthConfig `json:"auths"`
+}
+
+// defaultDockerConfig returns the docker config file of the user, honoring DOCKER_CONFIG.
+func defaultDockerConfig() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json")
+	}
+	home, err := homedir.Dir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".docker", "config.json")
+}
+
+// readDockerConfig reads the registries credentials of a docker config file,
+// both the config.json and the .dockerconfigjson of the kubernetes secrets have the same format.
+func readDockerConfig(name string) (dockerConfig, error) {
+	config := dockerConfig{}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("invalid docker config %s: %s", name, err)
+	}
+	return config, nil
+}
+
+// imageRegistry returns the registry the image is pulled from, as keyed by the docker config.
+func imageRegistry(image string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", err
+	}
+	return normalizeRegistry(reference.Domain(named)), nil
+}
+
+// normalizeRegistry strips the scheme and the path of a docker config key, if any, mapping Docker Hub to its key.
+func normalizeRegistry(registry string) string {
+	registry = strings.TrimPrefix(strings.TrimPrefix(registry, "https://"), "http://")
+	registry = strings.SplitN(registry, "/", 2)[0]
+	if registry == "docker.io" || registry == "index.docker.io" || registry == "registry-1.docker.io" {
+		return dockerHubRegistry
+	}
+	return registry
+}
+
+// registryAuth returns the encoded credentials the image is pulled with, empty when there are none.
+func registryAuth(image string, creds RegistryCredentials) (string, error) {
+	registry, err := imageRegistry(image)
+	if err != nil {
+		return "", err
+	}
+
+	auth := types.AuthConfig{}
+	if creds.Username != "" {
+		auth.Username = creds.Username
+		auth.Password = creds.Password
+	} else {
+		configFile := creds.ConfigFile
+		if configFile == "" {
+			configFile = defaultDockerConfig()
+			if _, err := os.Stat(configFile); err != nil {
+				return "", nil
+			}
+		}
+		config, err := readDockerConfig(configFile)
+		if err != nil {
+			return "", err
+		}
+		found := false
+		for key, a := range config.Auths {
+			if normalizeRegistry(key) == registry {
+				auth, found = a, true
+				break
+			}
+		}
+		if !found {
+			return "", nil
+		}
+		// the daemon wants the username and password, the docker config only stores them encoded together
+		if auth.Auth != "" && auth.Username == "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return "", fmt.Errorf("invalid credentials of %s in the docker config: %s", registry, err)
+			}
+			userPassword := strings.SplitN(string(decoded), ":", 2)
+			if len(userPassword) != 2 {
+				return "", fmt.Errorf("invalid credentials of %s in the docker config", registry)
+			}
+			auth.Username, auth.Password, auth.Auth = userPassword[0], userPassword[1], ""
+		}
+	}
+	auth.ServerAddress = registry
+
+	data, err := json.Marshal(auth)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(data), nil
+}
@@ -0,0 +1,76 @@
+package driverbuilder
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func decodeRegistryAuth(t *testing.T, encoded string) types.AuthConfig {
+	data, err := base64.URLEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := types.AuthConfig{}
+	if err := json.Unmarshal(data, &auth); err != nil {
+		t.Fatal(err)
+	}
+	return auth
+}
+
+func TestImageRegistry(t *testing.T) {
+	tests := map[string]string{
+		"falcosecurity/driverkit-builder:latest":              dockerHubRegistry,
+		"docker.io/falcosecurity/driverkit-builder":           dockerHubRegistry,
+		"quay.io/falcosecurity/driverkit-builder:latest":      "quay.io",
+		"registry.local:5000/falcosecurity/driverkit-builder": "registry.local:5000",
+	}
+	for image, want := range tests {
+		if got, err := imageRegistry(image); err != nil || got != want {
+			t.Errorf("Image %s | Got: [ '%s', %v ] / Want: [ '%s' ]", image, got, err, want)
+		}
+	}
+}
+
+func TestRegistryAuth(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "config.json")
+	err := ioutil.WriteFile(config, []byte(`{"auths": {
+		"https://index.docker.io/v1/": {"auth": "`+base64.StdEncoding.EncodeToString([]byte("hub:secret"))+`"},
+		"https://quay.io": {"username": "quay", "password": "token"}
+	}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := registryAuth("falcosecurity/driverkit-builder", RegistryCredentials{ConfigFile: config})
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if got := decodeRegistryAuth(t, encoded); got.Username != "hub" || got.Password != "secret" || got.ServerAddress != dockerHubRegistry {
+		t.Errorf("Got: [ %v ] / Want: [ the decoded Docker Hub credentials ]", got)
+	}
+
+	encoded, err = registryAuth("quay.io/falcosecurity/driverkit-builder", RegistryCredentials{ConfigFile: config})
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if got := decodeRegistryAuth(t, encoded); got.Username != "quay" || got.Password != "token" {
+		t.Errorf("Got: [ %v ] / Want: [ the quay.io credentials ]", got)
+	}
+
+	encoded, err = registryAuth("quay.io/falcosecurity/driverkit-builder", RegistryCredentials{Username: "user", Password: "password", ConfigFile: config})
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if got := decodeRegistryAuth(t, encoded); got.Username != "user" || got.Password != "password" {
+		t.Errorf("Got: [ %v ] / Want: [ the given credentials winning over the docker config ]", got)
+	}
+
+	if encoded, err := registryAuth("ghcr.io/falcosecurity/driverkit-builder", RegistryCredentials{ConfigFile: config}); err != nil || encoded != "" {
+		t.Errorf("Got: [ '%s', %v ] / Want: [ no credentials ]", encoded, err)
+	}
+}
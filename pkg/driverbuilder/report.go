@@ -0,0 +1,475 @@
+package driverbuilder
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/metrics"
+	"gopkg.in/yaml.v3"
+)
+
+// ArtifactModule, ArtifactProbe, ArtifactModernProbe, ArtifactBTF and ArtifactDKMS are the types of the artifacts of a build.
+const (
+	ArtifactModule      = "module"
+	ArtifactProbe       = "probe"
+	ArtifactModernProbe = "modern_probe"
+	ArtifactBTF         = "btf"
+	ArtifactDKMS        = "dkms"
+)
+
+// artifactNames are the names the artifacts are logged with, by type.
+var artifactNames = map[string]string{
+	ArtifactModule:      "kernel module",
+	ArtifactProbe:       "eBPF probe",
+	ArtifactModernProbe: "modern eBPF probe",
+	ArtifactBTF:         "BTF of the kernel",
+	ArtifactDKMS:        "DKMS package",
+}
+
+// BuildReport describes a build and the artifacts it produced.
+type BuildReport struct {
+	ID              string           `json:"id" yaml:"id"`
+	Target          string           `json:"target" yaml:"target"`
+	Architecture    string           `json:"architecture" yaml:"architecture"`
+	KernelRelease   string           `json:"kernelrelease" yaml:"kernelrelease"`
+	KernelVersion   string           `json:"kernelversion" yaml:"kernelversion"`
+	DriverVersion   string           `json:"driverversion" yaml:"driverversion"`
+	KernelURLs      []string         `json:"kernelurls" yaml:"kernelurls"`
+	BuilderImage    string           `json:"builderimage,omitempty" yaml:"builderimage,omitempty"`
+	BuilderTemplate string           `json:"builder_template,omitempty" yaml:"builder_template,omitempty"`
+	BuildLog        string           `json:"build_log,omitempty" yaml:"build_log,omitempty"`
+	StartedAt       time.Time        `json:"started_at" yaml:"started_at"`
+	DurationSeconds float64          `json:"duration_seconds" yaml:"duration_seconds"`
+	Timings         BuildTimings     `json:"timings" yaml:"timings"`
+	Success         bool             `json:"success" yaml:"success"`
+	Partial         bool             `json:"partial,omitempty" yaml:"partial,omitempty"`
+	Cached          bool             `json:"cached,omitempty" yaml:"cached,omitempty"`
+	Error           string           `json:"error,omitempty" yaml:"error,omitempty"`
+	Artifacts       []ArtifactReport `json:"artifacts" yaml:"artifacts"`
+	OCIRef          string           `json:"oci_ref,omitempty" yaml:"oci_ref,omitempty"`
+	OCIDigest       string           `json:"oci_digest,omitempty" yaml:"oci_digest,omitempty"`
+	Ccache          *CcacheReport    `json:"ccache,omitempty" yaml:"ccache,omitempty"`
+}
+
+// BuildTimings splits the duration of a build: the resolution of the kernel packages, the build itself,
+// then the checks, the compression and the publication of the artifacts.
+type BuildTimings struct {
+	ResolutionSeconds float64 `json:"resolution_seconds" yaml:"resolution_seconds"`
+	BuildSeconds      float64 `json:"build_seconds" yaml:"build_seconds"`
+	ArtifactsSeconds  float64 `json:"artifacts_seconds" yaml:"artifacts_seconds"`
+}
+
+// ArtifactReport describes an artifact of a build, the kernel module or the eBPF probe.
+type ArtifactReport struct {
+	Type         string `json:"type" yaml:"type"`
+	Path         string `json:"path" yaml:"path"`
+	Compression  string `json:"compression,omitempty" yaml:"compression,omitempty"`
+	Signed       bool   `json:"signed" yaml:"signed"`
+	SHA256       string `json:"sha256,omitempty" yaml:"sha256,omitempty"`
+	SHA512       string `json:"sha512,omitempty" yaml:"sha512,omitempty"`
+	ChecksumFile string `json:"checksum_file,omitempty" yaml:"checksum_file,omitempty"`
+	URL          string `json:"url,omitempty" yaml:"url,omitempty"`
+	ChecksumURL  string `json:"checksum_url,omitempty" yaml:"checksum_url,omitempty"`
+	Success      bool   `json:"success" yaml:"success"`
+	Error        string `json:"error,omitempty" yaml:"error,omitempty"`
+	Output       string `json:"output,omitempty" yaml:"output,omitempty"`
+}
+
+// ErrPartialBuild is wrapped by the errors of the builds that produced only some of their artifacts, the report tells which ones failed.
+var ErrPartialBuild = errors.New("partial build")
+
+// buildReporter fills the report of a build while it runs.
+type buildReporter struct {
+	report     *BuildReport
+	resolved   func() []string
+	resolution func() time.Duration
+	ccache     *ccacheStatsWriter
+	buildLog   *buildLogFile
+	outputs    map[string]time.Time
+}
+
+// startReport starts the report of the build, the returned context records the kernel URLs it resolves and the time it takes,
+// and logs with the fields of the build. The build is counted as in flight until completed.
+// The output of the build script is saved into the build log file of the build, if any, until completed.
+func startReport(ctx context.Context, b *builder.Build, image string) (context.Context, *buildReporter) {
+	ctx, id := withBuildLogger(ctx, b)
+	ctx, resolved := builder.WithResolvedURLs(ctx)
+	ctx, resolution := builder.WithResolutionTime(ctx)
+	metrics.BuildsInFlight.Inc()
+	if len(image) > 0 {
+		builder.Logger(ctx).WithField("image", image).Info("using the builder image")
+	}
+	report := newBuildReport(id, b, image)
+	ctx, buildLog := withBuildLogFile(ctx, b, report, resolved)
+	var ccache *ccacheStatsWriter
+	if len(b.CcacheDir) > 0 {
+		ctx, ccache = withCcacheStats(ctx)
+	}
+	return ctx, &buildReporter{
+		report:     report,
+		resolved:   resolved,
+		resolution: resolution,
+		ccache:     ccache,
+		buildLog:   buildLog,
+		outputs:    outputsModTimes(b),
+	}
+}
+
+// outputsModTimes returns the modification times of the artifacts of the build before it runs, zero when missing.
+func outputsModTimes(b *builder.Build) map[string]time.Time {
+	outputs := map[string]time.Time{}
+	for _, path := range []string{b.ModuleFilePath, b.ProbeFilePath, b.ModernProbeFilePath, b.BTFFilePath, b.DKMSFilePath} {
+		if len(path) == 0 {
+			continue
+		}
+		outputs[path] = time.Time{}
+		if info, err := os.Stat(path); err == nil {
+			outputs[path] = info.ModTime()
+		}
+	}
+	return outputs
+}
+
+// removeInterruptedOutputs removes the artifacts the interrupted build wrote, maybe partially, the ones it did not touch are left as they are.
+func (r *buildReporter) removeInterruptedOutputs(ctx context.Context) {
+	for path, before := range r.outputs {
+		info, err := os.Stat(path)
+		if err != nil || info.ModTime().Equal(before) {
+			continue
+		}
+		log := builder.Logger(ctx).WithField("path", path)
+		if err := os.Remove(path); err != nil {
+			log.WithError(err).Warn("unable to remove the artifact of the interrupted build")
+			continue
+		}
+		log.Info("removed the artifact of the interrupted build, it may be partial")
+	}
+}
+
+// newBuildReport returns the report of the build starting now, with the builder image it runs into if any.
+func newBuildReport(id string, b *builder.Build, image string) *BuildReport {
+	return &BuildReport{
+		ID:              id,
+		Target:          b.TargetType.String(),
+		Architecture:    b.Architecture,
+		KernelRelease:   b.KernelRelease,
+		KernelVersion:   b.KernelVersion,
+		DriverVersion:   b.DriverVersion,
+		BuilderImage:    image,
+		BuilderTemplate: b.TemplateOverride,
+		StartedAt:       time.Now(),
+	}
+}
+
+// complete completes the report once the build is done, with its error if any, checking the artifacts it produced.
+// The artifacts are compressed when asked to, then their checksum files are written next to them and they are uploaded or pushed when asked to,
+// the error of the build is returned unless it is one of these steps that fails.
+// When only the kernel module or the eBPF probe failed to build, the other artifacts are handled nonetheless and the failed ones get the tail of their build log;
+// the error returned wraps ErrPartialBuild when some artifacts succeeded while others did not.
+func (r *buildReporter) complete(ctx context.Context, b *builder.Build, err error) (*BuildReport, error) {
+	report := r.report
+	r.startTimings()
+	report.KernelURLs = r.resolved()
+	if r.ccache != nil {
+		report.Ccache = r.ccache.report()
+	}
+	report.Success = err == nil
+	if err != nil {
+		report.Error = err.Error()
+		// canceled or timed out
+		if ctx.Err() != nil {
+			r.removeInterruptedOutputs(ctx)
+		}
+	}
+	report.Artifacts = []ArtifactReport{}
+	failures, _ := err.(artifactsFailedError)
+	var uploader *s3manager.Uploader
+	for _, artifact := range []struct {
+		ArtifactReport
+		s3URL string
+	}{
+		{ArtifactReport{Type: ArtifactModule, Path: b.ModuleFilePath}, b.ModuleS3URL},
+		{ArtifactReport{Type: ArtifactProbe, Path: b.ProbeFilePath}, b.ProbeS3URL},
+		{ArtifactReport{Type: ArtifactModernProbe, Path: b.ModernProbeFilePath}, ""},
+		{ArtifactReport{Type: ArtifactBTF, Path: b.BTFFilePath}, ""},
+		{ArtifactReport{Type: ArtifactDKMS, Path: b.DKMSFilePath}, ""},
+	} {
+		a := artifact.ArtifactReport
+		if len(a.Path) == 0 {
+			continue
+		}
+		artifactErr := err
+		if failures != nil {
+			artifactErr = nil
+			if output, failed := failures[a.Type]; failed {
+				artifactErr = fmt.Errorf("the %s failed to build", artifactNames[a.Type])
+				a.Output = output
+			}
+		}
+		// the BTF is optional, the successful builds skip it when the debug package of the kernel was not found
+		if a.Type == ArtifactBTF && artifactErr == nil {
+			if _, statErr := os.Stat(a.Path); os.IsNotExist(statErr) {
+				continue
+			}
+		}
+		// the artifacts not built are not checked, compressed nor uploaded
+		if artifactErr == nil && a.Type == ArtifactModule && len(b.ModuleSigningKey) > 0 {
+			if a.Signed, artifactErr = isModuleSigned(a.Path); artifactErr == nil && !a.Signed {
+				artifactErr = fmt.Errorf("the kernel module was not signed")
+			}
+		}
+		// the DKMS package is a tarball compressed already
+		if artifactErr == nil && len(b.Compression) > 0 && a.Type != ArtifactDKMS {
+			if artifactErr = compressArtifact(&a, b.Compression); artifactErr == nil {
+				a.Compression = b.Compression
+			}
+		}
+		if artifactErr == nil {
+			artifactErr = checksumArtifact(&a, b.Checksum)
+		}
+		if artifactErr == nil && len(artifact.s3URL) > 0 {
+			if uploader == nil {
+				uploader, artifactErr = newS3Uploader(b.S3Endpoint)
+			}
+			if artifactErr == nil {
+				artifactErr = publishArtifact(ctx, uploader, &a, artifact.s3URL, report)
+			}
+		}
+		a.Success = artifactErr == nil
+		if artifactErr != nil {
+			a.Error = artifactErr.Error()
+			report.Success = false
+			if err == nil {
+				err = artifactErr
+			}
+		}
+		report.Artifacts = append(report.Artifacts, a)
+	}
+	if len(b.OCIRef) > 0 {
+		if pushErr := r.push(ctx, b); pushErr != nil {
+			report.Success = false
+			if err == nil {
+				err = pushErr
+			}
+		}
+	}
+	if err != nil && report.partial() {
+		report.Partial = true
+		err = fmt.Errorf("%w: %s", ErrPartialBuild, err)
+	}
+	r.closeBuildLog(ctx, err)
+	r.completeTimings()
+	// counted with the error of the steps handling the artifacts as well
+	r.observe(ctx, err)
+	return report, err
+}
+
+// partial tells whether some of the artifacts of the build succeeded while others did not.
+func (r *BuildReport) partial() bool {
+	succeeded, failed := false, false
+	for _, a := range r.Artifacts {
+		succeeded = succeeded || a.Success
+		failed = failed || !a.Success
+	}
+	return succeeded && failed
+}
+
+// completeWithoutArtifacts completes the report of a build producing no artifacts, e.g. a dry run, with its error if any.
+func (r *buildReporter) completeWithoutArtifacts(err error) (*BuildReport, error) {
+	report := r.report
+	r.startTimings()
+	// nothing was built, so there is nothing to count either
+	defer metrics.BuildsInFlight.Dec()
+	report.KernelURLs = r.resolved()
+	report.Success = err == nil
+	if err != nil {
+		report.Error = err.Error()
+	}
+	report.Artifacts = []ArtifactReport{}
+	r.closeBuildLog(context.Background(), err)
+	r.completeTimings()
+	return report, err
+}
+
+// closeBuildLog ends the build log file of the build, if any, with the error of the build.
+func (r *buildReporter) closeBuildLog(ctx context.Context, err error) {
+	if r.buildLog == nil {
+		return
+	}
+	if closeErr := r.buildLog.close(err); closeErr != nil {
+		builder.Logger(ctx).WithError(closeErr).WithField("path", r.report.BuildLog).Warn("unable to save the build log")
+	}
+}
+
+// startTimings records the time taken by the build, the artifacts are handled from now on.
+func (r *buildReporter) startTimings() {
+	t := &r.report.Timings
+	t.ResolutionSeconds = r.resolution().Seconds()
+	t.BuildSeconds = time.Since(r.report.StartedAt).Seconds() - t.ResolutionSeconds
+}
+
+// completeTimings records the time taken by the artifacts, and the duration of the whole build.
+func (r *buildReporter) completeTimings() {
+	t := &r.report.Timings
+	r.report.DurationSeconds = time.Since(r.report.StartedAt).Seconds()
+	t.ArtifactsSeconds = r.report.DurationSeconds - t.ResolutionSeconds - t.BuildSeconds
+}
+
+// observe counts the build completed with the error, if any, into the metrics.
+func (r *buildReporter) observe(ctx context.Context, err error) {
+	metrics.BuildsInFlight.Dec()
+	status := metrics.Status(ctx, err)
+	metrics.BuildsTotal.WithLabelValues(r.report.Target, r.report.Architecture, status).Inc()
+	metrics.BuildDuration.WithLabelValues(r.report.Target, r.report.Architecture, status).Observe(r.report.DurationSeconds)
+}
+
+// push pushes the artifacts built as a single OCI artifact, the local ones are left untouched whatever happens.
+func (r *buildReporter) push(ctx context.Context, b *builder.Build) error {
+	artifacts := []ArtifactReport{}
+	for _, a := range r.report.Artifacts {
+		if a.Success {
+			artifacts = append(artifacts, a)
+		}
+	}
+	if len(artifacts) == 0 {
+		return nil
+	}
+	ref, digest, err := pushOCI(ctx, b.OCIRef, b.OCIInsecure, r.report, artifacts)
+	if err != nil {
+		return err
+	}
+	r.report.OCIRef, r.report.OCIDigest = ref, digest
+	return nil
+}
+
+// renderBuildTemplate renders the template with the details of the build, e.g. s3://bucket/{{ .DriverVersion }}/{{ .Architecture }}/falco_{{ .Target }}_{{ .KernelRelease }}_{{ .KernelVersion }}.ko.
+// The driver version is the short one of the git refs, e.g. the short hash of a commit.
+func renderBuildTemplate(tmpl string, r *BuildReport) (string, error) {
+	t, err := template.New("build").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("invalid template %s: %s", tmpl, err)
+	}
+	short := *r
+	short.DriverVersion = builder.ShortDriverVersion(r.DriverVersion)
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, &short); err != nil {
+		return "", fmt.Errorf("invalid template %s: %s", tmpl, err)
+	}
+	return buf.String(), nil
+}
+
+// moduleSignatureMagic ends the kernel modules signed by sign-file.
+const moduleSignatureMagic = "~Module signature appended~\n"
+
+// isModuleSigned tells whether the kernel module has a signature appended.
+func isModuleSigned(name string) (bool, error) {
+	content, err := ioutil.ReadFile(name)
+	if err != nil {
+		return false, err
+	}
+	return bytes.HasSuffix(content, []byte(moduleSignatureMagic)), nil
+}
+
+// checksumArtifact computes the checksums of the artifact, writing the checksum file of the algorithm next to it, if any.
+// The SHA256 is always computed.
+func checksumArtifact(a *ArtifactReport, algorithm string) error {
+	sha256Sum, sha512Sum, err := fileChecksums(a.Path)
+	if err != nil {
+		return err
+	}
+	a.SHA256 = sha256Sum
+
+	var sum string
+	switch algorithm {
+	case "":
+		return nil
+	case "sha256":
+		sum = a.SHA256
+	case "sha512":
+		a.SHA512 = sha512Sum
+		sum = a.SHA512
+	default:
+		return fmt.Errorf("unsupported checksum algorithm %s", algorithm)
+	}
+	// the sha256sum format, checkable from the directory of the artifact
+	a.ChecksumFile = a.Path + "." + algorithm
+	content := fmt.Sprintf("%s  %s\n", sum, filepath.Base(a.Path))
+	if err := ioutil.WriteFile(a.ChecksumFile, []byte(content), 0644); err != nil {
+		return err
+	}
+	return nil
+}
+
+// fileChecksums returns the SHA256 and the SHA512 of the file, hex encoded.
+func fileChecksums(name string) (string, string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+	sha256Hash, sha512Hash := sha256.New(), sha512.New()
+	if _, err := io.Copy(io.MultiWriter(sha256Hash, sha512Hash), f); err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(sha256Hash.Sum(nil)), hex.EncodeToString(sha512Hash.Sum(nil)), nil
+}
+
+// Open opens the artifact of the type built successfully, either ArtifactModule, ArtifactProbe, ArtifactModernProbe, ArtifactBTF or ArtifactDKMS,
+// so that it can be streamed wherever needed, compressed when asked to.
+func (r *BuildReport) Open(artifactType string) (io.ReadCloser, error) {
+	for _, a := range r.Artifacts {
+		if a.Type != artifactType {
+			continue
+		}
+		if !a.Success {
+			return nil, fmt.Errorf("the %s was not built: %s", artifactType, a.Error)
+		}
+		return os.Open(a.Path)
+	}
+	return nil, fmt.Errorf("the build has no %s", artifactType)
+}
+
+// Write writes the report in the format, either json or yaml.
+func (r *BuildReport) Write(w io.Writer, format string) error {
+	return encodeReport(w, format, r)
+}
+
+// WriteBatchReport writes the reports of the builds of a batch in the format, either json or yaml.
+// The builds not run have no report.
+func WriteBatchReport(w io.Writer, format string, results []BatchResult) error {
+	reports := []*BuildReport{}
+	for _, res := range results {
+		if res.Report != nil {
+			reports = append(reports, res.Report)
+		}
+	}
+	return encodeReport(w, format, reports)
+}
+
+func encodeReport(w io.Writer, format string, v interface{}) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case "yaml":
+		enc := yaml.NewEncoder(w)
+		defer enc.Close()
+		return enc.Encode(v)
+	}
+	return fmt.Errorf("unsupported report format %s", format)
+}
@@ -0,0 +1,341 @@
+package driverbuilder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestBuildReport(t *testing.T) {
+	dir := t.TempDir()
+	b := &builder.Build{
+		TargetType:     builder.TargetTypeVanilla,
+		KernelRelease:  "5.10.0",
+		KernelVersion:  "1",
+		DriverVersion:  "master",
+		Architecture:   "amd64",
+		ModuleFilePath: filepath.Join(dir, "falco.ko"),
+		ProbeFilePath:  filepath.Join(dir, "falco.o"),
+	}
+	if err := ioutil.WriteFile(b.ModuleFilePath, []byte("module"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, reporter := startReport(context.Background(), b, BuilderBaseImage)
+	report, err := reporter.complete(context.Background(), b, nil)
+	if err == nil || report.Success || report.Error != "" {
+		t.Errorf("Got: [ %v, %v, '%s' ] / Want: [ a failure because of the missing probe ]", err, report.Success, report.Error)
+	}
+	if len(report.Artifacts) != 2 {
+		t.Fatalf("Got: [ %d artifacts ] / Want: [ 2 ]", len(report.Artifacts))
+	}
+	// sha256 of "module"
+	want := "120970d812836f19888625587a4606a5ad23cef31c8684e601771552548fc6b9"
+	if module := report.Artifacts[0]; module.Type != ArtifactModule || !module.Success || module.SHA256 != want {
+		t.Errorf("Got: [ %+v ] / Want: [ the module with sha256 %s ]", module, want)
+	}
+	if probe := report.Artifacts[1]; probe.Type != ArtifactProbe || probe.Success || probe.Error == "" {
+		t.Errorf("Got: [ %+v ] / Want: [ the missing probe ]", probe)
+	}
+	if report.BuilderImage != BuilderBaseImage || report.Target != "vanilla" || report.KernelRelease != "5.10.0" {
+		t.Errorf("Got: [ %+v ] / Want: [ the build details ]", report)
+	}
+
+	_, reporter = startReport(context.Background(), b, "")
+	report, err = reporter.complete(context.Background(), b, errors.New("build failed"))
+	if err == nil || err.Error() != "build failed" || report.Success || report.Error != "build failed" || report.Artifacts[0].Success || report.Artifacts[0].SHA256 != "" {
+		t.Errorf("Got: [ %+v ] / Want: [ the failed build ]", report)
+	}
+}
+
+func TestBuildReportPartial(t *testing.T) {
+	dir := t.TempDir()
+	b := &builder.Build{
+		TargetType:     builder.TargetTypeVanilla,
+		ModuleFilePath: filepath.Join(dir, "falco.ko"),
+		ProbeFilePath:  filepath.Join(dir, "falco.o"),
+	}
+	if err := ioutil.WriteFile(b.ModuleFilePath, []byte("module"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, reporter := startReport(context.Background(), b, "")
+	report, err := reporter.complete(context.Background(), b, artifactsFailedError{ArtifactProbe: "probe.c:1: error: expected expression\n"})
+	if !errors.Is(err, ErrPartialBuild) || report.Success || !report.Partial || report.Error != "the eBPF probe failed to build" {
+		t.Errorf("Got: [ %v, %v, %v, '%s' ] / Want: [ the partial build ]", err, report.Success, report.Partial, report.Error)
+	}
+	if module := report.Artifacts[0]; !module.Success || module.SHA256 == "" || module.Output != "" {
+		t.Errorf("Got: [ %+v ] / Want: [ the module built ]", module)
+	}
+	if probe := report.Artifacts[1]; probe.Success || probe.Error != "the eBPF probe failed to build" || probe.Output != "probe.c:1: error: expected expression\n" {
+		t.Errorf("Got: [ %+v ] / Want: [ the failed probe with the tail of its build log ]", probe)
+	}
+
+	// nothing was built
+	_, reporter = startReport(context.Background(), b, "")
+	report, err = reporter.complete(context.Background(), b, artifactsFailedError{ArtifactModule: "", ArtifactProbe: ""})
+	if err == nil || errors.Is(err, ErrPartialBuild) || report.Partial || err.Error() != "the kernel module and the eBPF probe failed to build" {
+		t.Errorf("Got: [ %v, %v ] / Want: [ the failed build ]", err, report.Partial)
+	}
+}
+
+func TestBuildReportInterrupted(t *testing.T) {
+	dir := t.TempDir()
+	b := &builder.Build{
+		TargetType:     builder.TargetTypeVanilla,
+		KernelRelease:  "5.10.0",
+		KernelVersion:  "1",
+		DriverVersion:  "master",
+		Architecture:   "amd64",
+		ModuleFilePath: filepath.Join(dir, "falco.ko"),
+		ProbeFilePath:  filepath.Join(dir, "falco.o"),
+	}
+	// the probe of a previous build is left untouched by the interrupted one
+	if err := ioutil.WriteFile(b.ProbeFilePath, []byte("probe"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx, reporter := startReport(ctx, b, BuilderBaseImage)
+	if err := ioutil.WriteFile(b.ModuleFilePath, []byte("mod"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	report, err := reporter.complete(ctx, b, ctx.Err())
+	if !errors.Is(err, context.Canceled) || report.Success {
+		t.Errorf("Got: [ %v, %+v ] / Want: [ the canceled build report ]", err, report)
+	}
+	if _, err := os.Stat(b.ModuleFilePath); !os.IsNotExist(err) {
+		t.Errorf("Got: [ %v ] / Want: [ the partial module removed ]", err)
+	}
+	if got, _ := ioutil.ReadFile(b.ProbeFilePath); string(got) != "probe" {
+		t.Errorf("Got: [ '%s' ] / Want: [ 'probe' ]", got)
+	}
+}
+
+func TestBuildReportChecksum(t *testing.T) {
+	dir := t.TempDir()
+	b := &builder.Build{
+		TargetType:     builder.TargetTypeVanilla,
+		ModuleFilePath: filepath.Join(dir, "falco.ko"),
+		Checksum:       "sha512",
+	}
+	if err := ioutil.WriteFile(b.ModuleFilePath, []byte("module"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, reporter := startReport(context.Background(), b, "")
+	report, err := reporter.complete(context.Background(), b, nil)
+	if err != nil || !report.Success {
+		t.Fatalf("Unexpected error encountered | Error: '%v'", err)
+	}
+	module := report.Artifacts[0]
+	if module.SHA256 == "" || module.SHA512 == "" || module.ChecksumFile != b.ModuleFilePath+".sha512" {
+		t.Errorf("Got: [ %+v ] / Want: [ both checksums and the sha512 checksum file ]", module)
+	}
+	content, err := ioutil.ReadFile(module.ChecksumFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := module.SHA512 + "  falco.ko\n"; string(content) != want {
+		t.Errorf("Got: [ '%s' ] / Want: [ '%s' ]", content, want)
+	}
+
+	b.Checksum = ""
+	if err := os.Remove(module.ChecksumFile); err != nil {
+		t.Fatal(err)
+	}
+	_, reporter = startReport(context.Background(), b, "")
+	if report, err = reporter.complete(context.Background(), b, nil); err != nil || report.Artifacts[0].ChecksumFile != "" {
+		t.Errorf("Got: [ %+v, %v ] / Want: [ no checksum file ]", report.Artifacts[0], err)
+	}
+	if _, err := os.Stat(module.ChecksumFile); !os.IsNotExist(err) {
+		t.Errorf("Got: [ %v ] / Want: [ no checksum file written ]", err)
+	}
+}
+
+func TestBuildReportWrite(t *testing.T) {
+	report := &BuildReport{
+		Target:     "vanilla",
+		KernelURLs: []string{"https://cdn.kernel.org/pub/linux/kernel/v5.x/linux-5.10.tar.xz"},
+		Success:    true,
+		Artifacts:  []ArtifactReport{{Type: ArtifactModule, Path: "/tmp/falco.ko", SHA256: "abc", Success: true}},
+	}
+
+	var buf bytes.Buffer
+	if err := report.Write(&buf, "json"); err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	got := &BuildReport{}
+	if err := json.Unmarshal(buf.Bytes(), got); err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if got.Target != "vanilla" || len(got.Artifacts) != 1 || got.Artifacts[0].SHA256 != "abc" {
+		t.Errorf("Got: [ %+v ] / Want: [ %+v ]", got, report)
+	}
+
+	buf.Reset()
+	if err := report.Write(&buf, "yaml"); err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if !strings.Contains(buf.String(), "kernelurls:\n    - https://cdn.kernel.org/") {
+		t.Errorf("Got: [ '%s' ] / Want: [ the yaml report ]", buf.String())
+	}
+
+	if err := report.Write(&buf, "xml"); err == nil {
+		t.Errorf("Expecting an error for an unsupported format")
+	}
+}
+
+func TestRenderBuildTemplate(t *testing.T) {
+	r := &BuildReport{Target: "ubuntu-generic", Architecture: "amd64", KernelRelease: "5.15.0-25-generic", KernelVersion: "26", DriverVersion: "master"}
+	tests := map[string]struct {
+		tmpl    string
+		want    string
+		wantErr bool
+	}{
+		"plain":         {tmpl: "s3://drivers/falco.ko", want: "s3://drivers/falco.ko"},
+		"templated":     {tmpl: "s3://drivers/{{ .DriverVersion }}/{{ .Architecture }}/falco_{{ .Target }}_{{ .KernelRelease }}_{{ .KernelVersion }}.ko", want: "s3://drivers/master/amd64/falco_ubuntu-generic_5.15.0-25-generic_26.ko"},
+		"unknown field": {tmpl: "s3://drivers/{{ .Kernel }}.ko", wantErr: true},
+		"invalid":       {tmpl: "s3://drivers/{{ .Target.ko", wantErr: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := renderBuildTemplate(test.tmpl, r)
+			if (err != nil) != test.wantErr || got != test.want {
+				t.Errorf("Got: [ '%s', %v ] / Want: [ '%s', error %v ]", got, err, test.want, test.wantErr)
+			}
+		})
+	}
+
+	// the commits are named by their short hash
+	r.DriverVersion = "2b1e7d6a0c5a0f8f3f4c7c2c6d4fd3a8e5c1b9a0"
+	if got, _ := renderBuildTemplate("s3://drivers/{{ .DriverVersion }}/falco.ko", r); got != "s3://drivers/2b1e7d6/falco.ko" {
+		t.Errorf("Got: '%s' / Want: 's3://drivers/2b1e7d6/falco.ko'", got)
+	}
+}
+
+func TestBuildReportSigned(t *testing.T) {
+	dir := t.TempDir()
+	b := &builder.Build{
+		TargetType:       builder.TargetTypeVanilla,
+		KernelRelease:    "5.10.0",
+		ModuleFilePath:   filepath.Join(dir, "falco.ko"),
+		ModuleSigningKey: filepath.Join(dir, "signing_key.pem"),
+	}
+
+	if err := ioutil.WriteFile(b.ModuleFilePath, []byte("module"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, reporter := startReport(context.Background(), b, BuilderBaseImage)
+	if report, err := reporter.complete(context.Background(), b, nil); err == nil || report.Artifacts[0].Signed {
+		t.Errorf("Got: [ %v, %+v ] / Want: [ an error because of the unsigned module ]", err, report.Artifacts[0])
+	}
+
+	if err := ioutil.WriteFile(b.ModuleFilePath, []byte("module"+moduleSignatureMagic), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, reporter = startReport(context.Background(), b, BuilderBaseImage)
+	if report, err := reporter.complete(context.Background(), b, nil); err != nil || !report.Artifacts[0].Signed {
+		t.Errorf("Got: [ %v, %+v ] / Want: [ the module signed ]", err, report.Artifacts[0])
+	}
+}
+
+func TestBuildReportMetrics(t *testing.T) {
+	dir := t.TempDir()
+	b := &builder.Build{
+		TargetType:     builder.TargetTypeGentoo,
+		KernelRelease:  "5.10.0",
+		Architecture:   "arm64",
+		ModuleFilePath: filepath.Join(dir, "falco.ko"),
+	}
+	if err := ioutil.WriteFile(b.ModuleFilePath, []byte("module"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	succeeded := metrics.BuildsTotal.WithLabelValues("gentoo", "arm64", metrics.StatusSuccess)
+	failed := metrics.BuildsTotal.WithLabelValues("gentoo", "arm64", metrics.StatusFailure)
+	before, beforeFailed := testutil.ToFloat64(succeeded), testutil.ToFloat64(failed)
+	inFlight := testutil.ToFloat64(metrics.BuildsInFlight)
+
+	ctx, reporter := startReport(context.Background(), b, BuilderBaseImage)
+	if got := testutil.ToFloat64(metrics.BuildsInFlight); got != inFlight+1 {
+		t.Errorf("In flight | Got: [ %v ] / Want: [ %v ]", got, inFlight+1)
+	}
+	report, err := reporter.complete(ctx, b, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if got := testutil.ToFloat64(metrics.BuildsInFlight); got != inFlight {
+		t.Errorf("In flight | Got: [ %v ] / Want: [ %v ]", got, inFlight)
+	}
+	if got := testutil.ToFloat64(succeeded); got != before+1 {
+		t.Errorf("Succeeded | Got: [ %v ] / Want: [ %v ]", got, before+1)
+	}
+	timings := report.Timings
+	if sum := timings.ResolutionSeconds + timings.BuildSeconds + timings.ArtifactsSeconds; sum < report.DurationSeconds-0.001 || sum > report.DurationSeconds+0.001 {
+		t.Errorf("Timings | Got: [ %+v ] / Want: [ summing up to %v ]", timings, report.DurationSeconds)
+	}
+
+	ctx, reporter = startReport(context.Background(), b, BuilderBaseImage)
+	reporter.complete(ctx, b, errors.New("build failed"))
+	if got := testutil.ToFloat64(failed); got != beforeFailed+1 {
+		t.Errorf("Failed | Got: [ %v ] / Want: [ %v ]", got, beforeFailed+1)
+	}
+}
+
+func TestBuildReportBuildLog(t *testing.T) {
+	dir := t.TempDir()
+	b := &builder.Build{
+		TargetType:    builder.TargetTypeVanilla,
+		KernelRelease: "5.10.0",
+		KernelVersion: "1",
+		DriverVersion: "master",
+		Architecture:  "amd64",
+		BuildLogPath:  filepath.Join(dir, "build-{{ .KernelRelease }}.log"),
+	}
+	var forwarded bytes.Buffer
+	ctx, reporter := startReport(WithBuildLog(context.Background(), &forwarded), b, BuilderBaseImage)
+	BuildLog(ctx).Write([]byte("+ make\n"))
+
+	// the lines written so far are saved before the build completes
+	name := filepath.Join(dir, "build-5.10.0.log")
+	content, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"# target: vanilla\n", "# builder image: " + BuilderBaseImage + "\n", "\n+ make\n"} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("Got: '%s' / Want: [ having '%s' ]", content, want)
+		}
+	}
+
+	report, _ := reporter.complete(ctx, b, context.Canceled)
+	BuildLog(ctx).Write([]byte("+ late line\n"))
+	if report.BuildLog != name {
+		t.Errorf("Got: '%s' / Want: '%s'", report.BuildLog, name)
+	}
+	if content, err = ioutil.ReadFile(name); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "+ make\n# build failed: context canceled at ") || strings.Contains(string(content), "late line") {
+		t.Errorf("Got: '%s' / Want: [ the outcome of the build ending the log ]", content)
+	}
+	if forwarded.String() != "+ make\n+ late line\n" {
+		t.Errorf("Got: '%s' / Want: [ the build log forwarded as well ]", forwarded.String())
+	}
+
+	url := "https://cdn.kernel.org/pub/linux/kernel/v5.x/linux-5.10.tar.xz"
+	if header := buildLogHeader(b, report, []string{url}); !strings.Contains(header, "# kernel url: "+url+"\n") {
+		t.Errorf("Got: '%s' / Want: [ having the kernel url %s ]", header, url)
+	}
+}
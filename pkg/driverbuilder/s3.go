@@ -0,0 +1,155 @@
+package driverbuilder
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+)
+
+// s3Retries is the number of times the uploads failing with transient errors are retried.
+const s3Retries = 5
+
+// s3DefaultRegion is the region used when none is configured, S3 compatible storages (e.g. MinIO) usually ignore it.
+const s3DefaultRegion = "us-east-1"
+
+// parseS3URL returns the bucket and the key of the s3://bucket/key URL.
+func parseS3URL(s3URL string) (string, string, error) {
+	u, err := url.Parse(s3URL)
+	if err != nil {
+		return "", "", err
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Scheme != "s3" || len(u.Host) == 0 || len(key) == 0 || strings.HasSuffix(key, "/") {
+		return "", "", fmt.Errorf("invalid s3 url %s, it must be s3://bucket/key", s3URL)
+	}
+	return u.Host, key, nil
+}
+
+// newS3Uploader returns an uploader resolving the credentials and the region the standard way (environment, shared config, instance role),
+// against the S3 compatible endpoint when given.
+func newS3Uploader(endpoint string) (*s3manager.Uploader, error) {
+	sess, err := newS3Session(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return s3manager.NewUploader(sess), nil
+}
+
+// newS3Session returns the session of the uploaders and of the transfers, see newS3Uploader.
+func newS3Session(endpoint string) (*session.Session, error) {
+	cfg := aws.NewConfig().WithMaxRetries(s3Retries)
+	if len(endpoint) > 0 {
+		cfg = cfg.WithEndpoint(endpoint).WithS3ForcePathStyle(true)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *cfg,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(aws.StringValue(sess.Config.Region)) == 0 {
+		sess.Config.Region = aws.String(s3DefaultRegion)
+	}
+	return sess, nil
+}
+
+// uploadS3 uploads the file to the s3 URL, returning the URL of the object.
+func uploadS3(ctx context.Context, uploader *s3manager.Uploader, name string, s3URL string) (string, error) {
+	bucket, key, err := parseS3URL(s3URL)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	res, err := uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   f,
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to upload %s to %s: %s", name, s3URL, err)
+	}
+	builder.Logger(ctx).WithField("path", name).WithField("url", res.Location).Info("artifact uploaded")
+	return res.Location, nil
+}
+
+// publishArtifact uploads the artifact and its checksum file, if any, to the templated s3 URL.
+func publishArtifact(ctx context.Context, uploader *s3manager.Uploader, a *ArtifactReport, tmpl string, r *BuildReport) error {
+	s3URL, err := renderBuildTemplate(tmpl, r)
+	if err != nil {
+		return err
+	}
+	if a.URL, err = uploadS3(ctx, uploader, a.Path, s3URL); err != nil {
+		return err
+	}
+	if len(a.ChecksumFile) > 0 {
+		extension := a.ChecksumFile[len(a.Path):]
+		if a.ChecksumURL, err = uploadS3(ctx, uploader, a.ChecksumFile, s3URL+extension); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// s3Transfer is the object the kubernetes build pod uploads the kernel module to, through a pre-signed URL,
+// and the processor downloads it from, then deletes.
+type s3Transfer struct {
+	client *s3.S3
+	bucket string
+	key    string
+}
+
+// newS3Transfer returns the transfer of the kernel module of the named build, under the s3://bucket[/prefix] URL.
+func newS3Transfer(endpoint string, s3URL string, name string) (*s3Transfer, error) {
+	bucket, key, err := parseS3URL(strings.TrimSuffix(s3URL, "/") + "/" + name + "/" + builder.ModuleFileName)
+	if err != nil {
+		return nil, err
+	}
+	sess, err := newS3Session(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Transfer{client: s3.New(sess), bucket: bucket, key: key}, nil
+}
+
+// presign returns the URL the kernel module is uploaded to until it expires, needing no credentials.
+func (t *s3Transfer) presign(expire time.Duration) (string, error) {
+	req, _ := t.client.PutObjectRequest(&s3.PutObjectInput{
+		Bucket: aws.String(t.bucket),
+		Key:    aws.String(t.key),
+	})
+	return req.Presign(expire)
+}
+
+// retrieve downloads the kernel module uploaded, then deletes it, failing to do so is not an error.
+func (t *s3Transfer) retrieve(ctx context.Context, out *os.File) error {
+	_, err := s3manager.NewDownloaderWithClient(t.client).DownloadWithContext(ctx, out, &s3.GetObjectInput{
+		Bucket: aws.String(t.bucket),
+		Key:    aws.String(t.key),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to download s3://%s/%s: %s", t.bucket, t.key, err)
+	}
+	_, err = t.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(t.bucket),
+		Key:    aws.String(t.key),
+	})
+	if err != nil {
+		builder.Logger(ctx).WithError(err).WithField("url", fmt.Sprintf("s3://%s/%s", t.bucket, t.key)).Warn("unable to delete the transferred kernel module")
+	}
+	return nil
+}
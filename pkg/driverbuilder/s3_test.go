@@ -0,0 +1,155 @@
+package driverbuilder
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+)
+
+func TestParseS3URL(t *testing.T) {
+	if bucket, key, err := parseS3URL("s3://drivers/master/falco.ko"); err != nil || bucket != "drivers" || key != "master/falco.ko" {
+		t.Errorf("Got: [ '%s', '%s', %v ] / Want: [ 'drivers', 'master/falco.ko' ]", bucket, key, err)
+	}
+	for _, s3URL := range []string{"https://drivers/falco.ko", "s3://drivers", "s3://drivers/", "s3://drivers/master/", "s3:///falco.ko"} {
+		if _, _, err := parseS3URL(s3URL); err == nil {
+			t.Errorf("Expecting an error for %s", s3URL)
+		}
+	}
+}
+
+func TestBuildReportS3(t *testing.T) {
+	withEnv(t, "AWS_ACCESS_KEY_ID", "driverkit")
+	withEnv(t, "AWS_SECRET_ACCESS_KEY", "driverkit")
+	withEnv(t, "AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	withEnv(t, "AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+
+	// a fake S3 endpoint, failing the first upload with a transient error
+	var mu sync.Mutex
+	uploaded := map[string]string{}
+	failed := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method != http.MethodPut {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !failed {
+			failed = true
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		uploaded[r.URL.Path] = string(body)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	b := &builder.Build{
+		TargetType:     builder.TargetTypeVanilla,
+		KernelRelease:  "5.10.0",
+		KernelVersion:  "1",
+		DriverVersion:  "master",
+		Architecture:   "amd64",
+		ModuleFilePath: filepath.Join(dir, "falco.ko"),
+		ProbeFilePath:  filepath.Join(dir, "falco.o"),
+		Checksum:       "sha256",
+		ModuleS3URL:    "s3://drivers/{{ .DriverVersion }}/falco_{{ .KernelRelease }}.ko",
+		ProbeS3URL:     "s3://drivers/{{ .DriverVersion }}/falco_{{ .KernelRelease }}.o",
+		S3Endpoint:     srv.URL,
+	}
+	if err := ioutil.WriteFile(b.ModuleFilePath, []byte("module"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the probe is missing, it is not uploaded
+	_, reporter := startReport(context.Background(), b, BuilderBaseImage)
+	report, err := reporter.complete(context.Background(), b, nil)
+	if err == nil {
+		t.Errorf("Expecting an error because of the missing probe")
+	}
+	module := report.Artifacts[0]
+	if !module.Success || module.URL != srv.URL+"/drivers/master/falco_5.10.0.ko" || module.ChecksumURL != srv.URL+"/drivers/master/falco_5.10.0.ko.sha256" {
+		t.Errorf("Got: [ %+v ] / Want: [ the module uploaded with its checksum file ]", module)
+	}
+	if probe := report.Artifacts[1]; probe.Success || probe.URL != "" {
+		t.Errorf("Got: [ %+v ] / Want: [ the probe not uploaded ]", probe)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(uploaded) != 2 || uploaded["/drivers/master/falco_5.10.0.ko"] != "module" {
+		t.Errorf("Got: [ %v ] / Want: [ the module and its checksum file ]", uploaded)
+	}
+	if got := uploaded["/drivers/master/falco_5.10.0.ko.sha256"]; got != "120970d812836f19888625587a4606a5ad23cef31c8684e601771552548fc6b9  falco.ko\n" {
+		t.Errorf("Got: [ '%s' ] / Want: [ the checksum file of the module ]", got)
+	}
+}
+
+func TestS3Transfer(t *testing.T) {
+	withEnv(t, "AWS_ACCESS_KEY_ID", "driverkit")
+	withEnv(t, "AWS_SECRET_ACCESS_KEY", "driverkit")
+	withEnv(t, "AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	withEnv(t, "AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+
+	// a fake S3 endpoint holding the module the build pod uploaded
+	var mu sync.Mutex
+	deleted := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodGet:
+			http.ServeContent(w, r, "module.ko", time.Time{}, strings.NewReader("module"))
+		case http.MethodDelete:
+			deleted = append(deleted, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer srv.Close()
+
+	transfer, err := newS3Transfer(srv.URL, "s3://drivers/transfers/", "driverkit-uid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url, err := transfer.presign(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := srv.URL + "/drivers/transfers/driverkit-uid/module.ko?"; !strings.HasPrefix(url, want) || !strings.Contains(url, "X-Amz-Signature=") || !strings.Contains(url, "X-Amz-Expires=60") {
+		t.Errorf("Got: [ '%s' ] / Want: [ the URL pre-signed for a minute, starting with '%s' ]", url, want)
+	}
+
+	out, err := os.Create(filepath.Join(t.TempDir(), "falco.ko"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if err := transfer.retrieve(context.Background(), out); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ioutil.ReadFile(out.Name()); string(got) != "module" {
+		t.Errorf("Got: [ '%s' ] / Want: [ 'module' ]", got)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"/drivers/transfers/driverkit-uid/module.ko"}; !reflect.DeepEqual(deleted, want) {
+		t.Errorf("Got: [ %v ] / Want: [ %v ]", deleted, want)
+	}
+
+	if _, err := newS3Transfer(srv.URL, "https://drivers", "driverkit-uid"); err == nil {
+		t.Errorf("Expecting an error for a non s3 URL")
+	}
+}
@@ -0,0 +1,194 @@
+package driverbuilder
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// SkipExistingBuildProcessorName is a constant containing the skip-existing name.
+const SkipExistingBuildProcessorName = "skip-existing"
+
+// SkipExistingBuildProcessor skips the builds whose artifacts exist already, running the others with the processor it wraps.
+// The artifacts exist when their local file does, matching its checksum file if any, or the object they are uploaded to when uploaded,
+// and the OCI artifact they are pushed as when pushed.
+type SkipExistingBuildProcessor struct {
+	processorOptions
+	processor BuildProcessor
+}
+
+// NewSkipExistingBuildProcessor constructs a SkipExistingBuildProcessor running the builds whose artifacts do not exist with the processor.
+func NewSkipExistingBuildProcessor(processor BuildProcessor, opts ...ProcessorOption) *SkipExistingBuildProcessor {
+	return &SkipExistingBuildProcessor{
+		processorOptions: newProcessorOptions(opts),
+		processor:        processor,
+	}
+}
+
+func (bp *SkipExistingBuildProcessor) String() string {
+	return SkipExistingBuildProcessorName
+}
+
+// Start runs the build with the wrapped processor unless its artifacts exist already,
+// the report of the build skipped is a cached one, with the artifacts found.
+func (bp *SkipExistingBuildProcessor) Start(ctx context.Context, b *builder.Build) (*BuildReport, error) {
+	// the wrapped processor logs with the fields of the build as well
+	ctx, id := withBuildLogger(bp.withLogger(ctx), b)
+	report := newBuildReport(id, b, "")
+	artifacts, err := existingArtifacts(ctx, b, report)
+	if err != nil {
+		builder.Logger(ctx).WithError(err).Info("building the artifacts")
+		return bp.processor.Start(ctx, b)
+	}
+	report.KernelURLs = []string{}
+	report.Artifacts = artifacts
+	report.Success = true
+	report.Cached = true
+	builder.Logger(ctx).Info("the artifacts exist already, skipping the build")
+	return report, nil
+}
+
+// existingArtifacts returns the artifacts of the build, filling the OCI artifact of the report when pushed,
+// or the error telling why they must be built. The BTF is not required, the builds skip it when the debug package of the kernel is not found.
+func existingArtifacts(ctx context.Context, b *builder.Build, r *BuildReport) ([]ArtifactReport, error) {
+	artifacts := []ArtifactReport{}
+	var uploader *s3manager.Uploader
+	for _, artifact := range []struct {
+		ArtifactReport
+		s3URL string
+	}{
+		{ArtifactReport{Type: ArtifactModule, Path: b.ModuleFilePath}, b.ModuleS3URL},
+		{ArtifactReport{Type: ArtifactProbe, Path: b.ProbeFilePath}, b.ProbeS3URL},
+		{ArtifactReport{Type: ArtifactModernProbe, Path: b.ModernProbeFilePath}, ""},
+		{ArtifactReport{Type: ArtifactDKMS, Path: b.DKMSFilePath}, ""},
+	} {
+		a := artifact.ArtifactReport
+		if len(a.Path) == 0 {
+			continue
+		}
+		// the DKMS package is never compressed, it is a tarball compressed already
+		if len(b.Compression) > 0 && a.Type != ArtifactDKMS {
+			a.Path += CompressionExtensions[b.Compression]
+			a.Compression = b.Compression
+		}
+		var err error
+		if len(artifact.s3URL) > 0 {
+			if uploader == nil {
+				uploader, err = newS3Uploader(b.S3Endpoint)
+			}
+			if err == nil {
+				err = existingS3Artifact(ctx, uploader, &a, artifact.s3URL, b.Checksum, r)
+			}
+		} else {
+			err = existingLocalArtifact(&a, b)
+		}
+		if err != nil {
+			return nil, err
+		}
+		a.Success = true
+		artifacts = append(artifacts, a)
+	}
+	if len(b.OCIRef) > 0 {
+		ref, err := ociReference(b.OCIRef, b.OCIInsecure, r)
+		if err != nil {
+			return nil, err
+		}
+		desc, err := remote.Head(ref, remote.WithContext(ctx), remote.WithAuthFromKeychain(authn.DefaultKeychain))
+		if err != nil {
+			return nil, fmt.Errorf("the oci artifact %s was not found: %s", ref, err)
+		}
+		r.OCIRef, r.OCIDigest = ref.String(), desc.Digest.String()
+	}
+	return artifacts, nil
+}
+
+// existingLocalArtifact fails when the artifact does not exist, or does not match its checksum files if any,
+// or is not signed when the build signs it.
+func existingLocalArtifact(a *ArtifactReport, b *builder.Build) error {
+	if _, err := os.Stat(a.Path); err != nil {
+		return fmt.Errorf("the %s was not found: %s", a.Type, err)
+	}
+	sha256Sum, sha512Sum, err := fileChecksums(a.Path)
+	if err != nil {
+		return err
+	}
+	a.SHA256 = sha256Sum
+	for algorithm, sum := range map[string]string{"sha256": sha256Sum, "sha512": sha512Sum} {
+		name := a.Path + "." + algorithm
+		content, err := ioutil.ReadFile(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if fields := strings.Fields(string(content)); len(fields) == 0 || fields[0] != sum {
+			return fmt.Errorf("the %s %s does not match its checksum file %s", a.Type, a.Path, name)
+		}
+		if algorithm == "sha512" {
+			a.SHA512 = sum
+		}
+		if algorithm == b.Checksum {
+			a.ChecksumFile = name
+		}
+	}
+	// the compressed modules cannot be checked, they were signed before being compressed
+	if a.Type == ArtifactModule && len(b.ModuleSigningKey) > 0 && len(a.Compression) == 0 {
+		if a.Signed, err = isModuleSigned(a.Path); err != nil || !a.Signed {
+			return fmt.Errorf("the kernel module %s is not signed", a.Path)
+		}
+	}
+	return nil
+}
+
+// existingS3Artifact fails when the object the artifact is uploaded to does not exist, nor the one of its checksum file when written.
+func existingS3Artifact(ctx context.Context, uploader *s3manager.Uploader, a *ArtifactReport, tmpl string, checksum string, r *BuildReport) error {
+	s3URL, err := renderBuildTemplate(tmpl, r)
+	if err != nil {
+		return err
+	}
+	urls := []string{s3URL}
+	if len(checksum) > 0 {
+		urls = append(urls, s3URL+"."+checksum)
+	}
+	for _, u := range urls {
+		if err := headS3(ctx, uploader, u); err != nil {
+			return err
+		}
+	}
+	a.URL = s3URL
+	if len(checksum) > 0 {
+		a.ChecksumURL = urls[1]
+	}
+	return nil
+}
+
+// headS3 fails when the object of the s3 URL does not exist.
+func headS3(ctx context.Context, uploader *s3manager.Uploader, s3URL string) error {
+	bucket, key, err := parseS3URL(s3URL)
+	if err != nil {
+		return err
+	}
+	_, err = uploader.S3.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == http.StatusNotFound {
+		return fmt.Errorf("the object %s was not found", s3URL)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to tell whether the object %s exists: %s", s3URL, err)
+	}
+	return nil
+}
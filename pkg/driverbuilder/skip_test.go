@@ -0,0 +1,114 @@
+package driverbuilder
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+)
+
+func TestSkipExisting(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		name = filepath.Join(dir, name)
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+	module := write("falco.ko", "module")
+	sum, _, _ := fileChecksums(module)
+	write("falco.ko.sha256", sum+"  falco.ko\n")
+	mismatching := write("mismatching.ko", "module")
+	write("mismatching.ko.sha256", "0000  mismatching.ko\n")
+	unchecked := write("unchecked.o", "probe")
+	write("compressed.ko.gz", "compressed")
+
+	tests := map[string]struct {
+		build  *builder.Build
+		cached bool
+	}{
+		"existing":                  {&builder.Build{ModuleFilePath: module}, true},
+		"existing without checksum": {&builder.Build{ModuleFilePath: module, ProbeFilePath: unchecked}, true},
+		"mismatching checksum":      {&builder.Build{ModuleFilePath: mismatching}, false},
+		"missing":                   {&builder.Build{ModuleFilePath: module, ProbeFilePath: filepath.Join(dir, "missing.o")}, false},
+		"compressed":                {&builder.Build{ModuleFilePath: filepath.Join(dir, "compressed.ko"), Compression: "gzip"}, true},
+		"not compressed":            {&builder.Build{ModuleFilePath: module, Compression: "xz"}, false},
+		"not signed":                {&builder.Build{ModuleFilePath: module, ModuleSigningKey: "key.pem", ModuleSigningCert: "key.x509"}, false},
+		"optional btf":              {&builder.Build{ModuleFilePath: module, BTFFilePath: filepath.Join(dir, "vmlinux.btf")}, true},
+	}
+	for name, test := range tests {
+		fake := &fakeBuildProcessor{}
+		bp := NewSkipExistingBuildProcessor(fake)
+		test.build.TargetType = builder.TargetTypeVanilla
+		test.build.KernelRelease = "5.10.0"
+		report, err := bp.Start(context.Background(), test.build)
+		if err != nil {
+			t.Fatalf("Unexpected error encountered | Test Input: '%s' | Error: '%s'", name, err)
+		}
+		if cached := report != nil && report.Cached; cached != test.cached || (fake.max == 0) != test.cached {
+			t.Errorf("Test Input: '%s' | Got: [ cached %v, built %v ] / Want: [ cached %v ]", name, cached, fake.max > 0, test.cached)
+		}
+	}
+
+	b := &builder.Build{TargetType: builder.TargetTypeVanilla, ModuleFilePath: module, Checksum: "sha256"}
+	report, err := NewSkipExistingBuildProcessor(&fakeBuildProcessor{}).Start(context.Background(), b)
+	if err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	if a := report.Artifacts; len(a) != 1 || !a[0].Success || a[0].SHA256 != sum || a[0].ChecksumFile != module+".sha256" {
+		t.Errorf("Got: [ %+v ] / Want: [ the module found, with its checksum file ]", a)
+	}
+}
+
+func TestSkipExistingS3(t *testing.T) {
+	withEnv(t, "AWS_ACCESS_KEY_ID", "driverkit")
+	withEnv(t, "AWS_SECRET_ACCESS_KEY", "driverkit")
+	withEnv(t, "AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	withEnv(t, "AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+
+	// a fake S3 endpoint with the objects of the kernel release 5.10.0 only
+	var heads int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&heads, 1)
+		switch {
+		case r.Method != http.MethodHead:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case r.URL.Path == "/drivers/5.10.0/falco.ko", r.URL.Path == "/drivers/5.10.0/falco.ko.sha256":
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	for release, cached := range map[string]bool{"5.10.0": true, "5.11.0": false} {
+		fake := &fakeBuildProcessor{}
+		b := &builder.Build{
+			TargetType:     builder.TargetTypeVanilla,
+			KernelRelease:  release,
+			ModuleFilePath: filepath.Join(t.TempDir(), "falco.ko"),
+			ModuleS3URL:    "s3://drivers/{{ .KernelRelease }}/falco.ko",
+			S3Endpoint:     srv.URL,
+			Checksum:       "sha256",
+		}
+		report, err := NewSkipExistingBuildProcessor(fake).Start(context.Background(), b)
+		if err != nil {
+			t.Fatalf("Unexpected error encountered | Test Input: '%s' | Error: '%s'", release, err)
+		}
+		if got := report != nil && report.Cached; got != cached || (fake.max == 0) != cached {
+			t.Errorf("Test Input: '%s' | Got: [ cached %v ] / Want: [ cached %v ]", release, got, cached)
+		}
+		if cached && report.Artifacts[0].URL != fmt.Sprintf("s3://drivers/%s/falco.ko", release) {
+			t.Errorf("Test Input: '%s' | Got: [ %+v ] / Want: [ the s3 url of the module ]", release, report.Artifacts)
+		}
+	}
+	if atomic.LoadInt32(&heads) == 0 {
+		t.Errorf("Got: [ no request ] / Want: [ the objects checked ]")
+	}
+}
@@ -0,0 +1,497 @@
+package driverbuilder
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	logger "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+// RemoteSSHBuildProcessorName is a constant containing the ssh name.
+const RemoteSSHBuildProcessorName = "ssh"
+
+// RemoteSSHOptions tell how to connect to the build host.
+type RemoteSSHOptions struct {
+	// Host is the build host, as [user@]host[:port].
+	Host string
+	// Jump is the bastion the build host is reached through, as [user@]host[:port], if any.
+	Jump string
+	// KeyFile is the private key to authenticate with, the ssh agent is used too when running.
+	KeyFile string
+	// KnownHosts is the known_hosts file the host keys are verified against.
+	KnownHosts string
+	// Docker runs the build script into a container of the builder image on the build host.
+	Docker bool
+}
+
+// RemoteSSHBuildProcessor runs the build script on a remote host, reached through SSH,
+// either directly or into a docker container.
+type RemoteSSHBuildProcessor struct {
+	processorOptions
+	timeout int
+	proxy   string
+	caCert  string
+	opts    RemoteSSHOptions
+}
+
+// NewRemoteSSHBuildProcessor constructs a RemoteSSHBuildProcessor.
+func NewRemoteSSHBuildProcessor(timeout int, proxy string, caCert string, opts RemoteSSHOptions, processorOpts ...ProcessorOption) *RemoteSSHBuildProcessor {
+	return &RemoteSSHBuildProcessor{
+		processorOptions: newProcessorOptions(processorOpts),
+		timeout:          timeout,
+		proxy:            proxy,
+		caCert:           caCert,
+		opts:             opts,
+	}
+}
+
+func (bp *RemoteSSHBuildProcessor) String() string {
+	return RemoteSSHBuildProcessorName
+}
+
+// Start the ssh processor
+func (bp *RemoteSSHBuildProcessor) Start(ctx context.Context, b *builder.Build) (*BuildReport, error) {
+	image := ""
+	if bp.opts.Docker {
+		image = builderImageOf(b)
+	}
+	ctx, reporter := startReport(bp.withLogger(ctx), b, image)
+	err := bp.start(ctx, b)
+	return reporter.complete(ctx, b, err)
+}
+
+// start runs the build.
+func (bp *RemoteSSHBuildProcessor) start(ctx context.Context, b *builder.Build) error {
+	builder.Logger(ctx).Debug("doing a new ssh build")
+
+	// create a builder based on the chosen build type
+	v, err := builder.Factory(b.TargetType)
+	if err != nil {
+		return err
+	}
+	if len(b.CcacheDir) > 0 {
+		return fmt.Errorf("the ccache directory cannot be mounted by the ssh processor, use the docker, kubernetes or local one")
+	}
+	caBundle, err := readCABundle(bp.caCert)
+	if err != nil {
+		return err
+	}
+	entitlementCert, entitlementKey, err := readRHELEntitlement(b)
+	if err != nil {
+		return err
+	}
+	signingKey, signingCert, err := readModuleSigningKey(b)
+	if err != nil {
+		return err
+	}
+	b, localKernel, err := withLocalKernel(b)
+	if err != nil {
+		return err
+	}
+	if err := checkLocalDriver(b); err != nil {
+		return err
+	}
+	c := builder.Config{
+		DriverName:          b.ModuleDriverName,
+		DeviceName:          b.ModuleDeviceName,
+		DownloadBaseURL:     "https://github.com/falcosecurity/libs/archive",
+		ProxyURL:            bp.proxy,
+		CABundle:            caBundle,
+		RHELEntitlementCert: entitlementCert,
+		RHELEntitlementKey:  entitlementKey,
+		Build:               b,
+	}
+
+	// fail fast when the target cannot do the build, before downloading anything
+	kr := c.Build.KernelReleaseFromBuildConfig()
+	if err := builder.Validate(v, c, kr); err != nil {
+		return err
+	}
+
+	ctx, err = builder.WithHTTPClient(ctx, c)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(bp.timeout)*time.Second)
+	defer cancel()
+
+	// Generate the build script from the builder
+	driverkitScript, err := builder.Script(ctx, v, c, kr)
+	if err != nil {
+		return err
+	}
+	if len(signingKey) > 0 {
+		driverkitScript = withModuleSigning(driverkitScript)
+	}
+	driverkitScript = withArtifactFailures(driverkitScript)
+
+	// Prepare driver config template
+	bufFillDriverConfig := bytes.NewBuffer(nil)
+	err = renderFillDriverConfig(bufFillDriverConfig, driverConfigData{DriverVersion: c.DriverVersion, DriverName: c.DriverName, DeviceName: c.DeviceName})
+	if err != nil {
+		return err
+	}
+
+	// Prepare makefile template
+	bufMakefile := bytes.NewBuffer(nil)
+	err = renderMakefile(bufMakefile, makefileData{ModuleName: c.DriverName, ModuleBuildDir: builder.DriverDirectory})
+	if err != nil {
+		return err
+	}
+
+	configDecoded, err := base64.StdEncoding.DecodeString(b.KernelConfigData)
+	if err != nil {
+		return err
+	}
+
+	conn, err := dialSSH(builder.Logger(ctx), bp.opts)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	uid := string(uuid.NewUUID())
+	workDir := path.Join("/tmp", fmt.Sprintf("driverkit-%s", uid))
+	if err := runSSH(ctx, conn.client, fmt.Sprintf("mkdir -m 0700 %s", workDir), nil, nil); err != nil {
+		return fmt.Errorf("unable to create the remote working directory: %s", err)
+	}
+	defer func() {
+		if err := runSSH(context.Background(), conn.client, fmt.Sprintf("rm -rf %s", workDir), nil, nil); err != nil {
+			builder.Logger(ctx).WithError(err).WithField("dir", workDir).Error("error removing the remote working directory")
+		}
+	}()
+	builder.Logger(ctx).WithField("dir", workDir).Debug("using remote working directory")
+
+	// When running directly on the host, the paths the script uses are moved into the working directory,
+	// the artifacts are found there in both cases since the driver directory of the container is copied into it
+	paths := localPaths(workDir)
+	makefile := bufMakefile.String()
+	fillDriverConfig := bufFillDriverConfig.String()
+	if bp.opts.Docker {
+		if len(caBundle) > 0 {
+			driverkitScript = withTrustedCABundle(driverkitScript)
+		}
+	} else {
+		driverkitScript, err = localScript(driverkitScript, workDir)
+		if err != nil {
+			return err
+		}
+		makefile = paths.Replace(makefile)
+		fillDriverConfig = paths.Replace(fillDriverConfig)
+	}
+	files := []dockerCopyFile{
+		{"driverkit/driverkit.sh", driverkitScript},
+		{"driverkit/kernel.config", string(configDecoded)},
+		{"driverkit/module-Makefile", makefile},
+		{"driverkit/fill-driver-config.sh", fillDriverConfig},
+	}
+	if len(caBundle) > 0 {
+		files = append(files, dockerCopyFile{path.Join("driverkit", path.Base(CABundlePath)), string(caBundle)})
+	}
+	// the key pair lives in the working directory, removed once done
+	if len(signingKey) > 0 {
+		files = append(files,
+			dockerCopyFile{path.Join(path.Base(ModuleSigningDirectory), path.Base(moduleSigningKeyPath)), string(signingKey)},
+			dockerCopyFile{path.Join(path.Base(ModuleSigningDirectory), path.Base(moduleSigningCertPath)), string(signingCert)},
+		)
+	}
+	if len(entitlementCert) > 0 {
+		files = append(files,
+			dockerCopyFile{path.Join(path.Base(builder.RHELEntitlementDirectory), path.Base(builder.RHELEntitlementCertPath)), string(entitlementCert)},
+			dockerCopyFile{path.Join(path.Base(builder.RHELEntitlementDirectory), path.Base(builder.RHELEntitlementKeyPath)), string(entitlementKey)},
+		)
+	}
+	kernelCurlConfig := builder.KernelURLsCurlConfig(b)
+	if len(kernelCurlConfig) > 0 {
+		files = append(files, dockerCopyFile{path.Join(path.Base(builder.KernelURLsAuthDirectory), path.Base(builder.KernelURLsCurlConfigPath)), string(kernelCurlConfig)})
+	}
+
+	// Upload the inputs, streaming them since the local kernel packages and driver sources can be big
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(tarRemoteInputs(pw, files, b.LocalKernelDir, localKernel, b.LocalDriverDir))
+	}()
+	err = runSSH(ctx, conn.client, fmt.Sprintf("tar -xf - -C %s", workDir), pr, nil)
+	pr.Close()
+	if err != nil {
+		return fmt.Errorf("unable to upload the build inputs: %s", err)
+	}
+
+	// Run the build, forwarding its logs
+	command := bp.remoteCommand(workDir, uid, builderImageOf(b), len(caBundle) > 0, len(localKernel) > 0, len(b.LocalDriverDir) > 0, len(signingKey) > 0, len(entitlementCert) > 0, len(kernelCurlConfig) > 0)
+	lr, lw := io.Pipe()
+	buildErr := make(chan error, 1)
+	go func() {
+		err := runSSH(ctx, conn.client, command, nil, lw)
+		lw.Close()
+		buildErr <- err
+	}()
+	forwardLogs(ctx, lr)
+	failures := artifactsFailedError{}
+	if err := <-buildErr; err != nil {
+		if ctx.Err() != nil {
+			bp.kill(builder.Logger(ctx), conn.client, workDir, uid)
+			return ctx.Err()
+		}
+		// the failure of the kernel module or of the eBPF probe does not prevent the other artifacts from being downloaded
+		failures = readArtifactFailures(b, func(name string) ([]byte, error) {
+			var out bytes.Buffer
+			err := runSSH(ctx, conn.client, fmt.Sprintf("cat %s", paths.Replace(name)), nil, &out)
+			return out.Bytes(), err
+		})
+		if len(failures) == 0 {
+			return fmt.Errorf("build script failed: %s", err)
+		}
+	}
+
+	return copyArtifacts(ctx, b, failures, func(from, to string) error {
+		return downloadSSH(ctx, conn.client, paths.Replace(from), to)
+	})
+}
+
+// remoteCommand returns the command running the build script, recording its pid so that it can be killed.
+// Its output, stderr included, is the build log.
+func (bp *RemoteSSHBuildProcessor) remoteCommand(workDir, uid, builderImage string, caBundle, localKernel, localDriver, moduleSigning, rhelEntitlement, kernelURLsAuth bool) string {
+	env := []string{}
+	if bp.proxy != "" {
+		env = append(env, "http_proxy="+shellQuote(bp.proxy), "https_proxy="+shellQuote(bp.proxy))
+	}
+	pidFile := path.Join(workDir, "driverkit.pid")
+
+	if !bp.opts.Docker {
+		env = append(env, fmt.Sprintf("PATH=%s:$PATH", path.Join(workDir, "bin")))
+		trusted := path.Join(workDir, "driverkit", "ca-trusted.crt")
+		prepare := ""
+		if caBundle {
+			// Trust the CA bundle in addition to the system one, without touching the latter
+			prepare = fmt.Sprintf("cat /etc/ssl/certs/ca-certificates.crt %s > %s 2>/dev/null || cp %s %s; ", path.Join(workDir, "driverkit", path.Base(CABundlePath)), trusted, path.Join(workDir, "driverkit", path.Base(CABundlePath)), trusted)
+			env = append(env, "CURL_CA_BUNDLE="+trusted, "SSL_CERT_FILE="+trusted)
+		}
+		return fmt.Sprintf("%secho $$ > %s; exec env %s /bin/bash %s 2>&1", prepare, pidFile, strings.Join(env, " "), path.Join(workDir, "driverkit", "driverkit.sh"))
+	}
+
+	name := fmt.Sprintf("driverkit-%s", uid)
+	args := []string{"docker", "run", "--name", name, "-v", path.Join(workDir, "driverkit") + ":/driverkit:ro"}
+	if localKernel {
+		args = append(args, "-v", path.Join(workDir, "driverkit-kernel")+":"+builder.LocalKernelDirectory+":ro")
+	}
+	if localDriver {
+		args = append(args, "-v", path.Join(workDir, path.Base(builder.LocalDriverDirectory))+":"+builder.LocalDriverDirectory+":ro")
+	}
+	if moduleSigning {
+		args = append(args, "-v", path.Join(workDir, path.Base(ModuleSigningDirectory))+":"+ModuleSigningDirectory+":ro")
+	}
+	if rhelEntitlement {
+		args = append(args, "-v", path.Join(workDir, path.Base(builder.RHELEntitlementDirectory))+":"+builder.RHELEntitlementDirectory+":ro")
+	}
+	if kernelURLsAuth {
+		args = append(args, "-v", path.Join(workDir, path.Base(builder.KernelURLsAuthDirectory))+":"+builder.KernelURLsAuthDirectory+":ro")
+	}
+	for _, e := range env {
+		args = append(args, "-e", e)
+	}
+	args = append(args, builderImage, "/bin/bash", "/driverkit/driverkit.sh")
+	// the driver directory is copied even when the build fails, for the artifacts built nonetheless and the failures to be downloaded
+	return fmt.Sprintf("echo %s > %s; %s 2>&1; status=$?; docker cp %s:%s %s || [ $status -ne 0 ] || status=1; docker rm -f %s >/dev/null; exit $status",
+		name, pidFile, strings.Join(args, " "), name, builder.DriverDirectory, workDir, name)
+}
+
+// kill stops the build script left running on the remote host.
+func (bp *RemoteSSHBuildProcessor) kill(log logger.FieldLogger, client *ssh.Client, workDir, uid string) {
+	pidFile := path.Join(workDir, "driverkit.pid")
+	command := fmt.Sprintf("kill -TERM -- -$(cat %s)", pidFile)
+	if bp.opts.Docker {
+		command = fmt.Sprintf("docker rm -f $(cat %s)", pidFile)
+	}
+	log.WithField("dir", workDir).Debug("killing the remote build")
+	if err := runSSH(context.Background(), client, command, nil, nil); err != nil {
+		log.WithError(err).Error("error killing the remote build")
+	}
+}
+
+// tarRemoteInputs writes the files, the local kernel packages and the local driver sources into the archive, relatively to the working directory.
+func tarRemoteInputs(w io.Writer, files []dockerCopyFile, localKernelDir string, localKernel []string, localDriverDir string) error {
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+	for _, file := range files {
+		hdr := &tar.Header{
+			Name: file.Name,
+			Mode: 0600,
+			Size: int64(len(file.Body)),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write([]byte(file.Body)); err != nil {
+			return err
+		}
+	}
+	for _, name := range localKernel {
+		if err := tarFile(tw, path.Join(localKernelDir, name), path.Join(path.Base(builder.LocalKernelDirectory), name)); err != nil {
+			return err
+		}
+	}
+	if len(localDriverDir) > 0 {
+		return tarLocalDriverFiles(tw, localDriverDir, path.Base(builder.LocalDriverDirectory))
+	}
+	return nil
+}
+
+// sshConnection is the connection to the build host, along with the one to the bastion, if any.
+type sshConnection struct {
+	client *ssh.Client
+	jump   *ssh.Client
+}
+
+func (c *sshConnection) Close() {
+	c.client.Close()
+	if c.jump != nil {
+		c.jump.Close()
+	}
+}
+
+// dialSSH connects to the build host, through the bastion if any, verifying the host keys against the known hosts.
+func dialSSH(log logger.FieldLogger, opts RemoteSSHOptions) (*sshConnection, error) {
+	hostKeyCallback, err := knownhosts.New(opts.KnownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the known hosts: %s", err)
+	}
+	auth, err := sshAuthMethods(log, opts.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	user, addr := parseSSHDestination(opts.Host)
+	config := &ssh.ClientConfig{User: user, Auth: auth, HostKeyCallback: hostKeyCallback, Timeout: 30 * time.Second}
+	if len(opts.Jump) == 0 {
+		log.WithField("host", addr).Debug("connecting to the build host")
+		client, err := ssh.Dial("tcp", addr, config)
+		if err != nil {
+			return nil, err
+		}
+		return &sshConnection{client: client}, nil
+	}
+
+	jumpUser, jumpAddr := parseSSHDestination(opts.Jump)
+	log.WithField("host", addr).WithField("jump", jumpAddr).Debug("connecting to the build host")
+	jump, err := ssh.Dial("tcp", jumpAddr, &ssh.ClientConfig{User: jumpUser, Auth: auth, HostKeyCallback: hostKeyCallback, Timeout: 30 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to the bastion: %s", err)
+	}
+	netConn, err := jump.Dial("tcp", addr)
+	if err != nil {
+		jump.Close()
+		return nil, err
+	}
+	clientConn, chans, reqs, err := ssh.NewClientConn(netConn, addr, config)
+	if err != nil {
+		jump.Close()
+		return nil, err
+	}
+	return &sshConnection{client: ssh.NewClient(clientConn, chans, reqs), jump: jump}, nil
+}
+
+// sshAuthMethods returns the private key, when given, and the keys of the ssh agent, when running.
+func sshAuthMethods(log logger.FieldLogger, keyFile string) ([]ssh.AuthMethod, error) {
+	methods := []ssh.AuthMethod{}
+	if len(keyFile) > 0 {
+		key, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("unable to use the ssh key %s: %s", keyFile, err)
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+	if sock := os.Getenv("SSH_AUTH_SOCK"); len(sock) > 0 {
+		conn, err := net.Dial("unix", sock)
+		if err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		} else {
+			log.WithError(err).Debug("ssh agent not available")
+		}
+	}
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("no ssh authentication available, provide a key or run an ssh agent")
+	}
+	return methods, nil
+}
+
+// parseSSHDestination splits [user@]host[:port] into the user, defaulting to the current one, and the address.
+func parseSSHDestination(dest string) (string, string) {
+	user := os.Getenv("USER")
+	if i := strings.LastIndex(dest, "@"); i >= 0 {
+		user, dest = dest[:i], dest[i+1:]
+	}
+	if _, _, err := net.SplitHostPort(dest); err != nil {
+		dest = net.JoinHostPort(strings.Trim(dest, "[]"), "22")
+	}
+	return user, dest
+}
+
+// runSSH runs the command on the remote host, it is stopped when the context is canceled.
+// The error output of the command is reported in the error, if it fails.
+func runSSH(ctx context.Context, client *ssh.Client, command string, stdin io.Reader, out io.Writer) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	stderr := bytes.NewBuffer(nil)
+	session.Stdin = stdin
+	session.Stdout = out
+	session.Stderr = stderr
+	if err := session.Start(command); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+	select {
+	case err := <-done:
+		if err != nil && stderr.Len() > 0 {
+			return fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return err
+	case <-ctx.Done():
+		session.Signal(ssh.SIGKILL)
+		return ctx.Err()
+	}
+}
+
+// downloadSSH copies the remote file to the local path.
+func downloadSSH(ctx context.Context, client *ssh.Client, from, to string) error {
+	out, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if err := runSSH(ctx, client, fmt.Sprintf("cat %s", from), nil, out); err != nil {
+		os.Remove(to)
+		return fmt.Errorf("unable to download %s: %s", from, err)
+	}
+	return nil
+}
+
+// shellQuote quotes the value for the remote shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
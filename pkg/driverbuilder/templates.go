@@ -2,6 +2,8 @@ package driverbuilder
 
 import (
 	"io"
+	"path"
+	"strings"
 	"text/template"
 
 	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
@@ -26,11 +28,26 @@ while true; do
 done
 `
 
+// buildFailedPath is created when the build script fails, with the container kept running.
+const buildFailedPath = "/tmp/driverkit.failed"
+
+// keepFailedPodScript runs the build script, keeping the container running on failure.
+var keepFailedPodScript = `/bin/bash /driverkit/driverkit.sh || { touch ` + buildFailedPath + `; sleep infinity; }`
+
 // waitForModuleAndCat MUST only output the file, any other output will break
 // the download file itself because it goes trough stdout
-var waitForModuleAndCat = `
+var waitForModuleAndCat = waitForReadyModuleAndCat(builder.ModuleFullPath)
+
+// waitForSignedModuleAndCat is waitForModuleAndCat for the builds signing the kernel module, that is ready once signed.
+var waitForSignedModuleAndCat = waitForReadyModuleAndCat(moduleSignedPath)
+
+func waitForReadyModuleAndCat(ready string) string {
+	return `
 while true; do
-  if [ ! -f ` + builder.ModuleFullPath + ` ]; then
+  if [ -f ` + buildFailedPath + ` ]; then
+	exit 1
+  fi
+  if [ ! -f ` + ready + ` ]; then
 	sleep 10 1>&/dev/null
 	continue
   fi
@@ -39,6 +56,165 @@ done
 cat ` + builder.ModuleFullPath + `
 rm /tmp/module-download.lock 1>&/dev/null
 `
+}
+
+// artifactsDirectory is where the persistent volume claim the kernel module is transferred through is mounted into the build pod.
+const artifactsDirectory = "/driverkit-artifacts"
+
+// artifactURLEnv is the variable holding the pre-signed URL the build pod uploads the kernel module to.
+const artifactURLEnv = "DRIVERKIT_ARTIFACT_URL"
+
+// transferModuleToPVCScript ends the build script copying the kernel module into the directory of the build on the claim.
+func transferModuleToPVCScript(dir string) string {
+	return `
+# Transfer the kernel module through the persistent volume claim, read out of it once the build pod succeeded
+mkdir -p ` + dir + `
+cp ` + builder.ModuleFullPath + ` ` + dir + `/` + builder.ModuleFileName + `
+`
+}
+
+var transferModuleToS3Script = `
+# Transfer the kernel module through the pre-signed URL, downloaded once the build pod succeeded
+curl --fail --silent --show-error --retry 5 -X PUT -T ` + builder.ModuleFullPath + ` "$` + artifactURLEnv + `"
+`
+
+// readModuleFromPVCScript prints the kernel module the build copied into the directory of the claim as base64, then removes it.
+func readModuleFromPVCScript(dir string) string {
+	return `base64 ` + dir + `/` + builder.ModuleFileName + ` && rm -rf ` + dir
+}
+
+// CABundlePath is where the CA bundle is copied into the builder.
+const CABundlePath = "/driverkit/ca-bundle.crt"
+
+var trustCABundleScript = `
+# Trust the provided CA bundle, so that downloads work through TLS intercepting proxies
+cat ` + CABundlePath + ` >> /etc/ssl/certs/ca-certificates.crt
+export CURL_CA_BUNDLE=/etc/ssl/certs/ca-certificates.crt
+export SSL_CERT_FILE=/etc/ssl/certs/ca-certificates.crt
+`
+
+// localKernelCompletePath is created once the local kernel packages are completely copied into the builder.
+var localKernelCompletePath = path.Join(builder.LocalKernelDirectory, ".complete")
+
+var waitForLocalKernelScript = `
+# Wait for the local kernel packages to be copied into the builder
+while [ ! -f ` + localKernelCompletePath + ` ]; do
+  echo "local kernel packages not copied yet - waiting for 5 seconds"
+  sleep 5
+done
+`
+
+// localDriverCompletePath is created once the local driver sources are completely copied into the builder.
+var localDriverCompletePath = path.Join(builder.LocalDriverDirectory, ".complete")
+
+var waitForLocalDriverScript = `
+# Wait for the local driver sources to be copied into the builder
+while [ ! -f ` + localDriverCompletePath + ` ]; do
+  echo "local driver sources not copied yet - waiting for 5 seconds"
+  sleep 5
+done
+`
+
+// ModuleSigningDirectory is where the key pair signing the kernel module is copied into the builder.
+const ModuleSigningDirectory = "/tmp/driverkit-signing"
+
+var (
+	moduleSigningKeyPath  = path.Join(ModuleSigningDirectory, "signing_key.pem")
+	moduleSigningCertPath = path.Join(ModuleSigningDirectory, "signing_key.x509")
+	// moduleSignedPath is created once the kernel module is signed.
+	moduleSignedPath = builder.ModuleFullPath + ".signed"
+)
+
+var removeModuleSigningKeyScript = `
+# The key pair signing the kernel module must not outlive the build, whatever happens
+trap 'rm -rf ` + ModuleSigningDirectory + ` 2>/dev/null || true' EXIT
+trap 'exit 1' INT TERM
+`
+
+// signModuleScript signs the kernel module with the sign-file of the kernel the module is built against,
+// found in the directory of its headers: $sourcedir for the builders setting it, /tmp/kernel otherwise.
+var signModuleScript = `
+# Sign the kernel module for Secure Boot
+if [ -f ` + builder.ModuleFullPath + ` ]; then
+  signkerneldir=${sourcedir:-/tmp/kernel}
+  signfile=$signkerneldir/scripts/sign-file
+  if [ ! -x $signfile ]; then
+    # the kernel headers of some distributions only ship its sources
+    gcc -o /tmp/sign-file $signkerneldir/scripts/sign-file.c -lcrypto
+    signfile=/tmp/sign-file
+  fi
+  $signfile sha256 ` + moduleSigningKeyPath + ` ` + moduleSigningCertPath + ` ` + builder.ModuleFullPath + `
+  touch ` + moduleSignedPath + `
+fi
+`
+
+var ccacheScript = `
+# Compile through ccache, with the cache mounted by the processor, only reading from it when it is not writable
+export CCACHE_DIR=` + builder.CcacheDirectory + `
+export CCACHE_TEMPDIR=/tmp/ccache-tmp
+mkdir -p $CCACHE_DIR
+[ -w $CCACHE_DIR ] || export CCACHE_READONLY=1
+command -v ccache >/dev/null || (apt-get update && apt-get install -y --no-install-recommends ccache) || yum install -y ccache
+ccache -z || true
+`
+
+// artifactFailuresScript ends the build script: the builders build the kernel module and the eBPF probe apart,
+// it fails once the other artifacts are built, signed and so on, when one of them failed.
+var artifactFailuresScript = `
+# Fail the build when the kernel module or the eBPF probe failed to build, now that the other artifacts are built
+if compgen -G "` + builder.ArtifactLogsDirectory + `/*.failed" >/dev/null; then
+  exit 1
+fi
+`
+
+// ccacheStatsMarker precedes the output of ccache -s in the logs of the build, the statistics of the report are parsed from there.
+const ccacheStatsMarker = "driverkit: ccache statistics"
+
+var ccacheStatsScript = `
+echo "` + ccacheStatsMarker + `"
+ccache -s || true
+`
+
+// withTrustedCABundle makes the build script trust the CA bundle before downloading anything.
+func withTrustedCABundle(script string) string {
+	return afterShebang(script, trustCABundleScript)
+}
+
+// withLocalKernelWait makes the build script wait for the local kernel packages before installing them.
+func withLocalKernelWait(script string) string {
+	return afterShebang(script, waitForLocalKernelScript)
+}
+
+// withLocalDriverWait makes the build script wait for the local driver sources before building them.
+func withLocalDriverWait(script string) string {
+	return afterShebang(script, waitForLocalDriverScript)
+}
+
+// withModuleSigning makes the build script sign the kernel module once built,
+// with the key pair the processor copies into ModuleSigningDirectory and that the script removes once done.
+func withModuleSigning(script string) string {
+	return afterShebang(script, removeModuleSigningKeyScript) + "\n" + signModuleScript
+}
+
+// withCcache makes the build script compile the kernel module through ccache, then print its statistics.
+func withCcache(script string) string {
+	return afterShebang(script, ccacheScript) + "\n" + ccacheStatsScript
+}
+
+// withArtifactFailures makes the build script fail once done when the kernel module or the eBPF probe failed to build,
+// the processors then copy the artifacts that were built nonetheless. It must be the last snippet appended to the script.
+func withArtifactFailures(script string) string {
+	return script + "\n" + artifactFailuresScript
+}
+
+// afterShebang inserts the snippet at the very beginning of the script, right after the shebang if any.
+func afterShebang(script, snippet string) string {
+	lines := strings.SplitN(script, "\n", 2)
+	if len(lines) == 2 && strings.HasPrefix(lines[0], "#!") {
+		return lines[0] + "\n" + snippet + lines[1]
+	}
+	return snippet + script
+}
 
 type makefileData struct {
 	ModuleName     string
@@ -0,0 +1,36 @@
+package driverbuilder
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+)
+
+func TestRenderDriverNames(t *testing.T) {
+	var makefile bytes.Buffer
+	if err := renderMakefile(&makefile, makefileData{ModuleName: "acme", ModuleBuildDir: builder.DriverDirectory}); err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	var config bytes.Buffer
+	if err := renderFillDriverConfig(&config, driverConfigData{DriverVersion: "7.0.0+driver", DriverName: "acme", DeviceName: "acme-dev"}); err != nil {
+		t.Fatalf("Unexpected error encountered | Error: '%s'", err)
+	}
+	for name, test := range map[string]struct {
+		got  string
+		want []string
+	}{
+		"makefile":      {makefile.String(), []string{"acme-y += main.o", "obj-m += acme.o"}},
+		"driver config": {config.String(), []string{`#define DRIVER_NAME "acme"`, `#define PROBE_NAME "acme"`, `#define DRIVER_DEVICE_NAME "acme-dev"`, `#define PROBE_DEVICE_NAME "acme-dev"`}},
+	} {
+		for _, want := range test.want {
+			if !strings.Contains(test.got, want) {
+				t.Errorf("Test Input: '%s' | Got: '%s' / Want: '%s' in it", name, test.got, want)
+			}
+		}
+		if strings.Contains(test.got, "falco") {
+			t.Errorf("Test Input: '%s' | Got: '%s' / Want: no falco left in it", name, test.got)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package driverbuilder
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/validate"
+)
+
+// envNamePattern matches the names of the variables the build scripts can export.
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// pvcNamePattern matches the names of the persistent volume claims, DNS subdomains.
+var pvcNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+
+// ValidateBuild checks the build before it runs: the options the CLI validates, then what the builder of the target checks, see builder.Validate.
+// It returns the first of the problems of the build, see BuildProblems.
+func ValidateBuild(b *builder.Build) error {
+	if problems := BuildProblems(b); len(problems) > 0 {
+		return problems[0]
+	}
+	return nil
+}
+
+// BuildProblems returns all the problems ValidateBuild finds, in the order it checks them, none when the build is valid.
+// The builder of the target checks the build once the target and the kernel release are known.
+func BuildProblems(b *builder.Build) []error {
+	var problems []error
+	v, err := builder.Factory(b.TargetType)
+	if err != nil {
+		problems = append(problems, err)
+	}
+	if len(b.KernelRelease) == 0 {
+		problems = append(problems, fmt.Errorf("the kernel release is required"))
+	}
+	switch b.Architecture {
+	case "amd64", "arm64", "ppc64le", "s390x", "riscv64", "arm":
+	default:
+		problems = append(problems, fmt.Errorf("unsupported architecture %s, it must be amd64, arm64, ppc64le, s390x, riscv64 or arm", b.Architecture))
+	}
+	if len(b.PackageArchitecture) > 0 && (b.Architecture != "arm" || (b.PackageArchitecture != "armhf" && b.PackageArchitecture != "armel")) {
+		problems = append(problems, fmt.Errorf("invalid package architecture %s, it must be armhf or armel, for the arm builds only", b.PackageArchitecture))
+	}
+	if b.ForceEmulation && crossCompiledArchitectures[b.Architecture] {
+		problems = append(problems, fmt.Errorf("the %s builds cannot be emulated, there is no builder image for them", b.Architecture))
+	}
+	if len(b.GCCVersion) > 0 && crossCompiledArchitectures[b.Architecture] {
+		problems = append(problems, fmt.Errorf("the gcc of the %s builds cannot be chosen, they are cross compiled with the toolchain of the builder image", b.Architecture))
+	}
+	// LLVM is a dependency of the eBPF probe only, the kernel module only builds go without it
+	if len(b.LLVMVersion) > 0 && len(b.ProbeFilePath) > 0 && builderImageOf(b) == BuilderBaseImage && !containsString(builderImageLLVMVersions, b.LLVMVersion) {
+		problems = append(problems, fmt.Errorf("LLVM %s is not available in the builder image, it ships %s", b.LLVMVersion, strings.Join(builderImageLLVMVersions, ", ")))
+	}
+	if validate.V.Var(b.DriverVersion, "eq=master|sha1|semver|gitref") != nil {
+		problems = append(problems, fmt.Errorf("invalid driver version %s, it must be master, a git commit hash, a git tag or a git ref", b.DriverVersion))
+	}
+	if len(b.ModuleFilePath) == 0 && len(b.ProbeFilePath) == 0 && len(b.ModernProbeFilePath) == 0 {
+		problems = append(problems, fmt.Errorf("the output path of the kernel module, of the eBPF probe or of the modern eBPF probe is required"))
+	}
+	if len(b.ModuleFilePath) > 0 && !strings.HasSuffix(b.ModuleFilePath, ".ko") {
+		problems = append(problems, fmt.Errorf("invalid kernel module path %s, it must end with .ko", b.ModuleFilePath))
+	}
+	if len(b.ProbeFilePath) > 0 && !strings.HasSuffix(b.ProbeFilePath, ".o") {
+		problems = append(problems, fmt.Errorf("invalid eBPF probe path %s, it must end with .o", b.ProbeFilePath))
+	}
+	if len(b.ModernProbeFilePath) > 0 && !strings.HasSuffix(b.ModernProbeFilePath, ".h") {
+		problems = append(problems, fmt.Errorf("invalid modern eBPF probe path %s, it must end with .h, it is the skeleton of the probe", b.ModernProbeFilePath))
+	}
+	if len(b.KernelConfigFragments) > 0 && b.TargetType != builder.TargetTypeVanilla {
+		problems = append(problems, fmt.Errorf("the kernel config fragments are only merged by the %s target, %s builds against the config of its kernel packages", builder.TargetTypeVanilla, b.TargetType))
+	}
+	if len(b.DKMSFilePath) > 0 && !strings.HasSuffix(b.DKMSFilePath, ".tar.gz") {
+		problems = append(problems, fmt.Errorf("invalid DKMS package path %s, it must end with .tar.gz", b.DKMSFilePath))
+	}
+	if len(b.DKMSFilePath) > 0 && len(b.ModuleFilePath) == 0 {
+		problems = append(problems, fmt.Errorf("the DKMS package is assembled from the sources of the kernel module once built, its output path is required"))
+	}
+	if len(b.ModuleDriverName) > 60 || len(b.ModuleDeviceName) > 255 || strings.Contains(b.ModuleDeviceName, "/") {
+		problems = append(problems, fmt.Errorf("invalid kernel module names %s and %s", b.ModuleDriverName, b.ModuleDeviceName))
+	}
+	if len(b.ImageRepo) > 0 && len(b.CustomBuilderImage) > 0 && b.CustomBuilderImage != BuilderBaseImage {
+		problems = append(problems, fmt.Errorf("the builder image and the image repository cannot be used together"))
+	}
+	if len(b.ImageRepo) > 0 && (validate.V.Var(b.ImageRepo, "imagename") != nil || strings.ContainsAny(b.ImageRepo[strings.LastIndex(b.ImageRepo, "/")+1:], ":@")) {
+		problems = append(problems, fmt.Errorf("invalid image repository %s, it must be an image name without tag nor digest", b.ImageRepo))
+	}
+	if image := builderImageOf(b); validate.V.Var(image, "imagename") != nil {
+		problems = append(problems, fmt.Errorf("invalid builder image %s", image))
+	}
+	if b.TargetType == builder.TargetTypeRedhat && builderImageOf(b) == BuilderBaseImage {
+		problems = append(problems, fmt.Errorf("target redhat requires a builder image registered to download its packages"))
+	}
+	if b.TargetType == builder.TargetTypeBottlerocket && !strings.Contains(b.KernelVersion, "-") {
+		problems = append(problems, fmt.Errorf("target bottlerocket requires the variant as kernel version, e.g. aws-k8s-1.24"))
+	}
+	if len(b.LocalKernelDir) > 0 && len(b.KernelUrls) > 0 {
+		problems = append(problems, fmt.Errorf("the local kernel directory and the kernel URLs cannot be used together"))
+	}
+	if (len(b.KernelURLsAuth) > 0 || len(b.KernelURLsToken) > 0) && len(b.KernelUrls) == 0 {
+		problems = append(problems, fmt.Errorf("the kernel URLs credentials only authenticate the custom kernel URLs, they are required"))
+	}
+	if len(b.KernelURLsAuth) > 0 && len(b.KernelURLsToken) > 0 {
+		problems = append(problems, fmt.Errorf("the kernel URLs basic credentials and token cannot be used together"))
+	}
+	if len(b.KernelURLsAuth) > 0 && !strings.Contains(b.KernelURLsAuth, ":") {
+		problems = append(problems, fmt.Errorf("invalid kernel URLs credentials, they must be user:password"))
+	}
+	if strings.ContainsAny(b.KernelURLsAuth+b.KernelURLsToken, "\r\n") {
+		problems = append(problems, fmt.Errorf("invalid kernel URLs credentials, they cannot span lines"))
+	}
+	if len(b.LocalDriverDir) > 0 && (len(b.DriverRepo) > 0 || b.DriverUseGit) {
+		problems = append(problems, fmt.Errorf("the local driver directory cannot be used together with the driver repository nor git, its sources are not downloaded"))
+	}
+	if b.Checksum != "" && b.Checksum != "sha256" && b.Checksum != "sha512" {
+		problems = append(problems, fmt.Errorf("unsupported checksum algorithm %s", b.Checksum))
+	}
+	if _, ok := CompressionExtensions[b.Compression]; b.Compression != "" && !ok {
+		problems = append(problems, fmt.Errorf("unsupported compression algorithm %s", b.Compression))
+	}
+	if (len(b.ModuleSigningKey) > 0) != (len(b.ModuleSigningCert) > 0) {
+		problems = append(problems, fmt.Errorf("the module signing key and cert are required together"))
+	}
+	if len(b.ModuleSigningKey) > 0 && len(b.ModuleFilePath) == 0 {
+		problems = append(problems, fmt.Errorf("only the kernel module can be signed, its output path is required"))
+	}
+	if (len(b.RHELEntitlementCert) > 0) != (len(b.RHELEntitlementKey) > 0) {
+		problems = append(problems, fmt.Errorf("the RHEL entitlement cert and key are required together"))
+	}
+	if len(b.RHELEntitlementCert) > 0 && b.TargetType != builder.TargetTypeRedhat {
+		problems = append(problems, fmt.Errorf("the RHEL entitlement is only used by the redhat target, not by %s", b.TargetType))
+	}
+	if claim := strings.TrimPrefix(b.CcacheDir, builder.CcachePVCPrefix); len(b.CcacheDir) > 0 && !path.IsAbs(b.CcacheDir) && (claim == b.CcacheDir || !pvcNamePattern.MatchString(claim)) {
+		problems = append(problems, fmt.Errorf("invalid ccache directory %s, it must be an absolute path or a persistent volume claim as pvc:<name>", b.CcacheDir))
+	}
+	for name := range b.Env {
+		if !envNamePattern.MatchString(name) {
+			problems = append(problems, fmt.Errorf("invalid environment variable name %q, it must be made of letters, digits and underscores", name))
+		}
+	}
+	for _, s3 := range []struct{ url, output string }{{b.ModuleS3URL, b.ModuleFilePath}, {b.ProbeS3URL, b.ProbeFilePath}} {
+		if len(s3.url) == 0 {
+			continue
+		}
+		if validate.V.Var(s3.url, "s3url") != nil {
+			problems = append(problems, fmt.Errorf("invalid s3 url %s, it must be s3://bucket/key", s3.url))
+			continue
+		}
+		if len(s3.output) == 0 {
+			problems = append(problems, fmt.Errorf("only the drivers built can be uploaded to %s, their output path is required", s3.url))
+		}
+	}
+	problems = append(problems, MirrorsProblems(b)...)
+	if v == nil || len(b.KernelRelease) == 0 {
+		return problems
+	}
+	c := builder.Config{
+		DriverName: b.ModuleDriverName,
+		DeviceName: b.ModuleDeviceName,
+		Build:      b,
+	}
+	if err := builder.Validate(v, c, b.KernelReleaseFromBuildConfig()); err != nil {
+		problems = append(problems, err)
+	}
+	return problems
+}
+
+// MirrorsProblems returns the problems of the mirrors of the build: they must be given by target, see WithMirror,
+// their targets must look for the kernel packages into mirrors, and they must be http or https URLs.
+func MirrorsProblems(b *builder.Build) []error {
+	problems := []error{}
+	targets := []string{}
+	for t := range b.Mirrors {
+		targets = append(targets, t)
+	}
+	sort.Strings(targets)
+	for _, t := range targets {
+		if len(t) == 0 {
+			for _, m := range b.Mirrors[t] {
+				problems = append(problems, fmt.Errorf("invalid mirror %s, it must be given as target=URL", m))
+			}
+			continue
+		}
+		tb, err := builder.Factory(builder.Type(t))
+		if err != nil {
+			problems = append(problems, fmt.Errorf("invalid mirror target %s, %s", t, err))
+			continue
+		}
+		if _, ok := tb.(builder.MirrorsProvider); !ok {
+			problems = append(problems, fmt.Errorf("target %s has no mirrors to override, see driverkit mirrors", t))
+			continue
+		}
+		for _, m := range b.Mirrors[t] {
+			if u, err := url.Parse(m); err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+				problems = append(problems, fmt.Errorf("invalid mirror %s of target %s, it must be an http or https URL", m, t))
+			}
+		}
+	}
+	if b.ReplaceMirrors && len(b.Mirrors) == 0 {
+		problems = append(problems, fmt.Errorf("the built-in mirrors can only be replaced with the mirrors of the build, they are required"))
+	}
+	return problems
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
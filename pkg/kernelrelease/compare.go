@@ -0,0 +1,141 @@
+package kernelrelease
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Compare returns -1, 0 or +1 when the kernel release a is older than, the same as or newer than b.
+// They are ordered by version, patch level and sublevel, then by the full version (e.g. 5.15.102.1)
+// and by the distro revision, or the extraversion of the releases without one.
+// The release candidates are older than the release they precede, e.g. 6.8-rc3 is older than 6.8.0.
+// The digit runs are compared numerically, so that -28 is newer than -9, and ~ sorts before anything,
+// even the end of the revision, so that the backport 1~bpo12+1 is older than 1.
+func Compare(a, b KernelRelease) int {
+	for _, c := range [][2]int{{a.Version, b.Version}, {a.PatchLevel, b.PatchLevel}, {a.Sublevel, b.Sublevel}} {
+		if c[0] != c[1] {
+			if c[0] < c[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+	if a.RC != b.RC {
+		if b.RC == 0 || (a.RC != 0 && a.RC < b.RC) {
+			return -1
+		}
+		return 1
+	}
+	if c := compareVersionPart(a.Fullversion, b.Fullversion); c != 0 {
+		return c
+	}
+	return compareVersionPart(a.revision(), b.revision())
+}
+
+// GreaterThan tells whether the kernel release is newer than the other one, see Compare.
+func (kr KernelRelease) GreaterThan(other KernelRelease) bool {
+	return Compare(kr, other) > 0
+}
+
+// LessThan tells whether the kernel release is older than the other one, see Compare.
+func (kr KernelRelease) LessThan(other KernelRelease) bool {
+	return Compare(kr, other) < 0
+}
+
+// SortKernelReleases sorts the kernel releases from the oldest to the newest, the same ones keeping their order.
+func SortKernelReleases(releases []KernelRelease) {
+	sort.SliceStable(releases, func(i, j int) bool {
+		return releases[i].LessThan(releases[j])
+	})
+}
+
+// ParseDebianPackageVersion parses the version of a Debian kernel package, <upstream>-<revision>,
+// the Debian revision being the distro revision.
+// Example: 6.5.10-1~bpo12+1 -> Fullversion 6.5.10, DistroRevision 1~bpo12+1
+func ParseDebianPackageVersion(version string) (KernelRelease, error) {
+	upstream, revision := version, ""
+	if i := strings.Index(version, "-"); i >= 0 {
+		upstream, revision = version[:i], version[i+1:]
+	}
+	kr := FromString(upstream)
+	if len(kr.Fullversion) == 0 {
+		return kr, fmt.Errorf("invalid debian package version %s, it must be <upstream>-<revision>, e.g. 6.1.69-1", version)
+	}
+	kr.DistroRevision = revision
+	return kr, nil
+}
+
+// revision returns the part of the release compared after the full version.
+func (kr KernelRelease) revision() string {
+	if len(kr.DistroRevision) > 0 {
+		return kr.DistroRevision
+	}
+	return strings.TrimPrefix(kr.FullExtraversion, "-")
+}
+
+// compareVersionPart implements the dpkg comparison algorithm:
+// non-digit runs are compared lexically ('~' sorting before anything, even the end of the string,
+// letters before non-letters) and digit runs numerically.
+func compareVersionPart(a, b string) int {
+	order := func(s string, i int) int {
+		if i >= len(s) {
+			return 0
+		}
+		c := s[i]
+		switch {
+		case c >= '0' && c <= '9':
+			return 0
+		case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+			return int(c)
+		case c == '~':
+			return -1
+		}
+		return int(c) + 256
+	}
+	isDigit := func(s string, i int) bool {
+		return i < len(s) && s[i] >= '0' && s[i] <= '9'
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		for (i < len(a) && !isDigit(a, i)) || (j < len(b) && !isDigit(b, j)) {
+			oa, ob := order(a, i), order(b, j)
+			if oa != ob {
+				if oa < ob {
+					return -1
+				}
+				return 1
+			}
+			i++
+			j++
+		}
+		for i < len(a) && a[i] == '0' {
+			i++
+		}
+		for j < len(b) && b[j] == '0' {
+			j++
+		}
+		firstDiff := 0
+		for isDigit(a, i) && isDigit(b, j) {
+			if firstDiff == 0 {
+				firstDiff = int(a[i]) - int(b[j])
+			}
+			i++
+			j++
+		}
+		if isDigit(a, i) {
+			return 1
+		}
+		if isDigit(b, j) {
+			return -1
+		}
+		if firstDiff != 0 {
+			if firstDiff < 0 {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,172 @@
+package kernelrelease
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+	"testing/quick"
+
+	"gotest.tools/assert"
+)
+
+// orderedReleases are real-world releases, from the oldest to the newest.
+var orderedReleases = []string{
+	"4.14.181-140.257.amzn2.x86_64",
+	"4.19.0-9-amd64",
+	"4.19.0-25-amd64",
+	"5.10.0-9-amd64",
+	"5.10.0-27-amd64",
+	"5.10.0-28-amd64",
+	"5.10.25-1.ph4",
+	"5.15.0-25-generic",
+	"5.15.0-1019-azure",
+	"5.15.102.1-1.cm2",
+	"6.1.0-17-amd64",
+	"6.1.0-17-cloud-amd64",
+	"6.1.8",
+	"6.1.69",
+	"6.8-rc3",
+	"6.8-rc10",
+	"6.8.0",
+}
+
+func TestCompareOrderedReleases(t *testing.T) {
+	for i, a := range orderedReleases {
+		for j, b := range orderedReleases {
+			want := 0
+			switch {
+			case i < j:
+				want = -1
+			case i > j:
+				want = 1
+			}
+			assert.Equal(t, want, Compare(FromString(a), FromString(b)), "Compare(%s, %s)", a, b)
+		}
+	}
+}
+
+func TestCompareDebianPackageVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"6.1.69-1", "6.1.8-1", 1},
+		{"5.10.205-10", "5.10.205-2", 1},
+		{"5.10.205-2", "5.10.205-2", 0},
+		{"6.1.8-1~bpo11+1", "6.1.8-1", -1},
+		{"6.5.10-1~bpo12+1", "6.5.3-1~bpo12+1", 1},
+		{"4.19.304-1", "4.19.304-1+deb10u1", -1},
+		{"6.1.0", "6.1.8-1~bpo11+1", -1},
+	}
+	for _, tt := range tests {
+		a, err := ParseDebianPackageVersion(tt.a)
+		assert.NilError(t, err)
+		b, err := ParseDebianPackageVersion(tt.b)
+		assert.NilError(t, err)
+		assert.Equal(t, tt.want, Compare(a, b), "Compare(%s, %s)", tt.a, tt.b)
+		assert.Equal(t, tt.want > 0, a.GreaterThan(b))
+		assert.Equal(t, tt.want < 0, a.LessThan(b))
+	}
+	_, err := ParseDebianPackageVersion("invalid-1")
+	assert.ErrorContains(t, err, "invalid debian package version")
+}
+
+func TestCompareRevisions(t *testing.T) {
+	// the digit runs are compared numerically
+	assert.Assert(t, FromString("5.10.0-28-amd64").GreaterThan(FromString("5.10.0-9-amd64")))
+	// the distro revisions take precedence over the extraversions
+	a, _ := ParseDebian("5.10.0-28-amd64")
+	b, _ := ParseDebian("5.10.0-9-cloud-amd64")
+	assert.Assert(t, a.GreaterThan(b))
+	// the backports sort before the releases they are backported from
+	bpo, _ := ParseDebianPackageVersion("6.5.10-1~bpo12+1")
+	release, _ := ParseDebianPackageVersion("6.5.10-1")
+	assert.Assert(t, bpo.LessThan(release))
+}
+
+// randomRelease generates the releases the properties are checked against, with few distinct components
+// so that the equal ones are generated too.
+func randomRelease(r *rand.Rand) KernelRelease {
+	revisions := []string{"", "1", "2", "9", "10", "28", "1~bpo12+1", "1+deb10u1", "0.deb12.4"}
+	release := fmt.Sprintf("%d.%d.%d", 4+r.Intn(3), r.Intn(20), r.Intn(3))
+	if revision := revisions[r.Intn(len(revisions))]; len(revision) > 0 {
+		release += "-" + revision
+	}
+	kr, _ := ParseDebianPackageVersion(release)
+	return kr
+}
+
+type releaseTriple struct{ a, b, c KernelRelease }
+
+func (releaseTriple) Generate(r *rand.Rand, _ int) reflect.Value {
+	return reflect.ValueOf(releaseTriple{randomRelease(r), randomRelease(r), randomRelease(r)})
+}
+
+func TestCompareProperties(t *testing.T) {
+	sign := func(c int) int {
+		switch {
+		case c < 0:
+			return -1
+		case c > 0:
+			return 1
+		}
+		return 0
+	}
+	properties := map[string]func(releaseTriple) bool{
+		"reflexive": func(tr releaseTriple) bool {
+			return Compare(tr.a, tr.a) == 0
+		},
+		"antisymmetric": func(tr releaseTriple) bool {
+			return sign(Compare(tr.a, tr.b)) == -sign(Compare(tr.b, tr.a))
+		},
+		"transitive": func(tr releaseTriple) bool {
+			if Compare(tr.a, tr.b) <= 0 && Compare(tr.b, tr.c) <= 0 {
+				return Compare(tr.a, tr.c) <= 0
+			}
+			return true
+		},
+		"consistent with the helpers": func(tr releaseTriple) bool {
+			c := Compare(tr.a, tr.b)
+			return tr.a.GreaterThan(tr.b) == (c > 0) && tr.a.LessThan(tr.b) == (c < 0)
+		},
+	}
+	for name, property := range properties {
+		t.Run(name, func(t *testing.T) {
+			assert.NilError(t, quick.Check(property, &quick.Config{MaxCount: 1000}))
+		})
+	}
+}
+
+func TestSortKernelReleases(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	releases := make([]KernelRelease, 0, len(orderedReleases))
+	for _, i := range r.Perm(len(orderedReleases)) {
+		releases = append(releases, FromString(orderedReleases[i]))
+	}
+	SortKernelReleases(releases)
+	for i, kr := range releases {
+		assert.Equal(t, FromString(orderedReleases[i]), kr)
+	}
+
+	// the result is sorted whatever the input, the same releases keeping their order
+	for n := 0; n < 100; n++ {
+		releases := make([]KernelRelease, 20)
+		for i := range releases {
+			releases[i] = randomRelease(r)
+			// the flavor is not compared, it tells the original index
+			releases[i].Flavor = strconv.Itoa(i)
+		}
+		SortKernelReleases(releases)
+		for i := 1; i < len(releases); i++ {
+			c := Compare(releases[i-1], releases[i])
+			assert.Assert(t, c <= 0, "%v sorted before %v", releases[i-1], releases[i])
+			if c == 0 {
+				before, _ := strconv.Atoi(releases[i-1].Flavor)
+				after, _ := strconv.Atoi(releases[i].Flavor)
+				assert.Assert(t, before < after, "%v moved after %v", releases[i-1], releases[i])
+			}
+		}
+	}
+}
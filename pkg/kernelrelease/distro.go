@@ -0,0 +1,99 @@
+package kernelrelease
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// debianExtraversionPattern matches the Debian extraversions, -<abi>-[<featureset>-]<flavor>, e.g. -27-rt-amd64.
+	debianExtraversionPattern = regexp.MustCompile(`^-(\d+(?:\.[0-9a-z]+)*)-([0-9a-z]+(?:-[0-9a-z]+)*)$`)
+	// ubuntuExtraversionPattern matches the Ubuntu extraversions, -<abi>[-<flavor>], e.g. -1019-azure or -25-generic-5.15.
+	ubuntuExtraversionPattern = regexp.MustCompile(`^-(\d+)(?:-([a-z-]+[a-z])-*\d?.*)?$`)
+	// rpmArchPattern matches the architecture the RPM based releases end with, e.g. .x86_64.
+	rpmArchPattern = regexp.MustCompile(`\.(x86_64|aarch64|ppc64le|s390x|riscv64|i686)$`)
+	// rhelRealtimePattern matches the realtime releases, e.g. 372.9.1.rt7.166.el8.
+	rhelRealtimePattern = regexp.MustCompile(`(^|\.)rt\d+(\.|$)`)
+)
+
+// debianPackageArchs are the package architectures of the Debian flavors, by the suffix of the flavor.
+// The first matching suffix wins, the longer ones come first.
+var debianPackageArchs = []struct{ suffix, arch string }{
+	{"amd64", "amd64"},
+	{"arm64", "arm64"},
+	{"powerpc64le", "ppc64el"},
+	{"s390x", "s390x"},
+	{"riscv64", "riscv64"},
+	{"armmp-lpae", "armhf"},
+	{"armmp", "armhf"},
+	{"marvell", "armel"},
+	{"rpi", "armel"},
+	{"686-pae", "i386"},
+	{"686", "i386"},
+}
+
+// ParseDebian parses a Debian kernel release, -<abi>-[<featureset>-]<flavor>:
+// the ABI is the distro revision, the featureset and the flavor are the flavor, e.g. rt-amd64,
+// and the package architecture is the one of the flavor.
+// Example: 5.10.0-27-rt-amd64 -> DistroRevision 27, Flavor rt-amd64, PackageArch amd64
+func ParseDebian(release string) (KernelRelease, error) {
+	kr := FromString(release)
+	match := debianExtraversionPattern.FindStringSubmatch(kr.FullExtraversion)
+	if len(kr.Fullversion) == 0 || match == nil {
+		return kr, fmt.Errorf("invalid debian kernel release %s, it must end with -<abi>-[<flavor>-]<arch>, e.g. 5.10.0-21-amd64 or 6.1.0-17-cloud-arm64", release)
+	}
+	kr.DistroRevision, kr.Flavor = match[1], match[2]
+	for _, a := range debianPackageArchs {
+		if kr.Flavor == a.suffix || strings.HasSuffix(kr.Flavor, "-"+a.suffix) {
+			kr.PackageArch = a.arch
+			break
+		}
+	}
+	return kr, nil
+}
+
+// ParseUbuntu parses an Ubuntu kernel release, -<abi>[-<flavor>], the flavor being generic when not given.
+// The flavor drops the version some of them are suffixed with, the package architecture is not told by the release.
+// Example: 5.15.0-1034-intel-iotg-5.15 -> DistroRevision 1034, Flavor intel-iotg
+func ParseUbuntu(release string) (KernelRelease, error) {
+	kr := FromString(release)
+	match := ubuntuExtraversionPattern.FindStringSubmatch(kr.FullExtraversion)
+	if len(kr.Fullversion) == 0 || match == nil {
+		return kr, fmt.Errorf("invalid ubuntu kernel release %s, it must end with -<abi>-<flavor>, e.g. 5.15.0-25-generic or 4.15.0-1129-aws", release)
+	}
+	kr.DistroRevision, kr.Flavor = match[1], match[2]
+	if len(kr.Flavor) == 0 {
+		kr.Flavor = "generic"
+	}
+	return kr, nil
+}
+
+// ParseRHEL parses the release of an RPM based distribution, RHEL and its clones, Amazon Linux, Oracle Linux or Photon OS:
+// the release of the package, dist tag included, is the distro revision and the architecture it ends with, if any, the package one.
+// The flavor is the one of the variants packaged on their own: rt, uek or the Photon OS ones (e.g. esx), none otherwise.
+// Example: 4.18.0-372.9.1.rt7.166.el8.x86_64 -> DistroRevision 372.9.1.rt7.166.el8, Flavor rt, PackageArch x86_64
+func ParseRHEL(release string) (KernelRelease, error) {
+	kr := FromString(release)
+	if len(kr.Fullversion) == 0 || len(kr.FullExtraversion) < 2 {
+		return kr, fmt.Errorf("invalid rpm kernel release %s, it must end with -<release>[.<arch>], e.g. 4.18.0-372.9.1.el8.x86_64", release)
+	}
+	revision := kr.FullExtraversion[1:]
+	if match := rpmArchPattern.FindStringSubmatch(revision); match != nil {
+		kr.PackageArch = match[1]
+		revision = strings.TrimSuffix(revision, match[0])
+	}
+	// the Photon OS variants are appended to the release, e.g. 1.ph4-esx
+	if i := strings.Index(revision, "-"); i > 0 {
+		revision, kr.Flavor = revision[:i], revision[i+1:]
+	}
+	switch {
+	case len(kr.Flavor) > 0:
+	case rhelRealtimePattern.MatchString(revision):
+		kr.Flavor = "rt"
+	case strings.HasSuffix(revision, "uek"):
+		kr.Flavor = "uek"
+	}
+	kr.DistroRevision = revision
+	return kr, nil
+}
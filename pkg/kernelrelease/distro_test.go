@@ -0,0 +1,112 @@
+package kernelrelease
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+type distroFields struct {
+	fullversion    string
+	flavor         string
+	distroRevision string
+	packageArch    string
+}
+
+func assertDistroFields(t *testing.T, parse func(string) (KernelRelease, error), tests map[string]distroFields) {
+	t.Helper()
+	for release, want := range tests {
+		t.Run(release, func(t *testing.T) {
+			got, err := parse(release)
+			assert.NilError(t, err)
+			assert.Equal(t, want.fullversion, got.Fullversion)
+			assert.Equal(t, want.flavor, got.Flavor)
+			assert.Equal(t, want.distroRevision, got.DistroRevision)
+			assert.Equal(t, want.packageArch, got.PackageArch)
+		})
+	}
+}
+
+func TestParseDebian(t *testing.T) {
+	assertDistroFields(t, ParseDebian, map[string]distroFields{
+		"4.19.0-25-amd64":              {"4.19.0", "amd64", "25", "amd64"},
+		"5.10.0-27-amd64":              {"5.10.0", "amd64", "27", "amd64"},
+		"5.10.0-27-arm64":              {"5.10.0", "arm64", "27", "arm64"},
+		"5.10.0-27-rt-amd64":           {"5.10.0", "rt-amd64", "27", "amd64"},
+		"5.10.0-27-rt-arm64":           {"5.10.0", "rt-arm64", "27", "arm64"},
+		"5.10.0-27-cloud-amd64":        {"5.10.0", "cloud-amd64", "27", "amd64"},
+		"6.1.0-17-cloud-arm64":         {"6.1.0", "cloud-arm64", "17", "arm64"},
+		"6.1.0-18-powerpc64le":         {"6.1.0", "powerpc64le", "18", "ppc64el"},
+		"5.10.0-21-s390x":              {"5.10.0", "s390x", "21", "s390x"},
+		"6.12.6-1-riscv64":             {"6.12.6", "riscv64", "1", "riscv64"},
+		"5.10.0-27-armmp":              {"5.10.0", "armmp", "27", "armhf"},
+		"5.10.0-27-armmp-lpae":         {"5.10.0", "armmp-lpae", "27", "armhf"},
+		"5.10.0-27-rt-armmp":           {"5.10.0", "rt-armmp", "27", "armhf"},
+		"5.10.0-27-marvell":            {"5.10.0", "marvell", "27", "armel"},
+		"6.1.0-17-rpi":                 {"6.1.0", "rpi", "17", "armel"},
+		"5.10.0-27-686-pae":            {"5.10.0", "686-pae", "27", "i386"},
+		"5.10.0-27-rt-686-pae":         {"5.10.0", "rt-686-pae", "27", "i386"},
+		"5.10.0-0.deb10.16-amd64":      {"5.10.0", "amd64", "0.deb10.16", "amd64"},
+		"6.1.0-0.deb11.13-cloud-amd64": {"6.1.0", "cloud-amd64", "0.deb11.13", "amd64"},
+	})
+}
+
+func TestParseDebianInvalid(t *testing.T) {
+	for _, release := range []string{"5.10.0", "5.10.0-amd64", "5.10.0-27", "invalid"} {
+		_, err := ParseDebian(release)
+		assert.ErrorContains(t, err, "invalid debian kernel release")
+	}
+}
+
+func TestParseUbuntu(t *testing.T) {
+	assertDistroFields(t, ParseUbuntu, map[string]distroFields{
+		"5.15.0-25-generic":             {"5.15.0", "generic", "25", ""},
+		"4.15.0-188-generic":            {"4.15.0", "generic", "188", ""},
+		"5.15.0-86-lowlatency":          {"5.15.0", "lowlatency", "86", ""},
+		"4.15.0-1129-aws":               {"4.15.0", "aws", "1129", ""},
+		"5.15.0-1019-azure":             {"5.15.0", "azure", "1019", ""},
+		"5.4.0-1086-azure-fde":          {"5.4.0", "azure-fde", "1086", ""},
+		"5.15.0-1032-realtime":          {"5.15.0", "realtime", "1032", ""},
+		"5.15.0-1034-intel-iotg":        {"5.15.0", "intel-iotg", "1034", ""},
+		"5.15.0-1034-intel-iotg-5.15":   {"5.15.0", "intel-iotg", "1034", ""},
+		"5.19.0-1010-nvidia-lowlatency": {"5.19.0", "nvidia-lowlatency", "1010", ""},
+		"5.4.0-1048-raspi":              {"5.4.0", "raspi", "1048", ""},
+		"5.15.0-25-generic-5":           {"5.15.0", "generic", "25", ""},
+		"5.15.0-25":                     {"5.15.0", "generic", "25", ""},
+	})
+}
+
+func TestParseUbuntuInvalid(t *testing.T) {
+	for _, release := range []string{"5.15.0", "5.15.0-generic", "invalid"} {
+		_, err := ParseUbuntu(release)
+		assert.ErrorContains(t, err, "invalid ubuntu kernel release")
+	}
+}
+
+func TestParseRHEL(t *testing.T) {
+	assertDistroFields(t, ParseRHEL, map[string]distroFields{
+		"3.10.0-1160.el7.x86_64":              {"3.10.0", "", "1160.el7", "x86_64"},
+		"4.18.0-372.9.1.el8.x86_64":           {"4.18.0", "", "372.9.1.el8", "x86_64"},
+		"5.14.0-362.8.1.el9_3.aarch64":        {"5.14.0", "", "362.8.1.el9_3", "aarch64"},
+		"4.18.0-348.7.1.el8_5.ppc64le":        {"4.18.0", "", "348.7.1.el8_5", "ppc64le"},
+		"4.18.0-372.9.1.rt7.166.el8.x86_64":   {"4.18.0", "rt", "372.9.1.rt7.166.el8", "x86_64"},
+		"5.14.0-70.13.1.rt21.83.el9_0.x86_64": {"5.14.0", "rt", "70.13.1.rt21.83.el9_0", "x86_64"},
+		"5.4.17-2136.307.3.1.el8uek.x86_64":   {"5.4.17", "uek", "2136.307.3.1.el8uek", "x86_64"},
+		"5.15.0-200.131.27.el9uek.aarch64":    {"5.15.0", "uek", "200.131.27.el9uek", "aarch64"},
+		"4.14.181-140.257.amzn2.x86_64":       {"4.14.181", "", "140.257.amzn2", "x86_64"},
+		"5.10.109-104.500.amzn2.aarch64":      {"5.10.109", "", "104.500.amzn2", "aarch64"},
+		"6.1.61-85.141.amzn2023.x86_64":       {"6.1.61", "", "85.141.amzn2023", "x86_64"},
+		"5.10.25-1.ph4":                       {"5.10.25", "", "1.ph4", ""},
+		"4.19.225-3.ph3-esx":                  {"4.19.225", "esx", "3.ph3", ""},
+		"5.10.25-1.ph4-rt":                    {"5.10.25", "rt", "1.ph4", ""},
+		"5.15.102.1-1.cm2":                    {"5.15.102.1", "", "1.cm2", ""},
+		"5.14.21-150400.24.46-default":        {"5.14.21", "default", "150400.24.46", ""},
+	})
+}
+
+func TestParseRHELInvalid(t *testing.T) {
+	for _, release := range []string{"4.18.0", "invalid"} {
+		_, err := ParseRHEL(release)
+		assert.ErrorContains(t, err, "invalid rpm kernel release")
+	}
+}
@@ -0,0 +1,69 @@
+package kernelrelease
+
+import (
+	"encoding/json"
+
+	"gopkg.in/yaml.v3"
+)
+
+// kernelReleaseFields has the fields of KernelRelease, not its (un)marshalling methods.
+type kernelReleaseFields KernelRelease
+
+// encodedKernelRelease is the encoding of a KernelRelease: the release string next to its parsed fields.
+type encodedKernelRelease struct {
+	Release             string `json:"release,omitempty" yaml:"release,omitempty"`
+	kernelReleaseFields `yaml:",inline"`
+}
+
+func (kr KernelRelease) encoded() encodedKernelRelease {
+	return encodedKernelRelease{Release: kr.String(), kernelReleaseFields: kernelReleaseFields(kr)}
+}
+
+// decoded returns the decoded kernel release, its version parts parsed from the release string when given,
+// so that the documents only telling it, e.g. {"release": "5.15.0-25-generic", "architecture": "amd64"}, are enough.
+func (e encodedKernelRelease) decoded() KernelRelease {
+	kr := KernelRelease(e.kernelReleaseFields)
+	if len(e.Release) == 0 {
+		return kr
+	}
+	parsed := FromString(e.Release)
+	kr.Fullversion = parsed.Fullversion
+	kr.Version = parsed.Version
+	kr.PatchLevel = parsed.PatchLevel
+	kr.Sublevel = parsed.Sublevel
+	kr.Extraversion = parsed.Extraversion
+	kr.FullExtraversion = parsed.FullExtraversion
+	kr.LocalVersion = parsed.LocalVersion
+	kr.RC = parsed.RC
+	return kr
+}
+
+// MarshalJSON encodes the kernel release as its release string and its parsed fields.
+func (kr KernelRelease) MarshalJSON() ([]byte, error) {
+	return json.Marshal(kr.encoded())
+}
+
+// UnmarshalJSON decodes the kernel release, parsing the release string when given, see MarshalJSON.
+func (kr *KernelRelease) UnmarshalJSON(data []byte) error {
+	var e encodedKernelRelease
+	if err := json.Unmarshal(data, &e); err != nil {
+		return err
+	}
+	*kr = e.decoded()
+	return nil
+}
+
+// MarshalYAML encodes the kernel release as its release string and its parsed fields.
+func (kr KernelRelease) MarshalYAML() (interface{}, error) {
+	return kr.encoded(), nil
+}
+
+// UnmarshalYAML decodes the kernel release, parsing the release string when given, see MarshalYAML.
+func (kr *KernelRelease) UnmarshalYAML(value *yaml.Node) error {
+	var e encodedKernelRelease
+	if err := value.Decode(&e); err != nil {
+		return err
+	}
+	*kr = e.decoded()
+	return nil
+}
@@ -0,0 +1,106 @@
+package kernelrelease
+
+import (
+	"encoding/json"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+	"gotest.tools/assert"
+)
+
+var encodingReleases = []string{
+	"5.15.0-25-generic",
+	"5.10.0-27-rt-amd64",
+	"4.18.0-372.9.1.el8.x86_64",
+	"5.15.138.1-1.cm2",
+	"6.1.21-v8+",
+	"5.16.5-arch1-1",
+	"6.1.69",
+}
+
+func TestKernelReleaseJSONRoundTrip(t *testing.T) {
+	for _, release := range encodingReleases {
+		kr, _ := ParseDebian(release)
+		kr.Architecture = "amd64"
+		kr.KernelVersion = "25"
+		data, err := json.Marshal(kr)
+		assert.NilError(t, err)
+		var got KernelRelease
+		assert.NilError(t, json.Unmarshal(data, &got))
+		assert.Equal(t, kr, got, "Test Input: '%s' | Encoded: '%s'", release, data)
+		assert.Equal(t, release, got.String())
+	}
+}
+
+func TestKernelReleaseYAMLRoundTrip(t *testing.T) {
+	for _, release := range encodingReleases {
+		kr := FromUname(release, "#25-Ubuntu SMP Wed Mar 30 15:54:22 UTC 2022")
+		kr.Architecture = "arm64"
+		data, err := yaml.Marshal(kr)
+		assert.NilError(t, err)
+		var got KernelRelease
+		assert.NilError(t, yaml.Unmarshal(data, &got))
+		assert.Equal(t, kr, got, "Test Input: '%s' | Encoded: '%s'", release, data)
+		assert.Equal(t, release, got.String())
+	}
+}
+
+func TestKernelReleaseEncodedFields(t *testing.T) {
+	data, err := yaml.Marshal(FromString("6.1.21-v8+"))
+	assert.NilError(t, err)
+	assert.Equal(t, `release: 6.1.21-v8+
+full_version: 6.1.21
+version: 6
+patch_level: 1
+sublevel: 21
+extra_version: v8
+full_extra_version: -v8
+local_version: +
+architecture: ""
+`, string(data))
+}
+
+func TestKernelReleaseDecodeFromRelease(t *testing.T) {
+	want := FromString("5.15.0-25-generic")
+	want.Architecture = "amd64"
+
+	var fromJSON KernelRelease
+	assert.NilError(t, json.Unmarshal([]byte(`{"release":"5.15.0-25-generic","architecture":"amd64"}`), &fromJSON))
+	assert.Equal(t, want, fromJSON)
+
+	var fromYAML KernelRelease
+	assert.NilError(t, yaml.Unmarshal([]byte("release: 5.15.0-25-generic\narchitecture: amd64\n"), &fromYAML))
+	assert.Equal(t, want, fromYAML)
+
+	// the release string wins over the version parts
+	var overridden KernelRelease
+	assert.NilError(t, json.Unmarshal([]byte(`{"release":"5.15.0-25-generic","full_version":"4.19.0","version":4}`), &overridden))
+	assert.Equal(t, FromString("5.15.0-25-generic"), overridden)
+
+	// the documents without it are decoded as they are
+	var fields KernelRelease
+	assert.NilError(t, json.Unmarshal([]byte(`{"full_version":"4.19.0","version":4,"patch_level":19}`), &fields))
+	assert.Equal(t, KernelRelease{Fullversion: "4.19.0", Version: 4, PatchLevel: 19}, fields)
+
+	var invalid KernelRelease
+	assert.ErrorContains(t, json.Unmarshal([]byte(`{"release":5}`), &invalid), "cannot unmarshal")
+}
+
+func TestFromUname(t *testing.T) {
+	tests := []struct {
+		r, v          string
+		release       string
+		kernelVersion string
+	}{
+		{"5.15.0-25-generic", "#25-Ubuntu SMP Wed Mar 30 15:54:22 UTC 2022", "5.15.0-25-generic", "25"},
+		{"4.14.186-146.268.amzn2.x86_64\n", "#1 SMP Tue Jul 14 18:16:52 UTC 2020\n", "4.14.186-146.268.amzn2.x86_64", "1"},
+		{"6.1.0-17-amd64", "#1 SMP PREEMPT_DYNAMIC Debian 6.1.69-1 (2023-12-30)", "6.1.0-17-amd64", "1"},
+		{"5.10.0", "SMP PREEMPT", "5.10.0", ""},
+		{"invalid", "", "", ""},
+	}
+	for _, tt := range tests {
+		got := FromUname(tt.r, tt.v)
+		assert.Equal(t, tt.release, got.String(), "Test Input: '%s' '%s'", tt.r, tt.v)
+		assert.Equal(t, tt.kernelVersion, got.KernelVersion, "Test Input: '%s' '%s'", tt.r, tt.v)
+	}
+}
@@ -0,0 +1,73 @@
+//go:build go1.18
+// +build go1.18
+
+package kernelrelease
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+var fuzzSeeds = []string{
+	"5.15.0-25-generic",
+	"5.10.0-27-rt-amd64",
+	"4.18.0-372.9.1.rt7.166.el8.x86_64",
+	"5.15.138.1-1.cm2",
+	"6.1.21-v8+",
+	"6.5.10-1~bpo12+1",
+	"99999999999999999999.0.0",
+	"",
+	"-",
+	"5.",
+	"#1 SMP",
+	"6.8-rc3",
+}
+
+func FuzzFromString(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, release string) {
+		kr := FromString(release)
+		if len(kr.Fullversion) > 0 && kr.String() != release {
+			t.Fatalf("Test Input: '%s' | Got: '%s' / Want: '%s'", release, kr.String(), release)
+		}
+		_, _ = ParseDebian(release)
+		_, _ = ParseUbuntu(release)
+		_, _ = ParseRHEL(release)
+		_, _ = ParseDebianPackageVersion(release)
+		_ = Compare(kr, FromString("5.15.0-25-generic"))
+	})
+}
+
+func FuzzFromUname(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed, "#25-Ubuntu SMP Wed Mar 30 15:54:22 UTC 2022")
+		f.Add(seed, seed)
+	}
+	f.Fuzz(func(t *testing.T, r, v string) {
+		kr := FromUname(r, v)
+		data, err := json.Marshal(kr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got KernelRelease
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got != kr {
+			t.Fatalf("Test Input: '%s' '%s' | Got: '%+v' / Want: '%+v'", r, v, got, kr)
+		}
+	})
+}
+
+func FuzzUnmarshalJSON(f *testing.F) {
+	f.Add(`{"release":"5.15.0-25-generic","architecture":"amd64"}`)
+	f.Add(`{"full_version":"4.19.0","version":4}`)
+	f.Add(`{"release":5}`)
+	f.Add(`null`)
+	f.Fuzz(func(t *testing.T, data string) {
+		var kr KernelRelease
+		_ = json.Unmarshal([]byte(data), &kr)
+	})
+}
@@ -1,27 +1,74 @@
 package kernelrelease
 
 import (
-	"log"
 	"regexp"
 	"strconv"
+	"strings"
 )
 
 var (
-	kernelVersionPattern = regexp.MustCompile(`(?P<fullversion>^(?P<version>0|[1-9]\d*)\.(?P<patchlevel>0|[1-9]\d*)\.(?P<sublevel>0|[1-9]\d*))(?P<fullextraversion>-(?P<extraversion>0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(\.(0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-_]*))*)?(\+[0-9a-zA-Z-]+(\.[0-9a-zA-Z-]+)*)?$`)
+	kernelVersionPattern = regexp.MustCompile(`(?P<fullversion>^(?P<version>0|[1-9]\d*)\.(?P<patchlevel>0|[1-9]\d*)(\.(?P<sublevel>0|[1-9]\d*)(\.\d+)?)?)(?P<fullextraversion>-(?P<extraversion>0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(\.(0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-_]*))*)?(?P<localversion>\+[0-9a-zA-Z-]*(\.[0-9a-zA-Z-]+)*)?$`)
+	// unameVersionPattern matches the numeric value after the hash of uname -v, e.g. #26-Ubuntu SMP.
+	unameVersionPattern = regexp.MustCompile(`^#(\d+)`)
+	// releaseCandidatePattern matches the extraversion of the release candidates, e.g. rc3 of 6.8-rc3 or 6.8.0-rc3.
+	releaseCandidatePattern = regexp.MustCompile(`^rc([1-9]\d*)(-|$)`)
 )
 
 type Architecture string
 
+// Architectures are the architectures the drivers can be built for.
+var Architectures = []Architecture{"amd64", "arm64", "ppc64le", "s390x", "riscv64", "arm"}
+
 func (a Architecture) ToNonDeb() string {
 	switch a {
 	case "arm64":
 		return "aarch64"
 	case "amd64":
 		return "x86_64"
+	case "ppc64le":
+		return "ppc64le"
+	case "s390x":
+		return "s390x"
+	case "riscv64":
+		return "riscv64"
 	}
 	return ""
 }
 
+// ToKernel returns the architecture as named by the kernel build system, the ARCH it is given.
+func (a Architecture) ToKernel() string {
+	switch a {
+	case "amd64", "x86_64":
+		return "x86_64"
+	case "arm64", "aarch64":
+		return "arm64"
+	case "ppc64le":
+		return "powerpc"
+	case "s390x":
+		return "s390"
+	case "riscv64":
+		return "riscv"
+	case "arm", "armhf", "armel", "armv7l":
+		return "arm"
+	}
+	return string(a)
+}
+
+// ToDeb returns the architecture as named by Debian packages.
+func (a Architecture) ToDeb() string {
+	switch a {
+	case "aarch64":
+		return "arm64"
+	case "x86_64":
+		return "amd64"
+	case "armv7l":
+		return "armhf"
+	case "ppc64le":
+		return "ppc64el"
+	}
+	return string(a)
+}
+
 func (a Architecture) String() string {
 	return string(a)
 }
@@ -32,16 +79,28 @@ func (a Architecture) String() string {
 // Instead, rely on the global option
 // (it it set for builders in kernelReleaseFromBuildConfig())
 type KernelRelease struct {
-	Fullversion      string       `json:"full_version"`
-	Version          int          `json:"version"`
-	PatchLevel       int          `json:"patch_level"`
-	Sublevel         int          `json:"sublevel"`
-	Extraversion     string       `json:"extra_version"`
-	FullExtraversion string       `json:"full_extra_version"`
-	Architecture     Architecture `json:"architecture"`
+	Fullversion      string `json:"full_version" yaml:"full_version"`
+	Version          int    `json:"version" yaml:"version"`
+	PatchLevel       int    `json:"patch_level" yaml:"patch_level"`
+	Sublevel         int    `json:"sublevel" yaml:"sublevel"`
+	Extraversion     string `json:"extra_version" yaml:"extra_version"`
+	FullExtraversion string `json:"full_extra_version" yaml:"full_extra_version"`
+	// LocalVersion is the local version marker some releases end with, e.g. the + of 6.1.21-v8+.
+	LocalVersion string       `json:"local_version,omitempty" yaml:"local_version,omitempty"`
+	Architecture Architecture `json:"architecture" yaml:"architecture"`
+	// Flavor, DistroRevision and PackageArch are the distribution specific components of the extraversion,
+	// only set by the parsing helpers of the distributions, e.g. ParseDebian.
+	Flavor         string `json:"flavor,omitempty" yaml:"flavor,omitempty"`
+	DistroRevision string `json:"distro_revision,omitempty" yaml:"distro_revision,omitempty"`
+	PackageArch    string `json:"package_arch,omitempty" yaml:"package_arch,omitempty"`
+	// RC is the number of the release candidate, e.g. 3 for 6.8-rc3, zero for the released kernels.
+	RC int `json:"rc,omitempty" yaml:"rc,omitempty"`
+	// KernelVersion is the numeric value after the hash of uname -v, only set by FromUname.
+	KernelVersion string `json:"kernel_version,omitempty" yaml:"kernel_version,omitempty"`
 }
 
-// FromString extracts a KernelRelease object from string.
+// FromString extracts a KernelRelease object from string, an empty one when it is not a valid kernel release.
+// The sublevel can only be omitted by the release candidates, as their tarballs are named, e.g. 6.8-rc3.
 func FromString(kernelVersionStr string) KernelRelease {
 	kv := KernelRelease{}
 	match := kernelVersionPattern.FindStringSubmatch(kernelVersionStr)
@@ -58,18 +117,46 @@ func FromString(kernelVersionStr string) KernelRelease {
 			case "patchlevel":
 				kv.PatchLevel, err = strconv.Atoi(match[i])
 			case "sublevel":
-				kv.Sublevel, err = strconv.Atoi(match[i])
+				if len(match[i]) > 0 {
+					kv.Sublevel, err = strconv.Atoi(match[i])
+				}
 			case "extraversion":
 				kv.Extraversion = match[i]
 			case "fullextraversion":
 				kv.FullExtraversion = match[i]
+			case "localversion":
+				kv.LocalVersion = match[i]
 			}
 
+			// the version parts overflowing an int are not valid ones
 			if err != nil {
-				log.Fatal(err)
+				return KernelRelease{}
 			}
 		}
 	}
 
+	if rc := releaseCandidatePattern.FindStringSubmatch(kv.Extraversion); rc != nil {
+		kv.RC, _ = strconv.Atoi(rc[1])
+	}
+	if len(kv.Fullversion) > 0 && len(identifiers["sublevel"]) == 0 && kv.RC == 0 {
+		return KernelRelease{}
+	}
 	return kv
 }
+
+// FromUname extracts a KernelRelease object from the outputs of uname -r and uname -v,
+// the kernel version being the numeric value after the hash of the latter, if any.
+// Example: 5.15.0-25-generic, #25-Ubuntu SMP Wed Mar 30 15:54:22 UTC 2022 -> KernelVersion 25
+func FromUname(r, v string) KernelRelease {
+	kr := FromString(strings.TrimSpace(r))
+	if match := unameVersionPattern.FindStringSubmatch(strings.TrimSpace(v)); match != nil {
+		kr.KernelVersion = match[1]
+	}
+	return kr
+}
+
+// String returns the kernel release as given to FromString, e.g. 5.15.0-25-generic,
+// an empty string when it could not be parsed.
+func (kr KernelRelease) String() string {
+	return kr.Fullversion + kr.FullExtraversion + kr.LocalVersion
+}
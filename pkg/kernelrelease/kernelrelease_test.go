@@ -21,7 +21,7 @@ func TestFromKrToJson(t *testing.T) {
 			FullExtraversion: "-arch1-1",
 			Architecture:     "amd64",
 		},
-		want: `{"full_version":"5.16.5","version":5,"patch_level":16,"sublevel":5,"extra_version":"arch1-1","full_extra_version":"-arch1-1","architecture":"amd64"}`,
+		want: `{"release":"5.16.5-arch1-1","full_version":"5.16.5","version":5,"patch_level":16,"sublevel":5,"extra_version":"arch1-1","full_extra_version":"-arch1-1","architecture":"amd64"}`,
 	}
 	t.Run("version with local version", func(t *testing.T) {
 		got, _ := json.Marshal(test.kernelRelease)
@@ -56,6 +56,55 @@ func TestFromString(t *testing.T) {
 				FullExtraversion: "",
 			},
 		},
+		"version with trailing plus": {
+			kernelVersionStr: "6.1.21-v8+",
+			want: KernelRelease{
+				Fullversion:      "6.1.21",
+				Version:          6,
+				PatchLevel:       1,
+				Sublevel:         21,
+				Extraversion:     "v8",
+				FullExtraversion: "-v8",
+				LocalVersion:     "+",
+			},
+		},
+		"release candidate": {
+			kernelVersionStr: "6.8-rc3",
+			want: KernelRelease{
+				Fullversion:      "6.8",
+				Version:          6,
+				PatchLevel:       8,
+				Extraversion:     "rc3",
+				FullExtraversion: "-rc3",
+				RC:               3,
+			},
+		},
+		"release candidate with sublevel": {
+			kernelVersionStr: "6.8.0-rc3-amd64",
+			want: KernelRelease{
+				Fullversion:      "6.8.0",
+				Version:          6,
+				PatchLevel:       8,
+				Extraversion:     "rc3-amd64",
+				FullExtraversion: "-rc3-amd64",
+				RC:               3,
+			},
+		},
+		"version without sublevel": {
+			kernelVersionStr: "6.8-1",
+			want:             KernelRelease{},
+		},
+		"version with four components": {
+			kernelVersionStr: "5.15.138.1-1.cm2",
+			want: KernelRelease{
+				Fullversion:      "5.15.138.1",
+				Version:          5,
+				PatchLevel:       15,
+				Sublevel:         138,
+				Extraversion:     "1",
+				FullExtraversion: "-1.cm2",
+			},
+		},
 		"an empty string": {
 			kernelVersionStr: "",
 			want: KernelRelease{
@@ -152,3 +201,24 @@ func TestFromString(t *testing.T) {
 		})
 	}
 }
+
+func TestArchitectureNames(t *testing.T) {
+	tests := map[Architecture]struct {
+		deb    string
+		nonDeb string
+		kernel string
+	}{
+		"amd64":   {deb: "amd64", nonDeb: "x86_64", kernel: "x86_64"},
+		"arm64":   {deb: "arm64", nonDeb: "aarch64", kernel: "arm64"},
+		"ppc64le": {deb: "ppc64el", nonDeb: "ppc64le", kernel: "powerpc"},
+		"s390x":   {deb: "s390x", nonDeb: "s390x", kernel: "s390"},
+		"riscv64": {deb: "riscv64", nonDeb: "riscv64", kernel: "riscv"},
+	}
+	for arch, tt := range tests {
+		t.Run(arch.String(), func(t *testing.T) {
+			assert.Equal(t, tt.deb, arch.ToDeb())
+			assert.Equal(t, tt.nonDeb, arch.ToNonDeb())
+			assert.Equal(t, tt.kernel, arch.ToKernel())
+		})
+	}
+}
@@ -0,0 +1,104 @@
+// Package metrics exports the Prometheus metrics of the builds: their outcomes, their durations and the ones running.
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	logger "github.com/sirupsen/logrus"
+)
+
+// The statuses of the builds counted by BuildsTotal.
+const (
+	StatusSuccess  = "success"
+	StatusFailure  = "failure"
+	StatusCanceled = "canceled"
+)
+
+var (
+	// BuildsTotal counts the builds completed, by target, architecture and status.
+	BuildsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "driverkit_builds_total",
+		Help: "Number of builds completed, by target, architecture and status.",
+	}, []string{"target", "arch", "status"})
+
+	// BuildDuration observes the duration of the builds, from the kernel packages resolution to the artifacts published.
+	BuildDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "driverkit_build_duration_seconds",
+		Help:    "Duration of the builds, from the kernel packages resolution to the artifacts published.",
+		Buckets: prometheus.ExponentialBuckets(10, 2, 8),
+	}, []string{"target", "arch", "status"})
+
+	// ResolutionDuration observes the time the builders take to resolve the kernel packages of the builds.
+	ResolutionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "driverkit_resolution_duration_seconds",
+		Help:    "Time taken to resolve the kernel packages of the builds, by target.",
+		Buckets: prometheus.ExponentialBuckets(0.25, 2, 10),
+	}, []string{"target"})
+
+	// BuildsInFlight is the number of builds running.
+	BuildsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "driverkit_builds_in_flight",
+		Help: "Number of builds running.",
+	})
+)
+
+// Registry is the registry of the driverkit metrics, along with the ones of the Go runtime and of the process.
+var Registry = prometheus.NewRegistry()
+
+func init() {
+	Registry.MustRegister(
+		BuildsTotal,
+		BuildDuration,
+		ResolutionDuration,
+		BuildsInFlight,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+}
+
+// Status returns the status of a build completed with the error, if any, within the context.
+func Status(ctx context.Context, err error) string {
+	switch {
+	case err == nil:
+		return StatusSuccess
+	case ctx.Err() == context.Canceled:
+		return StatusCanceled
+	}
+	return StatusFailure
+}
+
+// Handler returns the handler exposing the metrics in the Prometheus format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Serve exposes the metrics on /metrics of the address until the context is done.
+// It returns once listening, the errors of the server are logged.
+func Serve(ctx context.Context, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("unable to serve the metrics on %s: %s", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	srv := &http.Server{Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	go func() {
+		if err := srv.Serve(ln); err != http.ErrServerClosed {
+			logger.WithError(err).WithField("addr", addr).Error("error serving the metrics")
+		}
+	}()
+	logger.WithField("addr", ln.Addr().String()).Info("serving the metrics")
+	return nil
+}
@@ -0,0 +1,489 @@
+// Package server runs the builds requested over HTTP, a bounded number at a time, keeping their logs and artifacts around.
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	logger "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+// The states of a build.
+const (
+	StateQueued    = "queued"
+	StateRunning   = "running"
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed"
+	StateCanceled  = "canceled"
+)
+
+// maxRequestSize bounds the body of the requests creating builds, the kernel config data included.
+const maxRequestSize = 4 << 20
+
+// BuildRequest is the body of the requests creating builds.
+type BuildRequest struct {
+	Target           string `json:"target"`
+	Architecture     string `json:"arch"`
+	KernelRelease    string `json:"kernelrelease"`
+	KernelVersion    string `json:"kernelversion"`
+	KernelConfigData string `json:"kernelconfigdata"`
+	DriverVersion    string `json:"driverversion"`
+}
+
+// BuildFunc returns the build of the request, its artifacts written into the directory,
+// or an error when the request is not a valid build.
+type BuildFunc func(req BuildRequest, dir string) (*builder.Build, error)
+
+// BuildStatus describes a build requested to the server.
+type BuildStatus struct {
+	ID         string                     `json:"id"`
+	State      string                     `json:"state"`
+	Error      string                     `json:"error,omitempty"`
+	CreatedAt  time.Time                  `json:"created_at"`
+	StartedAt  *time.Time                 `json:"started_at,omitempty"`
+	FinishedAt *time.Time                 `json:"finished_at,omitempty"`
+	Report     *driverbuilder.BuildReport `json:"report,omitempty"`
+	Logs       string                     `json:"logs"`
+}
+
+// Options configure the server.
+type Options struct {
+	// Workers is the number of builds running at the same time.
+	Workers int
+	// QueueSize is the number of builds waiting for a worker, the ones requested beyond it are rejected.
+	QueueSize int
+	// WorkDir is the directory the artifacts of the builds are written into, one directory each.
+	WorkDir string
+	// Retention is the time the completed builds are kept for, with their logs and artifacts, DefaultRetention when not set.
+	Retention time.Duration
+	// MaxCompleted is the number of completed builds kept, the oldest ones are removed beyond it, DefaultMaxCompleted when not set.
+	MaxCompleted int
+}
+
+// DefaultRetention is the time the completed builds are kept for by default.
+const DefaultRetention = 24 * time.Hour
+
+// DefaultMaxCompleted is the number of completed builds kept by default.
+const DefaultMaxCompleted = 1000
+
+// Server runs the builds requested over HTTP with the processor.
+type Server struct {
+	processor driverbuilder.BuildProcessor
+	newBuild  BuildFunc
+	opts      Options
+	queue     chan *job
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+// job is a build requested to the server.
+type job struct {
+	id    string
+	dir   string
+	build *builder.Build
+	logs  *syncBuffer
+
+	// guarded by the mutex of the server
+	state      string
+	err        error
+	createdAt  time.Time
+	startedAt  *time.Time
+	finishedAt *time.Time
+	report     *driverbuilder.BuildReport
+	cancel     context.CancelFunc
+	deleted    bool
+}
+
+// New returns a server running the builds with the processor, the builds of the requests are returned by newBuild.
+func New(processor driverbuilder.BuildProcessor, newBuild BuildFunc, opts Options) *Server {
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	if opts.QueueSize < 0 {
+		opts.QueueSize = 0
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = DefaultRetention
+	}
+	if opts.MaxCompleted <= 0 {
+		opts.MaxCompleted = DefaultMaxCompleted
+	}
+	return &Server{
+		processor: processor,
+		newBuild:  newBuild,
+		opts:      opts,
+		queue:     make(chan *job, opts.QueueSize),
+		jobs:      map[string]*job{},
+	}
+}
+
+// Run runs the queued builds until the context is done, then it cancels the ones running and waits for them,
+// the ones still queued are canceled. Meanwhile the completed builds are removed once past their retention.
+func (s *Server) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < s.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case j := <-s.queue:
+					s.run(ctx, j)
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(s.opts.Retention / 10)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.mu.Lock()
+				s.evict(time.Now())
+				s.mu.Unlock()
+			}
+		}
+	}()
+	wg.Wait()
+	s.cancelQueued()
+}
+
+// cancelQueued cancels the builds left queued, no worker runs them anymore.
+func (s *Server) cancelQueued() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		select {
+		case j := <-s.queue:
+			if j.state == StateQueued {
+				s.cancelStopped(j)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// cancelStopped cancels the queued job the server stopped before running. The mutex must be held.
+func (s *Server) cancelStopped(j *job) {
+	now := time.Now()
+	j.state = StateCanceled
+	j.err = fmt.Errorf("the server stopped before running the build")
+	j.finishedAt = &now
+	s.removeDir(j)
+}
+
+// evict removes the completed builds past their retention, then the oldest ones beyond the number of completed builds kept.
+// The mutex must be held.
+func (s *Server) evict(now time.Time) {
+	completed := []*job{}
+	for _, j := range s.jobs {
+		if j.finishedAt == nil {
+			continue
+		}
+		if now.Sub(*j.finishedAt) >= s.opts.Retention {
+			s.remove(j)
+			continue
+		}
+		completed = append(completed, j)
+	}
+	if len(completed) <= s.opts.MaxCompleted {
+		return
+	}
+	sort.Slice(completed, func(i, k int) bool { return completed[i].finishedAt.Before(*completed[k].finishedAt) })
+	for _, j := range completed[:len(completed)-s.opts.MaxCompleted] {
+		s.remove(j)
+	}
+}
+
+// remove forgets the completed job, removing its logs and artifacts. The mutex must be held.
+func (s *Server) remove(j *job) {
+	delete(s.jobs, j.id)
+	j.logs.Reset()
+	s.removeDir(j)
+	logger.WithField("build", j.id).Debug("build removed")
+}
+
+// run runs the build of the job, unless it was canceled while queued.
+func (s *Server) run(ctx context.Context, j *job) {
+	ctx, cancel := context.WithCancel(driverbuilder.WithBuildID(driverbuilder.WithBuildLog(ctx, j.logs), j.id))
+	defer cancel()
+
+	s.mu.Lock()
+	if j.state != StateQueued {
+		s.mu.Unlock()
+		return
+	}
+	// picked while the server stops
+	if ctx.Err() != nil {
+		s.cancelStopped(j)
+		s.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	j.state = StateRunning
+	j.startedAt = &now
+	j.cancel = cancel
+	s.mu.Unlock()
+
+	log := logger.WithField("build", j.id)
+	log.Info("build started")
+	report, err := s.processor.Start(ctx, j.build)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now = time.Now()
+	j.finishedAt = &now
+	j.report = report
+	j.err = err
+	switch {
+	case j.deleted:
+		j.state = StateCanceled
+	case err != nil:
+		j.state = StateFailed
+	default:
+		j.state = StateSucceeded
+	}
+	j.cancel = nil
+	log.WithField("state", j.state).Info("build completed")
+	// the artifacts of the builds deleted while running are removed once their resources are cleaned up
+	if j.deleted {
+		j.logs.Reset()
+		s.removeDir(j)
+		return
+	}
+	s.evict(now)
+}
+
+// ServeHTTP implements http.Handler:
+//
+//	POST   /builds                           creates a build, its body is a BuildRequest
+//	GET    /builds/{id}                      returns the BuildStatus of the build, with its logs
+//	GET    /builds/{id}/artifacts/{artifact} downloads the module or the probe of a succeeded build
+//	DELETE /builds/{id}                      cancels the build, if not completed yet, and removes it with its artifacts
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "builds" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s", r.URL.Path))
+		return
+	}
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodPost:
+		s.create(w, r)
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		s.status(w, parts[1])
+	case len(parts) == 2 && r.Method == http.MethodDelete:
+		s.delete(w, parts[1])
+	case len(parts) == 4 && parts[2] == "artifacts" && r.Method == http.MethodGet:
+		s.artifact(w, parts[1], parts[3])
+	case len(parts) <= 2 || (len(parts) == 4 && parts[2] == "artifacts"):
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed on %s", r.Method, r.URL.Path))
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s", r.URL.Path))
+	}
+}
+
+func (s *Server) create(w http.ResponseWriter, r *http.Request) {
+	req := BuildRequest{}
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxRequestSize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid build request: %s", err))
+		return
+	}
+
+	id := string(uuid.NewUUID())
+	dir := filepath.Join(s.opts.WorkDir, id)
+	b, err := s.newBuild(req, dir)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	j := &job{
+		id:        id,
+		dir:       dir,
+		build:     b,
+		logs:      &syncBuffer{},
+		state:     StateQueued,
+		createdAt: time.Now(),
+	}
+
+	s.mu.Lock()
+	select {
+	case s.queue <- j:
+		s.jobs[id] = j
+		s.mu.Unlock()
+	default:
+		s.mu.Unlock()
+		os.RemoveAll(dir)
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("too many builds queued, retry later"))
+		return
+	}
+	logger.WithField("build", id).WithField("target", b.TargetType).WithField("kernelrelease", b.KernelRelease).Info("build queued")
+	writeJSON(w, http.StatusAccepted, map[string]string{"id": id})
+}
+
+func (s *Server) status(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	j, ok := s.jobs[id]
+	if !ok {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, fmt.Errorf("no such build %s", id))
+		return
+	}
+	status := BuildStatus{
+		ID:         j.id,
+		State:      j.state,
+		CreatedAt:  j.createdAt,
+		StartedAt:  j.startedAt,
+		FinishedAt: j.finishedAt,
+		Report:     j.report,
+	}
+	if j.err != nil {
+		status.Error = j.err.Error()
+	}
+	s.mu.Unlock()
+	status.Logs = j.logs.String()
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *Server) artifact(w http.ResponseWriter, id string, artifactType string) {
+	if artifactType != driverbuilder.ArtifactModule && artifactType != driverbuilder.ArtifactProbe {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no such artifact %s, it must be %s or %s", artifactType, driverbuilder.ArtifactModule, driverbuilder.ArtifactProbe))
+		return
+	}
+	s.mu.Lock()
+	j, ok := s.jobs[id]
+	var state string
+	var report *driverbuilder.BuildReport
+	if ok {
+		state, report = j.state, j.report
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no such build %s", id))
+		return
+	}
+	if state == StateQueued || state == StateRunning {
+		writeError(w, http.StatusConflict, fmt.Errorf("the build %s is %s", id, state))
+		return
+	}
+	if report == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("the build %s has no %s", id, artifactType))
+		return
+	}
+	f, err := report.Open(artifactType)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	defer f.Close()
+	name := artifactType
+	for _, a := range report.Artifacts {
+		if a.Type == artifactType {
+			name = filepath.Base(a.Path)
+		}
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, f); err != nil {
+		logger.WithError(err).WithField("build", id).Debug("error sending the artifact")
+	}
+}
+
+func (s *Server) delete(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no such build %s", id))
+		return
+	}
+	delete(s.jobs, id)
+	j.deleted = true
+	switch j.state {
+	case StateQueued:
+		// the worker picking it skips it
+		j.state = StateCanceled
+		j.logs.Reset()
+		s.removeDir(j)
+	case StateRunning:
+		// the processor cleans up the pod or the container, then the worker removes the logs and the artifacts
+		j.cancel()
+	default:
+		j.logs.Reset()
+		s.removeDir(j)
+	}
+	logger.WithField("build", id).Info("build deleted")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeDir removes the directory of the artifacts of the job.
+func (s *Server) removeDir(j *job) {
+	if err := os.RemoveAll(j.dir); err != nil {
+		logger.WithError(err).WithField("build", j.id).Error("error removing the build artifacts")
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// syncBuffer is a buffer safe to use concurrently, the build logs are written by the processors while they are read.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// Reset frees the content of the buffer.
+func (b *syncBuffer) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = bytes.Buffer{}
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,276 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+)
+
+// fakeBuildProcessor writes the module of each build, or blocks until canceled when asked to.
+type fakeBuildProcessor struct {
+	block   bool
+	started chan struct{}
+}
+
+func (bp *fakeBuildProcessor) String() string {
+	return "fake"
+}
+
+func (bp *fakeBuildProcessor) Start(ctx context.Context, b *builder.Build) (*driverbuilder.BuildReport, error) {
+	if bp.started != nil {
+		bp.started <- struct{}{}
+	}
+	if w := driverbuilder.BuildLog(ctx); w != nil {
+		fmt.Fprintf(w, "building %s\n", b.KernelRelease)
+	}
+	if bp.block {
+		<-ctx.Done()
+		return &driverbuilder.BuildReport{Error: ctx.Err().Error()}, ctx.Err()
+	}
+	if err := ioutil.WriteFile(b.ModuleFilePath, []byte("module"), 0644); err != nil {
+		return nil, err
+	}
+	return &driverbuilder.BuildReport{
+		KernelRelease: b.KernelRelease,
+		Success:       true,
+		Artifacts: []driverbuilder.ArtifactReport{
+			{Type: driverbuilder.ArtifactModule, Path: b.ModuleFilePath, Success: true},
+		},
+	}, nil
+}
+
+func fakeBuild(req BuildRequest, dir string) (*builder.Build, error) {
+	if len(req.KernelRelease) == 0 {
+		return nil, fmt.Errorf("the kernel release is required")
+	}
+	return &builder.Build{
+		TargetType:     builder.Type(req.Target),
+		KernelRelease:  req.KernelRelease,
+		ModuleFilePath: filepath.Join(dir, "falco.ko"),
+	}, nil
+}
+
+func newTestServer(t *testing.T, bp driverbuilder.BuildProcessor, queueSize int) (*Server, *httptest.Server) {
+	t.Helper()
+	s, ts, stop := startTestServer(t, bp, Options{Workers: 1, QueueSize: queueSize, WorkDir: t.TempDir()})
+	t.Cleanup(stop)
+	return s, ts
+}
+
+// startTestServer runs the server with the options, stop stops it and waits for its workers.
+func startTestServer(t *testing.T, bp driverbuilder.BuildProcessor, opts Options) (*Server, *httptest.Server, func()) {
+	t.Helper()
+	s := New(bp, fakeBuild, opts)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(stopped)
+	}()
+	ts := httptest.NewServer(s)
+	var once sync.Once
+	return s, ts, func() {
+		once.Do(func() {
+			cancel()
+			<-stopped
+		})
+		ts.Close()
+	}
+}
+
+func createBuild(t *testing.T, url string, body string) (int, string) {
+	t.Helper()
+	res, err := http.Post(url+"/builds", "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	created := map[string]string{}
+	json.NewDecoder(res.Body).Decode(&created)
+	return res.StatusCode, created["id"]
+}
+
+func waitState(t *testing.T, url string, id string, state string) BuildStatus {
+	t.Helper()
+	var status BuildStatus
+	for i := 0; i < 100; i++ {
+		res, err := http.Get(url + "/builds/" + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewDecoder(res.Body).Decode(&status)
+		res.Body.Close()
+		if status.State == state {
+			return status
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Build state | Got: [ %s ] / Want: [ %s ]", status.State, state)
+	return status
+}
+
+func TestServerBuild(t *testing.T) {
+	_, ts := newTestServer(t, &fakeBuildProcessor{}, 1)
+
+	code, id := createBuild(t, ts.URL, `{"target": "vanilla", "kernelrelease": "5.10.0"}`)
+	if code != http.StatusAccepted || len(id) == 0 {
+		t.Fatalf("Create | Got: [ %d, %q ] / Want: [ %d, an id ]", code, id, http.StatusAccepted)
+	}
+	status := waitState(t, ts.URL, id, StateSucceeded)
+	if status.Logs != "building 5.10.0\n" {
+		t.Errorf("Logs | Got: [ %q ] / Want: [ %q ]", status.Logs, "building 5.10.0\n")
+	}
+
+	res, err := http.Get(ts.URL + "/builds/" + id + "/artifacts/module")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || string(content) != "module" {
+		t.Errorf("Module | Got: [ %d, %q ] / Want: [ %d, %q ]", res.StatusCode, content, http.StatusOK, "module")
+	}
+	res, err = http.Get(ts.URL + "/builds/" + id + "/artifacts/probe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("Probe | Got: [ %d ] / Want: [ %d ]", res.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestServerInvalidBuild(t *testing.T) {
+	_, ts := newTestServer(t, &fakeBuildProcessor{}, 1)
+
+	for _, body := range []string{`{"target": "vanilla"}`, `{"kernel": "5.10.0"}`, `not json`} {
+		if code, _ := createBuild(t, ts.URL, body); code != http.StatusBadRequest {
+			t.Errorf("Create %s | Got: [ %d ] / Want: [ %d ]", body, code, http.StatusBadRequest)
+		}
+	}
+	res, err := http.Get(ts.URL + "/builds/unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("Status | Got: [ %d ] / Want: [ %d ]", res.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestServerQueueFull(t *testing.T) {
+	bp := &fakeBuildProcessor{block: true, started: make(chan struct{}, 1)}
+	_, ts := newTestServer(t, bp, 1)
+
+	// one build running, one queued
+	if code, _ := createBuild(t, ts.URL, `{"kernelrelease": "5.10.0"}`); code != http.StatusAccepted {
+		t.Fatalf("Create | Got: [ %d ] / Want: [ %d ]", code, http.StatusAccepted)
+	}
+	<-bp.started
+	if code, _ := createBuild(t, ts.URL, `{"kernelrelease": "5.11.0"}`); code != http.StatusAccepted {
+		t.Fatalf("Create | Got: [ %d ] / Want: [ %d ]", code, http.StatusAccepted)
+	}
+	if code, _ := createBuild(t, ts.URL, `{"kernelrelease": "5.12.0"}`); code != http.StatusServiceUnavailable {
+		t.Errorf("Create | Got: [ %d ] / Want: [ %d ]", code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestServerDelete(t *testing.T) {
+	bp := &fakeBuildProcessor{block: true, started: make(chan struct{}, 1)}
+	s, ts := newTestServer(t, bp, 1)
+
+	_, id := createBuild(t, ts.URL, `{"kernelrelease": "5.10.0"}`)
+	<-bp.started
+	waitState(t, ts.URL, id, StateRunning)
+	dir := filepath.Join(s.opts.WorkDir, id)
+
+	req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/builds/"+id, nil)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("Delete | Got: [ %d ] / Want: [ %d ]", res.StatusCode, http.StatusNoContent)
+	}
+	res, err = http.Get(ts.URL + "/builds/" + id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("Status | Got: [ %d ] / Want: [ %d ]", res.StatusCode, http.StatusNotFound)
+	}
+	// the worker removes the artifacts once the build returns
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("Build directory %s not removed", dir)
+}
+
+func TestServerEvict(t *testing.T) {
+	s, ts, stop := startTestServer(t, &fakeBuildProcessor{}, Options{Workers: 1, QueueSize: 1, WorkDir: t.TempDir(), MaxCompleted: 2, Retention: time.Hour})
+	defer stop()
+
+	ids := []string{}
+	for _, kr := range []string{"5.10.0", "5.11.0", "5.12.0"} {
+		_, id := createBuild(t, ts.URL, `{"kernelrelease": "`+kr+`"}`)
+		waitState(t, ts.URL, id, StateSucceeded)
+		ids = append(ids, id)
+	}
+	// the oldest completed build goes beyond the number kept
+	s.mu.Lock()
+	_, kept := s.jobs[ids[0]]
+	count := len(s.jobs)
+	s.mu.Unlock()
+	if kept || count != 2 {
+		t.Errorf("Jobs | Got: [ %d, the oldest kept: %v ] / Want: [ 2, the oldest removed ]", count, kept)
+	}
+	if _, err := os.Stat(filepath.Join(s.opts.WorkDir, ids[0])); !os.IsNotExist(err) {
+		t.Errorf("Build directory of %s not removed", ids[0])
+	}
+
+	// then the others once past their retention, with their logs
+	s.mu.Lock()
+	j := s.jobs[ids[2]]
+	s.evict(time.Now().Add(time.Hour))
+	count = len(s.jobs)
+	s.mu.Unlock()
+	if count != 0 || len(j.logs.String()) > 0 {
+		t.Errorf("Jobs | Got: [ %d, logs %q ] / Want: [ 0, no logs ]", count, j.logs.String())
+	}
+}
+
+func TestServerStopCancelsQueued(t *testing.T) {
+	bp := &fakeBuildProcessor{block: true, started: make(chan struct{}, 1)}
+	s, ts, stop := startTestServer(t, bp, Options{Workers: 1, QueueSize: 1, WorkDir: t.TempDir()})
+	defer stop()
+
+	_, running := createBuild(t, ts.URL, `{"kernelrelease": "5.10.0"}`)
+	<-bp.started
+	_, queued := createBuild(t, ts.URL, `{"kernelrelease": "5.11.0"}`)
+	stop()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, want := range map[string]string{running: StateFailed, queued: StateCanceled} {
+		if j := s.jobs[id]; j.state != want || j.finishedAt == nil {
+			t.Errorf("Build %s state | Got: [ %s ] / Want: [ %s, finished ]", id, j.state, want)
+		}
+	}
+}
@@ -4,21 +4,37 @@ import (
 	"context"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 )
 
+// receivedKey is the key of the signal a context returned by WithSignals was canceled with.
+type receivedKey struct{}
+
+// received is the signal received, if any.
+type received struct {
+	mu  sync.Mutex
+	sig os.Signal
+}
+
 // WithSignals returns a context that is canceled with any signal in sigs.
+// The signals are no longer handled once received, a second one terminates the process the default way.
 func WithSignals(ctx context.Context, sigs ...os.Signal) context.Context {
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, sigs...)
 
-	ctx, cancel := context.WithCancel(ctx)
+	r := &received{}
+	ctx, cancel := context.WithCancel(context.WithValue(ctx, receivedKey{}, r))
 	go func() {
 		defer cancel()
+		defer signal.Stop(sigCh)
 		select {
 		case <-ctx.Done():
 			return
-		case <-sigCh:
+		case sig := <-sigCh:
+			r.mu.Lock()
+			r.sig = sig
+			r.mu.Unlock()
 			return
 		}
 	}()
@@ -28,4 +44,15 @@ func WithSignals(ctx context.Context, sigs ...os.Signal) context.Context {
 // WithStandardSignals cancels the context on os.Interrupt, syscall.SIGTERM.
 func WithStandardSignals(ctx context.Context) context.Context {
 	return WithSignals(ctx, os.Interrupt, syscall.SIGTERM)
-}
\ No newline at end of file
+}
+
+// Received returns the signal the context, or its parents, was canceled with by WithSignals, if any.
+func Received(ctx context.Context) (os.Signal, bool) {
+	r, ok := ctx.Value(receivedKey{}).(*received)
+	if !ok {
+		return nil, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.sig, r.sig != nil
+}
@@ -0,0 +1,25 @@
+package validate
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+var gitRefRegex = regexp.MustCompile(`^refs/[A-Za-z0-9._-]+(/[A-Za-z0-9._-]+)+$`)
+
+// isGitRef tells whether the field is a full git ref, e.g. refs/heads/master or refs/pull/123/head.
+func isGitRef(fl validator.FieldLevel) bool {
+	field := fl.Field()
+
+	switch field.Kind() {
+	case reflect.String:
+		ref := field.String()
+		return gitRefRegex.MatchString(ref) && !strings.Contains(ref, "..") && !strings.HasSuffix(ref, ".lock")
+	}
+
+	panic(fmt.Sprintf("Bad field type %T", field.Interface()))
+}
@@ -0,0 +1,23 @@
+package validate
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// s3URLRegex matches s3://bucket/key URLs, the key can be a template.
+var s3URLRegex = regexp.MustCompile("^s3://[^/]+/.*[^/]$")
+
+func isS3URL(fl validator.FieldLevel) bool {
+	field := fl.Field()
+
+	switch field.Kind() {
+	case reflect.String:
+		return s3URLRegex.MatchString(field.String())
+	}
+
+	panic(fmt.Sprintf("Bad field type %T", field.Interface()))
+}
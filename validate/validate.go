@@ -3,6 +3,7 @@ package validate
 import (
 	"fmt"
 	"reflect"
+	"regexp"
 	"strings"
 
 	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
@@ -17,6 +18,9 @@ import (
 // It is a singleton so to cache the structs info.
 var V *validator.Validate
 
+// camelCaseBoundary matches the boundaries of the words of the camel case names.
+var camelCaseBoundary = regexp.MustCompile(`([a-z])([A-Z])`)
+
 // T is the universal translator for validatiors.
 var T ut.Translator
 
@@ -34,11 +38,14 @@ func init() {
 
 	V.RegisterValidation("logrus", isLogrusLevel)
 	V.RegisterValidation("filepath", isFilePath)
+	V.RegisterValidation("dirpath", isDirPath)
 	V.RegisterValidation("sha1", isSHA1)
+	V.RegisterValidation("gitref", isGitRef)
 	V.RegisterValidation("target", isTargetSupported)
 	V.RegisterValidation("semver", isSemVer)
 	V.RegisterValidation("proxy", isProxy)
 	V.RegisterValidation("imagename", isImageName)
+	V.RegisterValidation("s3url", isS3URL)
 
 	eng := en.New()
 	uni := ut.New(eng, eng)
@@ -58,6 +65,19 @@ func init() {
 		},
 	)
 
+	V.RegisterTranslation(
+		"dirpath",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("dirpath", "{0} must be a valid directory path", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T("dirpath", fe.Field())
+
+			return t
+		},
+	)
+
 	V.RegisterTranslation(
 		"target",
 		T,
@@ -84,6 +104,19 @@ func init() {
 		},
 	)
 
+	V.RegisterTranslation(
+		"required_kernelconfigdata_with_target_gentoo",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("required_kernelconfigdata_with_target_gentoo", "{0} is a required field when target is gentoo", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T("required_kernelconfigdata_with_target_gentoo", "kernel config data") // fixme ? tag "name" does not work when used at struct level
+
+			return t
+		},
+	)
+
 	V.RegisterTranslation(
 		"required_kernelversion_with_target_ubuntu",
 		T,
@@ -97,6 +130,19 @@ func init() {
 		},
 	)
 
+	V.RegisterTranslation(
+		"required_kernelversion_with_target_bottlerocket",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("required_kernelversion_with_target_bottlerocket", "{0} must be the bottlerocket variant (eg. aws-k8s-1.24) when target is bottlerocket", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T("required_kernelversion_with_target_bottlerocket", "kernel version") // fixme ? tag "name" does not work when used at struct level
+
+			return t
+		},
+	)
+
 	V.RegisterTranslation(
 	    "required_builderimage_with_target_redhat",
 	    T,
@@ -110,6 +156,110 @@ func init() {
     	},
     )
 
+	V.RegisterTranslation(
+		"excluded_localkerneldir_with_kernelurls",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("excluded_localkerneldir_with_kernelurls", "{0} cannot be used together with kernel header urls", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T("excluded_localkerneldir_with_kernelurls", "local kernel directory") // fixme ? tag "name" does not work when used at struct level
+
+			return t
+		},
+	)
+
+	V.RegisterTranslation(
+		"required_kernelurls_with_kernelurls_auth",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("required_kernelurls_with_kernelurls_auth", "{0} are required when authenticating their downloads", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T("required_kernelurls_with_kernelurls_auth", "kernel header urls")
+
+			return t
+		},
+	)
+
+	V.RegisterTranslation(
+		"excluded_kernelurls_auth_with_token",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("excluded_kernelurls_auth_with_token", "{0} cannot be used together with the kernel header urls token", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T("excluded_kernelurls_auth_with_token", "kernel header urls auth")
+
+			return t
+		},
+	)
+
+	V.RegisterTranslation(
+		"excluded_localdriverdir_with_repo",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("excluded_localdriverdir_with_repo", "{0} cannot be used together with the driver repository nor git", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T("excluded_localdriverdir_with_repo", "local driver directory")
+
+			return t
+		},
+	)
+
+	V.RegisterTranslation(
+		"required_output_with_module_signing",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("required_output_with_module_signing", "{0} is required when signing the kernel module", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T("required_output_with_module_signing", fe.Field())
+
+			return t
+		},
+	)
+
+	V.RegisterTranslation(
+		"excluded_rhel_entitlement_without_target_redhat",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("excluded_rhel_entitlement_without_target_redhat", "{0} is only used when target is redhat", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T("excluded_rhel_entitlement_without_target_redhat", fe.Field())
+
+			return t
+		},
+	)
+
+	V.RegisterTranslation(
+		"required_output_with_dkms",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("required_output_with_dkms", "{0} is required when assembling the DKMS package", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T("required_output_with_dkms", fe.Field())
+
+			return t
+		},
+	)
+
+	V.RegisterTranslation(
+		"required_output_with_s3",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("required_output_with_s3", "{0} is required when uploading it to s3", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T("required_output_with_s3", fe.Field())
+
+			return t
+		},
+	)
+
 	V.RegisterTranslation(
 		"logrus",
 		T,
@@ -162,6 +312,37 @@ func init() {
 		},
 	)
 
+	V.RegisterTranslation(
+		"required_without_all",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("required_without_all", "{0} is required when {1} are missing", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			// e.g. Probe ModernProbe -> probe and modern probe
+			fields := strings.Fields(fe.Param())
+			for i, f := range fields {
+				fields[i] = strings.ToLower(camelCaseBoundary.ReplaceAllString(f, "$1 $2"))
+			}
+			t, _ := ut.T(fe.Tag(), fe.Field(), strings.Join(fields, " and "))
+
+			return t
+		},
+	)
+
+	V.RegisterTranslation(
+		"required_with",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("required_with", "{0} is required when {1} is given", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T(fe.Tag(), fe.Field(), strings.ToLower(fe.Param()))
+
+			return t
+		},
+	)
+
 	V.RegisterTranslation(
 		"endswith",
 		T,
@@ -187,4 +368,17 @@ func init() {
 			return t
 		},
 	)
+
+	V.RegisterTranslation(
+		"s3url",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("s3url", "{0} must be a valid s3://bucket/key url", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T(fe.Tag(), fe.Field())
+
+			return t
+		},
+	)
 }